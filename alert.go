@@ -0,0 +1,323 @@
+package goapp
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ALERT_RULES_ENV    = "DOC_ALERT_RULES"    // Environment variable with the path of a JSON file listing the alert rules
+	ALERT_INTERVAL_ENV = "DOC_ALERT_INTERVAL" // Environment variable with the time between two evaluations of the rules, e.g. "5m"
+
+	ALERT_KIND_SILENCE      = "silence"      // Kind of rules firing when no document was ingested from a source for Hours
+	ALERT_KIND_FAILURE_RATE = "failure_rate" // Kind of rules firing when more than Percent of the documents of a source failed within Hours
+
+	ALERT_STATUS_FIRING   = "firing"   // Status of alerts whose condition started to hold
+	ALERT_STATUS_RESOLVED = "resolved" // Status of alerts whose condition stopped holding
+
+	ALERT_DEFAULT_INTERVAL = 5 * time.Minute  // Time between two evaluations of the rules by default
+	ALERT_WEBHOOK_TIMEOUT  = 10 * time.Second // Timeout of a request to an alert webhook
+)
+
+// AlertRule describes a condition on the ingestion of sources which should be noticed
+type AlertRule struct {
+	Name    string
+	Source  string   // Source is the name of a source, a prefix ending with "*" like "dir:*", or empty for all sources
+	Kind    string   // Kind is one of the ALERT_KIND_* constants
+	Hours   float64  // Hours is the time without documents of silence rules, or the window of failure rate rules
+	Percent float64  // Percent is the failure rate above which failure rate rules fire
+	Webhook string   // Webhook is a URL alerts are posted to as JSON
+	Email   []string // Email are the addresses alerts are mailed to
+}
+
+// Alert is a change of the state of a rule for a source, sent to the webhook and email addresses of the rule
+type Alert struct {
+	Rule    string
+	Source  string
+	Status  string // Status is firing or resolved
+	Message string
+	At      string
+}
+
+// alertSample holds the counters of the sources at the time of an evaluation
+type alertSample struct {
+	At      time.Time
+	Sources map[string]SourceStats
+}
+
+// Alerter evaluates alert rules on the ingestion counters of sources and notifies when alerts fire or resolve
+type Alerter struct {
+	Rules    []AlertRule
+	Interval time.Duration // Interval is the time between two evaluations
+	Client   *http.Client
+	Mailer   *Mailer // Mailer sends the alerts of rules with email addresses, nil if emails can't be sent
+
+	samples []alertSample   // samples are the counters of past evaluations within the longest window
+	firing  map[string]bool // firing holds the rule and source pairs whose alert is firing
+}
+
+// alerter is the alerting of the server, nil unless configured by initAlerts
+var alerter *Alerter
+
+// initAlerts sets up alerting if alert rules are configured
+func initAlerts() {
+	funcName := "initAlerts"
+
+	path := os.Getenv(ALERT_RULES_ENV)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s: %v", funcName, ALERT_RULES_ENV, err)
+	}
+	rules, err := parseAlertRules(data)
+	if err != nil {
+		log.Fatalf("%s: Invalid alert rules in %s: %v", funcName, path, err)
+	}
+
+	interval := ALERT_DEFAULT_INTERVAL
+	if value := os.Getenv(ALERT_INTERVAL_ENV); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("%s: %s must be a positive duration like 5m", funcName, ALERT_INTERVAL_ENV)
+		}
+		interval = parsed
+	}
+
+	alerter = newAlerter(rules, interval)
+	alerter.Mailer = mailer
+	for _, rule := range rules {
+		if len(rule.Email) > 0 && mailer == nil {
+			log.Fatalf("%s: %s and %s are required to email alerts of rule %s", funcName, SMTP_ADDR_ENV, SMTP_FROM_ENV, rule.Name)
+		}
+	}
+}
+
+// newAlerter creates an alerter evaluating the rules every interval
+func newAlerter(rules []AlertRule, interval time.Duration) *Alerter {
+	return &Alerter{
+		Rules:    rules,
+		Interval: interval,
+		Client:   &http.Client{Timeout: ALERT_WEBHOOK_TIMEOUT},
+		firing:   map[string]bool{},
+	}
+}
+
+// parseAlertRules parses a JSON list of alert rules and checks them
+func parseAlertRules(data []byte) ([]AlertRule, error) {
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for i, rule := range rules {
+		if rule.Name == "" || names[rule.Name] {
+			return nil, fmt.Errorf("rule %d needs a unique name", i+1)
+		}
+		names[rule.Name] = true
+
+		switch rule.Kind {
+		case ALERT_KIND_SILENCE:
+		case ALERT_KIND_FAILURE_RATE:
+			if rule.Percent < 0 || rule.Percent >= 100 {
+				return nil, fmt.Errorf("rule %s: percent must be between 0 and 100", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("rule %s: unknown kind %q", rule.Name, rule.Kind)
+		}
+		if rule.Hours <= 0 {
+			return nil, fmt.Errorf("rule %s: hours must be positive", rule.Name)
+		}
+		if rule.Webhook == "" && len(rule.Email) == 0 {
+			return nil, fmt.Errorf("rule %s needs a webhook or email addresses", rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+// matches reports whether the rule applies to the source
+func (rule *AlertRule) matches(source string) bool {
+	if strings.HasSuffix(rule.Source, "*") {
+		return strings.HasPrefix(source, strings.TrimSuffix(rule.Source, "*"))
+	}
+	return rule.Source == "" || rule.Source == source
+}
+
+// Evaluate checks the rules against the counters of the sources at now and returns the alerts which fired or resolved
+func (alerter *Alerter) Evaluate(db *sql.DB, now time.Time) ([]Alert, error) {
+	sources, err := listSources(db)
+	if err != nil {
+		return nil, err
+	}
+	current := alertSample{At: now, Sources: map[string]SourceStats{}}
+	for _, source := range sources {
+		current.Sources[source.Name] = source
+	}
+	alerter.record(current)
+
+	var alerts []Alert
+	for _, rule := range alerter.Rules {
+		names := []string{}
+		for _, source := range sources {
+			if rule.matches(source.Name) {
+				names = append(names, source.Name)
+			}
+		}
+		// A source named by a silence rule is silent until its first document arrives
+		if rule.Kind == ALERT_KIND_SILENCE && rule.Source != "" && !strings.HasSuffix(rule.Source, "*") && len(names) == 0 {
+			names = append(names, rule.Source)
+		}
+
+		for _, name := range names {
+			source, ok := current.Sources[name]
+			if !ok {
+				source.Name = name
+			}
+			message, holds := alerter.check(rule, source, now)
+			key := rule.Name + "\x00" + name
+			if holds == alerter.firing[key] {
+				continue
+			}
+			alerter.firing[key] = holds
+
+			alert := Alert{Rule: rule.Name, Source: name, Status: ALERT_STATUS_RESOLVED, Message: message, At: formatExpiry(now)}
+			if holds {
+				alert.Status = ALERT_STATUS_FIRING
+				metrics.inc("alerts_fired_total", "rule", rule.Name)
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+// record adds the sample and drops the samples no rule window needs anymore
+// The newest sample before the longest window is kept as the base of failure rates.
+func (alerter *Alerter) record(sample alertSample) {
+	window := 0.0
+	for _, rule := range alerter.Rules {
+		if rule.Kind == ALERT_KIND_FAILURE_RATE && rule.Hours > window {
+			window = rule.Hours
+		}
+	}
+	start := sample.At.Add(-time.Duration(window * float64(time.Hour)))
+
+	alerter.samples = append(alerter.samples, sample)
+	for len(alerter.samples) > 1 && !alerter.samples[1].At.After(start) {
+		alerter.samples = alerter.samples[1:]
+	}
+}
+
+// check reports whether the condition of the rule holds for the source at now, with a message describing it
+func (alerter *Alerter) check(rule AlertRule, source SourceStats, now time.Time) (string, bool) {
+	window := time.Duration(rule.Hours * float64(time.Hour))
+
+	if rule.Kind == ALERT_KIND_SILENCE {
+		if source.LastSuccess == "" {
+			return fmt.Sprintf("No document was ingested from %s yet", source.Name), true
+		}
+		last, err := time.Parse(time.RFC3339, source.LastSuccess)
+		if err != nil || now.Sub(last) < window {
+			return fmt.Sprintf("Documents are ingested from %s again", source.Name), false
+		}
+		return fmt.Sprintf("No document was ingested from %s since %s", source.Name, source.LastSuccess), true
+	}
+
+	// The failure rate is computed from the counters at the start of the window, or the oldest known ones
+	base := alerter.samples[0]
+	for _, sample := range alerter.samples {
+		if sample.At.After(now.Add(-window)) {
+			break
+		}
+		base = sample
+	}
+	previous := base.Sources[source.Name]
+	documents := source.Documents - previous.Documents
+	failures := source.Failures - previous.Failures
+	if documents+failures == 0 {
+		return fmt.Sprintf("Nothing was ingested from %s in the last %g hours", source.Name, rule.Hours), false
+	}
+	rate := float64(failures) * 100 / float64(documents+failures)
+	message := fmt.Sprintf("%.1f%% of %d documents from %s failed in the last %g hours", rate, documents+failures, source.Name, rule.Hours)
+	return message, rate > rule.Percent
+}
+
+// Notify sends the alert to the webhook and email addresses of its rule
+func (alerter *Alerter) Notify(alert Alert) error {
+	var rule AlertRule
+	for _, candidate := range alerter.Rules {
+		if candidate.Name == alert.Rule {
+			rule = candidate
+		}
+	}
+
+	var errs []string
+	if rule.Webhook != "" {
+		if err := alerter.postWebhook(rule.Webhook, alert); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(rule.Email) > 0 {
+		if err := alerter.Mailer.Send(rule.Email, "alert", alert); err != nil {
+			errs = append(errs, fmt.Sprintf("email: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to notify %s: %s", alert.Rule, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// postWebhook posts the alert as JSON to the URL
+func (alerter *Alerter) postWebhook(url string, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := alerter.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runAlerter evaluates the alert rules every interval, on a single instance if several share the database
+func runAlerter(db *sql.DB, alerter *Alerter) {
+	funcName := "runAlerter"
+
+	ticker := time.NewTicker(alerter.Interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if !leaderElector.Lead(db, JOB_ALERTER, now, 2*alerter.Interval) {
+			continue
+		}
+
+		alerts, err := alerter.Evaluate(db, now)
+		if err != nil {
+			log.Printf("%s: Failed to evaluate alert rules: %v", funcName, err)
+			continue
+		}
+		for _, alert := range alerts {
+			log.Printf("%s: Alert %s is %s for %s: %s", funcName, alert.Rule, alert.Status, alert.Source, alert.Message)
+			if err := alerter.Notify(alert); err != nil {
+				log.Printf("%s: %v", funcName, err)
+			}
+			postChat(alertChatEvent(alert))
+		}
+	}
+}
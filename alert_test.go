@@ -0,0 +1,158 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing and checking alert rules
+func TestParseAlertRules(t *testing.T) {
+	rules, err := parseAlertRules([]byte(`[
+		{"Name": "feed", "Source": "dir:./feed", "Kind": "silence", "Hours": 6, "Webhook": "http://hooks.example.com/alert"},
+		{"Name": "failures", "Source": "key:*", "Kind": "failure_rate", "Hours": 1, "Percent": 20, "Email": ["ops@example.com"]}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{desc: "missing name", data: `[{"Kind": "silence", "Hours": 1, "Webhook": "http://x"}]`, expected: "rule 1 needs a unique name"},
+		{desc: "unknown kind", data: `[{"Name": "a", "Kind": "latency", "Hours": 1, "Webhook": "http://x"}]`, expected: `rule a: unknown kind "latency"`},
+		{desc: "no hours", data: `[{"Name": "a", "Kind": "silence", "Webhook": "http://x"}]`, expected: "rule a: hours must be positive"},
+		{desc: "invalid percent", data: `[{"Name": "a", "Kind": "failure_rate", "Hours": 1, "Percent": 100, "Webhook": "http://x"}]`, expected: "rule a: percent must be between 0 and 100"},
+		{desc: "no receiver", data: `[{"Name": "a", "Kind": "silence", "Hours": 1}]`, expected: "rule a needs a webhook or email addresses"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseAlertRules([]byte(tt.data))
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+// Test matching sources by name and prefix
+func TestAlertRuleMatches(t *testing.T) {
+	require.True(t, (&AlertRule{}).matches("dir:./feed"))
+	require.True(t, (&AlertRule{Source: "dir:./feed"}).matches("dir:./feed"))
+	require.False(t, (&AlertRule{Source: "dir:./feed"}).matches("dir:./other"))
+	require.True(t, (&AlertRule{Source: "dir:*"}).matches("dir:./other"))
+	require.False(t, (&AlertRule{Source: "dir:*"}).matches("http:192.0.2.1"))
+}
+
+// Test silence alerts firing and resolving
+func TestAlerterSilence(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	alerter := newAlerter([]AlertRule{
+		{Name: "feed", Source: "dir:./feed", Kind: ALERT_KIND_SILENCE, Hours: 6, Webhook: "http://x"},
+	}, time.Minute)
+
+	// A source which never delivered is silent
+	alerts, err := alerter.Evaluate(db, start)
+	require.NoError(t, err)
+	require.Equal(t, []Alert{{Rule: "feed", Source: "dir:./feed", Status: ALERT_STATUS_FIRING, Message: "No document was ingested from dir:./feed yet", At: formatExpiry(start)}}, alerts)
+
+	// Alerts are only sent when their state changes
+	alerts, err = alerter.Evaluate(db, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	require.NoError(t, recordIngest(db, "dir:./feed", 10, nil, start.Add(time.Hour)))
+	alerts, err = alerter.Evaluate(db, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, ALERT_STATUS_RESOLVED, alerts[0].Status)
+
+	alerts, err = alerter.Evaluate(db, start.Add(7*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, ALERT_STATUS_FIRING, alerts[0].Status)
+	require.Equal(t, "No document was ingested from dir:./feed since 2024-05-01T13:00:00Z", alerts[0].Message)
+}
+
+// Test failure rate alerts over their window
+func TestAlerterFailureRate(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	alerter := newAlerter([]AlertRule{
+		{Name: "failures", Source: "key:*", Kind: ALERT_KIND_FAILURE_RATE, Hours: 1, Percent: 20, Webhook: "http://x"},
+	}, time.Minute)
+
+	for i := 0; i < 9; i++ {
+		require.NoError(t, recordIngest(db, "key:api", 10, nil, start))
+	}
+	require.NoError(t, recordIngest(db, "key:api", 10, errors.New("tag pairing error"), start))
+	alerts, err := alerter.Evaluate(db, start)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	// Failures of the last hour count, not the earlier successes
+	for i := 0; i < 3; i++ {
+		require.NoError(t, recordIngest(db, "key:api", 10, errors.New("tag pairing error"), start.Add(90*time.Minute)))
+	}
+	require.NoError(t, recordIngest(db, "key:api", 10, nil, start.Add(90*time.Minute)))
+	alerts, err = alerter.Evaluate(db, start.Add(90*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []Alert{{
+		Rule:    "failures",
+		Source:  "key:api",
+		Status:  ALERT_STATUS_FIRING,
+		Message: "75.0% of 4 documents from key:api failed in the last 1 hours",
+		At:      formatExpiry(start.Add(90 * time.Minute)),
+	}}, alerts)
+
+	// Without ingestion in the window the alert resolves
+	alerts, err = alerter.Evaluate(db, start.Add(3*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, ALERT_STATUS_RESOLVED, alerts[0].Status)
+}
+
+// Test sending alerts to webhooks and email addresses
+func TestAlerterNotify(t *testing.T) {
+	var received Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	var mailed []string
+	defer func(previous func(*Mailer, []string, []byte) error) { sendMail = previous }(sendMail)
+	sendMail = func(mailer *Mailer, to []string, message []byte) error {
+		mailed = append(mailed, mailer.Addr, string(message))
+		return nil
+	}
+
+	alerter := newAlerter([]AlertRule{
+		{Name: "feed", Kind: ALERT_KIND_SILENCE, Hours: 6, Webhook: server.URL, Email: []string{"ops@example.com"}},
+	}, time.Minute)
+	var err error
+	alerter.Mailer, err = newMailer("mail.example.com:25", "alerts@example.com", SMTP_TLS_NONE, "")
+	require.NoError(t, err)
+
+	alert := Alert{Rule: "feed", Source: "dir:./feed", Status: ALERT_STATUS_FIRING, Message: "No document was ingested from dir:./feed yet", At: "2024-05-01T12:00:00Z"}
+	require.NoError(t, alerter.Notify(alert))
+	require.Equal(t, alert, received)
+	require.Equal(t, []string{
+		"mail.example.com:25",
+		"From: alerts@example.com\r\nTo: ops@example.com\r\nSubject: [firing] feed: dir:./feed\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nNo document was ingested from dir:./feed yet\r\n",
+	}, mailed)
+
+	// Failed notifications are reported
+	server.Close()
+	require.Error(t, alerter.Notify(alert))
+}
@@ -0,0 +1,340 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ANNOTATION_MAX_LENGTH = 10000 // Longest text of an annotation in characters
+
+	DB_ANNOTATION_TABLE_NAME           = "doc_annotation"     // Table name of the annotations of documents in SQLite
+	DB_ANNOTATION_ID_FIELD_NAME        = "id"                 // Field name for the ID of the annotation
+	DB_ANNOTATION_DOCUMENT_FIELD_NAME  = "document_id"        // Field name for the annotated document
+	DB_ANNOTATION_PARENT_FIELD_NAME    = "parent_id"          // Field name for the annotation replied to, NULL for the start of a thread
+	DB_ANNOTATION_PATH_FIELD_NAME      = "path"               // Field name for the path of the annotated element, empty for the whole document
+	DB_ANNOTATION_AUTHOR_FIELD_NAME    = "author"             // Field name for who wrote the annotation
+	DB_ANNOTATION_BODY_FIELD_NAME      = "body"               // Field name for the text of the annotation
+	DB_ANNOTATION_CREATEDAT_FIELD_NAME = "created_at"         // Field name for the time the annotation was written
+	DB_ANNOTATION_INDEX_NAME           = "doc_annotation_doc" // Index of annotations by document
+)
+
+// Annotation is a comment of a reviewer on a document, or on one of its elements if it has a Path
+// Annotations form threads: replies are listed under the annotation they answer.
+type Annotation struct {
+	ID         int64
+	DocumentID string
+	ParentID   int64  `json:",omitempty"` // ParentID is the annotation replied to, 0 for the start of a thread
+	Path       string `json:",omitempty"` // Path is the path of the annotated element like /report/section[2], empty for the whole document
+	Author     string // Author is the email of an SSO user, or the credential or address of the client like the source of a document
+	Body       string
+	CreatedAt  string
+	Replies    []*Annotation `json:",omitempty"`
+}
+
+// createAnnotationTable creates the table of annotations if not exists
+func createAnnotationTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER PRIMARY KEY,
+		"%s" INTEGER NOT NULL,
+		"%s" INTEGER,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL
+	);
+`, DB_ANNOTATION_TABLE_NAME, DB_ANNOTATION_ID_FIELD_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME, DB_ANNOTATION_PARENT_FIELD_NAME, DB_ANNOTATION_PATH_FIELD_NAME,
+		DB_ANNOTATION_AUTHOR_FIELD_NAME, DB_ANNOTATION_BODY_FIELD_NAME, DB_ANNOTATION_CREATEDAT_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", DB_ANNOTATION_INDEX_NAME, DB_ANNOTATION_TABLE_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME))
+	return err
+}
+
+// annotationColumns lists the columns read for an annotation, in the order scanAnnotation expects
+var annotationColumns = strings.Join([]string{DB_ANNOTATION_ID_FIELD_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME, DB_ANNOTATION_PARENT_FIELD_NAME,
+	DB_ANNOTATION_PATH_FIELD_NAME, DB_ANNOTATION_AUTHOR_FIELD_NAME, DB_ANNOTATION_BODY_FIELD_NAME, DB_ANNOTATION_CREATEDAT_FIELD_NAME}, ", ")
+
+// scanAnnotation reads an annotation selected with annotationColumns
+func scanAnnotation(row rowScanner) (*Annotation, error) {
+	annotation := &Annotation{}
+	var parentID sql.NullInt64
+	err := row.Scan(&annotation.ID, &annotation.DocumentID, &parentID, &annotation.Path, &annotation.Author, &annotation.Body, &annotation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	annotation.ParentID = parentID.Int64
+	return annotation, nil
+}
+
+// addAnnotation stores an annotation and returns it with its ID and creation time
+func addAnnotation(db *sql.DB, annotation Annotation, now time.Time) (*Annotation, error) {
+	defer observeQuery("addAnnotation", time.Now())
+
+	var parentID sql.NullInt64
+	if annotation.ParentID != 0 {
+		parentID = sql.NullInt64{Int64: annotation.ParentID, Valid: true}
+	}
+	annotation.CreatedAt = formatExpiry(now)
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?, ?)
+	`, DB_ANNOTATION_TABLE_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME, DB_ANNOTATION_PARENT_FIELD_NAME, DB_ANNOTATION_PATH_FIELD_NAME,
+		DB_ANNOTATION_AUTHOR_FIELD_NAME, DB_ANNOTATION_BODY_FIELD_NAME, DB_ANNOTATION_CREATEDAT_FIELD_NAME)
+	err := withDBRetry(func() error {
+		result, err := db.Exec(query, annotation.DocumentID, parentID, annotation.Path, annotation.Author, annotation.Body, annotation.CreatedAt)
+		if err != nil {
+			return err
+		}
+		annotation.ID, err = result.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+// getAnnotation returns the annotation with the ID on the document, sql.ErrNoRows if it has none
+func getAnnotation(db *sql.DB, documentID string, id int64) (*Annotation, error) {
+	defer observeQuery("getAnnotation", time.Now())
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s=? AND %s=?", annotationColumns, DB_ANNOTATION_TABLE_NAME, DB_ANNOTATION_ID_FIELD_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME)
+	var annotation *Annotation
+	err := withDBRetry(func() error {
+		var err error
+		annotation, err = scanAnnotation(db.QueryRow(query, id, documentID))
+		return err
+	})
+	return annotation, err
+}
+
+// listAnnotations returns the threads of annotations on a document, oldest first
+// With a path only the threads anchored to that element are returned.
+func listAnnotations(db *sql.DB, documentID string, path string) ([]*Annotation, error) {
+	defer observeQuery("listAnnotations", time.Now())
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s=? ORDER BY %s", annotationColumns, DB_ANNOTATION_TABLE_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME, DB_ANNOTATION_ID_FIELD_NAME)
+	var annotations []*Annotation
+	err := withDBRetry(func() error {
+		rows, err := db.Query(query, documentID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		annotations = nil
+		for rows.Next() {
+			annotation, err := scanAnnotation(rows)
+			if err != nil {
+				return err
+			}
+			annotations = append(annotations, annotation)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Replies are written after the annotation they answer, so it is always found first
+	threads := []*Annotation{}
+	byID := map[int64]*Annotation{}
+	for _, annotation := range annotations {
+		byID[annotation.ID] = annotation
+		if parent, ok := byID[annotation.ParentID]; ok {
+			parent.Replies = append(parent.Replies, annotation)
+		} else if path == "" || annotation.Path == path {
+			threads = append(threads, annotation)
+		}
+	}
+	return threads, nil
+}
+
+// deleteAnnotation deletes an annotation of a document with all replies to it
+// It returns sql.ErrNoRows if the document has no such annotation
+func deleteAnnotation(db *sql.DB, documentID string, id int64) error {
+	defer observeQuery("deleteAnnotation", time.Now())
+
+	query := fmt.Sprintf(`
+		WITH RECURSIVE thread(id) AS (
+			SELECT %[2]s FROM %[1]s WHERE %[2]s=? AND %[3]s=?
+			UNION ALL SELECT %[1]s.%[2]s FROM %[1]s JOIN thread ON %[1]s.%[4]s=thread.id
+		)
+		DELETE FROM %[1]s WHERE %[2]s IN thread
+	`, DB_ANNOTATION_TABLE_NAME, DB_ANNOTATION_ID_FIELD_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME, DB_ANNOTATION_PARENT_FIELD_NAME)
+	return withDBRetry(func() error {
+		result, err := db.Exec(query, id, documentID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// requestActor returns who makes a request, the email of a user logged in with the SSO or else the
+// credential or address of the client
+func requestActor(db *sql.DB, r *http.Request) string {
+	if bearerToken(r) == "" {
+		if session, ok := requestSession(db, r); ok {
+			if session.Email != "" {
+				return "user:" + session.Email
+			}
+			return "user:" + session.Subject
+		}
+	}
+	return requestSource(db, r)
+}
+
+// mayDeleteAnnotation reports whether the client of a request may delete the annotation
+// Authors delete their own annotations, the API key and admins any.
+func mayDeleteAnnotation(db *sql.DB, r *http.Request, annotation *Annotation) bool {
+	if apiKey == "" || isAPIKey(bearerToken(r)) {
+		return true
+	}
+	if session, ok := requestSession(db, r); ok && bearerToken(r) == "" && session.grants(ROLE_ADMIN) {
+		return true
+	}
+	return requestActor(db, r) == annotation.Author
+}
+
+// handleAnnotationsRequest lists the annotation threads of a document with GET /annotations?id=1, adds one with
+// POST and deletes one with its replies with DELETE /annotations?id=1&annotation=5
+func handleAnnotationsRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	funcName := "handleAnnotationsRequest"
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	var response interface{}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		threads, err := listAnnotations(db, id, r.URL.Query().Get("path"))
+		if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to list annotations: %v", err), err)
+			return
+		}
+		response = threads
+	case http.MethodPost:
+		var annotation Annotation
+		if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(annotation.Body) == "" {
+			http.Error(w, "Body of the annotation is required", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(annotation.Body) > ANNOTATION_MAX_LENGTH {
+			http.Error(w, fmt.Sprintf("Body of the annotation is longer than %d characters", ANNOTATION_MAX_LENGTH), http.StatusBadRequest)
+			return
+		}
+
+		// Documents are annotated in any state, e.g. when reviewing archived ones
+		doc, err := getDocumentByID(db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+			return
+		}
+
+		// Replies are anchored where the annotation they answer is
+		if annotation.ParentID != 0 {
+			parent, err := getAnnotation(db, id, annotation.ParentID)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, fmt.Sprintf("Annotation %d of document %s not found", annotation.ParentID, id), http.StatusNotFound)
+				return
+			} else if err != nil {
+				httpStoreError(w, fmt.Sprintf("Failed to fetch annotation %d: %v", annotation.ParentID, err), err)
+				return
+			}
+			if annotation.Path != "" && annotation.Path != parent.Path {
+				http.Error(w, "Replies have the path of the annotation they answer", http.StatusBadRequest)
+				return
+			}
+			annotation.Path = parent.Path
+		} else if annotation.Path != "" {
+			values, err := doc.Query(annotation.Path)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(values) == 0 {
+				http.Error(w, fmt.Sprintf("Path %s selects nothing in document %s", annotation.Path, id), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		annotation.DocumentID = id
+		annotation.Author = requestActor(db, r)
+		added, err := addAnnotation(db, annotation, time.Now())
+		if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to add annotation: %v", err), err)
+			return
+		}
+		response, status = added, http.StatusCreated
+	case http.MethodDelete:
+		annotationID, err := strconv.ParseInt(r.URL.Query().Get("annotation"), 10, 64)
+		if err != nil {
+			http.Error(w, "annotation parameter must be the ID of an annotation", http.StatusBadRequest)
+			return
+		}
+		annotation, err := getAnnotation(db, id, annotationID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Annotation %d of document %s not found", annotationID, id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to fetch annotation %d: %v", annotationID, err), err)
+			return
+		}
+		if !mayDeleteAnnotation(db, r, annotation) {
+			http.Error(w, "Only the author of an annotation or an admin can delete it", http.StatusForbidden)
+			return
+		}
+		err = deleteAnnotation(db, id, annotationID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			httpStoreError(w, fmt.Sprintf("Failed to delete annotation %d: %v", annotationID, err), err)
+			return
+		}
+		log.Printf("%s: Deleted annotation %d of document %s", funcName, annotationID, id)
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Convert to JSON and send response
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,109 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test adding, listing and deleting threads of annotations
+func TestHandleAnnotationsRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	apiKey = "test api key"
+	defer func() { apiKey = "" }()
+
+	doc, err := parseDocument(`<report><title>Q3</title><section>one</section><section>two</section></report>`)
+	require.NoError(t, err)
+	doc.State = DOC_STATE_ARCHIVED
+	require.NoError(t, insertDocument(db, *doc))
+
+	now := time.Now()
+	alice, err := mintToken(db, ACCESS_WRITE, "1", "", now)
+	require.NoError(t, err)
+	bob, err := mintToken(db, ACCESS_WRITE, "", "", now)
+	require.NoError(t, err)
+	reader, err := mintToken(db, ACCESS_READ, "1", "", now)
+	require.NoError(t, err)
+
+	request := func(method string, query string, credential string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/annotations?"+query, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, req)
+		return rr
+	}
+	add := func(credential string, body string) Annotation {
+		rr := request("POST", "id=1", credential, body)
+		require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+		var annotation Annotation
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &annotation))
+		return annotation
+	}
+
+	first := add(alice.Token, `{"Body": "Check the numbers", "Path": "/report/section[2]"}`)
+	require.Equal(t, "1", first.DocumentID)
+	require.Equal(t, "/report/section[2]", first.Path)
+	require.Equal(t, fmt.Sprintf("key:token-%d", alice.ID), first.Author)
+	reply := add(bob.Token, fmt.Sprintf(`{"Body": "Done", "ParentID": %d}`, first.ID))
+	require.Equal(t, first.Path, reply.Path)
+	add(alice.Token, fmt.Sprintf(`{"Body": "Thanks", "ParentID": %d}`, reply.ID))
+	general := add(bob.Token, `{"Body": "Approved"}`)
+
+	list := func(query string) []*Annotation {
+		rr := request("GET", query, reader.Token, "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var threads []*Annotation
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &threads))
+		return threads
+	}
+	threads := list("id=1")
+	require.Len(t, threads, 2)
+	require.Equal(t, "Check the numbers", threads[0].Body)
+	require.Equal(t, "Done", threads[0].Replies[0].Body)
+	require.Equal(t, "Thanks", threads[0].Replies[0].Replies[0].Body)
+	require.Equal(t, "Approved", threads[1].Body)
+	require.Len(t, list("id=1&path=/report/section[2]"), 1)
+
+	for _, tt := range []struct {
+		desc   string
+		body   string
+		status int
+	}{
+		{desc: "empty body", body: `{"Body": " "}`, status: http.StatusBadRequest},
+		{desc: "long body", body: `{"Body": "` + strings.Repeat("a", ANNOTATION_MAX_LENGTH+1) + `"}`, status: http.StatusBadRequest},
+		{desc: "invalid JSON", body: `{`, status: http.StatusBadRequest},
+		{desc: "invalid path", body: `{"Body": "x", "Path": "report"}`, status: http.StatusBadRequest},
+		{desc: "path selecting nothing", body: `{"Body": "x", "Path": "/report/appendix"}`, status: http.StatusUnprocessableEntity},
+		{desc: "unknown parent", body: `{"Body": "x", "ParentID": 99}`, status: http.StatusNotFound},
+		{desc: "reply elsewhere", body: fmt.Sprintf(`{"Body": "x", "ParentID": %d, "Path": "/report/title"}`, first.ID), status: http.StatusBadRequest},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.status, request("POST", "id=1", alice.Token, tt.body).Code)
+		})
+	}
+	require.Equal(t, http.StatusForbidden, request("POST", "id=1", reader.Token, `{"Body": "x"}`).Code)
+	require.Equal(t, http.StatusNotFound, request("POST", "id=2", bob.Token, `{"Body": "x"}`).Code)
+
+	// Only authors and the API key delete annotations, with their replies
+	require.Equal(t, http.StatusForbidden, request("DELETE", fmt.Sprintf("id=1&annotation=%d", first.ID), bob.Token, "").Code)
+	require.Equal(t, http.StatusOK, request("DELETE", fmt.Sprintf("id=1&annotation=%d", first.ID), alice.Token, "").Code)
+	require.Equal(t, http.StatusNotFound, request("DELETE", fmt.Sprintf("id=1&annotation=%d", reply.ID), apiKey, "").Code)
+	require.Equal(t, http.StatusOK, request("DELETE", fmt.Sprintf("id=1&annotation=%d", general.ID), apiKey, "").Code)
+	require.Empty(t, list("id=1"))
+	require.Equal(t, http.StatusBadRequest, request("DELETE", "id=1&annotation=x", apiKey, "").Code)
+
+	// Annotations are deleted with their document
+	add(apiKey, `{"Body": "Gone soon"}`)
+	require.NoError(t, deleteDocumentByID(db, "1"))
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+DB_ANNOTATION_TABLE_NAME).Scan(&count))
+	require.Zero(t, count)
+}
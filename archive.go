@@ -0,0 +1,372 @@
+package goapp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	ARCHIVE_FORMAT_VERSION = 1               // Version of the archive layout, bumped on incompatible changes
+	ARCHIVE_MANIFEST_NAME  = "manifest.json" // Name of the manifest in an archive
+	ARCHIVE_DOCUMENTS_DIR  = "documents"     // Directory holding the XML files in an archive
+	ARCHIVE_SIGNATURE_NAME = "manifest.sig"  // Name of the signature of the manifest in an archive
+
+	ARCHIVE_KEY_ENV = "DOC_ARCHIVE_KEY" // Environment variable holding the key archive manifests are signed with
+)
+
+// archiveKey is the HMAC key archive manifests are signed and verified with, set by initArchiveKey
+// Archives are neither signed nor verified without a key.
+var archiveKey []byte
+
+// initArchiveKey loads the archive key from the environment
+// Unlike the URL signing key it isn't generated, since archives are verified by other deployments.
+func initArchiveKey() {
+	archiveKey = []byte(os.Getenv(ARCHIVE_KEY_ENV))
+}
+
+// ArchiveEntry describes a document in the manifest of an archive
+type ArchiveEntry struct {
+	ID            string
+	Title         string
+	Description   string
+	Author        string
+	Authors       []string          `json:",omitempty"`
+	Custom        map[string]string `json:",omitempty"`
+	CreatedAt     string
+	CreatedOffset string            `json:",omitempty"`
+	DateProfile   string            `json:",omitempty"`
+	Variants      []LangVariant     `json:",omitempty"`
+	Language      string            `json:",omitempty"`
+	ProcessingLog []ProcessingEntry `json:",omitempty"`
+	Tags          []string          `json:",omitempty"`
+	ExpiresAt     string            `json:",omitempty"`
+	PublishAt     string            `json:",omitempty"`
+	State         string
+	ParserVersion string `json:",omitempty"`
+	Revision      int    `json:",omitempty"`
+	File          string // File is the path of the original XML in the archive
+	SHA256        string // SHA256 is the hex encoded checksum of File
+}
+
+// ArchiveManifest lists the documents of an archive with their metadata
+type ArchiveManifest struct {
+	Version     int
+	ExportedAt  string
+	Cursor      int64    // Cursor is the position of the change feed the archive is up to date with, the since of the next incremental export
+	Incremental bool     `json:",omitempty"` // Incremental archives only hold the documents changed after Since
+	Since       int64    `json:",omitempty"`
+	Deleted     []string `json:",omitempty"` // Deleted are the IDs of the documents deleted after Since
+	Documents   []ArchiveEntry
+}
+
+// checksum returns the hex encoded SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// manifestSignature returns the hex encoded HMAC-SHA256 of the manifest data with the archive key
+func manifestSignature(manifestData []byte) string {
+	mac := hmac.New(sha256.New, archiveKey)
+	mac.Write(manifestData)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyManifest checks the signature of the manifest data against the archive key
+// With a key, unsigned archives are rejected too, since stripping the signature would otherwise hide tampering.
+func verifyManifest(manifestData []byte, signature []byte) error {
+	switch {
+	case len(archiveKey) == 0 && signature != nil:
+		return fmt.Errorf("archive is signed but %s is not set", ARCHIVE_KEY_ENV)
+	case len(archiveKey) == 0:
+		return nil
+	case signature == nil:
+		return errors.New("archive is not signed")
+	case !hmac.Equal([]byte(manifestSignature(manifestData)), signature):
+		return errors.New("invalid manifest signature")
+	}
+	return nil
+}
+
+// exportArchive writes all documents as a gzipped tar of their original XML and a manifest
+// It returns the number of exported documents
+func exportArchive(db *sql.DB, out io.Writer, now time.Time) (int, error) {
+	manifest, err := exportChanges(db, out, "", now)
+	if err != nil {
+		return 0, err
+	}
+	return len(manifest.Documents), nil
+}
+
+// exportChanges writes the documents changed after the cursor since like exportArchive, all documents if since is empty
+// With an archive key the manifest, which holds the checksums of the files, is signed.
+// It returns the manifest, whose cursor is the since of the next export. The cursor is read before the documents,
+// so documents changed while they are exported are exported again by the next export.
+func exportChanges(db *sql.DB, out io.Writer, since string, now time.Time) (*ArchiveManifest, error) {
+	cursor, err := changeCursor(db)
+	if err != nil {
+		return nil, err
+	}
+	manifest := ArchiveManifest{
+		Version:    ARCHIVE_FORMAT_VERSION,
+		ExportedAt: formatExpiry(now),
+		Cursor:     cursor,
+		Documents:  []ArchiveEntry{},
+	}
+
+	var ids []string
+	if since == "" {
+		ids, err = listDocumentIDs(db, documentStates, false)
+	} else {
+		manifest.Incremental = true
+		manifest.Since, err = parseCursor(since, cursor)
+		if err != nil {
+			return nil, err
+		}
+		ids, err = changedDocumentIDs(db, manifest.Since, cursor)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, id := range ids {
+		doc, err := getDocumentByID(db, id)
+		if manifest.Incremental && errors.Is(err, sql.ErrNoRows) {
+			manifest.Deleted = append(manifest.Deleted, id)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("document %s: %w", id, err)
+		}
+
+		// The root element is exported after its prolog, like the XML declaration
+		raw := []byte(doc.rawXML())
+		file := path.Join(ARCHIVE_DOCUMENTS_DIR, doc.ID+".xml")
+		files[file] = raw
+		manifest.Documents = append(manifest.Documents, ArchiveEntry{
+			ID:            doc.ID,
+			Title:         doc.Title,
+			Description:   doc.Description,
+			Author:        doc.Author,
+			Authors:       doc.Authors,
+			Custom:        doc.Custom,
+			CreatedAt:     doc.CreatedAt,
+			CreatedOffset: doc.CreatedOffset,
+			DateProfile:   doc.DateProfile,
+			Variants:      doc.Variants,
+			Language:      doc.Language,
+			ProcessingLog: doc.ProcessingLog,
+			Tags:          doc.Tags,
+			ExpiresAt:     doc.ExpiresAt,
+			PublishAt:     doc.PublishAt,
+			State:         doc.State,
+			ParserVersion: doc.ParserVersion,
+			Revision:      doc.Revision,
+			File:          file,
+			SHA256:        checksum(raw),
+		})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	// The manifest comes first so readers can validate files as they stream by
+	err = writeArchiveFile(tw, ARCHIVE_MANIFEST_NAME, manifestData, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(archiveKey) > 0 {
+		err = writeArchiveFile(tw, ARCHIVE_SIGNATURE_NAME, []byte(manifestSignature(manifestData)), now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range manifest.Documents {
+		err = writeArchiveFile(tw, entry.File, files[entry.File], now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// writeArchiveFile adds a regular file to the tar
+func writeArchiveFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// readArchive reads the manifest and files of an archive written by exportArchive
+// The signature of the manifest is verified before it is used.
+func readArchive(in io.Reader) (*ArchiveManifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gz.Close()
+
+	var manifestData, signature []byte
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch header.Name {
+		case ARCHIVE_MANIFEST_NAME:
+			manifestData = data
+		case ARCHIVE_SIGNATURE_NAME:
+			signature = data
+		default:
+			files[header.Name] = data
+		}
+	}
+
+	if manifestData == nil {
+		return nil, nil, errors.New("missing manifest")
+	}
+	if err := verifyManifest(manifestData, signature); err != nil {
+		return nil, nil, err
+	}
+	manifest := &ArchiveManifest{}
+	if err := json.Unmarshal(manifestData, manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version != ARCHIVE_FORMAT_VERSION {
+		return nil, nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+	return manifest, files, nil
+}
+
+// importArchive restores the documents of an archive written by exportArchive, keeping their IDs
+// The whole archive is validated before anything is inserted: a missing or corrupted file, an
+// unparsable document or an ID which is already taken aborts the import
+// It returns the number of imported documents
+func importArchive(db *sql.DB, in io.Reader) (int, error) {
+	manifest, files, err := readArchive(in)
+	if err != nil {
+		return 0, err
+	}
+	// The documents of incremental archives may already exist, and the deleted ones aren't in them
+	if manifest.Incremental {
+		return 0, fmt.Errorf("incremental archive of the changes since %d can't be imported", manifest.Since)
+	}
+
+	docs := make([]XMLDoc, 0, len(manifest.Documents))
+	for _, entry := range manifest.Documents {
+		data, ok := files[entry.File]
+		if !ok {
+			return 0, fmt.Errorf("document %s: missing file %s", entry.ID, entry.File)
+		}
+		if checksum(data) != entry.SHA256 {
+			return 0, fmt.Errorf("document %s: checksum mismatch for %s", entry.ID, entry.File)
+		}
+		if entry.State != "" && !isValidState(entry.State) {
+			return 0, fmt.Errorf("document %s: invalid state %s", entry.ID, entry.State)
+		}
+		for _, tag := range entry.Tags {
+			if err := validateTag(tag); err != nil {
+				return 0, fmt.Errorf("document %s: %w", entry.ID, err)
+			}
+		}
+
+		_, err := getDocumentByID(db, entry.ID)
+		if err == nil {
+			return 0, fmt.Errorf("document %s: ID already exists", entry.ID)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+
+		// The stored element tree is rebuilt from the XML, the metadata is restored as exported
+		// so documents parsed by an older parser aren't silently changed
+		doc, err := parseDocumentFromWithOptions(string(data), "archive:"+entry.File, storedParseOptions)
+		if err != nil {
+			return 0, fmt.Errorf("document %s: %w", entry.ID, err)
+		}
+		docs = append(docs, XMLDoc{
+			ID:            entry.ID,
+			Title:         entry.Title,
+			Description:   entry.Description,
+			Author:        entry.Author,
+			Authors:       entry.Authors,
+			Custom:        entry.Custom,
+			CreatedAt:     entry.CreatedAt,
+			CreatedOffset: entry.CreatedOffset,
+			DateProfile:   entry.DateProfile,
+			XMLData:       doc.XMLData,
+			Paths:         doc.Paths,
+			Stats:         doc.Stats,
+			Preview:       doc.Preview,
+			Tree:          doc.Tree,
+			Prolog:        doc.Prolog,
+			Doctype:       doc.Doctype,
+			CanonicalHash: doc.CanonicalHash,
+			Validation:    doc.Validation,
+			Variants:      entry.Variants,
+			Language:      entry.Language,
+			ProcessingLog: entry.ProcessingLog,
+			Tags:          entry.Tags,
+			ExpiresAt:     entry.ExpiresAt,
+			PublishAt:     entry.PublishAt,
+			State:         entry.State,
+			ParserVersion: entry.ParserVersion,
+			Revision:      entry.Revision,
+		})
+	}
+
+	for i, doc := range docs {
+		err := insertDocument(db, doc)
+		if err != nil {
+			return i, fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+		// Tags aren't columns of the document
+		err = withDBRetry(func() error { return setDocumentTags(db, doc.ID, doc.Tags) })
+		if err != nil {
+			return i, fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+	}
+	return len(docs), nil
+}
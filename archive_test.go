@@ -0,0 +1,206 @@
+package goapp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that exporting and importing an archive keeps documents unchanged
+func TestExportImportArchive(t *testing.T) {
+	source, cleanupSource := setupTestDB(t)
+	defer cleanupSource()
+
+	for _, data := range []string{
+		`<doc expires="2030-01-01" publish="2029-01-01"><title>First</title><title xml:lang="fr">Premier</title><author>Ann</author></doc>`,
+		"<doc><title>Second</title></doc>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(source, *doc))
+	}
+	require.NoError(t, transitionDocument(source, "2", DOC_STATE_ARCHIVED))
+	require.NoError(t, setDocumentTags(source, "1", []string{"invoices", "urgent"}))
+
+	var archive bytes.Buffer
+	count, err := exportArchive(source, &archive, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	target, cleanupTarget := setupTestDB(t)
+	defer cleanupTarget()
+
+	count, err = importArchive(target, bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	for _, id := range []string{"1", "2"} {
+		expected, err := getDocumentByID(source, id)
+		require.NoError(t, err)
+		actual, err := getDocumentByID(target, id)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	}
+	imported, err := getDocumentByID(target, "1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"invoices", "urgent"}, imported.Tags)
+
+	// Importing again conflicts with the existing IDs and inserts nothing
+	_, err = importArchive(target, bytes.NewReader(archive.Bytes()))
+	require.ErrorContains(t, err, "ID already exists")
+}
+
+// Test that invalid archives are rejected before anything is inserted
+func TestImportArchiveInvalid(t *testing.T) {
+	manifest := `{"Version": 1, "Documents": [{"ID": "7", "Title": "Doc", "State": "active", "File": "documents/7.xml", "SHA256": "` + checksum([]byte("<doc><title>Doc</title></doc>")) + `"}]}`
+
+	tests := []struct {
+		desc        string
+		files       map[string]string
+		expectedErr string
+	}{
+		{
+			desc:        "missing manifest",
+			files:       map[string]string{"documents/7.xml": "<doc><title>Doc</title></doc>"},
+			expectedErr: "missing manifest",
+		}, {
+			desc:        "unsupported version",
+			files:       map[string]string{ARCHIVE_MANIFEST_NAME: `{"Version": 99}`},
+			expectedErr: "unsupported archive version 99",
+		}, {
+			desc:        "missing file",
+			files:       map[string]string{ARCHIVE_MANIFEST_NAME: manifest},
+			expectedErr: "missing file documents/7.xml",
+		}, {
+			desc:        "corrupted file",
+			files:       map[string]string{ARCHIVE_MANIFEST_NAME: manifest, "documents/7.xml": "<doc><title>Changed</title></doc>"},
+			expectedErr: "checksum mismatch",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			db, cleanup := setupTestDB(t)
+			defer cleanup()
+
+			var archive bytes.Buffer
+			gz := gzip.NewWriter(&archive)
+			tw := tar.NewWriter(gz)
+			for name, data := range tt.files {
+				require.NoError(t, writeArchiveFile(tw, name, []byte(data), time.Now()))
+			}
+			require.NoError(t, tw.Close())
+			require.NoError(t, gz.Close())
+
+			count, err := importArchive(db, &archive)
+			require.ErrorContains(t, err, tt.expectedErr)
+			require.Equal(t, 0, count)
+
+			ids, err := listDocumentIDs(db, documentStates, false)
+			require.NoError(t, err)
+			require.Empty(t, ids)
+		})
+	}
+}
+
+// Test that the archive holds the original XML
+func TestExportArchiveFiles(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<doc><title>Only</title></doc>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	var archive bytes.Buffer
+	_, err = exportArchive(db, &archive, time.Now())
+	require.NoError(t, err)
+
+	manifest, files, err := readArchive(&archive)
+	require.NoError(t, err)
+	require.Len(t, manifest.Documents, 1)
+	require.Equal(t, "documents/1.xml", manifest.Documents[0].File)
+	require.Equal(t, "<doc><title>Only</title></doc>", string(files["documents/1.xml"]))
+}
+
+// Test that archives exported with a key are signed and only imported with the same key
+func TestSignedArchive(t *testing.T) {
+	source, cleanupSource := setupTestDB(t)
+	defer cleanupSource()
+
+	doc, err := parseDocument("<doc><title>Signed</title></doc>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(source, *doc))
+
+	export := func(key string) []byte {
+		archiveKey = []byte(key)
+		defer func() { archiveKey = nil }()
+		var archive bytes.Buffer
+		_, err := exportArchive(source, &archive, time.Now())
+		require.NoError(t, err)
+		return archive.Bytes()
+	}
+	signed := export("archive key")
+	unsigned := export("")
+
+	// Tampering with the manifest, e.g. to match a changed file, breaks the signature
+	manifest, files, err := readArchive(bytes.NewReader(unsigned))
+	require.NoError(t, err)
+	require.Len(t, manifest.Documents, 1)
+	var tampered bytes.Buffer
+	gz := gzip.NewWriter(&tampered)
+	tw := tar.NewWriter(gz)
+	manifestFile, signature := readArchiveFiles(t, signed)
+	require.NoError(t, writeArchiveFile(tw, ARCHIVE_MANIFEST_NAME, bytes.Replace(manifestFile, []byte("Signed"), []byte("Forged"), 1), time.Now()))
+	require.NoError(t, writeArchiveFile(tw, ARCHIVE_SIGNATURE_NAME, signature, time.Now()))
+	require.NoError(t, writeArchiveFile(tw, "documents/1.xml", files["documents/1.xml"], time.Now()))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	for _, tt := range []struct {
+		desc        string
+		key         string
+		archive     []byte
+		expectedErr string
+	}{
+		{desc: "signed", key: "archive key", archive: signed},
+		{desc: "unsigned without key", archive: unsigned},
+		{desc: "other key", key: "other key", archive: signed, expectedErr: "invalid manifest signature"},
+		{desc: "tampered", key: "archive key", archive: tampered.Bytes(), expectedErr: "invalid manifest signature"},
+		{desc: "unsigned", key: "archive key", archive: unsigned, expectedErr: "archive is not signed"},
+		{desc: "signed without key", archive: signed, expectedErr: "archive is signed but " + ARCHIVE_KEY_ENV + " is not set"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			target, cleanupTarget := setupTestDB(t)
+			defer cleanupTarget()
+
+			archiveKey = []byte(tt.key)
+			defer func() { archiveKey = nil }()
+			count, err := importArchive(target, bytes.NewReader(tt.archive))
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 1, count)
+		})
+	}
+}
+
+// readArchiveFiles returns the manifest and signature files of an archive as they are stored
+func readArchiveFiles(t *testing.T, archive []byte) ([]byte, []byte) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = data
+	}
+	return files[ARCHIVE_MANIFEST_NAME], files[ARCHIVE_SIGNATURE_NAME]
+}
@@ -0,0 +1,377 @@
+package goapp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	API_KEY_ENV = "DOC_API_KEY" // Environment variable holding the global API key
+
+	DB_TOKEN_TABLE_NAME             = "access_token" // Table name of access tokens in SQLite
+	DB_TOKEN_ID_FIELD_NAME          = "id"           // Field name for id in the access token table
+	DB_TOKEN_HASH_FIELD_NAME        = "token_hash"   // Field name for the SHA-256 hash of the token
+	DB_TOKEN_ACCESS_FIELD_NAME      = "access"       // Field name for the granted access ("read" or "write")
+	DB_TOKEN_DOCUMENT_ID_FIELD_NAME = "document_id"  // Field name for the document the token is scoped to, NULL for all documents
+	DB_TOKEN_TAG_FIELD_NAME         = "tag"          // Field name for the tag of the collection the token is scoped to, NULL for all documents
+	DB_TOKEN_CREATEDAT_FIELD_NAME   = "created_at"   // Field name for the creation time of the token
+	DB_TOKEN_REVOKEDAT_FIELD_NAME   = "revoked_at"   // Field name for the revocation time of the token
+
+	ACCESS_READ  = "read"  // Access needed to read documents
+	ACCESS_WRITE = "write" // Access needed to change documents, implies read access
+
+	TOKEN_PREFIX = "dt_" // Prefix of minted access tokens so they are recognizable in logs and configs
+)
+
+// apiKey is the global API key, set by initAuth
+// Authentication is disabled when it is empty
+var apiKey string
+
+// AccessToken describes a minted token, the token itself is only returned once when minting
+type AccessToken struct {
+	ID         int64
+	Token      string `json:",omitempty"`
+	Access     string
+	DocumentID string
+	Tag        string
+	CreatedAt  string
+}
+
+// initAuth loads the global API key from the environment
+func initAuth() {
+	funcName := "initAuth"
+
+	apiKey = os.Getenv(API_KEY_ENV)
+	if apiKey == "" {
+		log.Printf("%s: %s is not set, authentication is disabled", funcName, API_KEY_ENV)
+	}
+}
+
+// createTokenTable creates the access token table if not exists
+func createTokenTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER PRIMARY KEY,
+		"%s" TEXT NOT NULL UNIQUE,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT,
+		"%s" TEXT
+	);
+`, DB_TOKEN_TABLE_NAME, DB_TOKEN_ID_FIELD_NAME, DB_TOKEN_HASH_FIELD_NAME, DB_TOKEN_ACCESS_FIELD_NAME, DB_TOKEN_DOCUMENT_ID_FIELD_NAME, DB_TOKEN_CREATEDAT_FIELD_NAME, DB_TOKEN_REVOKEDAT_FIELD_NAME, DB_TOKEN_TAG_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+	return ensureColumn(db, DB_TOKEN_TABLE_NAME, DB_TOKEN_TAG_FIELD_NAME, "TEXT")
+}
+
+// hashToken returns the hex encoded SHA-256 hash under which a token is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// mintToken creates a token granting access to the document documentID, to the collection of documents with the tag,
+// or to all documents if both are empty
+func mintToken(db *sql.DB, access string, documentID string, tag string, now time.Time) (*AccessToken, error) {
+	defer observeQuery("mintToken", time.Now())
+
+	if access != ACCESS_READ && access != ACCESS_WRITE {
+		return nil, fmt.Errorf("invalid access %s", access)
+	}
+	if documentID != "" && tag != "" {
+		return nil, errors.New("a token is scoped to a document or a collection, not both")
+	}
+	if tag != "" {
+		if err := validateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	token := TOKEN_PREFIX + hex.EncodeToString(secret)
+
+	var docID, collection sql.NullString
+	if documentID != "" {
+		docID = sql.NullString{String: documentID, Valid: true}
+	}
+	if tag != "" {
+		collection = sql.NullString{String: tag, Valid: true}
+	}
+	createdAt := now.UTC().Format(time.RFC3339)
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s, %s, %s)
+		VALUES (?, ?, ?, ?, ?)
+	`, DB_TOKEN_TABLE_NAME, DB_TOKEN_HASH_FIELD_NAME, DB_TOKEN_ACCESS_FIELD_NAME, DB_TOKEN_DOCUMENT_ID_FIELD_NAME, DB_TOKEN_TAG_FIELD_NAME, DB_TOKEN_CREATEDAT_FIELD_NAME)
+	result, err := db.Exec(query, hashToken(token), access, docID, collection, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessToken{ID: id, Token: token, Access: access, DocumentID: documentID, Tag: tag, CreatedAt: createdAt}, nil
+}
+
+// revokeToken revokes the token with the given ID
+// It returns sql.ErrNoRows if there is no such token which isn't revoked yet
+func revokeToken(db *sql.DB, id string, now time.Time) error {
+	defer observeQuery("revokeToken", time.Now())
+
+	query := fmt.Sprintf(`
+		UPDATE %s SET %s=? WHERE %s=? AND %s IS NULL
+	`, DB_TOKEN_TABLE_NAME, DB_TOKEN_REVOKEDAT_FIELD_NAME, DB_TOKEN_ID_FIELD_NAME, DB_TOKEN_REVOKEDAT_FIELD_NAME)
+	result, err := db.Exec(query, now.UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// lookupToken returns the access token with the given secret
+// It returns sql.ErrNoRows if the token doesn't exist or is revoked
+func lookupToken(db *sql.DB, token string) (*AccessToken, error) {
+	defer observeQuery("lookupToken", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, %s, %s, %s FROM %s WHERE %s=? AND %s IS NULL
+	`, DB_TOKEN_ID_FIELD_NAME, DB_TOKEN_ACCESS_FIELD_NAME, DB_TOKEN_DOCUMENT_ID_FIELD_NAME, DB_TOKEN_TAG_FIELD_NAME, DB_TOKEN_CREATEDAT_FIELD_NAME, DB_TOKEN_TABLE_NAME, DB_TOKEN_HASH_FIELD_NAME, DB_TOKEN_REVOKEDAT_FIELD_NAME)
+	var accessToken AccessToken
+	var documentID, tag sql.NullString
+	err := db.QueryRow(query, hashToken(token)).Scan(&accessToken.ID, &accessToken.Access, &documentID, &tag, &accessToken.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	accessToken.DocumentID = documentID.String
+	accessToken.Tag = tag.String
+	return &accessToken, nil
+}
+
+// grants reports whether the token grants access to the documents documentIDs, whose tags are looked up with tagged
+// for tokens scoped to a collection
+// No documentIDs stand for requests which aren't about a single document, e.g. listing or adding, which scoped
+// tokens don't grant.
+func (token *AccessToken) grants(access string, documentIDs []string, tagged func(id string, tag string) (bool, error)) (bool, error) {
+	if access == ACCESS_WRITE && token.Access != ACCESS_WRITE {
+		return false, nil
+	}
+	if token.DocumentID == "" && token.Tag == "" {
+		return true, nil
+	}
+	if len(documentIDs) == 0 {
+		return false, nil
+	}
+	for _, id := range documentIDs {
+		if token.DocumentID != "" && id != token.DocumentID {
+			return false, nil
+		}
+		if token.Tag != "" {
+			ok, err := tagged(id, token.Tag)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// documentScope returns the IDs of every document a request reads or changes, so scoped tokens can be checked
+// against all of them
+// Routes which aren't about a single document, e.g. listing, have no scope and are denied to scoped tokens.
+type documentScope func(r *http.Request) []string
+
+// documentParam is the scope of requests about the document given by the id parameter
+func documentParam(r *http.Request) []string {
+	return []string{r.URL.Query().Get("id")}
+}
+
+// bearerToken returns the credential of the Authorization header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+	return ""
+}
+
+// isAPIKey reports whether the credential is the global API key
+func isAPIKey(credential string) bool {
+	return apiKey != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(apiKey)) == 1
+}
+
+// requireAccess is a middleware accepting the global API key, a token granting access
+// to the documents of the scope or the session of a user whose role allows the access
+func requireAccess(access string, scope documentScope, next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		if apiKey == "" {
+			next(db, w, r)
+			return
+		}
+		if rejectBlockedClient(w, r, time.Now()) {
+			return
+		}
+
+		credential := bearerToken(r)
+		if credential == "" {
+			// Users logged in with the SSO have a session cookie instead
+			if found, allowed := sessionAccess(db, w, r, access); found {
+				if allowed {
+					next(db, w, r)
+				}
+				return
+			}
+			rejectAuth(db, w, r, credential, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+		if isAPIKey(credential) {
+			next(db, w, r)
+			return
+		}
+
+		token, err := lookupToken(db, credential)
+		if errors.Is(err, sql.ErrNoRows) {
+			rejectAuth(db, w, r, credential, "Invalid credentials", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to check access token: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// A valid token used outside of its scope is not a failed authentication
+		var documentIDs []string
+		if scope != nil {
+			documentIDs = scope(r)
+		}
+		granted, err := token.grants(access, documentIDs, func(id string, tag string) (bool, error) {
+			return documentHasTag(db, id, tag)
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to check access token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !granted {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		next(db, w, r)
+	}
+}
+
+// requireAPIKey is a middleware only accepting the global API key or the session of an admin
+func requireAPIKey(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		if apiKey == "" {
+			next(db, w, r)
+			return
+		}
+		if rejectBlockedClient(w, r, time.Now()) {
+			return
+		}
+
+		credential := bearerToken(r)
+		if credential == "" {
+			// Users logged in with the SSO need the admin role
+			if found, allowed := sessionAccess(db, w, r, ROLE_ADMIN); found {
+				if allowed {
+					next(db, w, r)
+				}
+				return
+			}
+		}
+		if !isAPIKey(credential) {
+			rejectAuth(db, w, r, credential, "API key required", http.StatusUnauthorized)
+			return
+		}
+		next(db, w, r)
+	}
+}
+
+// handleMintTokenRequest mints an access token, e.g. /token?access=read&id=1 or /token?access=read&tag=case-1234
+// Without id and tag the token grants access to all documents
+func handleMintTokenRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	access := r.URL.Query().Get("access")
+	if access == "" {
+		access = ACCESS_READ
+	}
+	if access != ACCESS_READ && access != ACCESS_WRITE {
+		http.Error(w, fmt.Sprintf("Invalid access %s", access), http.StatusBadRequest)
+		return
+	}
+
+	id, tag := r.URL.Query().Get("id"), r.URL.Query().Get("tag")
+	if id != "" && tag != "" {
+		http.Error(w, "Only one of id and tag parameters is allowed", http.StatusBadRequest)
+		return
+	}
+	if tag != "" {
+		if err := validateTag(tag); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid tag: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, err := mintToken(db, access, id, tag, time.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to mint token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(token)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+// handleRevokeTokenRequest revokes an access token by its ID, e.g. /token/revoke?id=1
+func handleRevokeTokenRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	err := revokeToken(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Token with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke token with ID %s: %v", id, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
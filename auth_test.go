@@ -0,0 +1,172 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test which accesses a token grants
+func TestTokenGrants(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	now := time.Now()
+	readDoc, err := mintToken(db, ACCESS_READ, "1", "", now)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(readDoc.Token, TOKEN_PREFIX))
+	writeAll, err := mintToken(db, ACCESS_WRITE, "", "", now)
+	require.NoError(t, err)
+	readCase, err := mintToken(db, ACCESS_READ, "", "case-1234", now)
+	require.NoError(t, err)
+	tagged := func(id string, tag string) (bool, error) {
+		return id == "1" && tag == "case-1234", nil
+	}
+
+	tests := []struct {
+		desc        string
+		token       string
+		access      string
+		documentIDs []string
+		expected    bool
+	}{
+		{desc: "read own document", token: readDoc.Token, access: ACCESS_READ, documentIDs: []string{"1"}, expected: true},
+		{desc: "read other document", token: readDoc.Token, access: ACCESS_READ, documentIDs: []string{"2"}, expected: false},
+		{desc: "read own and other document", token: readDoc.Token, access: ACCESS_READ, documentIDs: []string{"1", "2"}, expected: false},
+		{desc: "write with read token", token: readDoc.Token, access: ACCESS_WRITE, documentIDs: []string{"1"}, expected: false},
+		{desc: "list with document token", token: readDoc.Token, access: ACCESS_READ, documentIDs: nil, expected: false},
+		{desc: "write any document", token: writeAll.Token, access: ACCESS_WRITE, documentIDs: []string{"2"}, expected: true},
+		{desc: "read with write token", token: writeAll.Token, access: ACCESS_READ, documentIDs: nil, expected: true},
+		{desc: "read tagged document", token: readCase.Token, access: ACCESS_READ, documentIDs: []string{"1"}, expected: true},
+		{desc: "read untagged document", token: readCase.Token, access: ACCESS_READ, documentIDs: []string{"1", "2"}, expected: false},
+		{desc: "list with collection token", token: readCase.Token, access: ACCESS_READ, documentIDs: nil, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			token, err := lookupToken(db, tt.token)
+			require.NoError(t, err)
+			granted, err := token.grants(tt.access, tt.documentIDs, tagged)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, granted)
+		})
+	}
+
+	_, err = lookupToken(db, "dt_unknown")
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+
+	_, err = mintToken(db, "admin", "", "", now)
+	require.Error(t, err)
+	_, err = mintToken(db, ACCESS_READ, "1", "case-1234", now)
+	require.Error(t, err)
+	_, err = mintToken(db, ACCESS_READ, "", "a,b", now)
+	require.Error(t, err)
+}
+
+// Test that revoked tokens don't grant access anymore
+func TestRevokeToken(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	token, err := mintToken(db, ACCESS_READ, "1", "", time.Now())
+	require.NoError(t, err)
+
+	require.NoError(t, revokeToken(db, "1", time.Now()))
+	_, err = lookupToken(db, token.Token)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+
+	err = revokeToken(db, "1", time.Now())
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+// Test authenticating requests with the API key and access tokens
+func TestRequireAccess(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	apiKey = "test api key"
+	defer func() { apiKey = "" }()
+
+	for _, data := range []string{"<doc><title>Test Title</title></doc>", "<doc><title>Secret Title</title></doc>"} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	// Mint a read token for document 1 with the API key
+	req := httptest.NewRequest("POST", "/token?access=read&id=1", nil)
+	req.Header.Set("Authorization", "Bearer test api key")
+	w := httptest.NewRecorder()
+	handleRequest(db, w, req)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var token AccessToken
+	require.NoError(t, json.Unmarshal(body, &token))
+	writeToken, err := mintToken(db, ACCESS_WRITE, "1", "", time.Now())
+	require.NoError(t, err)
+
+	// Mint a read token for the collection of documents tagged case-1234, which only document 2 is in
+	require.NoError(t, setDocumentTags(db, "2", []string{"case-1234"}))
+	req = httptest.NewRequest("POST", "/token?access=read&tag=case-1234", nil)
+	req.Header.Set("Authorization", "Bearer test api key")
+	w = httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+	var caseToken AccessToken
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &caseToken))
+	require.Equal(t, "case-1234", caseToken.Tag)
+
+	tests := []struct {
+		desc          string
+		method        string
+		path          string
+		authorization string
+		expectedCode  int
+	}{
+		{desc: "no credentials", method: "GET", path: "/document?id=1", expectedCode: http.StatusUnauthorized},
+		{desc: "api key", method: "GET", path: "/document?id=1", authorization: "Bearer test api key", expectedCode: http.StatusOK},
+		{desc: "token", method: "GET", path: "/document?id=1", authorization: "Bearer " + token.Token, expectedCode: http.StatusOK},
+		{desc: "token for other document", method: "GET", path: "/document?id=2", authorization: "Bearer " + token.Token, expectedCode: http.StatusForbidden},
+		{desc: "token without write access", method: "DELETE", path: "/del?id=1", authorization: "Bearer " + token.Token, expectedCode: http.StatusForbidden},
+		{desc: "token can't mint tokens", method: "POST", path: "/token", authorization: "Bearer " + token.Token, expectedCode: http.StatusUnauthorized},
+		// The id parameter doesn't open routes which aren't about the document of the token
+		{desc: "token listing", method: "GET", path: "/list?id=1", authorization: "Bearer " + token.Token, expectedCode: http.StatusForbidden},
+		{desc: "token exporting", method: "GET", path: "/export?id=1", authorization: "Bearer " + token.Token, expectedCode: http.StatusForbidden},
+		{desc: "token suggesting", method: "GET", path: "/suggest?id=1&prefix=Se", authorization: "Bearer " + token.Token, expectedCode: http.StatusForbidden},
+		{desc: "token tagging", method: "POST", path: "/documents/tags?id=1", authorization: "Bearer " + writeToken.Token, expectedCode: http.StatusForbidden},
+		{desc: "token adding", method: "POST", path: "/add?id=1", authorization: "Bearer " + writeToken.Token, expectedCode: http.StatusForbidden},
+		// Comparing documents checks all of them
+		{desc: "token diffing other document", method: "GET", path: "/diff?id=1&id1=1&id2=2", authorization: "Bearer " + token.Token, expectedCode: http.StatusForbidden},
+		{desc: "token diffing own document", method: "GET", path: "/diff?id1=1&id2=1", authorization: "Bearer " + token.Token, expectedCode: http.StatusOK},
+		// Merging creates a new document, like adding
+		{desc: "token merging other document", method: "POST", path: "/documents/merge?id=1&ids=1,2", authorization: "Bearer " + writeToken.Token, expectedCode: http.StatusForbidden},
+		{desc: "token merging own document", method: "POST", path: "/documents/merge?id=1&ids=1,1", authorization: "Bearer " + writeToken.Token, expectedCode: http.StatusForbidden},
+		// Collection tokens are checked against the tags of the documents
+		{desc: "collection token", method: "GET", path: "/document?id=2", authorization: "Bearer " + caseToken.Token, expectedCode: http.StatusOK},
+		{desc: "collection token for untagged document", method: "GET", path: "/document?id=1", authorization: "Bearer " + caseToken.Token, expectedCode: http.StatusForbidden},
+		{desc: "collection token listing", method: "GET", path: "/list?tag=case-1234", authorization: "Bearer " + caseToken.Token, expectedCode: http.StatusForbidden},
+		{desc: "minting for document and collection", method: "POST", path: "/token?id=1&tag=case-1234", authorization: "Bearer test api key", expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			handleRequest(db, w, req)
+
+			require.Equal(t, tt.expectedCode, w.Result().StatusCode)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package goapp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BREAKER_STATE_CLOSED    = "closed"    // State of a breaker letting all calls through
+	BREAKER_STATE_OPEN      = "open"      // State of a breaker rejecting all calls
+	BREAKER_STATE_HALF_OPEN = "half-open" // State of a breaker letting a single probe call through
+
+	BREAKER_DEFAULT_FAILURES    = 5               // Consecutive failures opening a breaker by default
+	BREAKER_DEFAULT_BACKOFF     = time.Second     // Time a breaker stays open after it first opened by default
+	BREAKER_DEFAULT_MAX_BACKOFF = 5 * time.Minute // Longest time a breaker stays open by default
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker.Call while the downstream is considered dead
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops calling a failing downstream for a while so callers don't stall on it
+// After MaxFailures consecutive failures the breaker opens for Backoff, which doubles each time
+// a probe fails, up to MaxBackoff. Once the backoff elapsed a single probe call is let through
+// (half-open); it closes the breaker on success and reopens it on failure.
+type CircuitBreaker struct {
+	Name        string        // Name identifies the downstream in metrics
+	MaxFailures int           // MaxFailures is the number of consecutive failures opening the breaker
+	Backoff     time.Duration // Backoff is the time the breaker stays open after it first opened
+	MaxBackoff  time.Duration // MaxBackoff caps the exponential backoff
+
+	mu        sync.Mutex
+	state     string
+	failures  int
+	backoff   time.Duration
+	openUntil time.Time
+	now       func() time.Time // now returns the current time, replaced in tests
+}
+
+// newCircuitBreaker creates a closed breaker with the default settings
+func newCircuitBreaker(name string) *CircuitBreaker {
+	return &CircuitBreaker{
+		Name:        name,
+		MaxFailures: BREAKER_DEFAULT_FAILURES,
+		Backoff:     BREAKER_DEFAULT_BACKOFF,
+		MaxBackoff:  BREAKER_DEFAULT_MAX_BACKOFF,
+		state:       BREAKER_STATE_CLOSED,
+		now:         time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (breaker *CircuitBreaker) State() string {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.state == BREAKER_STATE_OPEN && !breaker.now().Before(breaker.openUntil) {
+		return BREAKER_STATE_HALF_OPEN
+	}
+	return breaker.state
+}
+
+// allow reports whether a call may go through and moves an elapsed open breaker to half-open
+func (breaker *CircuitBreaker) allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case BREAKER_STATE_OPEN:
+		if breaker.now().Before(breaker.openUntil) {
+			return false
+		}
+		// Only the first caller after the backoff probes the downstream
+		breaker.state = BREAKER_STATE_HALF_OPEN
+		return true
+	case BREAKER_STATE_HALF_OPEN:
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a call
+func (breaker *CircuitBreaker) record(err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if err == nil {
+		breaker.state = BREAKER_STATE_CLOSED
+		breaker.failures = 0
+		breaker.backoff = 0
+		return
+	}
+
+	breaker.failures++
+	if breaker.state == BREAKER_STATE_HALF_OPEN {
+		// The probe failed, wait twice as long before the next one
+		breaker.backoff *= 2
+		if breaker.backoff > breaker.MaxBackoff {
+			breaker.backoff = breaker.MaxBackoff
+		}
+	} else if breaker.failures >= breaker.MaxFailures {
+		breaker.backoff = breaker.Backoff
+	} else {
+		return
+	}
+
+	breaker.state = BREAKER_STATE_OPEN
+	breaker.openUntil = breaker.now().Add(breaker.backoff)
+	metrics.inc("circuit_breaker_opened_total", "breaker", breaker.Name)
+}
+
+// Call runs fn unless the breaker is open, in which case ErrCircuitOpen is returned right away
+func (breaker *CircuitBreaker) Call(fn func() error) error {
+	if !breaker.allow() {
+		metrics.inc("circuit_breaker_rejected_total", "breaker", breaker.Name)
+		return ErrCircuitOpen
+	}
+
+	err := fn()
+	breaker.record(err)
+	return err
+}
@@ -0,0 +1,73 @@
+package goapp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test opening, probing and closing a circuit breaker
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 7, 9, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker("test")
+	breaker.MaxFailures = 2
+	breaker.Backoff = time.Second
+	breaker.MaxBackoff = 3 * time.Second
+	breaker.now = func() time.Time { return now }
+
+	failing := func() error { return errors.New("downstream dead") }
+	calls := 0
+	working := func() error {
+		calls++
+		return nil
+	}
+
+	require.Error(t, breaker.Call(failing))
+	require.Equal(t, BREAKER_STATE_CLOSED, breaker.State())
+	require.Error(t, breaker.Call(failing))
+	require.Equal(t, BREAKER_STATE_OPEN, breaker.State())
+
+	// Calls are rejected without reaching the downstream while open
+	require.True(t, errors.Is(breaker.Call(working), ErrCircuitOpen))
+	require.Equal(t, 0, calls)
+
+	// A failed probe doubles the backoff
+	now = now.Add(time.Second)
+	require.Equal(t, BREAKER_STATE_HALF_OPEN, breaker.State())
+	require.Error(t, breaker.Call(failing))
+	now = now.Add(time.Second)
+	require.True(t, errors.Is(breaker.Call(working), ErrCircuitOpen))
+
+	// The backoff is capped at MaxBackoff
+	now = now.Add(time.Second)
+	require.Error(t, breaker.Call(failing))
+	now = now.Add(3 * time.Second)
+	require.Equal(t, BREAKER_STATE_HALF_OPEN, breaker.State())
+
+	// A successful probe closes the breaker
+	require.NoError(t, breaker.Call(working))
+	require.Equal(t, 1, calls)
+	require.Equal(t, BREAKER_STATE_CLOSED, breaker.State())
+	require.NoError(t, breaker.Call(working))
+}
+
+// Test that only one probe is let through while half-open
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	now := time.Date(2024, 7, 9, 0, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker("test")
+	breaker.MaxFailures = 1
+	breaker.now = func() time.Time { return now }
+
+	require.Error(t, breaker.Call(func() error { return errors.New("downstream dead") }))
+	now = now.Add(breaker.Backoff)
+
+	err := breaker.Call(func() error {
+		// A concurrent call during the probe is rejected
+		require.True(t, errors.Is(breaker.Call(func() error { return nil }), ErrCircuitOpen))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, BREAKER_STATE_CLOSED, breaker.State())
+}
@@ -0,0 +1,285 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AUTH_MAX_FAILURES            = 5                   // Failed authentications allowed per source within AUTH_FAILURE_WINDOW
+	AUTH_FAILURE_WINDOW          = 5 * time.Minute     // Window in which failed authentications are counted
+	AUTH_BLOCK_DURATION          = 15 * time.Minute    // Duration a source is blocked for after too many failures
+	AUTH_LIMITER_SWEEP_THRESHOLD = 10000               // Number of tracked sources above which stale entries are swept
+	AUTH_FAILURE_KEY_HINT_LENGTH = 12                  // Length of the credential fingerprint kept in the audit log
+	AUTH_FAILURE_RETENTION       = 30 * 24 * time.Hour // Time auth failures are kept in the audit log
+
+	AUTH_FAILURES_DEFAULT_LIMIT = 50  // Number of auth failures returned by the admin endpoint by default
+	AUTH_FAILURES_MAX_LIMIT     = 500 // Highest number of auth failures the admin endpoint returns
+
+	DB_AUTH_FAILURE_TABLE_NAME         = "auth_failure"      // Table name of the auth failure audit log in SQLite
+	DB_AUTH_FAILURE_ID_FIELD_NAME      = "id"                // Field name for id in the auth failure table
+	DB_AUTH_FAILURE_TIME_FIELD_NAME    = "occurred_at"       // Field name for the time of the failure
+	DB_AUTH_FAILURE_IP_FIELD_NAME      = "ip"                // Field name for the client IP
+	DB_AUTH_FAILURE_KEY_FIELD_NAME     = "key_hint"          // Field name for the fingerprint of the presented credential
+	DB_AUTH_FAILURE_PATH_FIELD_NAME    = "path"              // Field name for the requested path
+	DB_AUTH_FAILURE_REASON_FIELD_NAME  = "reason"            // Field name for the reason of the failure
+	DB_AUTH_FAILURE_BLOCKED_FIELD_NAME = "blocked"           // Field name for whether the failure led to a block
+	DB_AUTH_FAILURE_TIME_INDEX_NAME    = "auth_failure_time" // Index of auth failures by time, used to prune the audit log
+)
+
+// AuthFailure is an entry of the auth failure audit log
+type AuthFailure struct {
+	ID         int64
+	OccurredAt string
+	IP         string
+	KeyHint    string
+	Path       string
+	Reason     string
+	Blocked    bool
+}
+
+// authSource holds the recent failures of one source (an IP or a credential)
+type authSource struct {
+	Failures     []time.Time
+	BlockedUntil time.Time
+}
+
+// authLimiter tracks failed authentications per source and blocks abusive ones
+type authLimiter struct {
+	mu      sync.Mutex
+	sources map[string]*authSource
+}
+
+// authFailures is the limiter shared by all authenticated endpoints
+var authFailures = newAuthLimiter()
+
+func newAuthLimiter() *authLimiter {
+	return &authLimiter{sources: map[string]*authSource{}}
+}
+
+// blockedUntil returns the time until which one of the keys is blocked
+func (limiter *authLimiter) blockedUntil(keys []string, now time.Time) (time.Time, bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	var until time.Time
+	for _, key := range keys {
+		source, ok := limiter.sources[key]
+		if ok && source.BlockedUntil.After(now) && source.BlockedUntil.After(until) {
+			until = source.BlockedUntil
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// fail records a failed authentication for all keys and reports whether one of them got blocked
+func (limiter *authLimiter) fail(keys []string, now time.Time) bool {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if len(limiter.sources) > AUTH_LIMITER_SWEEP_THRESHOLD {
+		limiter.sweep(now)
+	}
+
+	blocked := false
+	for _, key := range keys {
+		source, ok := limiter.sources[key]
+		if !ok {
+			source = &authSource{}
+			limiter.sources[key] = source
+		}
+
+		// Only keep the failures inside the window
+		recent := source.Failures[:0]
+		for _, failure := range source.Failures {
+			if now.Sub(failure) < AUTH_FAILURE_WINDOW {
+				recent = append(recent, failure)
+			}
+		}
+		source.Failures = append(recent, now)
+
+		if len(source.Failures) >= AUTH_MAX_FAILURES {
+			source.BlockedUntil = now.Add(AUTH_BLOCK_DURATION)
+			source.Failures = nil
+			blocked = true
+		}
+	}
+	return blocked
+}
+
+// sweep drops sources which are neither blocked nor have failures inside the window, mu must be held
+func (limiter *authLimiter) sweep(now time.Time) {
+	for key, source := range limiter.sources {
+		stale := !source.BlockedUntil.After(now)
+		for _, failure := range source.Failures {
+			if now.Sub(failure) < AUTH_FAILURE_WINDOW {
+				stale = false
+			}
+		}
+		if stale {
+			delete(limiter.sources, key)
+		}
+	}
+}
+
+// authKeyHint returns a short fingerprint of a credential which is safe to log
+func authKeyHint(credential string) string {
+	if credential == "" {
+		return ""
+	}
+	return hashToken(credential)[:AUTH_FAILURE_KEY_HINT_LENGTH]
+}
+
+// authSourceKeys returns the limiter keys of a request: its IP and, if given, its credential
+func authSourceKeys(r *http.Request, credential string) []string {
+	keys := []string{"ip:" + clientIP(r).String()}
+	if credential != "" {
+		keys = append(keys, "key:"+authKeyHint(credential))
+	}
+	return keys
+}
+
+// rejectBlockedClient answers 429 if the client or its credential is blocked and reports whether it did
+func rejectBlockedClient(w http.ResponseWriter, r *http.Request, now time.Time) bool {
+	until, blocked := authFailures.blockedUntil(authSourceKeys(r, bearerToken(r)), now)
+	if !blocked {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(until.Sub(now).Seconds())+1))
+	http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+	return true
+}
+
+// rejectAuth records a failed authentication in the limiter and the audit log and answers with the error
+func rejectAuth(db *sql.DB, w http.ResponseWriter, r *http.Request, credential string, message string, status int) {
+	funcName := "rejectAuth"
+
+	now := time.Now()
+	blocked := authFailures.fail(authSourceKeys(r, credential), now)
+
+	failure := AuthFailure{
+		OccurredAt: now.UTC().Format(time.RFC3339),
+		IP:         clientIP(r).String(),
+		KeyHint:    authKeyHint(credential),
+		Path:       r.URL.Path,
+		Reason:     message,
+		Blocked:    blocked,
+	}
+	if err := insertAuthFailure(db, failure); err != nil {
+		log.Printf("%s: Failed to record auth failure: %v", funcName, err)
+	}
+	// Pruning on insert bounds the audit log while it is written to, e.g. during an attack
+	if err := pruneAuthFailures(db, now); err != nil {
+		log.Printf("%s: Failed to prune auth failures: %v", funcName, err)
+	}
+	if blocked {
+		log.Printf("%s: Blocking %s after %d failed authentication attempts", funcName, failure.IP, AUTH_MAX_FAILURES)
+	}
+
+	http.Error(w, message, status)
+}
+
+// createAuthFailureTable creates the auth failure audit log table if not exists
+func createAuthFailureTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER PRIMARY KEY,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT,
+		"%s" TEXT,
+		"%s" TEXT,
+		"%s" TEXT,
+		"%s" INTEGER NOT NULL DEFAULT 0
+	);
+`, DB_AUTH_FAILURE_TABLE_NAME, DB_AUTH_FAILURE_ID_FIELD_NAME, DB_AUTH_FAILURE_TIME_FIELD_NAME, DB_AUTH_FAILURE_IP_FIELD_NAME, DB_AUTH_FAILURE_KEY_FIELD_NAME, DB_AUTH_FAILURE_PATH_FIELD_NAME, DB_AUTH_FAILURE_REASON_FIELD_NAME, DB_AUTH_FAILURE_BLOCKED_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", DB_AUTH_FAILURE_TIME_INDEX_NAME, DB_AUTH_FAILURE_TABLE_NAME, DB_AUTH_FAILURE_TIME_FIELD_NAME))
+	return err
+}
+
+// insertAuthFailure appends a failure to the audit log
+func insertAuthFailure(db *sql.DB, failure AuthFailure) error {
+	defer observeQuery("insertAuthFailure", time.Now())
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s, %s, %s, %s)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, DB_AUTH_FAILURE_TABLE_NAME, DB_AUTH_FAILURE_TIME_FIELD_NAME, DB_AUTH_FAILURE_IP_FIELD_NAME, DB_AUTH_FAILURE_KEY_FIELD_NAME, DB_AUTH_FAILURE_PATH_FIELD_NAME, DB_AUTH_FAILURE_REASON_FIELD_NAME, DB_AUTH_FAILURE_BLOCKED_FIELD_NAME)
+	_, err := db.Exec(query, failure.OccurredAt, failure.IP, failure.KeyHint, failure.Path, failure.Reason, failure.Blocked)
+	return err
+}
+
+// pruneAuthFailures deletes the auth failures older than AUTH_FAILURE_RETENTION at now
+func pruneAuthFailures(db *sql.DB, now time.Time) error {
+	defer observeQuery("pruneAuthFailures", time.Now())
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s<?`, DB_AUTH_FAILURE_TABLE_NAME, DB_AUTH_FAILURE_TIME_FIELD_NAME)
+	_, err := db.Exec(query, now.Add(-AUTH_FAILURE_RETENTION).UTC().Format(time.RFC3339))
+	return err
+}
+
+// listAuthFailures returns the most recent auth failures, newest first
+func listAuthFailures(db *sql.DB, limit int) ([]AuthFailure, error) {
+	defer observeQuery("listAuthFailures", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, %s, %s, %s, %s, %s FROM %s ORDER BY %s DESC LIMIT ?
+	`, DB_AUTH_FAILURE_ID_FIELD_NAME, DB_AUTH_FAILURE_TIME_FIELD_NAME, DB_AUTH_FAILURE_IP_FIELD_NAME, DB_AUTH_FAILURE_KEY_FIELD_NAME, DB_AUTH_FAILURE_PATH_FIELD_NAME, DB_AUTH_FAILURE_REASON_FIELD_NAME, DB_AUTH_FAILURE_BLOCKED_FIELD_NAME, DB_AUTH_FAILURE_TABLE_NAME, DB_AUTH_FAILURE_ID_FIELD_NAME)
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []AuthFailure{}
+	for rows.Next() {
+		var failure AuthFailure
+		err := rows.Scan(&failure.ID, &failure.OccurredAt, &failure.IP, &failure.KeyHint, &failure.Path, &failure.Reason, &failure.Blocked)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
+
+// handleAuthFailuresRequest returns the recent auth failures, e.g. /admin/auth-failures?limit=20
+func handleAuthFailuresRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	limit := AUTH_FAILURES_DEFAULT_LIMIT
+	if param := r.URL.Query().Get("limit"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value <= 0 || value > AUTH_FAILURES_MAX_LIMIT {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", AUTH_FAILURES_MAX_LIMIT), http.StatusBadRequest)
+			return
+		}
+		limit = value
+	}
+
+	failures, err := listAuthFailures(db, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list auth failures: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(failures)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
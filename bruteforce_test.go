@@ -0,0 +1,102 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test blocking a source after too many failures
+func TestAuthLimiter(t *testing.T) {
+	limiter := newAuthLimiter()
+	now := time.Date(2024, 7, 9, 0, 0, 0, 0, time.UTC)
+	keys := []string{"ip:192.0.2.1"}
+
+	for i := 0; i < AUTH_MAX_FAILURES-1; i++ {
+		require.False(t, limiter.fail(keys, now))
+	}
+	_, blocked := limiter.blockedUntil(keys, now)
+	require.False(t, blocked)
+
+	// Failures outside of the window are forgotten
+	later := now.Add(AUTH_FAILURE_WINDOW)
+	require.False(t, limiter.fail(keys, later))
+
+	for i := 0; i < AUTH_MAX_FAILURES-2; i++ {
+		require.False(t, limiter.fail(keys, later))
+	}
+	require.True(t, limiter.fail(keys, later))
+
+	until, blocked := limiter.blockedUntil(keys, later)
+	require.True(t, blocked)
+	require.Equal(t, later.Add(AUTH_BLOCK_DURATION), until)
+
+	_, blocked = limiter.blockedUntil([]string{"ip:192.0.2.2"}, later)
+	require.False(t, blocked)
+	_, blocked = limiter.blockedUntil(keys, until)
+	require.False(t, blocked)
+}
+
+// Test that failed authentications are blocked and audited
+func TestBruteForceProtection(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	apiKey = "test api key"
+	authFailures = newAuthLimiter()
+	defer func() {
+		apiKey = ""
+		authFailures = newAuthLimiter()
+	}()
+
+	for i := 0; i < AUTH_MAX_FAILURES; i++ {
+		req := httptest.NewRequest("GET", "/list", nil)
+		req.Header.Set("Authorization", "Bearer wrong key")
+		w := httptest.NewRecorder()
+		handleRequest(db, w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	}
+
+	// Even the right key is rejected while the address is blocked
+	req := httptest.NewRequest("GET", "/list", nil)
+	req.Header.Set("Authorization", "Bearer test api key")
+	w := httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	require.NotEmpty(t, w.Result().Header.Get("Retry-After"))
+
+	failures, err := listAuthFailures(db, 10)
+	require.NoError(t, err)
+	require.Len(t, failures, AUTH_MAX_FAILURES)
+	require.True(t, failures[0].Blocked)
+	require.False(t, failures[1].Blocked)
+	require.Equal(t, "/list", failures[0].Path)
+	require.Equal(t, authKeyHint("wrong key"), failures[0].KeyHint)
+
+	// Another address can still use the admin endpoint
+	req = httptest.NewRequest("GET", "/admin/auth-failures?limit=2", nil)
+	req.RemoteAddr = "192.0.2.99:1234"
+	req.Header.Set("Authorization", "Bearer test api key")
+	w = httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+// Test that auth failures older than the retention are pruned
+func TestPruneAuthFailures(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{now.Add(-AUTH_FAILURE_RETENTION - time.Second), now.Add(-AUTH_FAILURE_RETENTION), now} {
+		require.NoError(t, insertAuthFailure(db, AuthFailure{OccurredAt: at.Format(time.RFC3339), Path: "/list"}))
+	}
+	require.NoError(t, pruneAuthFailures(db, now))
+
+	failures, err := listAuthFailures(db, 10)
+	require.NoError(t, err)
+	require.Len(t, failures, 2)
+	require.Equal(t, now.Add(-AUTH_FAILURE_RETENTION).Format(time.RFC3339), failures[1].OccurredAt)
+}
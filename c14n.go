@@ -0,0 +1,210 @@
+package goapp
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// c14nTextEscaper escapes text in canonical XML
+	c14nTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	// c14nAttrEscaper escapes attribute values in canonical XML
+	c14nAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+	// lineEndNormalizer turns CRLF and CR line ends into LF, as XML processors do
+	lineEndNormalizer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+)
+
+// Canonicalize returns the Exclusive XML Canonicalization (without comments) of data
+// Documents which only differ in their declaration, DOCTYPE, comments, attribute order and quotes, empty-element
+// tags, character references, CDATA sections, line ends or unused namespace declarations have the same
+// canonical form. Entities declared in the DOCTYPE aren't expanded. Malformed markup gives a *ParseError.
+func Canonicalize(data string) (string, error) {
+	nodes, err := parseMarkup(data)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	afterRoot := false
+	for _, node := range nodes {
+		switch {
+		case node.kind == markupElement:
+			if err := node.writeCanonical(&out, map[string]string{}, map[string]string{}); err != nil {
+				return "", err
+			}
+			afterRoot = true
+		case isInstruction(node) && !isDeclaration(node):
+			// Processing instructions are separated from the root element by a line end
+			if afterRoot {
+				out.WriteString("\n" + canonicalInstruction(node.source))
+			} else {
+				out.WriteString(canonicalInstruction(node.source) + "\n")
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+// canonicalHash returns the hex SHA-256 of the canonical form of data, empty if it can't be canonicalized
+func canonicalHash(data string) string {
+	canonical, err := Canonicalize(data)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:])
+}
+
+func isInstruction(node *markupNode) bool {
+	return node.kind == markupOther && strings.HasPrefix(node.source, PI_START)
+}
+
+// isDeclaration reports whether the node is the XML declaration, which isn't a processing instruction
+func isDeclaration(node *markupNode) bool {
+	target, _ := splitInstruction(node.source)
+	return target == "xml"
+}
+
+// splitInstruction splits a processing instruction like "<?target data?>" into its target and data
+func splitInstruction(source string) (target string, data string) {
+	content := strings.TrimSuffix(strings.TrimPrefix(source, PI_START), PI_END)
+	target = content
+	if i := strings.IndexAny(content, XML_WHITESPACE); i >= 0 {
+		target, data = content[:i], strings.TrimLeft(content[i:], XML_WHITESPACE)
+	}
+	return target, data
+}
+
+// canonicalInstruction writes a processing instruction with a single space between its target and data
+func canonicalInstruction(source string) string {
+	target, data := splitInstruction(source)
+	if data == "" {
+		return PI_START + target + PI_END
+	}
+	return PI_START + target + " " + lineEndNormalizer.Replace(data) + PI_END
+}
+
+// canonicalAttr is an attribute of a canonical start tag
+type canonicalAttr struct {
+	name      string
+	namespace string
+	local     string
+	value     string
+}
+
+// writeCanonical writes the element in canonical form
+// inScope holds the namespaces declared by the ancestors by prefix, rendered the namespaces declared by
+// the output ancestors; only the namespaces the element and its attributes use are declared on it.
+func (node *markupNode) writeCanonical(out *strings.Builder, inScope map[string]string, rendered map[string]string) error {
+	name, attrText := splitTag(node.start)
+	values := parseAttributes(attrText)
+
+	scope := make(map[string]string, len(inScope))
+	for prefix, uri := range inScope {
+		scope[prefix] = uri
+	}
+	for prefix, uri := range namespaceDeclarations(values) {
+		scope[prefix] = uri
+	}
+
+	var attrs []canonicalAttr
+	used := map[string]bool{}
+	if prefix, _ := splitName(name); prefix != "xml" {
+		used[prefix] = true
+	}
+	for attrName, value := range values {
+		if prefix, _ := splitName(attrName); attrName == XMLNS_ATTRIBUTE || prefix == XMLNS_ATTRIBUTE {
+			continue
+		}
+		// Attribute values are normalized the way a parser without DTD does
+		value = decodeEntities(strings.NewReplacer("\n", " ", "\t", " ").Replace(lineEndNormalizer.Replace(value)))
+		attr := canonicalAttr{name: attrName, local: attrName, value: value}
+		if prefix, local := splitName(attrName); prefix == "xml" {
+			attr.namespace, attr.local = XML_NAMESPACE, local
+		} else if prefix != "" {
+			uri, ok := scope[prefix]
+			if !ok {
+				return fmt.Errorf("undeclared namespace prefix %s of attribute %s", prefix, attrName)
+			}
+			attr.namespace, attr.local = uri, local
+			used[prefix] = true
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].namespace != attrs[j].namespace {
+			return attrs[i].namespace < attrs[j].namespace
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	// Declare the namespaces used here which no output ancestor declared with the same URI
+	var prefixes []string
+	for prefix := range used {
+		uri, ok := scope[prefix]
+		if !ok && prefix != "" {
+			return fmt.Errorf("undeclared namespace prefix %s of element %s", prefix, name)
+		}
+		if rendered[prefix] != uri {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	childRendered := rendered
+	if len(prefixes) > 0 {
+		childRendered = make(map[string]string, len(rendered)+len(prefixes))
+		for prefix, uri := range rendered {
+			childRendered[prefix] = uri
+		}
+	}
+
+	out.WriteString("<" + name)
+	for _, prefix := range prefixes {
+		childRendered[prefix] = scope[prefix]
+		if prefix == "" {
+			out.WriteString(` xmlns="` + c14nAttrEscaper.Replace(scope[prefix]) + `"`)
+		} else {
+			out.WriteString(" xmlns:" + prefix + `="` + c14nAttrEscaper.Replace(scope[prefix]) + `"`)
+		}
+	}
+	for _, attr := range attrs {
+		out.WriteString(" " + attr.name + `="` + c14nAttrEscaper.Replace(attr.value) + `"`)
+	}
+	out.WriteString(">")
+
+	for _, child := range node.children {
+		switch {
+		case child.kind == markupElement:
+			if err := child.writeCanonical(out, scope, childRendered); err != nil {
+				return err
+			}
+		case child.kind == markupText:
+			out.WriteString(c14nTextEscaper.Replace(decodeEntities(lineEndNormalizer.Replace(child.source))))
+		case child.kind == markupCDATA:
+			content := strings.TrimSuffix(strings.TrimPrefix(child.source, CDATA_START), CDATA_END)
+			out.WriteString(c14nTextEscaper.Replace(lineEndNormalizer.Replace(content)))
+		case isInstruction(child):
+			out.WriteString(canonicalInstruction(child.source))
+		}
+	}
+	out.WriteString("</" + name + ">")
+	return nil
+}
+
+// findDuplicate returns the ID of a document which isn't deleted and has the canonical hash
+// It returns sql.ErrNoRows if there is none
+func findDuplicate(db *sql.DB, hash string) (string, error) {
+	defer observeQuery("findDuplicate", time.Now())
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s=? AND %s<>? ORDER BY %s LIMIT 1", DB_ID_FIELD_NAME, DB_TABLE_NAME, DB_CANONICALHASH_FIELD_NAME, DB_STATE_FIELD_NAME, DB_ID_FIELD_NAME)
+	var id string
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, hash, DOC_STATE_DELETED).Scan(&id)
+	})
+	return id, err
+}
@@ -0,0 +1,78 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test the canonical form of documents written in different ways
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{
+			desc:     "prolog and comments",
+			data:     "<?xml version=\"1.0\"?>\r\n<!DOCTYPE doc>\n<?style href=\"a.css\"?><!-- c --><doc><!-- x -->a\r\nb</doc>\n<?end?>",
+			expected: "<?style href=\"a.css\"?>\n<doc>a\nb</doc>\n<?end?>",
+		},
+		{
+			desc:     "attributes",
+			data:     `<doc b='2' a="x &#x41; &quot;q&quot;" xml:lang="en"><e/></doc>`,
+			expected: `<doc a="x A &quot;q&quot;" b="2" xml:lang="en"><e></e></doc>`,
+		},
+		{
+			desc:     "text",
+			data:     `<doc>&#169; &gt; <![CDATA[<b> & ]]></doc>`,
+			expected: "<doc>© &gt; &lt;b&gt; &amp; </doc>",
+		},
+		{
+			desc:     "exclusive namespaces",
+			data:     `<a:doc xmlns:a="urn:a" xmlns:b="urn:b" xmlns:unused="urn:u"><b:e a:z="1" y="2"/><a:f xmlns:a="urn:a"/></a:doc>`,
+			expected: `<a:doc xmlns:a="urn:a"><b:e xmlns:b="urn:b" y="2" a:z="1"></b:e><a:f></a:f></a:doc>`,
+		},
+		{
+			desc:     "default namespace",
+			data:     `<doc xmlns="urn:d"><e xmlns=""/></doc>`,
+			expected: `<doc xmlns="urn:d"><e xmlns=""></e></doc>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			canonical, err := Canonicalize(tt.data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, canonical)
+		})
+	}
+
+	_, err := Canonicalize(`<a:doc/>`)
+	require.Error(t, err)
+	_, err = Canonicalize(`<doc>`)
+	require.Error(t, err)
+}
+
+// Test that /add turns away documents equal to a stored one but not to a deleted one
+func TestAddDuplicate(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	add := func(data string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest(http.MethodPost, "/add", strings.NewReader(data)))
+		return rr
+	}
+	require.Equal(t, http.StatusCreated, add(`<document><title a="1" b="2">Test</title></document>`).Code)
+
+	rr := add("<?xml version=\"1.0\"?>\n<document><!-- again --><title b='2' a='1'>T&#101;st</title></document>")
+	require.Equal(t, http.StatusConflict, rr.Code)
+	require.Contains(t, rr.Body.String(), "duplicate of document 1")
+	require.Equal(t, http.StatusCreated, add(`<document><title a="1" b="2">Test 2</title></document>`).Code)
+
+	require.NoError(t, changeState(db, "1", DOC_STATE_DELETED))
+	require.Equal(t, http.StatusCreated, add(`<document><title a="1" b="2">Test</title></document>`).Code)
+}
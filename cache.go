@@ -0,0 +1,352 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CACHE_ENV             = "DOC_CACHE"             // Environment variable enabling the response cache, "memory", "redis" or a redis:// URL
+	CACHE_TTL_ENV         = "DOC_CACHE_TTL"         // Environment variable with the time responses are cached, like 30s
+	CACHE_MAX_ENTRIES_ENV = "DOC_CACHE_MAX_ENTRIES" // Environment variable with the number of responses kept in memory
+
+	CACHE_MEMORY              = "memory"         // Value of CACHE_ENV caching responses in the memory of each instance
+	CACHE_REDIS               = "redis"          // Value of CACHE_ENV caching responses in the Redis server of REDIS_URL_ENV
+	CACHE_DEFAULT_TTL         = 30 * time.Second // Time responses are cached by default
+	CACHE_DEFAULT_MAX_ENTRIES = 1000             // Number of responses kept in memory by default
+	CACHE_MAX_BODY            = 1 << 20          // Largest response body which is cached
+	CACHE_GENERATION_KEY      = "cache:generation"
+
+	CACHE_STATUS_HEADER = "X-Cache" // Header telling whether the response came from the cache, "HIT" or "MISS"
+	CACHE_HIT           = "HIT"
+	CACHE_MISS          = "MISS"
+)
+
+// cachedHeaders are the response headers kept with cached responses
+var cachedHeaders = []string{"Content-Type", "Content-Language", "Content-Disposition"}
+
+// varyHeaders are the request headers the read endpoints use, they are part of the cache key
+var varyHeaders = []string{"Accept", "Accept-Language"}
+
+// responseVary is the Vary header of cacheable responses, which depend on the varyHeaders and the credential,
+// so shared proxies don't serve them to other clients
+var responseVary = strings.Join(varyHeaders, ", ") + ", Authorization, Cookie"
+
+// responseStore holds cached responses by key
+// Keys start with the generation of the store, which Invalidate increments to drop all cached responses at once.
+// Changes of documents don't need it, the keys hold the cacheVersion of the documents too.
+type responseStore interface {
+	Generation() (int64, error)
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Invalidate() error
+}
+
+// ResponseCache caches the successful responses of read endpoints for a while
+type ResponseCache struct {
+	Store responseStore
+	TTL   time.Duration
+}
+
+// cachedResponse is a response as it is kept in the store
+type cachedResponse struct {
+	Status int `json:",omitempty"` // Status is the status code, 200 OK if it is 0
+	Header map[string]string
+	Body   []byte
+}
+
+// responseCache is the cache of the read endpoints, nil unless CACHE_ENV is set
+var responseCache *ResponseCache
+
+// initCache sets up the response cache from the environment
+func initCache() {
+	funcName := "initCache"
+
+	backend := os.Getenv(CACHE_ENV)
+	if backend == "" {
+		return
+	}
+
+	ttl := CACHE_DEFAULT_TTL
+	if value := os.Getenv(CACHE_TTL_ENV); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("%s: %s must be a positive duration like 30s", funcName, CACHE_TTL_ENV)
+		}
+	}
+
+	if backend == CACHE_MEMORY {
+		maxEntries := CACHE_DEFAULT_MAX_ENTRIES
+		if value := os.Getenv(CACHE_MAX_ENTRIES_ENV); value != "" {
+			var err error
+			maxEntries, err = strconv.Atoi(value)
+			if err != nil || maxEntries <= 0 {
+				log.Fatalf("%s: %s must be a positive number", funcName, CACHE_MAX_ENTRIES_ENV)
+			}
+		}
+		responseCache = &ResponseCache{Store: newMemoryStore(maxEntries), TTL: ttl}
+		return
+	}
+
+	client := sharedRedis
+	if backend == CACHE_REDIS {
+		if client == nil {
+			log.Fatalf("%s: %s=%s needs %s", funcName, CACHE_ENV, CACHE_REDIS, REDIS_URL_ENV)
+		}
+	} else {
+		var err error
+		client, err = newRedisClient(backend)
+		if err != nil {
+			log.Fatalf("%s: Invalid %s: %v", funcName, CACHE_ENV, err)
+		}
+	}
+	responseCache = &ResponseCache{Store: &redisStore{client: client}, TTL: ttl}
+}
+
+// cacheKey returns the key of the response to a request, made of the URL, the headers the response depends
+// on and the credential, so clients never get responses cached for others
+func cacheKey(r *http.Request, generation int64, version string) string {
+	parts := []string{strconv.FormatInt(generation, 10), version, credentialScope(r), r.URL.Path, r.URL.RawQuery}
+	for _, name := range varyHeaders {
+		parts = append(parts, r.Header.Get(name))
+	}
+	return hashToken(strings.Join(parts, "\n"))
+}
+
+// cacheVersion returns the state of the documents at now, which changes with every change in the change feed,
+// whichever code path or instance made it, and when an embargo ends
+func cacheVersion(db *sql.DB, now time.Time) (string, error) {
+	defer observeQuery("cacheVersion", time.Now())
+
+	query := fmt.Sprintf("SELECT (SELECT COALESCE(MAX(%s), 0) FROM %s), (SELECT COALESCE(MAX(%s), '') FROM %s WHERE %s<=?)",
+		DB_CHANGE_SEQ_FIELD_NAME, DB_CHANGE_TABLE_NAME, DB_PUBLISHAT_FIELD_NAME, DB_TABLE_NAME, DB_PUBLISHAT_FIELD_NAME)
+	var cursor int64
+	var published string
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, formatExpiry(now)).Scan(&cursor, &published)
+	})
+	return fmt.Sprintf("%d@%s", cursor, published), err
+}
+
+// credentialScope returns the hash of the credential of a request, "anonymous" if it has none
+func credentialScope(r *http.Request) string {
+	if credential := bearerToken(r); credential != "" {
+		return hashToken(credential)
+	} else if cookie, err := r.Cookie(SESSION_COOKIE); err == nil && cookie.Value != "" {
+		return hashToken(cookie.Value)
+	}
+	return "anonymous"
+}
+
+// cacheResponses is a middleware serving GET requests from the response cache
+// It must run after the authentication, so only clients allowed to see a response get it from the cache.
+func cacheResponses(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", responseVary)
+		cache := responseCache
+		if cache == nil || r.Method != http.MethodGet {
+			next(db, w, r)
+			return
+		}
+		funcName := "cacheResponses"
+
+		generation, err := cache.Store.Generation()
+		if err != nil {
+			// The cache is an optimization, requests are served without it while it is down
+			log.Printf("%s: Failed to read cache generation: %v", funcName, err)
+			next(db, w, r)
+			return
+		}
+		version, err := cacheVersion(db, time.Now())
+		if err != nil {
+			log.Printf("%s: Failed to read document changes: %v", funcName, err)
+			next(db, w, r)
+			return
+		}
+		key := cacheKey(r, generation, version)
+
+		if data, found, err := cache.Store.Get(key); err != nil {
+			log.Printf("%s: Failed to read cached response: %v", funcName, err)
+		} else if found {
+			var response cachedResponse
+			if err := json.Unmarshal(data, &response); err == nil {
+				for name, value := range response.Header {
+					w.Header().Set(name, value)
+				}
+				w.Header().Set(CACHE_STATUS_HEADER, CACHE_HIT)
+				metrics.inc("response_cache_total", "result", "hit")
+				w.WriteHeader(http.StatusOK)
+				w.Write(response.Body)
+				return
+			}
+		}
+
+		metrics.inc("response_cache_total", "result", "miss")
+		w.Header().Set(CACHE_STATUS_HEADER, CACHE_MISS)
+		recorder := &statusRecorder{ResponseWriter: w, Status: http.StatusOK, Capture: CACHE_MAX_BODY + 1}
+		next(db, recorder, r)
+
+		// Only complete successful responses are cached, not the ones setting cookies
+		if recorder.Status != http.StatusOK || recorder.Bytes != len(recorder.Body) || recorder.Bytes > CACHE_MAX_BODY ||
+			w.Header().Get("Set-Cookie") != "" {
+			return
+		}
+		response := cachedResponse{Header: map[string]string{}, Body: recorder.Body}
+		for _, name := range cachedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				response.Header[name] = value
+			}
+		}
+		data, err := json.Marshal(response)
+		if err == nil {
+			err = cache.Store.Set(key, data, cache.TTL)
+		}
+		if err != nil {
+			log.Printf("%s: Failed to cache response: %v", funcName, err)
+		}
+	}
+}
+
+// invalidateOnWrite is a middleware dropping the cached responses after successful write requests
+func invalidateOnWrite(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		if responseCache == nil {
+			next(db, w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, Status: http.StatusOK}
+		next(db, recorder, r)
+		if recorder.Status < http.StatusBadRequest {
+			invalidateResponseCache()
+		}
+	}
+}
+
+// invalidateResponseCache drops all cached responses, after documents or settings changed
+func invalidateResponseCache() {
+	if responseCache == nil {
+		return
+	}
+	if err := responseCache.Store.Invalidate(); err != nil {
+		log.Printf("invalidateResponseCache: Failed to invalidate response cache: %v", err)
+	}
+}
+
+// memoryStore keeps cached responses in the memory of the instance
+type memoryStore struct {
+	maxEntries int
+
+	mu         sync.Mutex
+	generation int64
+	entries    map[string]memoryEntry
+	now        func() time.Time // now returns the current time, replaced in tests
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryStore(maxEntries int) *memoryStore {
+	return &memoryStore{maxEntries: maxEntries, entries: map[string]memoryEntry{}, now: time.Now}
+}
+
+func (store *memoryStore) Generation() (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.generation, nil
+}
+
+func (store *memoryStore) Get(key string) ([]byte, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.entries[key]
+	if !ok || !store.now().Before(entry.expires) {
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (store *memoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.now()
+	if _, ok := store.entries[key]; !ok && len(store.entries) >= store.maxEntries {
+		for other, entry := range store.entries {
+			if !now.Before(entry.expires) {
+				delete(store.entries, other)
+			}
+		}
+		// Without expired entries an arbitrary one makes room
+		for other := range store.entries {
+			if len(store.entries) < store.maxEntries {
+				break
+			}
+			delete(store.entries, other)
+		}
+	}
+	store.entries[key] = memoryEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+func (store *memoryStore) Invalidate() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// Responses of requests still running are stored under the old generation, where they are never found
+	store.generation++
+	store.entries = map[string]memoryEntry{}
+	return nil
+}
+
+// redisStore keeps cached responses in Redis, shared by all instances
+type redisStore struct {
+	client *RedisClient
+}
+
+func (store *redisStore) Generation() (int64, error) {
+	value, err := store.client.Get(store.client.Key(CACHE_GENERATION_KEY))
+	if errors.Is(err, ErrRedisNil) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	generation, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cache generation %q", value)
+	}
+	return generation, nil
+}
+
+func (store *redisStore) Get(key string) ([]byte, bool, error) {
+	value, err := store.client.Get(store.client.Key("cache:" + key))
+	if errors.Is(err, ErrRedisNil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+func (store *redisStore) Set(key string, value []byte, ttl time.Duration) error {
+	return store.client.Set(store.client.Key("cache:"+key), string(value), ttl)
+}
+
+func (store *redisStore) Invalidate() error {
+	// The entries of older generations expire by themselves
+	_, err := store.client.Incr(store.client.Key(CACHE_GENERATION_KEY))
+	return err
+}
@@ -0,0 +1,224 @@
+package goapp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test serving read endpoints from the cache until a write invalidates it
+func TestCacheResponses(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	responseCache = &ResponseCache{Store: newMemoryStore(10), TTL: time.Minute}
+	defer func() { responseCache = nil }()
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "First"}))
+
+	get := func(path string, authorization string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		handleRequest(db, rr, req)
+		return rr
+	}
+
+	first := get("/list", "")
+	require.Equal(t, http.StatusOK, first.Code)
+	require.Equal(t, CACHE_MISS, first.Header().Get(CACHE_STATUS_HEADER))
+
+	second := get("/list", "")
+	require.Equal(t, CACHE_HIT, second.Header().Get(CACHE_STATUS_HEADER))
+	require.Equal(t, first.Body.String(), second.Body.String())
+	require.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	require.Equal(t, "Accept, Accept-Language, Authorization, Cookie", second.Header().Get("Vary"))
+
+	// Other parameters and other credentials have their own entries
+	require.Equal(t, CACHE_MISS, get("/list?state=archived", "").Header().Get(CACHE_STATUS_HEADER))
+	require.Equal(t, CACHE_MISS, get("/list", "Bearer other").Header().Get(CACHE_STATUS_HEADER))
+
+	// Failed requests aren't cached
+	require.Equal(t, http.StatusBadRequest, get("/diff?id1=1", "").Code)
+	require.Equal(t, CACHE_MISS, get("/diff?id1=1", "").Header().Get(CACHE_STATUS_HEADER))
+
+	// A failed write keeps the cache, a successful one drops it
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("DELETE", "/del", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Equal(t, CACHE_HIT, get("/list", "").Header().Get(CACHE_STATUS_HEADER))
+
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("DELETE", "/del?id=1", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	third := get("/list", "")
+	require.Equal(t, CACHE_MISS, third.Header().Get(CACHE_STATUS_HEADER))
+	require.NotContains(t, third.Body.String(), "First")
+
+	// Changes made outside of write requests, like loading files or by other instances, are in the change feed
+	require.Equal(t, CACHE_HIT, get("/list", "").Header().Get(CACHE_STATUS_HEADER))
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Loaded"}))
+	fourth := get("/list", "")
+	require.Equal(t, CACHE_MISS, fourth.Header().Get(CACHE_STATUS_HEADER))
+	require.Contains(t, fourth.Body.String(), "Loaded")
+
+	// Ending embargoes change the version of the documents too
+	now := time.Now().UTC()
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Embargoed", PublishAt: formatExpiry(now.Add(time.Hour))}))
+	before, err := cacheVersion(db, now)
+	require.NoError(t, err)
+	same, err := cacheVersion(db, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, before, same)
+	after, err := cacheVersion(db, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.NotEqual(t, before, after)
+}
+
+// Test expiring and evicting responses kept in memory
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := newMemoryStore(2)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Set("a", []byte("1"), time.Minute))
+	require.NoError(t, store.Set("b", []byte("2"), time.Second))
+	value, found, err := store.Get("a")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "1", string(value))
+
+	// The expired entry makes room for the new one
+	now = now.Add(2 * time.Second)
+	require.NoError(t, store.Set("c", []byte("3"), time.Minute))
+	_, found, _ = store.Get("a")
+	require.True(t, found)
+	_, found, _ = store.Get("b")
+	require.False(t, found)
+
+	require.NoError(t, store.Invalidate())
+	generation, _ := store.Generation()
+	require.Equal(t, int64(1), generation)
+	_, found, _ = store.Get("c")
+	require.False(t, found)
+}
+
+// fakeRedis serves GET, SET (with NX), INCR, PEXPIRE and DEL like Redis does, without expiry
+func fakeRedis(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	values := map[string]string{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					reply, err := readRedisReply(reader)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, arg := range reply.([]interface{}) {
+						args = append(args, arg.(string))
+					}
+
+					mu.Lock()
+					switch args[0] {
+					case "GET":
+						if value, ok := values[args[1]]; ok {
+							conn.Write([]byte("$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"))
+						} else {
+							conn.Write([]byte("$-1\r\n"))
+						}
+					case "SET":
+						if _, ok := values[args[1]]; ok && args[len(args)-1] == "NX" {
+							conn.Write([]byte("$-1\r\n"))
+							break
+						}
+						values[args[1]] = args[2]
+						conn.Write([]byte("+OK\r\n"))
+					case "PEXPIRE":
+						conn.Write([]byte(":1\r\n"))
+					case "DEL":
+						delete(values, args[1])
+						conn.Write([]byte(":1\r\n"))
+					case "INCR":
+						count, _ := strconv.Atoi(values[args[1]])
+						values[args[1]] = strconv.Itoa(count + 1)
+						conn.Write([]byte(":" + values[args[1]] + "\r\n"))
+					default:
+						conn.Write([]byte("-ERR unknown command\r\n"))
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return "redis://" + listener.Addr().String()
+}
+
+// Test keeping cached responses in Redis
+func TestRedisStore(t *testing.T) {
+	client, err := newRedisClient(fakeRedis(t))
+	require.NoError(t, err)
+	store := &redisStore{client: client}
+
+	generation, err := store.Generation()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), generation)
+
+	_, found, err := store.Get("a")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, store.Set("a", []byte("cached"), time.Minute))
+	value, found, err := store.Get("a")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "cached", string(value))
+
+	require.NoError(t, store.Invalidate())
+	generation, err = store.Generation()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), generation)
+
+	// Error replies don't break the connection
+	_, err = client.Do("PING")
+	require.EqualError(t, err, "redis: ERR unknown command")
+	_, found, err = store.Get("a")
+	require.NoError(t, err)
+	require.True(t, found)
+}
+
+// Test parsing Redis URLs
+func TestNewRedisClient(t *testing.T) {
+	client, err := newRedisClient("redis://:secret@cache.internal/2")
+	require.NoError(t, err)
+	require.Equal(t, "cache.internal:6379", client.Addr)
+	require.Equal(t, "secret", client.Password)
+	require.Equal(t, 2, client.DB)
+
+	_, err = newRedisClient("http://cache.internal")
+	require.Error(t, err)
+	_, err = newRedisClient("redis://cache.internal/x")
+	require.Error(t, err)
+}
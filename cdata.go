@@ -0,0 +1,74 @@
+package goapp
+
+import "strings"
+
+const (
+	CDATA_START   = "<![CDATA[" // Start of a CDATA section, whose content is text even if it holds '<' or '>'
+	CDATA_END     = "]]>"       // End of a CDATA section
+	COMMENT_START = "<!--"      // Start of a comment
+	COMMENT_END   = "-->"       // End of a comment
+)
+
+// sectionEnd returns the index after the CDATA section, comment, processing instruction or DOCTYPE starting at data[i]
+// It returns 0 if none starts there and -1 if it is never closed
+func sectionEnd(data string, i int) int {
+	for _, section := range [][2]string{{CDATA_START, CDATA_END}, {COMMENT_START, COMMENT_END}, {PI_START, PI_END}} {
+		if !strings.HasPrefix(data[i:], section[0]) {
+			continue
+		}
+		end := strings.Index(data[i+len(section[0]):], section[1])
+		if end < 0 {
+			return -1
+		}
+		return i + len(section[0]) + end + len(section[1])
+	}
+	return doctypeEnd(data, i)
+}
+
+// sectionError describes the unterminated section starting at data[i]
+func sectionError(data string, i int) string {
+	if strings.HasPrefix(data[i:], DOCTYPE_START) {
+		return "unterminated DOCTYPE"
+	}
+	return "unterminated CDATA section, comment or processing instruction"
+}
+
+// unwrapCDATA replaces the CDATA sections of text with their content
+func unwrapCDATA(text string) string {
+	return mapCDATA(text, func(outside string) string { return outside })
+}
+
+// stripComments removes the comments of text, an unterminated one is kept
+func stripComments(text string) string {
+	for {
+		start := strings.Index(text, COMMENT_START)
+		if start < 0 {
+			return text
+		}
+		end := strings.Index(text[start+len(COMMENT_START):], COMMENT_END)
+		if end < 0 {
+			return text
+		}
+		text = text[:start] + text[start+len(COMMENT_START)+end+len(COMMENT_END):]
+	}
+}
+
+// mapCDATA replaces the CDATA sections of text with their content and applies outside to the text around them
+func mapCDATA(text string, outside func(string) string) string {
+	var result strings.Builder
+	for {
+		start := strings.Index(text, CDATA_START)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start+len(CDATA_START):], CDATA_END)
+		if end < 0 {
+			break
+		}
+		result.WriteString(outside(text[:start]))
+		result.WriteString(text[start+len(CDATA_START) : start+len(CDATA_START)+end])
+		text = text[start+len(CDATA_START)+end+len(CDATA_END):]
+	}
+	result.WriteString(outside(text))
+	return result.String()
+}
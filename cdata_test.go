@@ -0,0 +1,60 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that CDATA sections and comments holding '<' and '>' don't break parsing
+func TestParseDocumentCDATA(t *testing.T) {
+	tests := []struct {
+		desc        string
+		data        string
+		title       string
+		description string
+		err         string
+	}{
+		{
+			desc:        "cdata with markup",
+			data:        `<document><title><![CDATA[Fish & <Chips>]]></title><description>Before <![CDATA[a < b]]> after</description></document>`,
+			title:       "Fish & <Chips>",
+			description: "Before a < b after",
+		},
+		{
+			desc:  "cdata with closing tag",
+			data:  `<document><title><![CDATA[</title>]]></title></document>`,
+			title: "</title>",
+		},
+		{
+			desc:  "comment with markup",
+			data:  `<document><!-- <title>Old</title> --><title>Test Title</title></document>`,
+			title: "Test Title",
+		},
+		{
+			desc: "unterminated cdata",
+			data: `<document><title><![CDATA[Test Title</title></document>`,
+			err:  "unterminated CDATA section, comment or processing instruction at line 1, column 18",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			doc, err := parseDocument(tt.data)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.title, doc.Title)
+			require.Equal(t, tt.description, doc.Description)
+		})
+	}
+}
+
+// Test unwrapping CDATA sections in text
+func TestUnwrapCDATA(t *testing.T) {
+	require.Equal(t, "plain", unwrapCDATA("plain"))
+	require.Equal(t, "a <b> c", unwrapCDATA("a <![CDATA[<b>]]> c"))
+	require.Equal(t, "12", unwrapCDATA("<![CDATA[1]]><![CDATA[2]]>"))
+	require.Equal(t, "x <![CDATA[open", unwrapCDATA("x <![CDATA[open"))
+}
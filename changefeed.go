@@ -0,0 +1,157 @@
+package goapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DB_CHANGE_TABLE_NAME          = "document_change" // Table name of the change feed of the documents in SQLite
+	DB_CHANGE_SEQ_FIELD_NAME      = "seq"             // Field name for the position of the change in the feed
+	DB_CHANGE_DOCUMENT_FIELD_NAME = "document_id"     // Field name for the ID of the changed document
+	DB_CHANGE_KIND_FIELD_NAME     = "kind"            // Field name for the CHANGE_KIND_* of the change
+	DB_CHANGE_TIME_FIELD_NAME     = "changed_at"      // Field name for the time of the change
+
+	CHANGE_KIND_INSERT = "insert" // The document was added
+	CHANGE_KIND_UPDATE = "update" // The document was changed, e.g. patched, moved to another state or reprocessed
+	CHANGE_KIND_DELETE = "delete" // The document was deleted
+)
+
+// ErrInvalidCursor is returned for change feed cursors which aren't positions of the feed
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// createChangeTable creates the change feed table if not exists, with the triggers appending to it
+// The triggers record the writes of every code path, like the expiry job and reprocessing. The feed starts
+// when the table is created, so earlier changes aren't in it. Tagging and untagging a document are updates of it,
+// except for the tags removed with a deleted document.
+func createChangeTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"%s" INTEGER NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT
+	);
+`, DB_CHANGE_TABLE_NAME, DB_CHANGE_SEQ_FIELD_NAME, DB_CHANGE_DOCUMENT_FIELD_NAME, DB_CHANGE_KIND_FIELD_NAME, DB_CHANGE_TIME_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+	err = ensureColumn(db, DB_CHANGE_TABLE_NAME, DB_CHANGE_TIME_FIELD_NAME, "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// The triggers are replaced in one transaction, so they follow the columns of the document table
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	tagged := fmt.Sprintf("EXISTS (SELECT 1 FROM %s WHERE %s=%%s.%s)", DB_TABLE_NAME, DB_ID_FIELD_NAME, DB_TAG_DOCUMENT_FIELD_NAME)
+	for _, trigger := range []struct {
+		Name   string
+		Event  string
+		Table  string
+		Row    string
+		Column string // Column is the column of Table with the ID of the changed document
+		When   string // When is the condition of the trigger, empty for all rows
+		Kind   string
+	}{
+		{CHANGE_KIND_INSERT, "INSERT", DB_TABLE_NAME, "NEW", DB_ID_FIELD_NAME, "", CHANGE_KIND_INSERT},
+		{CHANGE_KIND_UPDATE, "UPDATE OF " + strings.Join(changeTrackedColumns(), ", "), DB_TABLE_NAME, "NEW", DB_ID_FIELD_NAME, "", CHANGE_KIND_UPDATE},
+		{CHANGE_KIND_DELETE, "DELETE", DB_TABLE_NAME, "OLD", DB_ID_FIELD_NAME, "", CHANGE_KIND_DELETE},
+		{"tag_insert", "INSERT", DB_TAG_TABLE_NAME, "NEW", DB_TAG_DOCUMENT_FIELD_NAME, fmt.Sprintf(tagged, "NEW"), CHANGE_KIND_UPDATE},
+		{"tag_delete", "DELETE", DB_TAG_TABLE_NAME, "OLD", DB_TAG_DOCUMENT_FIELD_NAME, fmt.Sprintf(tagged, "OLD"), CHANGE_KIND_UPDATE},
+	} {
+		name := DB_CHANGE_TABLE_NAME + "_" + trigger.Name
+		when := ""
+		if trigger.When != "" {
+			when = "WHEN " + trigger.When
+		}
+		query = fmt.Sprintf(`
+		CREATE TRIGGER %s AFTER %s ON %s %s
+		BEGIN
+			INSERT INTO %s (%s, %s, %s) VALUES (%s.%s, '%s', strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', 'now'));
+		END;
+`, name, trigger.Event, trigger.Table, when,
+			DB_CHANGE_TABLE_NAME, DB_CHANGE_DOCUMENT_FIELD_NAME, DB_CHANGE_KIND_FIELD_NAME, DB_CHANGE_TIME_FIELD_NAME, trigger.Row, trigger.Column, trigger.Kind)
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// changeTrackedColumns returns the columns of the document table whose updates are changes of the document
+// Moving the XML of a document between storage tiers leaves the document unchanged.
+func changeTrackedColumns() []string {
+	untracked := map[string]bool{DB_XMLDATA_FIELD_NAME: true, DB_TREE_FIELD_NAME: true, DB_TIER_FIELD_NAME: true, documentTagsColumn: true}
+	var columns []string
+	for _, column := range documentColumns {
+		if !untracked[column] {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// changeCursor returns the position of the last change in the feed, 0 if there is none yet
+func changeCursor(db *sql.DB) (int64, error) {
+	defer observeQuery("changeCursor", time.Now())
+
+	query := fmt.Sprintf("SELECT COALESCE(MAX(%s), 0) FROM %s", DB_CHANGE_SEQ_FIELD_NAME, DB_CHANGE_TABLE_NAME)
+	var cursor int64
+	err := withDBRetry(func() error {
+		return db.QueryRow(query).Scan(&cursor)
+	})
+	return cursor, err
+}
+
+// parseCursor parses a cursor returned by an earlier export, which can't be ahead of the feed at cursor
+func parseCursor(param string, cursor int64) (int64, error) {
+	since, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || since < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidCursor, param)
+	}
+	if since > cursor {
+		return 0, fmt.Errorf("%w: %d is ahead of the change feed at %d", ErrInvalidCursor, since, cursor)
+	}
+	return since, nil
+}
+
+// changedDocumentIDs returns the IDs of the documents changed after since up to cursor, in order
+func changedDocumentIDs(db *sql.DB, since int64, cursor int64) ([]string, error) {
+	defer observeQuery("changedDocumentIDs", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT DISTINCT %s FROM %s WHERE %s>? AND %s<=? ORDER BY %s
+	`, DB_CHANGE_DOCUMENT_FIELD_NAME, DB_CHANGE_TABLE_NAME, DB_CHANGE_SEQ_FIELD_NAME, DB_CHANGE_SEQ_FIELD_NAME, DB_CHANGE_DOCUMENT_FIELD_NAME)
+	var ids []string
+	err := withDBRetry(func() error {
+		rows, err := db.Query(query, since, cursor)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		ids = []string{}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	return ids, err
+}
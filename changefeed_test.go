@@ -0,0 +1,108 @@
+package goapp
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test exporting only the documents changed since an earlier export
+func TestExportChanges(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{"<doc><title>One</title></doc>", "<doc><title>Two</title></doc>", "<doc><title>Three</title></doc>"} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	export := func(since string) *ArchiveManifest {
+		var archive bytes.Buffer
+		manifest, err := exportChanges(db, &archive, since, time.Now())
+		require.NoError(t, err)
+		read, _, err := readArchive(&archive)
+		require.NoError(t, err)
+		require.Equal(t, manifest, read)
+		return manifest
+	}
+	full := export("")
+	require.False(t, full.Incremental)
+	require.Len(t, full.Documents, 3)
+	cursor := strconv.FormatInt(full.Cursor, 10)
+
+	// Nothing changed yet
+	manifest := export(cursor)
+	require.True(t, manifest.Incremental)
+	require.Empty(t, manifest.Documents)
+	require.Equal(t, full.Cursor, manifest.Cursor)
+
+	require.NoError(t, transitionDocument(db, "2", DOC_STATE_ARCHIVED))
+	require.NoError(t, deleteDocumentByID(db, "1"))
+	doc, err := parseDocument("<doc><title>Four</title></doc>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	manifest = export(cursor)
+	require.Equal(t, full.Cursor, manifest.Since)
+	require.Greater(t, manifest.Cursor, full.Cursor)
+	require.Equal(t, []string{"1"}, manifest.Deleted)
+	require.Len(t, manifest.Documents, 2)
+	require.Equal(t, "2", manifest.Documents[0].ID)
+	require.Equal(t, DOC_STATE_ARCHIVED, manifest.Documents[0].State)
+	require.Equal(t, "Four", manifest.Documents[1].Title)
+
+	// Exporting from the new cursor starts over
+	cursor = strconv.FormatInt(manifest.Cursor, 10)
+	require.Empty(t, export(cursor).Documents)
+
+	// Tagging and untagging are changes of the document, the tags removed with a deleted document aren't
+	_, err = tagDocuments(db, documentSelection{States: []string{DOC_STATE_ACTIVE}, Filter: DB_ID_FIELD_NAME + "=?", FilterArgs: []interface{}{"3"}}, "urgent", TAG_ACTION_ADD, false, time.Now())
+	require.NoError(t, err)
+	manifest = export(cursor)
+	require.Len(t, manifest.Documents, 1)
+	require.Equal(t, "3", manifest.Documents[0].ID)
+	require.Equal(t, []string{"urgent"}, manifest.Documents[0].Tags)
+
+	cursor = strconv.FormatInt(manifest.Cursor, 10)
+	_, err = tagDocuments(db, documentSelection{States: []string{DOC_STATE_ACTIVE}, Filter: DB_ID_FIELD_NAME + "=?", FilterArgs: []interface{}{"3"}}, "urgent", TAG_ACTION_REMOVE, false, time.Now())
+	require.NoError(t, err)
+	manifest = export(cursor)
+	require.Len(t, manifest.Documents, 1)
+	require.Empty(t, manifest.Documents[0].Tags)
+
+	cursor = strconv.FormatInt(manifest.Cursor, 10)
+	require.NoError(t, setDocumentTags(db, "4", []string{"draft"}))
+	since := strconv.FormatInt(export(cursor).Cursor, 10)
+	require.NoError(t, deleteDocumentByID(db, "4"))
+	manifest = export(since)
+	require.Empty(t, manifest.Documents)
+	require.Equal(t, []string{"4"}, manifest.Deleted)
+	var kinds []string
+	rows, err := db.Query("SELECT "+DB_CHANGE_KIND_FIELD_NAME+" FROM "+DB_CHANGE_TABLE_NAME+" WHERE "+DB_CHANGE_SEQ_FIELD_NAME+">?", manifest.Since)
+	require.NoError(t, err)
+	for rows.Next() {
+		var kind string
+		require.NoError(t, rows.Scan(&kind))
+		kinds = append(kinds, kind)
+	}
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{CHANGE_KIND_DELETE}, kinds)
+
+	for _, since := range []string{"x", "-1", strconv.FormatInt(manifest.Cursor+1, 10)} {
+		_, err := exportChanges(db, &bytes.Buffer{}, since, time.Now())
+		require.ErrorIs(t, err, ErrInvalidCursor)
+	}
+
+	// Incremental archives can't be imported
+	var archive bytes.Buffer
+	_, err = exportChanges(db, &archive, cursor, time.Now())
+	require.NoError(t, err)
+	target, cleanupTarget := setupTestDB(t)
+	defer cleanupTarget()
+	_, err = importArchive(target, &archive)
+	require.ErrorContains(t, err, "incremental archive")
+}
@@ -0,0 +1,237 @@
+package goapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	CHAT_CONNECTORS_ENV = "DOC_CHAT_CONNECTORS" // Environment variable with the path of a JSON file listing the Slack and Teams connectors
+
+	CHAT_KIND_SLACK = "slack" // Kind of connectors posting to a Slack incoming webhook
+	CHAT_KIND_TEAMS = "teams" // Kind of connectors posting to a Microsoft Teams incoming webhook
+
+	CHAT_EVENT_INGESTION_FAILURE = "ingestion_failure" // Event of a document which couldn't be ingested
+	CHAT_EVENT_ALERT_FIRING      = "alert_firing"      // Event of an alert which started firing
+	CHAT_EVENT_ALERT_RESOLVED    = "alert_resolved"    // Event of an alert which resolved
+
+	CHAT_COLOR_FAILURE  = "D70000" // Color of messages about failures and firing alerts
+	CHAT_COLOR_RESOLVED = "2EB67D" // Color of messages about resolved alerts
+
+	CHAT_TIMEOUT = 10 * time.Second // Timeout of a request to a chat webhook
+)
+
+// ChatConnector posts the events of the listed types to a Slack or Teams incoming webhook
+type ChatConnector struct {
+	Name   string
+	Kind   string   // Kind is one of the CHAT_KIND_* constants
+	URL    string   // URL is the incoming webhook of the channel
+	Events []string // Events are the CHAT_EVENT_* types posted to the channel
+}
+
+// ChatEvent is a notification posted to chat channels
+type ChatEvent struct {
+	Type  string // Type is one of the CHAT_EVENT_* constants
+	Title string
+	Text  string
+	Facts [][2]string // Facts are name and value pairs shown below the text, like the source of a document
+}
+
+// ChatNotifier posts events to the connectors subscribed to their type
+type ChatNotifier struct {
+	Connectors []ChatConnector
+	Client     *http.Client
+	Breakers   map[string]*CircuitBreaker // Breakers stop posting to a connector for a while when its webhook is unreachable
+}
+
+// chatNotifier posts to the chat connectors of the server, nil unless configured by initChatConnectors
+var chatNotifier *ChatNotifier
+
+// initChatConnectors sets up posting to Slack and Teams if connectors are configured
+func initChatConnectors() {
+	funcName := "initChatConnectors"
+
+	path := os.Getenv(CHAT_CONNECTORS_ENV)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s: %v", funcName, CHAT_CONNECTORS_ENV, err)
+	}
+	connectors, err := parseChatConnectors(data)
+	if err != nil {
+		log.Fatalf("%s: Invalid chat connectors in %s: %v", funcName, path, err)
+	}
+	chatNotifier = newChatNotifier(connectors)
+}
+
+// newChatNotifier creates a notifier posting to the connectors
+func newChatNotifier(connectors []ChatConnector) *ChatNotifier {
+	notifier := &ChatNotifier{
+		Connectors: connectors,
+		Client:     &http.Client{Timeout: CHAT_TIMEOUT},
+		Breakers:   map[string]*CircuitBreaker{},
+	}
+	for _, connector := range connectors {
+		notifier.Breakers[connector.Name] = newCircuitBreaker("chat_" + connector.Name)
+	}
+	return notifier
+}
+
+// parseChatConnectors parses a JSON list of chat connectors and checks them
+func parseChatConnectors(data []byte) ([]ChatConnector, error) {
+	var connectors []ChatConnector
+	if err := json.Unmarshal(data, &connectors); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for i, connector := range connectors {
+		if connector.Name == "" || names[connector.Name] {
+			return nil, fmt.Errorf("connector %d needs a unique name", i+1)
+		}
+		names[connector.Name] = true
+
+		if connector.Kind != CHAT_KIND_SLACK && connector.Kind != CHAT_KIND_TEAMS {
+			return nil, fmt.Errorf("connector %s: unknown kind %q", connector.Name, connector.Kind)
+		}
+		parsed, err := url.Parse(connector.URL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("connector %s: invalid URL %q", connector.Name, connector.URL)
+		}
+		if len(connector.Events) == 0 {
+			return nil, fmt.Errorf("connector %s needs events", connector.Name)
+		}
+		for _, event := range connector.Events {
+			switch event {
+			case CHAT_EVENT_INGESTION_FAILURE, CHAT_EVENT_ALERT_FIRING, CHAT_EVENT_ALERT_RESOLVED:
+			default:
+				return nil, fmt.Errorf("connector %s: unknown event %q", connector.Name, event)
+			}
+		}
+	}
+	return connectors, nil
+}
+
+// subscribed reports whether the connector posts events of the type
+func (connector *ChatConnector) subscribed(eventType string) bool {
+	for _, event := range connector.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Post sends the event to the connectors subscribed to its type in the background
+func (notifier *ChatNotifier) Post(event ChatEvent) {
+	for _, connector := range notifier.Connectors {
+		if !connector.subscribed(event.Type) {
+			continue
+		}
+		connector := connector
+		go func() {
+			err := notifier.Breakers[connector.Name].Call(func() error {
+				return notifier.send(connector, event)
+			})
+			if err != nil {
+				log.Printf("ChatNotifier: Failed to post to %s: %v", connector.Name, err)
+			}
+		}()
+	}
+}
+
+// send posts the event to the webhook of the connector, formatted for its kind
+func (notifier *ChatNotifier) send(connector ChatConnector, event ChatEvent) error {
+	var payload map[string]interface{}
+	if connector.Kind == CHAT_KIND_TEAMS {
+		payload = teamsMessage(event)
+	} else {
+		payload = slackMessage(event)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifier.Client.Post(connector.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// color returns the hex color of the event without '#'
+func (event ChatEvent) color() string {
+	if event.Type == CHAT_EVENT_ALERT_RESOLVED {
+		return CHAT_COLOR_RESOLVED
+	}
+	return CHAT_COLOR_FAILURE
+}
+
+// slackMessage formats the event as a Slack message with a colored attachment
+func slackMessage(event ChatEvent) map[string]interface{} {
+	fields := []map[string]interface{}{}
+	for _, fact := range event.Facts {
+		fields = append(fields, map[string]interface{}{"title": fact[0], "value": fact[1], "short": true})
+	}
+	return map[string]interface{}{
+		"text": event.Title,
+		"attachments": []map[string]interface{}{{
+			"color":  "#" + event.color(),
+			"title":  event.Title,
+			"text":   event.Text,
+			"fields": fields,
+		}},
+	}
+}
+
+// teamsMessage formats the event as a Teams message card
+func teamsMessage(event ChatEvent) map[string]interface{} {
+	facts := []map[string]string{}
+	for _, fact := range event.Facts {
+		facts = append(facts, map[string]string{"name": fact[0], "value": fact[1]})
+	}
+	return map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"themeColor": event.color(),
+		"summary":    event.Title,
+		"title":      event.Title,
+		"text":       event.Text,
+		"sections":   []map[string]interface{}{{"facts": facts}},
+	}
+}
+
+// postChat sends the event to the chat connectors, if any are configured
+func postChat(event ChatEvent) {
+	if chatNotifier != nil {
+		chatNotifier.Post(event)
+	}
+}
+
+// alertChatEvent describes an alert for chat channels
+func alertChatEvent(alert Alert) ChatEvent {
+	eventType := CHAT_EVENT_ALERT_FIRING
+	if alert.Status == ALERT_STATUS_RESOLVED {
+		eventType = CHAT_EVENT_ALERT_RESOLVED
+	}
+	return ChatEvent{
+		Type:  eventType,
+		Title: fmt.Sprintf("Alert %s is %s for %s", alert.Rule, alert.Status, alert.Source),
+		Text:  alert.Message,
+		Facts: [][2]string{{"Rule", alert.Rule}, {"Source", alert.Source}, {"At", alert.At}},
+	}
+}
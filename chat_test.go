@@ -0,0 +1,128 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing and checking chat connectors
+func TestParseChatConnectors(t *testing.T) {
+	connectors, err := parseChatConnectors([]byte(`[
+		{"Name": "ops", "Kind": "slack", "URL": "https://hooks.slack.com/services/T0/B0/x", "Events": ["ingestion_failure", "alert_firing"]},
+		{"Name": "team", "Kind": "teams", "URL": "https://example.webhook.office.com/webhookb2/x", "Events": ["alert_firing", "alert_resolved"]}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, connectors, 2)
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{desc: "missing name", data: `[{"Kind": "slack", "URL": "https://x", "Events": ["alert_firing"]}]`, expected: "connector 1 needs a unique name"},
+		{desc: "unknown kind", data: `[{"Name": "a", "Kind": "irc", "URL": "https://x", "Events": ["alert_firing"]}]`, expected: `connector a: unknown kind "irc"`},
+		{desc: "invalid URL", data: `[{"Name": "a", "Kind": "slack", "URL": "hooks.slack.com", "Events": ["alert_firing"]}]`, expected: `connector a: invalid URL "hooks.slack.com"`},
+		{desc: "no events", data: `[{"Name": "a", "Kind": "slack", "URL": "https://x"}]`, expected: "connector a needs events"},
+		{desc: "unknown event", data: `[{"Name": "a", "Kind": "slack", "URL": "https://x", "Events": ["deploy"]}]`, expected: `connector a: unknown event "deploy"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseChatConnectors([]byte(tt.data))
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+// Test formatting events for Slack and Teams
+func TestChatMessages(t *testing.T) {
+	event := alertChatEvent(Alert{Rule: "feed", Source: "dir:./feed", Status: ALERT_STATUS_RESOLVED, Message: "Ingestion resumed", At: "2024-05-01T12:00:00Z"})
+	require.Equal(t, CHAT_EVENT_ALERT_RESOLVED, event.Type)
+
+	slack, err := json.Marshal(slackMessage(event))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"text": "Alert feed is resolved for dir:./feed",
+		"attachments": [{
+			"color": "#2EB67D",
+			"title": "Alert feed is resolved for dir:./feed",
+			"text": "Ingestion resumed",
+			"fields": [
+				{"title": "Rule", "value": "feed", "short": true},
+				{"title": "Source", "value": "dir:./feed", "short": true},
+				{"title": "At", "value": "2024-05-01T12:00:00Z", "short": true}
+			]
+		}]
+	}`, string(slack))
+
+	teams, err := json.Marshal(teamsMessage(event))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"@type": "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"themeColor": "2EB67D",
+		"summary": "Alert feed is resolved for dir:./feed",
+		"title": "Alert feed is resolved for dir:./feed",
+		"text": "Ingestion resumed",
+		"sections": [{"facts": [
+			{"name": "Rule", "value": "feed"},
+			{"name": "Source", "value": "dir:./feed"},
+			{"name": "At", "value": "2024-05-01T12:00:00Z"}
+		]}]
+	}`, string(teams))
+}
+
+// Test posting events only to the connectors subscribed to their type
+func TestChatNotifierPost(t *testing.T) {
+	posted := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var message map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+		if _, ok := message["@type"]; ok {
+			posted <- r.URL.Path + " " + message["title"].(string)
+		} else {
+			posted <- r.URL.Path + " " + message["text"].(string)
+		}
+	}))
+	defer server.Close()
+
+	defer func(previous *ChatNotifier) { chatNotifier = previous }(chatNotifier)
+	chatNotifier = newChatNotifier([]ChatConnector{
+		{Name: "ops", Kind: CHAT_KIND_SLACK, URL: server.URL + "/slack", Events: []string{CHAT_EVENT_INGESTION_FAILURE}},
+		{Name: "team", Kind: CHAT_KIND_TEAMS, URL: server.URL + "/teams", Events: []string{CHAT_EVENT_ALERT_FIRING}},
+	})
+
+	reportIngestionFailure("dir:./feed", errors.New("tag pairing error at line 1, column 7"))
+	select {
+	case message := <-posted:
+		require.Equal(t, "/slack Failed to ingest a document from dir:./feed", message)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the ingestion failure wasn't posted")
+	}
+
+	postChat(alertChatEvent(Alert{Rule: "feed", Source: "dir:./feed", Status: ALERT_STATUS_FIRING}))
+	select {
+	case message := <-posted:
+		require.Equal(t, "/teams Alert feed is firing for dir:./feed", message)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the alert wasn't posted")
+	}
+
+	// Resolved alerts go to no connector
+	postChat(alertChatEvent(Alert{Rule: "feed", Source: "dir:./feed", Status: ALERT_STATUS_RESOLVED}))
+	select {
+	case message := <-posted:
+		t.Fatalf("unexpected post %s", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Failed posts are reported
+	server.Close()
+	require.Error(t, chatNotifier.send(chatNotifier.Connectors[0], ChatEvent{Type: CHAT_EVENT_INGESTION_FAILURE}))
+}
@@ -0,0 +1,101 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CheckResponse is the response of /check
+type CheckResponse struct {
+	Valid  bool
+	Errors []ParseError // Errors are the well-formedness errors with their positions, empty for valid documents
+}
+
+// checkRepairMessages rewords the repairs of repairXML as the errors they fix
+var checkRepairMessages = map[string]string{
+	"skipped unmatched closing tag ": "unmatched closing tag ",
+	"auto-closed tag ":               "unclosed tag ",
+}
+
+// checkDocument parses data like /add without storing it, and reports whether it is well-formed
+// The parser stops at the first error. If that is a tag pairing error, all tags which don't pair up are
+// reported, in the order of their positions. Errors without a position, like an empty document, are returned.
+func checkDocument(data string, options ParseOptions) (CheckResponse, error) {
+	_, err := parseDocumentWithOptions(data, options)
+	if err == nil {
+		return CheckResponse{Valid: true, Errors: []ParseError{}}, nil
+	}
+	var parseError *ParseError
+	if !errors.As(err, &parseError) {
+		return CheckResponse{}, err
+	}
+
+	response := CheckResponse{Errors: []ParseError{*parseError}}
+	if !strings.HasPrefix(parseError.Msg, "no opening tag error") && !strings.HasPrefix(parseError.Msg, "unmatched closing tag error") {
+		return response, nil
+	}
+	// The positions of the repairs are in the data as it was parsed
+	if options.Fragment {
+		data = wrapFragment(data)
+	}
+	_, repairs, err := repairXML(data)
+	if err != nil || len(repairs) == 0 {
+		return response, nil
+	}
+	for i, repair := range repairs {
+		for prefix, msg := range checkRepairMessages {
+			if strings.HasPrefix(repair.Msg, prefix) {
+				repairs[i].Msg = msg + strings.TrimPrefix(repair.Msg, prefix)
+			}
+		}
+	}
+	sort.SliceStable(repairs, func(i, j int) bool {
+		return repairs[i].Line < repairs[j].Line || repairs[i].Line == repairs[j].Line && repairs[i].Col < repairs[j].Col
+	})
+	response.Errors = repairs
+	return response, nil
+}
+
+// Function to handle /check requests, which report whether the XML of the body is well-formed without storing it
+func handleCheckRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var options ParseOptions
+	if param := r.URL.Query().Get("fragment"); param != "" {
+		var err error
+		options.Fragment, err = strconv.ParseBool(param)
+		if err != nil {
+			http.Error(w, "fragment must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	xmlData, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	response, err := checkDocument(string(xmlData), options)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check document: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
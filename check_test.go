@@ -0,0 +1,76 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test checking documents for well-formedness without storing them
+func TestHandleCheckRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	check := func(query string, data string) (int, CheckResponse) {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("POST", "/check"+query, strings.NewReader(data)))
+		var response CheckResponse
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		}
+		return rr.Code, response
+	}
+
+	tests := []struct {
+		desc     string
+		query    string
+		data     string
+		expected CheckResponse
+	}{
+		{
+			desc:     "well-formed",
+			data:     "<doc><title>Fine</title></doc>",
+			expected: CheckResponse{Valid: true, Errors: []ParseError{}},
+		}, {
+			desc: "tags which don't pair up",
+			data: "<doc>\n<title>Broken</section>\n<p>Open\n</doc>",
+			expected: CheckResponse{Errors: []ParseError{
+				{Line: 2, Col: 1, Msg: "unclosed tag <title>", Snippet: "<title>Broken</section>"},
+				{Line: 2, Col: 14, Msg: "unmatched closing tag </section>", Snippet: "<title>Broken</section>"},
+				{Line: 3, Col: 1, Msg: "unclosed tag <p>", Snippet: "<p>Open"},
+			}},
+		}, {
+			desc: "unterminated comment",
+			data: "<doc><!-- open</doc>",
+			expected: CheckResponse{Errors: []ParseError{
+				{Line: 1, Col: 6, Msg: "unterminated CDATA section, comment or processing instruction", Snippet: "<doc><!-- open</doc>"},
+			}},
+		}, {
+			desc:     "fragment",
+			query:    "?fragment=true",
+			data:     "<title>a</title><author>b</author>",
+			expected: CheckResponse{Valid: true, Errors: []ParseError{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			code, response := check(tt.query, tt.data)
+			require.Equal(t, http.StatusOK, code)
+			require.Equal(t, tt.expected, response)
+		})
+	}
+
+	code, _ := check("", "")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = check("?fragment=maybe", "<doc/>")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// Nothing is stored
+	ids, err := listDocumentIDs(db, documentStates, false)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/leon22129/goapp"
+)
+
+func main() {
+	config := goapp.Config{}
+
+	// Run a subcommand such as export or import instead of the server
+	if len(os.Args) > 1 {
+		if err := goapp.RunCommand(config, os.Args[1:]); err != nil {
+			log.Fatalf("main: %v", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := goapp.RunServer(ctx, config); err != nil {
+		log.Fatalf("main: %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package goapp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	COLD_STORAGE_ENV = "DOC_COLD_STORAGE"    // Environment variable with the directory or s3://bucket/prefix the XML of idle documents is moved to
+	COLD_AFTER_ENV   = "DOC_COLD_AFTER_DAYS" // Environment variable with the days a document stays untouched before it is moved
+
+	COLD_DEFAULT_AFTER_DAYS = 90         // Days a document stays untouched before it is moved by default
+	COLD_INTERVAL           = time.Hour  // Interval between two runs of the tiering job
+	COLD_BATCH_SIZE         = 500        // Most documents moved per run, so a run doesn't hold the database for long
+	COLD_KEY_EXTENSION      = ".json.gz" // Extension of the files and objects holding the XML of a document
+	COLD_S3_SCHEME          = "s3://"    // Scheme of cold storage in S3, which uses the endpoint and credentials of snapshots
+	TIER_COLD               = "cold"     // Tier of documents whose XML is in cold storage
+	TIER_HOT                = "hot"      // Tier of documents whose XML is in the table, stored as NULL
+
+	DB_TIER_FIELD_NAME       = "tier"        // Field name for tier (TIER_COLD, NULL for documents stored in the table) in SQLite table
+	DB_ACCESSEDAT_FIELD_NAME = "accessed_at" // Field name for the time a document was last brought back from cold storage in SQLite table
+)
+
+// ColdStore keeps the XML of documents which haven't been touched for a while, outside of the database
+type ColdStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+}
+
+// dirColdStore keeps cold documents as files in a directory, e.g. on a cheaper disk
+type dirColdStore string
+
+func (dir dirColdStore) Put(key string, data []byte) error {
+	path := filepath.Join(string(dir), key)
+	// Files are written aside and renamed so a failed write never replaces a good copy
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (dir dirColdStore) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(dir), key))
+}
+
+// s3ColdStore keeps cold documents as objects under a prefix of an S3 bucket
+type s3ColdStore struct {
+	Client *S3Client
+	Prefix string
+}
+
+func (store s3ColdStore) Put(key string, data []byte) error {
+	return store.Client.PutObject(store.Prefix+key, data)
+}
+
+func (store s3ColdStore) Get(key string) ([]byte, error) {
+	return store.Client.GetObject(store.Prefix + key)
+}
+
+// coldStore is where the XML of untouched documents is moved, nil unless configured by initColdStorage
+var coldStore ColdStore
+
+// coldAfter is the time a document stays untouched before it is moved to coldStore
+var coldAfter = COLD_DEFAULT_AFTER_DAYS * 24 * time.Hour
+
+// initColdStorage sets up cold storage if a directory or S3 bucket is configured
+func initColdStorage() {
+	funcName := "initColdStorage"
+
+	location := os.Getenv(COLD_STORAGE_ENV)
+	if location == "" {
+		return
+	}
+	if value := os.Getenv(COLD_AFTER_ENV); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			log.Fatalf("%s: %s must be a positive number of days", funcName, COLD_AFTER_ENV)
+		}
+		coldAfter = time.Duration(days) * 24 * time.Hour
+	}
+
+	if !strings.HasPrefix(location, COLD_S3_SCHEME) {
+		if err := os.MkdirAll(location, 0755); err != nil {
+			log.Fatalf("%s: Failed to create %s: %v", funcName, location, err)
+		}
+		coldStore = dirColdStore(location)
+		return
+	}
+
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, COLD_S3_SCHEME), "/")
+	endpoint := os.Getenv(SNAPSHOT_ENDPOINT_ENV)
+	accessKey, secretKey := os.Getenv(SNAPSHOT_ACCESS_KEY_ENV), os.Getenv(SNAPSHOT_SECRET_KEY_ENV)
+	if bucket == "" || endpoint == "" || accessKey == "" || secretKey == "" {
+		log.Fatalf("%s: %s needs a bucket, %s, %s and %s", funcName, COLD_STORAGE_ENV, SNAPSHOT_ENDPOINT_ENV, SNAPSHOT_ACCESS_KEY_ENV, SNAPSHOT_SECRET_KEY_ENV)
+	}
+	region := os.Getenv(SNAPSHOT_REGION_ENV)
+	if region == "" {
+		region = SNAPSHOT_DEFAULT_REGION
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	coldStore = s3ColdStore{Client: newS3Client(endpoint, region, bucket, accessKey, secretKey), Prefix: prefix}
+}
+
+// coldData is the XML of a document in cold storage, as stored in its columns
+type coldData struct {
+	XMLData string
+	Tree    string
+}
+
+// coldKey returns the key of the XML of document id in cold storage
+func coldKey(id string) string {
+	return id + COLD_KEY_EXTENSION
+}
+
+// moveToColdStorage moves the XML of up to COLD_BATCH_SIZE documents untouched since now minus after to store
+// Documents are untouched if neither the change feed nor a read from cold storage saw them since then; documents
+// last changed before the feed started count as untouched. Their metadata stays in the table.
+// It returns the number of moved documents
+func moveToColdStorage(db *sql.DB, store ColdStore, now time.Time, after time.Duration) (int, error) {
+	defer observeQuery("moveToColdStorage", time.Now())
+
+	cutoff := formatExpiry(now.Add(-after))
+	query := fmt.Sprintf(`
+		SELECT %s, %s, COALESCE(%s, '') FROM %s
+		WHERE %s IS NULL AND %s!='' AND (%s IS NULL OR %s<=?)
+		AND NOT EXISTS (SELECT 1 FROM %s WHERE %s=%s.%s AND %s>?)
+		ORDER BY %s LIMIT %d
+	`, DB_ID_FIELD_NAME, DB_XMLDATA_FIELD_NAME, DB_TREE_FIELD_NAME, DB_TABLE_NAME,
+		DB_TIER_FIELD_NAME, DB_XMLDATA_FIELD_NAME, DB_ACCESSEDAT_FIELD_NAME, DB_ACCESSEDAT_FIELD_NAME,
+		DB_CHANGE_TABLE_NAME, DB_CHANGE_DOCUMENT_FIELD_NAME, DB_TABLE_NAME, DB_ID_FIELD_NAME, DB_CHANGE_TIME_FIELD_NAME,
+		DB_ID_FIELD_NAME, COLD_BATCH_SIZE)
+	type idleDocument struct {
+		ID   string
+		Data coldData
+	}
+	var idle []idleDocument
+	err := withDBRetry(func() error {
+		rows, err := db.Query(query, cutoff, cutoff)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		idle = nil
+		for rows.Next() {
+			var doc idleDocument
+			if err := rows.Scan(&doc.ID, &doc.Data.XMLData, &doc.Data.Tree); err != nil {
+				return err
+			}
+			idle = append(idle, doc)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// The XML is only removed from the table if it is still what was stored, in case the document changed meanwhile
+	update := fmt.Sprintf(`
+		UPDATE %s SET %s='', %s=NULL, %s=?, %s=? WHERE %s=? AND %s IS NULL AND %s=?
+	`, DB_TABLE_NAME, DB_XMLDATA_FIELD_NAME, DB_TREE_FIELD_NAME, DB_TIER_FIELD_NAME, DB_COLDBYTES_FIELD_NAME, DB_ID_FIELD_NAME, DB_TIER_FIELD_NAME, DB_XMLDATA_FIELD_NAME)
+	moved := 0
+	for _, doc := range idle {
+		data, err := encodeColdData(doc.Data)
+		if err != nil {
+			return moved, fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+		if err := store.Put(coldKey(doc.ID), data); err != nil {
+			return moved, fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+		var count int64
+		err = withDBRetry(func() error {
+			result, err := db.Exec(update, TIER_COLD, len(data), doc.ID, doc.Data.XMLData)
+			if err != nil {
+				return err
+			}
+			count, err = result.RowsAffected()
+			return err
+		})
+		if err != nil {
+			return moved, fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+		moved += int(count)
+	}
+	return moved, nil
+}
+
+// encodeColdData encodes and compresses the XML of a document for cold storage
+func encodeColdData(data coldData) ([]byte, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(encoded); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// loadColdData reads the XML of a document in the cold tier back from cold storage, leaving it there
+func (doc *XMLDoc) loadColdData() error {
+	if coldStore == nil {
+		return fmt.Errorf("document %s is in cold storage, which isn't configured", doc.ID)
+	}
+	compressed, err := coldStore.Get(coldKey(doc.ID))
+	if err != nil {
+		return fmt.Errorf("failed to read document %s from cold storage: %w", doc.ID, err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	encoded, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return err
+	}
+	var data coldData
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return err
+	}
+
+	doc.XMLData = strings.Split(data.XMLData, SPLIT_XMLDATA_STR)
+	doc.Tree, err = decodeTree(data.Tree)
+	return err
+}
+
+// rehydrateDocument moves the XML of a cold document loaded with loadColdData back into the table, so the
+// following reads are fast again
+// The document counts as touched at now, so it stays in the table for the idle time of cold storage.
+func rehydrateDocument(db *sql.DB, doc *XMLDoc, now time.Time) error {
+	defer observeQuery("rehydrateDocument", time.Now())
+
+	tree, err := encodeTree(doc.Tree)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		UPDATE %s SET %s=?, %s=?, %s=NULL, %s=? WHERE %s=? AND %s=?
+	`, DB_TABLE_NAME, DB_XMLDATA_FIELD_NAME, DB_TREE_FIELD_NAME, DB_TIER_FIELD_NAME, DB_ACCESSEDAT_FIELD_NAME, DB_ID_FIELD_NAME, DB_TIER_FIELD_NAME)
+	err = withDBRetry(func() error {
+		_, err := db.Exec(query, strings.Join(doc.XMLData, SPLIT_XMLDATA_STR), tree, formatExpiry(now), doc.ID, TIER_COLD)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	doc.Tier = ""
+	return nil
+}
+
+// runTiering moves untouched documents to cold storage every interval while this instance leads the tiering job
+// It never returns
+func runTiering(db *sql.DB, store ColdStore, interval time.Duration) {
+	funcName := "runTiering"
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if !leaderElector.Lead(db, JOB_TIERER, now, 2*interval) {
+			continue
+		}
+
+		count, err := moveToColdStorage(db, store, now, coldAfter)
+		if count > 0 {
+			log.Printf("%s: Moved %d documents to cold storage", funcName, count)
+		}
+		if err != nil {
+			log.Printf("%s: Failed to move documents to cold storage: %v", funcName, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test moving idle documents to cold storage and reading them back
+func TestMoveToColdStorage(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	coldStore = dirColdStore(dir)
+	defer func() { coldStore = nil }()
+
+	for _, data := range []string{
+		`<document><title>Minutes</title><body>Approved</body></document>`,
+		`<document><title>Agenda</title></document>`,
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	// Documents just added are touched
+	now := time.Now()
+	moved, err := moveToColdStorage(db, coldStore, now, 24*time.Hour)
+	require.NoError(t, err)
+	require.Zero(t, moved)
+
+	later := now.Add(48 * time.Hour)
+	moved, err = moveToColdStorage(db, coldStore, later, 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 2, moved)
+	_, err = os.Stat(filepath.Join(dir, coldKey("1")))
+	require.NoError(t, err)
+	var xmlData string
+	require.NoError(t, db.QueryRow("SELECT "+DB_XMLDATA_FIELD_NAME+" FROM "+DB_TABLE_NAME+" WHERE "+DB_ID_FIELD_NAME+"=1").Scan(&xmlData))
+	require.Empty(t, xmlData)
+
+	// Cold documents are read transparently, moving to the cold tier isn't a change of the document
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, TIER_COLD, doc.Tier)
+	require.Equal(t, "Minutes", doc.Title)
+	require.Contains(t, doc.rawXML(), "<body>Approved</body>")
+	require.NotNil(t, doc.Tree)
+	ids, err := changedDocumentIDs(db, 2, 100)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/document?id=2", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), "Agenda")
+
+	// Reading a document through the API moves it back, and it stays until it is idle again
+	doc, err = getDocumentByID(db, "2")
+	require.NoError(t, err)
+	require.Empty(t, doc.Tier)
+	require.Equal(t, "Agenda", doc.Title)
+	_, err = getPublishedDocumentByID(db, "1", later)
+	require.NoError(t, err)
+	moved, err = moveToColdStorage(db, coldStore, later, 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 1, moved)
+	doc, err = getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Empty(t, doc.Tier)
+
+	// Without cold storage the documents left there can't be read
+	coldStore = nil
+	_, err = getDocumentByID(db, "2")
+	require.Error(t, err)
+}
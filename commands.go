@@ -0,0 +1,257 @@
+package goapp
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// runCommand runs a command line subcommand like `goapp export --out archive.tar.gz` instead of the server
+func runCommand(db *sql.DB, args []string) error {
+	switch args[0] {
+	case "export":
+		return runExportCommand(db, args[1:])
+	case "import":
+		return runImportCommand(db, args[1:])
+	case "migrate":
+		return runMigrateCommand(db, args[1:])
+	case "snapshot":
+		return runSnapshotCommand(db, args[1:])
+	case "snapshots":
+		return runListSnapshotsCommand(args[1:])
+	case "restore":
+		return runRestoreCommand(args[1:])
+	case "format":
+		return runFormatCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command %s", args[0])
+}
+
+// runExportCommand writes all documents to the archive given by --out, or those changed since the cursor given by --since
+func runExportCommand(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := flags.String("out", "", "path of the archive to write (.tar.gz)")
+	since := flags.String("since", "", "cursor printed by an earlier export to only export the changes after it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("export: --out is required")
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	manifest, err := exportChanges(db, file, *since, time.Now())
+	if err != nil {
+		file.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if manifest.Incremental {
+		fmt.Printf("Exported %d changed and %d deleted documents to %s\n", len(manifest.Documents), len(manifest.Deleted), *out)
+	} else {
+		fmt.Printf("Exported %d documents to %s\n", len(manifest.Documents), *out)
+	}
+	fmt.Printf("Cursor: %d\n", manifest.Cursor)
+	return nil
+}
+
+// runImportCommand restores the documents of the archive given by --in
+func runImportCommand(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	in := flags.String("in", "", "path of the archive to read (.tar.gz)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("import: --in is required")
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	count, err := importArchive(db, file)
+	if err != nil {
+		return fmt.Errorf("imported %d documents before failing: %w", count, err)
+	}
+
+	fmt.Printf("Imported %d documents from %s\n", count, *in)
+	return nil
+}
+
+// runMigrateCommand imports documents from the foreign SQLite table or CSV given by --sqlite and --table or --csv
+func runMigrateCommand(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	sqlitePath := flags.String("sqlite", "", "path of a foreign SQLite database")
+	table := flags.String("table", "", "table of the foreign SQLite database")
+	csvPath := flags.String("csv", "", "path of a CSV file with a header line")
+	mapping := flags.String("columns", FOREIGN_COLUMN_XML+"="+FOREIGN_COLUMN_XML, "column mapping like xml=body,id=doc_id,state=status")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	columns, err := parseColumnMapping(*mapping)
+	if err != nil {
+		return err
+	}
+
+	var rows []foreignRow
+	var source string
+	switch {
+	case *sqlitePath != "" && *csvPath == "":
+		if *table == "" {
+			return errors.New("migrate: --table is required with --sqlite")
+		}
+		foreign, err := sql.Open("sqlite3", "file:"+*sqlitePath+"?mode=ro")
+		if err != nil {
+			return err
+		}
+		defer foreign.Close()
+
+		source = "sqlite:" + *sqlitePath
+		rows, err = readSQLiteRows(foreign, *table, columns)
+		if err != nil {
+			return err
+		}
+	case *csvPath != "" && *sqlitePath == "":
+		file, err := os.Open(*csvPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		source = "csv:" + *csvPath
+		rows, err = readCSVRows(file, columns)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("migrate: either --sqlite or --csv is required")
+	}
+
+	report := migrateRows(db, rows, source)
+	fmt.Printf("Migrated %d documents from %s, %d rows skipped\n", report.Imported, source, report.Failed)
+	return nil
+}
+
+// errSnapshotsDisabled is returned by snapshot commands when no S3 bucket is configured
+var errSnapshotsDisabled = fmt.Errorf("snapshots need %s to be set", SNAPSHOT_BUCKET_ENV)
+
+// runSnapshotCommand uploads a snapshot of the database right away
+func runSnapshotCommand(db *sql.DB, args []string) error {
+	if snapshotter == nil {
+		return errSnapshotsDisabled
+	}
+
+	key, err := snapshotter.Snapshot(db, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded snapshot %s\n", key)
+	return nil
+}
+
+// runListSnapshotsCommand prints the keys of all snapshots, oldest first
+func runListSnapshotsCommand(args []string) error {
+	if snapshotter == nil {
+		return errSnapshotsDisabled
+	}
+
+	keys, err := snapshotter.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	return nil
+}
+
+// runRestoreCommand writes the latest snapshot taken at or before --at to --out
+func runRestoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	at := flags.String("at", "", "restore the state at this time (RFC 3339), defaults to the latest snapshot")
+	out := flags.String("out", "", "path of the restored database, which must not exist yet")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("restore: --out is required")
+	}
+	if snapshotter == nil {
+		return errSnapshotsDisabled
+	}
+
+	target := time.Now()
+	if *at != "" {
+		parsed, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("restore: invalid --at: %w", err)
+		}
+		target = parsed
+	}
+
+	key, err := snapshotter.FindSnapshot(target)
+	if err != nil {
+		return err
+	}
+	err = snapshotter.Restore(key, *out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored snapshot %s to %s\n", key, *out)
+	return nil
+}
+
+// runFormatCommand indents or, with --minify, minifies the XML of --in or stdin and writes it to --out or stdout
+func runFormatCommand(args []string) error {
+	flags := flag.NewFlagSet("format", flag.ContinueOnError)
+	in := flags.String("in", "", "path of the XML to format (default: stdin)")
+	out := flags.String("out", "", "path to write the result to (default: stdout)")
+	indentParam := flags.String("indent", "2", "spaces per level or tab")
+	minify := flags.Bool("minify", false, "remove comments and whitespace between markup instead of indenting")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	indent, err := parseIndent(*indentParam)
+	if err != nil {
+		return fmt.Errorf("format: %v", err)
+	}
+
+	var data []byte
+	if *in == "" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(*in)
+	}
+	if err != nil {
+		return err
+	}
+
+	var result string
+	if *minify {
+		result, err = MinifyXML(string(data))
+	} else {
+		result, err = FormatXML(string(data), indent)
+	}
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.WriteString(result)
+		return err
+	}
+	return ioutil.WriteFile(*out, []byte(result), 0644)
+}
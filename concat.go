@@ -0,0 +1,103 @@
+package goapp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitDocuments splits data holding several documents back to back, like a log file appending a document per
+// entry, into the documents, each with its prolog
+// Comments and processing instructions after the last root element stay with the last document. Malformed markup
+// ends the splitting, the rest is left to the parser as one document.
+func splitDocuments(data string) []string {
+	var documents []string
+	for data = strings.TrimLeft(data, XML_WHITESPACE); data != ""; data = strings.TrimLeft(data, XML_WHITESPACE) {
+		prolog, rest := splitProlog(data)
+		end, next := rootEnd(rest), ""
+		if end >= 0 {
+			_, next = splitProlog(rest[end:])
+		}
+		// The last document keeps anything after its root element
+		if next == "" {
+			documents = append(documents, data)
+			break
+		}
+		end += len(prolog)
+		documents = append(documents, data[:end])
+		data = data[end:]
+	}
+	return documents
+}
+
+// rootEnd returns the index after the element data starts with, -1 if it isn't closed
+func rootEnd(data string) int {
+	depth := 0
+	for i := 0; i < len(data); {
+		start := strings.IndexByte(data[i:], '<')
+		if start < 0 {
+			return -1
+		}
+		start += i
+
+		if end := sectionEnd(data, start); end != 0 {
+			if end < 0 {
+				return -1
+			}
+			i = end
+			continue
+		}
+		end := tagEnd(data, start, "<>")
+		if end < 0 || data[end] == '<' {
+			return -1
+		}
+		tag := data[start : end+1]
+		switch {
+		case strings.HasPrefix(tag, "</"):
+			depth--
+		case !strings.HasSuffix(tag, "/>"):
+			depth++
+		}
+		if depth <= 0 {
+			return end + 1
+		}
+		i = end + 1
+	}
+	return -1
+}
+
+// ParseAll parses data holding several documents back to back into one XMLDoc each, in order
+// A single document gives one XMLDoc, like parsing it alone. Errors tell which document failed, counting from 1.
+func ParseAll(data string, options ParseOptions) ([]*XMLDoc, error) {
+	return parseAll(data, func(data string) (*XMLDoc, error) {
+		return parseDocumentWithOptions(data, options)
+	})
+}
+
+// parseAllFrom parses the documents of data like ParseAll, each like parseDocumentFrom
+func parseAllFrom(data string, source string) ([]*XMLDoc, error) {
+	return parseAll(data, func(data string) (*XMLDoc, error) {
+		return parseDocumentFrom(data, source)
+	})
+}
+
+// parseAll parses each document of data with parse
+func parseAll(data string, parse func(string) (*XMLDoc, error)) ([]*XMLDoc, error) {
+	documents := splitDocuments(data)
+	if len(documents) <= 1 {
+		doc, err := parse(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*XMLDoc{doc}, nil
+	}
+
+	docs := make([]*XMLDoc, 0, len(documents))
+	for i, document := range documents {
+		doc, err := parse(document)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i+1, err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
@@ -0,0 +1,62 @@
+package goapp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test splitting data holding several documents back to back
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected []string
+	}{
+		{desc: "single document", data: "<a><b/></a>\n", expected: []string{"<a><b/></a>\n"}},
+		{
+			desc:     "documents with prologs",
+			data:     "<?xml version=\"1.0\"?>\n<log><title>One</title></log>\n<?xml version=\"1.0\"?>\n<!DOCTYPE log>\n<log><title>Two</title></log>\n",
+			expected: []string{"<?xml version=\"1.0\"?>\n<log><title>One</title></log>", "<?xml version=\"1.0\"?>\n<!DOCTYPE log>\n<log><title>Two</title></log>\n"},
+		},
+		{desc: "empty roots", data: `<a x="/>"/><b><!-- </b> --></b><c/>`, expected: []string{`<a x="/>"/>`, `<b><!-- </b> --></b>`, "<c/>"}},
+		{desc: "trailing comment", data: "<a/><b/><!-- end -->", expected: []string{"<a/>", "<b/><!-- end -->"}},
+		{desc: "malformed", data: "<a/><b><c></b>", expected: []string{"<a/>", "<b><c></b>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.expected, splitDocuments(tt.data))
+		})
+	}
+}
+
+// Test parsing several documents from one input, and loading files holding them as a row each
+func TestParseAll(t *testing.T) {
+	data := "<?xml version=\"1.0\"?>\n<entry><title>First</title></entry>\n<?xml version=\"1.0\"?>\n<entry><title>Second</title></entry>\n"
+	docs, err := ParseAll(data, ParseOptions{})
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+	require.Equal(t, "First", docs[0].Title)
+	require.Equal(t, "Second", docs[1].Title)
+	require.Equal(t, "<entry><title>Second</title></entry>", docs[1].XMLData[0])
+
+	docs, err = ParseAll("<entry><title>Only</title></entry>", ParseOptions{})
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+
+	_, err = ParseAll("<entry/>\n<entry><title>Broken</entry>", ParseOptions{})
+	require.EqualError(t, err, "document 2: unmatched closing tag error: <title> </entry> at line 1, column 21")
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "log.xml"), []byte(data), 0644))
+	require.NoError(t, loadXMLFiles(db, dir))
+	for id, title := range map[string]string{"1": "First", "2": "Second"} {
+		doc, err := getDocumentByID(db, id)
+		require.NoError(t, err)
+		require.Equal(t, title, doc.Title)
+	}
+}
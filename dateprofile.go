@@ -0,0 +1,148 @@
+package goapp
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DATE_PROFILES_ENV = "DOC_DATE_PROFILES" // Environment variable with custom profiles like "acme=2006.01.02|02 Jan 06;globex=01-02-2006"
+	DATE_SOURCES_ENV  = "DOC_DATE_SOURCES"  // Environment variable mapping source prefixes to profiles like "http:10.0.0.5=de,file:=fr"
+
+	DATE_LAYOUT     = "2006-01-02"          // Stored format of creation dates without time
+	DATETIME_LAYOUT = "2006-01-02T15:04:05" // Stored format of creation dates with time but without offset
+)
+
+// DateProfile describes how a supplier writes creation dates
+type DateProfile struct {
+	Layouts []string // Layouts are tried in order with time.Parse
+	Months  []string // Months holds localized month names from January to December, translated before parsing
+}
+
+// dateProfiles holds the profiles by name, custom profiles are added by initDateProfiles
+var dateProfiles = map[string]DateProfile{
+	"de": {Layouts: []string{"02.01.2006", "2.1.2006", "02.01.2006 15:04", "02.01.2006 15:04:05"}},
+	"us": {Layouts: []string{"01/02/2006", "1/2/2006", "Jan 2, 2006", "January 2, 2006", "01/02/2006 3:04 PM"}},
+	"uk": {Layouts: []string{"02/01/2006", "2/1/2006", "2 Jan 2006", "2 January 2006"}},
+	"fr": {
+		Layouts: []string{"02/01/2006", "2/1/2006", "2 January 2006", "02/01/2006 15:04"},
+		Months:  []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	},
+}
+
+// dateSources maps source prefixes to the name of their profile, set by initDateProfiles
+var dateSources = map[string]string{}
+
+// initDateProfiles loads custom profiles and the profiles of sources from the environment
+func initDateProfiles() {
+	funcName := "initDateProfiles"
+
+	for _, spec := range strings.Split(os.Getenv(DATE_PROFILES_ENV), ";") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Fatalf("%s: Invalid profile %q in %s", funcName, spec, DATE_PROFILES_ENV)
+		}
+		dateProfiles[parts[0]] = DateProfile{Layouts: strings.Split(parts[1], "|")}
+	}
+
+	sources := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(DATE_SOURCES_ENV), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			log.Fatalf("%s: Invalid source %q in %s", funcName, pair, DATE_SOURCES_ENV)
+		}
+		if _, ok := dateProfiles[pair[i+1:]]; !ok {
+			log.Fatalf("%s: Unknown profile %q in %s", funcName, pair[i+1:], DATE_SOURCES_ENV)
+		}
+		sources[pair[:i]] = pair[i+1:]
+	}
+	dateSources = sources
+}
+
+// dateProfileFor returns the name of the profile of the longest source prefix matching source, or ""
+func dateProfileFor(source string) string {
+	name, longest := "", -1
+	for prefix, profile := range dateSources {
+		if strings.HasPrefix(source, prefix) && len(prefix) > longest {
+			name, longest = profile, len(prefix)
+		}
+	}
+	return name
+}
+
+// Parse parses a date written as described by the profile
+// It returns the date in the stored format and whether any layout matched
+func (profile DateProfile) Parse(value string) (string, bool) {
+	value = profile.translate(strings.TrimSpace(value))
+	for _, layout := range profile.Layouts {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		// Only layouts with minutes carry a time, the others are plain dates
+		if strings.Contains(layout, "04") {
+			return t.Format(DATETIME_LAYOUT), true
+		}
+		return t.Format(DATE_LAYOUT), true
+	}
+	return "", false
+}
+
+// translate replaces localized month names with English ones and drops ordinal suffixes like "1er"
+func (profile DateProfile) translate(value string) string {
+	if len(profile.Months) == 0 {
+		return value
+	}
+	words := strings.Fields(value)
+	for i, word := range words {
+		lower := strings.ToLower(strings.TrimSuffix(word, "."))
+		for month, name := range profile.Months {
+			if lower == name {
+				words[i] = time.Month(month + 1).String()
+			}
+		}
+		if lower == "1er" {
+			words[i] = "1"
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// isStoredDate reports whether value is already in one of the stored formats
+func isStoredDate(value string) bool {
+	for _, layout := range []string{DATE_LAYOUT, DATETIME_LAYOUT} {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDateProfile parses the creation date of doc with the named profile
+// The profile is recorded on the document so reprocessing parses the date the same way.
+// Dates which are already normalized or which the profile doesn't match are left as they are.
+func applyDateProfile(doc *XMLDoc, name string) {
+	profile, ok := dateProfiles[name]
+	if !ok {
+		return
+	}
+	doc.DateProfile = name
+	if doc.CreatedAt == "" || doc.CreatedOffset != "" || isStoredDate(doc.CreatedAt) {
+		return
+	}
+
+	value, ok := profile.Parse(doc.CreatedAt)
+	if !ok {
+		metrics.inc("unparsed_dates_total", "profile", name)
+		return
+	}
+	doc.CreatedAt = value
+}
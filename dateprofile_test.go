@@ -0,0 +1,69 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing dates with the built-in profiles
+func TestDateProfileParse(t *testing.T) {
+	tests := []struct {
+		desc     string
+		profile  string
+		value    string
+		expected string
+		ok       bool
+	}{
+		{desc: "german date", profile: "de", value: "09.07.2024", expected: "2024-07-09", ok: true},
+		{desc: "german date with time", profile: "de", value: "09.07.2024 14:30", expected: "2024-07-09T14:30:00", ok: true},
+		{desc: "us numeric date", profile: "us", value: "07/09/2024", expected: "2024-07-09", ok: true},
+		{desc: "us month name", profile: "us", value: "Jul 9, 2024", expected: "2024-07-09", ok: true},
+		{desc: "us time", profile: "us", value: "07/09/2024 2:30 PM", expected: "2024-07-09T14:30:00", ok: true},
+		{desc: "uk numeric date", profile: "uk", value: "09/07/2024", expected: "2024-07-09", ok: true},
+		{desc: "french month name", profile: "fr", value: "9 juillet 2024", expected: "2024-07-09", ok: true},
+		{desc: "french month name with accent", profile: "fr", value: "15 Août 2024", expected: "2024-08-15", ok: true},
+		{desc: "french ordinal", profile: "fr", value: "1er décembre 2024", expected: "2024-12-01", ok: true},
+		{desc: "no matching layout", profile: "de", value: "July 9, 2024"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			value, ok := dateProfiles[tt.profile].Parse(tt.value)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+// Test that the profile of the source is applied when parsing and kept when reprocessing
+func TestDateProfileSources(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(sources map[string]string) { dateSources = sources }(dateSources)
+	dateSources = map[string]string{"http:": "us", "http:10.0.0.5": "fr"}
+
+	require.Equal(t, "us", dateProfileFor("http:10.0.0.1"))
+	require.Equal(t, "fr", dateProfileFor("http:10.0.0.5"))
+	require.Equal(t, "", dateProfileFor("file:./xml_files/test.xml"))
+
+	data := "<document><title>Test Title</title><creationDate>9 juillet 2024</creationDate></document>"
+	doc, err := parseDocumentFrom(data, "http:10.0.0.5")
+	require.NoError(t, err)
+	require.Equal(t, "2024-07-09", doc.CreatedAt)
+	require.Equal(t, "fr", doc.DateProfile)
+	require.NoError(t, insertDocument(db, *doc))
+
+	// Timestamps with an offset are normalized whatever the profile
+	doc, err = parseDocumentFrom("<document><creationDate>2024-07-09T14:30:00+02:00</creationDate></document>", "http:10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, "2024-07-09T12:30:00Z", doc.CreatedAt)
+
+	changed, err := reprocessDocument(db, "1")
+	require.NoError(t, err)
+	require.False(t, changed)
+	stored, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "2024-07-09", stored.CreatedAt)
+	require.Equal(t, "fr", stored.DateProfile)
+}
@@ -0,0 +1,166 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DIFF_ADDED   = "added"   // Kind of a change adding an element or attribute
+	DIFF_REMOVED = "removed" // Kind of a change removing an element or attribute
+	DIFF_CHANGED = "changed" // Kind of a change of the text of an element or the value of an attribute
+)
+
+// XMLChange is a difference between two documents
+type XMLChange struct {
+	Kind string // Kind is DIFF_ADDED, DIFF_REMOVED or DIFF_CHANGED
+	Path string // Path locates the element like /order/item[2]/price[1], in the second document for added elements
+	Attr string `json:",omitempty"` // Attr is the name of the attribute, empty for changes of elements
+	Old  string `json:",omitempty"` // Old is the text or value in the first document
+	New  string `json:",omitempty"` // New is the text or value in the second document
+}
+
+// DiffResponse is the JSON response of /diff
+type DiffResponse struct {
+	ID1     string
+	ID2     string
+	Changes []XMLChange
+}
+
+// DiffXML compares the element trees of two XML documents
+// Elements are matched by name and position among their siblings of the same name, like in the paths of
+// the changes, and the text of elements is compared with its whitespace collapsed. An element only found
+// in one document is a single change, its descendants aren't listed.
+func DiffXML(a string, b string) ([]XMLChange, error) {
+	treeA, err := ParseTree(strings.NewReader(a))
+	if err != nil {
+		return nil, fmt.Errorf("first document: %w", err)
+	}
+	treeB, err := ParseTree(strings.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("second document: %w", err)
+	}
+	return diffTrees(treeA, treeB), nil
+}
+
+// diffTrees compares two element trees
+func diffTrees(a *Node, b *Node) []XMLChange {
+	changes := []XMLChange{}
+	if a.Name != b.Name {
+		// Documents with different roots have nothing in common
+		return append(changes,
+			XMLChange{Kind: DIFF_REMOVED, Path: "/" + a.Name, Old: a.String()},
+			XMLChange{Kind: DIFF_ADDED, Path: "/" + b.Name, New: b.String()})
+	}
+	diffNodes(a, b, "/"+a.Name, &changes)
+	return changes
+}
+
+func diffNodes(a *Node, b *Node, path string, changes *[]XMLChange) {
+	names := make([]string, 0, len(a.Attrs)+len(b.Attrs))
+	for name := range a.Attrs {
+		names = append(names, name)
+	}
+	for name := range b.Attrs {
+		if _, ok := a.Attrs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		old, inA := a.Attrs[name]
+		value, inB := b.Attrs[name]
+		switch {
+		case !inB:
+			*changes = append(*changes, XMLChange{Kind: DIFF_REMOVED, Path: path, Attr: name, Old: old})
+		case !inA:
+			*changes = append(*changes, XMLChange{Kind: DIFF_ADDED, Path: path, Attr: name, New: value})
+		case old != value:
+			*changes = append(*changes, XMLChange{Kind: DIFF_CHANGED, Path: path, Attr: name, Old: old, New: value})
+		}
+	}
+
+	if old, text := collapseText(a.directText()), collapseText(b.directText()); old != text {
+		*changes = append(*changes, XMLChange{Kind: DIFF_CHANGED, Path: path, Old: old, New: text})
+	}
+
+	// Children are matched by name and position among their siblings of the same name
+	countsA := map[string]int{}
+	matched := map[string]*Node{}
+	for _, child := range a.Children {
+		countsA[child.Name]++
+		matched[fmt.Sprintf("%s[%d]", child.Name, countsA[child.Name])] = child
+	}
+	countsB := map[string]int{}
+	for _, child := range b.Children {
+		countsB[child.Name]++
+		step := fmt.Sprintf("%s[%d]", child.Name, countsB[child.Name])
+		if childA, ok := matched[step]; ok {
+			diffNodes(childA, child, path+"/"+step, changes)
+			delete(matched, step)
+		} else {
+			*changes = append(*changes, XMLChange{Kind: DIFF_ADDED, Path: path + "/" + step, New: child.String()})
+		}
+	}
+	countsA = map[string]int{}
+	for _, child := range a.Children {
+		countsA[child.Name]++
+		step := fmt.Sprintf("%s[%d]", child.Name, countsA[child.Name])
+		if _, ok := matched[step]; ok {
+			*changes = append(*changes, XMLChange{Kind: DIFF_REMOVED, Path: path + "/" + step, Old: child.String()})
+		}
+	}
+}
+
+// collapseText trims text and replaces its runs of whitespace with a single space
+func collapseText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// diffDocumentIDs is the scope of /diff, both compared documents
+func diffDocumentIDs(r *http.Request) []string {
+	return []string{r.URL.Query().Get("id1"), r.URL.Query().Get("id2")}
+}
+
+// handleDiffRequest compares the element trees of two documents, e.g. /diff?id1=1&id2=2
+func handleDiffRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	ids := diffDocumentIDs(r)
+	if ids[0] == "" || ids[1] == "" {
+		http.Error(w, "id1 and id2 parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	var trees []*Node
+	for _, id := range ids {
+		doc, err := getPublishedDocumentByID(db, id, time.Now())
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+			return
+		}
+		// Documents stored before trees were kept have none until they are reprocessed
+		if doc.Tree == nil {
+			http.Error(w, fmt.Sprintf("Document with ID %s has no element tree, reprocess it first", id), http.StatusConflict)
+			return
+		}
+		trees = append(trees, doc.Tree)
+	}
+
+	response, err := json.Marshal(DiffResponse{ID1: ids[0], ID2: ids[1], Changes: diffTrees(trees[0], trees[1])})
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
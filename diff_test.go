@@ -0,0 +1,68 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test listing the added, removed and changed elements and attributes of two documents
+func TestDiffXML(t *testing.T) {
+	changes, err := DiffXML(
+		`<order id="7" status="open"><item><name>Fish</name></item><item><name>Chips</name></item><note>fast</note></order>`,
+		`<order id="7" currency="EUR">
+			<item><name>Fish</name></item>
+			<item><name>Peas</name></item>
+			<item><name>Chips</name></item>
+		</order>`)
+	require.NoError(t, err)
+	require.Equal(t, []XMLChange{
+		{Kind: DIFF_ADDED, Path: "/order", Attr: "currency", New: "EUR"},
+		{Kind: DIFF_REMOVED, Path: "/order", Attr: "status", Old: "open"},
+		{Kind: DIFF_CHANGED, Path: "/order/item[2]/name[1]", Old: "Chips", New: "Peas"},
+		{Kind: DIFF_ADDED, Path: "/order/item[3]", New: "<item><name>Chips</name></item>"},
+		{Kind: DIFF_REMOVED, Path: "/order/note[1]", Old: "<note>fast</note>"},
+	}, changes)
+
+	changes, err = DiffXML(`<a><b x="1">t</b></a>`, "<a>\n  <b x=\"1\"> t </b>\n</a>")
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	_, err = DiffXML(`<a>`, `<a/>`)
+	require.Error(t, err)
+}
+
+// Test comparing two stored documents
+func TestHandleDiffRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var ids []string
+	for _, data := range []string{"<document><title>One</title></document>", "<document><title>Two</title></document>"} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		id, err := addDocument(db, *doc)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest(http.MethodGet, "/diff?id1="+ids[0]+"&id2="+ids[1], nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var response DiffResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Equal(t, []XMLChange{{Kind: DIFF_CHANGED, Path: "/document/title[1]", Old: "One", New: "Two"}}, response.Changes)
+
+	for path, expected := range map[string]int{
+		"/diff?id1=" + ids[0]:                    http.StatusBadRequest,
+		"/diff?id1=" + ids[0] + "&id2=999":       http.StatusNotFound,
+		"/diff?id1=" + ids[0] + "&id2=" + ids[0]: http.StatusOK,
+	} {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, expected, rr.Code, path)
+	}
+}
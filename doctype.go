@@ -0,0 +1,68 @@
+package goapp
+
+import (
+	"strings"
+)
+
+const (
+	DOCTYPE_START = "<!DOCTYPE" // Start of a document type declaration, whose internal subset may hold '<' and '>'
+)
+
+// doctypeEnd returns the index after the DOCTYPE declaration starting at data[i]
+// A '>' only ends it outside quotes and the internal subset in brackets, which holds markup declarations like <!ENTITY a "<b>">.
+// It returns 0 if none starts there and -1 if it is never closed
+func doctypeEnd(data string, i int) int {
+	if !strings.HasPrefix(data[i:], DOCTYPE_START) {
+		return 0
+	}
+	var quote byte
+	depth := 0 // depth is 1 inside the internal subset
+	for j := i + len(DOCTYPE_START); j < len(data); j++ {
+		char := data[j]
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '[':
+			depth++
+		case char == ']' && depth > 0:
+			depth--
+		case char == '>' && depth == 0:
+			return j + 1
+		case char == '<' && depth > 0:
+			// Comments and processing instructions of the internal subset may hold quotes and brackets
+			end := sectionEnd(data, j)
+			if end < 0 {
+				return -1
+			} else if end > 0 {
+				j = end - 1
+			}
+		}
+	}
+	return -1
+}
+
+// parseDoctype returns the name of the root element declared by the DOCTYPE of data, empty if it has none
+func parseDoctype(data string) string {
+	for i := 0; i < len(data); i++ {
+		if data[i] != '<' {
+			continue
+		}
+		end := sectionEnd(data, i)
+		if end <= 0 {
+			continue
+		}
+		if strings.HasPrefix(data[i:], DOCTYPE_START) {
+			declaration := strings.TrimLeft(data[i+len(DOCTYPE_START):end], " \t\r\n")
+			if j := strings.IndexAny(declaration, " \t\r\n[>"); j >= 0 {
+				return declaration[:j]
+			}
+			return ""
+		}
+		i = end - 1
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test finding the end of DOCTYPE declarations
+func TestDoctypeEnd(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected int
+	}{
+		{desc: "no doctype", data: "<document/>", expected: 0},
+		{desc: "name only", data: "<!DOCTYPE document><document/>", expected: 19},
+		{desc: "external id", data: `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "a>b.dtd"><html/>`, expected: 67},
+		{desc: "internal subset", data: `<!DOCTYPE d [ <!ENTITY a "<b>"> <!ELEMENT d (#PCDATA)> ]><d/>`, expected: 57},
+		{desc: "comment in internal subset", data: "<!DOCTYPE d [ <!-- don't ] --> ]><d/>", expected: 33},
+		{desc: "unterminated", data: "<!DOCTYPE d [ <!ELEMENT d (#PCDATA)> <d/>", expected: -1},
+		{desc: "unterminated comment", data: "<!DOCTYPE d [ <!-- ]>", expected: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.expected, doctypeEnd(tt.data, 0))
+		})
+	}
+}
+
+// Test parsing documents with a DOCTYPE, whose internal subset used to break tag pairing
+func TestParseDocumentDoctype(t *testing.T) {
+	data := "<?xml version=\"1.0\"?>\n<!DOCTYPE document [\n  <!ENTITY company \"Acme <Ltd>\">\n  <!ELEMENT document (title)>\n]>\n<document><title>Test Title</title></document>"
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "Test Title", doc.Title)
+	require.Equal(t, "document", doc.Doctype)
+	require.Equal(t, "<document><title>Test Title</title></document>", doc.XMLData[0])
+	require.Equal(t, strings.TrimSuffix(data, "\n<document><title>Test Title</title></document>"), doc.Prolog)
+	require.Equal(t, DocumentStats{Words: 2, Characters: 10, Elements: 2, MaxDepth: 2}, doc.Stats)
+
+	doc, err = parseDocument("<document><title>Test Title</title></document>")
+	require.NoError(t, err)
+	require.Empty(t, doc.Doctype)
+
+	_, err = parseDocument("<!DOCTYPE document [ <!ELEMENT document (title)>\n<document/>")
+	require.EqualError(t, err, "unterminated DOCTYPE at line 1, column 1")
+
+	handler := &recordingHandler{}
+	require.NoError(t, ParseReader(strings.NewReader(data), handler))
+	require.Equal(t, []string{"start document", "start title", "text Test Title", "end title", "end document"}, handler.events)
+}
+
+// Test listing documents by their DOCTYPE with /list?doctype=
+func TestHandleListRequestDoctype(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		"<!DOCTYPE document><document><title>A</title></document>",
+		"<!DOCTYPE html [ <!ENTITY nbsp \"&#160;\"> ]><html><title>B</title></html>",
+		"<document><title>C</title></document>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	req := httptest.NewRequest("GET", "/list?doctype=html", nil)
+	w := httptest.NewRecorder()
+	handleListRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var docs []XMLDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, "B", docs[0].Title)
+	require.Equal(t, "html", docs[0].Doctype)
+
+	// The doctype filter combines with the other filters
+	req = httptest.NewRequest("GET", "/list?doctype=document&validation=unvalidated", nil)
+	w = httptest.NewRecorder()
+	handleListRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, "A", docs[0].Title)
+}
@@ -0,0 +1,118 @@
+package goapp
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// XMLElement is the structured form of an element string like `<tag attr="x">text</tag>`
+type XMLElement struct {
+	Name  string            // Name is the tag name, including any namespace prefix
+	Space string            // Space is the URI of the namespace of the element, empty if it has none
+	Local string            // Local is the tag name without namespace prefix
+	Attrs map[string]string // Attrs holds the attribute values by attribute name
+	Text  string            // Text is the content between the tags as returned by elementText, nested elements included
+}
+
+// parseXMLElement parses an element string as returned by parseXML into an XMLElement
+func parseXMLElement(str string) (XMLElement, bool) {
+	name, attrs, text, ok := parseElement(str)
+	if !ok {
+		return XMLElement{}, false
+	}
+	element := XMLElement{Name: name, Local: localName(name), Attrs: parseAttributes(attrs), Text: elementText(text)}
+	element.resolveNamespace(nil)
+	return element, true
+}
+
+// Attr returns the value of the named attribute and whether the element has it
+func (element XMLElement) Attr(name string) (string, bool) {
+	value, ok := element.Attrs[name]
+	return value, ok
+}
+
+// Element returns the first element of the document with the given name
+// A name without prefix like "title" matches whatever prefix the element has, e.g. <dc:title>.
+// Elements are looked up in the order of XMLData, so outer elements come before nested ones
+func (doc *XMLDoc) Element(name string) (XMLElement, bool) {
+	prefixed := strings.Contains(name, ":")
+	return doc.findElement(func(element XMLElement) bool {
+		return element.Name == name || (!prefixed && element.Local == name)
+	})
+}
+
+// All returns the non-empty texts of all elements of the document with the given name, in document order
+// Names are matched like Element, e.g. doc.All("author") returns every author of a document crediting several.
+func (doc *XMLDoc) All(name string) []string {
+	prefixed := strings.Contains(name, ":")
+	var values []string
+	for _, str := range doc.XMLData {
+		element, ok := parseXMLElement(str)
+		if !ok || element.Text == "" {
+			continue
+		}
+		if element.Name == name || (!prefixed && element.Local == name) {
+			values = append(values, element.Text)
+		}
+	}
+	return values
+}
+
+// ElementNS returns the first element of the document with the given namespace URI and local name
+func (doc *XMLDoc) ElementNS(space string, local string) (XMLElement, bool) {
+	return doc.findElement(func(element XMLElement) bool {
+		return element.Space == space && element.Local == local
+	})
+}
+
+// findElement returns the first element of the document matching with its namespace resolved
+func (doc *XMLDoc) findElement(match func(XMLElement) bool) (XMLElement, bool) {
+	namespaces := documentNamespaces(doc.XMLData)
+	for _, str := range doc.XMLData {
+		element, ok := parseXMLElement(str)
+		if !ok {
+			continue
+		}
+		element.resolveNamespace(namespaces)
+		if match(element) {
+			return element, true
+		}
+	}
+	return XMLElement{}, false
+}
+
+// Attr returns the value of an attribute of the first element with the given name, e.g. doc.Attr("title", "lang")
+// It returns an empty string if there is no such element or attribute
+func (doc *XMLDoc) Attr(element string, name string) string {
+	found, ok := doc.Element(element)
+	if !ok {
+		return ""
+	}
+	value, _ := found.Attr(name)
+	return value
+}
+
+// encodeValues encodes multiple values of a field, like the authors, for storage in a column
+func encodeValues(values []string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeValues decodes the content of a column encoded by encodeValues
+func decodeValues(data string) ([]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var values []string
+	err := json.Unmarshal([]byte(data), &values)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
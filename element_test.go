@@ -0,0 +1,109 @@
+package goapp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing element strings into the structured model
+func TestParseXMLElement(t *testing.T) {
+	tests := []struct {
+		desc     string
+		str      string
+		expected XMLElement
+		ok       bool
+	}{
+		{desc: "no attributes", str: "<title>Test Title</title>", expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{}, Text: "Test Title"}, ok: true},
+		{desc: "attributes", str: `<title lang="en" id='t1'>Test Title</title>`, expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{"lang": "en", "id": "t1"}, Text: "Test Title"}, ok: true},
+		{desc: "namespaced attribute", str: `<title xml:lang="fr">Titre</title>`, expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{"xml:lang": "fr"}, Text: "Titre"}, ok: true},
+		{desc: "self-closing", str: `<link href="/a"/>`, expected: XMLElement{Name: "link", Local: "link", Attrs: map[string]string{"href": "/a"}}, ok: true},
+		{desc: "duplicate attribute", str: `<title lang="en" lang="fr">Test Title</title>`, expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{"lang": "en"}, Text: "Test Title"}, ok: true},
+		{desc: "malformed attribute", str: `<title lang="en" id=t1>Test Title</title>`, expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{"lang": "en"}, Text: "Test Title"}, ok: true},
+		{desc: "multi-byte text", str: `<title lang="日本">日本語 – Ünïcødé €</title>`, expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{"lang": "日本"}, Text: "日本語 – Ünïcødé €"}, ok: true},
+		{desc: "greater-than sign in attribute", str: `<title note="a>b">日本</title>`, expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{"note": "a>b"}, Text: "日本"}, ok: true},
+		{desc: "whitespace in closing tag", str: "<title>€uro</title \n>", expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{}, Text: "€uro"}, ok: true},
+		{desc: "character references", str: "<title>&#x65E5;&amp;&#26412;</title>", expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{}, Text: "日&本"}, ok: true},
+		{desc: "comment", str: "<title>Test <!-- if x > 3 -->Title<![CDATA[<!-- kept -->]]></title>", expected: XMLElement{Name: "title", Local: "title", Attrs: map[string]string{}, Text: "Test Title<!-- kept -->"}, ok: true},
+		{desc: "unmatched closing tag", str: "<title>Test Title</author>"},
+		{desc: "unterminated start tag", str: `<title note="a>b</title>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			element, ok := parseXMLElement(tt.str)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.expected, element)
+		})
+	}
+}
+
+// Test that metadata elements with attributes are extracted and their attributes can be queried
+func TestParseDocumentAttributes(t *testing.T) {
+	data := `<document id="42"><title lang="en">Test Title</title><description class="short">Test Description</description><author role="editor">John Doe</author></document>`
+
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "Test Title", doc.Title)
+	require.Equal(t, "Test Description", doc.Description)
+	require.Equal(t, "John Doe", doc.Author)
+	require.Empty(t, doc.Variants)
+
+	require.Equal(t, "en", doc.Attr("title", "lang"))
+	require.Equal(t, "editor", doc.Attr("author", "role"))
+	require.Equal(t, "42", doc.Attr("document", "id"))
+	require.Equal(t, "", doc.Attr("title", "missing"))
+	require.Equal(t, "", doc.Attr("missing", "lang"))
+
+	element, ok := doc.Element("description")
+	require.True(t, ok)
+	value, ok := element.Attr("class")
+	require.True(t, ok)
+	require.Equal(t, "short", value)
+}
+
+// Test that metadata with multi-byte characters is extracted whole
+func TestParseDocumentMultiByteFields(t *testing.T) {
+	data := `<document><title note="a>b">日本語のタイトル</title><author>Zoë Ünïcødé</author ><description>€ – ✓</description></document>`
+
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "日本語のタイトル", doc.Title)
+	require.Equal(t, "Zoë Ünïcødé", doc.Author)
+	require.Equal(t, "€ – ✓", doc.Description)
+}
+
+// Test collecting every author and the values of repeated elements
+func TestParseDocumentRepeatedElements(t *testing.T) {
+	data := `<paper><title>On Trees</title><author>Ada Lovelace</author><dc:author xmlns:dc="http://purl.org/dc/elements/1.1/">Charles Babbage</dc:author><author/><keywords><keyword>xml</keyword><keyword>parsing</keyword></keywords></paper>`
+
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "Ada Lovelace", doc.Author)
+	require.Equal(t, []string{"Ada Lovelace", "Charles Babbage"}, doc.Authors)
+
+	require.Equal(t, []string{"Ada Lovelace", "Charles Babbage"}, doc.All("author"))
+	require.Equal(t, []string{"Charles Babbage"}, doc.All("dc:author"))
+	require.Equal(t, []string{"xml", "parsing"}, doc.All("keyword"))
+	require.Empty(t, doc.All("missing"))
+}
+
+// Test storing all authors and reading those of documents stored before they were kept
+func TestDocumentAuthorsStored(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument(`<paper><author>Ada Lovelace</author><author>Charles Babbage</author></paper>`)
+	require.NoError(t, err)
+	id, err := addDocument(db, *doc)
+	require.NoError(t, err)
+	stored, err := getDocumentByID(db, id)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Ada Lovelace", "Charles Babbage"}, stored.Authors)
+
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET %s=NULL", DB_TABLE_NAME, DB_AUTHORS_FIELD_NAME))
+	require.NoError(t, err)
+	stored, err = getDocumentByID(db, id)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Ada Lovelace"}, stored.Authors)
+}
@@ -0,0 +1,94 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	ENCODING_UTF8    = "utf-8"    // Encoding documents are stored in
+	ENCODING_UTF16LE = "utf-16le" // Encoding of UTF-16 documents starting with FF FE or "<" followed by a zero byte
+	ENCODING_UTF16BE = "utf-16be" // Encoding of UTF-16 documents starting with FE FF or a zero byte followed by "<"
+)
+
+// ErrUnsupportedEncoding is wrapped by the errors of documents declaring an encoding which can't be transcoded
+var ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+// xmlEncodings are the encodings documents are transcoded from by lower case name, including common aliases
+var xmlEncodings = map[string]encoding.Encoding{
+	"utf-16":       unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso_8859-1":   charmap.ISO8859_1,
+	"latin1":       charmap.ISO8859_1,
+	"l1":           charmap.ISO8859_1,
+	"iso-8859-15":  charmap.ISO8859_15,
+	"windows-1252": charmap.Windows1252,
+	"cp1252":       charmap.Windows1252,
+	"shift_jis":    japanese.ShiftJIS,
+	"shift-jis":    japanese.ShiftJIS,
+	"sjis":         japanese.ShiftJIS,
+	"x-sjis":       japanese.ShiftJIS,
+	"windows-31j":  japanese.ShiftJIS,
+	"cp932":        japanese.ShiftJIS,
+}
+
+// utf8Encodings are the encoding names whose documents are read as they are
+var utf8Encodings = map[string]bool{"utf-8": true, "utf8": true, "us-ascii": true, "ascii": true}
+
+// encodingDeclaration matches the encoding of an XML declaration in group 1
+var encodingDeclaration = regexp.MustCompile(`^(?:\x{FEFF})?\s*<\?xml\s[^>]*?\bencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+// detectEncoding returns the lower case name of the encoding of data from its byte order mark or its XML declaration
+// It returns utf-8 if neither tells the encoding.
+func detectEncoding(data string) string {
+	switch {
+	case strings.HasPrefix(data, "\xFF\xFE"), strings.HasPrefix(data, "<\x00"):
+		return ENCODING_UTF16LE
+	case strings.HasPrefix(data, "\xFE\xFF"), strings.HasPrefix(data, "\x00<"):
+		return ENCODING_UTF16BE
+	}
+	if match := encodingDeclaration.FindStringSubmatch(data); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return ENCODING_UTF8
+}
+
+// transcodeXML converts a document to UTF-8
+// The encoding of the XML declaration is changed to UTF-8 so the stored document describes itself correctly.
+// Documents declaring a single-byte or Shift_JIS encoding which are valid UTF-8 are taken as they are,
+// as they were converted before or were mislabeled by their producer.
+func transcodeXML(data string) (string, error) {
+	name := detectEncoding(data)
+	if utf8Encodings[name] {
+		return data, nil
+	}
+	enc, ok := xmlEncodings[name]
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnsupportedEncoding, name)
+	}
+	if name != ENCODING_UTF16LE && name != ENCODING_UTF16BE && utf8.ValidString(data) {
+		return data, nil
+	}
+
+	decoded, err := enc.NewDecoder().String(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s data: %w", name, err)
+	}
+	decoded = strings.TrimPrefix(decoded, UTF8_BOM)
+
+	if match := encodingDeclaration.FindStringSubmatchIndex(decoded); match != nil {
+		decoded = decoded[:match[2]] + "UTF-8" + decoded[match[3]:]
+	}
+	metrics.inc("transcoded_documents_total", "encoding", name)
+	return decoded, nil
+}
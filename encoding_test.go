@@ -0,0 +1,93 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodeString encodes a UTF-8 test document with an encoder from x/text
+func encodeString(t *testing.T, encoder interface {
+	String(string) (string, error)
+}, data string) string {
+	t.Helper()
+
+	encoded, err := encoder.String(data)
+	require.NoError(t, err)
+	return encoded
+}
+
+// Test converting documents in legacy encodings to UTF-8
+func TestTranscodeXML(t *testing.T) {
+	utf16 := `<?xml version="1.0" encoding="UTF-16"?><doc><title>Café</title></doc>`
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+		err      string
+	}{
+		{desc: "UTF-8", data: "<doc><title>Café</title></doc>", expected: "<doc><title>Café</title></doc>"},
+		{
+			desc:     "UTF-16LE with BOM",
+			data:     encodeString(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder(), utf16),
+			expected: `<?xml version="1.0" encoding="UTF-8"?><doc><title>Café</title></doc>`,
+		},
+		{
+			desc:     "UTF-16BE without BOM",
+			data:     encodeString(t, unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder(), utf16),
+			expected: `<?xml version="1.0" encoding="UTF-8"?><doc><title>Café</title></doc>`,
+		},
+		{
+			desc:     "ISO-8859-1",
+			data:     "<?xml version='1.0' encoding='ISO-8859-1'?><doc><title>Caf\xe9</title></doc>",
+			expected: "<?xml version='1.0' encoding='UTF-8'?><doc><title>Café</title></doc>",
+		},
+		{
+			desc:     "Shift_JIS",
+			data:     encodeString(t, japanese.ShiftJIS.NewEncoder(), `<?xml version="1.0" encoding="Shift_JIS"?><doc><title>日本語</title></doc>`),
+			expected: `<?xml version="1.0" encoding="UTF-8"?><doc><title>日本語</title></doc>`,
+		},
+		{
+			desc:     "mislabeled UTF-8",
+			data:     `<?xml version="1.0" encoding="windows-1252"?><doc><title>Café</title></doc>`,
+			expected: `<?xml version="1.0" encoding="windows-1252"?><doc><title>Café</title></doc>`,
+		},
+		{desc: "unsupported", data: `<?xml version="1.0" encoding="EBCDIC-US"?><doc/>`, err: "unsupported encoding ebcdic-us"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			data, err := transcodeXML(tt.data)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, data)
+		})
+	}
+}
+
+// Test ingesting a Latin-1 export with /add
+func TestHandleAddRequestEncoding(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add", strings.NewReader("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<doc id=\"1\"><title>Cr\xe8me br\xfbl\xe9e</title></doc>")))
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Crème brûlée", doc.Title)
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`, doc.Prolog)
+
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add", strings.NewReader(`<?xml version="1.0" encoding="KOI8-R"?><doc/>`)))
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Result().StatusCode)
+}
@@ -0,0 +1,403 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DECODE_ENTITIES_ENV = "DOC_DECODE_ENTITIES" // Environment variable turning entity decoding of element text off with "false"
+
+	ENTITY_MAX_LENGTH = 10 // Longest entity reference between '&' and ';' which is decoded, like "#x10FFFF"
+)
+
+// xmlEntities holds the predefined XML entities by name
+var xmlEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"quot": `"`,
+	"apos": "'",
+}
+
+// decodeElementEntities tells whether element text is entity decoded, set by initEntityDecoding
+// With decoding off the metadata keeps the raw form of the XML, e.g. "Fish &amp; Chips"
+var decodeElementEntities = true
+
+// initEntityDecoding loads whether element text is entity decoded from the environment
+func initEntityDecoding() {
+	funcName := "initEntityDecoding"
+
+	value := os.Getenv(DECODE_ENTITIES_ENV)
+	if value == "" {
+		return
+	}
+	decode, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s: %s must be true or false", funcName, DECODE_ENTITIES_ENV)
+	}
+	decodeElementEntities = decode
+}
+
+// decodeEntities replaces the predefined entities and numeric character references like "&#169;" or
+// "&#xA9;" in text with the characters they stand for
+// Unknown entities and invalid references are kept as they are.
+func decodeEntities(text string) string {
+	if !strings.Contains(text, "&") {
+		return text
+	}
+
+	var result strings.Builder
+	for {
+		start := strings.IndexByte(text, '&')
+		if start < 0 {
+			break
+		}
+		result.WriteString(text[:start])
+		text = text[start:]
+
+		end := strings.IndexByte(text, ';')
+		if end < 0 || end > ENTITY_MAX_LENGTH+1 {
+			result.WriteByte('&')
+			text = text[1:]
+			continue
+		}
+		if value, ok := decodeEntity(text[1:end]); ok {
+			result.WriteString(value)
+			text = text[end+1:]
+		} else {
+			result.WriteByte('&')
+			text = text[1:]
+		}
+	}
+	result.WriteString(text)
+	return result.String()
+}
+
+// decodeEntity returns the characters a single entity name like "amp" or "#169" stands for
+func decodeEntity(name string) (string, bool) {
+	if value, ok := xmlEntities[name]; ok {
+		return value, true
+	}
+	if !strings.HasPrefix(name, "#") {
+		return "", false
+	}
+
+	digits, base := name[1:], 10
+	if strings.HasPrefix(digits, "x") || strings.HasPrefix(digits, "X") {
+		digits, base = digits[1:], 16
+	}
+	code, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || code == 0 || !utf8.ValidRune(rune(code)) {
+		return "", false
+	}
+	return string(rune(code)), true
+}
+
+// elementText returns the text of an element as stored in the metadata
+// CDATA sections are unwrapped, comments are left out and the text around them is entity decoded unless
+// decoding is off; the content of CDATA sections is never decoded since it is literal.
+func elementText(text string) string {
+	if !decodeElementEntities {
+		return mapCDATA(text, stripComments)
+	}
+	return mapCDATA(text, func(outside string) string {
+		return decodeEntities(stripComments(outside))
+	})
+}
+
+// entityDeclaration is a general entity declared in the internal subset of a DOCTYPE
+type entityDeclaration struct {
+	Value    string // Value is the replacement text of an internal entity, with its character references decoded
+	PublicID string
+	SystemID string // SystemID is the URI of an external entity, empty for internal ones
+}
+
+// entityLimitError is the error of an expansion over the parser limits
+type entityLimitError string
+
+func (err entityLimitError) Error() string {
+	return string(err)
+}
+
+// parseEntityDeclarations returns the general entities declared by the DOCTYPE of prolog by name
+// Parameter entities, unparsed entities with NDATA and the predefined entities aren't returned, and of
+// entities declared twice the first declaration is kept.
+func parseEntityDeclarations(prolog string) map[string]entityDeclaration {
+	entities := map[string]entityDeclaration{}
+	start := strings.Index(prolog, DOCTYPE_START)
+	if start < 0 {
+		return entities
+	}
+	end := doctypeEnd(prolog, start)
+	if end <= 0 {
+		return entities
+	}
+	doctype := prolog[start:end]
+	subset := strings.IndexByte(doctype, '[')
+	if subset < 0 {
+		return entities
+	}
+
+	for i := subset + 1; i < len(doctype); i++ {
+		if doctype[i] != '<' {
+			continue
+		}
+		if sectionEnd := sectionEnd(doctype, i); sectionEnd > 0 {
+			i = sectionEnd - 1
+			continue
+		}
+		// Declarations end at the first '>' outside quotes
+		var quote byte
+		declEnd := i
+		for ; declEnd < len(doctype); declEnd++ {
+			char := doctype[declEnd]
+			if quote != 0 {
+				if char == quote {
+					quote = 0
+				}
+			} else if char == '"' || char == '\'' {
+				quote = char
+			} else if char == '>' {
+				break
+			}
+		}
+		if strings.HasPrefix(doctype[i:], "<!ENTITY") {
+			if name, entity, ok := parseEntityDeclaration(doctype[i+len("<!ENTITY") : declEnd]); ok {
+				if _, declared := entities[name]; !declared && xmlEntities[name] == "" {
+					entities[name] = entity
+				}
+			}
+		}
+		i = declEnd
+	}
+	return entities
+}
+
+// parseEntityDeclaration parses the declaration of a general entity between "<!ENTITY" and '>', like
+// `company "Acme"` or `terms SYSTEM "https://example.com/terms.xml"`
+func parseEntityDeclaration(decl string) (string, entityDeclaration, bool) {
+	// Tokens are names and quoted literals, which keep their quotes to tell them apart
+	var tokens []string
+	for i := 0; i < len(decl); {
+		switch char := decl[i]; {
+		case strings.IndexByte(XML_WHITESPACE, char) >= 0:
+			i++
+		case char == '"' || char == '\'':
+			end := strings.IndexByte(decl[i+1:], char)
+			if end < 0 {
+				return "", entityDeclaration{}, false
+			}
+			tokens = append(tokens, decl[i:i+end+2])
+			i += end + 2
+		default:
+			end := strings.IndexAny(decl[i:], XML_WHITESPACE+`"'`)
+			if end < 0 {
+				end = len(decl) - i
+			}
+			tokens = append(tokens, decl[i:i+end])
+			i += end
+		}
+	}
+	literal := func(token string) (string, bool) {
+		if len(token) >= 2 && (token[0] == '"' || token[0] == '\'') {
+			return token[1 : len(token)-1], true
+		}
+		return "", false
+	}
+
+	if len(tokens) < 2 || tokens[0] == "%" || containsString(tokens, "NDATA") {
+		return "", entityDeclaration{}, false
+	}
+	name := tokens[0]
+	if value, ok := literal(tokens[1]); ok {
+		return name, entityDeclaration{Value: decodeCharacterReferences(value)}, true
+	}
+	var entity entityDeclaration
+	var ok bool
+	switch {
+	case tokens[1] == "SYSTEM" && len(tokens) >= 3:
+		entity.SystemID, ok = literal(tokens[2])
+	case tokens[1] == "PUBLIC" && len(tokens) >= 4:
+		entity.PublicID, _ = literal(tokens[2])
+		entity.SystemID, ok = literal(tokens[3])
+	}
+	return name, entity, ok
+}
+
+// decodeCharacterReferences replaces the character references of an entity value, which are decoded when
+// the entity is declared unlike references to other entities
+func decodeCharacterReferences(value string) string {
+	var result strings.Builder
+	for {
+		start := strings.Index(value, "&#")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], ';')
+		decoded, ok := "", false
+		if end > 0 && end <= ENTITY_MAX_LENGTH+1 {
+			decoded, ok = decodeEntity(value[start+1 : start+end])
+		}
+		if !ok {
+			result.WriteString(value[:start+2])
+			value = value[start+2:]
+			continue
+		}
+		result.WriteString(value[:start] + decoded)
+		value = value[start+end+1:]
+	}
+	result.WriteString(value)
+	return result.String()
+}
+
+// entityExpander expands the references to the entities declared by a document
+type entityExpander struct {
+	declared   map[string]entityDeclaration
+	resolver   EntityResolver
+	limits     ParseLimits
+	resolved   map[string]*string // resolved holds the replacement text of external entities, nil if they aren't resolved
+	expansions int
+	out        strings.Builder
+}
+
+// expandEntities replaces the references to the entities declared by the DOCTYPE of data with their
+// replacement text, in the content and attribute values of the document
+// External entities are only expanded if the resolver allows them, references to other ones are kept as they
+// are. Expansions count against the entity expansion limit and the expanded document against the size limit.
+func expandEntities(data string, resolver EntityResolver, limits ParseLimits) (string, error) {
+	prolog, body := splitProlog(data)
+	declared := parseEntityDeclarations(prolog)
+	if len(declared) == 0 {
+		return data, nil
+	}
+
+	expander := entityExpander{declared: declared, resolver: resolver, limits: limits, resolved: map[string]*string{}}
+	expander.out.WriteString(prolog)
+	for i := 0; i < len(body); {
+		end := strings.IndexByte(body[i:], '<')
+		if end < 0 {
+			end = len(body) - i
+		}
+		inTag := false
+		if end == 0 {
+			// CDATA sections, comments and processing instructions are literal
+			if sectionEnd := sectionEnd(body, i); sectionEnd > 0 {
+				expander.out.WriteString(body[i:sectionEnd])
+				i = sectionEnd
+				continue
+			}
+			end = tagEnd(body, i, ">") - i + 1
+			if end <= 0 {
+				end = len(body) - i
+			}
+			inTag = true
+		}
+		if err := expander.expand(body[i:i+end], inTag, nil); err != nil {
+			// The error is given at the start of the text or tag with the reference
+			var limitErr entityLimitError
+			if errors.As(err, &limitErr) {
+				return "", fmt.Errorf("%w: %v", ErrParseLimit, newParseError(data, len(prolog)+i, string(limitErr)))
+			}
+			return "", newParseError(data, len(prolog)+i, err.Error())
+		}
+		i += end
+	}
+	return expander.out.String(), nil
+}
+
+// expand writes text with the references to declared entities expanded, open being the entities being expanded
+// Replacement text in tags has its '<' and quotes escaped, so it can't end attribute values.
+// The text itself is written as it is.
+func (expander *entityExpander) expand(text string, inTag bool, open []string) error {
+	for {
+		start := strings.IndexByte(text, '&')
+		if start < 0 {
+			break
+		}
+		expander.write(text[:start], inTag && len(open) > 0)
+		text = text[start:]
+
+		end := strings.IndexAny(text[1:], ";&<"+XML_WHITESPACE) + 1
+		name := ""
+		if end > 1 && text[end] == ';' {
+			name = text[1:end]
+		}
+		replacement, ok := expander.replacement(name, inTag)
+		if !ok {
+			expander.out.WriteByte('&')
+			text = text[1:]
+			continue
+		}
+		if containsString(open, name) {
+			return fmt.Errorf("recursive entity reference &%s;", name)
+		}
+		expander.expansions++
+		if expander.limits.MaxEntityExpansions > 0 && expander.expansions > expander.limits.MaxEntityExpansions {
+			return entityLimitError(fmt.Sprintf("more than %d entity references", expander.limits.MaxEntityExpansions))
+		}
+		if err := expander.expand(replacement, inTag, append(open, name)); err != nil {
+			return err
+		}
+		text = text[end+1:]
+	}
+	expander.write(text, inTag && len(open) > 0)
+
+	if expander.limits.MaxInputBytes > 0 && expander.out.Len() > expander.limits.MaxInputBytes {
+		return entityLimitError(fmt.Sprintf("document larger than %d bytes with its entities expanded", expander.limits.MaxInputBytes))
+	}
+	return nil
+}
+
+// write writes literal text, escaped for attribute values if escape is set
+func (expander *entityExpander) write(text string, escape bool) {
+	if escape {
+		text = strings.NewReplacer("<", "&lt;", `"`, "&quot;", "'", "&apos;").Replace(text)
+	}
+	expander.out.WriteString(text)
+}
+
+// replacement returns the replacement text of a declared entity and whether its reference is expanded
+// External entities aren't expanded in attribute values, which XML doesn't allow.
+func (expander *entityExpander) replacement(name string, inTag bool) (string, bool) {
+	entity, ok := expander.declared[name]
+	if !ok {
+		return "", false
+	}
+	if entity.SystemID == "" {
+		return entity.Value, true
+	}
+	if inTag {
+		return "", false
+	}
+
+	value, done := expander.resolved[name]
+	if !done {
+		text, err := expander.resolver.Resolve(entity.PublicID, entity.SystemID)
+		if errors.Is(err, ErrEntityDenied) {
+			metrics.inc("external_entities_total", "result", "denied")
+		} else if err != nil {
+			metrics.inc("external_entities_total", "result", "failed")
+			log.Printf("expandEntities: Failed to resolve external entity %s: %v", name, err)
+		} else {
+			metrics.inc("external_entities_total", "result", "resolved")
+			// The text declaration of the external entity isn't part of its replacement text
+			if strings.HasPrefix(text, "<?xml") {
+				if end := strings.Index(text, "?>"); end >= 0 {
+					text = text[end+2:]
+				}
+			}
+			value = &text
+		}
+		expander.resolved[name] = value
+	}
+	if value == nil {
+		return "", false
+	}
+	return *value, true
+}
@@ -0,0 +1,115 @@
+package goapp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test decoding entities and character references
+func TestDecodeEntities(t *testing.T) {
+	tests := []struct {
+		desc     string
+		text     string
+		expected string
+	}{
+		{desc: "plain", text: "Fish and Chips", expected: "Fish and Chips"},
+		{desc: "predefined", text: "&lt;b&gt; &amp; &quot;q&quot; &apos;a&apos;", expected: `<b> & "q" 'a'`},
+		{desc: "decimal reference", text: "&#169; 2024", expected: "© 2024"},
+		{desc: "hexadecimal reference", text: "&#xA9; &#X1F600;", expected: "© 😀"},
+		{desc: "double escaped", text: "&amp;amp;", expected: "&amp;"},
+		{desc: "unknown entity", text: "&nbsp;&copy;", expected: "&nbsp;&copy;"},
+		{desc: "invalid references", text: "&#0; &#xD800; &#12a; &#;", expected: "&#0; &#xD800; &#12a; &#;"},
+		{desc: "bare ampersands", text: "R&D & more; a&b", expected: "R&D & more; a&b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.expected, decodeEntities(tt.text))
+		})
+	}
+}
+
+// Test that metadata is entity decoded, except CDATA content and with decoding off
+func TestParseDocumentEntities(t *testing.T) {
+	data := `<document><title>Fish &amp; Chips &#169;</title><description><![CDATA[&amp; stays]]> &lt;ok&gt;</description><author>Zo&#xE9;</author></document>`
+
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "Fish & Chips ©", doc.Title)
+	require.Equal(t, "&amp; stays <ok>", doc.Description)
+	require.Equal(t, "Zoé", doc.Author)
+	require.Contains(t, doc.XMLData[0], "Fish &amp; Chips &#169;")
+
+	defer func(decode bool) { decodeElementEntities = decode }(decodeElementEntities)
+	decodeElementEntities = false
+
+	doc, err = parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "Fish &amp; Chips &#169;", doc.Title)
+	require.Equal(t, "&amp; stays &lt;ok&gt;", doc.Description)
+}
+
+// Test expanding the entities declared by the DOCTYPE
+func TestExpandEntities(t *testing.T) {
+	limits := ParseLimits{MaxEntityExpansions: 100, MaxInputBytes: 1000}
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+		err      string
+	}{
+		{
+			desc:     "text and markup",
+			data:     `<!DOCTYPE d [<!ENTITY co "Acme &amp; Co"><!ENTITY bold "<b>&co;</b>"><!ENTITY copy "&#169;">]><d>&copy; &bold; &lt;&unknown;</d>`,
+			expected: `<!DOCTYPE d [<!ENTITY co "Acme &amp; Co"><!ENTITY bold "<b>&co;</b>"><!ENTITY copy "&#169;">]><d>© <b>Acme &amp; Co</b> &lt;&unknown;</d>`,
+		},
+		{
+			desc:     "attribute values",
+			data:     `<!DOCTYPE d [<!ENTITY q 'say "hi" <now>'>]><d a="&q;"/>`,
+			expected: `<!DOCTYPE d [<!ENTITY q 'say "hi" <now>'>]><d a="say &quot;hi&quot; &lt;now>"/>`,
+		},
+		{
+			desc:     "first declaration, parameter entities and comments",
+			data:     `<!DOCTYPE d [<!-- <!ENTITY a "comment"> --><!ENTITY % p "param"><!ENTITY a "first"><!ENTITY a "second">]><d>&a;<![CDATA[&a;]]></d>`,
+			expected: `<!DOCTYPE d [<!-- <!ENTITY a "comment"> --><!ENTITY % p "param"><!ENTITY a "first"><!ENTITY a "second">]><d>first<![CDATA[&a;]]></d>`,
+		},
+		{
+			desc:     "external entities denied",
+			data:     `<!DOCTYPE d [<!ENTITY passwd SYSTEM "file:///etc/passwd">]><d>&passwd;</d>`,
+			expected: `<!DOCTYPE d [<!ENTITY passwd SYSTEM "file:///etc/passwd">]><d>&passwd;</d>`,
+		},
+		{
+			desc: "recursion",
+			data: `<!DOCTYPE d [<!ENTITY a "&b;"><!ENTITY b "x&a;">]><d>&a;</d>`,
+			err:  "recursive entity reference &a; at line 1, column 54",
+		},
+		{
+			desc: "billion laughs",
+			data: `<!DOCTYPE d [<!ENTITY l "lol"><!ENTITY l2 "&l;&l;&l;&l;&l;"><!ENTITY l3 "&l2;&l2;&l2;&l2;&l2;"><!ENTITY l4 "&l3;&l3;&l3;&l3;&l3;">]><d>&l4;</d>`,
+			err:  "parser limit exceeded: more than 100 entity references",
+		},
+		{
+			desc: "quadratic blowup",
+			data: `<!DOCTYPE d [<!ENTITY a "` + strings.Repeat("a", 100) + `">]><d>` + strings.Repeat("&a;", 20) + `</d>`,
+			err:  "parser limit exceeded: document larger than 1000 bytes with its entities expanded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			expanded, err := expandEntities(tt.data, DenyAllResolver{}, limits)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, expanded)
+		})
+	}
+
+	// Metadata is read from the expanded document
+	doc, err := parseDocument(`<!DOCTYPE d [<!ENTITY co "Acme &amp; Co">]><d><title>&co; news</title><author>&nobody;</author></d>`)
+	require.NoError(t, err)
+	require.Equal(t, "Acme & Co news", doc.Title)
+	require.Equal(t, "&nobody;", doc.Author)
+}
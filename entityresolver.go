@@ -0,0 +1,138 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ENTITY_ALLOWLIST_ENV = "DOC_ENTITY_ALLOWLIST" // Environment variable with the comma-separated URL prefixes external entities may be fetched from
+
+	ENTITY_FETCH_TIMEOUT   = 10 * time.Second // Longest time fetching an external entity may take
+	ENTITY_FETCH_MAX_BYTES = 1 << 20          // Largest external entity which is fetched
+)
+
+// ErrEntityDenied is returned by resolvers for external entities they don't resolve
+var ErrEntityDenied = errors.New("external entity denied")
+
+// EntityResolver resolves the external entities declared by documents, like <!ENTITY terms SYSTEM "https://example.com/terms.xml">
+// The server never reads files or makes requests for a document except through its resolver.
+type EntityResolver interface {
+	// Resolve returns the replacement text of the external entity with the public and system identifiers,
+	// or an error wrapping ErrEntityDenied if it mustn't be resolved
+	Resolve(publicID string, systemID string) (string, error)
+}
+
+// DenyAllResolver resolves no external entity, references to them are kept as they are
+type DenyAllResolver struct{}
+
+func (DenyAllResolver) Resolve(publicID string, systemID string) (string, error) {
+	return "", fmt.Errorf("%w: %s", ErrEntityDenied, systemID)
+}
+
+// AllowlistResolver fetches external entities whose system identifier is an http or https URL under one of
+// the Allowed prefixes, like "https://dtd.example.com/entities/", and denies all others
+// Local files are never read, and redirects are only followed within the allowlist.
+type AllowlistResolver struct {
+	Allowed []string
+	Client  *http.Client // Client fetches the entities, a client with ENTITY_FETCH_TIMEOUT if nil
+}
+
+// entityResolver resolves the external entities of ingested documents, set by initEntityResolver
+var entityResolver EntityResolver = DenyAllResolver{}
+
+// initEntityResolver resolves external entities from the allowlist of the environment, if there is one
+// The resolver of the embedding program takes precedence.
+func initEntityResolver(config Config) {
+	funcName := "initEntityResolver"
+
+	if config.EntityResolver != nil {
+		entityResolver = config.EntityResolver
+		return
+	}
+	var allowed []string
+	for _, prefix := range strings.Split(os.Getenv(ENTITY_ALLOWLIST_ENV), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix == "" {
+			continue
+		}
+		if _, err := parseEntityURL(prefix); err != nil {
+			log.Fatalf("%s: Invalid prefix %q in %s: %v", funcName, prefix, ENTITY_ALLOWLIST_ENV, err)
+		}
+		allowed = append(allowed, prefix)
+	}
+	if len(allowed) > 0 {
+		entityResolver = &AllowlistResolver{Allowed: allowed}
+	}
+}
+
+// Allows tells whether the URL is under one of the allowed prefixes
+// Scheme and host must be the same, so "https://example.com" doesn't allow "https://example.com.evil.org".
+func (resolver *AllowlistResolver) Allows(systemID string) bool {
+	target, err := parseEntityURL(systemID)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range resolver.Allowed {
+		allowed, err := parseEntityURL(prefix)
+		if err == nil && target.Scheme == allowed.Scheme && strings.EqualFold(target.Host, allowed.Host) &&
+			strings.HasPrefix(target.EscapedPath(), allowed.EscapedPath()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (resolver *AllowlistResolver) Resolve(publicID string, systemID string) (string, error) {
+	if !resolver.Allows(systemID) {
+		return "", fmt.Errorf("%w: %s is not in the allowlist", ErrEntityDenied, systemID)
+	}
+
+	client := http.Client{Timeout: ENTITY_FETCH_TIMEOUT}
+	if resolver.Client != nil {
+		client = *resolver.Client
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !resolver.Allows(req.URL.String()) {
+			return fmt.Errorf("%w: redirect to %s is not in the allowlist", ErrEntityDenied, req.URL)
+		}
+		return nil
+	}
+
+	resp, err := client.Get(systemID)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", systemID, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, ENTITY_FETCH_MAX_BYTES+1))
+	if err != nil {
+		return "", err
+	} else if len(data) > ENTITY_FETCH_MAX_BYTES {
+		return "", fmt.Errorf("%s is larger than %d bytes", systemID, ENTITY_FETCH_MAX_BYTES)
+	}
+	return string(data), nil
+}
+
+// parseEntityURL parses an absolute http or https URL without credentials
+func parseEntityURL(value string) (*url.URL, error) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.New("must be an http or https URL")
+	}
+	if parsed.User != nil {
+		return nil, errors.New("must not hold credentials")
+	}
+	return parsed, nil
+}
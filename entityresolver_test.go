@@ -0,0 +1,43 @@
+package goapp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test resolving external entities from the allowlist only
+func TestAllowlistResolver(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch r.URL.Path {
+		case "/dtd/terms.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><terms>Net 30</terms>`))
+		case "/dtd/moved.xml":
+			http.Redirect(w, r, "/private/secret.xml", http.StatusFound)
+		default:
+			w.Write([]byte("secret"))
+		}
+	}))
+	defer server.Close()
+
+	resolver := &AllowlistResolver{Allowed: []string{server.URL + "/dtd/"}}
+	require.True(t, resolver.Allows(server.URL+"/dtd/terms.xml"))
+	require.False(t, resolver.Allows(server.URL+"/private/secret.xml"))
+	require.False(t, resolver.Allows("file:///etc/passwd"))
+	require.False(t, (&AllowlistResolver{Allowed: []string{"https://example.com"}}).Allows("https://example.com.evil.org/a"))
+
+	data := `<!DOCTYPE d [<!ENTITY terms SYSTEM "` + server.URL + `/dtd/terms.xml"><!ENTITY secret SYSTEM "` + server.URL + `/private/secret.xml"><!ENTITY moved PUBLIC "-//Moved//EN" "` + server.URL + `/dtd/moved.xml">]>`
+	expanded, err := expandEntities(data+`<d a="&terms;">&terms;&terms; &secret; &moved;</d>`, resolver, ParseLimits{})
+	require.NoError(t, err)
+	require.Equal(t, data+`<d a="&terms;"><terms>Net 30</terms><terms>Net 30</terms> &secret; &moved;</d>`, expanded)
+	// Each entity is fetched once and the redirect out of the allowlist isn't followed
+	require.Equal(t, []string{"/dtd/terms.xml", "/dtd/moved.xml"}, requests)
+
+	_, err = DenyAllResolver{}.Resolve("", server.URL+"/dtd/terms.xml")
+	require.True(t, errors.Is(err, ErrEntityDenied))
+}
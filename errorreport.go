@@ -0,0 +1,227 @@
+package goapp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const (
+	SENTRY_DSN_ENV = "DOC_SENTRY_DSN" // Environment variable with the Sentry DSN errors are reported to
+
+	ERROR_KIND_PANIC     = "panic"     // Kind of errors raised by a panicking handler
+	ERROR_KIND_HTTP_5XX  = "http_5xx"  // Kind of errors answered with a 5xx status
+	ERROR_KIND_INGESTION = "ingestion" // Kind of errors raised while ingesting documents
+
+	ERROR_REPORT_MAX_BODY = 1024             // Number of bytes of a 5xx response body included in the report
+	SENTRY_TIMEOUT        = 10 * time.Second // Timeout of a request to Sentry
+)
+
+// ErrorEvent describes an error reported to the ErrorReporter
+type ErrorEvent struct {
+	Kind    string            // Kind is one of the ERROR_KIND_* constants
+	Message string            // Message is a human readable description of the error
+	Method  string            // Method is the HTTP method of the failed request, if any
+	Path    string            // Path is the URL path of the failed request, if any
+	Status  int               // Status is the HTTP status of the response, if any
+	Stack   string            // Stack is the stack trace for panics
+	Extra   map[string]string // Extra holds additional context like the source of a document
+}
+
+// ErrorReporter receives the errors which should reach the on-call engineer
+// Report must not block the caller
+type ErrorReporter interface {
+	Report(event ErrorEvent)
+}
+
+// noopReporter drops all errors, it is used when no reporter is configured
+type noopReporter struct{}
+
+func (noopReporter) Report(event ErrorEvent) {}
+
+// errorReporter is the reporter used by the server, set by initErrorReporter
+var errorReporter ErrorReporter = noopReporter{}
+
+// initErrorReporter sets up the Sentry reporter if a DSN is configured
+func initErrorReporter() {
+	funcName := "initErrorReporter"
+
+	dsn := os.Getenv(SENTRY_DSN_ENV)
+	if dsn == "" {
+		return
+	}
+
+	reporter, err := newSentryReporter(dsn)
+	if err != nil {
+		log.Fatalf("%s: Invalid %s: %v", funcName, SENTRY_DSN_ENV, err)
+	}
+	errorReporter = reporter
+}
+
+// SentryReporter sends errors to Sentry through its store API
+type SentryReporter struct {
+	StoreURL  string // StoreURL is the endpoint events are posted to
+	PublicKey string // PublicKey is the key part of the DSN
+	Client    *http.Client
+	Breaker   *CircuitBreaker // Breaker stops reporting for a while when Sentry is unreachable
+}
+
+// newSentryReporter creates a reporter from a DSN like https://key@sentry.example.com/42
+func newSentryReporter(dsn string) (*SentryReporter, error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return nil, fmt.Errorf("missing public key")
+	}
+
+	project := strings.Trim(parsed.Path, "/")
+	if project == "" {
+		return nil, fmt.Errorf("missing project ID")
+	}
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+
+	return &SentryReporter{
+		StoreURL:  fmt.Sprintf("%s://%s%s/api/%s/store/", parsed.Scheme, parsed.Host, prefix, project),
+		PublicKey: parsed.User.Username(),
+		Client:    &http.Client{Timeout: SENTRY_TIMEOUT},
+		Breaker:   newCircuitBreaker("sentry"),
+	}, nil
+}
+
+// Report sends the event to Sentry in the background
+func (reporter *SentryReporter) Report(event ErrorEvent) {
+	go func() {
+		err := reporter.Breaker.Call(func() error {
+			return reporter.send(event)
+		})
+		if err != nil {
+			log.Printf("SentryReporter: Failed to report error: %v", err)
+		}
+	}()
+}
+
+// send posts the event to Sentry
+func (reporter *SentryReporter) send(event ErrorEvent) error {
+	eventID := make([]byte, 16)
+	if _, err := rand.Read(eventID); err != nil {
+		return err
+	}
+
+	level := "error"
+	if event.Kind == ERROR_KIND_PANIC {
+		level = "fatal"
+	}
+	extra := map[string]string{}
+	for key, value := range event.Extra {
+		extra[key] = value
+	}
+	if event.Stack != "" {
+		extra["stack"] = event.Stack
+	}
+	tags := map[string]string{"kind": event.Kind}
+	if event.Status != 0 {
+		tags["status"] = fmt.Sprint(event.Status)
+	}
+
+	payload := map[string]interface{}{
+		"event_id":  hex.EncodeToString(eventID),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"level":     level,
+		"platform":  "go",
+		"logger":    "goapp",
+		"message":   event.Message,
+		"tags":      tags,
+		"extra":     extra,
+	}
+	if event.Path != "" {
+		payload["request"] = map[string]string{"method": event.Method, "url": event.Path}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", reporter.StoreURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=goapp/1.0, sentry_key=%s", reporter.PublicKey))
+
+	resp, err := reporter.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// reportErrors is a middleware reporting panics and 5xx responses
+// A panicking handler is answered with 500 instead of dropping the connection
+func reportErrors(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, Status: http.StatusOK, Capture: ERROR_REPORT_MAX_BODY}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				errorReporter.Report(ErrorEvent{
+					Kind:    ERROR_KIND_PANIC,
+					Message: fmt.Sprint(recovered),
+					Method:  r.Method,
+					Path:    r.URL.Path,
+					Status:  http.StatusInternalServerError,
+					Stack:   string(debug.Stack()),
+				})
+				log.Printf("reportErrors: Panic serving %s %s: %v", r.Method, r.URL.Path, recovered)
+				if !recorder.WroteHeader {
+					http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+				}
+			}
+		}()
+
+		next(db, recorder, r)
+
+		if recorder.Status >= 500 {
+			errorReporter.Report(ErrorEvent{
+				Kind:    ERROR_KIND_HTTP_5XX,
+				Message: strings.TrimSpace(string(recorder.Body)),
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Status:  recorder.Status,
+			})
+		}
+	}
+}
+
+// reportIngestionFailure reports a document which couldn't be ingested from source, also to chat channels
+func reportIngestionFailure(source string, err error) {
+	errorReporter.Report(ErrorEvent{
+		Kind:    ERROR_KIND_INGESTION,
+		Message: err.Error(),
+		Extra:   map[string]string{"source": source},
+	})
+	postChat(ChatEvent{
+		Type:  CHAT_EVENT_INGESTION_FAILURE,
+		Title: "Failed to ingest a document from " + source,
+		Text:  err.Error(),
+		Facts: [][2]string{{"Source", source}},
+	})
+}
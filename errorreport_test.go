@@ -0,0 +1,137 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingReporter keeps all reported events for inspection
+type recordingReporter struct {
+	mu     sync.Mutex
+	events []ErrorEvent
+}
+
+func (reporter *recordingReporter) Report(event ErrorEvent) {
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	reporter.events = append(reporter.events, event)
+}
+
+// Test reporting panics and 5xx responses
+func TestReportErrors(t *testing.T) {
+	reporter := &recordingReporter{}
+	errorReporter = reporter
+	defer func() { errorReporter = noopReporter{} }()
+
+	tests := []struct {
+		desc            string
+		handler         dbHandler
+		expectedCode    int
+		expectedKind    string
+		expectedMessage string
+	}{
+		{
+			desc: "panic",
+			handler: func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+				panic("something broke")
+			},
+			expectedCode:    http.StatusInternalServerError,
+			expectedKind:    ERROR_KIND_PANIC,
+			expectedMessage: "something broke",
+		}, {
+			desc: "server error",
+			handler: func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Failed to insert document", http.StatusInternalServerError)
+			},
+			expectedCode:    http.StatusInternalServerError,
+			expectedKind:    ERROR_KIND_HTTP_5XX,
+			expectedMessage: "Failed to insert document",
+		}, {
+			desc: "client error",
+			handler: func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "ID parameter is required", http.StatusBadRequest)
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			reporter.events = nil
+			w := httptest.NewRecorder()
+
+			reportErrors(tt.handler)(nil, w, httptest.NewRequest("GET", "/document?id=1", nil))
+
+			require.Equal(t, tt.expectedCode, w.Result().StatusCode)
+			if tt.expectedKind == "" {
+				require.Empty(t, reporter.events)
+				return
+			}
+			require.Len(t, reporter.events, 1)
+			require.Equal(t, tt.expectedKind, reporter.events[0].Kind)
+			require.Equal(t, tt.expectedMessage, reporter.events[0].Message)
+			require.Equal(t, "/document", reporter.events[0].Path)
+		})
+	}
+}
+
+// Test parsing a DSN and sending an event to Sentry
+func TestSentryReporter(t *testing.T) {
+	var received map[string]interface{}
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/42/store/", r.URL.Path)
+		auth = r.Header.Get("X-Sentry-Auth")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	reporter, err := newSentryReporter("http://public@" + server.Listener.Addr().String() + "/42")
+	require.NoError(t, err)
+
+	err = reporter.send(ErrorEvent{Kind: ERROR_KIND_INGESTION, Message: "tag pairing error", Extra: map[string]string{"source": "file:doc.xml"}})
+	require.NoError(t, err)
+	require.Contains(t, auth, "sentry_key=public")
+	require.Equal(t, "tag pairing error", received["message"])
+	require.Equal(t, "file:doc.xml", received["extra"].(map[string]interface{})["source"])
+
+	_, err = newSentryReporter("https://sentry.example.com/42")
+	require.Error(t, err)
+	_, err = newSentryReporter("https://public@sentry.example.com")
+	require.Error(t, err)
+
+	reporter, err = newSentryReporter("https://public@sentry.example.com/prefix/42")
+	require.NoError(t, err)
+	require.Equal(t, "https://sentry.example.com/prefix/api/42/store/", reporter.StoreURL)
+}
+
+// Test that ingestion failures of XML files are reported
+func TestLoadXMLFilesReportsFailures(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	reporter := &recordingReporter{}
+	errorReporter = reporter
+	defer func() { errorReporter = noopReporter{} }()
+
+	directory := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(directory, "valid.xml"), []byte(`<document><title>Test Title</title></document>`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(directory, "broken.xml"), []byte(`<document><title></document>`), 0644))
+
+	require.NoError(t, loadXMLFiles(db, directory))
+
+	require.Len(t, reporter.events, 1)
+	require.Equal(t, ERROR_KIND_INGESTION, reporter.events[0].Kind)
+	require.Contains(t, reporter.events[0].Extra["source"], "broken.xml")
+
+	_, err := getDocumentByID(db, "1")
+	require.False(t, errors.Is(err, sql.ErrNoRows))
+}
@@ -0,0 +1,81 @@
+package goapp
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	ARCHIVE_INTERVAL = time.Minute // Interval between two runs of the archiver
+)
+
+// expiryLayouts lists the accepted formats of an expiry value
+// A date without time expires at the start of that day in UTC
+var expiryLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseExpiry parses an expiry value and returns it in the stored format
+func parseExpiry(value string) (string, error) {
+	for _, layout := range expiryLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return formatExpiry(t), nil
+		}
+	}
+	return "", fmt.Errorf("invalid expiry date: %s", value)
+}
+
+// formatExpiry formats t as stored in the expires_at column
+// All values are kept in UTC so they can be compared as strings in SQL
+func formatExpiry(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// archiveExpiredDocuments moves the active documents expired at now to the archived state
+// Documents under legal hold stay active until the hold is released. It returns the number of archived documents
+func archiveExpiredDocuments(db *sql.DB, now time.Time) (int64, error) {
+	defer observeQuery("archiveExpiredDocuments", time.Now())
+
+	query := fmt.Sprintf(`
+		UPDATE %s SET %s=? WHERE %s=? AND %s IS NOT NULL AND %s<=? AND NOT %s
+	`, DB_TABLE_NAME, DB_STATE_FIELD_NAME, DB_STATE_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, heldCondition)
+	var count int64
+	err := withDBRetry(func() error {
+		result, err := db.Exec(query, DOC_STATE_ARCHIVED, DOC_STATE_ACTIVE, formatExpiry(now))
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
+	return count, err
+}
+
+// runArchiver archives expired documents every interval while this instance leads the archiver, it never returns
+func runArchiver(db *sql.DB, interval time.Duration) {
+	funcName := "runArchiver"
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if !leaderElector.Lead(db, JOB_ARCHIVER, now, 2*interval) {
+			continue
+		}
+
+		count, err := archiveExpiredDocuments(db, now)
+		if err != nil {
+			log.Printf("%s: Failed to archive expired documents: %v", funcName, err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("%s: Archived %d expired documents", funcName, count)
+			invalidateResponseCache()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package goapp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing the expiry from an element or from the root attribute
+func TestParseDocumentExpiry(t *testing.T) {
+	tests := []struct {
+		desc             string
+		msg              string
+		expectedResponse string
+		err              bool
+	}{
+		{
+			desc:             "expiry element",
+			msg:              `<document><title>Test Title</title><expiresAt>2024-07-09</expiresAt></document>`,
+			expectedResponse: "2024-07-09T00:00:00Z",
+		}, {
+			desc:             "expiry attribute",
+			msg:              `<document expires="2024-07-09T12:30:00+02:00"><title>Test Title</title></document>`,
+			expectedResponse: "2024-07-09T10:30:00Z",
+		}, {
+			desc:             "no expiry",
+			msg:              `<document><title>Test Title</title></document>`,
+			expectedResponse: "",
+		}, {
+			desc: "invalid expiry",
+			msg:  `<document><expiresAt>next week</expiresAt></document>`,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			doc, err := parseDocument(tt.msg)
+			if tt.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedResponse, doc.ExpiresAt)
+			}
+		})
+	}
+}
+
+// Test that expired documents are archived and excluded from listings
+func TestArchiveExpiredDocuments(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	now := time.Date(2024, 7, 9, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Expired", ExpiresAt: "2024-07-01T00:00:00Z"}))
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Not Expired", ExpiresAt: "2024-08-01T00:00:00Z"}))
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "No Expiry"}))
+
+	// Expired documents are hidden even before the archiver runs
+	docs, err := listDocuments(db, now, []string{DOC_STATE_ACTIVE}, "", DB_ID_FIELD_NAME)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+
+	count, err := archiveExpiredDocuments(db, now)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, DOC_STATE_ARCHIVED, doc.State)
+
+	doc, err = getDocumentByID(db, "3")
+	require.NoError(t, err)
+	require.Equal(t, DOC_STATE_ACTIVE, doc.State)
+}
+
+// Test handling /list requests
+func TestHandleListRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Expired", ExpiresAt: "2000-01-01T00:00:00Z"}))
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Test Title"}))
+
+	req := httptest.NewRequest("GET", "/list", nil)
+	w := httptest.NewRecorder()
+
+	handleRequest(db, w, req)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var docs []XMLDoc
+	require.NoError(t, json.Unmarshal(body, &docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, "Test Title", docs[0].Title)
+}
@@ -0,0 +1,149 @@
+package goapp
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EXPORT_FORMAT_CSV  = "csv"  // Format of /export writing comma-separated values
+	EXPORT_FORMAT_XLSX = "xlsx" // Format of /export writing an Excel workbook
+
+	EXPORT_DEFAULT_FIELDS = "id,title,author,createdAt,state" // Fields exported unless others are requested
+	EXPORT_SHEET_NAME     = "Documents"                       // Name of the sheet of exported workbooks
+)
+
+// exportField is a metadata field which can be exported, as text or as a number
+type exportField struct {
+	Text   func(doc *XMLDoc) string
+	Number func(doc *XMLDoc) int64
+}
+
+// exportFields are the fields /export can write by name
+var exportFields = map[string]exportField{
+	"id":          {Text: func(doc *XMLDoc) string { return doc.ID }},
+	"title":       {Text: func(doc *XMLDoc) string { return doc.Title }},
+	"description": {Text: func(doc *XMLDoc) string { return doc.Description }},
+	"author":      {Text: func(doc *XMLDoc) string { return doc.Author }},
+	"authors":     {Text: func(doc *XMLDoc) string { return strings.Join(doc.Authors, "; ") }},
+	"createdAt":   {Text: func(doc *XMLDoc) string { return doc.CreatedAt }},
+	"expiresAt":   {Text: func(doc *XMLDoc) string { return doc.ExpiresAt }},
+	"state":       {Text: func(doc *XMLDoc) string { return doc.State }},
+	"doctype":     {Text: func(doc *XMLDoc) string { return doc.Doctype }},
+	"language":    {Text: func(doc *XMLDoc) string { return doc.Language }},
+	"validation":  {Text: func(doc *XMLDoc) string { return doc.Validation.Status }},
+	"words":       {Number: func(doc *XMLDoc) int64 { return int64(doc.Stats.Words) }},
+	"characters":  {Number: func(doc *XMLDoc) int64 { return int64(doc.Stats.Characters) }},
+	"elements":    {Number: func(doc *XMLDoc) int64 { return int64(doc.Stats.Elements) }},
+	"depth":       {Number: func(doc *XMLDoc) int64 { return int64(doc.Stats.MaxDepth) }},
+	"revision":    {Number: func(doc *XMLDoc) int64 { return int64(doc.Revision) }},
+}
+
+// parseExportFields returns the field names of a comma-separated list like "id,title"
+func parseExportFields(param string) ([]string, error) {
+	if param == "" {
+		param = EXPORT_DEFAULT_FIELDS
+	}
+	fields := strings.Split(param, ",")
+	for _, field := range fields {
+		if _, ok := exportFields[field]; !ok {
+			return nil, fmt.Errorf("invalid field %s", field)
+		}
+	}
+	return fields, nil
+}
+
+// handleExportRequest answers GET /export?format=xlsx&fields=id,title with a spreadsheet of the metadata of the
+// documents selected like by /list
+func handleExportRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = EXPORT_FORMAT_CSV
+	}
+	if format != EXPORT_FORMAT_CSV && format != EXPORT_FORMAT_XLSX {
+		http.Error(w, "format must be csv or xlsx", http.StatusBadRequest)
+		return
+	}
+	fields, err := parseExportFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selection, err := parseDocumentSelection(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	loc, err := parseTimeZone(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	docs, err := selection.List(db, time.Now())
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to list documents: %v", err), err)
+		return
+	}
+	rows := make([][]XLSXCell, len(docs))
+	for i := range docs {
+		renderCreatedAt(&docs[i], loc)
+		rows[i] = make([]XLSXCell, len(fields))
+		for j, name := range fields {
+			field := exportFields[name]
+			if field.Number != nil {
+				number := field.Number(&docs[i])
+				rows[i][j] = XLSXCell{Number: &number}
+			} else {
+				rows[i][j] = XLSXCell{Text: field.Text(&docs[i])}
+			}
+		}
+	}
+
+	name := "documents-" + time.Now().UTC().Format("2006-01-02") + "." + format
+	if format == EXPORT_FORMAT_CSV {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		w.WriteHeader(http.StatusOK)
+		writer := csv.NewWriter(w)
+		writer.Write(fields)
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for j, cell := range row {
+				record[j] = csvText(cell.Text)
+				if cell.Number != nil {
+					record[j] = strconv.FormatInt(*cell.Number, 10)
+				}
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		return
+	}
+
+	// The workbook is written in memory, so a failure can still be answered with an error
+	var workbook bytes.Buffer
+	if err := writeXLSX(&workbook, EXPORT_SHEET_NAME, fields, rows); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write workbook: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", XLSX_CONTENT_TYPE)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	w.WriteHeader(http.StatusOK)
+	w.Write(workbook.Bytes())
+}
+
+// csvText keeps spreadsheet programs from running text starting like a formula, e.g. "=HYPERLINK(...)", by quoting it with '
+// Cells of workbooks are always text, they don't need it.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
@@ -0,0 +1,84 @@
+package goapp
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test exporting the metadata of documents as CSV and XLSX
+func TestHandleExportRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		"<document><title>Fish &amp; Chips</title><author>=HYPERLINK(\"x\")</author><p>one two three</p></document>",
+		"<!DOCTYPE report><report><title>Report</title></report>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		_, err = addDocument(db, *doc)
+		require.NoError(t, err)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/export?fields=id,title,author,words", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+	require.Equal(t, "id,title,author,words\n1,Fish & Chips,\"'=HYPERLINK(\"\"x\"\")\",7\n2,Report,,1\n", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/export?format=xlsx&fields=title,words&doctype=report", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, XLSX_CONTENT_TYPE, rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Header().Get("Content-Disposition"), ".xlsx")
+
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	require.NoError(t, err)
+	parts := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		reader.Close()
+		parts[file.Name] = string(data)
+
+		// Every part is well-formed XML
+		decoder := xml.NewDecoder(strings.NewReader(string(data)))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, file.Name)
+		}
+	}
+	require.Contains(t, parts, "[Content_Types].xml")
+	require.Contains(t, parts, "xl/workbook.xml")
+	require.Contains(t, parts["xl/worksheets/sheet1.xml"],
+		`<row r="1"><c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">title</t></is></c><c r="B1" s="1" t="inlineStr"><is><t xml:space="preserve">words</t></is></c></row>`+
+			`<row r="2"><c r="A2" t="inlineStr"><is><t xml:space="preserve">Report</t></is></c><c r="B2"><v>1</v></c></row></sheetData>`)
+
+	for _, path := range []string{"/export?format=pdf", "/export?fields=title,secret", "/export?state=gone"} {
+		rr = httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code, path)
+	}
+}
+
+// Test naming spreadsheet columns
+func TestXLSXColumn(t *testing.T) {
+	for index, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		require.Equal(t, expected, xlsxColumn(index))
+	}
+	require.Equal(t, "ab", xlsxText("a\x00b\x1f", 10))
+	require.Equal(t, "äö", xlsxText("äöü", 2))
+}
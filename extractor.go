@@ -0,0 +1,81 @@
+package goapp
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Extractor computes derived fields of a document, e.g. a word count or a custom ID
+// It is called by parseDocument for every element of the document in document order, after the
+// metadata is extracted, and usually stores its results in doc.Custom with doc.SetCustom.
+type Extractor func(node *Node, doc *XMLDoc)
+
+// extractors are the registered extractors in registration order
+var (
+	extractorsMu sync.RWMutex
+	extractors   []Extractor
+)
+
+// RegisterExtractor adds an extractor run on every parsed document
+// Extractors should be registered before documents are parsed, e.g. from an init function. Stored documents
+// get the fields of new extractors when they are reprocessed with /admin/reprocess, without outdated=true
+// since extractors aren't part of the parser version.
+func RegisterExtractor(extractor Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors = append(extractors, extractor)
+}
+
+// runExtractors calls the registered extractors for every element of the tree of the document
+func runExtractors(doc *XMLDoc) {
+	extractorsMu.RLock()
+	registered := extractors
+	extractorsMu.RUnlock()
+	if len(registered) == 0 || doc.Tree == nil {
+		return
+	}
+
+	var walk func(node *Node)
+	walk = func(node *Node) {
+		for _, extractor := range registered {
+			extractor(node, doc)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(doc.Tree)
+}
+
+// SetCustom sets a custom field of the document
+func (doc *XMLDoc) SetCustom(name string, value string) {
+	if doc.Custom == nil {
+		doc.Custom = map[string]string{}
+	}
+	doc.Custom[name] = value
+}
+
+// encodeCustomFields encodes custom fields as JSON for storage, empty if there are none
+func encodeCustomFields(fields map[string]string) (string, error) {
+	if len(fields) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeCustomFields decodes the content of a column encoded by encodeCustomFields
+func decodeCustomFields(data string) (map[string]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var fields map[string]string
+	err := json.Unmarshal([]byte(data), &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
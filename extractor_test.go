@@ -0,0 +1,40 @@
+package goapp
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that registered extractors see every element and their fields are stored
+func TestRegisterExtractor(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	defer func() { extractors = nil }()
+
+	var visited []string
+	RegisterExtractor(func(node *Node, doc *XMLDoc) {
+		visited = append(visited, node.Name)
+	})
+	RegisterExtractor(func(node *Node, doc *XMLDoc) {
+		if node.Name == "body" {
+			doc.SetCustom("body_words", strconv.Itoa(len(strings.Fields(node.TextContent()))))
+		}
+		if node.Parent == nil {
+			doc.SetCustom("slug", strings.ToLower(strings.ReplaceAll(doc.Title, " ", "-")))
+		}
+	})
+
+	doc, err := parseDocument("<document><title>Fish And Chips</title><body>one two <b>three</b></body></document>")
+	require.NoError(t, err)
+	require.Equal(t, []string{"document", "title", "body", "b"}, visited)
+	require.Equal(t, map[string]string{"body_words": "3", "slug": "fish-and-chips"}, doc.Custom)
+
+	id, err := addDocument(db, *doc)
+	require.NoError(t, err)
+	stored, err := getDocumentByID(db, id)
+	require.NoError(t, err)
+	require.Equal(t, doc.Custom, stored.Custom)
+}
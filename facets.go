@@ -0,0 +1,99 @@
+package goapp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	FACET_AUTHOR   = "author"   // Facet counting the documents of each author, all authors of a document included
+	FACET_YEAR     = "year"     // Facet counting the documents created in each year
+	FACET_DOCTYPE  = "doctype"  // Facet counting the documents of each DOCTYPE root element name
+	FACET_LANGUAGE = "language" // Facet counting the documents of each language
+	FACET_TAG      = "tag"      // Facet counting the documents with each tag
+)
+
+// facetValues returns the values of a facet for a document, empty if it has none
+var facetValues = map[string]func(doc *XMLDoc) []string{
+	FACET_AUTHOR: func(doc *XMLDoc) []string {
+		if len(doc.Authors) > 0 {
+			return doc.Authors
+		}
+		return []string{doc.Author}
+	},
+	FACET_YEAR: func(doc *XMLDoc) []string {
+		// Creation dates are stored as "2024-07-09" or in UTC, dates no profile could parse have no year
+		if len(doc.CreatedAt) >= 5 && doc.CreatedAt[4] == '-' && strings.Trim(doc.CreatedAt[:4], "0123456789") == "" {
+			return []string{doc.CreatedAt[:4]}
+		}
+		return nil
+	},
+	FACET_DOCTYPE: func(doc *XMLDoc) []string {
+		return []string{doc.Doctype}
+	},
+	FACET_LANGUAGE: func(doc *XMLDoc) []string {
+		return []string{doc.Language}
+	},
+	FACET_TAG: func(doc *XMLDoc) []string {
+		// Listed documents have the tags of the tag table
+		return doc.Tags
+	},
+}
+
+// FacetCount is the number of listed documents with a value of a facet
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// ListResponse is the response of /list when facets are requested
+type ListResponse struct {
+	Documents []XMLDoc
+	Facets    map[string][]FacetCount // Facets holds the counts of each requested facet by name
+}
+
+// parseFacets returns the facet names of a comma-separated list like "author,year"
+func parseFacets(param string) ([]string, error) {
+	var facets []string
+	for _, facet := range strings.Split(param, ",") {
+		if facetValues[facet] == nil {
+			return nil, fmt.Errorf("invalid facet %s", facet)
+		}
+		if !containsString(facets, facet) {
+			facets = append(facets, facet)
+		}
+	}
+	return facets, nil
+}
+
+// countFacets counts the documents of each value of the facets, most frequent values first
+// A document with a value repeated, like an author credited twice, is counted once.
+func countFacets(docs []XMLDoc, facets []string) map[string][]FacetCount {
+	result := map[string][]FacetCount{}
+	for _, facet := range facets {
+		counts := map[string]int{}
+		for i := range docs {
+			seen := map[string]bool{}
+			for _, value := range facetValues[facet](&docs[i]) {
+				if value != "" && !seen[value] {
+					seen[value] = true
+					counts[value]++
+				}
+			}
+		}
+
+		values := []FacetCount{}
+		for value, count := range counts {
+			values = append(values, FacetCount{Value: value, Count: count})
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Count != values[j].Count {
+				return values[i].Count > values[j].Count
+			}
+			return values[i].Value < values[j].Value
+		})
+		result[facet] = values
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test listing documents with facet counts
+func TestHandleListRequestFacets(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		"<document><title>A</title><author>Ann</author><author>Bob</author><creationDate>2023-05-01</creationDate></document>",
+		"<!DOCTYPE report><report><title>B</title><author>Bob</author><creationDate>2024-01-02T10:00:00Z</creationDate></report>",
+		"<!DOCTYPE report><report><title>C</title><author>Bob</author><author>Bob</author><creationDate>someday</creationDate></report>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		_, err = addDocument(db, *doc)
+		require.NoError(t, err)
+	}
+	for _, tag := range []string{"invoices", "urgent"} {
+		_, err := tagDocuments(db, documentSelection{States: []string{DOC_STATE_ACTIVE}}, tag, TAG_ACTION_ADD, false, time.Now())
+		require.NoError(t, err)
+	}
+	_, err := tagDocuments(db, documentSelection{States: []string{DOC_STATE_ACTIVE}, Filter: DB_TITLE_FIELD_NAME + "=?", FilterArgs: []interface{}{"A"}}, "urgent", TAG_ACTION_REMOVE, false, time.Now())
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/list?facets=author,year,doctype,tag,author&view=summary", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response ListResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Documents, 3)
+	require.Equal(t, map[string][]FacetCount{
+		FACET_AUTHOR:  {{Value: "Bob", Count: 3}, {Value: "Ann", Count: 1}},
+		FACET_YEAR:    {{Value: "2023", Count: 1}, {Value: "2024", Count: 1}},
+		FACET_DOCTYPE: {{Value: "report", Count: 2}},
+		FACET_TAG:     {{Value: "invoices", Count: 3}, {Value: "urgent", Count: 2}},
+	}, response.Facets)
+
+	// Without facets the documents are listed as an array
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/list?doctype=report", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var docs []XMLDoc
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+	require.Len(t, docs, 2)
+
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/list?facets=author,tags", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Equal(t, "invalid facet tags\n", rr.Body.String())
+}
@@ -0,0 +1,337 @@
+package goapp
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DB_FLAG_TABLE_NAME            = "feature_flag" // Table name of feature flag overrides in SQLite
+	DB_FLAG_NAME_FIELD_NAME       = "name"         // Field name for the name of the flag
+	DB_FLAG_DEFINITION_FIELD_NAME = "definition"   // Field name for the flag as JSON
+	DB_FLAG_UPDATED_AT_FIELD_NAME = "updated_at"   // Field name for the time the override was set
+
+	FEATURE_FLAGS_ENV = "DOC_FEATURE_FLAGS" // Environment variable with the path of a JSON file listing the feature flags
+
+	FEATURE_FLAT_TREE = "flat_tree" // Flag serving the element tree of /document as a flat list of elements with their path
+)
+
+// featureNames lists the experimental behaviors which can be gated by a flag
+var featureNames = []string{FEATURE_FLAT_TREE}
+
+// FeatureFlag turns an experimental behavior on for some tenants
+// A tenant is the source of a request: "key:api" for the API key, "key:token-{id}" for an access token,
+// or "http:{address}" for clients without credentials.
+type FeatureFlag struct {
+	Name    string
+	Enabled bool     // Enabled switches the flag off for everyone when false
+	Tenants []string `json:",omitempty"` // Tenants always get the behavior while the flag is enabled
+	Percent int      // Percent is the share of the other tenants getting the behavior, between 0 and 100
+}
+
+// FeatureFlagStatus is a flag as listed by the admin API
+type FeatureFlagStatus struct {
+	FeatureFlag
+	Overridden       bool   // Overridden is true when the flag was set with the admin API
+	UpdatedAt        string `json:",omitempty"`
+	EnabledForTenant *bool  `json:",omitempty"` // EnabledForTenant tells whether the tenant asked about gets the behavior
+}
+
+// FeatureFlags holds the flags in effect
+type FeatureFlags struct {
+	mu       sync.RWMutex
+	defaults map[string]FeatureFlag // defaults are the flags of the configuration file, or disabled flags
+	flags    map[string]FeatureFlag // flags are the defaults with the overrides of the database applied
+}
+
+// featureFlags are the flags of the server, all disabled unless configured by initFeatureFlags or the admin API
+var featureFlags = newFeatureFlags(nil)
+
+// newFeatureFlags creates the flags of every feature, using the given ones as defaults
+func newFeatureFlags(defaults []FeatureFlag) *FeatureFlags {
+	flags := &FeatureFlags{defaults: map[string]FeatureFlag{}, flags: map[string]FeatureFlag{}}
+	for _, name := range featureNames {
+		flags.defaults[name] = FeatureFlag{Name: name}
+	}
+	for _, flag := range defaults {
+		flags.defaults[flag.Name] = flag
+	}
+	for name, flag := range flags.defaults {
+		flags.flags[name] = flag
+	}
+	return flags
+}
+
+// initFeatureFlags loads the default flags from the configuration file, if any, and applies the overrides of the database
+func initFeatureFlags(db *sql.DB) {
+	funcName := "initFeatureFlags"
+
+	if path := os.Getenv(FEATURE_FLAGS_ENV); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatalf("%s: Failed to read %s: %v", funcName, FEATURE_FLAGS_ENV, err)
+		}
+		flags, err := parseFeatureFlags(data)
+		if err != nil {
+			log.Fatalf("%s: Invalid feature flags in %s: %v", funcName, path, err)
+		}
+		featureFlags = newFeatureFlags(flags)
+	}
+	if err := featureFlags.Reload(db); err != nil {
+		log.Fatalf("%s: Failed to load feature flags: %v", funcName, err)
+	}
+}
+
+// parseFeatureFlags parses a JSON list of feature flags and checks them
+func parseFeatureFlags(data []byte) ([]FeatureFlag, error) {
+	var flags []FeatureFlag
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return nil, err
+	}
+	for _, flag := range flags {
+		if err := flag.check(); err != nil {
+			return nil, err
+		}
+	}
+	return flags, nil
+}
+
+// check reports an unknown feature or an invalid rollout
+func (flag FeatureFlag) check() error {
+	known := false
+	for _, name := range featureNames {
+		known = known || name == flag.Name
+	}
+	if !known {
+		return fmt.Errorf("unknown feature %q", flag.Name)
+	}
+	if flag.Percent < 0 || flag.Percent > 100 {
+		return fmt.Errorf("flag %s: percent must be between 0 and 100", flag.Name)
+	}
+	return nil
+}
+
+// EnabledFor reports whether the tenant gets the behavior of the flag
+// Tenants are put in a percentage bucket by a hash of the flag and tenant, so a tenant keeps
+// the behavior while the rollout grows.
+func (flag FeatureFlag) EnabledFor(tenant string) bool {
+	if !flag.Enabled {
+		return false
+	}
+	for _, listed := range flag.Tenants {
+		if listed == tenant {
+			return true
+		}
+	}
+	sum := sha256.Sum256([]byte(flag.Name + "\n" + tenant))
+	return int(binary.BigEndian.Uint32(sum[:4])%100) < flag.Percent
+}
+
+// Flag returns the flag of a feature in effect
+func (flags *FeatureFlags) Flag(name string) FeatureFlag {
+	flags.mu.RLock()
+	defer flags.mu.RUnlock()
+	return flags.flags[name]
+}
+
+// Reload applies the overrides of the database and reverts the flags without one to their default
+func (flags *FeatureFlags) Reload(db *sql.DB) error {
+	funcName := "FeatureFlags.Reload"
+
+	overrides, err := listFlagOverrides(db)
+	if err != nil {
+		return err
+	}
+
+	flags.mu.Lock()
+	defer flags.mu.Unlock()
+	for name, flag := range flags.defaults {
+		if override, ok := overrides[name]; ok {
+			if err := override.Flag.check(); err != nil {
+				log.Printf("%s: Ignoring invalid override of %s: %v", funcName, name, err)
+			} else {
+				flag = override.Flag
+			}
+		}
+		flags.flags[name] = flag
+	}
+	return nil
+}
+
+// flagOverride is a flag stored by the admin API
+type flagOverride struct {
+	Flag      FeatureFlag
+	UpdatedAt string
+}
+
+// createFlagTable creates the feature flag table if not exists
+func createFlagTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL PRIMARY KEY,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL
+	);
+`, DB_FLAG_TABLE_NAME, DB_FLAG_NAME_FIELD_NAME, DB_FLAG_DEFINITION_FIELD_NAME, DB_FLAG_UPDATED_AT_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// listFlagOverrides returns the stored flags by name
+// Flags which can't be decoded are logged and left out.
+func listFlagOverrides(db *sql.DB) (map[string]flagOverride, error) {
+	defer observeQuery("listFlagOverrides", time.Now())
+	funcName := "listFlagOverrides"
+
+	query := fmt.Sprintf("SELECT %s, %s, %s FROM %s", DB_FLAG_NAME_FIELD_NAME, DB_FLAG_DEFINITION_FIELD_NAME, DB_FLAG_UPDATED_AT_FIELD_NAME, DB_FLAG_TABLE_NAME)
+	var overrides map[string]flagOverride
+	err := withDBRetry(func() error {
+		overrides = map[string]flagOverride{}
+		rows, err := db.Query(query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name, definition string
+			var override flagOverride
+			if err := rows.Scan(&name, &definition, &override.UpdatedAt); err != nil {
+				return err
+			}
+			if err := json.Unmarshal([]byte(definition), &override.Flag); err != nil {
+				log.Printf("%s: Ignoring flag %s: %v", funcName, name, err)
+				continue
+			}
+			overrides[name] = override
+		}
+		return rows.Err()
+	})
+	return overrides, err
+}
+
+// saveFlagOverride stores a flag set with the admin API
+func saveFlagOverride(db *sql.DB, flag FeatureFlag, now time.Time) error {
+	defer observeQuery("saveFlagOverride", time.Now())
+
+	definition, err := json.Marshal(flag)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s) VALUES (?, ?, ?)
+		ON CONFLICT(%[2]s) DO UPDATE SET %[3]s=excluded.%[3]s, %[4]s=excluded.%[4]s
+	`, DB_FLAG_TABLE_NAME, DB_FLAG_NAME_FIELD_NAME, DB_FLAG_DEFINITION_FIELD_NAME, DB_FLAG_UPDATED_AT_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, flag.Name, string(definition), formatExpiry(now))
+		return err
+	})
+}
+
+// deleteFlagOverride removes the stored flag
+func deleteFlagOverride(db *sql.DB, name string) error {
+	defer observeQuery("deleteFlagOverride", time.Now())
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s=?", DB_FLAG_TABLE_NAME, DB_FLAG_NAME_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, name)
+		return err
+	})
+}
+
+// requestFeature reports whether the tenant of the request gets the behavior of the feature
+func requestFeature(db *sql.DB, r *http.Request, name string) bool {
+	flag := featureFlags.Flag(name)
+	// The tenant of tokens is looked up in the database, which disabled flags don't need
+	if !flag.Enabled {
+		return false
+	}
+	return flag.EnabledFor(requestSource(db, r))
+}
+
+// handleFlagsRequest lists the feature flags on GET, with ?tenant= telling whether a tenant gets each behavior,
+// sets a flag from a JSON object on PUT, and reverts one to its default on DELETE with ?name=
+func handleFlagsRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	funcName := "handleFlagsRequest"
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var flag FeatureFlag
+		if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := flag.check(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := saveFlagOverride(db, flag, time.Now()); err != nil {
+			httpStoreError(w, "Failed to save flag", err)
+			return
+		}
+		log.Printf("%s: Set flag %s enabled=%t percent=%d tenants=%v", funcName, flag.Name, flag.Enabled, flag.Percent, flag.Tenants)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if err := (FeatureFlag{Name: name}).check(); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := deleteFlagOverride(db, name); err != nil {
+			httpStoreError(w, "Failed to reset flag", err)
+			return
+		}
+		log.Printf("%s: Reset flag %s", funcName, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := featureFlags.Reload(db); err != nil {
+		httpStoreError(w, "Failed to load flags", err)
+		return
+	}
+	overrides, err := listFlagOverrides(db)
+	if err != nil {
+		httpStoreError(w, "Failed to load flags", err)
+		return
+	}
+
+	tenant := r.URL.Query().Get("tenant")
+	statuses := []FeatureFlagStatus{}
+	for _, name := range featureNames {
+		status := FeatureFlagStatus{FeatureFlag: featureFlags.Flag(name)}
+		if override, ok := overrides[name]; ok {
+			status.Overridden = true
+			status.UpdatedAt = override.UpdatedAt
+		}
+		if tenant != "" {
+			enabled := status.EnabledFor(tenant)
+			status.EnabledForTenant = &enabled
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(statuses)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,142 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing and checking the flags of the configuration file
+func TestParseFeatureFlags(t *testing.T) {
+	flags, err := parseFeatureFlags([]byte(`[{"Name": "flat_tree", "Enabled": true, "Tenants": ["key:token-3"], "Percent": 10}]`))
+	require.NoError(t, err)
+	require.Equal(t, []FeatureFlag{{Name: FEATURE_FLAT_TREE, Enabled: true, Tenants: []string{"key:token-3"}, Percent: 10}}, flags)
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{desc: "unknown feature", data: `[{"Name": "search_v2", "Enabled": true}]`, expected: `unknown feature "search_v2"`},
+		{desc: "invalid percent", data: `[{"Name": "flat_tree", "Percent": 101}]`, expected: "flag flat_tree: percent must be between 0 and 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseFeatureFlags([]byte(tt.data))
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+// Test rolling a flag out to listed tenants and a stable share of the others
+func TestFeatureFlagEnabledFor(t *testing.T) {
+	flag := FeatureFlag{Name: FEATURE_FLAT_TREE, Tenants: []string{"key:token-3"}, Percent: 100}
+	require.False(t, flag.EnabledFor("key:token-3"))
+
+	flag.Enabled = true
+	flag.Percent = 0
+	require.True(t, flag.EnabledFor("key:token-3"))
+	require.False(t, flag.EnabledFor("key:token-4"))
+
+	// About the given share of tenants gets the behavior, and keeps it when the rollout grows
+	flag.Tenants = nil
+	enabled := map[string]bool{}
+	for _, percent := range []int{20, 50} {
+		flag.Percent = percent
+		count := 0
+		for i := 0; i < 1000; i++ {
+			tenant := fmt.Sprintf("http:10.0.%d.%d", i/256, i%256)
+			if flag.EnabledFor(tenant) {
+				count++
+				enabled[tenant] = true
+			} else {
+				require.False(t, enabled[tenant], "%s lost the behavior", tenant)
+			}
+		}
+		require.InDelta(t, flag.Percent*10, count, 50)
+	}
+}
+
+// Test setting, listing and resetting flags with /admin/flags
+func TestHandleFlagsRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(previous *FeatureFlags) { featureFlags = previous }(featureFlags)
+	featureFlags = newFeatureFlags(nil)
+
+	tests := []struct {
+		desc     string
+		method   string
+		target   string
+		body     string
+		status   int
+		expected []FeatureFlagStatus
+	}{
+		{desc: "defaults", method: "GET", target: "/admin/flags", status: http.StatusOK, expected: []FeatureFlagStatus{{FeatureFlag: FeatureFlag{Name: FEATURE_FLAT_TREE}}}},
+		{desc: "set", method: "PUT", target: "/admin/flags", body: `{"Name": "flat_tree", "Enabled": true, "Tenants": ["key:token-3"]}`, status: http.StatusOK, expected: []FeatureFlagStatus{{FeatureFlag: FeatureFlag{Name: FEATURE_FLAT_TREE, Enabled: true, Tenants: []string{"key:token-3"}}, Overridden: true}}},
+		{desc: "tenant", method: "GET", target: "/admin/flags?tenant=key:token-3", status: http.StatusOK, expected: []FeatureFlagStatus{{FeatureFlag: FeatureFlag{Name: FEATURE_FLAT_TREE, Enabled: true, Tenants: []string{"key:token-3"}}, Overridden: true, EnabledForTenant: &[]bool{true}[0]}}},
+		{desc: "unknown", method: "PUT", target: "/admin/flags", body: `{"Name": "search_v2", "Enabled": true}`, status: http.StatusBadRequest},
+		{desc: "reset", method: "DELETE", target: "/admin/flags?name=flat_tree", status: http.StatusOK, expected: []FeatureFlagStatus{{FeatureFlag: FeatureFlag{Name: FEATURE_FLAT_TREE}}}},
+		{desc: "reset unknown", method: "DELETE", target: "/admin/flags?name=search_v2", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handleFlagsRequest(db, w, req)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var statuses []FeatureFlagStatus
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &statuses))
+			for i := range statuses {
+				statuses[i].UpdatedAt = ""
+			}
+			require.Equal(t, tt.expected, statuses)
+		})
+	}
+}
+
+// Test serving the flat tree format to the tenants of the flag only
+func TestHandleDocumentRequestFlatTree(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(previous *FeatureFlags) { featureFlags = previous }(featureFlags)
+	featureFlags = newFeatureFlags([]FeatureFlag{{Name: FEATURE_FLAT_TREE, Enabled: true, Tenants: []string{"http:192.0.2.1"}}})
+
+	doc, err := parseDocument(`<order id="1"><item sku="A">One</item><item sku="B">Two</item></order>`)
+	require.NoError(t, err)
+	_, err = addDocument(db, *doc)
+	require.NoError(t, err)
+
+	// httptest requests come from 192.0.2.1
+	w := httptest.NewRecorder()
+	handleDocumentRequest(db, w, httptest.NewRequest("GET", "/document?id=1", nil))
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var response XMLDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Nil(t, response.Tree)
+	require.Equal(t, []FlatElement{
+		{Path: "/order", Attrs: map[string]string{"id": "1"}},
+		{Path: "/order/item[1]", Attrs: map[string]string{"sku": "A"}, Text: "One"},
+		{Path: "/order/item[2]", Attrs: map[string]string{"sku": "B"}, Text: "Two"},
+	}, response.Elements)
+
+	req := httptest.NewRequest("GET", "/document?id=1", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	w = httptest.NewRecorder()
+	handleDocumentRequest(db, w, req)
+	response = XMLDoc{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NotNil(t, response.Tree)
+	require.Empty(t, response.Elements)
+}
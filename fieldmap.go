@@ -0,0 +1,198 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const FIELD_MAPPINGS_ENV = "DOC_FIELD_MAPPINGS" // Environment variable with the path of a JSON file mapping metadata fields to elements
+
+// FieldMappings maps metadata fields like "title" to the element names or paths holding them
+// The rules of a field are tried in order and the first one selecting a non-empty value wins.
+// A rule starting with '/' is a path of the XPath subset of /query, e.g. "/feed/meta/@published",
+// other rules are element names matched like doc.Element, e.g. "headline" or "dc:creator".
+type FieldMappings map[string][]string
+
+// metadataFields lists the metadata fields which can be mapped to other elements
+var metadataFields = map[string]bool{
+	XML_TITLE_FIELD:       true,
+	XML_DESCRIPTION_FIELD: true,
+	XML_AUTHOR_FIELD:      true,
+	XML_CREATEDAT_FIELD:   true,
+	XML_EXPIRESAT_FIELD:   true,
+}
+
+// fieldMappings holds the mappings of the server, nil to read every field from the element of its name
+var fieldMappings FieldMappings
+
+// initFieldMappings loads the field mappings from the file named by the environment
+// The mappings are part of the ruleset of the parser version, so changing them marks stored documents outdated.
+func initFieldMappings() {
+	funcName := "initFieldMappings"
+
+	path := os.Getenv(FIELD_MAPPINGS_ENV)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s: %v", funcName, FIELD_MAPPINGS_ENV, err)
+	}
+	mappings, err := parseFieldMappings(data)
+	if err != nil {
+		log.Fatalf("%s: Invalid field mappings in %s: %v", funcName, path, err)
+	}
+	fieldMappings = mappings
+	parserVersion = formatParserVersion(PARSER_VERSION, append(parserRules, mappings.rules()...))
+}
+
+// parseFieldMappings parses a JSON object of field mappings and checks their fields and rules
+func parseFieldMappings(data []byte) (FieldMappings, error) {
+	var mappings FieldMappings
+	if err := json.Unmarshal(data, &mappings); err != nil {
+		return nil, err
+	}
+	for field, rules := range mappings {
+		if !metadataFields[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("field %s needs an element", field)
+		}
+		for _, rule := range rules {
+			if err := checkFieldRule(rule); err != nil {
+				return nil, fmt.Errorf("field %s: %v", field, err)
+			}
+		}
+	}
+	return mappings, nil
+}
+
+// checkFieldRule returns why a rule of a field mapping is invalid, or nil
+func checkFieldRule(rule string) error {
+	if rule == "" {
+		return fmt.Errorf("empty element name")
+	}
+	if isFieldPath(rule) {
+		_, err := parseXPath(rule)
+		return err
+	}
+	return nil
+}
+
+// isFieldPath reports whether a rule of a field mapping is a path rather than an element name
+func isFieldPath(rule string) bool {
+	return strings.HasPrefix(rule, "/")
+}
+
+// rules returns the mappings as sorted lines for the parser version
+func (mappings FieldMappings) rules() []string {
+	var lines []string
+	for field, rules := range mappings {
+		lines = append(lines, field+"="+strings.Join(rules, "|"))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// fieldRules returns the rules each metadata field is extracted with
+// Fields are read from the element of their name unless the server or the tenant maps them elsewhere,
+// and the mapping of the tenant wins over the one of the server.
+func fieldRules(options ParseOptions) map[string][]string {
+	rules := map[string][]string{}
+	for field := range metadataFields {
+		rules[field] = []string{field}
+	}
+	for field, mapped := range fieldMappings {
+		rules[field] = mapped
+	}
+	for field, element := range options.Fields {
+		rules[field] = []string{element}
+	}
+	return rules
+}
+
+// mixedContents returns by index the text content of the elements at paths which hold child elements, so
+// metadata like <description>The <b>quick</b> fox</description> is read as "The quick fox" instead of markup
+// Elements without child elements keep their text as parseXMLElement reads it.
+func mixedContents(root *Node, paths []string) map[int]string {
+	nodes := map[string]*Node{}
+	var walk func(node *Node, path string)
+	walk = func(node *Node, path string) {
+		nodes[path] = node
+		counts := map[string]int{}
+		for _, child := range node.Children {
+			counts[child.Name]++
+			walk(child, fmt.Sprintf("%s/%s[%d]", path, child.Name, counts[child.Name]))
+		}
+	}
+	if root != nil {
+		walk(root, "/"+root.Name)
+	}
+
+	contents := map[int]string{}
+	for i, path := range paths {
+		if node, ok := nodes[path]; ok && len(node.Children) > 0 {
+			contents[i] = node.TextContent()
+		}
+	}
+	return contents
+}
+
+// extractFields returns the non-empty values of the first rule of each field which selects any
+// Element names are looked up in the elements of xmlDataArr in the namespaces of options, with the texts of
+// contents for elements holding child elements, paths in the tree of doc.
+func extractFields(doc *XMLDoc, xmlDataArr []string, contents map[int]string, rules map[string][]string, options ParseOptions) map[string][]string {
+	namespaces := documentNamespaces(xmlDataArr)
+	// Metadata elements are matched by local name, so attributes like <title lang="en"> and
+	// namespace prefixes like <dc:title> don't hide them, unless the rule has a prefix itself
+	byName := map[string][]string{}
+	for i, str := range xmlDataArr {
+		// Language variants such as <title xml:lang="fr"> are kept apart from the untagged metadata
+		if _, ok := parseLangVariant(str); ok {
+			continue
+		}
+		element, ok := parseXMLElement(str)
+		if content, mixed := contents[i]; mixed {
+			element.Text = content
+		}
+		if !ok || element.Text == "" {
+			continue
+		}
+		element.resolveNamespace(namespaces)
+		if !options.inNamespaces(element) {
+			continue
+		}
+		byName[element.Local] = append(byName[element.Local], element.Text)
+		if element.Name != element.Local {
+			byName[element.Name] = append(byName[element.Name], element.Text)
+		}
+	}
+
+	values := map[string][]string{}
+	for field, fieldRules := range rules {
+		for _, rule := range fieldRules {
+			var selected []string
+			if isFieldPath(rule) {
+				found, _ := doc.Query(rule)
+				for _, value := range found {
+					if value != "" {
+						selected = append(selected, value)
+					}
+				}
+			} else {
+				selected = byName[rule]
+			}
+			if len(selected) > 0 {
+				values[field] = selected
+				break
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,77 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing and checking the field mappings of the configuration file
+func TestParseFieldMappings(t *testing.T) {
+	mappings, err := parseFieldMappings([]byte(`{"title": ["headline", "title"], "creationDate": ["/feed/meta/@published"]}`))
+	require.NoError(t, err)
+	require.Equal(t, FieldMappings{XML_TITLE_FIELD: {"headline", "title"}, XML_CREATEDAT_FIELD: {"/feed/meta/@published"}}, mappings)
+	require.Equal(t, []string{"creationDate=/feed/meta/@published", "title=headline|title"}, mappings.rules())
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{desc: "unknown field", data: `{"summary": ["abstract"]}`, expected: `unknown field "summary"`},
+		{desc: "no rules", data: `{"title": []}`, expected: "field title needs an element"},
+		{desc: "empty name", data: `{"author": [""]}`, expected: "field author: empty element name"},
+		{desc: "invalid path", data: `{"author": ["/feed//"]}`, expected: "field author: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseFieldMappings([]byte(tt.data))
+			require.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+// Test extracting the metadata of feeds with their own conventions
+func TestParseDocumentFieldMappings(t *testing.T) {
+	defer func(previous FieldMappings) { fieldMappings = previous }(fieldMappings)
+	fieldMappings = FieldMappings{
+		XML_TITLE_FIELD:     {"headline", "title"},
+		XML_AUTHOR_FIELD:    {"byline", "dc:creator"},
+		XML_CREATEDAT_FIELD: {"/story/meta/@published"},
+	}
+
+	tests := []struct {
+		desc     string
+		data     string
+		options  ParseOptions
+		expected XMLDoc
+	}{
+		{
+			desc:     "mapped elements",
+			data:     `<story><meta published="2024-07-09"></meta><headline>Rates rise</headline><title>Economy</title><byline>Ada</byline><byline>Charles</byline></story>`,
+			expected: XMLDoc{Title: "Rates rise", Author: "Ada", Authors: []string{"Ada", "Charles"}, CreatedAt: "2024-07-09"},
+		},
+		{
+			desc:     "fallback rules",
+			data:     `<story xmlns:dc="http://purl.org/dc/elements/1.1/"><headline/><title>Economy</title><dc:creator>Ada</dc:creator><creator>Unrelated</creator></story>`,
+			expected: XMLDoc{Title: "Economy", Author: "Ada", Authors: []string{"Ada"}},
+		},
+		{
+			desc:     "tenant wins",
+			data:     `<story><headline>Rates rise</headline><teaser>Short</teaser><summary>Rates rise again</summary></story>`,
+			options:  ParseOptions{Fields: map[string]string{XML_TITLE_FIELD: "/story/summary", XML_DESCRIPTION_FIELD: "teaser"}},
+			expected: XMLDoc{Title: "Rates rise again", Description: "Short"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			doc, err := parseDocumentWithOptions(tt.data, tt.options)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected.Title, doc.Title)
+			require.Equal(t, tt.expected.Description, doc.Description)
+			require.Equal(t, tt.expected.Author, doc.Author)
+			require.Equal(t, tt.expected.Authors, doc.Authors)
+			require.Equal(t, tt.expected.CreatedAt, doc.CreatedAt)
+		})
+	}
+}
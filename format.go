@@ -0,0 +1,239 @@
+package goapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const FORMAT_DEFAULT_INDENT = "  " // Indentation of /format and the format command if none is given
+
+const (
+	markupElement = iota // markupElement is an element with its children
+	markupText           // markupText is character data between markup
+	markupCDATA          // markupCDATA is a CDATA section
+	markupOther          // markupOther is a comment, processing instruction or DOCTYPE
+)
+
+// markupNode is a piece of XML as it was written, for formatting it without changing its content
+type markupNode struct {
+	kind     int
+	source   string // source is the markup or text as written, the whole element for elements
+	start    string // start is the start tag of elements
+	end      string // end is the end tag of elements, empty for empty-element tags
+	children []*markupNode
+	mixed    bool // mixed is set for elements with text besides their children, whose whitespace is content
+	preserve bool // preserve is set for elements under xml:space="preserve"
+}
+
+// isComment reports whether the node is a comment
+func (node *markupNode) isComment() bool {
+	return node.kind == markupOther && strings.HasPrefix(node.source, COMMENT_START)
+}
+
+// isBlank reports whether the node is whitespace between markup
+func (node *markupNode) isBlank() bool {
+	return node.kind == markupText && strings.Trim(node.source, XML_WHITESPACE) == ""
+}
+
+// parseMarkup splits data into its top-level nodes, checking that the tags pair up
+// Errors are *ParseError with the position of the error in data
+func parseMarkup(data string) ([]*markupNode, error) {
+	root := &markupNode{kind: markupElement}
+	stack := []*markupNode{root}
+	offsets := []int{0} // offsets are the positions of the start tags of the open elements
+	preserves := []bool{false}
+
+	for i := 0; i < len(data); {
+		parent := stack[len(stack)-1]
+		if data[i] != '<' {
+			end := strings.IndexByte(data[i:], '<')
+			if end < 0 {
+				end = len(data)
+			} else {
+				end += i
+			}
+			node := &markupNode{kind: markupText, source: data[i:end]}
+			if !node.isBlank() {
+				if len(stack) == 1 {
+					return nil, newParseError(data, i, "text outside of the root element")
+				}
+				parent.mixed = true
+			}
+			parent.children = append(parent.children, node)
+			i = end
+			continue
+		}
+
+		if end := sectionEnd(data, i); end < 0 {
+			return nil, newParseError(data, i, sectionError(data, i))
+		} else if end > 0 {
+			node := &markupNode{kind: markupOther, source: data[i:end]}
+			if strings.HasPrefix(node.source, CDATA_START) {
+				if len(stack) == 1 {
+					return nil, newParseError(data, i, "text outside of the root element")
+				}
+				node.kind = markupCDATA
+				parent.mixed = true
+			}
+			parent.children = append(parent.children, node)
+			i = end
+			continue
+		}
+
+		// A '>' inside a quoted attribute value doesn't end the tag
+		end := tagEnd(data, i, "<>")
+		if end < 0 || data[end] == '<' {
+			return nil, newParseError(data, i, "tag pairing error")
+		}
+		end++
+		tag := data[i:end]
+
+		switch {
+		case strings.HasPrefix(tag, "</"):
+			if len(stack) == 1 {
+				return nil, newParseError(data, i, "no opening tag error: no opening tag")
+			}
+			name, _ := splitTag(parent.start)
+			if strings.TrimSpace(tag[2:len(tag)-1]) != name {
+				return nil, newParseError(data, i, "unmatched closing tag error: "+parent.start+" "+tag)
+			}
+			parent.end = tag
+			parent.source = data[offsets[len(offsets)-1]:end]
+			stack, offsets, preserves = stack[:len(stack)-1], offsets[:len(offsets)-1], preserves[:len(preserves)-1]
+		case strings.HasPrefix(tag, "<!"):
+			// Other declarations like <!ELEMENT> aren't elements
+			parent.children = append(parent.children, &markupNode{kind: markupOther, source: tag})
+		default:
+			if name, _ := splitTag(tag); name == "" {
+				return nil, newParseError(data, i, "empty tag error: "+tag)
+			}
+			node := &markupNode{kind: markupElement, source: tag, start: tag, preserve: xmlSpacePreserve(tag, preserves[len(preserves)-1])}
+			parent.children = append(parent.children, node)
+			if !strings.HasSuffix(tag, "/>") {
+				stack, offsets, preserves = append(stack, node), append(offsets, i), append(preserves, node.preserve)
+			}
+		}
+		i = end
+	}
+
+	if len(stack) > 1 {
+		name, _ := splitTag(stack[len(stack)-1].start)
+		return nil, newParseError(data, offsets[len(offsets)-1], fmt.Sprintf("unclosed tag error: <%s>", name))
+	}
+	return root.children, nil
+}
+
+// FormatXML indents the markup of data, one element, comment or processing instruction per line and each
+// level indented by indent, e.g. "  "
+// Elements with text besides their children, without child elements or under xml:space="preserve" are
+// written as they are, so formatting doesn't change the text of the document. Errors are *ParseError.
+func FormatXML(data string, indent string) (string, error) {
+	nodes, err := parseMarkup(data)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	for _, node := range nodes {
+		if !node.isBlank() {
+			node.writeFormatted(&out, indent, 0)
+			out.WriteString("\n")
+		}
+	}
+	return out.String(), nil
+}
+
+func (node *markupNode) writeFormatted(out *strings.Builder, indent string, depth int) {
+	nested := false
+	for _, child := range node.children {
+		nested = nested || child.kind == markupElement
+	}
+	if node.kind != markupElement || node.mixed || node.preserve || !nested {
+		out.WriteString(node.source)
+		return
+	}
+
+	out.WriteString(node.start)
+	// Whitespace between the children is replaced by the indentation
+	for _, child := range node.children {
+		if !child.isBlank() {
+			out.WriteString("\n" + strings.Repeat(indent, depth+1))
+			child.writeFormatted(out, indent, depth+1)
+		}
+	}
+	out.WriteString("\n" + strings.Repeat(indent, depth) + node.end)
+}
+
+// MinifyXML removes the comments of data and the whitespace between its markup
+// Whitespace in elements with text besides their children or under xml:space="preserve" is content and kept.
+// Errors are *ParseError.
+func MinifyXML(data string) (string, error) {
+	nodes, err := parseMarkup(data)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	for _, node := range nodes {
+		node.writeMinified(&out, false)
+	}
+	return out.String(), nil
+}
+
+func (node *markupNode) writeMinified(out *strings.Builder, keepBlanks bool) {
+	switch {
+	case node.isComment(), node.isBlank() && !keepBlanks:
+		return
+	case node.kind != markupElement:
+		out.WriteString(node.source)
+		return
+	}
+
+	out.WriteString(node.start)
+	for _, child := range node.children {
+		child.writeMinified(out, node.mixed || node.preserve)
+	}
+	out.WriteString(node.end)
+}
+
+// handleFormatRequest returns the XML of the request body indented, e.g. /format?indent=4, or minified with /format?minify=true
+func handleFormatRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	indent := FORMAT_DEFAULT_INDENT
+	if param := r.URL.Query().Get("indent"); param != "" {
+		var err error
+		if indent, err = parseIndent(param); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	xmlData, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	var result string
+	if r.URL.Query().Get("minify") == "true" {
+		result, err = MinifyXML(string(xmlData))
+	} else {
+		result, err = FormatXML(string(xmlData), indent)
+	}
+	var parseError *ParseError
+	if errors.As(err, &parseError) {
+		httpParseError(w, "Failed to format document", parseError)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to format document: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(result))
+}
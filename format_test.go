@@ -0,0 +1,107 @@
+package goapp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const formatTestXML = `<?xml version="1.0"?>
+<!DOCTYPE order [<!ENTITY shop "Fish &amp; Chips">]>
+<order id="7" note="a > b"><!-- items -->
+      <item><name>&shop;</name><p>The <b>quick</b> fox</p>
+   <code xml:space="preserve">  a
+  b </code><empty/><script><![CDATA[if (a < b) {}]]></script></item>
+</order>`
+
+// Test indenting XML without changing its text
+func TestFormatXML(t *testing.T) {
+	formatted, err := FormatXML(formatTestXML, "  ")
+	require.NoError(t, err)
+	require.Equal(t, `<?xml version="1.0"?>
+<!DOCTYPE order [<!ENTITY shop "Fish &amp; Chips">]>
+<order id="7" note="a > b">
+  <!-- items -->
+  <item>
+    <name>&shop;</name>
+    <p>The <b>quick</b> fox</p>
+    <code xml:space="preserve">  a
+  b </code>
+    <empty/>
+    <script><![CDATA[if (a < b) {}]]></script>
+  </item>
+</order>
+`, formatted)
+
+	// Formatting again changes nothing
+	again, err := FormatXML(formatted, "  ")
+	require.NoError(t, err)
+	require.Equal(t, formatted, again)
+}
+
+// Test removing comments and whitespace between markup
+func TestMinifyXML(t *testing.T) {
+	minified, err := MinifyXML(formatTestXML)
+	require.NoError(t, err)
+	require.Equal(t, `<?xml version="1.0"?><!DOCTYPE order [<!ENTITY shop "Fish &amp; Chips">]><order id="7" note="a > b"><item><name>&shop;</name><p>The <b>quick</b> fox</p><code xml:space="preserve">  a
+  b </code><empty/><script><![CDATA[if (a < b) {}]]></script></item></order>`, minified)
+
+	formatted, err := FormatXML(minified, "\t")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(formatted, "<?xml version=\"1.0\"?>\n<!DOCTYPE"))
+}
+
+// Test that malformed XML is rejected with the position of the error
+func TestFormatXMLErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		msg  string
+	}{
+		{data: "<a><b></a>", msg: "unmatched closing tag error: <b> </a>"},
+		{data: "<a>\n<b>", msg: "unclosed tag error: <b>"},
+		{data: "</a>", msg: "no opening tag error: no opening tag"},
+		{data: "text<a/>", msg: "text outside of the root element"},
+		{data: "<a><!-- x</a>", msg: "unterminated CDATA section, comment or processing instruction"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			_, err := FormatXML(tt.data, "  ")
+			var parseError *ParseError
+			require.True(t, errors.As(err, &parseError))
+			require.Equal(t, tt.msg, parseError.Msg)
+		})
+	}
+}
+
+// Test the formatting endpoint
+func TestHandleFormatRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		path     string
+		body     string
+		expected int
+		result   string
+	}{
+		{path: "/format", body: "<a> <b>1</b></a>", expected: http.StatusOK, result: "<a>\n  <b>1</b>\n</a>\n"},
+		{path: "/format?indent=tab", body: "<a> <b>1</b></a>", expected: http.StatusOK, result: "<a>\n\t<b>1</b>\n</a>\n"},
+		{path: "/format?minify=true", body: "<a>\n  <b>1</b>\n</a>\n", expected: http.StatusOK, result: "<a><b>1</b></a>"},
+		{path: "/format?indent=x", body: "<a/>", expected: http.StatusBadRequest},
+		{path: "/format", body: "<a>", expected: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleRequest(db, rr, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			require.Equal(t, tt.expected, rr.Code)
+			if tt.result != "" {
+				require.Equal(t, tt.result, rr.Body.String())
+			}
+		})
+	}
+}
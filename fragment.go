@@ -0,0 +1,25 @@
+package goapp
+
+import "strings"
+
+const FRAGMENT_ROOT = "fragment" // Element wrapped around XML fragments, which may have several or no root elements
+
+// wrapFragment wraps the content of data after its prolog in FRAGMENT_ROOT, so it has a single root element
+func wrapFragment(data string) string {
+	data = strings.TrimLeft(data, XML_WHITESPACE)
+	prolog, content := splitProlog(data)
+	// Text without elements isn't a prolog
+	if content == "" {
+		prolog, content = "", data
+	}
+	return prolog + "<" + FRAGMENT_ROOT + ">" + content + "</" + FRAGMENT_ROOT + ">"
+}
+
+// ParseFragment parses content without a single root element, like metadata snippets such as
+// <title>a</title><author>b</author>
+// The content is wrapped in a FRAGMENT_ROOT element, which is the root of the XMLData and Tree of the document.
+func ParseFragment(data string, opts ...ParseOption) (*XMLDoc, error) {
+	options := newParseOptions(opts...)
+	options.Fragment = true
+	return parseDocumentWithOptions(data, options)
+}
@@ -0,0 +1,50 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing content without a single root element
+func TestParseFragment(t *testing.T) {
+	doc, err := ParseFragment(`<?xml version="1.0"?> <title>Report</title><author>Ann</author><author>Bob</author>`)
+	require.NoError(t, err)
+	require.Equal(t, "Report", doc.Title)
+	require.Equal(t, []string{"Ann", "Bob"}, doc.Authors)
+	require.Equal(t, "<fragment><title>Report</title><author>Ann</author><author>Bob</author></fragment>", doc.Tree.String())
+	require.Equal(t, `<?xml version="1.0"?>`, doc.Prolog)
+
+	doc, err = ParseFragment("just text")
+	require.NoError(t, err)
+	require.Equal(t, "<fragment>just text</fragment>", doc.Tree.String())
+
+	_, err = ParseFragment("<title>Report</author>")
+	require.Error(t, err)
+	_, err = ParseFragment("")
+	require.Error(t, err)
+}
+
+// Test adding metadata snippets with /add?fragment=true
+func TestHandleAddRequestFragment(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	add := func(query string) int {
+		req := httptest.NewRequest("POST", "/add?"+query, strings.NewReader("<title>Snippet</title><description>Metadata only</description>"))
+		w := httptest.NewRecorder()
+		handleAddRequest(db, w, req)
+		return w.Result().StatusCode
+	}
+	require.Equal(t, http.StatusCreated, add("fragment=true"))
+	require.Equal(t, http.StatusBadRequest, add("fragment=maybe"))
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Snippet", doc.Title)
+	require.Equal(t, "Metadata only", doc.Description)
+	require.Equal(t, FRAGMENT_ROOT, doc.Tree.Name)
+}
@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/text v0.14.0
 )
 
 require (
@@ -0,0 +1,373 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	HOLD_KIND_DOCUMENT = "document" // Kind of a hold on a single document, its target is the document ID
+	HOLD_KIND_TAG      = "tag"      // Kind of a hold on the collection of documents with a tag, its target is the tag
+
+	HOLD_ACTION_PLACE   = "place"   // Action of the audit log entry of a hold being placed
+	HOLD_ACTION_RELEASE = "release" // Action of the audit log entry of a hold being released
+
+	HOLD_REASON_MAX_LENGTH   = 500 // Longest reason of a hold change in characters
+	HOLD_AUDIT_DEFAULT_LIMIT = 50  // Number of audit log entries returned by the admin endpoint by default
+	HOLD_AUDIT_MAX_LIMIT     = 500 // Highest number of audit log entries the admin endpoint returns
+
+	DB_HOLD_TABLE_NAME          = "legal_hold" // Table name of the legal holds in SQLite
+	DB_HOLD_KIND_FIELD_NAME     = "kind"       // Field name for the HOLD_KIND_* of the hold
+	DB_HOLD_TARGET_FIELD_NAME   = "target"     // Field name for the held document ID or tag
+	DB_HOLD_REASON_FIELD_NAME   = "reason"     // Field name for the reason given when the hold was placed
+	DB_HOLD_PLACEDBY_FIELD_NAME = "placed_by"  // Field name for who placed the hold
+	DB_HOLD_PLACEDAT_FIELD_NAME = "placed_at"  // Field name for when the hold was placed
+
+	DB_HOLD_AUDIT_TABLE_NAME        = "legal_hold_audit" // Table name of the audit log of hold changes in SQLite
+	DB_HOLD_AUDIT_ID_FIELD_NAME     = "id"               // Field name for id in the audit log
+	DB_HOLD_AUDIT_ACTION_FIELD_NAME = "action"           // Field name for the HOLD_ACTION_* of the change
+	DB_HOLD_AUDIT_KIND_FIELD_NAME   = "kind"             // Field name for the kind of the changed hold
+	DB_HOLD_AUDIT_TARGET_FIELD_NAME = "target"           // Field name for the target of the changed hold
+	DB_HOLD_AUDIT_REASON_FIELD_NAME = "reason"           // Field name for the reason given for the change
+	DB_HOLD_AUDIT_ACTOR_FIELD_NAME  = "actor"            // Field name for who made the change
+	DB_HOLD_AUDIT_AT_FIELD_NAME     = "changed_at"       // Field name for when the change was made
+)
+
+// ErrLegalHold is returned when a document under legal hold would be deleted
+var ErrLegalHold = errors.New("legal hold")
+
+// heldCondition selects the documents under a hold of their own or of one of their tags
+var heldCondition = fmt.Sprintf("(%[1]s IN (SELECT %[2]s FROM %[3]s WHERE %[4]s='%[5]s') OR %[1]s IN (SELECT %[6]s FROM %[7]s WHERE %[8]s IN (SELECT %[2]s FROM %[3]s WHERE %[4]s='%[9]s')))",
+	DB_ID_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME, DB_HOLD_TABLE_NAME, DB_HOLD_KIND_FIELD_NAME, HOLD_KIND_DOCUMENT,
+	DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_TABLE_NAME, DB_TAG_NAME_FIELD_NAME, HOLD_KIND_TAG)
+
+// LegalHold keeps a document, or all documents with a tag, from being deleted or purged until it is released
+type LegalHold struct {
+	Kind     string
+	Target   string
+	Reason   string
+	PlacedBy string
+	PlacedAt string
+}
+
+// HoldAuditEntry is an entry of the audit log of hold changes
+type HoldAuditEntry struct {
+	ID     int64
+	Action string
+	Kind   string
+	Target string
+	Reason string
+	Actor  string
+	At     string
+}
+
+// createHoldTables creates the tables of the legal holds and their audit log if not exist
+func createHoldTables(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT,
+		"%s" TEXT,
+		"%s" TEXT NOT NULL,
+		PRIMARY KEY ("%s", "%s")
+	);
+`, DB_HOLD_TABLE_NAME, DB_HOLD_KIND_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME, DB_HOLD_REASON_FIELD_NAME, DB_HOLD_PLACEDBY_FIELD_NAME, DB_HOLD_PLACEDAT_FIELD_NAME,
+		DB_HOLD_KIND_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	query = fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER PRIMARY KEY,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT,
+		"%s" TEXT,
+		"%s" TEXT NOT NULL
+	);
+`, DB_HOLD_AUDIT_TABLE_NAME, DB_HOLD_AUDIT_ID_FIELD_NAME, DB_HOLD_AUDIT_ACTION_FIELD_NAME, DB_HOLD_AUDIT_KIND_FIELD_NAME, DB_HOLD_AUDIT_TARGET_FIELD_NAME,
+		DB_HOLD_AUDIT_REASON_FIELD_NAME, DB_HOLD_AUDIT_ACTOR_FIELD_NAME, DB_HOLD_AUDIT_AT_FIELD_NAME)
+
+	_, err = db.Exec(query)
+	return err
+}
+
+// changeHold places or releases a hold and appends the change to the audit log, in one transaction
+// It reports whether the hold changed, placing a held target or releasing one without hold changes nothing
+// and isn't audited.
+func changeHold(db *sql.DB, action string, hold LegalHold) (bool, error) {
+	defer observeQuery("changeHold", time.Now())
+
+	var query string
+	var args []interface{}
+	if action == HOLD_ACTION_PLACE {
+		query = fmt.Sprintf(`
+			INSERT OR IGNORE INTO %s (%s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?)
+		`, DB_HOLD_TABLE_NAME, DB_HOLD_KIND_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME, DB_HOLD_REASON_FIELD_NAME, DB_HOLD_PLACEDBY_FIELD_NAME, DB_HOLD_PLACEDAT_FIELD_NAME)
+		args = []interface{}{hold.Kind, hold.Target, hold.Reason, hold.PlacedBy, hold.PlacedAt}
+	} else {
+		query = fmt.Sprintf(`
+			DELETE FROM %s WHERE %s=? AND %s=?
+		`, DB_HOLD_TABLE_NAME, DB_HOLD_KIND_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME)
+		args = []interface{}{hold.Kind, hold.Target}
+	}
+	auditQuery := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?, ?)
+	`, DB_HOLD_AUDIT_TABLE_NAME, DB_HOLD_AUDIT_ACTION_FIELD_NAME, DB_HOLD_AUDIT_KIND_FIELD_NAME, DB_HOLD_AUDIT_TARGET_FIELD_NAME,
+		DB_HOLD_AUDIT_REASON_FIELD_NAME, DB_HOLD_AUDIT_ACTOR_FIELD_NAME, DB_HOLD_AUDIT_AT_FIELD_NAME)
+
+	changed := false
+	err := withDBRetry(func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		changed = count > 0
+		if !changed {
+			return nil
+		}
+		_, err = tx.Exec(auditQuery, action, hold.Kind, hold.Target, hold.Reason, hold.PlacedBy, hold.PlacedAt)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	return changed, err
+}
+
+// listHolds returns the holds in place, oldest first
+func listHolds(db *sql.DB) ([]LegalHold, error) {
+	defer observeQuery("listHolds", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, %s, %s, %s FROM %s ORDER BY %s, %s, %s
+	`, DB_HOLD_KIND_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME, DB_HOLD_REASON_FIELD_NAME, DB_HOLD_PLACEDBY_FIELD_NAME, DB_HOLD_PLACEDAT_FIELD_NAME,
+		DB_HOLD_TABLE_NAME, DB_HOLD_PLACEDAT_FIELD_NAME, DB_HOLD_KIND_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME)
+	holds := []LegalHold{}
+	err := withDBRetry(func() error {
+		holds = holds[:0]
+		rows, err := db.Query(query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var hold LegalHold
+			var reason, placedBy sql.NullString
+			if err := rows.Scan(&hold.Kind, &hold.Target, &reason, &placedBy, &hold.PlacedAt); err != nil {
+				return err
+			}
+			hold.Reason, hold.PlacedBy = reason.String, placedBy.String
+			holds = append(holds, hold)
+		}
+		return rows.Err()
+	})
+	return holds, err
+}
+
+// listHoldAudit returns the most recent hold changes, newest first
+func listHoldAudit(db *sql.DB, limit int) ([]HoldAuditEntry, error) {
+	defer observeQuery("listHoldAudit", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, %s, %s, %s, %s, %s FROM %s ORDER BY %s DESC LIMIT ?
+	`, DB_HOLD_AUDIT_ID_FIELD_NAME, DB_HOLD_AUDIT_ACTION_FIELD_NAME, DB_HOLD_AUDIT_KIND_FIELD_NAME, DB_HOLD_AUDIT_TARGET_FIELD_NAME,
+		DB_HOLD_AUDIT_REASON_FIELD_NAME, DB_HOLD_AUDIT_ACTOR_FIELD_NAME, DB_HOLD_AUDIT_AT_FIELD_NAME, DB_HOLD_AUDIT_TABLE_NAME, DB_HOLD_AUDIT_ID_FIELD_NAME)
+	entries := []HoldAuditEntry{}
+	err := withDBRetry(func() error {
+		entries = entries[:0]
+		rows, err := db.Query(query, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var entry HoldAuditEntry
+			var reason, actor sql.NullString
+			if err := rows.Scan(&entry.ID, &entry.Action, &entry.Kind, &entry.Target, &reason, &actor, &entry.At); err != nil {
+				return err
+			}
+			entry.Reason, entry.Actor = reason.String, actor.String
+			entries = append(entries, entry)
+		}
+		return rows.Err()
+	})
+	return entries, err
+}
+
+// checkNotHeld returns ErrLegalHold if the document is under a hold of its own or of one of its tags
+func checkNotHeld(db sqlQueryer, id string) error {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s=? AND %s", DB_TABLE_NAME, DB_ID_FIELD_NAME, heldCondition)
+	var held int
+	if err := db.QueryRow(query, id).Scan(&held); err != nil {
+		return err
+	}
+	if held > 0 {
+		return fmt.Errorf("%w: document %s can't be deleted", ErrLegalHold, id)
+	}
+	return nil
+}
+
+// tagHeld reports whether the collection of documents with the tag is under hold
+func tagHeld(db *sql.DB, tag string) (bool, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s=? AND %s=?", DB_HOLD_TABLE_NAME, DB_HOLD_KIND_FIELD_NAME, DB_HOLD_TARGET_FIELD_NAME)
+	var held int
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, HOLD_KIND_TAG, tag).Scan(&held)
+	})
+	return held > 0, err
+}
+
+// parseHoldTarget reads the hold a request is about, ?id=1 for a document or ?tag=case-1234 for a collection
+func parseHoldTarget(r *http.Request) (string, string, error) {
+	id, tag := r.URL.Query().Get("id"), r.URL.Query().Get("tag")
+	if (id == "") == (tag == "") {
+		return "", "", errors.New("either id or tag parameter is required")
+	}
+	if tag != "" {
+		return HOLD_KIND_TAG, tag, validateTag(tag)
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return "", "", fmt.Errorf("invalid document ID %s", id)
+	}
+	return HOLD_KIND_DOCUMENT, id, nil
+}
+
+// handleHoldsRequest lists the legal holds with GET /admin/holds, places one with POST /admin/holds?id=1&reason=...
+// or ?tag=case-1234 for all documents with the tag, and releases one with DELETE
+// Every placed and released hold is recorded in the audit log of /admin/holds/audit with who changed it.
+func handleHoldsRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		holds, err := listHolds(db)
+		if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to list legal holds: %v", err), err)
+			return
+		}
+		response, err := json.Marshal(holds)
+		if err != nil {
+			http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind, target, err := parseHoldTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if r.Method == http.MethodPost && reason == "" {
+		http.Error(w, "reason parameter is required", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(reason) > HOLD_REASON_MAX_LENGTH {
+		http.Error(w, fmt.Sprintf("reason is longer than %d characters", HOLD_REASON_MAX_LENGTH), http.StatusBadRequest)
+		return
+	}
+
+	// Documents are held in any state, tags before any document has them
+	if kind == HOLD_KIND_DOCUMENT && r.Method == http.MethodPost {
+		_, err := getDocumentByID(db, target)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Document with ID %s not found", target), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", target, err), err)
+			return
+		}
+	}
+
+	action := HOLD_ACTION_PLACE
+	if r.Method == http.MethodDelete {
+		action = HOLD_ACTION_RELEASE
+	}
+	// The audit log records who made the change and when, for a release too
+	hold := LegalHold{Kind: kind, Target: target, Reason: reason, PlacedBy: requestActor(db, r), PlacedAt: formatExpiry(time.Now())}
+	changed, err := changeHold(db, action, hold)
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to change legal hold: %v", err), err)
+		return
+	}
+	if !changed && action == HOLD_ACTION_PLACE {
+		http.Error(w, fmt.Sprintf("%s %s is already under legal hold", kind, target), http.StatusConflict)
+		return
+	} else if !changed {
+		http.Error(w, fmt.Sprintf("%s %s is not under legal hold", kind, target), http.StatusNotFound)
+		return
+	}
+
+	if action == HOLD_ACTION_RELEASE {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	response, err := json.Marshal(hold)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+// handleHoldAuditRequest returns the recent hold changes, e.g. /admin/holds/audit?limit=20
+func handleHoldAuditRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	limit := HOLD_AUDIT_DEFAULT_LIMIT
+	if param := r.URL.Query().Get("limit"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value <= 0 || value > HOLD_AUDIT_MAX_LIMIT {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", HOLD_AUDIT_MAX_LIMIT), http.StatusBadRequest)
+			return
+		}
+		limit = value
+	}
+
+	entries, err := listHoldAudit(db, limit)
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to list hold changes: %v", err), err)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(entries)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,103 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test placing and releasing legal holds on documents and tags, and what they block
+func TestHandleHoldsRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	apiKey = "test api key"
+	defer func() { apiKey = "" }()
+
+	for _, data := range []string{
+		`<document expires="2020-01-01"><title>Contract</title></document>`,
+		`<document expires="2020-01-01"><title>Email</title></document>`,
+		`<document expires="2020-01-01"><title>Memo</title></document>`,
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+	writer, err := mintToken(db, ACCESS_WRITE, "", "", time.Now())
+	require.NoError(t, err)
+
+	request := func(method string, target string, credential string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+credential)
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, req)
+		return rr
+	}
+
+	rr := request("POST", "/admin/holds?id=1&reason=Case%201234", apiKey)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	require.Equal(t, http.StatusCreated, request("POST", "/admin/holds?tag=case-1234&reason=Case%201234", apiKey).Code)
+	// Expired documents aren't selected by /documents/tags
+	_, err = db.Exec("INSERT INTO " + DB_TAG_TABLE_NAME + " VALUES (2, 'case-1234')")
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		desc   string
+		method string
+		target string
+		status int
+	}{
+		{desc: "held twice", method: "POST", target: "/admin/holds?id=1&reason=again", status: http.StatusConflict},
+		{desc: "without reason", method: "POST", target: "/admin/holds?id=2", status: http.StatusBadRequest},
+		{desc: "document and tag", method: "POST", target: "/admin/holds?id=2&tag=x&reason=x", status: http.StatusBadRequest},
+		{desc: "unknown document", method: "POST", target: "/admin/holds?id=9&reason=x", status: http.StatusNotFound},
+		{desc: "release without hold", method: "DELETE", target: "/admin/holds?id=3", status: http.StatusNotFound},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.status, request(tt.method, tt.target, apiKey).Code)
+		})
+	}
+	// Only admins change holds
+	require.Equal(t, http.StatusUnauthorized, request("POST", "/admin/holds?id=3&reason=x", writer.Token).Code)
+
+	// Held documents can't be deleted or purged, the others can
+	require.Equal(t, http.StatusConflict, request("GET", "/del?id=1", writer.Token).Code)
+	require.Equal(t, http.StatusConflict, request("POST", "/state?id=2&to=deleted", writer.Token).Code)
+	require.Equal(t, http.StatusConflict, request("POST", "/documents/tags?remove=case-1234", writer.Token).Code)
+	archived, err := archiveExpiredDocuments(db, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), archived)
+	doc, err := getDocumentByID(db, "2")
+	require.NoError(t, err)
+	require.Equal(t, DOC_STATE_ACTIVE, doc.State)
+	require.Equal(t, http.StatusOK, request("GET", "/del?id=3", writer.Token).Code)
+
+	rr = request("GET", "/admin/holds", apiKey)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var holds []LegalHold
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &holds))
+	require.Len(t, holds, 2)
+	require.Equal(t, LegalHold{Kind: HOLD_KIND_DOCUMENT, Target: "1", Reason: "Case 1234", PlacedBy: "key:api", PlacedAt: holds[0].PlacedAt}, holds[0])
+
+	// Released documents can be deleted again
+	require.Equal(t, http.StatusOK, request("DELETE", "/admin/holds?id=1&reason=Case%20closed", apiKey).Code)
+	require.Equal(t, http.StatusOK, request("DELETE", "/admin/holds?tag=case-1234", apiKey).Code)
+	require.Equal(t, http.StatusOK, request("GET", "/del?id=1", writer.Token).Code)
+	require.Equal(t, http.StatusOK, request("POST", "/state?id=2&to=deleted", writer.Token).Code)
+
+	rr = request("GET", "/admin/holds/audit?limit=3", apiKey)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var entries []HoldAuditEntry
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+	require.Len(t, entries, 3)
+	require.Equal(t, HOLD_ACTION_RELEASE, entries[0].Action)
+	require.Equal(t, "case-1234", entries[0].Target)
+	require.Equal(t, "Case closed", entries[1].Reason)
+	require.Equal(t, "key:api", entries[1].Actor)
+	require.Equal(t, HOLD_ACTION_PLACE, entries[2].Action)
+	require.Equal(t, http.StatusBadRequest, request("GET", "/admin/holds/audit?limit=0", apiKey).Code)
+}
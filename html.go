@@ -0,0 +1,299 @@
+package goapp
+
+import (
+	"strconv"
+	"strings"
+)
+
+const HTML_FRAGMENT_ROOT = "body" // Element wrapped around HTML fragments without a single root element
+
+// htmlVoidElements are the HTML elements which never have content or a closing tag, like <br>
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlRawTextElements are the HTML elements whose content is text even if it looks like markup
+var htmlRawTextElements = map[string]bool{"script": true, "style": true}
+
+// htmlBlockElements are the start tags ending an open paragraph
+var htmlBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "div": true, "dl": true, "fieldset": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "main": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "ul": true,
+}
+
+// htmlImpliedEnds lists the elements whose closing tag may be left out, with the start tags closing them
+// They are also closed by the closing tag of an enclosing element.
+var htmlImpliedEnds = map[string]map[string]bool{
+	"p":        htmlBlockElements,
+	"li":       {"li": true},
+	"dt":       {"dt": true, "dd": true},
+	"dd":       {"dt": true, "dd": true},
+	"option":   {"option": true, "optgroup": true},
+	"optgroup": {"optgroup": true},
+	"tr":       {"tr": true, "tbody": true, "tfoot": true},
+	"td":       {"td": true, "th": true, "tr": true, "tbody": true, "tfoot": true},
+	"th":       {"td": true, "th": true, "tr": true, "tbody": true, "tfoot": true},
+	"thead":    {"tbody": true, "tfoot": true},
+	"tbody":    {"tbody": true, "tfoot": true},
+}
+
+// htmlEntities are the character references of HTML beyond the predefined XML entities, which XML parsers don't know
+var htmlEntities = map[string]rune{
+	"nbsp": 160, "iexcl": 161, "cent": 162, "pound": 163, "curren": 164, "yen": 165, "brvbar": 166, "sect": 167,
+	"uml": 168, "copy": 169, "ordf": 170, "laquo": 171, "not": 172, "shy": 173, "reg": 174, "macr": 175,
+	"deg": 176, "plusmn": 177, "sup2": 178, "sup3": 179, "acute": 180, "micro": 181, "para": 182, "middot": 183,
+	"cedil": 184, "sup1": 185, "ordm": 186, "raquo": 187, "frac14": 188, "frac12": 189, "frac34": 190,
+	"iquest": 191, "times": 215, "divide": 247, "auml": 228, "ouml": 246, "uuml": 252, "Auml": 196,
+	"Ouml": 214, "Uuml": 220, "szlig": 223, "eacute": 233, "egrave": 232, "agrave": 224, "ccedil": 231,
+	"ndash": 8211, "mdash": 8212, "lsquo": 8216, "rsquo": 8217, "sbquo": 8218, "ldquo": 8220, "rdquo": 8221,
+	"bdquo": 8222, "dagger": 8224, "bull": 8226, "hellip": 8230, "permil": 8240, "lsaquo": 8249,
+	"rsaquo": 8250, "euro": 8364, "trade": 8482, "larr": 8592, "rarr": 8594,
+}
+
+// repairHTML turns HTML, like a fragment with unclosed <br> and <li> tags, into XML
+// Void elements become empty-element tags and their closing tags are dropped, elements whose closing tag
+// HTML allows to leave out are closed where HTML implies it, tag and attribute names are lower-cased,
+// attribute values quoted, HTML entities turned into character references, script and style content
+// wrapped in CDATA sections, and fragments without a single root element wrapped in HTML_FRAGMENT_ROOT.
+// Other broken tags are left to repairXML. The warnings give the positions of the dropped tags in data.
+func repairHTML(data string) (string, []ParseError, error) {
+	var out strings.Builder
+	var warnings []ParseError
+	var stack []string // Stack of the names of the open elements
+	roots, outside := 0, false
+	contentStart := -1 // Index in out of the first element or text, where a fragment root is opened
+
+	// closeImplied closes the open elements the start or closing tag of name implies the end of
+	closeImplied := func(name string, closing bool) {
+		for len(stack) > 0 {
+			open := stack[len(stack)-1]
+			if closing {
+				if open == name || htmlImpliedEnds[open] == nil || !containsString(stack, name) {
+					return
+				}
+			} else if !htmlImpliedEnds[open][name] {
+				return
+			}
+			out.WriteString("</" + open + ">")
+			stack = stack[:len(stack)-1]
+		}
+	}
+	markContent := func() {
+		if contentStart < 0 {
+			contentStart = out.Len()
+		}
+	}
+
+	text := 0 // Index of the start of the text before the current tag
+	for i := 0; i < len(data); i++ {
+		if data[i] != '<' {
+			continue
+		}
+		if end := sectionEnd(data, i); end < 0 {
+			return "", nil, newParseError(data, i, sectionError(data, i))
+		} else if end > 0 {
+			// Comments, CDATA sections, processing instructions and the DOCTYPE are kept
+			chunk := htmlText(data[text:i])
+			if strings.TrimSpace(chunk) != "" && len(stack) == 0 {
+				outside = true
+				markContent()
+			}
+			out.WriteString(chunk)
+			if strings.HasPrefix(data[i:], CDATA_START) && len(stack) == 0 {
+				outside = true
+				markContent()
+			}
+			out.WriteString(data[i:end])
+			i, text = end-1, end
+			continue
+		}
+
+		closing := strings.HasPrefix(data[i:], "</")
+		nameStart := i + 1
+		if closing {
+			nameStart++
+		}
+		if nameStart >= len(data) || !isASCIILetter(data[nameStart]) {
+			// A '<' which doesn't start a tag is text, like in "a < b"
+			continue
+		}
+		end := tagEnd(data, i, ">")
+		if end < 0 {
+			return "", nil, newParseError(data, i, "unclosed tag error: "+data[i:])
+		}
+
+		chunk := htmlText(data[text:i])
+		if strings.TrimSpace(chunk) != "" && len(stack) == 0 {
+			outside = true
+			markContent()
+		}
+		out.WriteString(chunk)
+		text = end + 1
+
+		tag := data[i : end+1]
+		nameEnd := nameStart
+		for nameEnd < end && strings.IndexByte(XML_WHITESPACE+"/>", data[nameEnd]) < 0 {
+			nameEnd++
+		}
+		name := strings.ToLower(data[nameStart:nameEnd])
+		if closing {
+			if htmlVoidElements[name] {
+				warnings = append(warnings, *newParseError(data, i, "skipped closing tag of void element "+tag))
+				continue
+			}
+			closeImplied(name, true)
+			out.WriteString("</" + name + ">")
+			if len(stack) > 0 && stack[len(stack)-1] == name {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		closeImplied(name, false)
+		if len(stack) == 0 {
+			roots++
+			markContent()
+		}
+		selfClosing := strings.HasSuffix(tag, "/>")
+		attrs := htmlAttributes(strings.TrimSuffix(data[nameEnd:end], "/"))
+		if htmlVoidElements[name] || selfClosing {
+			out.WriteString("<" + name + attrs + "/>")
+			continue
+		}
+		out.WriteString("<" + name + attrs + ">")
+
+		if htmlRawTextElements[name] {
+			// The content ends at the first closing tag of the element, whatever it looks like
+			contentEnd := strings.Index(strings.ToLower(data[text:]), "</"+name)
+			if contentEnd < 0 {
+				contentEnd = len(data) - text
+			}
+			if content := data[text : text+contentEnd]; content != "" {
+				out.WriteString(CDATA_START + strings.ReplaceAll(content, CDATA_END, "]]"+CDATA_END+CDATA_START+">") + CDATA_END)
+			}
+			text += contentEnd
+			i = text - 1
+		}
+		stack = append(stack, name)
+	}
+	chunk := htmlText(data[text:])
+	if strings.TrimSpace(chunk) != "" && len(stack) == 0 {
+		outside = true
+		markContent()
+	}
+	out.WriteString(chunk)
+
+	// Elements like a last <li> end with the document
+	for len(stack) > 0 && htmlImpliedEnds[stack[len(stack)-1]] != nil {
+		out.WriteString("</" + stack[len(stack)-1] + ">")
+		stack = stack[:len(stack)-1]
+	}
+
+	result := out.String()
+	if roots > 1 || (roots > 0 && outside) {
+		result = result[:contentStart] + "<" + HTML_FRAGMENT_ROOT + ">" + result[contentStart:] + "</" + HTML_FRAGMENT_ROOT + ">"
+	}
+	return result, warnings, nil
+}
+
+// htmlAttributes turns the attributes of an HTML start tag into XML: names lower-cased, values quoted and
+// attributes without value like "disabled" given their name as value
+// Of repeated attributes the first one is kept, as HTML does.
+func htmlAttributes(text string) string {
+	var out strings.Builder
+	seen := map[string]bool{}
+	for i := 0; i < len(text); {
+		if strings.IndexByte(XML_WHITESPACE+"/", text[i]) >= 0 {
+			i++
+			continue
+		}
+		nameEnd := i
+		for nameEnd < len(text) && strings.IndexByte(XML_WHITESPACE+"=/", text[nameEnd]) < 0 {
+			nameEnd++
+		}
+		name := strings.ToLower(text[i:nameEnd])
+		i = nameEnd
+		for i < len(text) && strings.IndexByte(XML_WHITESPACE, text[i]) >= 0 {
+			i++
+		}
+
+		value := name
+		if i < len(text) && text[i] == '=' {
+			i++
+			for i < len(text) && strings.IndexByte(XML_WHITESPACE, text[i]) >= 0 {
+				i++
+			}
+			if i < len(text) && (text[i] == '"' || text[i] == '\'') {
+				end := strings.IndexByte(text[i+1:], text[i])
+				if end < 0 {
+					end = len(text) - i - 1
+				}
+				value = text[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(text) && strings.IndexByte(XML_WHITESPACE, text[i]) < 0 {
+					i++
+				}
+				value = text[start:i]
+			}
+		}
+
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		value = strings.ReplaceAll(htmlText(value), `"`, "&quot;")
+		out.WriteString(" " + name + `="` + value + `"`)
+	}
+	return out.String()
+}
+
+// htmlText replaces the HTML entities of text with character references and escapes bare ampersands and '<'
+func htmlText(text string) string {
+	text = strings.ReplaceAll(text, "<", "&lt;")
+	if !strings.Contains(text, "&") {
+		return text
+	}
+
+	var out strings.Builder
+	for {
+		start := strings.IndexByte(text, '&')
+		if start < 0 {
+			break
+		}
+		out.WriteString(text[:start])
+		text = text[start:]
+
+		end := strings.IndexByte(text, ';')
+		name := ""
+		if end > 1 && end <= ENTITY_MAX_LENGTH+1 {
+			name = text[1:end]
+		}
+		switch {
+		case name == "":
+			out.WriteString("&amp;")
+			text = text[1:]
+			continue
+		case htmlEntities[name] != 0:
+			out.WriteString("&#" + strconv.Itoa(int(htmlEntities[name])) + ";")
+		case xmlEntities[name] != "" || strings.HasPrefix(name, "#"):
+			out.WriteString(text[:end+1])
+		default:
+			// An ampersand which doesn't start a known reference, like in "Fish & Chips;"
+			out.WriteString("&amp;")
+			text = text[1:]
+			continue
+		}
+		text = text[end+1:]
+	}
+	out.WriteString(text)
+	return out.String()
+}
+
+func isASCIILetter(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
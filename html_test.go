@@ -0,0 +1,107 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test turning HTML into XML
+func TestRepairHTML(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+		warnings []string
+	}{
+		{
+			desc:     "void elements",
+			data:     `<p>Line<br>next<BR/><img src=a.png alt="A > B"></p>`,
+			expected: `<p>Line<br/>next<br/><img src="a.png" alt="A > B"/></p>`,
+		},
+		{
+			desc:     "closing tag of void element",
+			data:     `<div>a<br></br></div>`,
+			expected: `<div>a<br/></div>`,
+			warnings: []string{"skipped closing tag of void element </br> at line 1, column 11"},
+		},
+		{
+			desc:     "implied closes",
+			data:     "<ul><li>One<li>Two</ul><p>First<p>Second<div>Block</div>",
+			expected: "<body><ul><li>One</li><li>Two</li></ul><p>First</p><p>Second</p><div>Block</div></body>",
+		},
+		{
+			desc:     "table cells",
+			data:     "<table><tr><td>a<td>b<tr><td>c</table>",
+			expected: "<table><tr><td>a</td><td>b</td></tr><tr><td>c</td></tr></table>",
+		},
+		{
+			desc:     "attributes and entities",
+			data:     `<P CLASS=intro hidden id='x' id="y">Fish &amp; Chips &nbsp;&copy; & more</P>`,
+			expected: `<p class="intro" hidden="hidden" id="x">Fish &amp; Chips &#160;&#169; &amp; more</p>`,
+		},
+		{
+			desc:     "raw text",
+			data:     `<div><script>if (a < b && c) { x = "]]>"; }</script>a < b</div>`,
+			expected: `<div><script><![CDATA[if (a < b && c) { x = "]]]]><![CDATA[>"; }]]></script>a &lt; b</div>`,
+		},
+		{
+			desc:     "fragment with text",
+			data:     `<!-- teaser -->Read <a href="/more">more</a>`,
+			expected: `<!-- teaser --><body>Read <a href="/more">more</a></body>`,
+		},
+		{
+			desc:     "page",
+			data:     "<!DOCTYPE html>\n<html><head><meta charset=utf-8><title>T</title></head><body><p>x</body></html>",
+			expected: "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"/><title>T</title></head><body><p>x</p></body></html>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			repaired, warnings, err := repairHTML(tt.data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, repaired)
+
+			var messages []string
+			for _, warning := range warnings {
+				messages = append(messages, warning.Error())
+			}
+			require.Equal(t, tt.warnings, messages)
+		})
+	}
+}
+
+// Test adding HTML fragments with /add?html=true
+func TestHandleAddRequestHTML(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	data := "<html><head><title>News</title></head><body><p>First line<br>second line<p>Next</body></html>"
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(data))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Result().StatusCode)
+
+	req = httptest.NewRequest("POST", "/add?html=true", strings.NewReader(data))
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	var response AddResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Empty(t, response.Warnings)
+
+	doc, err := getDocumentByID(db, response.ID)
+	require.NoError(t, err)
+	require.Equal(t, "News", doc.Title)
+	require.Equal(t, "<html><head><title>News</title></head><body><p>First line<br></br>second line</p><p>Next</p></body></html>", doc.Tree.String())
+
+	req = httptest.NewRequest("POST", "/add?html=maybe", strings.NewReader(data))
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
@@ -0,0 +1,177 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER      = "Idempotency-Key"     // Header with a key the client picks for a submission, retries send the same key
+	IDEMPOTENCY_REPLAYED_HEADER = "Idempotent-Replayed" // Header set to "true" on responses replayed for a retried submission
+	IDEMPOTENCY_TTL             = 24 * time.Hour        // Time the response to a submission is replayed for retries
+	IDEMPOTENCY_MAX_KEY         = 255                   // Longest idempotency key which is accepted
+	IDEMPOTENCY_MAX_BODY        = 64 * 1024             // Largest response which is kept for retries
+	IDEMPOTENCY_PENDING         = `{"Status":-1}`       // Value of a key whose first submission is still being handled
+	IDEMPOTENCY_PREFIX          = "idempotency:"        // Prefix of the keys in Redis
+)
+
+// idempotencyStore remembers the responses to submissions with an idempotency key
+type idempotencyStore interface {
+	// Reserve marks the key as being handled unless it is already known, in which case it returns its value
+	Reserve(key string, ttl time.Duration) (value string, reserved bool, err error)
+	// Complete stores the response of the key
+	Complete(key string, value string, ttl time.Duration) error
+	// Release forgets the key, so the submission can be retried
+	Release(key string) error
+}
+
+// idempotencyKeys holds the idempotency keys, in Redis if the instances share one
+var idempotencyKeys idempotencyStore = newMemoryIdempotencyStore()
+
+// initIdempotency keeps the idempotency keys in the shared Redis server if there is one
+func initIdempotency() {
+	if sharedRedis != nil {
+		idempotencyKeys = &redisIdempotencyStore{client: sharedRedis}
+	}
+}
+
+// idempotentRequests is a middleware replaying the response to the first submission with an Idempotency-Key
+// to the retries with the same key and credential, instead of handling them again
+// Retries arriving while the first submission is handled get 409 Conflict; failed submissions may be retried.
+func idempotentRequests(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		if idempotencyKey == "" {
+			next(db, w, r)
+			return
+		}
+		if len(idempotencyKey) > IDEMPOTENCY_MAX_KEY {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		funcName := "idempotentRequests"
+
+		key := hashToken(credentialScope(r) + "\n" + r.URL.Path + "\n" + idempotencyKey)
+		value, reserved, err := idempotencyKeys.Reserve(key, IDEMPOTENCY_TTL)
+		if err != nil {
+			// Submissions are still accepted while the store is down, at the risk of duplicates
+			log.Printf("%s: Failed to reserve idempotency key: %v", funcName, err)
+			next(db, w, r)
+			return
+		}
+		if !reserved {
+			var response cachedResponse
+			if err := json.Unmarshal([]byte(value), &response); err != nil || response.Status < 0 {
+				http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+				return
+			}
+			for name, value := range response.Header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set(IDEMPOTENCY_REPLAYED_HEADER, "true")
+			w.WriteHeader(response.Status)
+			w.Write(response.Body)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, Status: http.StatusOK, Capture: IDEMPOTENCY_MAX_BODY + 1}
+		next(db, recorder, r)
+
+		// Failures of the server and overload may go away, their submissions are tried again
+		if recorder.Status >= http.StatusInternalServerError || recorder.Status == http.StatusTooManyRequests ||
+			recorder.Bytes > IDEMPOTENCY_MAX_BODY {
+			if err := idempotencyKeys.Release(key); err != nil {
+				log.Printf("%s: Failed to release idempotency key: %v", funcName, err)
+			}
+			return
+		}
+		response := cachedResponse{Status: recorder.Status, Header: map[string]string{}, Body: recorder.Body}
+		for _, name := range cachedHeaders {
+			if value := w.Header().Get(name); value != "" {
+				response.Header[name] = value
+			}
+		}
+		data, err := json.Marshal(response)
+		if err == nil {
+			err = idempotencyKeys.Complete(key, string(data), IDEMPOTENCY_TTL)
+		}
+		if err != nil {
+			log.Printf("%s: Failed to store response of idempotency key: %v", funcName, err)
+		}
+	}
+}
+
+// memoryIdempotencyStore keeps the idempotency keys in the memory of the instance
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	now     func() time.Time // now returns the current time, replaced in tests
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{entries: map[string]memoryEntry{}, now: time.Now}
+}
+
+func (store *memoryIdempotencyStore) Reserve(key string, ttl time.Duration) (string, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.now()
+	if entry, ok := store.entries[key]; ok && now.Before(entry.expires) {
+		return string(entry.value), false, nil
+	}
+	// Expired keys are dropped as new ones come in
+	for other, entry := range store.entries {
+		if !now.Before(entry.expires) {
+			delete(store.entries, other)
+		}
+	}
+	store.entries[key] = memoryEntry{value: []byte(IDEMPOTENCY_PENDING), expires: now.Add(ttl)}
+	return "", true, nil
+}
+
+func (store *memoryIdempotencyStore) Complete(key string, value string, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.entries[key] = memoryEntry{value: []byte(value), expires: store.now().Add(ttl)}
+	return nil
+}
+
+func (store *memoryIdempotencyStore) Release(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.entries, key)
+	return nil
+}
+
+// redisIdempotencyStore keeps the idempotency keys in Redis, so retries sent to another instance are recognized
+type redisIdempotencyStore struct {
+	client *RedisClient
+}
+
+func (store *redisIdempotencyStore) Reserve(key string, ttl time.Duration) (string, bool, error) {
+	redisKey := store.client.Key(IDEMPOTENCY_PREFIX + key)
+	reserved, err := store.client.SetNX(redisKey, IDEMPOTENCY_PENDING, ttl)
+	if err != nil || reserved {
+		return "", reserved, err
+	}
+	value, err := store.client.Get(redisKey)
+	if errors.Is(err, ErrRedisNil) {
+		// The key expired or was released in between, the submission is handled as in progress and retried
+		return IDEMPOTENCY_PENDING, false, nil
+	}
+	return value, false, err
+}
+
+func (store *redisIdempotencyStore) Complete(key string, value string, ttl time.Duration) error {
+	return store.client.Set(store.client.Key(IDEMPOTENCY_PREFIX+key), value, ttl)
+}
+
+func (store *redisIdempotencyStore) Release(key string) error {
+	return store.client.Del(store.client.Key(IDEMPOTENCY_PREFIX + key))
+}
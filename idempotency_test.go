@@ -0,0 +1,79 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test replaying the response to a submission for its retries
+func TestIdempotentRequests(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(store idempotencyStore) { idempotencyKeys = store }(idempotencyKeys)
+	idempotencyKeys = newMemoryIdempotencyStore()
+
+	add := func(body string, idempotencyKey string, authorization string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/add", strings.NewReader(body))
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, idempotencyKey)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		handleRequest(db, rr, req)
+		return rr
+	}
+
+	first := add("<document><title>First</title></document>", "feed-1", "")
+	require.Equal(t, http.StatusCreated, first.Code)
+	require.Empty(t, first.Header().Get(IDEMPOTENCY_REPLAYED_HEADER))
+
+	// The retry gets the same response, instead of a conflict with the stored duplicate
+	retry := add("<document><title>First</title></document>", "feed-1", "")
+	require.Equal(t, http.StatusCreated, retry.Code)
+	require.Equal(t, first.Body.String(), retry.Body.String())
+	require.Equal(t, "true", retry.Header().Get(IDEMPOTENCY_REPLAYED_HEADER))
+
+	// Keys of other clients are their own
+	require.Equal(t, http.StatusConflict, add("<document><title>First</title></document>", "feed-1", "Bearer other").Code)
+
+	// Rejected submissions are replayed too
+	require.Equal(t, http.StatusBadRequest, add("<document></title></document>", "feed-2", "").Code)
+	require.Equal(t, http.StatusBadRequest, add("<document><title>Second</title></document>", "feed-2", "").Code)
+
+	require.Equal(t, http.StatusBadRequest, add("<document/>", strings.Repeat("k", IDEMPOTENCY_MAX_KEY+1), "").Code)
+}
+
+// Test keeping idempotency keys in memory and in Redis
+func TestIdempotencyStores(t *testing.T) {
+	client, err := newRedisClient(fakeRedis(t))
+	require.NoError(t, err)
+
+	for name, store := range map[string]idempotencyStore{"memory": newMemoryIdempotencyStore(), "redis": &redisIdempotencyStore{client: client}} {
+		t.Run(name, func(t *testing.T) {
+			_, reserved, err := store.Reserve("a", time.Hour)
+			require.NoError(t, err)
+			require.True(t, reserved)
+
+			value, reserved, err := store.Reserve("a", time.Hour)
+			require.NoError(t, err)
+			require.False(t, reserved)
+			require.Equal(t, IDEMPOTENCY_PENDING, value)
+
+			require.NoError(t, store.Complete("a", `{"Status":201}`, time.Hour))
+			value, _, err = store.Reserve("a", time.Hour)
+			require.NoError(t, err)
+			require.Equal(t, `{"Status":201}`, value)
+
+			require.NoError(t, store.Release("a"))
+			_, reserved, err = store.Reserve("a", time.Hour)
+			require.NoError(t, err)
+			require.True(t, reserved)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	INGEST_WORKERS_ENV     = "DOC_INGEST_WORKERS"     // Environment variable with the number of ingestion workers
+	INGEST_QUEUE_LIMIT_ENV = "DOC_INGEST_QUEUE_LIMIT" // Environment variable with the number of jobs allowed to wait
+
+	INGEST_DEFAULT_QUEUE_LIMIT = 100 // Number of jobs allowed to wait by default
+	INGEST_MIN_RETRY_AFTER     = 1   // Shortest wait in seconds suggested to rejected clients
+	INGEST_MAX_RETRY_AFTER     = 60  // Longest wait in seconds suggested to rejected clients
+	INGEST_JOB_TIME_WEIGHT     = 0.2 // Weight of the latest job in the average job time
+
+	INGEST_PRIORITY_HIGH   = "high"   // Priority of interactive submissions, the default of /add
+	INGEST_PRIORITY_NORMAL = "normal" // Priority of regular submissions
+	INGEST_PRIORITY_LOW    = "low"    // Priority of bulk back-fills like directory loads
+)
+
+// ErrQueueFull is returned when too many jobs are waiting to accept another one
+var ErrQueueFull = errors.New("ingestion queue is full")
+
+// ingestPriorities lists the priorities from the first to the last served
+var ingestPriorities = []string{INGEST_PRIORITY_HIGH, INGEST_PRIORITY_NORMAL, INGEST_PRIORITY_LOW}
+
+// isValidPriority reports whether priority is a known ingestion priority
+func isValidPriority(priority string) bool {
+	return ingestLane(priority) >= 0
+}
+
+// ingestLane returns the index of the lane of priority, or -1 if it is unknown
+func ingestLane(priority string) int {
+	for i, known := range ingestPriorities {
+		if known == priority {
+			return i
+		}
+	}
+	return -1
+}
+
+// ingestJob is a unit of work waiting in the queue
+type ingestJob struct {
+	run       func()
+	done      chan struct{}
+	recovered interface{} // recovered holds the value run panicked with, raised again in the caller
+}
+
+// IngestQueue runs ingestion work on a fixed pool of workers, one lane per priority
+// Workers always take the oldest job of the highest priority lane, so interactive submissions
+// don't wait behind bulk back-fills. Workers start with the first job.
+// Once Limit jobs are waiting new ones are rejected, so producers can throttle themselves
+// instead of piling up work which would time out.
+type IngestQueue struct {
+	Workers int // Workers is the number of jobs run at the same time
+	Limit   int // Limit is the number of jobs allowed to wait for a worker
+
+	once    sync.Once
+	mu      sync.Mutex
+	ready   *sync.Cond
+	lanes   [][]*ingestJob
+	depth   int
+	jobTime time.Duration // jobTime is the moving average of the time a job takes
+}
+
+// ingestQueue is the queue of all ingestion, its workers are set by initIngestQueue
+var ingestQueue = newIngestQueue(runtime.NumCPU())
+
+func newIngestQueue(workers int) *IngestQueue {
+	queue := &IngestQueue{Workers: workers, Limit: INGEST_DEFAULT_QUEUE_LIMIT, lanes: make([][]*ingestJob, len(ingestPriorities))}
+	queue.ready = sync.NewCond(&queue.mu)
+	return queue
+}
+
+// initIngestQueue loads the number of ingestion workers and the queue limit from the environment
+func initIngestQueue() {
+	funcName := "initIngestQueue"
+
+	settings := map[string]*int{
+		INGEST_WORKERS_ENV:     &ingestQueue.Workers,
+		INGEST_QUEUE_LIMIT_ENV: &ingestQueue.Limit,
+	}
+	for env, setting := range settings {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			log.Fatalf("%s: %s must be at least 1", funcName, env)
+		}
+		*setting = number
+	}
+}
+
+// Do queues fn with the given priority and waits until a worker ran it
+// It returns ErrQueueFull without running fn if the queue is full
+func (queue *IngestQueue) Do(priority string, fn func()) error {
+	lane := ingestLane(priority)
+	if lane < 0 {
+		return fmt.Errorf("invalid priority %s", priority)
+	}
+	queue.once.Do(queue.start)
+
+	job := &ingestJob{run: fn, done: make(chan struct{})}
+	queue.mu.Lock()
+	if queue.depth >= queue.Limit {
+		queue.mu.Unlock()
+		metrics.inc("ingest_rejected_total", "priority", priority)
+		return ErrQueueFull
+	}
+	queue.lanes[lane] = append(queue.lanes[lane], job)
+	queue.depth++
+	queue.mu.Unlock()
+	queue.ready.Signal()
+	metrics.inc("ingest_jobs_total", "priority", priority)
+
+	<-job.done
+	if job.recovered != nil {
+		panic(job.recovered)
+	}
+	return nil
+}
+
+// Depth returns the number of jobs waiting for a worker
+func (queue *IngestQueue) Depth() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.depth
+}
+
+// RetryAfter estimates in seconds how long it takes the workers to drain the waiting jobs
+func (queue *IngestQueue) RetryAfter() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	drain := queue.jobTime.Seconds() * float64(queue.depth) / float64(queue.Workers)
+	seconds := int(math.Ceil(drain))
+	if seconds < INGEST_MIN_RETRY_AFTER {
+		return INGEST_MIN_RETRY_AFTER
+	}
+	if seconds > INGEST_MAX_RETRY_AFTER {
+		return INGEST_MAX_RETRY_AFTER
+	}
+	return seconds
+}
+
+// start runs the workers
+func (queue *IngestQueue) start() {
+	for i := 0; i < queue.Workers; i++ {
+		go queue.work()
+	}
+}
+
+// next waits for a job and takes it from the highest priority lane holding one
+func (queue *IngestQueue) next() *ingestJob {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for {
+		for i, lane := range queue.lanes {
+			if len(lane) > 0 {
+				job := lane[0]
+				lane[0] = nil
+				queue.lanes[i] = lane[1:]
+				queue.depth--
+				return job
+			}
+		}
+		queue.ready.Wait()
+	}
+}
+
+// work runs jobs until the process exits
+func (queue *IngestQueue) work() {
+	for {
+		job := queue.next()
+		start := time.Now()
+		func() {
+			defer close(job.done)
+			defer func() { job.recovered = recover() }()
+			job.run()
+		}()
+		queue.observe(time.Since(start))
+	}
+}
+
+// observe adds the time a job took to the average job time
+func (queue *IngestQueue) observe(elapsed time.Duration) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.jobTime == 0 {
+		queue.jobTime = elapsed
+		return
+	}
+	queue.jobTime = time.Duration(float64(queue.jobTime)*(1-INGEST_JOB_TIME_WEIGHT) + float64(elapsed)*INGEST_JOB_TIME_WEIGHT)
+}
@@ -0,0 +1,157 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that waiting jobs run by priority, oldest first within a priority
+func TestIngestQueuePriorities(t *testing.T) {
+	queue := newIngestQueue(1)
+
+	// Keep the only worker busy until all jobs are queued
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go queue.Do(INGEST_PRIORITY_LOW, func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	var mu sync.Mutex
+	order := []string{}
+	var wg sync.WaitGroup
+	for i, name := range []string{"low 1", "normal", "low 2", "high"} {
+		priority := strings.Fields(name)[0]
+		name := name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queue.Do(priority, func() {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+			})
+		}()
+		// Queue the jobs one after the other
+		require.Eventually(t, func() bool { return queue.Depth() == i+1 }, time.Second, time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+	require.Equal(t, []string{"high", "normal", "low 1", "low 2"}, order)
+}
+
+// Test rejecting unknown priorities and raising panics in the caller
+func TestIngestQueueDo(t *testing.T) {
+	queue := newIngestQueue(1)
+
+	require.Error(t, queue.Do("urgent", func() {}))
+	require.PanicsWithValue(t, "broken", func() {
+		queue.Do(INGEST_PRIORITY_HIGH, func() { panic("broken") })
+	})
+
+	// The worker survived the panic
+	ran := false
+	require.NoError(t, queue.Do(INGEST_PRIORITY_HIGH, func() { ran = true }))
+	require.True(t, ran)
+}
+
+// Test the priority parameter of /add
+func TestHandleAddRequestPriority(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		desc           string
+		query          string
+		expectedStatus int
+	}{
+		{desc: "default priority", query: "", expectedStatus: http.StatusCreated},
+		{desc: "low priority", query: "?priority=low", expectedStatus: http.StatusCreated},
+		{desc: "invalid priority", query: "?priority=urgent", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			// Documents differ so they aren't rejected as duplicates
+			req := httptest.NewRequest("POST", "/add"+tt.query, strings.NewReader(`<document><title>`+tt.desc+`</title></document>`))
+			w := httptest.NewRecorder()
+			handleAddRequest(db, w, req)
+			require.Equal(t, tt.expectedStatus, w.Result().StatusCode)
+		})
+	}
+}
+
+// Test rejecting jobs once the queue is full
+func TestIngestQueueFull(t *testing.T) {
+	queue := newIngestQueue(1)
+	queue.Limit = 1
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go queue.Do(INGEST_PRIORITY_LOW, func() {
+		close(started)
+		<-release
+	})
+	<-started
+	go queue.Do(INGEST_PRIORITY_LOW, func() {})
+	require.Eventually(t, func() bool { return queue.Depth() == 1 }, time.Second, time.Millisecond)
+
+	require.ErrorIs(t, queue.Do(INGEST_PRIORITY_HIGH, func() {}), ErrQueueFull)
+	close(release)
+	require.Eventually(t, func() bool { return queue.Depth() == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, queue.Do(INGEST_PRIORITY_HIGH, func() {}))
+}
+
+// Test estimating how long the backlog takes to drain
+func TestIngestQueueRetryAfter(t *testing.T) {
+	tests := []struct {
+		desc     string
+		workers  int
+		depth    int
+		jobTime  time.Duration
+		expected int
+	}{
+		{desc: "empty queue", workers: 1, depth: 0, jobTime: time.Second, expected: INGEST_MIN_RETRY_AFTER},
+		{desc: "one worker", workers: 1, depth: 10, jobTime: 500 * time.Millisecond, expected: 5},
+		{desc: "several workers", workers: 4, depth: 10, jobTime: 500 * time.Millisecond, expected: 2},
+		{desc: "long backlog", workers: 1, depth: 1000, jobTime: time.Second, expected: INGEST_MAX_RETRY_AFTER},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			queue := newIngestQueue(tt.workers)
+			queue.depth = tt.depth
+			queue.jobTime = tt.jobTime
+			require.Equal(t, tt.expected, queue.RetryAfter())
+		})
+	}
+
+	queue := newIngestQueue(1)
+	queue.observe(time.Second)
+	queue.observe(2 * time.Second)
+	require.Equal(t, 1200*time.Millisecond, queue.jobTime)
+}
+
+// Test that /add is answered with 429 and Retry-After when the queue is full
+func TestHandleAddRequestQueueFull(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	previous := ingestQueue
+	ingestQueue = newIngestQueue(1)
+	ingestQueue.Limit = 0
+	defer func() { ingestQueue = previous }()
+
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(`<document><title>Test Title</title></document>`))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+
+	require.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	require.Equal(t, "1", w.Result().Header.Get("Retry-After"))
+}
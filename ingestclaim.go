@@ -0,0 +1,68 @@
+package goapp
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	DB_CLAIM_TABLE_NAME          = "ingest_claim" // Table name of the claims of ingested files in SQLite
+	DB_CLAIM_PATH_FIELD_NAME     = "path"         // Field name for the path of the file within the watched directory
+	DB_CLAIM_CHECKSUM_FIELD_NAME = "checksum"     // Field name for the SHA-256 of the file content
+	DB_CLAIM_HOLDER_FIELD_NAME   = "holder"       // Field name for the instance which ingested the file
+	DB_CLAIM_TIME_FIELD_NAME     = "claimed_at"   // Field name for the time the file was claimed
+)
+
+// createClaimTable creates the ingest claim table if not exists
+// A file is identified by its path and content, so a file replaced with new content is ingested again
+func createClaimTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		PRIMARY KEY ("%s", "%s")
+	);
+`, DB_CLAIM_TABLE_NAME, DB_CLAIM_PATH_FIELD_NAME, DB_CLAIM_CHECKSUM_FIELD_NAME, DB_CLAIM_HOLDER_FIELD_NAME, DB_CLAIM_TIME_FIELD_NAME, DB_CLAIM_PATH_FIELD_NAME, DB_CLAIM_CHECKSUM_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// claimFile claims a file for ingestion by holder
+// It reports whether the claim succeeded, which fails if any instance already claimed the same file
+func claimFile(db *sql.DB, path string, sum string, holder string, now time.Time) (bool, error) {
+	defer observeQuery("claimFile", time.Now())
+
+	query := fmt.Sprintf(`
+		INSERT OR IGNORE INTO %s (%s, %s, %s, %s) VALUES (?, ?, ?, ?)
+	`, DB_CLAIM_TABLE_NAME, DB_CLAIM_PATH_FIELD_NAME, DB_CLAIM_CHECKSUM_FIELD_NAME, DB_CLAIM_HOLDER_FIELD_NAME, DB_CLAIM_TIME_FIELD_NAME)
+	var count int64
+	err := withDBRetry(func() error {
+		result, err := db.Exec(query, path, sum, holder, formatExpiry(now))
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// releaseFile drops the claim of a file so it can be ingested again, e.g. after the insert failed
+func releaseFile(db *sql.DB, path string, sum string) error {
+	defer observeQuery("releaseFile", time.Now())
+
+	query := fmt.Sprintf(`
+		DELETE FROM %s WHERE %s=? AND %s=?
+	`, DB_CLAIM_TABLE_NAME, DB_CLAIM_PATH_FIELD_NAME, DB_CLAIM_CHECKSUM_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, path, sum)
+		return err
+	})
+}
@@ -0,0 +1,69 @@
+package goapp
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that a file is claimed by a single instance
+func TestClaimFile(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	now := time.Now()
+	tests := []struct {
+		desc     string
+		path     string
+		sum      string
+		holder   string
+		expected bool
+	}{
+		{desc: "first claim", path: "a.xml", sum: "1", holder: "first", expected: true},
+		{desc: "claimed by another instance", path: "a.xml", sum: "1", holder: "second", expected: false},
+		{desc: "claimed by the same instance", path: "a.xml", sum: "1", holder: "first", expected: false},
+		{desc: "changed content", path: "a.xml", sum: "2", holder: "second", expected: true},
+		{desc: "other file", path: "b.xml", sum: "1", holder: "second", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			claimed, err := claimFile(db, tt.path, tt.sum, tt.holder, now)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, claimed)
+		})
+	}
+
+	require.NoError(t, releaseFile(db, "a.xml", "1"))
+	claimed, err := claimFile(db, "a.xml", "1", "second", now)
+	require.NoError(t, err)
+	require.True(t, claimed)
+}
+
+// Test that files in a shared directory are ingested once
+func TestLoadXMLFilesOnce(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	directory := t.TempDir()
+	path := filepath.Join(directory, "doc.xml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`<document><title>First</title></document>`), 0644))
+
+	// A second run stands for another instance watching the same directory
+	require.NoError(t, loadXMLFiles(db, directory))
+	require.NoError(t, loadXMLFiles(db, directory))
+
+	ids, err := listDocumentIDs(db, documentStates, false)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+
+	// A file replaced with new content is ingested again
+	require.NoError(t, ioutil.WriteFile(path, []byte(`<document><title>Second</title></document>`), 0644))
+	require.NoError(t, loadXMLFiles(db, directory))
+
+	ids, err = listDocumentIDs(db, documentStates, false)
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+}
@@ -0,0 +1,46 @@
+package goapp
+
+import (
+	"errors"
+	"time"
+)
+
+// ingestDocument runs data from source through the ingest pipeline and parses it like parseDocumentWithOptions
+// Legacy encodings are converted first, then obviously non-XML payloads and documents over the parser limits
+// are rejected before the full parser runs. The parsed document gets the date profile of its source and is
+// validated against the schemas of options.
+func ingestDocument(data string, source string, options ParseOptions) (*XMLDoc, error) {
+	data, err := transcodeXML(data)
+	if err == nil {
+		err = parseLimits.Check(data)
+	}
+	if err == nil {
+		err = sniffXML(data)
+		// HTML pages are welcome in HTML mode
+		if options.HTML && errors.Is(err, ErrHTMLPayload) {
+			err = nil
+		}
+	}
+	// Ingest rules strip noise like tracking pixels before the text limits count it
+	var processingLog []ProcessingEntry
+	if err == nil {
+		data, processingLog = ingestRules.Apply(data, source, time.Now())
+	}
+	var overflow []TextOverflow
+	if err == nil {
+		data, overflow, err = textLimits.Apply(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parseDocumentWithOptions(data, options)
+	if err != nil {
+		return nil, err
+	}
+	doc.Overflow = overflow
+	doc.ProcessingLog = processingLog
+	applyDateProfile(doc, dateProfileFor(source))
+	validateDocumentWith(doc, options.Schemas, time.Now())
+	return doc, nil
+}
@@ -0,0 +1,335 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	INGEST_RULES_ENV = "DOC_INGEST_RULES" // Environment variable with the path of a JSON file of rules changing documents before they are stored
+
+	INGEST_ACTION_STRIP  = "strip"  // Action removing elements with their content, or attributes
+	INGEST_ACTION_RENAME = "rename" // Action renaming elements or attributes
+
+	DB_PROCESSINGLOG_FIELD_NAME = "processing_log" // Field name for the processing log of the document as JSON in SQLite table
+)
+
+// IngestRule strips or renames elements or attributes of documents before they are stored, e.g. tracking pixels
+// A rule with an Attribute changes that attribute of the elements named Element, of all elements if Element is
+// empty. A rule without one changes the elements named Element themselves. Where restricts a rule to the elements
+// with the given attribute values, like {"width": "1", "height": "1"}.
+type IngestRule struct {
+	Element   string            `json:",omitempty"`
+	Attribute string            `json:",omitempty"`
+	Where     map[string]string `json:",omitempty"`
+	Action    string            // Action is INGEST_ACTION_STRIP or INGEST_ACTION_RENAME
+	To        string            `json:",omitempty"` // To is the new name of renamed elements or attributes
+}
+
+// IngestRules are applied in order to every start tag, so a rule matches the element name given by the rules before it
+type IngestRules []IngestRule
+
+// ProcessingEntry records a change the ingest rules made to a document
+type ProcessingEntry struct {
+	Rule   string // Rule describes the rule, like "strip element img[width=1]"
+	Count  int    // Count is the number of elements or attributes the rule changed
+	Source string // Source is where the changed XML came from, like "http:192.0.2.1" or "reprocess:42"
+	At     string // At is the time of the change in UTC
+}
+
+// ingestRules are the rules of the server, set by initIngestRules
+var ingestRules IngestRules
+
+// initIngestRules loads the ingest rules from the file named by the environment
+func initIngestRules() {
+	funcName := "initIngestRules"
+
+	path := os.Getenv(INGEST_RULES_ENV)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s: %v", funcName, INGEST_RULES_ENV, err)
+	}
+	rules, err := parseIngestRules(data)
+	if err != nil {
+		log.Fatalf("%s: Invalid ingest rules in %s: %v", funcName, path, err)
+	}
+	ingestRules = rules
+}
+
+// parseIngestRules parses a JSON array of ingest rules and checks them
+func parseIngestRules(data []byte) (IngestRules, error) {
+	var rules IngestRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		if err := rule.check(); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
+	return rules, nil
+}
+
+// check returns an error if the rule is incomplete or names something which can't be a name
+func (rule IngestRule) check() error {
+	if rule.Element == "" && rule.Attribute == "" {
+		return fmt.Errorf("rule needs an element or an attribute")
+	}
+	for _, name := range []string{rule.Element, rule.Attribute} {
+		if name != "" && !isIngestName(name) {
+			return fmt.Errorf("invalid name %q", name)
+		}
+	}
+	switch rule.Action {
+	case INGEST_ACTION_STRIP:
+		if rule.To != "" {
+			return fmt.Errorf("%s takes no new name", rule.Action)
+		}
+	case INGEST_ACTION_RENAME:
+		if !isIngestName(rule.To) {
+			return fmt.Errorf("invalid new name %q", rule.To)
+		}
+	default:
+		return fmt.Errorf("action must be %s or %s", INGEST_ACTION_STRIP, INGEST_ACTION_RENAME)
+	}
+	return nil
+}
+
+// isIngestName reports whether name can be the name of an element or attribute, like "img" or "vnd:id"
+func isIngestName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, XML_WHITESPACE+`<>/="'&`)
+}
+
+// String describes the rule for the processing log, like "rename attribute data-id of div to id"
+func (rule IngestRule) String() string {
+	target := "element " + rule.Element
+	if rule.Attribute != "" {
+		target = "attribute " + rule.Attribute
+		if rule.Element != "" {
+			target += " of " + rule.Element
+		}
+	}
+	if len(rule.Where) > 0 {
+		var conditions []string
+		for name, value := range rule.Where {
+			conditions = append(conditions, name+"="+value)
+		}
+		sort.Strings(conditions)
+		target += "[" + strings.Join(conditions, ",") + "]"
+	}
+	if rule.Action == INGEST_ACTION_RENAME {
+		return rule.Action + " " + target + " to " + rule.To
+	}
+	return rule.Action + " " + target
+}
+
+// matches reports whether the rule applies to the element name with the attributes attrs
+func (rule IngestRule) matches(name string, attrs map[string]string) bool {
+	if rule.Element != "" && rule.Element != name {
+		return false
+	}
+	for key, value := range rule.Where {
+		if actual, ok := attrs[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// attributeSpan is an attribute of a start tag with its text as written, like `id="1"`
+type attributeSpan struct {
+	Name string
+	Text string
+}
+
+// attributeSpans splits an attribute string like parseAttributes does, keeping the order and text of the attributes
+func attributeSpans(attrs string) []attributeSpan {
+	var spans []attributeSpan
+	for attrs != "" {
+		eq := strings.Index(attrs, "=")
+		if eq < 0 {
+			break
+		}
+		rest := strings.TrimLeft(attrs[eq+1:], XML_WHITESPACE)
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			break
+		}
+		end := strings.IndexByte(rest[1:], rest[0])
+		if end < 0 {
+			break
+		}
+		length := len(attrs) - len(rest) + end + 2
+		spans = append(spans, attributeSpan{Name: strings.TrimSpace(attrs[:eq]), Text: attrs[:length]})
+		attrs = strings.TrimLeft(attrs[length:], XML_WHITESPACE)
+	}
+	return spans
+}
+
+// Apply runs the rules over data and returns the changed XML with an entry per rule which changed something
+// Root elements are never stripped, and data the tags of which can't be scanned is left to the parser unchanged.
+// Documents no rule applies to are returned as they are, byte for byte.
+func (rules IngestRules) Apply(data string, source string, now time.Time) (string, []ProcessingEntry) {
+	if len(rules) == 0 {
+		return data, nil
+	}
+	tags, err := scanXMLTags(data)
+	if err != nil {
+		return data, nil
+	}
+
+	// openElement is an element whose end tag is still to come
+	type openElement struct {
+		Name    string // Name is the name of the start tag
+		NewName string // NewName is the name given by the rules
+	}
+
+	counts := make([]int, len(rules))
+	var out strings.Builder
+	last := 0              // last is the index of data up to which data was written or skipped
+	var open []openElement // open holds the open elements, innermost last
+	skipping := 0          // skipping is the depth of the stripped element the tags are skipped in, 0 if none
+	for _, tag := range tags {
+		end := tag.Index + len(tag.Tag)
+		closing := strings.HasPrefix(tag.Tag, "</")
+		selfClosing := !closing && strings.HasSuffix(tag.Tag, "/>")
+
+		if skipping > 0 {
+			switch {
+			case closing:
+				open = open[:len(open)-1]
+			case !selfClosing:
+				open = append(open, openElement{})
+			}
+			if len(open) < skipping {
+				skipping, last = 0, end
+			}
+			continue
+		}
+
+		if closing {
+			if len(open) == 0 {
+				return data, nil
+			}
+			element := open[len(open)-1]
+			open = open[:len(open)-1]
+			// End tags not matching their start tag are left for the parser to report
+			if element.NewName != element.Name && tagName(tag.Tag) == element.Name {
+				out.WriteString(data[last:tag.Index])
+				out.WriteString("</" + element.NewName + ">")
+				last = end
+			}
+			continue
+		}
+
+		content := strings.TrimSuffix(tag.Tag[1:len(tag.Tag)-1], "/")
+		name, attrs := content, ""
+		if i := strings.IndexAny(content, XML_WHITESPACE); i >= 0 {
+			name, attrs = content[:i], strings.TrimSpace(content[i+1:])
+		}
+		values := parseAttributes(attrs)
+		spans := attributeSpans(attrs)
+		newName, changed, stripped := name, false, false
+		for i, rule := range rules {
+			if !rule.matches(newName, values) {
+				continue
+			}
+			if rule.Attribute == "" {
+				if rule.Action == INGEST_ACTION_STRIP && len(open) > 0 {
+					counts[i]++
+					stripped = true
+					break
+				}
+				if rule.Action == INGEST_ACTION_RENAME {
+					counts[i]++
+					newName, changed = rule.To, true
+				}
+				continue
+			}
+			for j := 0; j < len(spans); j++ {
+				if spans[j].Name != rule.Attribute {
+					continue
+				}
+				counts[i]++
+				changed = true
+				if rule.Action == INGEST_ACTION_STRIP {
+					spans = append(spans[:j], spans[j+1:]...)
+					j--
+					continue
+				}
+				spans[j] = attributeSpan{Name: rule.To, Text: rule.To + spans[j].Text[len(spans[j].Name):]}
+			}
+		}
+
+		switch {
+		case stripped:
+			out.WriteString(data[last:tag.Index])
+			last = end
+			if !selfClosing {
+				open = append(open, openElement{Name: name, NewName: newName})
+				skipping = len(open)
+			}
+			continue
+		case changed:
+			out.WriteString(data[last:tag.Index])
+			out.WriteString("<" + newName)
+			for _, span := range spans {
+				out.WriteString(" " + span.Text)
+			}
+			if selfClosing {
+				out.WriteString("/>")
+			} else {
+				out.WriteString(">")
+			}
+			last = end
+		}
+		if !selfClosing {
+			open = append(open, openElement{Name: name, NewName: newName})
+		}
+	}
+
+	var entries []ProcessingEntry
+	for i, count := range counts {
+		if count > 0 {
+			entries = append(entries, ProcessingEntry{Rule: rules[i].String(), Count: count, Source: source, At: now.UTC().Format(time.RFC3339)})
+		}
+	}
+	if entries == nil {
+		return data, nil
+	}
+	out.WriteString(data[last:])
+	return out.String(), entries
+}
+
+// encodeProcessingLog encodes a processing log for the processing_log column
+func encodeProcessingLog(entries []ProcessingEntry) (string, error) {
+	if len(entries) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeProcessingLog decodes the content of the processing_log column
+func decodeProcessingLog(data string) ([]ProcessingEntry, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var entries []ProcessingEntry
+	err := json.Unmarshal([]byte(data), &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
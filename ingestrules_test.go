@@ -0,0 +1,129 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that incomplete or invalid ingest rules are rejected
+func TestParseIngestRules(t *testing.T) {
+	rules, err := parseIngestRules([]byte(`[{"Element": "img", "Where": {"width": "1"}, "Action": "strip"}, {"Attribute": "data-id", "Action": "rename", "To": "id"}]`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, "strip element img[width=1]", rules[0].String())
+	require.Equal(t, "rename attribute data-id to id", rules[1].String())
+
+	for _, data := range []string{
+		`{"Element": "img", "Action": "strip"}`,
+		`[{"Action": "strip"}]`,
+		`[{"Element": "img", "Action": "drop"}]`,
+		`[{"Element": "img", "Action": "rename"}]`,
+		`[{"Element": "img", "Action": "rename", "To": "a b"}]`,
+		`[{"Element": "img", "Action": "strip", "To": "image"}]`,
+		`[{"Element": "<img>", "Action": "strip"}]`,
+	} {
+		_, err := parseIngestRules([]byte(data))
+		require.Error(t, err, data)
+	}
+}
+
+// Test stripping and renaming elements and attributes
+func TestApplyIngestRules(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rules := IngestRules{
+		{Element: "img", Where: map[string]string{"width": "1", "height": "1"}, Action: INGEST_ACTION_STRIP},
+		{Element: "vnd:meta", Action: INGEST_ACTION_STRIP},
+		{Element: "para", Action: INGEST_ACTION_RENAME, To: "p"},
+		{Attribute: "data-track", Action: INGEST_ACTION_STRIP},
+		{Element: "p", Attribute: "vnd:id", Action: INGEST_ACTION_RENAME, To: "id"},
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		data    string
+		result  string
+		entries []ProcessingEntry
+	}{
+		{
+			desc:    "tracking pixel",
+			data:    `<doc><img src="a.png" width="1" height="1"/><img src="b.png" width="10" height="1"/></doc>`,
+			result:  `<doc><img src="b.png" width="10" height="1"/></doc>`,
+			entries: []ProcessingEntry{{Rule: "strip element img[height=1,width=1]", Count: 1, Source: "test", At: "2024-05-01T12:00:00Z"}},
+		},
+		{
+			desc:    "nested element",
+			data:    "<doc><vnd:meta><vnd:meta>x</vnd:meta><a/></vnd:meta>\n<title>T</title></doc>",
+			result:  "<doc>\n<title>T</title></doc>",
+			entries: []ProcessingEntry{{Rule: "strip element vnd:meta", Count: 1, Source: "test", At: "2024-05-01T12:00:00Z"}},
+		},
+		{
+			desc:   "renamed element and attributes",
+			data:   `<doc data-track="1"><para vnd:id="p1" data-track='2' class="x">A <b>B</b></para><para/></doc>`,
+			result: `<doc><p id="p1" class="x">A <b>B</b></p><p/></doc>`,
+			entries: []ProcessingEntry{
+				{Rule: "rename element para to p", Count: 2, Source: "test", At: "2024-05-01T12:00:00Z"},
+				{Rule: "strip attribute data-track", Count: 2, Source: "test", At: "2024-05-01T12:00:00Z"},
+				{Rule: "rename attribute vnd:id of p to id", Count: 1, Source: "test", At: "2024-05-01T12:00:00Z"},
+			},
+		},
+		{
+			desc:    "root element",
+			data:    `<vnd:meta><para>A</para></vnd:meta>`,
+			result:  `<vnd:meta><p>A</p></vnd:meta>`,
+			entries: []ProcessingEntry{{Rule: "rename element para to p", Count: 1, Source: "test", At: "2024-05-01T12:00:00Z"}},
+		},
+		{
+			desc:   "unchanged",
+			data:   "<doc>\n  <img src='a.png'  width=\"1\"/>\n</doc>",
+			result: "<doc>\n  <img src='a.png'  width=\"1\"/>\n</doc>",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			result, entries := rules.Apply(tt.data, "test", now)
+			require.Equal(t, tt.result, result)
+			require.Equal(t, tt.entries, entries)
+		})
+	}
+}
+
+// Test that documents are stored with the ingest rules applied and the changes in their processing log
+func TestIngestRulesProcessingLog(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	ingestRules = IngestRules{{Element: "img", Where: map[string]string{"width": "1"}, Action: INGEST_ACTION_STRIP}}
+	defer func() { ingestRules = nil }()
+
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add", strings.NewReader(`<doc id="1"><title>T</title><img src="t.gif" width="1"/><vnd:note>x</vnd:note></doc>`)))
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode, w.Body.String())
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.NotContains(t, doc.rawXML(), "t.gif")
+	require.Len(t, doc.ProcessingLog, 1)
+	require.Equal(t, "strip element img[width=1]", doc.ProcessingLog[0].Rule)
+	require.True(t, strings.HasPrefix(doc.ProcessingLog[0].Source, "http:"), doc.ProcessingLog[0].Source)
+
+	// Reprocessing with the same rules changes nothing, a new rule is logged after the first
+	changed, err := reprocessDocument(db, "1")
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	ingestRules = append(ingestRules, IngestRule{Element: "vnd:note", Action: INGEST_ACTION_STRIP})
+	changed, err = reprocessDocument(db, "1")
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	doc, err = getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.NotContains(t, doc.rawXML(), "vnd:note")
+	require.Len(t, doc.ProcessingLog, 2)
+	require.Equal(t, "strip element vnd:note", doc.ProcessingLog[1].Rule)
+	require.Equal(t, "reprocess:1", doc.ProcessingLog[1].Source)
+}
@@ -0,0 +1,88 @@
+package goapp
+
+import (
+	"strings"
+)
+
+const (
+	PI_START        = "<?"  // Start of a processing instruction, which may hold '<' and '>'
+	PI_END          = "?>"  // End of a processing instruction
+	XML_DECL_TARGET = "xml" // Target of the XML declaration like <?xml version="1.0"?>
+)
+
+// XMLDeclaration holds the pseudo-attributes of the XML declaration of a document
+type XMLDeclaration struct {
+	Version    string
+	Encoding   string `json:",omitempty"`
+	Standalone string `json:",omitempty"` // Standalone is "yes" or "no", empty if not declared
+}
+
+// ProcessingInstruction is a processing instruction like <?php echo 1 ?> or <?xml-stylesheet href="a.xsl"?>
+type ProcessingInstruction struct {
+	Target string
+	Data   string `json:",omitempty"`
+}
+
+// parseInstructions returns the XML declaration of data, nil if it has none, and its other processing instructions
+// Only an instruction with the target xml at the very beginning is the declaration.
+func parseInstructions(data string) (*XMLDeclaration, []ProcessingInstruction) {
+	var declaration *XMLDeclaration
+	var instructions []ProcessingInstruction
+
+	start := len(data) - len(strings.TrimLeft(strings.TrimPrefix(data, UTF8_BOM), " \t\r\n"))
+	for i := 0; i < len(data); i++ {
+		if data[i] != '<' {
+			continue
+		}
+		end := sectionEnd(data, i)
+		if end <= 0 {
+			continue
+		}
+		if strings.HasPrefix(data[i:], PI_START) {
+			instruction := data[i+len(PI_START) : end-len(PI_END)]
+			target, content := instruction, ""
+			if j := strings.IndexAny(instruction, " \t\r\n"); j >= 0 {
+				target, content = instruction[:j], strings.TrimSpace(instruction[j+1:])
+			}
+
+			if target == XML_DECL_TARGET && i == start {
+				attrs := parseAttributes(content)
+				declaration = &XMLDeclaration{Version: attrs["version"], Encoding: attrs["encoding"], Standalone: attrs["standalone"]}
+			} else {
+				instructions = append(instructions, ProcessingInstruction{Target: target, Data: content})
+			}
+		}
+		i = end - 1
+	}
+	return declaration, instructions
+}
+
+// splitProlog splits data into the prolog before the root element, like the XML declaration and a DOCTYPE, and the rest
+func splitProlog(data string) (string, string) {
+	for i := 0; i < len(data); i++ {
+		if data[i] != '<' {
+			continue
+		}
+		if end := sectionEnd(data, i); end > 0 {
+			i = end - 1
+			continue
+		}
+		if strings.HasPrefix(data[i:], "<!") {
+			continue
+		}
+		return data[:i], data[i:]
+	}
+	return data, ""
+}
+
+// rawXML returns the XML of the document as stored, its root element after its prolog
+func (doc *XMLDoc) rawXML() string {
+	raw := ""
+	if len(doc.XMLData) > 0 {
+		raw = doc.XMLData[0]
+	}
+	if doc.Prolog == "" {
+		return raw
+	}
+	return doc.Prolog + "\n" + raw
+}
@@ -0,0 +1,95 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing the XML declaration and other processing instructions
+func TestParseInstructions(t *testing.T) {
+	tests := []struct {
+		desc         string
+		data         string
+		declaration  *XMLDeclaration
+		instructions []ProcessingInstruction
+	}{
+		{
+			desc:        "declaration",
+			data:        `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><document/>`,
+			declaration: &XMLDeclaration{Version: "1.0", Encoding: "UTF-8", Standalone: "yes"},
+		},
+		{
+			desc:        "declaration after BOM and whitespace",
+			data:        UTF8_BOM + "\n<?xml version='1.0'?>\n<document/>",
+			declaration: &XMLDeclaration{Version: "1.0"},
+		},
+		{
+			desc:         "instructions",
+			data:         `<?xml-stylesheet href="a.xsl"?><document><?php if ($a > 1) echo 1; ?><!-- <?skipped?> --><?break?></document>`,
+			instructions: []ProcessingInstruction{{Target: "xml-stylesheet", Data: `href="a.xsl"`}, {Target: "php", Data: "if ($a > 1) echo 1;"}, {Target: "break"}},
+		},
+		{
+			desc:         "misplaced declaration",
+			data:         `<document><?xml version="1.0"?></document>`,
+			instructions: []ProcessingInstruction{{Target: "xml", Data: `version="1.0"`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			declaration, instructions := parseInstructions(tt.data)
+			require.Equal(t, tt.declaration, declaration)
+			require.Equal(t, tt.instructions, instructions)
+		})
+	}
+}
+
+// Test parsing documents with processing instructions, which used to break tag pairing
+func TestParseDocumentInstructions(t *testing.T) {
+	data := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE document>\n<document><?php if ($a > 1) { echo '<b>'; } ?><title>Test Title</title></document>"
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "Test Title", doc.Title)
+	require.Equal(t, &XMLDeclaration{Version: "1.0", Encoding: "UTF-8"}, doc.Declaration)
+	require.Equal(t, []ProcessingInstruction{{Target: "php", Data: "if ($a > 1) { echo '<b>'; }"}}, doc.Instructions)
+	require.Equal(t, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE document>", doc.Prolog)
+	tree := &Node{Name: "document", Children: []*Node{{Name: "title", Text: "Test Title"}}}
+	tree.setParents()
+	require.Equal(t, tree, doc.Tree)
+	require.Equal(t, DocumentStats{Words: 2, Characters: 10, Elements: 2, MaxDepth: 2}, doc.Stats)
+
+	_, err = parseDocument("<document><?php echo 1</document>")
+	require.EqualError(t, err, "unterminated CDATA section, comment or processing instruction at line 1, column 11")
+}
+
+// Test keeping the prolog of stored documents
+func TestStoredProlog(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	data := "<?xml version=\"1.0\"?>\n<document><title>Test Title</title></document>"
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	stored, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, data, stored.rawXML())
+	require.Equal(t, &XMLDeclaration{Version: "1.0"}, stored.Declaration)
+
+	// Reprocessing parses the prolog again instead of dropping it
+	changed, err := reprocessDocument(db, "1")
+	require.NoError(t, err)
+	require.False(t, changed)
+}
+
+// Test splitting the prolog from the root element
+func TestSplitProlog(t *testing.T) {
+	prolog, rest := splitProlog(`<?xml version="1.0"?><!-- <a> --><!DOCTYPE a><a/>`)
+	require.Equal(t, `<?xml version="1.0"?><!-- <a> --><!DOCTYPE a>`, prolog)
+	require.Equal(t, "<a/>", rest)
+
+	prolog, rest = splitProlog("<a/>")
+	require.Empty(t, prolog)
+	require.Equal(t, "<a/>", rest)
+}
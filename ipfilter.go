@@ -0,0 +1,125 @@
+package goapp
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	READ_ALLOW_ENV  = "DOC_READ_ALLOW"  // Environment variable with the CIDRs allowed to call read endpoints
+	READ_DENY_ENV   = "DOC_READ_DENY"   // Environment variable with the CIDRs denied on read endpoints
+	WRITE_ALLOW_ENV = "DOC_WRITE_ALLOW" // Environment variable with the CIDRs allowed to call write endpoints
+	WRITE_DENY_ENV  = "DOC_WRITE_DENY"  // Environment variable with the CIDRs denied on write endpoints
+)
+
+// IPPolicy holds the CIDR rules of one kind of endpoint
+// Deny rules win over allow rules, and an empty allow list allows everything not denied
+type IPPolicy struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// ipPolicies maps an access (ACCESS_READ or ACCESS_WRITE) to its policy, set by initIPPolicies
+var ipPolicies = map[string]IPPolicy{}
+
+// initIPPolicies loads the read and write policies from the environment
+func initIPPolicies() {
+	funcName := "initIPPolicies"
+
+	envs := map[string][2]string{
+		ACCESS_READ:  {READ_ALLOW_ENV, READ_DENY_ENV},
+		ACCESS_WRITE: {WRITE_ALLOW_ENV, WRITE_DENY_ENV},
+	}
+	for access, env := range envs {
+		allow, err := parseCIDRs(os.Getenv(env[0]))
+		if err != nil {
+			log.Fatalf("%s: Invalid %s: %v", funcName, env[0], err)
+		}
+		deny, err := parseCIDRs(os.Getenv(env[1]))
+		if err != nil {
+			log.Fatalf("%s: Invalid %s: %v", funcName, env[1], err)
+		}
+		ipPolicies[access] = IPPolicy{Allow: allow, Deny: deny}
+	}
+}
+
+// parseCIDRs parses a comma-separated list of CIDRs, plain IPs are taken as single-address networks
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %s", part)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether one of the networks contains ip
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the policy lets ip through
+func (policy IPPolicy) allows(ip net.IP) bool {
+	if containsIP(policy.Deny, ip) {
+		return false
+	}
+	return len(policy.Allow) == 0 || containsIP(policy.Allow, ip)
+}
+
+// clientIP returns the IP of the client which sent the request, taken from X-Forwarded-For behind a trusted proxy
+func clientIP(r *http.Request) net.IP {
+	if fromTrustedProxy(r) {
+		if ip := forwardedClientIP(r); ip != nil {
+			return ip
+		}
+	}
+	return peerIP(r)
+}
+
+// requireIPPolicy is a middleware rejecting clients not allowed by the policy of the access
+func requireIPPolicy(access string, next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		policy, ok := ipPolicies[access]
+		if ok && (len(policy.Allow) > 0 || len(policy.Deny) > 0) {
+			ip := clientIP(r)
+			if ip == nil || !policy.allows(ip) {
+				http.Error(w, "Access denied for this address", http.StatusForbidden)
+				return
+			}
+		}
+
+		next(db, w, r)
+	}
+}
@@ -0,0 +1,80 @@
+package goapp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test evaluating allow and deny rules
+func TestIPPolicyAllows(t *testing.T) {
+	allow, err := parseCIDRs("10.0.0.0/8, 192.168.1.5")
+	require.NoError(t, err)
+	deny, err := parseCIDRs("10.1.0.0/16")
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc     string
+		policy   IPPolicy
+		ip       string
+		expected bool
+	}{
+		{desc: "empty policy", policy: IPPolicy{}, ip: "8.8.8.8", expected: true},
+		{desc: "allowed network", policy: IPPolicy{Allow: allow}, ip: "10.2.3.4", expected: true},
+		{desc: "allowed single address", policy: IPPolicy{Allow: allow}, ip: "192.168.1.5", expected: true},
+		{desc: "not allowed", policy: IPPolicy{Allow: allow}, ip: "192.168.1.6", expected: false},
+		{desc: "deny wins over allow", policy: IPPolicy{Allow: allow, Deny: deny}, ip: "10.1.2.3", expected: false},
+		{desc: "deny only", policy: IPPolicy{Deny: deny}, ip: "8.8.8.8", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.policy.allows(net.ParseIP(tt.ip)))
+		})
+	}
+
+	_, err = parseCIDRs("10.0.0.0/33")
+	require.Error(t, err)
+	_, err = parseCIDRs("localhost")
+	require.Error(t, err)
+}
+
+// Test that read and write endpoints use separate policies and are checked before authentication
+func TestRequireIPPolicy(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	writeAllow, err := parseCIDRs("10.0.0.0/8")
+	require.NoError(t, err)
+	ipPolicies = map[string]IPPolicy{ACCESS_WRITE: {Allow: writeAllow}}
+	apiKey = "test api key"
+	defer func() {
+		ipPolicies = map[string]IPPolicy{}
+		apiKey = ""
+	}()
+
+	tests := []struct {
+		desc         string
+		method       string
+		path         string
+		remoteAddr   string
+		expectedCode int
+	}{
+		{desc: "read from anywhere", method: "GET", path: "/list", remoteAddr: "8.8.8.8:1234", expectedCode: http.StatusUnauthorized},
+		{desc: "write from outside", method: "POST", path: "/add", remoteAddr: "8.8.8.8:1234", expectedCode: http.StatusForbidden},
+		{desc: "write from allowed network", method: "POST", path: "/add", remoteAddr: "10.0.0.1:1234", expectedCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+
+			handleRequest(db, w, req)
+
+			require.Equal(t, tt.expectedCode, w.Result().StatusCode)
+		})
+	}
+}
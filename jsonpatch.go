@@ -0,0 +1,286 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	JSON_PATCH_CONTENT_TYPE = "application/json-patch+json" // Content type of RFC 6902 JSON Patches of the metadata sent to PATCH /document
+
+	JSON_PATCH_OP_ADD     = "add"
+	JSON_PATCH_OP_REMOVE  = "remove"
+	JSON_PATCH_OP_REPLACE = "replace"
+	JSON_PATCH_OP_MOVE    = "move"
+	JSON_PATCH_OP_COPY    = "copy"
+	JSON_PATCH_OP_TEST    = "test"
+
+	JSON_PATCH_TAGS_PATH = "/Tags" // JSON pointer of the tags, whose elements JSON Patches can change like "/Tags/0" or "/Tags/-"
+)
+
+// metadataElements maps the metadata fields JSON Patches can change, by their JSON name, to their elements
+var metadataElements = map[string]string{
+	"Title":       XML_TITLE_FIELD,
+	"Description": XML_DESCRIPTION_FIELD,
+	"Author":      XML_AUTHOR_FIELD,
+	"CreatedAt":   XML_CREATEDAT_FIELD,
+	"ExpiresAt":   XML_EXPIRESAT_FIELD,
+}
+
+// JSONPatchOperation is an operation of a JSON Patch
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// parseJSONPatch parses a JSON Patch of the metadata and checks its operations
+func parseJSONPatch(data []byte) ([]JSONPatchOperation, error) {
+	var ops []JSONPatchOperation
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if isTagPointer(op.Path) {
+			if err := checkTagOperation(op); err != nil {
+				return nil, fmt.Errorf("operation %d: %v", i+1, err)
+			}
+			continue
+		}
+		switch op.Op {
+		case JSON_PATCH_OP_ADD, JSON_PATCH_OP_REPLACE, JSON_PATCH_OP_TEST:
+			var value string
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d: value must be a string", i+1)
+			}
+		case JSON_PATCH_OP_MOVE, JSON_PATCH_OP_COPY:
+			if _, err := metadataField(op.From); err != nil {
+				return nil, fmt.Errorf("operation %d: %v", i+1, err)
+			}
+		case JSON_PATCH_OP_REMOVE:
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i+1, op.Op)
+		}
+		if _, err := metadataField(op.Path); err != nil {
+			return nil, fmt.Errorf("operation %d: %v", i+1, err)
+		}
+	}
+	return ops, nil
+}
+
+// metadataField returns the field a JSON pointer like "/Title" points to
+func metadataField(pointer string) (string, error) {
+	field := strings.TrimPrefix(pointer, "/")
+	if _, ok := metadataElements[field]; !ok || !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("path %q isn't a metadata field", pointer)
+	}
+	return field, nil
+}
+
+// isTagPointer reports whether a JSON pointer points to an element of the tags, like "/Tags/0"
+func isTagPointer(pointer string) bool {
+	return strings.HasPrefix(pointer, JSON_PATCH_TAGS_PATH+"/")
+}
+
+// tagIndex returns the index of the tag a JSON pointer like "/Tags/0" points to, len(tags) for "/Tags/-"
+func tagIndex(pointer string, tags []string) (int, error) {
+	index := strings.TrimPrefix(pointer, JSON_PATCH_TAGS_PATH+"/")
+	if index == "-" {
+		return len(tags), nil
+	}
+	// RFC 6901 indexes have no sign and no leading zeros
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 || strconv.Itoa(n) != index {
+		return 0, fmt.Errorf("path %q isn't a tag", pointer)
+	}
+	return n, nil
+}
+
+// checkTagOperation checks an operation on a tag: tags are added, removed, replaced or tested, but not moved or copied
+func checkTagOperation(op JSONPatchOperation) error {
+	if op.Op == JSON_PATCH_OP_MOVE || op.Op == JSON_PATCH_OP_COPY {
+		return fmt.Errorf("tags can't be moved or copied")
+	}
+	if _, err := tagIndex(op.Path, nil); err != nil {
+		return err
+	}
+	switch op.Op {
+	case JSON_PATCH_OP_ADD, JSON_PATCH_OP_REPLACE, JSON_PATCH_OP_TEST:
+		var value string
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return fmt.Errorf("value must be a string")
+		}
+		if op.Op != JSON_PATCH_OP_TEST {
+			return validateTag(value)
+		}
+	case JSON_PATCH_OP_REMOVE:
+		if strings.HasSuffix(op.Path, "/-") {
+			return fmt.Errorf("path %q isn't a tag", op.Path)
+		}
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	return nil
+}
+
+// applyTagOperation applies an operation on a tag to the tags, which stay in alphabetical order without duplicates
+// like documents return them, so the indexes of later operations point to the tags a client sees.
+func applyTagOperation(tags []string, op JSONPatchOperation) ([]string, error) {
+	index, _ := tagIndex(op.Path, tags)
+	var value string
+	json.Unmarshal(op.Value, &value)
+
+	exists := index < len(tags)
+	switch op.Op {
+	case JSON_PATCH_OP_ADD:
+		if index > len(tags) {
+			return nil, fmt.Errorf("%s is after the last tag", op.Path)
+		}
+		tags = append(tags, value)
+	case JSON_PATCH_OP_REPLACE:
+		if !exists {
+			return nil, fmt.Errorf("%s has no tag to replace", op.Path)
+		}
+		tags[index] = value
+	case JSON_PATCH_OP_REMOVE:
+		if !exists {
+			return nil, fmt.Errorf("%s has no tag to remove", op.Path)
+		}
+		tags = append(tags[:index], tags[index+1:]...)
+	case JSON_PATCH_OP_TEST:
+		if !exists || tags[index] != value {
+			return nil, fmt.Errorf("test of %s failed", op.Path)
+		}
+	}
+
+	sort.Strings(tags)
+	unique := tags[:0]
+	for i, tag := range tags {
+		if i == 0 || tag != tags[i-1] {
+			unique = append(unique, tag)
+		}
+	}
+	return unique, nil
+}
+
+// documentMetadata returns the metadata fields of a document which have a value
+func documentMetadata(doc *XMLDoc) map[string]string {
+	metadata := map[string]string{}
+	for field, value := range map[string]string{
+		"Title":       doc.Title,
+		"Description": doc.Description,
+		"Author":      doc.Author,
+		"CreatedAt":   doc.CreatedAt,
+		"ExpiresAt":   doc.ExpiresAt,
+	} {
+		if value != "" {
+			metadata[field] = value
+		}
+	}
+	return metadata
+}
+
+// applyJSONPatch applies the operations to the metadata of doc and writes the changed fields to the tree of root
+// The operations are applied all or none, like RFC 6902 requires. The XML stays the source of the metadata,
+// so the changes are kept when the document is reprocessed. Tags aren't part of the XML, the changed tags are
+// set on doc for storing them with the patched document.
+func applyJSONPatch(doc *XMLDoc, root *Node, ops []JSONPatchOperation) error {
+	before := documentMetadata(doc)
+	metadata := documentMetadata(doc)
+	tags := append([]string{}, doc.Tags...)
+	for i, op := range ops {
+		if isTagPointer(op.Path) {
+			var err error
+			tags, err = applyTagOperation(tags, op)
+			if err != nil {
+				return fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i+1, err)
+			}
+			continue
+		}
+		field, _ := metadataField(op.Path)
+		var value string
+		json.Unmarshal(op.Value, &value)
+
+		current, exists := metadata[field]
+		switch op.Op {
+		case JSON_PATCH_OP_ADD:
+			metadata[field] = value
+		case JSON_PATCH_OP_REPLACE:
+			if !exists {
+				return fmt.Errorf("%w: operation %d: %s has no value to replace", ErrInvalidPatch, i+1, op.Path)
+			}
+			metadata[field] = value
+		case JSON_PATCH_OP_REMOVE:
+			if !exists {
+				return fmt.Errorf("%w: operation %d: %s has no value to remove", ErrInvalidPatch, i+1, op.Path)
+			}
+			delete(metadata, field)
+		case JSON_PATCH_OP_MOVE, JSON_PATCH_OP_COPY:
+			from, _ := metadataField(op.From)
+			fromValue, ok := metadata[from]
+			if !ok {
+				return fmt.Errorf("%w: operation %d: %s has no value to %s", ErrInvalidPatch, i+1, op.From, op.Op)
+			}
+			if op.Op == JSON_PATCH_OP_MOVE {
+				delete(metadata, from)
+			}
+			metadata[field] = fromValue
+		case JSON_PATCH_OP_TEST:
+			if !exists || current != value {
+				return fmt.Errorf("%w: operation %d: test of %s failed", ErrInvalidPatch, i+1, op.Path)
+			}
+		}
+	}
+
+	for field, element := range metadataElements {
+		value, ok := metadata[field]
+		if previous, had := before[field]; ok == had && value == previous {
+			continue
+		}
+		writeMetadata(root, element, value, ok)
+	}
+	if len(tags) == 0 {
+		tags = nil
+	}
+	doc.Tags = tags
+	return nil
+}
+
+// writeMetadata sets the text of the metadata element of root, adding it if missing, or removes it if not present
+// The metadata element is the outermost one with the name and without xml:lang, like parseDocument picks it.
+func writeMetadata(root *Node, element string, value string, present bool) {
+	node := metadataNode(root, element)
+	switch {
+	case present && node != nil:
+		node.setText(value)
+	case present:
+		root.Children = append(root.Children, &Node{Name: element, Text: value, Parent: root})
+	case node != nil:
+		node.Parent.removeChild(childIndex(node))
+	}
+
+	// The expiry may also be an attribute of the root, which is only used without an element
+	if element == XML_EXPIRESAT_FIELD && !present {
+		delete(root.Attrs, XML_EXPIRES_ATTRIBUTE)
+	}
+}
+
+// metadataNode returns the outermost element below root with the local name and without xml:lang, or nil
+func metadataNode(root *Node, element string) *Node {
+	level := root.Children
+	for len(level) > 0 {
+		var next []*Node
+		for _, node := range level {
+			if localName(node.Name) == element && node.Attrs[XML_LANG_ATTRIBUTE] == "" {
+				return node
+			}
+			next = append(next, node.Children...)
+		}
+		level = next
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test applying JSON Patches of the metadata to the element tree
+func TestApplyJSONPatch(t *testing.T) {
+	data := `<document expires="2030-01-01"><title>Old</title><title xml:lang="fr">Vieux</title><description>Text</description></document>`
+	tests := []struct {
+		desc     string
+		patch    string
+		expected string
+		err      string
+	}{
+		{
+			desc:     "replace",
+			patch:    `[{"op": "replace", "path": "/Title", "value": "New & better"}]`,
+			expected: `<document expires="2030-01-01"><title>New &amp; better</title><title xml:lang="fr">Vieux</title><description>Text</description></document>`,
+		},
+		{
+			desc:     "add missing field",
+			patch:    `[{"op": "add", "path": "/Author", "value": "Ann"}]`,
+			expected: `<document expires="2030-01-01"><title>Old</title><title xml:lang="fr">Vieux</title><description>Text</description><author>Ann</author></document>`,
+		},
+		{
+			desc:     "remove",
+			patch:    `[{"op": "remove", "path": "/Description"}, {"op": "remove", "path": "/ExpiresAt"}]`,
+			expected: `<document><title>Old</title><title xml:lang="fr">Vieux</title></document>`,
+		},
+		{
+			desc:     "move and copy",
+			patch:    `[{"op": "copy", "from": "/Title", "path": "/Author"}, {"op": "move", "from": "/Description", "path": "/Title"}]`,
+			expected: `<document expires="2030-01-01"><title>Text</title><title xml:lang="fr">Vieux</title><author>Old</author></document>`,
+		},
+		{
+			desc:     "test",
+			patch:    `[{"op": "test", "path": "/Title", "value": "Old"}, {"op": "replace", "path": "/Title", "value": "New"}]`,
+			expected: `<document expires="2030-01-01"><title>New</title><title xml:lang="fr">Vieux</title><description>Text</description></document>`,
+		},
+		{desc: "failed test", patch: `[{"op": "test", "path": "/Title", "value": "New"}]`, err: "operation 1: test of /Title failed"},
+		{desc: "replace missing field", patch: `[{"op": "replace", "path": "/Author", "value": "Ann"}]`, err: "operation 1: /Author has no value to replace"},
+		{desc: "remove missing field", patch: `[{"op": "remove", "path": "/Author"}]`, err: "operation 1: /Author has no value to remove"},
+		{desc: "copy missing field", patch: `[{"op": "copy", "from": "/Author", "path": "/Title"}]`, err: "operation 1: /Author has no value to copy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			doc, err := parseDocument(data)
+			require.NoError(t, err)
+			ops, err := parseJSONPatch([]byte(tt.patch))
+			require.NoError(t, err)
+
+			err = applyJSONPatch(doc, doc.Tree, ops)
+			if tt.err != "" {
+				require.True(t, errors.Is(err, ErrInvalidPatch))
+				require.EqualError(t, err, "invalid patch: "+tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, doc.Tree.String())
+		})
+	}
+}
+
+// Test adding, removing, replacing and testing tags with JSON Patches
+func TestApplyJSONPatchTags(t *testing.T) {
+	tests := []struct {
+		desc     string
+		patch    string
+		expected []string
+		err      string
+	}{
+		{desc: "add", patch: `[{"op": "add", "path": "/Tags/-", "value": "archive"}, {"op": "add", "path": "/Tags/0", "value": "invoices"}]`, expected: []string{"archive", "draft", "invoices", "urgent"}},
+		{desc: "add existing", patch: `[{"op": "add", "path": "/Tags/-", "value": "draft"}]`, expected: []string{"draft", "urgent"}},
+		{desc: "test and remove", patch: `[{"op": "test", "path": "/Tags/1", "value": "urgent"}, {"op": "remove", "path": "/Tags/1"}]`, expected: []string{"draft"}},
+		{desc: "replace", patch: `[{"op": "replace", "path": "/Tags/0", "value": "final"}]`, expected: []string{"final", "urgent"}},
+		{desc: "remove all", patch: `[{"op": "remove", "path": "/Tags/0"}, {"op": "remove", "path": "/Tags/0"}]`},
+		{desc: "failed test", patch: `[{"op": "test", "path": "/Tags/0", "value": "urgent"}]`, err: "operation 1: test of /Tags/0 failed"},
+		{desc: "remove missing tag", patch: `[{"op": "remove", "path": "/Tags/2"}]`, err: "operation 1: /Tags/2 has no tag to remove"},
+		{desc: "add after the last", patch: `[{"op": "add", "path": "/Tags/3", "value": "x"}]`, err: "operation 1: /Tags/3 is after the last tag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			doc, err := parseDocument("<document><title>Old</title></document>")
+			require.NoError(t, err)
+			doc.Tags = []string{"draft", "urgent"}
+			ops, err := parseJSONPatch([]byte(tt.patch))
+			require.NoError(t, err)
+
+			err = applyJSONPatch(doc, doc.Tree, ops)
+			if tt.err != "" {
+				require.True(t, errors.Is(err, ErrInvalidPatch))
+				require.EqualError(t, err, "invalid patch: "+tt.err)
+				require.Equal(t, []string{"draft", "urgent"}, doc.Tags)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, doc.Tags)
+			require.Equal(t, "<document><title>Old</title></document>", doc.Tree.String())
+		})
+	}
+}
+
+// Test rejecting malformed JSON Patches
+func TestParseJSONPatchInvalid(t *testing.T) {
+	tests := []struct {
+		desc  string
+		patch string
+		err   string
+	}{
+		{desc: "not a list", patch: `{"op": "add"}`, err: "json: cannot unmarshal object into Go value of type []goapp.JSONPatchOperation"},
+		{desc: "unknown op", patch: `[{"op": "merge", "path": "/Title"}]`, err: `operation 1: unknown op "merge"`},
+		{desc: "unknown field", patch: `[{"op": "add", "path": "/Tags", "value": "x"}]`, err: `operation 1: path "/Tags" isn't a metadata field`},
+		{desc: "nested path", patch: `[{"op": "remove", "path": "/Title/0"}]`, err: `operation 1: path "/Title/0" isn't a metadata field`},
+		{desc: "missing value", patch: `[{"op": "replace", "path": "/Title"}]`, err: "operation 1: value must be a string"},
+		{desc: "value not a string", patch: `[{"op": "add", "path": "/Title", "value": 5}]`, err: "operation 1: value must be a string"},
+		{desc: "missing from", patch: `[{"op": "move", "path": "/Title"}]`, err: `operation 1: path "" isn't a metadata field`},
+		{desc: "moved tag", patch: `[{"op": "move", "from": "/Tags/0", "path": "/Tags/1"}]`, err: "operation 1: tags can't be moved or copied"},
+		{desc: "tag index with leading zero", patch: `[{"op": "remove", "path": "/Tags/01"}]`, err: `operation 1: path "/Tags/01" isn't a tag`},
+		{desc: "removed tag after the last", patch: `[{"op": "remove", "path": "/Tags/-"}]`, err: `operation 1: path "/Tags/-" isn't a tag`},
+		{desc: "invalid tag", patch: `[{"op": "add", "path": "/Tags/-", "value": "a,b"}]`, err: `operation 1: invalid tag "a,b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseJSONPatch([]byte(tt.patch))
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+// Test patching the metadata of stored documents through PATCH /document
+func TestHandleJSONPatchRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<document><title>Test Title</title><author>John Doe</author></document>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	req := httptest.NewRequest("PATCH", "/document?id=1", strings.NewReader(`[{"op": "replace", "path": "/Title", "value": "New Title"}, {"op": "remove", "path": "/Author"}]`))
+	req.Header.Set("Content-Type", JSON_PATCH_CONTENT_TYPE)
+	w := httptest.NewRecorder()
+	handlePatchRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var patched XMLDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &patched))
+	require.Equal(t, "New Title", patched.Title)
+	require.Empty(t, patched.Author)
+	require.Equal(t, 2, patched.Revision)
+
+	// The changes are part of the stored XML so reprocessing keeps them
+	changed, err := reprocessDocument(db, "1")
+	require.NoError(t, err)
+	require.False(t, changed)
+	stored, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "<document><title>New Title</title></document>", stored.XMLData[0])
+
+	req = httptest.NewRequest("PATCH", "/document?id=1", strings.NewReader(`[{"op": "test", "path": "/Title", "value": "Test Title"}]`))
+	req.Header.Set("Content-Type", JSON_PATCH_CONTENT_TYPE)
+	w = httptest.NewRecorder()
+	handlePatchRequest(db, w, req)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+
+	// Tags are stored with the patch, and kept by patches of the XML
+	req = httptest.NewRequest("PATCH", "/document?id=1", strings.NewReader(`[{"op": "add", "path": "/Tags/-", "value": "urgent"}, {"op": "add", "path": "/Tags/-", "value": "draft"}]`))
+	req.Header.Set("Content-Type", JSON_PATCH_CONTENT_TYPE)
+	w = httptest.NewRecorder()
+	handlePatchRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode, w.Body.String())
+	stored, err = getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"draft", "urgent"}, stored.Tags)
+
+	req = httptest.NewRequest("PATCH", "/document?id=1", strings.NewReader(`[{"op": "remove", "path": "/Tags/0"}]`))
+	req.Header.Set("Content-Type", JSON_PATCH_CONTENT_TYPE)
+	w = httptest.NewRecorder()
+	handlePatchRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &patched))
+	require.Equal(t, []string{"urgent"}, patched.Tags)
+
+	req = httptest.NewRequest("PATCH", "/document?id=1", strings.NewReader(`<diff><replace sel="/document/title/text()">XML Title</replace></diff>`))
+	req.Header.Set("Content-Type", XML_PATCH_CONTENT_TYPE)
+	w = httptest.NewRecorder()
+	handlePatchRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode, w.Body.String())
+	stored, err = getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "XML Title", stored.Title)
+	require.Equal(t, []string{"urgent"}, stored.Tags)
+}
@@ -0,0 +1,249 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	XML_TITLE_FIELD       = "title"       // Element name of the title metadata
+	XML_DESCRIPTION_FIELD = "description" // Element name of the description metadata
+	XML_LANG_ATTRIBUTE    = "xml:lang"    // Attribute holding the language of an element
+)
+
+// LangVariant holds one language-specific value of a metadata element
+type LangVariant struct {
+	Field string // Field is the element name ("title" or "description")
+	Lang  string // Lang is the value of the xml:lang attribute
+	Value string // Value is the text content of the element
+}
+
+// parseElement splits an element string like `<tag attr="x">text</tag>` into its parts
+// The parts are found by scanning the markup, so a '>' in an attribute value or a closing tag like `</tag >`
+// doesn't shift them into each other. text is the raw content between the tags, callers decode it with elementText.
+func parseElement(str string) (name string, attrs string, text string, ok bool) {
+	if !strings.HasPrefix(str, "<") {
+		return "", "", "", false
+	}
+	end := tagEnd(str, 0, ">")
+	if end < 0 {
+		return "", "", "", false
+	}
+
+	open := str[1:end]
+	selfClosing := strings.HasSuffix(open, "/")
+	open = strings.TrimSuffix(open, "/")
+
+	name = open
+	if i := strings.IndexAny(open, XML_WHITESPACE); i >= 0 {
+		name = open[:i]
+		attrs = strings.TrimSpace(open[i+1:])
+	}
+	if name == "" {
+		return "", "", "", false
+	}
+	if selfClosing {
+		return name, attrs, "", true
+	}
+
+	// The closing tag may have whitespace before its '>'
+	closing := strings.LastIndex(str, "</")
+	if closing <= end || !strings.HasSuffix(str, ">") || strings.TrimRight(str[closing+2:len(str)-1], XML_WHITESPACE) != name {
+		return "", "", "", false
+	}
+	return name, attrs, str[end+1 : closing], true
+}
+
+// parseAttributes parses an attribute string like `id="1" xml:lang='en'` into a map
+// Parsing stops at the first malformed attribute, and the first of duplicate attributes wins
+func parseAttributes(attrs string) map[string]string {
+	result := map[string]string{}
+	for attrs != "" {
+		eq := strings.Index(attrs, "=")
+		if eq < 0 {
+			break
+		}
+		name := strings.TrimSpace(attrs[:eq])
+		rest := strings.TrimLeft(attrs[eq+1:], " \t\r\n")
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			break
+		}
+
+		quote := rest[0]
+		end := strings.IndexByte(rest[1:], quote)
+		if end < 0 {
+			break
+		}
+		if _, ok := result[name]; !ok {
+			result[name] = rest[1 : end+1]
+		}
+		attrs = strings.TrimLeft(rest[end+2:], " \t\r\n")
+	}
+	return result
+}
+
+// attributeValue returns the value of the named attribute in an attribute string
+func attributeValue(attrs string, key string) (string, bool) {
+	value, ok := parseAttributes(attrs)[key]
+	return value, ok
+}
+
+// parseLangVariant returns the language variant if str is a metadata element with xml:lang
+func parseLangVariant(str string) (LangVariant, bool) {
+	name, attrs, text, ok := parseElement(str)
+	name = localName(name)
+	if !ok || (name != XML_TITLE_FIELD && name != XML_DESCRIPTION_FIELD) {
+		return LangVariant{}, false
+	}
+
+	lang, ok := attributeValue(attrs, XML_LANG_ATTRIBUTE)
+	if !ok || lang == "" {
+		return LangVariant{}, false
+	}
+
+	return LangVariant{Field: name, Lang: lang, Value: elementText(text)}, true
+}
+
+// parseAcceptLanguage parses an Accept-Language header into language tags ordered by preference
+// Tags with q=0 are dropped since the client explicitly refuses them
+func parseAcceptLanguage(header string) []string {
+	type langPref struct {
+		Tag     string
+		Quality float64
+	}
+
+	var prefs []langPref
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		prefs = append(prefs, langPref{Tag: tag, Quality: quality})
+	}
+
+	// Keep the header order for tags with the same quality
+	sort.SliceStable(prefs, func(i, j int) bool {
+		return prefs[i].Quality > prefs[j].Quality
+	})
+
+	var result []string
+	for _, pref := range prefs {
+		result = append(result, pref.Tag)
+	}
+	return result
+}
+
+// firstLangVariant returns the first variant stored for field
+func firstLangVariant(variants []LangVariant, field string) (LangVariant, bool) {
+	for _, variant := range variants {
+		if variant.Field == field {
+			return variant, true
+		}
+	}
+	return LangVariant{}, false
+}
+
+// matchLangVariant picks the variant of field best matching the preferred languages
+// An exact tag match wins over a match on the primary subtag ("fr" for "fr-CH")
+func matchLangVariant(variants []LangVariant, field string, prefs []string) (LangVariant, bool) {
+	primary := func(tag string) string {
+		return strings.ToLower(strings.Split(tag, "-")[0])
+	}
+
+	for _, pref := range prefs {
+		if pref == "*" {
+			return firstLangVariant(variants, field)
+		}
+		for _, variant := range variants {
+			if variant.Field == field && strings.EqualFold(variant.Lang, pref) {
+				return variant, true
+			}
+		}
+		for _, variant := range variants {
+			if variant.Field == field && primary(variant.Lang) == primary(pref) {
+				return variant, true
+			}
+		}
+	}
+	return LangVariant{}, false
+}
+
+// localizeDocument replaces the title and description of doc with the variants
+// matching the Accept-Language header and returns the language of the chosen title
+// Fields without a matching variant keep their default value
+func localizeDocument(doc *XMLDoc, acceptLanguage string) string {
+	if len(doc.Variants) == 0 || acceptLanguage == "" {
+		return ""
+	}
+	prefs := parseAcceptLanguage(acceptLanguage)
+
+	lang := ""
+	if variant, ok := matchLangVariant(doc.Variants, XML_TITLE_FIELD, prefs); ok {
+		doc.Title = variant.Value
+		lang = variant.Lang
+	}
+	if variant, ok := matchLangVariant(doc.Variants, XML_DESCRIPTION_FIELD, prefs); ok {
+		doc.Description = variant.Value
+		if lang == "" {
+			lang = variant.Lang
+		}
+	}
+
+	return lang
+}
+
+// varyOn adds the request header name to the Vary header of a response, unless it is already listed
+func varyOn(w http.ResponseWriter, name string) {
+	for _, value := range w.Header().Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(listed), name) {
+				return
+			}
+		}
+	}
+	w.Header().Add("Vary", name)
+}
+
+// encodeLangVariants encodes the variants for storage in the lang_data column
+func encodeLangVariants(variants []LangVariant) (string, error) {
+	if len(variants) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(variants)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeLangVariants decodes the content of the lang_data column
+func decodeLangVariants(data string) ([]LangVariant, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var variants []LangVariant
+	err := json.Unmarshal([]byte(data), &variants)
+	if err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
@@ -0,0 +1,146 @@
+package goapp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing of Accept-Language headers
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		desc             string
+		header           string
+		expectedResponse []string
+	}{
+		{
+			desc:             "ordered by quality",
+			header:           "en;q=0.5, fr-CH, fr;q=0.9",
+			expectedResponse: []string{"fr-CH", "fr", "en"},
+		}, {
+			desc:             "refused language",
+			header:           "de;q=0, en",
+			expectedResponse: []string{"en"},
+		}, {
+			desc:             "empty header",
+			header:           "",
+			expectedResponse: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.EqualValues(t, tt.expectedResponse, parseAcceptLanguage(tt.header))
+		})
+	}
+}
+
+// Test that language variants are collected while parsing a document
+func TestParseDocumentLangVariants(t *testing.T) {
+	doc, err := parseDocument(`<document>
+		<title xml:lang="en">English Title</title>
+		<title xml:lang="fr">Titre Français</title>
+		<description>Default Description</description>
+		<description xml:lang="fr">Description Française</description>
+	</document>`)
+	require.NoError(t, err)
+
+	require.Equal(t, "English Title", doc.Title)
+	require.Equal(t, "Default Description", doc.Description)
+	require.EqualValues(t, []LangVariant{
+		{Field: "title", Lang: "en", Value: "English Title"},
+		{Field: "title", Lang: "fr", Value: "Titre Français"},
+		{Field: "description", Lang: "fr", Value: "Description Française"},
+	}, doc.Variants)
+}
+
+// Test picking the metadata variant matching the Accept-Language header
+func TestLocalizeDocument(t *testing.T) {
+	variants := []LangVariant{
+		{Field: "title", Lang: "en", Value: "English Title"},
+		{Field: "title", Lang: "fr-CA", Value: "Titre Canadien"},
+		{Field: "description", Lang: "en", Value: "English Description"},
+	}
+	tests := []struct {
+		desc                string
+		header              string
+		expectedTitle       string
+		expectedDescription string
+		expectedLang        string
+	}{
+		{
+			desc:                "exact match",
+			header:              "fr-CA",
+			expectedTitle:       "Titre Canadien",
+			expectedDescription: "Default Description",
+			expectedLang:        "fr-CA",
+		}, {
+			desc:                "primary subtag match",
+			header:              "fr-FR, en;q=0.5",
+			expectedTitle:       "Titre Canadien",
+			expectedDescription: "English Description",
+			expectedLang:        "fr-CA",
+		}, {
+			desc:                "no match keeps defaults",
+			header:              "de",
+			expectedTitle:       "Default Title",
+			expectedDescription: "Default Description",
+			expectedLang:        "",
+		}, {
+			desc:                "wildcard",
+			header:              "de, *;q=0.1",
+			expectedTitle:       "English Title",
+			expectedDescription: "English Description",
+			expectedLang:        "en",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			doc := XMLDoc{Title: "Default Title", Description: "Default Description", Variants: variants}
+			lang := localizeDocument(&doc, tt.header)
+			require.Equal(t, tt.expectedTitle, doc.Title)
+			require.Equal(t, tt.expectedDescription, doc.Description)
+			require.Equal(t, tt.expectedLang, lang)
+		})
+	}
+}
+
+// Test handling /document requests with an Accept-Language header
+func TestHandleDocumentRequestAcceptLanguage(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument(`<document>
+		<title>Default Title</title>
+		<title xml:lang="fr">Titre Français</title>
+	</document>`)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	req := httptest.NewRequest("GET", "/document?id=1", nil)
+	req.Header.Set("Accept-Language", "fr-FR,fr;q=0.9")
+	w := httptest.NewRecorder()
+
+	handleRequest(db, w, req)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "fr", resp.Header.Get("Content-Language"))
+	require.Equal(t, []string{responseVary}, resp.Header.Values("Vary"))
+
+	// The handler varies on the language by itself, e.g. behind other middlewares
+	w = httptest.NewRecorder()
+	handleDocumentRequest(db, w, req)
+	require.Equal(t, "Accept-Language", w.Result().Header.Get("Vary"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var retrievedDoc XMLDoc
+	require.NoError(t, json.Unmarshal(body, &retrievedDoc))
+	require.Equal(t, "Titre Français", retrievedDoc.Title)
+	require.Len(t, retrievedDoc.Variants, 1)
+}
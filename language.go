@@ -0,0 +1,134 @@
+package goapp
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	DB_LANGUAGE_FIELD_NAME = "language" // Field name for the language of the document in SQLite table
+
+	DETECT_LANGUAGE_ENV = "DOC_DETECT_LANGUAGE" // Environment variable detecting the language of documents without xml:lang from their text when true
+
+	LANGUAGE_PARAM = "lang" // Query parameter of /list and /export selecting the documents of a language
+
+	LANGUAGE_DETECT_MIN_WORDS = 5 // Minimum number of stop words of the detected language in the text of a document
+)
+
+// detectLanguages is true when the language of documents without xml:lang is detected from their text
+var detectLanguages bool
+
+// languageStopWords are frequent words of each detectable language, which are rare in the others
+var languageStopWords = map[string][]string{
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "sich", "auf", "für", "ein", "eine", "dem", "den", "von", "wird", "auch", "werden"},
+	"en": {"the", "and", "is", "of", "to", "in", "that", "it", "with", "for", "was", "are", "this", "be", "have", "from", "which", "by"},
+	"es": {"el", "los", "las", "del", "que", "y", "es", "por", "para", "con", "una", "se", "su", "como", "pero", "más", "está", "sus"},
+	"fr": {"le", "les", "des", "et", "est", "une", "du", "que", "qui", "dans", "pour", "pas", "sur", "avec", "au", "sont", "ce", "aux"},
+	"it": {"il", "gli", "della", "che", "è", "per", "non", "una", "sono", "con", "del", "nel", "alla", "anche", "come", "dei", "questo", "più"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "met", "voor", "zijn", "op", "ook", "aan", "wordt", "bij", "naar", "deze"},
+	"pt": {"o", "os", "as", "do", "da", "dos", "das", "que", "não", "um", "uma", "para", "com", "em", "por", "mais", "são", "ao"},
+}
+
+// languageOfStopWord maps each stop word to the languages it is frequent in
+var languageOfStopWord = func() map[string][]string {
+	result := map[string][]string{}
+	for lang, words := range languageStopWords {
+		for _, word := range words {
+			result[word] = append(result[word], lang)
+		}
+	}
+	return result
+}()
+
+// initLanguageDetection turns the detection of languages on if DETECT_LANGUAGE_ENV asks for it
+func initLanguageDetection() {
+	funcName := "initLanguageDetection"
+
+	value := os.Getenv(DETECT_LANGUAGE_ENV)
+	if value == "" {
+		return
+	}
+	detect, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s: %s must be true or false", funcName, DETECT_LANGUAGE_ENV)
+	}
+	detectLanguages = detect
+}
+
+// documentLanguage returns the language of a document with the element tree root and the text
+// The xml:lang of the root element, which its elements inherit, is the language of the document. The xml:lang of
+// other elements, like the language variants of the title, don't tell the language of the rest. Documents without
+// one have the language detected from text, if detection is on.
+func documentLanguage(root *Node, text string) string {
+	if root == nil {
+		return ""
+	}
+	if lang := root.Attrs[XML_LANG_ATTRIBUTE]; lang != "" {
+		return lang
+	}
+	if detectLanguages {
+		return detectLanguage(text)
+	}
+	return ""
+}
+
+// detectLanguage returns the ISO 639-1 code of the language text is most likely written in, empty if it isn't clear
+// Words are counted towards the languages they are a stop word of. The language with the most counts wins if it has
+// at least LANGUAGE_DETECT_MIN_WORDS and is ahead of the others by half.
+func detectLanguage(text string) string {
+	counts := map[string]int{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		for _, lang := range languageOfStopWord[word] {
+			counts[lang]++
+		}
+	}
+
+	best := ""
+	for lang, count := range counts {
+		if best == "" || count > counts[best] || (count == counts[best] && lang < best) {
+			best = lang
+		}
+	}
+	runnerUp := 0
+	for lang, count := range counts {
+		if lang != best && count > runnerUp {
+			runnerUp = count
+		}
+	}
+	if best == "" || counts[best] < LANGUAGE_DETECT_MIN_WORDS || 2*counts[best] < 3*runnerUp {
+		return ""
+	}
+	return best
+}
+
+// isValidLanguageTag reports whether tag is a language tag like "en" or "pt-BR"
+func isValidLanguageTag(tag string) bool {
+	for _, part := range strings.Split(tag, "-") {
+		if part == "" || len(part) > 8 {
+			return false
+		}
+		for _, r := range part {
+			if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// languageFilter returns the WHERE condition selecting the documents in the language of tag with its arguments
+// A tag matches the languages it is a prefix of, ignoring case, so "en" matches "en" and "en-GB" but not "eng".
+func languageFilter(tag string) (string, []interface{}, error) {
+	if !isValidLanguageTag(tag) {
+		return "", nil, fmt.Errorf("Invalid %s %s", LANGUAGE_PARAM, tag)
+	}
+	condition := fmt.Sprintf("(%s=? COLLATE NOCASE OR %s LIKE ?)", DB_LANGUAGE_FIELD_NAME, DB_LANGUAGE_FIELD_NAME)
+	return condition, []interface{}{tag, tag + "-%"}, nil
+}
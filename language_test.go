@@ -0,0 +1,87 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the language of a document is the xml:lang of its root element, or the detected one
+func TestDocumentLanguage(t *testing.T) {
+	english := "The report is a summary of the sales of the year, and it was written for the board."
+	for _, tt := range []struct {
+		desc   string
+		data   string
+		detect bool
+		lang   string
+	}{
+		{desc: "root xml:lang", data: `<doc xml:lang="pt-BR"><title>Relatório</title></doc>`, lang: "pt-BR"},
+		{desc: "variants only", data: `<doc><title>Report</title><title xml:lang="fr">Rapport</title></doc>`},
+		{desc: "not detected", data: "<doc><p>" + english + "</p></doc>"},
+		{desc: "detected", data: "<doc><p>" + english + "</p></doc>", detect: true, lang: "en"},
+		{desc: "root wins over detection", data: `<doc xml:lang="de"><p>` + english + `</p></doc>`, detect: true, lang: "de"},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			detectLanguages = tt.detect
+			defer func() { detectLanguages = false }()
+
+			doc, err := parseDocument(tt.data)
+			require.NoError(t, err)
+			require.Equal(t, tt.lang, doc.Language)
+		})
+	}
+}
+
+// Test detecting the language of texts
+func TestDetectLanguage(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		lang string
+	}{
+		{text: "Der Bericht ist eine Zusammenfassung der Verkäufe, die auch für den Vorstand geschrieben wurde und nicht öffentlich ist.", lang: "de"},
+		{text: "Le rapport est une synthèse des ventes de l'année, qui sont en hausse dans les pays du sud pour la plupart.", lang: "fr"},
+		{text: "El informe es un resumen de las ventas del año, que se presentan para el consejo con los datos de sus filiales.", lang: "es"},
+		{text: "Het rapport is een samenvatting van de verkopen, die voor het bestuur is geschreven en niet openbaar is.", lang: "nl"},
+		{text: "The sales of the year", lang: ""},
+		{text: "", lang: ""},
+	} {
+		require.Equal(t, tt.lang, detectLanguage(tt.text), tt.text)
+	}
+}
+
+// Test listing the documents of a language with their facet
+func TestListLanguage(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		`<doc xml:lang="en"><title>One</title></doc>`,
+		`<doc xml:lang="en-GB"><title>Two</title></doc>`,
+		`<doc xml:lang="eng"><title>Three</title></doc>`,
+		`<doc xml:lang="de"><title>Vier</title></doc>`,
+		`<doc><title>Five</title></doc>`,
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/list?lang=EN&facets=language", nil))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response ListResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Len(t, response.Documents, 2)
+	require.Equal(t, "en", response.Documents[0].Language)
+	require.Equal(t, "en-GB", response.Documents[1].Language)
+	require.Equal(t, []FacetCount{{Value: "en", Count: 1}, {Value: "en-GB", Count: 1}}, response.Facets[FACET_LANGUAGE])
+
+	for _, lang := range []string{"en_GB", "en-", "en%25"} {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("GET", "/list?lang="+lang, nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code, lang)
+	}
+}
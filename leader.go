@@ -0,0 +1,125 @@
+package goapp
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	INSTANCE_ID_ENV = "DOC_INSTANCE_ID" // Environment variable with the name of this instance in leader leases
+
+	JOB_ARCHIVER    = "archiver"    // Name of the lease of the expiry archiver
+	JOB_SNAPSHOTTER = "snapshotter" // Name of the lease of the S3 snapshotter
+	JOB_ALERTER     = "alerter"     // Name of the lease of the alert rule evaluation
+	JOB_REPORTER    = "reporter"    // Name of the lease of the scheduled ingestion reports
+	JOB_TIERER      = "tierer"      // Name of the lease of the move of idle documents to cold storage
+
+	DB_LEASE_TABLE_NAME         = "leader_lease" // Table name of the leader leases in SQLite
+	DB_LEASE_NAME_FIELD_NAME    = "name"         // Field name for the name of the job
+	DB_LEASE_HOLDER_FIELD_NAME  = "holder"       // Field name for the instance holding the lease
+	DB_LEASE_EXPIRES_FIELD_NAME = "expires_at"   // Field name for the time the lease runs out
+)
+
+// LeaderElector decides which of the instances sharing a database runs each background job
+// An instance leads a job while it holds the job's lease, which it renews every time the job runs.
+// When the leader stops, its lease runs out and the next instance to run the job takes over.
+type LeaderElector struct {
+	Holder string // Holder identifies this instance in leases
+
+	mu      sync.Mutex
+	leading map[string]bool
+}
+
+// leaderElector is the elector of this instance, set by initLeaderElection
+var leaderElector = newLeaderElector("local")
+
+func newLeaderElector(holder string) *LeaderElector {
+	return &LeaderElector{Holder: holder, leading: map[string]bool{}}
+}
+
+// initLeaderElection names this instance from the environment, or from its host name and a random suffix
+func initLeaderElection() {
+	holder := os.Getenv(INSTANCE_ID_ENV)
+	if holder == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "instance"
+		}
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		holder = hostname + "-" + hex.EncodeToString(suffix)
+	}
+	leaderElector = newLeaderElector(holder)
+}
+
+// createLeaseTable creates the leader lease table if not exists
+func createLeaseTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT PRIMARY KEY,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL
+	);
+`, DB_LEASE_TABLE_NAME, DB_LEASE_NAME_FIELD_NAME, DB_LEASE_HOLDER_FIELD_NAME, DB_LEASE_EXPIRES_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// acquireLease takes or renews the lease of a job for holder until now+ttl
+// It reports whether holder got the lease, which fails while another holder's lease hasn't run out
+func acquireLease(db *sql.DB, name string, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	defer observeQuery("acquireLease", time.Now())
+
+	// The upsert only replaces a lease held by the same holder or which ran out, in a single statement
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s) VALUES (?, ?, ?)
+		ON CONFLICT(%s) DO UPDATE SET %s=excluded.%s, %s=excluded.%s
+		WHERE %s.%s=excluded.%s OR %s.%s<=?
+	`, DB_LEASE_TABLE_NAME, DB_LEASE_NAME_FIELD_NAME, DB_LEASE_HOLDER_FIELD_NAME, DB_LEASE_EXPIRES_FIELD_NAME,
+		DB_LEASE_NAME_FIELD_NAME, DB_LEASE_HOLDER_FIELD_NAME, DB_LEASE_HOLDER_FIELD_NAME, DB_LEASE_EXPIRES_FIELD_NAME, DB_LEASE_EXPIRES_FIELD_NAME,
+		DB_LEASE_TABLE_NAME, DB_LEASE_HOLDER_FIELD_NAME, DB_LEASE_HOLDER_FIELD_NAME, DB_LEASE_TABLE_NAME, DB_LEASE_EXPIRES_FIELD_NAME)
+	var count int64
+	err := withDBRetry(func() error {
+		result, err := db.Exec(query, name, holder, formatExpiry(now.Add(ttl)), formatExpiry(now))
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Lead reports whether this instance should run the job now, holding its lease for ttl
+// The ttl must be longer than the time between two runs so the leader keeps the lease
+func (elector *LeaderElector) Lead(db *sql.DB, job string, now time.Time, ttl time.Duration) bool {
+	funcName := "LeaderElector.Lead"
+
+	leading, err := acquireLease(db, job, elector.Holder, now, ttl)
+	if err != nil {
+		log.Printf("%s: Failed to acquire lease of %s: %v", funcName, job, err)
+		leading = false
+	}
+
+	elector.mu.Lock()
+	defer elector.mu.Unlock()
+	if leading != elector.leading[job] {
+		if leading {
+			log.Printf("%s: %s now leads %s", funcName, elector.Holder, job)
+		} else {
+			log.Printf("%s: %s no longer leads %s", funcName, elector.Holder, job)
+		}
+		elector.leading[job] = leading
+	}
+	return leading
+}
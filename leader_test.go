@@ -0,0 +1,61 @@
+package goapp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that a lease is held by a single instance until it runs out
+func TestAcquireLease(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	start := time.Date(2024, 7, 9, 12, 0, 0, 0, time.UTC)
+	ttl := time.Minute
+
+	tests := []struct {
+		desc     string
+		job      string
+		holder   string
+		at       time.Duration
+		expected bool
+	}{
+		{desc: "first instance acquires", holder: "a", at: 0, expected: true},
+		{desc: "second instance waits", holder: "b", at: 30 * time.Second, expected: false},
+		{desc: "leader renews", holder: "a", at: 45 * time.Second, expected: true},
+		{desc: "renewed lease still held", holder: "b", at: 90 * time.Second, expected: false},
+		{desc: "second instance takes over", holder: "b", at: 105 * time.Second, expected: true},
+		{desc: "former leader waits", holder: "a", at: 120 * time.Second, expected: false},
+		{desc: "other jobs are independent", job: JOB_SNAPSHOTTER, holder: "a", at: 120 * time.Second, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			job := tt.job
+			if job == "" {
+				job = JOB_ARCHIVER
+			}
+			acquired, err := acquireLease(db, job, tt.holder, start.Add(tt.at), ttl)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, acquired)
+		})
+	}
+}
+
+// Test that exactly one of two electors leads a job
+func TestLeaderElectorLead(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	first := newLeaderElector("first")
+	second := newLeaderElector("second")
+	now := time.Now()
+
+	require.True(t, first.Lead(db, JOB_ARCHIVER, now, time.Minute))
+	require.False(t, second.Lead(db, JOB_ARCHIVER, now, time.Minute))
+	require.True(t, first.Lead(db, JOB_ARCHIVER, now.Add(30*time.Second), time.Minute))
+	// The first instance stopped renewing
+	require.True(t, second.Lead(db, JOB_ARCHIVER, now.Add(2*time.Minute), time.Minute))
+	require.False(t, first.Lead(db, JOB_ARCHIVER, now.Add(2*time.Minute), time.Minute))
+}
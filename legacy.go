@@ -0,0 +1,135 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LEGACY_API_ENV = "DOC_LEGACY_API" // Environment variable serving /document, /add and /del like the first release when true
+
+	LEGACY_TITLE_PREFIX       = "<title>"        // Start tag the first release read the title from
+	LEGACY_DESCRIPTION_PREFIX = "<description>"  // Start tag the first release read the description from
+	LEGACY_AUTHOR_PREFIX      = "<author>"       // Start tag the first release read the author from
+	LEGACY_CREATEDAT_PREFIX   = "<creationDate>" // Start tag the first release read the creation date from
+)
+
+// legacyAPI is true when /document, /add and /del keep the semantics and JSON of the first release
+var legacyAPI bool
+
+// LegacyDocument is a document in the JSON shape of the first release of /document
+type LegacyDocument struct {
+	ID          string
+	Title       string
+	Description string
+	Author      string
+	CreatedAt   string
+	XMLData     []string
+}
+
+// initLegacyAPI turns the legacy API on if the configuration or LEGACY_API_ENV asks for it
+func initLegacyAPI(config Config) {
+	funcName := "initLegacyAPI"
+
+	legacyAPI = config.LegacyAPI
+	value := os.Getenv(LEGACY_API_ENV)
+	if legacyAPI || value == "" {
+		return
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s: %s must be true or false", funcName, LEGACY_API_ENV)
+	}
+	legacyAPI = enabled
+}
+
+// Legacy returns the document in the JSON shape of the first release
+// The metadata is read from the element strings the way the first release did, the text of the first element
+// starting with exactly the start tag, so it is the same as that release returned for the same XML whatever the
+// field mappings, entities or dates of the document.
+func (doc *XMLDoc) Legacy() LegacyDocument {
+	legacy := LegacyDocument{ID: doc.ID, XMLData: doc.XMLData}
+	for _, str := range doc.XMLData {
+		legacySetField(&legacy.Title, str, LEGACY_TITLE_PREFIX)
+		legacySetField(&legacy.Description, str, LEGACY_DESCRIPTION_PREFIX)
+		legacySetField(&legacy.Author, str, LEGACY_AUTHOR_PREFIX)
+		legacySetField(&legacy.CreatedAt, str, LEGACY_CREATEDAT_PREFIX)
+	}
+	return legacy
+}
+
+// legacySetField sets an empty field to the content of str if it is an element starting with prefix
+func legacySetField(field *string, str string, prefix string) {
+	if *field != "" || !strings.HasPrefix(str, prefix) || len(str) < 2*len(prefix)+1 {
+		return
+	}
+	*field = str[len(prefix) : len(str)-len(prefix)-1]
+}
+
+// handleLegacyDocumentRequest serves /document like the first release
+// Documents are looked up by the id parameter as given and failed lookups, including unknown IDs, are 500 errors.
+func handleLegacyDocumentRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), http.StatusInternalServerError)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+	doc.ID = id
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(doc.Legacy())
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// legacyAddWriter drops the body of successful /add responses, which the first release didn't send
+type legacyAddWriter struct {
+	http.ResponseWriter
+	created bool // created is true once the status was 201 Created
+}
+
+func (writer *legacyAddWriter) WriteHeader(status int) {
+	if status == http.StatusCreated {
+		writer.created = true
+		writer.Header().Del("Content-Type")
+	}
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *legacyAddWriter) Write(data []byte) (int, error) {
+	if writer.created {
+		return len(data), nil
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// handleLegacyAddRequest serves /add like the first release, which took no parameters and answered 201 Created
+// without a body
+// Documents still go through the ingestion of /add, with its defaults, quotas and duplicate checks.
+func handleLegacyAddRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	r.URL.RawQuery = ""
+	handleAddRequest(db, &legacyAddWriter{ResponseWriter: w}, r)
+}
@@ -0,0 +1,51 @@
+package goapp
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the legacy API answers byte for byte like the first release, whose responses are kept here
+func TestLegacyAPI(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	legacyAPI = true
+	defer func() { legacyAPI = false }()
+
+	data := "<document>\n\t<title>Quarterly report</title>\n\t<description>Figures of the third quarter</description>\n\t<author>Ann Lee</author>\n\t<creationDate>2024-01-02T10:00:00+02:00</creationDate>\n\t<section>\n\t\t<heading>Sales</heading>\n\t</section>\n</document>"
+	xmlData := `"XMLData":["\u003cdocument\u003e\u003ctitle\u003eQuarterly report\u003c/title\u003e\u003cdescription\u003eFigures of the third quarter\u003c/description\u003e\u003cauthor\u003eAnn Lee\u003c/author\u003e\u003ccreationDate\u003e2024-01-02T10:00:00+02:00\u003c/creationDate\u003e\u003csection\u003e\u003cheading\u003eSales\u003c/heading\u003e\u003c/section\u003e\u003c/document\u003e","\u003ctitle\u003eQuarterly report\u003c/title\u003e","\u003cdescription\u003eFigures of the third quarter\u003c/description\u003e","\u003cauthor\u003eAnn Lee\u003c/author\u003e","\u003ccreationDate\u003e2024-01-02T10:00:00+02:00\u003c/creationDate\u003e","\u003csection\u003e\u003cheading\u003eSales\u003c/heading\u003e\u003c/section\u003e","\u003cheading\u003eSales\u003c/heading\u003e"]`
+	metadata := `"Title":"Quarterly report","Description":"Figures of the third quarter","Author":"Ann Lee","CreatedAt":"2024-01-02T10:00:00+02:00",`
+
+	for _, tt := range []struct {
+		method      string
+		target      string
+		body        string
+		status      int
+		contentType string
+		response    string
+	}{
+		{method: "POST", target: "/add?lenient=true", body: data, status: 201},
+		{method: "GET", target: "/document?id=1", status: 200, contentType: "application/json", response: `{"ID":"1",` + metadata + xmlData + `}`},
+		{method: "GET", target: "/document?id=01", status: 200, contentType: "application/json", response: `{"ID":"01",` + metadata + xmlData + `}`},
+		{method: "GET", target: "/document", status: 400, contentType: "text/plain; charset=utf-8", response: "ID parameter is required\n"},
+		{method: "GET", target: "/document?id=9", status: 500, contentType: "text/plain; charset=utf-8", response: "Failed to fetch document with ID 9: sql: no rows in result set\n"},
+		{method: "GET", target: "/del?id=9", status: 200},
+		{method: "GET", target: "/del?id=1", status: 200},
+	} {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+		require.Equal(t, tt.status, rr.Code, tt.target)
+		require.Equal(t, tt.contentType, rr.Header().Get("Content-Type"), tt.target)
+		require.Equal(t, tt.response, rr.Body.String(), tt.target)
+	}
+
+	// The current API is served again once the flag is off
+	legacyAPI = false
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/document?id=9", nil))
+	require.Equal(t, 404, rr.Code)
+}
@@ -0,0 +1,100 @@
+package goapp
+
+import (
+	"strings"
+)
+
+// ParseOptions changes how documents are parsed
+type ParseOptions struct {
+	Lenient    bool     // Lenient repairs dangling and unmatched tags instead of failing
+	HTML       bool     // HTML turns HTML like unclosed <br> tags into XML first, and repairs leniently
+	Whitespace string   // Whitespace is the WHITESPACE_* mode, empty for the default
+	Comments   bool     // Comments keeps the comments inside the root element in the Tree, they are left out of it otherwise
+	Fragment   bool     // Fragment wraps content without a single root element in FRAGMENT_ROOT
+	MaxDepth   int      // MaxDepth rejects documents nested deeper, 0 for the limit of the server
+	Namespaces []string // Namespaces are the URIs of the namespaces metadata fields are read from, any namespace if empty
+	Order      string   // Order is the XMLDATA_ORDER_* of XMLData, breadth-first if empty
+
+	Fields  map[string]string  // Fields maps metadata fields like "title" to the element name or path holding them, for tenants with their own vocabulary
+	Schemas []ValidationSchema // Schemas are tried before the server's schemas when validating the document
+}
+
+// storedParseOptions parse the XML of stored documents again, whose whitespace was handled when they were added
+var storedParseOptions = ParseOptions{Whitespace: WHITESPACE_PRESERVE}
+
+// storedOptions returns the options the XML of a stored document is parsed again with
+// Comments are kept if the tree of the document has any, since it was added with them.
+func (doc *XMLDoc) storedOptions() ParseOptions {
+	options := storedParseOptions
+	options.Comments = doc.Tree != nil && doc.Tree.hasComments()
+	return options
+}
+
+// AddResponse is the response of /add in lenient and HTML mode
+type AddResponse struct {
+	ID       string
+	Warnings []ParseError // Warnings are the repairs made to the document, empty if it was well-formed
+}
+
+// repairXML closes dangling tags and drops closing tags without an opening tag
+// It returns the repaired data and a warning at the position of each repair in data.
+// A dangling tag is closed before the closing tag of an enclosing element, or at the end of data.
+func repairXML(data string) (string, []ParseError, error) {
+	tags, err := scanXMLTags(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var repaired strings.Builder
+	var warnings []ParseError
+	var stack []XMLTag // Stack of the open tags
+	copied := 0        // Index of the end of data copied to repaired
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag.Tag, "</") {
+			// Self-closing tags, comments, declarations and processing instructions don't need closing
+			if !strings.HasSuffix(tag.Tag, "/>") && !strings.HasPrefix(tag.Tag, "<!") && !strings.HasPrefix(tag.Tag, "<?") {
+				stack = append(stack, tag)
+			}
+			continue
+		}
+
+		name := tagName(tag.Tag)
+		open := len(stack) - 1
+		for open >= 0 && tagName(stack[open].Tag) != name {
+			open--
+		}
+		repaired.WriteString(data[copied:tag.Index])
+		if open < 0 {
+			warnings = append(warnings, *newParseError(data, tag.Index, "skipped unmatched closing tag "+tag.Tag))
+			copied = tag.Index + len(tag.Tag)
+			continue
+		}
+		for len(stack)-1 > open {
+			dangling := stack[len(stack)-1]
+			warnings = append(warnings, *newParseError(data, dangling.Index, "auto-closed tag "+dangling.Tag))
+			repaired.WriteString("</" + tagName(dangling.Tag) + ">")
+			stack = stack[:len(stack)-1]
+		}
+		stack = stack[:open]
+		copied = tag.Index
+	}
+	repaired.WriteString(data[copied:])
+
+	for len(stack) > 0 {
+		dangling := stack[len(stack)-1]
+		warnings = append(warnings, *newParseError(data, dangling.Index, "auto-closed tag "+dangling.Tag))
+		repaired.WriteString("</" + tagName(dangling.Tag) + ">")
+		stack = stack[:len(stack)-1]
+	}
+	return repaired.String(), warnings, nil
+}
+
+// tagName returns the element name of a tag like "<section id="1">" or "</section>"
+func tagName(tag string) string {
+	tag = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(tag, "<"), "/"), ">")
+	fields := strings.Fields(strings.TrimSuffix(tag, "/"))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,119 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test repairing dangling and unmatched tags
+func TestRepairXML(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+		warnings []string
+	}{
+		{
+			desc:     "well-formed",
+			data:     `<?xml version="1.0"?><document><br/><!-- c --><title>T</title></document>`,
+			expected: `<?xml version="1.0"?><document><br/><!-- c --><title>T</title></document>`,
+		},
+		{
+			desc:     "dangling tag",
+			data:     "<document><title>T</document>",
+			expected: "<document><title>T</title></document>",
+			warnings: []string{"auto-closed tag <title> at line 1, column 11"},
+		},
+		{
+			desc:     "dangling root",
+			data:     "<document>\n<title>T</title>",
+			expected: "<document>\n<title>T</title></document>",
+			warnings: []string{"auto-closed tag <document> at line 1, column 1"},
+		},
+		{
+			desc:     "unmatched closing tag",
+			data:     "<document><title>T</title></author></document>",
+			expected: "<document><title>T</title></document>",
+			warnings: []string{"skipped unmatched closing tag </author> at line 1, column 27"},
+		},
+		{
+			desc:     "nested dangling tags",
+			data:     `<document><section id="1"><item>a</document>`,
+			expected: `<document><section id="1"><item>a</item></section></document>`,
+			warnings: []string{"auto-closed tag <item> at line 1, column 27", `auto-closed tag <section id="1"> at line 1, column 11`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			repaired, warnings, err := repairXML(tt.data)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, repaired)
+
+			var messages []string
+			for _, warning := range warnings {
+				messages = append(messages, warning.Error())
+			}
+			require.Equal(t, tt.warnings, messages)
+		})
+	}
+
+	_, _, err := repairXML("<document><![CDATA[a</document>")
+	require.EqualError(t, err, "unterminated CDATA section, comment or processing instruction at line 1, column 11")
+}
+
+// Test parsing broken documents in lenient mode
+func TestParseDocumentLenient(t *testing.T) {
+	data := "<document><title>Test Title</title><author>John Doe</document>"
+
+	_, err := parseDocument(data)
+	require.Error(t, err)
+
+	doc, err := parseDocumentWithOptions(data, ParseOptions{Lenient: true})
+	require.NoError(t, err)
+	require.Equal(t, "Test Title", doc.Title)
+	require.Equal(t, "John Doe", doc.Author)
+	require.Equal(t, "<document><title>Test Title</title><author>John Doe</author></document>", doc.XMLData[0])
+	require.Len(t, doc.Warnings, 1)
+	require.Equal(t, "auto-closed tag <author>", doc.Warnings[0].Msg)
+
+	// Well-formed documents parse the same in both modes
+	doc, err = parseDocumentWithOptions("<document><title>Test Title</title></document>", ParseOptions{Lenient: true})
+	require.NoError(t, err)
+	require.Empty(t, doc.Warnings)
+}
+
+// Test adding broken documents with /add?lenient=true
+func TestHandleAddRequestLenient(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	data := "<document><title>Test Title</title></author></document>"
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(data))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	req = httptest.NewRequest("POST", "/add?lenient=true", strings.NewReader(data))
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	var response AddResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, "1", response.ID)
+	require.Equal(t, []ParseError{{Line: 1, Col: 36, Msg: "skipped unmatched closing tag </author>", Snippet: data}}, response.Warnings)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Test Title", doc.Title)
+
+	req = httptest.NewRequest("POST", "/add?lenient=maybe", strings.NewReader(data))
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
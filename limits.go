@@ -0,0 +1,141 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_DEPTH_ENV             = "DOC_MAX_DEPTH"             // Environment variable with the deepest nesting of elements accepted
+	MAX_TOKENS_ENV            = "DOC_MAX_TOKENS"            // Environment variable with the number of tags and text runs accepted
+	MAX_INPUT_BYTES_ENV       = "DOC_MAX_INPUT_BYTES"       // Environment variable with the largest document accepted in bytes
+	MAX_ENTITY_EXPANSIONS_ENV = "DOC_MAX_ENTITY_EXPANSIONS" // Environment variable with the number of entity references expanded per document
+
+	DEFAULT_MAX_DEPTH             = 256      // Deepest nesting of elements accepted by default
+	DEFAULT_MAX_TOKENS            = 1000000  // Number of tags and text runs accepted by default
+	DEFAULT_MAX_INPUT_BYTES       = 10 << 20 // Largest document accepted by default
+	DEFAULT_MAX_ENTITY_EXPANSIONS = 100000   // Number of entity references expanded per document by default
+)
+
+// ErrParseLimit is returned for documents over one of the parser limits, like deeply nested elements
+var ErrParseLimit = errors.New("parser limit exceeded")
+
+// ParseLimits bound the resources a single document may take to parse, so payloads like thousands of nested
+// elements or entities expanding to gigabytes are rejected before they are parsed
+// A limit of 0 means unlimited.
+type ParseLimits struct {
+	MaxDepth            int // MaxDepth is the deepest nesting of elements
+	MaxTokens           int // MaxTokens is the number of tags, text runs, comments and other markup
+	MaxInputBytes       int // MaxInputBytes is the size of the document in bytes
+	MaxEntityExpansions int // MaxEntityExpansions is the number of entity and character references, counted again each time an entity is expanded
+}
+
+// parseLimits are the limits applied to ingested documents, set by initParseLimits
+var parseLimits = ParseLimits{
+	MaxDepth:            DEFAULT_MAX_DEPTH,
+	MaxTokens:           DEFAULT_MAX_TOKENS,
+	MaxInputBytes:       DEFAULT_MAX_INPUT_BYTES,
+	MaxEntityExpansions: DEFAULT_MAX_ENTITY_EXPANSIONS,
+}
+
+// initParseLimits loads the parser limits from the environment
+func initParseLimits() {
+	funcName := "initParseLimits"
+
+	for env, limit := range map[string]*int{
+		MAX_DEPTH_ENV:             &parseLimits.MaxDepth,
+		MAX_TOKENS_ENV:            &parseLimits.MaxTokens,
+		MAX_INPUT_BYTES_ENV:       &parseLimits.MaxInputBytes,
+		MAX_ENTITY_EXPANSIONS_ENV: &parseLimits.MaxEntityExpansions,
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			log.Fatalf("%s: %s must be a positive number, 0 for unlimited", funcName, env)
+		}
+		*limit = number
+	}
+}
+
+// Check returns an error wrapping ErrParseLimit with the position of the first limit data goes over
+// It only scans the markup, errors like unclosed tags are left to the parser.
+func (limits ParseLimits) Check(data string) error {
+	if limits.MaxInputBytes > 0 && len(data) > limits.MaxInputBytes {
+		return fmt.Errorf("%w: document larger than %d bytes", ErrParseLimit, limits.MaxInputBytes)
+	}
+
+	depth, tokens, expansions := 0, 0, 0
+	exceeded := func(offset int, msg string) error {
+		return fmt.Errorf("%w: %v", ErrParseLimit, newParseError(data, offset, msg))
+	}
+	// count counts a token at offset and the references in its text
+	count := func(offset int, text string) error {
+		tokens++
+		if limits.MaxTokens > 0 && tokens > limits.MaxTokens {
+			return exceeded(offset, fmt.Sprintf("more than %d tags and text runs", limits.MaxTokens))
+		}
+		expansions += strings.Count(text, "&")
+		if limits.MaxEntityExpansions > 0 && expansions > limits.MaxEntityExpansions {
+			return exceeded(offset, fmt.Sprintf("more than %d entity references", limits.MaxEntityExpansions))
+		}
+		return nil
+	}
+
+	for i := 0; i < len(data); {
+		if data[i] != '<' {
+			end := strings.IndexByte(data[i:], '<')
+			if end < 0 {
+				end = len(data) - i
+			}
+			if text := data[i : i+end]; strings.TrimSpace(text) != "" {
+				if err := count(i, text); err != nil {
+					return err
+				}
+			}
+			i += end
+			continue
+		}
+
+		end := sectionEnd(data, i)
+		if end < 0 {
+			return nil
+		} else if end > 0 {
+			// CDATA sections and comments hold no references, the entity declarations of a DOCTYPE do
+			text := ""
+			if strings.HasPrefix(data[i:], DOCTYPE_START) {
+				text = data[i:end]
+			}
+			if err := count(i, text); err != nil {
+				return err
+			}
+			i = end
+			continue
+		}
+
+		end = tagEnd(data, i, ">")
+		if end < 0 {
+			return nil
+		}
+		tag := data[i : end+1]
+		if err := count(i, tag); err != nil {
+			return err
+		}
+		if strings.HasPrefix(tag, "</") {
+			depth--
+		} else if !strings.HasSuffix(tag, "/>") {
+			depth++
+			if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+				return exceeded(i, fmt.Sprintf("elements nested deeper than %d", limits.MaxDepth))
+			}
+		}
+		i = end + 1
+	}
+	return nil
+}
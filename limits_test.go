@@ -0,0 +1,75 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test rejecting documents over the parser limits
+func TestParseLimitsCheck(t *testing.T) {
+	limits := ParseLimits{MaxDepth: 3, MaxTokens: 10, MaxInputBytes: 200, MaxEntityExpansions: 4}
+	laughs := `<!DOCTYPE lolz [<!ENTITY lol "lol"><!ENTITY lol2 "&lol;&lol;&lol;"><!ENTITY lol3 "&lol2;&lol2;&lol2;">]><lolz>&lol3;</lolz>`
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{desc: "within limits", data: "<a><b><c/>x &amp; y</b><!-- <d><e><f> --></a>"},
+		{desc: "too deep", data: "<a><b><c><d>x</d></c></b></a>", expected: "elements nested deeper than 3 at line 1, column 10"},
+		{desc: "too many tokens", data: "<a>" + strings.Repeat("<b/>", 10) + "</a>", expected: "more than 10 tags and text runs at line 1, column 40"},
+		{desc: "too large", data: "<a>" + strings.Repeat("x", 200) + "</a>", expected: "document larger than 200 bytes"},
+		{desc: "too many references", data: "<a>&amp;&lt;</a><b x='&gt;&quot;&apos;'/>", expected: "more than 4 entity references at line 1, column 17"},
+		{desc: "entity declarations", data: laughs, expected: "more than 4 entity references at line 1, column 1"},
+		{desc: "unlimited", data: "<a><b><c><d>x</d></c></b></a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			check := limits
+			if tt.desc == "unlimited" {
+				check = ParseLimits{}
+			}
+			err := check.Check(tt.data)
+			if tt.expected == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrParseLimit)
+			require.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+// Test /add turning away documents over the parser limits
+func TestHandleAddRequestLimits(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(limits ParseLimits) { parseLimits = limits }(parseLimits)
+	parseLimits = ParseLimits{MaxDepth: 2, MaxInputBytes: 100}
+
+	tests := []struct {
+		desc         string
+		data         string
+		expectedCode int
+		expectedBody string
+	}{
+		{desc: "within limits", data: "<document><title>T</title></document>", expectedCode: http.StatusCreated},
+		{desc: "too deep", data: "<document><a><b>x</b></a></document>", expectedCode: http.StatusRequestEntityTooLarge, expectedBody: "Failed to parse document: parser limit exceeded: elements nested deeper than 2 at line 1, column 14\n"},
+		{desc: "too large", data: "<document>" + strings.Repeat("x", 100) + "</document>", expectedCode: http.StatusRequestEntityTooLarge, expectedBody: "Failed to read request body: parser limit exceeded: document larger than 100 bytes\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleRequest(db, rr, httptest.NewRequest("POST", "/add", strings.NewReader(tt.data)))
+			require.Equal(t, tt.expectedCode, rr.Code)
+			if tt.expectedBody != "" {
+				require.Equal(t, tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
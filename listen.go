@@ -0,0 +1,118 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	LISTEN_ADDR_ENV = "DOC_LISTEN"      // Environment variable with the address to listen on, like ":3456" or "unix:/run/goapp.sock"
+	SOCKET_MODE_ENV = "DOC_SOCKET_MODE" // Environment variable with the octal file mode of a unix socket, like "0660"
+
+	UNIX_ADDR_PREFIX    = "unix:" // Prefix of listen addresses which are unix socket paths
+	DEFAULT_SOCKET_MODE = 0660    // File mode of unix sockets if not configured, so a proxy in the group can connect
+
+	SYSTEMD_LISTEN_FDS_START = 3 // First file descriptor passed by systemd socket activation
+)
+
+// systemdListeners returns the sockets passed by systemd socket activation, none if the process wasn't activated
+// The LISTEN_* variables are removed so child processes don't take the sockets for theirs.
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := SYSTEMD_LISTEN_FDS_START; fd < SYSTEMD_LISTEN_FDS_START+count; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("socket %d from systemd: %v", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a unix socket at path with the file mode of the environment
+// A socket left behind by a previous run is removed, other files are not.
+func listenUnix(path string) (net.Listener, error) {
+	mode := os.FileMode(DEFAULT_SOCKET_MODE)
+	if value := os.Getenv(SOCKET_MODE_ENV); value != "" {
+		parsed, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || parsed > 0777 {
+			return nil, fmt.Errorf("invalid %s %q", SOCKET_MODE_ENV, value)
+		}
+		mode = os.FileMode(parsed)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		// Only a socket nobody accepts on is stale
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// listen opens the listeners RunServer serves on: the sockets passed by systemd if the process was
+// socket activated, otherwise addr, a TCP address or a unix socket path prefixed with "unix:"
+func listen(addr string) ([]net.Listener, error) {
+	funcName := "listen"
+
+	listeners, err := systemdListeners()
+	if err != nil || len(listeners) > 0 {
+		if err == nil {
+			log.Printf("%s: Using %d sockets from systemd, ignoring %s", funcName, len(listeners), addr)
+		}
+		return listeners, err
+	}
+
+	var listener net.Listener
+	if strings.HasPrefix(addr, UNIX_ADDR_PREFIX) {
+		path := strings.TrimPrefix(addr, UNIX_ADDR_PREFIX)
+		if path == "" {
+			return nil, errors.New("unix socket path is empty")
+		}
+		listener, err = listenUnix(path)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{listener}, nil
+}
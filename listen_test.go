@@ -0,0 +1,45 @@
+package goapp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test listening on a unix socket, replacing a stale one but not other files
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "goapp.sock")
+
+	listeners, err := listen(UNIX_ADDR_PREFIX + path)
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(DEFAULT_SOCKET_MODE), info.Mode().Perm())
+
+	// A socket in use isn't taken over
+	_, err = listen(UNIX_ADDR_PREFIX + path)
+	require.Error(t, err)
+
+	// A socket left behind by a crash is
+	listeners[0].(*net.UnixListener).SetUnlinkOnClose(false)
+	listeners[0].Close()
+	listeners, err = listen(UNIX_ADDR_PREFIX + path)
+	require.NoError(t, err)
+	listeners[0].Close()
+
+	file := filepath.Join(dir, "data")
+	require.NoError(t, os.WriteFile(file, []byte("keep"), 0600))
+	_, err = listen(UNIX_ADDR_PREFIX + file)
+	require.Error(t, err)
+	_, err = listen(UNIX_ADDR_PREFIX)
+	require.Error(t, err)
+
+	t.Setenv(SOCKET_MODE_ENV, "999")
+	_, err = listen(UNIX_ADDR_PREFIX + path)
+	require.Error(t, err)
+}
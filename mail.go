@@ -0,0 +1,199 @@
+package goapp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	SMTP_ADDR_ENV      = "DOC_SMTP_ADDR"      // Environment variable with the host:port of the SMTP server notification emails are sent through
+	SMTP_FROM_ENV      = "DOC_SMTP_FROM"      // Environment variable with the sender of notification emails
+	SMTP_USERNAME_ENV  = "DOC_SMTP_USERNAME"  // Environment variable with the user of the SMTP server, if it requires authentication
+	SMTP_PASSWORD_ENV  = "DOC_SMTP_PASSWORD"  // Environment variable with the password of the SMTP user
+	SMTP_TLS_ENV       = "DOC_SMTP_TLS"       // Environment variable with how the connection to the SMTP server is secured, one of the SMTP_TLS_* constants
+	SMTP_TEMPLATES_ENV = "DOC_SMTP_TEMPLATES" // Environment variable with the path of a file redefining the email templates
+
+	SMTP_TLS_STARTTLS = "starttls" // Upgrade the connection with STARTTLS, usually on port 587
+	SMTP_TLS_IMPLICIT = "tls"      // Connect with TLS from the start, usually on port 465
+	SMTP_TLS_NONE     = "none"     // Send in plain text, only for servers on a trusted network
+
+	SMTP_TIMEOUT = 30 * time.Second // Timeout of connecting to the SMTP server
+)
+
+// defaultMailTemplates define the subject and body of every kind of notification email, as "{kind}.subject" and "{kind}.body"
+const defaultMailTemplates = `
+{{define "alert.subject"}}[{{.Status}}] {{.Rule}}: {{.Source}}{{end}}
+{{define "alert.body"}}{{.Message}}
+{{end}}
+{{define "report.subject"}}Ingestion report {{.To}}{{end}}
+{{define "report.body"}}Ingestion {{if .From}}from {{.From}} {{end}}until {{.To}}: {{.Documents}} documents, {{.Failures}} failures
+{{range .Sources}}
+{{.Name}}: {{.Documents}} documents, {{.Failures}} failures, {{.Bytes}} bytes{{if .LastError}}
+  last error: {{.LastError}}{{end}}{{end}}
+{{end}}`
+
+// Mailer sends notification emails rendered from templates through an SMTP server
+type Mailer struct {
+	Addr      string    // Addr is the host:port of the SMTP server
+	From      string    // From is the sender of emails
+	Auth      smtp.Auth // Auth authenticates with the SMTP server, nil without authentication
+	TLS       string    // TLS is one of the SMTP_TLS_* constants
+	Templates *template.Template
+}
+
+// mailer is the SMTP notifier of the server, nil unless configured by initMailer
+var mailer *Mailer
+
+// sendMail delivers a message through the SMTP server of the mailer, replaced in tests
+var sendMail = deliverMail
+
+// initMailer sets up sending notification emails if an SMTP server is configured
+func initMailer() {
+	funcName := "initMailer"
+
+	addr := os.Getenv(SMTP_ADDR_ENV)
+	if addr == "" {
+		return
+	}
+	from := os.Getenv(SMTP_FROM_ENV)
+	if from == "" {
+		log.Fatalf("%s: %s is required with %s", funcName, SMTP_FROM_ENV, SMTP_ADDR_ENV)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		log.Fatalf("%s: %s must be host:port: %v", funcName, SMTP_ADDR_ENV, err)
+	}
+
+	mode := SMTP_TLS_STARTTLS
+	if value := os.Getenv(SMTP_TLS_ENV); value != "" {
+		mode = value
+	}
+	templates := ""
+	if path := os.Getenv(SMTP_TEMPLATES_ENV); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatalf("%s: Failed to read %s: %v", funcName, SMTP_TEMPLATES_ENV, err)
+		}
+		templates = string(data)
+	}
+	mailer, err = newMailer(addr, from, mode, templates)
+	if err != nil {
+		log.Fatalf("%s: %v", funcName, err)
+	}
+	if username := os.Getenv(SMTP_USERNAME_ENV); username != "" {
+		mailer.Auth = smtp.PlainAuth("", username, os.Getenv(SMTP_PASSWORD_ENV), host)
+	}
+}
+
+// newMailer creates a mailer sending from the address through the SMTP server at addr
+// templates may redefine the default templates, like {{define "alert.subject"}}...{{end}}
+func newMailer(addr string, from string, mode string, templates string) (*Mailer, error) {
+	switch mode {
+	case SMTP_TLS_STARTTLS, SMTP_TLS_IMPLICIT, SMTP_TLS_NONE:
+	default:
+		return nil, fmt.Errorf("invalid TLS mode %q, must be %s, %s or %s", mode, SMTP_TLS_STARTTLS, SMTP_TLS_IMPLICIT, SMTP_TLS_NONE)
+	}
+	parsed, err := template.New("mail").Parse(defaultMailTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if templates != "" {
+		if parsed, err = parsed.Parse(templates); err != nil {
+			return nil, fmt.Errorf("invalid email templates: %v", err)
+		}
+	}
+	return &Mailer{Addr: addr, From: from, TLS: mode, Templates: parsed}, nil
+}
+
+// Send mails the kind of notification, like "alert" or "report", rendered with data to the addresses
+func (mailer *Mailer) Send(to []string, kind string, data interface{}) error {
+	var subject, body bytes.Buffer
+	if err := mailer.Templates.ExecuteTemplate(&subject, kind+".subject", data); err != nil {
+		return err
+	}
+	if err := mailer.Templates.ExecuteTemplate(&body, kind+".body", data); err != nil {
+		return err
+	}
+	return sendMail(mailer, to, mailer.message(to, strings.TrimSpace(subject.String()), body.String()))
+}
+
+// message formats an email with the subject and the plain text body
+func (mailer *Mailer) message(to []string, subject string, body string) []byte {
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", mailer.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	// SMTP requires CRLF line endings
+	for _, line := range strings.Split(strings.TrimRight(body, "\r\n"), "\n") {
+		message.WriteString(strings.TrimSuffix(line, "\r") + "\r\n")
+	}
+	return []byte(message.String())
+}
+
+// deliverMail sends the message to the addresses through the SMTP server of the mailer, secured by its TLS mode
+func deliverMail(mailer *Mailer, to []string, message []byte) error {
+	host, _, err := net.SplitHostPort(mailer.Addr)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: SMTP_TIMEOUT}
+	if mailer.TLS == SMTP_TLS_IMPLICIT {
+		conn, err = tls.DialWithDialer(dialer, "tcp", mailer.Addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", mailer.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if mailer.TLS == SMTP_TLS_STARTTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if mailer.Auth != nil {
+		if err := client.Auth(mailer.Auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(mailer.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
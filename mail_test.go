@@ -0,0 +1,112 @@
+package goapp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test rendering notification emails from the default and custom templates
+func TestMailerSend(t *testing.T) {
+	var sent []string
+	defer func(previous func(*Mailer, []string, []byte) error) { sendMail = previous }(sendMail)
+	sendMail = func(mailer *Mailer, to []string, message []byte) error {
+		sent = append(sent, strings.Join(to, ","), string(message))
+		return nil
+	}
+
+	alert := Alert{Rule: "feed", Source: "dir:./feed", Status: ALERT_STATUS_RESOLVED, Message: "Ingestion from dir:./feed resumed"}
+	mailer, err := newMailer("mail.example.com:587", "alerts@example.com", SMTP_TLS_STARTTLS, `{{define "alert.subject"}}Feed {{.Source}} {{.Status}} ✓{{end}}`)
+	require.NoError(t, err)
+	require.NoError(t, mailer.Send([]string{"a@example.com", "b@example.com"}, "alert", alert))
+	require.Equal(t, []string{
+		"a@example.com,b@example.com",
+		"From: alerts@example.com\r\nTo: a@example.com, b@example.com\r\nSubject: =?utf-8?q?Feed_dir:./feed_resolved_=E2=9C=93?=\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nIngestion from dir:./feed resumed\r\n",
+	}, sent)
+
+	require.Error(t, mailer.Send([]string{"a@example.com"}, "digest", alert))
+
+	_, err = newMailer("mail.example.com:25", "alerts@example.com", "ssl", "")
+	require.EqualError(t, err, `invalid TLS mode "ssl", must be starttls, tls or none`)
+	_, err = newMailer("mail.example.com:25", "alerts@example.com", SMTP_TLS_NONE, `{{define "alert.body"}}{{.Message}`)
+	require.Error(t, err)
+}
+
+// Test delivering a message to an SMTP server
+func TestDeliverMail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// The server accepts one message and records the commands and data it received
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				conn.Write([]byte("250 queued\r\n"))
+			case inData:
+			case line == "DATA":
+				inData = true
+				conn.Write([]byte("354 go ahead\r\n"))
+			case line == "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				received <- lines
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+		received <- lines
+	}()
+
+	mailer, err := newMailer(listener.Addr().String(), "alerts@example.com", SMTP_TLS_NONE, "")
+	require.NoError(t, err)
+	require.NoError(t, deliverMail(mailer, []string{"ops@example.com"}, mailer.message([]string{"ops@example.com"}, "Test", "Hello")))
+
+	lines := <-received
+	require.Equal(t, "MAIL FROM:<alerts@example.com>", lines[1])
+	require.Equal(t, "RCPT TO:<ops@example.com>", lines[2])
+	require.Equal(t, "DATA", lines[3])
+	require.Contains(t, lines, "Subject: Test")
+	require.Contains(t, lines, "Hello")
+	require.Equal(t, "QUIT", lines[len(lines)-1])
+
+	// STARTTLS is required unless disabled, the server doesn't support it
+	mailer.TLS = SMTP_TLS_STARTTLS
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+		reader.ReadString('\n')
+		conn.Write([]byte("250 ok\r\n"))
+		reader.ReadString('\n')
+		conn.Write([]byte("502 STARTTLS not supported\r\n"))
+	}()
+	err = deliverMail(mailer, []string{"ops@example.com"}, []byte("Hello\r\n"))
+	require.EqualError(t, err, `502 "STARTTLS not supported"`)
+}
@@ -1,4 +1,4 @@
-package main
+package goapp
 
 import (
 	"database/sql"
@@ -10,166 +10,445 @@ import (
 	"net/http"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	DB_TABLE_NAME             = "doc"         // Table name for SQLite
-	DB_ID_FIELD_NAME          = "id"          // Field name for id in SQLite table
-	DB_TITLE_FIELD_NAME       = "title"       // Field name for title in SQLite table
-	DB_DESCRIPTION_FIELD_NAME = "description" // Field name for description in SQLite table
-	DB_AUTHOR_FIELD_NAME      = "author"      // Field name for author in SQLite table
-	DB_CREATEDAT_FIELD_NAME   = "created_at"  // Field name for created_at in SQLite table
-	DB_XMLDATA_FIELD_NAME     = "xml_data"    // Field name for xml_data in SQLite table
-
-	XML_FILES_PATH        = "./xml_files"    // XML file path to get all xml files in the storage
-	XML_TITLE_PREFIX      = "<title>"        // XML tag prefix for title
-	XML_DESCIPTION_PREFIX = "<description>"  // XML tag prefix for description
-	XML_AUTHOR_PREFIX     = "<author>"       // XML tag prefix for author
-	XML_CREATEDAT_PREFIX  = "<creationDate>" // XML tag prefix for creationDate
+	DB_TABLE_NAME               = "doc"            // Table name for SQLite
+	DB_ID_FIELD_NAME            = "id"             // Field name for id in SQLite table
+	DB_TITLE_FIELD_NAME         = "title"          // Field name for title in SQLite table
+	DB_DESCRIPTION_FIELD_NAME   = "description"    // Field name for description in SQLite table
+	DB_AUTHOR_FIELD_NAME        = "author"         // Field name for author in SQLite table
+	DB_CREATEDAT_FIELD_NAME     = "created_at"     // Field name for created_at in SQLite table
+	DB_XMLDATA_FIELD_NAME       = "xml_data"       // Field name for xml_data in SQLite table
+	DB_LANGDATA_FIELD_NAME      = "lang_data"      // Field name for lang_data (JSON encoded language variants) in SQLite table
+	DB_EXPIRESAT_FIELD_NAME     = "expires_at"     // Field name for expires_at in SQLite table
+	DB_STATE_FIELD_NAME         = "state"          // Field name for state in SQLite table
+	DB_PARSERVERSION_FIELD_NAME = "parser_version" // Field name for parser_version in SQLite table
+	DB_CREATEDOFFSET_FIELD_NAME = "created_offset" // Field name for created_offset (original offset of created_at) in SQLite table
+	DB_DATEPROFILE_FIELD_NAME   = "date_profile"   // Field name for date_profile (profile created_at was parsed with) in SQLite table
+	DB_WORDCOUNT_FIELD_NAME     = "word_count"     // Field name for word_count in SQLite table
+	DB_CHARCOUNT_FIELD_NAME     = "char_count"     // Field name for char_count in SQLite table
+	DB_ELEMENTCOUNT_FIELD_NAME  = "element_count"  // Field name for element_count in SQLite table
+	DB_MAXDEPTH_FIELD_NAME      = "max_depth"      // Field name for max_depth in SQLite table
+	DB_PREVIEW_FIELD_NAME       = "preview"        // Field name for preview (HTML escaped first sentences) in SQLite table
+	DB_TREE_FIELD_NAME          = "tree"           // Field name for tree (JSON element tree) in SQLite table
+	DB_REVISION_FIELD_NAME      = "revision"       // Field name for revision (bumped by every patch) in SQLite table
+
+	DB_VALIDATIONSCHEMA_FIELD_NAME     = "validation_schema"     // Field name for validation_schema (schema the document was validated against) in SQLite table
+	DB_VALIDATIONSTATUS_FIELD_NAME     = "validation_status"     // Field name for validation_status (passed or failed) in SQLite table
+	DB_VALIDATIONVIOLATIONS_FIELD_NAME = "validation_violations" // Field name for validation_violations (JSON encoded violations) in SQLite table
+	DB_VALIDATEDAT_FIELD_NAME          = "validated_at"          // Field name for validated_at in SQLite table
+	DB_PROLOG_FIELD_NAME               = "prolog"                // Field name for prolog (markup before the root element) in SQLite table
+	DB_DOCTYPE_FIELD_NAME              = "doctype"               // Field name for doctype (root element name declared by the DOCTYPE) in SQLite table
+	DB_AUTHORS_FIELD_NAME              = "authors"               // Field name for authors (JSON encoded list of all authors) in SQLite table
+	DB_CUSTOM_FIELD_NAME               = "custom_fields"         // Field name for custom_fields (JSON encoded fields of registered extractors) in SQLite table
+	DB_CANONICALHASH_FIELD_NAME        = "canonical_hash"        // Field name for canonical_hash (SHA-256 of the canonical XML) in SQLite table
+	DB_CANONICALHASH_INDEX_NAME        = "doc_canonical_hash"    // Index of canonical_hash, to find duplicates of submitted documents
+
+	XML_FILES_PATH        = "./xml_files"  // XML file path to get all xml files in the storage
+	XML_AUTHOR_FIELD      = "author"       // Element name of the author metadata
+	XML_CREATEDAT_FIELD   = "creationDate" // Element name of the creation date metadata
+	XML_EXPIRESAT_FIELD   = "expiresAt"    // Element name of the expiry metadata
+	XML_EXPIRES_ATTRIBUTE = "expires"      // Root element attribute holding the expiry
 
 	SPLIT_XMLDATA_STR = "µ∜⨚Ť¿" // String to split and join XML data
 )
 
 // XML Document struct to hold parsed data
 type XMLDoc struct {
-	ID          string
-	Title       string
-	Description string
-	Author      string
-	CreatedAt   string
-	XMLData     []string
+	ID            string
+	Title         string
+	Description   string
+	Author        string            // Author is the first author, kept for clients reading a single one
+	Authors       []string          `json:",omitempty"` // Authors are the texts of all author elements in document order
+	Custom        map[string]string `json:",omitempty"` // Custom holds the fields computed by registered extractors
+	CanonicalHash string            `json:",omitempty"` // CanonicalHash is the SHA-256 of the canonical XML of the document, empty if it couldn't be computed
+	CreatedAt     string            // CreatedAt is in UTC if the source date had an offset
+	CreatedOffset string            // CreatedOffset is the original offset of CreatedAt, e.g. "+02:00"
+	DateProfile   string            // DateProfile is the date parsing profile of the source of the document
+	Stats         DocumentStats
+	Preview       string        // Preview is the HTML escaped beginning of the text of the document
+	XMLData       []string      `json:",omitempty"`
+	Paths         []string      `json:",omitempty"` // Paths locate the elements of XMLData in the same order, like /document/metadata/author[1]
+	Tree          *Node         `json:",omitempty"` // Tree is the element tree of the document
+	Elements      []FlatElement `json:",omitempty"` // Elements replace Tree in /document for tenants with the flat_tree feature, they aren't stored
+	Revision      int           // Revision starts at 1 and is bumped whenever the document is patched
+	Validation    ValidationResult
+	Declaration   *XMLDeclaration         `json:",omitempty"` // Declaration is the XML declaration of the document, nil if it has none
+	Instructions  []ProcessingInstruction `json:",omitempty"` // Instructions are the processing instructions of the document besides the declaration
+	Prolog        string                  `json:"-"`          // Prolog is the markup before the root element, like the XML declaration
+	Doctype       string                  `json:",omitempty"` // Doctype is the root element name declared by the DOCTYPE of the document, like "html"
+	Tags          []string                `json:",omitempty"` // Tags are the labels given to the document with /documents/tags, in alphabetical order
+	Variants      []LangVariant
+	Language      string `json:",omitempty"` // Language is the xml:lang of the document, or its detected language, like "en" or "pt-BR"
+	ExpiresAt     string
+	PublishAt     string `json:",omitempty"` // PublishAt is the time in UTC before which the document is embargoed, empty if it is published
+	Tier          string `json:",omitempty"` // Tier is TIER_COLD while the XML of the document is in cold storage
+	Tenant        string `json:"-"`          // Tenant is the tenant which added the document through /add, for storage reports
+	State         string
+	ParserVersion string         // ParserVersion identifies the parser and ruleset the metadata was extracted with
+	Overflow      []TextOverflow `json:"-"`          // Overflow holds the full text of elements truncated when ingested, served by /overflow
+	Warnings      []ParseError   `json:",omitempty"` // Warnings are the repairs of a lenient parse, they aren't stored
+
+	ProcessingLog []ProcessingEntry `json:",omitempty"` // ProcessingLog lists the changes of the ingest rules to the XML, oldest first
+
+	xmlDataTree *Node // xmlDataTree replaces XMLData in the JSON of responses asking for XMLDATA_MODE_TREE
 }
 
-// parseXML parses XML-formed string to array
-// Array's order is the same with visiting tree by depth-order
-func parseXML(data string) ([]string, error) {
-	// XMLTag represents a parsed XML tag with its index
-	type XMLTag struct {
-		Tag   string // Tag represents the XML tag string ("<tag>" or "</tag>")
-		Index int    // Index is the starting index of the tag in the original XML data string
-	}
-
-	var result []string   // The result which returned in this function
-	var xmlTags []XMLTag  // Slice to hold parsed XML tags
-	var currentTag XMLTag // current tag for cache
-	inTag := false        // Flag to track if currently parsing inside a tag
-
-	// Parse through the XML string character by character
-	for i, char := range data {
-		if char == '<' { // If it's a new start of a tag
-			inTag = true
-			if currentTag.Tag != "" {
-				return nil, errors.New("tag pairing error") // Return error if tags are not properly paired
+// XMLTag represents a parsed XML tag with its index
+type XMLTag struct {
+	Tag   string // Tag represents the XML tag string ("<tag>" or "</tag>")
+	Index int    // Index is the starting index of the tag in the original XML data string
+}
+
+// scanXMLTags returns the tags of an XML-formed string in order, leaving out CDATA sections and comments
+// Tags are substrings of data found by their byte indices, so scanning allocates little more than the slice of tags.
+// A '>' outside of tags is text. Errors are *ParseError with the position of the error in data
+func scanXMLTags(data string) ([]XMLTag, error) {
+	xmlTags := make([]XMLTag, 0, strings.Count(data, "<")) // Slice to hold parsed XML tags
+
+	for i := 0; i < len(data); {
+		start := strings.IndexByte(data[i:], '<')
+		if start < 0 {
+			break
+		}
+		start += i
+
+		// CDATA sections, comments, processing instructions and the DOCTYPE may hold '<' and '>'
+		end := sectionEnd(data, start)
+		if end < 0 {
+			return nil, newParseError(data, start, sectionError(data, start))
+		} else if end > 0 {
+			i = end
+			continue
+		}
+
+		// The tag ends at the next '>', another '<' before it means tags are not properly paired
+		end = tagEnd(data, start, "<>")
+		if end < 0 {
+			// An attribute value which is never closed would hide the rest of the document
+			if strings.IndexByte(data[start:], '>') >= 0 {
+				return nil, newParseError(data, start, "unterminated attribute value")
 			}
-			currentTag.Tag = "<"
-			currentTag.Index = i
-		} else if char == '>' { // If it's the end of a tag
-			inTag = false
-			currentTag.Tag += ">"
-			xmlTags = append(xmlTags, currentTag)
-			currentTag.Tag = ""
-		} else { // If not, then add the letter to currentTag
-			if inTag {
-				currentTag.Tag += string(char)
+			// A tag which is never closed is left out
+			break
+		}
+		if data[end] == '<' {
+			return nil, newParseError(data, end, "tag pairing error")
+		}
+		xmlTags = append(xmlTags, XMLTag{Tag: data[start : end+1], Index: start})
+		i = end + 1
+	}
+	return xmlTags, nil
+}
+
+// tagEnd returns the index of the first of the bytes stops after the '<' at data[start] which is not in a quoted
+// attribute value, -1 if there is none
+// Attribute values may hold '<' and '>' like in `<img alt="a > b">`, so they don't end the tag.
+func tagEnd(data string, start int, stops string) int {
+	var quote byte
+	for i := start + 1; i < len(data); i++ {
+		switch char := data[i]; {
+		case quote != 0:
+			if char == quote {
+				quote = 0
 			}
+		case char == '"' || char == '\'':
+			quote = char
+		case strings.IndexByte(stops, char) >= 0:
+			return i
 		}
 	}
+	return -1
+}
+
+// tagNameBefore returns the part of a tag's content before its first space, like "section" of `section id="1"`
+func tagNameBefore(content string) string {
+	if i := strings.IndexByte(content, ' '); i >= 0 {
+		return content[:i]
+	}
+	return content
+}
 
-	var stack []XMLTag // Stack to manage nested tags
-	index := 0         // Depth index counter
+// parseXML parses XML-formed string to array
+// Array's order is the same with visiting tree by depth-order, unless another XMLDATA_ORDER_* is picked WithOrder
+// Whitespace of the elements is handled by WithWhitespace, broken tags are repaired WithLenient and deep nesting is
+// rejected WithMaxDepth
+// Errors are *ParseError with the position of the error in data
+func parseXML(data string, opts ...ParseOption) ([]string, error) {
+	options := newParseOptions(opts...)
+	if options.Lenient {
+		repaired, _, err := repairXML(data)
+		if err != nil {
+			return nil, err
+		}
+		data = repaired
+	}
+	if err := options.checkDepth(data); err != nil {
+		return nil, err
+	}
+	result, _, err := parseXMLPaths(data, options.Whitespace, options.Order)
+	return result, err
+}
+
+// parseXMLPaths parses data like parseXML, and also returns the path of each element, like /document/metadata/author[1]
+// Paths give the position of each element among its siblings of the same name, so they locate elements
+// unambiguously, like the paths of FlatElement. The root element has no position.
+// Elements are in the XMLDATA_ORDER_* order, breadth-first if it is empty.
+func parseXMLPaths(data string, whitespace string, order string) ([]string, []string, error) {
+	var result []string // The result which returned in this function
+
+	xmlTags, err := scanXMLTags(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var stack []XMLTag   // Stack to manage nested tags
+	var preserves []bool // preserves tells for each tag of the stack whether its element keeps its whitespace
+	var paths []string   // paths holds the path of each tag of the stack
+	// counts holds for the root and each tag of the stack how many children of each name it has had so far
+	counts := []map[string]int{{}}
+	index := 0 // Depth index counter
+
+	// childPath returns the path of the next child named name of the innermost open element
+	childPath := func(name string) string {
+		siblings := counts[len(counts)-1]
+		siblings[name]++
+		if len(paths) == 0 {
+			return "/" + name
+		}
+		return fmt.Sprintf("%s/%s[%d]", paths[len(paths)-1], name, siblings[name])
+	}
 
 	// XMLData represents extracted XML data along with its depth
 	type XMLData struct {
-		Data  string // Data is the extracted XML data including its tags
-		Depth int    // Depth represents the nested level of the XML data
+		Data     string // Data is the extracted XML data including its tags
+		Path     string // Path locates the element in the document
+		Depth    int    // Depth represents the nested level of the XML data
+		Start    int    // Start is the index of the start tag of the element in data
+		Preserve bool   // Preserve is true when the parent of the element keeps its whitespace
 	}
-	var xmlDataArr []XMLData // Slice to hold final extracted XML data
+	xmlDataArr := make([]XMLData, 0, len(xmlTags)) // Slice to hold final extracted XML data, at most one per tag
 
 	// Process each parsed XML tag
 	for _, tag := range xmlTags {
 		if strings.HasPrefix(tag.Tag, "</") { // If it's a closing tag
 			if len(stack) == 0 {
-				return nil, errors.New("no opening tag error: no opening tag") // Return error if no matching opening tag found
+				return nil, nil, newParseError(data, tag.Index, "no opening tag error: no opening tag") // Return error if no matching opening tag found
 			}
 			lastTag := stack[len(stack)-1] // Get the last opened tag from the stack
 
-			if strings.Split(lastTag.Tag[1:len(lastTag.Tag)-1], " ")[0] == strings.Split(tag.Tag[2:len(tag.Tag)-1], " ")[0] { // Check if the closing tag matches the last opened tag ***split is needed if tag is like this: "<section id="1">"***
-				data := XMLData{Data: data[lastTag.Index:tag.Index] + tag.Tag, Depth: index}
+			if tagNameBefore(lastTag.Tag[1:len(lastTag.Tag)-1]) == tagNameBefore(tag.Tag[2:len(tag.Tag)-1]) { // Check if the closing tag matches the last opened tag ***the name ends at a space if tag is like this: "<section id="1">"***
+				preserves = preserves[:len(preserves)-1]
+				// The element is a substring of data from its start tag through its closing tag
+				data := XMLData{Data: data[lastTag.Index : tag.Index+len(tag.Tag)], Path: paths[len(paths)-1], Depth: index, Start: lastTag.Index, Preserve: len(preserves) > 0 && preserves[len(preserves)-1]}
 				xmlDataArr = append(xmlDataArr, data) // Add to xmlDataArr
 				stack = stack[:len(stack)-1]
+				paths = paths[:len(paths)-1]
+				counts = counts[:len(counts)-1]
 				index--
 			} else {
-				return nil, errors.New("unmatched closing tag error: " + lastTag.Tag + " " + tag.Tag) // Return error if closing tag doesn't match
+				return nil, nil, newParseError(data, tag.Index, "unmatched closing tag error: "+lastTag.Tag+" "+tag.Tag) // Return error if closing tag doesn't match
 			}
 		} else {
 			if strings.HasSuffix(tag.Tag, "/>") { // If self-closing tag
-				data := XMLData{Data: tag.Tag, Depth: index}
+				// The element is one level below the open elements, like the elements closed with a tag
+				data := XMLData{Data: tag.Tag, Path: childPath(tagName(tag.Tag)), Depth: index + 1, Start: tag.Index}
 				xmlDataArr = append(xmlDataArr, data)
 			} else if !(strings.HasPrefix(tag.Tag, "<!--")) { // Check if it's a comment
+				preserves = append(preserves, xmlSpacePreserve(tag.Tag, len(preserves) > 0 && preserves[len(preserves)-1]))
+				paths = append(paths, childPath(tagName(tag.Tag)))
+				counts = append(counts, map[string]int{})
 				stack = append(stack, tag)
 				index++
 			}
 		}
 	}
 
-	// Sort xmlDataArr by depth
-	sort.Slice(xmlDataArr, func(i, j int) bool {
-		return xmlDataArr[i].Depth < xmlDataArr[j].Depth
-	})
+	// Elements are collected as they are closed, which is depth-first order
+	switch order {
+	case XMLDATA_ORDER_DOCUMENT:
+		sort.Slice(xmlDataArr, func(i, j int) bool {
+			return xmlDataArr[i].Start < xmlDataArr[j].Start
+		})
+	case XMLDATA_ORDER_DEPTH_FIRST:
+	default:
+		// Sort xmlDataArr by depth, elements of the same depth stay in document order
+		sort.SliceStable(xmlDataArr, func(i, j int) bool {
+			return xmlDataArr[i].Depth < xmlDataArr[j].Depth
+		})
+	}
 
+	result = make([]string, 0, len(xmlDataArr))
+	resultPaths := make([]string, 0, len(xmlDataArr))
 	for _, data := range xmlDataArr {
-		// Clean up unnecessary characters from data
-		str := strings.ReplaceAll(data.Data, "\t", "")
-		str = strings.ReplaceAll(str, "    ", "")
-		str = strings.ReplaceAll(str, "\n", "")
-		str = strings.ReplaceAll(str, "\r", "")
-
-		result = append(result, str)
+		// Clean up unnecessary whitespace from data
+		result = append(result, normalizeWhitespace(data.Data, whitespace, data.Preserve))
+		resultPaths = append(resultPaths, data.Path)
 	}
 
-	return result, nil
+	return result, resultPaths, nil
 }
 
 // Function to parse XML-formed string to XMLDoc struct
-func parseDocument(data string) (*XMLDoc, error) {
+func parseDocument(data string, opts ...ParseOption) (*XMLDoc, error) {
+	return parseDocumentWithOptions(data, newParseOptions(opts...))
+}
+
+// parseDocumentWithOptions parses XML-formed string to XMLDoc struct like parseDocument
+// In lenient mode broken tags are repaired and each repair is reported in the Warnings of the document
+func parseDocumentWithOptions(data string, options ParseOptions) (*XMLDoc, error) {
 	if data == "" {
 		return nil, errors.New("no data for parsing")
 	}
 
+	if options.Fragment {
+		data = wrapFragment(data)
+	}
+	var warnings []ParseError
+	if options.HTML {
+		var err error
+		data, warnings, err = repairHTML(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Lenient || options.HTML {
+		repaired, repairs, err := repairXML(data)
+		if err != nil {
+			return nil, err
+		}
+		data, warnings = repaired, append(warnings, repairs...)
+	}
+
+	// Entities declared by the DOCTYPE are expanded, external ones only if the resolver allows them
+	data, err := expandEntities(data, entityResolver, parseLimits)
+	if err != nil {
+		return nil, err
+	}
+	if err := options.checkDepth(data); err != nil {
+		return nil, err
+	}
+
 	// Get xmlDoc-formed data by calling parseXML
-	xmlDataArr, err := parseXML(data)
+	// The elements are parsed breadth-first, so the root element comes first
+	xmlDataArr, paths, err := parseXMLPaths(data, options.Whitespace, XMLDATA_ORDER_BREADTH_FIRST)
 	if err != nil {
 		return nil, err
 	}
 
-	doc := XMLDoc{}
+	doc := XMLDoc{Warnings: warnings}
 
-	for _, str := range xmlDataArr {
-		// Check and parse specific elements if they match known prefixes
+	// The tree is built first so fields can be mapped to paths
+	if len(xmlDataArr) > 0 {
+		doc.Tree, err = parseTree(strings.NewReader(xmlDataArr[0]), options.Comments)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		if strings.HasPrefix(str, XML_TITLE_PREFIX) && doc.Title == "" {
-			doc.Title = str[len(XML_TITLE_PREFIX) : len(str)-len(XML_TITLE_PREFIX)-1]
+	// Elements with child elements give the text content of their node, not their markup
+	contents := mixedContents(doc.Tree, paths)
+
+	// Collect language variants such as <title xml:lang="fr"> apart from the untagged metadata
+	for i, str := range xmlDataArr {
+		if variant, ok := parseLangVariant(str); ok {
+			if content, ok := contents[i]; ok {
+				variant.Value = content
+			}
+			doc.Variants = append(doc.Variants, variant)
 		}
-		if strings.HasPrefix(str, XML_DESCIPTION_PREFIX) && doc.Description == "" {
-			doc.Description = str[len(XML_DESCIPTION_PREFIX) : len(str)-len(XML_DESCIPTION_PREFIX)-1]
+	}
+
+	// Fields are read from the elements of their name unless they are mapped elsewhere, e.g. {"title": ["headline"]}
+	fields := map[string]*string{
+		XML_TITLE_FIELD:       &doc.Title,
+		XML_DESCRIPTION_FIELD: &doc.Description,
+		XML_AUTHOR_FIELD:      &doc.Author,
+		XML_CREATEDAT_FIELD:   &doc.CreatedAt,
+		XML_EXPIRESAT_FIELD:   &doc.ExpiresAt,
+	}
+	extracted := extractFields(&doc, xmlDataArr, contents, fieldRules(options), options)
+	for field, values := range extracted {
+		*fields[field] = values[0]
+	}
+	// Documents may credit several authors, all of which are kept besides the first
+	doc.Authors = extracted[XML_AUTHOR_FIELD]
+
+	// Fall back to the first language variant if there is no untagged element
+	if variant, ok := firstLangVariant(doc.Variants, XML_TITLE_FIELD); ok && doc.Title == "" {
+		doc.Title = variant.Value
+	}
+	if variant, ok := firstLangVariant(doc.Variants, XML_DESCRIPTION_FIELD); ok && doc.Description == "" {
+		doc.Description = variant.Value
+	}
+
+	// Creation dates from suppliers in different zones are stored in UTC so they compare consistently
+	doc.CreatedAt, doc.CreatedOffset = normalizeCreatedAt(doc.CreatedAt)
+
+	// The expiry may also be given as an attribute of the root element
+	if doc.ExpiresAt == "" && len(xmlDataArr) > 0 {
+		if root, ok := parseXMLElement(xmlDataArr[0]); ok {
+			doc.ExpiresAt, _ = root.Attr(XML_EXPIRES_ATTRIBUTE)
 		}
-		if strings.HasPrefix(str, XML_AUTHOR_PREFIX) && doc.Author == "" {
-			doc.Author = str[len(XML_AUTHOR_PREFIX) : len(str)-len(XML_AUTHOR_PREFIX)-1]
+	}
+	if doc.ExpiresAt != "" {
+		doc.ExpiresAt, err = parseExpiry(doc.ExpiresAt)
+		if err != nil {
+			return nil, err
 		}
-		if strings.HasPrefix(str, XML_CREATEDAT_PREFIX) && doc.CreatedAt == "" {
-			doc.CreatedAt = str[len(XML_CREATEDAT_PREFIX) : len(str)-len(XML_CREATEDAT_PREFIX)-1]
+	}
+	// Documents delivered ahead of their publication carry the time they may be read from
+	if len(xmlDataArr) > 0 {
+		if root, ok := parseXMLElement(xmlDataArr[0]); ok {
+			if value, ok := root.Attr(XML_PUBLISH_ATTRIBUTE); ok {
+				doc.PublishAt, err = parsePublishAt(value)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
 	doc.XMLData = xmlDataArr
+	doc.Paths = paths
+	doc.ParserVersion = parserVersion
+
+	// The prolog isn't part of XMLData, it is kept so the document can be served as it was sent
+	prolog, _ := splitProlog(data)
+	doc.Prolog = strings.TrimSpace(prolog)
+	doc.Declaration, doc.Instructions = parseInstructions(doc.rawXML())
+	doc.Doctype = parseDoctype(doc.Prolog)
+	doc.CanonicalHash = canonicalHash(doc.rawXML())
+
+	// Statistics are computed from the stored form of the document so reprocessing gives the same values
+	if len(xmlDataArr) > 0 {
+		doc.Stats = computeStats(xmlDataArr[0])
+		doc.Preview = makePreview(xmlDataArr[0], previewSentences)
+		doc.Language = documentLanguage(doc.Tree, extractText(xmlDataArr[0]))
+	}
+
+	// Extractors registered by users see the document with all of its metadata
+	runExtractors(&doc)
+
+	// Elements are put in the order asked for last, since the code above expects the root element first
+	if options.Order != "" && options.Order != XMLDATA_ORDER_BREADTH_FIRST {
+		doc.XMLData, doc.Paths, err = parseXMLPaths(data, options.Whitespace, options.Order)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &doc, nil
 }
 
 // loadXMLFiles loads XML files from the specified directory, parses them, and inserts into the database
+// Each file is claimed before it is ingested, so instances sharing the directory and database ingest it once
 func loadXMLFiles(db *sql.DB, directory string) error {
 	funcName := "loadXMLFiles"
 
@@ -184,23 +463,65 @@ func loadXMLFiles(db *sql.DB, directory string) error {
 		if !file.IsDir() && strings.HasSuffix(file.Name(), ".xml") {
 			// Read XML file content
 			filePath := filepath.Join(directory, file.Name())
+			source := "file:" + filePath
 			content, err := ioutil.ReadFile(filePath)
 			if err != nil {
-				log.Fatalf(funcName, "Error reading file %s: %v", filePath, err)
+				trackIngest(db, directorySource(filePath), 0, err)
+				reportIngestionFailure(source, err)
+				log.Printf("%s: Error reading file %s: %v", funcName, filePath, err)
 				continue
 			}
 
-			// Parse content to XMLDoc struct
-			doc, err := parseDocument(string(content))
+			// Skip files another instance, or an earlier run, already ingested
+			// Claims use the file name since instances may mount the directory at different paths
+			sum := checksum(content)
+			claimed, err := claimFile(db, file.Name(), sum, leaderElector.Holder, time.Now())
 			if err != nil {
-				log.Fatalf(funcName, err)
+				reportIngestionFailure(source, err)
+				log.Printf("%s: Error claiming file %s: %v", funcName, filePath, err)
+				continue
+			}
+			if !claimed {
 				continue
 			}
 
-			// Add doc to SQLite
-			err = insertDocument(db, *doc)
-			if err != nil {
-				log.Fatalf(funcName, err)
+			// Parse content to XMLDoc structs and add the docs to SQLite, behind interactive submissions
+			// Files holding several documents back to back, like logs, give a row per document.
+			var parseErr, insertErr error
+			for {
+				err = ingestQueue.Do(INGEST_PRIORITY_LOW, func() {
+					var docs []*XMLDoc
+					docs, parseErr = parseAllFrom(string(content), source)
+					if parseErr != nil {
+						trackIngest(db, directorySource(filePath), len(content), parseErr)
+						return
+					}
+					for _, doc := range docs {
+						if insertErr = insertDocument(db, *doc); insertErr != nil {
+							break
+						}
+					}
+					trackIngest(db, directorySource(filePath), len(content), insertErr)
+				})
+				if !errors.Is(err, ErrQueueFull) {
+					break
+				}
+				// Wait for the backlog to be worked off instead of dropping the file
+				time.Sleep(time.Duration(ingestQueue.RetryAfter()) * time.Second)
+			}
+			if parseErr != nil {
+				reportIngestionFailure(source, parseErr)
+				log.Printf("%s: Error parsing file %s: %v", funcName, filePath, parseErr)
+				continue
+			}
+			if insertErr != nil {
+				reportIngestionFailure(source, insertErr)
+				log.Printf("%s: Error inserting file %s: %v", funcName, filePath, insertErr)
+
+				// Let the next run retry the file
+				if err := releaseFile(db, file.Name(), sum); err != nil {
+					log.Printf("%s: Error releasing file %s: %v", funcName, filePath, err)
+				}
 			}
 		}
 	}
@@ -229,6 +550,132 @@ func initDB(db *sql.DB) {
 		log.Fatalf(funcName, "Failed to create table: %v", err)
 	}
 
+	// Add columns introduced after the initial schema so existing databases keep working
+	columns := []struct {
+		Name       string
+		Definition string
+	}{
+		{DB_LANGDATA_FIELD_NAME, "TEXT"},
+		{DB_EXPIRESAT_FIELD_NAME, "TEXT"},
+		{DB_STATE_FIELD_NAME, fmt.Sprintf("TEXT NOT NULL DEFAULT '%s'", DOC_STATE_ACTIVE)},
+		{DB_PARSERVERSION_FIELD_NAME, "TEXT"},
+		{DB_CREATEDOFFSET_FIELD_NAME, "TEXT"},
+		{DB_DATEPROFILE_FIELD_NAME, "TEXT"},
+		{DB_WORDCOUNT_FIELD_NAME, "INTEGER NOT NULL DEFAULT 0"},
+		{DB_CHARCOUNT_FIELD_NAME, "INTEGER NOT NULL DEFAULT 0"},
+		{DB_ELEMENTCOUNT_FIELD_NAME, "INTEGER NOT NULL DEFAULT 0"},
+		{DB_MAXDEPTH_FIELD_NAME, "INTEGER NOT NULL DEFAULT 0"},
+		{DB_PREVIEW_FIELD_NAME, "TEXT"},
+		{DB_TREE_FIELD_NAME, "TEXT"},
+		{DB_REVISION_FIELD_NAME, "INTEGER NOT NULL DEFAULT 1"},
+		{DB_VALIDATIONSCHEMA_FIELD_NAME, "TEXT"},
+		{DB_VALIDATIONSTATUS_FIELD_NAME, "TEXT"},
+		{DB_VALIDATIONVIOLATIONS_FIELD_NAME, "TEXT"},
+		{DB_VALIDATEDAT_FIELD_NAME, "TEXT"},
+		{DB_PROLOG_FIELD_NAME, "TEXT"},
+		{DB_DOCTYPE_FIELD_NAME, "TEXT"},
+		{DB_AUTHORS_FIELD_NAME, "TEXT"},
+		{DB_CUSTOM_FIELD_NAME, "TEXT"},
+		{DB_CANONICALHASH_FIELD_NAME, "TEXT"},
+		{DB_PUBLISHAT_FIELD_NAME, "TEXT"},
+		{DB_XMLPATHS_FIELD_NAME, "TEXT"},
+		{DB_TIER_FIELD_NAME, "TEXT"},
+		{DB_ACCESSEDAT_FIELD_NAME, "TEXT"},
+		{DB_TENANT_FIELD_NAME, "TEXT"},
+		{DB_COLDBYTES_FIELD_NAME, "INTEGER"},
+		{DB_LANGUAGE_FIELD_NAME, "TEXT"},
+		{DB_PROCESSINGLOG_FIELD_NAME, "TEXT"},
+	}
+	for _, column := range columns {
+		err = ensureColumn(db, DB_TABLE_NAME, column.Name, column.Definition)
+		if err != nil {
+			log.Fatalf("%s: Failed to add column %s: %v", funcName, column.Name, err)
+		}
+	}
+	// Not unique, since databases from before the hash may hold duplicates
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", DB_CANONICALHASH_INDEX_NAME, DB_TABLE_NAME, DB_CANONICALHASH_FIELD_NAME))
+	if err != nil {
+		log.Fatalf("%s: Failed to create index %s: %v", funcName, DB_CANONICALHASH_INDEX_NAME, err)
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", DB_PUBLISHAT_INDEX_NAME, DB_TABLE_NAME, DB_PUBLISHAT_FIELD_NAME))
+	if err != nil {
+		log.Fatalf("%s: Failed to create index %s: %v", funcName, DB_PUBLISHAT_INDEX_NAME, err)
+	}
+
+	err = createTagTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create tag table: %v", funcName, err)
+	}
+	err = createSuggestIndexes(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create suggestion indexes: %v", funcName, err)
+	}
+	err = createHoldTables(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create legal hold tables: %v", funcName, err)
+	}
+	err = createStorageTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create storage usage table: %v", funcName, err)
+	}
+	err = createChangeTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create change feed table: %v", funcName, err)
+	}
+
+	err = createTokenTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create access token table: %v", funcName, err)
+	}
+	err = createAuthFailureTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create auth failure table: %v", funcName, err)
+	}
+	err = createLeaseTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create leader lease table: %v", funcName, err)
+	}
+	err = createClaimTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create ingest claim table: %v", funcName, err)
+	}
+	err = createOverflowTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create overflow table: %v", funcName, err)
+	}
+	err = createSourceTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create ingestion source table: %v", funcName, err)
+	}
+	err = createSettingTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create runtime setting table: %v", funcName, err)
+	}
+	err = createFlagTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create feature flag table: %v", funcName, err)
+	}
+	err = createTenantTables(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create tenant tables: %v", funcName, err)
+	}
+	err = createUsageTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create usage table: %v", funcName, err)
+	}
+	err = createSessionTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create session table: %v", funcName, err)
+	}
+	err = createShareTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create share table: %v", funcName, err)
+	}
+	err = createAnnotationTable(db)
+	if err != nil {
+		log.Fatalf("%s: Failed to create annotation table: %v", funcName, err)
+	}
+
 	// Add document from files
 	// err = loadXMLFiles(db, XML_FILES_PATH)
 	// if err != nil {
@@ -236,57 +683,554 @@ func initDB(db *sql.DB) {
 	// }
 }
 
+// ensureColumn adds a column to the table if it does not exist yet
+func ensureColumn(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "%s" %s`, table, column, definition))
+	return err
+}
+
 // insertDocument inserts a document into the database
+// A document with an ID keeps it, e.g. when restored from an archive, otherwise a new ID is assigned
 func insertDocument(db *sql.DB, doc XMLDoc) error {
-	query := fmt.Sprintf(`
-		INSERT INTO %s (%s, %s, %s, %s, %s)
-		VALUES (?, ?, ?, ?, ?)
-	`, DB_TABLE_NAME, DB_TITLE_FIELD_NAME, DB_DESCRIPTION_FIELD_NAME, DB_AUTHOR_FIELD_NAME, DB_CREATEDAT_FIELD_NAME, DB_XMLDATA_FIELD_NAME)
-	_, err := db.Exec(query, doc.Title, doc.Description, doc.Author, doc.CreatedAt, strings.Join(doc.XMLData, SPLIT_XMLDATA_STR))
+	_, err := addDocument(db, doc)
 	return err
 }
 
+// addDocument inserts a document into the database like insertDocument and returns its ID
+func addDocument(db *sql.DB, doc XMLDoc) (string, error) {
+	defer observeQuery("insertDocument", time.Now())
+
+	langData, err := encodeLangVariants(doc.Variants)
+	if err != nil {
+		return "", err
+	}
+	tree, err := encodeTree(doc.Tree)
+	if err != nil {
+		return "", err
+	}
+	violations, err := encodeViolations(doc.Validation.Violations)
+	if err != nil {
+		return "", err
+	}
+	authors, err := encodeValues(doc.Authors)
+	if err != nil {
+		return "", err
+	}
+	custom, err := encodeCustomFields(doc.Custom)
+	if err != nil {
+		return "", err
+	}
+	paths, err := encodeValues(doc.Paths)
+	if err != nil {
+		return "", err
+	}
+
+	// Store NULL instead of an empty string so documents without expiry never match expiry queries
+	var expiresAt sql.NullString
+	if doc.ExpiresAt != "" {
+		expiresAt = sql.NullString{String: doc.ExpiresAt, Valid: true}
+	}
+	var publishAt sql.NullString
+	if doc.PublishAt != "" {
+		publishAt = sql.NullString{String: doc.PublishAt, Valid: true}
+	}
+	state := doc.State
+	if state == "" {
+		state = DOC_STATE_ACTIVE
+	}
+
+	revision := doc.Revision
+	if revision == 0 {
+		revision = 1
+	}
+
+	var tenant sql.NullString
+	if doc.Tenant != "" {
+		tenant = sql.NullString{String: doc.Tenant, Valid: true}
+	}
+
+	var language sql.NullString
+	if doc.Language != "" {
+		language = sql.NullString{String: doc.Language, Valid: true}
+	}
+	processingLog, err := encodeProcessingLog(doc.ProcessingLog)
+	if err != nil {
+		return "", err
+	}
+
+	var id sql.NullString
+	if doc.ID != "" {
+		id = sql.NullString{String: doc.ID, Valid: true}
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, DB_TABLE_NAME, DB_ID_FIELD_NAME, DB_TITLE_FIELD_NAME, DB_DESCRIPTION_FIELD_NAME, DB_AUTHOR_FIELD_NAME, DB_CREATEDAT_FIELD_NAME, DB_XMLDATA_FIELD_NAME, DB_LANGDATA_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, DB_STATE_FIELD_NAME, DB_PARSERVERSION_FIELD_NAME, DB_CREATEDOFFSET_FIELD_NAME, DB_DATEPROFILE_FIELD_NAME,
+		DB_WORDCOUNT_FIELD_NAME, DB_CHARCOUNT_FIELD_NAME, DB_ELEMENTCOUNT_FIELD_NAME, DB_MAXDEPTH_FIELD_NAME, DB_PREVIEW_FIELD_NAME, DB_TREE_FIELD_NAME, DB_REVISION_FIELD_NAME,
+		DB_VALIDATIONSCHEMA_FIELD_NAME, DB_VALIDATIONSTATUS_FIELD_NAME, DB_VALIDATIONVIOLATIONS_FIELD_NAME, DB_VALIDATEDAT_FIELD_NAME, DB_PROLOG_FIELD_NAME, DB_DOCTYPE_FIELD_NAME, DB_AUTHORS_FIELD_NAME, DB_CUSTOM_FIELD_NAME, DB_CANONICALHASH_FIELD_NAME, DB_PUBLISHAT_FIELD_NAME, DB_XMLPATHS_FIELD_NAME, DB_TENANT_FIELD_NAME, DB_LANGUAGE_FIELD_NAME, DB_PROCESSINGLOG_FIELD_NAME)
+	var docID int64
+	err = withDBRetry(func() error {
+		// The document and the full text of its truncated elements are stored together
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		result, err := tx.Exec(query, id, doc.Title, doc.Description, doc.Author, doc.CreatedAt, strings.Join(doc.XMLData, SPLIT_XMLDATA_STR), langData, expiresAt, state, doc.ParserVersion, doc.CreatedOffset, doc.DateProfile,
+			doc.Stats.Words, doc.Stats.Characters, doc.Stats.Elements, doc.Stats.MaxDepth, doc.Preview, tree, revision,
+			doc.Validation.Schema, doc.Validation.Status, violations, doc.Validation.ValidatedAt, doc.Prolog, doc.Doctype, authors, custom, doc.CanonicalHash, publishAt, paths, tenant, language, processingLog)
+		if err != nil {
+			return err
+		}
+		docID, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := insertOverflow(tx, docID, doc.Overflow); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(docID, 10), nil
+}
+
 func deleteDocumentByID(db *sql.DB, id string) error {
+	defer observeQuery("deleteDocumentByID", time.Now())
+
 	query := fmt.Sprintf(`
 		DELETE FROM %s WHERE %s=?
 	`, DB_TABLE_NAME, DB_ID_FIELD_NAME)
-	_, err := db.Exec(query, id)
-	return err
+	overflowQuery := fmt.Sprintf(`
+		DELETE FROM %s WHERE %s=?
+	`, DB_OVERFLOW_TABLE_NAME, DB_OVERFLOW_DOC_FIELD_NAME)
+	// A link must not serve a later document reusing the ID
+	shareQuery := fmt.Sprintf(`
+		DELETE FROM %s WHERE %s=?
+	`, DB_SHARE_TABLE_NAME, DB_SHARE_DOCUMENT_FIELD_NAME)
+	tagQuery := fmt.Sprintf(`
+		DELETE FROM %s WHERE %s=?
+	`, DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME)
+	annotationQuery := fmt.Sprintf(`
+		DELETE FROM %s WHERE %s=?
+	`, DB_ANNOTATION_TABLE_NAME, DB_ANNOTATION_DOCUMENT_FIELD_NAME)
+	return withDBRetry(func() error {
+		// The hold is checked and the document deleted with everything attached to it in one transaction,
+		// so a hold placed meanwhile isn't missed and no rows are left for a later document with the ID
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Documents under legal hold are kept until the hold is released
+		if err := checkNotHeld(tx, id); err != nil {
+			return err
+		}
+		for _, query := range []string{query, overflowQuery, shareQuery, tagQuery, annotationQuery} {
+			if _, err := tx.Exec(query, id); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// documentColumns lists the columns read for a document, in the order scanDocument expects
+var documentColumns = []string{
+	DB_ID_FIELD_NAME,
+	DB_TITLE_FIELD_NAME,
+	DB_DESCRIPTION_FIELD_NAME,
+	DB_AUTHOR_FIELD_NAME,
+	DB_CREATEDAT_FIELD_NAME,
+	DB_XMLDATA_FIELD_NAME,
+	DB_LANGDATA_FIELD_NAME,
+	DB_EXPIRESAT_FIELD_NAME,
+	DB_STATE_FIELD_NAME,
+	DB_PARSERVERSION_FIELD_NAME,
+	DB_CREATEDOFFSET_FIELD_NAME,
+	DB_DATEPROFILE_FIELD_NAME,
+	DB_WORDCOUNT_FIELD_NAME,
+	DB_CHARCOUNT_FIELD_NAME,
+	DB_ELEMENTCOUNT_FIELD_NAME,
+	DB_MAXDEPTH_FIELD_NAME,
+	DB_PREVIEW_FIELD_NAME,
+	DB_TREE_FIELD_NAME,
+	DB_REVISION_FIELD_NAME,
+	DB_VALIDATIONSCHEMA_FIELD_NAME,
+	DB_VALIDATIONSTATUS_FIELD_NAME,
+	DB_VALIDATIONVIOLATIONS_FIELD_NAME,
+	DB_VALIDATEDAT_FIELD_NAME,
+	DB_PROLOG_FIELD_NAME,
+	DB_DOCTYPE_FIELD_NAME,
+	DB_AUTHORS_FIELD_NAME,
+	DB_CUSTOM_FIELD_NAME,
+	DB_CANONICALHASH_FIELD_NAME,
+	DB_PUBLISHAT_FIELD_NAME,
+	DB_XMLPATHS_FIELD_NAME,
+	DB_TIER_FIELD_NAME,
+	DB_TENANT_FIELD_NAME,
+	DB_LANGUAGE_FIELD_NAME,
+	DB_PROCESSINGLOG_FIELD_NAME,
+	documentTagsColumn,
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDocument reads a document selected with documentColumns
+func scanDocument(row rowScanner) (*XMLDoc, error) {
+	var id, title, description, author, createdAt, xmlDataStr, state string
+	var langData, expiresAt, version, createdOffset, dateProfile, preview, treeData sql.NullString
+	var validationSchema, validationStatus, violationData, validatedAt, prolog, doctype, authorData, customData, canonicalHash, publishAt, pathData, tier, tenant, language, processingData, tagData sql.NullString
+	var stats DocumentStats
+	var revision int
+	err := row.Scan(&id, &title, &description, &author, &createdAt, &xmlDataStr, &langData, &expiresAt, &state, &version, &createdOffset, &dateProfile,
+		&stats.Words, &stats.Characters, &stats.Elements, &stats.MaxDepth, &preview, &treeData, &revision,
+		&validationSchema, &validationStatus, &violationData, &validatedAt, &prolog, &doctype, &authorData, &customData, &canonicalHash, &publishAt, &pathData, &tier, &tenant, &language, &processingData, &tagData)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlData := strings.Split(xmlDataStr, SPLIT_XMLDATA_STR)
+	variants, err := decodeLangVariants(langData.String)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := decodeTree(treeData.String)
+	if err != nil {
+		return nil, err
+	}
+	violations, err := decodeViolations(violationData.String)
+	if err != nil {
+		return nil, err
+	}
+	authors, err := decodeValues(authorData.String)
+	if err != nil {
+		return nil, err
+	}
+	custom, err := decodeCustomFields(customData.String)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := decodeValues(pathData.String)
+	if err != nil {
+		return nil, err
+	}
+	processingLog, err := decodeProcessingLog(processingData.String)
+	if err != nil {
+		return nil, err
+	}
+	// Documents stored before all authors were kept have their first one until they are reprocessed
+	if authors == nil && author != "" {
+		authors = []string{author}
+	}
+	doc := &XMLDoc{
+		ID:            id,
+		Title:         title,
+		Description:   description,
+		Author:        author,
+		Authors:       authors,
+		Custom:        custom,
+		CanonicalHash: canonicalHash.String,
+		CreatedAt:     createdAt,
+		CreatedOffset: createdOffset.String,
+		DateProfile:   dateProfile.String,
+		Stats:         stats,
+		Preview:       preview.String,
+		XMLData:       xmlData,
+		Paths:         paths,
+		Tree:          tree,
+		Revision:      revision,
+		Validation: ValidationResult{
+			Schema:      validationSchema.String,
+			Status:      validationStatus.String,
+			Violations:  violations,
+			ValidatedAt: validatedAt.String,
+		},
+		Prolog:        prolog.String,
+		Doctype:       doctype.String,
+		Variants:      variants,
+		Language:      language.String,
+		ExpiresAt:     expiresAt.String,
+		PublishAt:     publishAt.String,
+		Tier:          tier.String,
+		Tenant:        tenant.String,
+		State:         state,
+		ParserVersion: version.String,
+		Tags:          decodeTags(tagData.String),
+		ProcessingLog: processingLog,
+	}
+	// Cold documents are read back from cold storage, the caller can't tell them from the others
+	if doc.Tier == TIER_COLD {
+		if err := doc.loadColdData(); err != nil {
+			return nil, err
+		}
+	}
+	doc.Declaration, doc.Instructions = parseInstructions(doc.rawXML())
+	return doc, nil
 }
 
 // getDocumentByID retrieves a document from the database by its ID
 func getDocumentByID(db *sql.DB, id string) (*XMLDoc, error) {
+	defer observeQuery("getDocumentByID", time.Now())
+
 	query := fmt.Sprintf(`
-		SELECT %s, %s, %s, %s, %s FROM %s WHERE %s=?
-	`, DB_TITLE_FIELD_NAME, DB_DESCRIPTION_FIELD_NAME, DB_AUTHOR_FIELD_NAME, DB_CREATEDAT_FIELD_NAME, DB_XMLDATA_FIELD_NAME, DB_TABLE_NAME, DB_ID_FIELD_NAME)
-	var title, description, author, createdAt, xmlDataStr string
-	err := db.QueryRow(query, id).Scan(&title, &description, &author, &createdAt, &xmlDataStr)
+		SELECT %s FROM %s WHERE %s=?
+	`, strings.Join(documentColumns, ", "), DB_TABLE_NAME, DB_ID_FIELD_NAME)
+	var doc *XMLDoc
+	err := withDBRetry(func() error {
+		var err error
+		doc, err = scanDocument(db.QueryRow(query, id))
+		return err
+	})
+	return doc, err
+}
+
+// listDocuments retrieves the documents in one of the given states matching filter, a WHERE condition or empty,
+// ordered by order, an ORDER BY clause
+// The placeholders of filter take filterArgs
+// Active documents which are expired at now are left out even before the archiver moves them, and so are
+// documents embargoed until after now
+func listDocuments(db *sql.DB, now time.Time, states []string, filter string, order string, filterArgs ...interface{}) ([]XMLDoc, error) {
+	defer observeQuery("listDocuments", time.Now())
+
+	condition, args := listCondition(now, states, filter, filterArgs)
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s WHERE %s ORDER BY %s
+	`, strings.Join(documentColumns, ", "), DB_TABLE_NAME, condition, order)
+	var docs []XMLDoc
+	err := withDBRetry(func() error {
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		docs = []XMLDoc{}
+		for rows.Next() {
+			doc, err := scanDocument(rows)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, *doc)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
+	return docs, nil
+}
 
-	xmlData := strings.Split(xmlDataStr, SPLIT_XMLDATA_STR)
-	return &XMLDoc{
-		ID:          id,
-		Title:       title,
-		Description: description,
-		Author:      author,
-		CreatedAt:   createdAt,
-		XMLData:     xmlData,
-	}, nil
+// listCondition returns the WHERE condition of listDocuments with the values of its placeholders
+func listCondition(now time.Time, states []string, filter string, filterArgs []interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(states))
+	args := make([]interface{}, 0, len(states)+3+len(filterArgs))
+	for i, state := range states {
+		placeholders[i] = "?"
+		args = append(args, state)
+	}
+	args = append(args, DOC_STATE_ACTIVE, formatExpiry(now), formatExpiry(now))
+	args = append(args, filterArgs...)
+	if filter == "" {
+		filter = "1"
+	}
+	condition := fmt.Sprintf("%s IN (%s) AND (%s!=? OR %s IS NULL OR %s>?) AND %s AND (%s)", DB_STATE_FIELD_NAME, strings.Join(placeholders, ", "),
+		DB_STATE_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, publishedCondition, filter)
+	return condition, args
 }
 
+// dbHandler is the signature of all request handlers, which get the database passed in
+type dbHandler func(db *sql.DB, w http.ResponseWriter, r *http.Request)
+
 func handleRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	access, handler := routeRequest(r)
+	if handler == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	// Successful writes drop the cached responses of the read endpoints
+	if access == ACCESS_WRITE {
+		handler = invalidateOnWrite(handler)
+	}
+
+	// Address rules are checked before any authentication, then maintenance mode and the rate limit
+	// Requests turned away by them aren't metered
+	requireIPPolicy(access, applyRuntimePolicies(access, meterRequests(handler)))(db, w, r)
+}
+
+// routeRequest returns the access an endpoint needs and its handler wrapped with the authentication it requires
+// The handler is nil for unknown paths
+func routeRequest(r *http.Request) (string, dbHandler) {
 	switch r.URL.Path {
 	case "/document":
-		handleDocumentRequest(db, w, r)
+		if r.Method == http.MethodPatch {
+			return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, handlePatchRequest)
+		}
+		// Clients of the first release get its JSON until they move to the current one
+		if legacyAPI {
+			return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, cacheResponses(handleLegacyDocumentRequest))
+		}
+		return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, cacheResponses(handleDocumentRequest))
 	case "/add":
-		handleAddRequest(db, w, r)
+		if legacyAPI {
+			return ACCESS_WRITE, requireAccess(ACCESS_WRITE, nil, idempotentRequests(handleLegacyAddRequest))
+		}
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, nil, idempotentRequests(handleAddRequest))
+	case "/validate":
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, nil, handleValidateRequest)
+	case "/diff":
+		return ACCESS_READ, requireAccess(ACCESS_READ, diffDocumentIDs, cacheResponses(handleDiffRequest))
+	case "/format":
+		// Formatting stores nothing
+		return ACCESS_READ, requireAccess(ACCESS_READ, nil, handleFormatRequest)
+	case "/check":
+		// Checking stores nothing
+		return ACCESS_READ, requireAccess(ACCESS_READ, nil, handleCheckRequest)
 	case "/del":
-		handleDeleteRequest(db, w, r)
-	default:
-		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, handleDeleteRequest)
+	case "/list":
+		return ACCESS_READ, requireAccess(ACCESS_READ, nil, cacheResponses(handleListRequest))
+	case "/overflow":
+		return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, cacheResponses(handleOverflowRequest))
+	case "/query":
+		return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, cacheResponses(handleQueryRequest))
+	case "/element":
+		return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, cacheResponses(handleElementRequest))
+	case "/export":
+		return ACCESS_READ, requireAccess(ACCESS_READ, nil, handleExportRequest)
+	case "/suggest":
+		return ACCESS_READ, requireAccess(ACCESS_READ, nil, cacheResponses(handleSuggestRequest))
+	case "/documents/tags":
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, nil, handleTagRequest)
+	case "/documents/merge":
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, nil, handleMergeRequest)
+	case "/state":
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+			handleStateRequest(db, w, r, r.URL.Query().Get("to"))
+		})
+	case "/archive":
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+			handleStateRequest(db, w, r, DOC_STATE_ARCHIVED)
+		})
+	case "/unarchive":
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+			handleStateRequest(db, w, r, DOC_STATE_ACTIVE)
+		})
+	case "/sign":
+		return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, handleSignRequest)
+	case "/share":
+		if r.Method == http.MethodGet {
+			return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, handleShareRequest)
+		}
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, handleShareRequest)
+	case "/annotations":
+		if r.Method == http.MethodGet {
+			return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, handleAnnotationsRequest)
+		}
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, handleAnnotationsRequest)
+	case "/token":
+		return ACCESS_WRITE, requireAPIKey(handleMintTokenRequest)
+	case "/token/revoke":
+		return ACCESS_WRITE, requireAPIKey(handleRevokeTokenRequest)
+	case "/admin/auth-failures":
+		return ACCESS_READ, requireAPIKey(handleAuthFailuresRequest)
+	case "/admin/config":
+		if r.Method == http.MethodGet {
+			return ACCESS_READ, requireAPIKey(handleConfigRequest)
+		}
+		return ACCESS_WRITE, requireAPIKey(handleConfigRequest)
+	case "/admin/flags":
+		if r.Method == http.MethodGet {
+			return ACCESS_READ, requireAPIKey(handleFlagsRequest)
+		}
+		return ACCESS_WRITE, requireAPIKey(handleFlagsRequest)
+	case "/admin/tenants":
+		if r.Method == http.MethodGet {
+			return ACCESS_READ, requireAPIKey(handleTenantsRequest)
+		}
+		return ACCESS_WRITE, requireAPIKey(handleTenantsRequest)
+	case "/admin/usage":
+		return ACCESS_READ, requireAPIKey(handleUsageRequest)
+	case "/admin/storage":
+		return ACCESS_READ, requireAPIKey(handleStorageRequest)
+	case "/admin/holds":
+		if r.Method == http.MethodGet {
+			return ACCESS_READ, requireAPIKey(handleHoldsRequest)
+		}
+		return ACCESS_WRITE, requireAPIKey(handleHoldsRequest)
+	case "/admin/holds/audit":
+		return ACCESS_READ, requireAPIKey(handleHoldAuditRequest)
+	case "/admin/reprocess":
+		return ACCESS_WRITE, requireAPIKey(handleReprocessRequest)
+	case "/metrics":
+		return ACCESS_READ, requireAPIKey(handleMetricsRequest)
+	case "/auth/login":
+		return ACCESS_READ, handleLoginRequest
+	case "/auth/callback":
+		return ACCESS_READ, handleCallbackRequest
+	case "/auth/logout":
+		return ACCESS_READ, handleLogoutRequest
+	case "/auth/session":
+		return ACCESS_READ, handleSessionRequest
+	case "/sources":
+		return ACCESS_READ, requireAPIKey(handleSourcesRequest)
+	}
+
+	if _, ok := rawDocumentID(r.URL.Path); ok {
+		return ACCESS_READ, requireSignedURL(handleRawRequest)
+	}
+	if _, ok := publicToken(r.URL.Path); ok {
+		return ACCESS_READ, handlePublicRequest
+	}
+	if _, ok := sourceStatsID(r.URL.Path); ok {
+		return ACCESS_READ, requireAPIKey(handleSourceStatsRequest)
 	}
+	if id, ok := serializeDocumentID(r.URL.Path); ok {
+		// Tokens scoped to a document check the id parameter
+		query := r.URL.Query()
+		query.Set("id", id)
+		r.URL.RawQuery = query.Encode()
+		return ACCESS_READ, requireAccess(ACCESS_READ, documentParam, cacheResponses(handleSerializeRequest))
+	}
+	if id, ok := revalidateDocumentID(r.URL.Path); ok {
+		// Tokens scoped to a document check the id parameter
+		query := r.URL.Query()
+		query.Set("id", id)
+		r.URL.RawQuery = query.Encode()
+		return ACCESS_WRITE, requireAccess(ACCESS_WRITE, documentParam, handleRevalidateRequest)
+	}
+	return "", nil
 }
 
 func handleDocumentRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
@@ -296,12 +1240,61 @@ func handleDocumentRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	doc, err := getDocumentByID(db, id)
+	loc, err := parseTimeZone(r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := parseXMLDataMode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order, err := parseXMLDataOrder(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	doc, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+
+	// Registered stylesheets turn the document into another format, e.g. ?transform=summary
+	if name := r.URL.Query().Get("transform"); name != "" {
+		transformDocument(w, doc, name)
+		return
+	}
+	renderCreatedAt(doc, loc)
+	if mode == XMLDATA_MODE_FLAT {
+		if err := orderXMLData(doc, order); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to order the elements of document %s: %v", id, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := shapeXMLData(doc, mode); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to build the element tree of document %s: %v", id, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Tenants trying the flat tree format get the elements with their path instead of the nested tree
+	if doc.Tree != nil && requestFeature(db, r, FEATURE_FLAT_TREE) {
+		doc.Elements = doc.Tree.flatten()
+		doc.Tree = nil
+	}
+
+	// Pick the metadata variants matching the client's preferred languages
+	varyOn(w, "Accept-Language")
+	lang := localizeDocument(doc, r.Header.Get("Accept-Language"))
+	if lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
+
 	// Convert to JSON and send response
 	response, err := json.Marshal(doc)
 	if err != nil {
@@ -315,24 +1308,192 @@ func handleDocumentRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 }
 
 func handleAddRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
-	// Parse request body
-	xmlData, err := ioutil.ReadAll(r.Body)
-	if err != nil {
+	// Parse request body, which isn't read past the size limit
+	body := r.Body
+	if parseLimits.MaxInputBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, int64(parseLimits.MaxInputBytes))
+	}
+	xmlData, err := ioutil.ReadAll(body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, fmt.Sprintf("Failed to read request body: %v: document larger than %d bytes", ErrParseLimit, maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
 
-	// Parse XML data into XMLDoc struct
-	doc, err := parseDocument(string(xmlData))
+	// Interactive submissions go first unless the client asks for a lower priority, e.g. for back-fills
+	priority := INGEST_PRIORITY_HIGH
+	if param := r.URL.Query().Get("priority"); param != "" {
+		if !isValidPriority(param) {
+			http.Error(w, fmt.Sprintf("Invalid priority %s", param), http.StatusBadRequest)
+			return
+		}
+		priority = param
+	}
+
+	// Feeds with broken tags can be accepted as well as possible, e.g. ?lenient=true
+	// The default is the lenient_parsing runtime setting
+	options := ParseOptions{Lenient: lenientByDefault.Load()}
+	if param := r.URL.Query().Get("lenient"); param != "" {
+		options.Lenient, err = strconv.ParseBool(param)
+		if err != nil {
+			http.Error(w, "lenient must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	// HTML fragments are turned into XML, e.g. ?html=true
+	if param := r.URL.Query().Get("html"); param != "" {
+		options.HTML, err = strconv.ParseBool(param)
+		if err != nil {
+			http.Error(w, "html must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	// Metadata snippets without a single root element are wrapped in one, e.g. ?fragment=true
+	if param := r.URL.Query().Get("fragment"); param != "" {
+		options.Fragment, err = strconv.ParseBool(param)
+		if err != nil {
+			http.Error(w, "fragment must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	// Pre-formatted text can be kept or tidied up, e.g. ?whitespace=preserve
+	if param := r.URL.Query().Get("whitespace"); param != "" {
+		if !isValidWhitespaceMode(param) {
+			http.Error(w, "whitespace must be strip, preserve, trim or collapse", http.StatusBadRequest)
+			return
+		}
+		options.Whitespace = param
+	}
+	// Comments can be kept in the element tree, e.g. ?comments=true
+	if param := r.URL.Query().Get("comments"); param != "" {
+		options.Comments, err = strconv.ParseBool(param)
+		if err != nil {
+			http.Error(w, "comments must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	// Documents sent ahead of their release are embargoed until then, e.g. ?publish_at=2024-07-09T08:00:00Z
+	// The parameter overrides the publish attribute of the root element.
+	publishAt := ""
+	if param := r.URL.Query().Get("publish_at"); param != "" {
+		publishAt, err = parsePublishAt(param)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Parse XML data into XMLDoc struct and insert it into database on an ingestion worker
+	var parseErr, insertErr error
+	var parseError *ParseError
+	var schemaError *SchemaError
+	var doc *XMLDoc
+	var id, duplicateOf string
+	source := requestSource(db, r)
+
+	// Tenants may have their own field mappings, schemas, quota and webhook
+	tenant, err := tenantConfigFor(db, source)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse document: %v", err), http.StatusInternalServerError)
+		httpStoreError(w, fmt.Sprintf("Failed to load configuration of tenant %s: %v", source, err), err)
 		return
 	}
+	if !checkTenantQuota(db, w, tenant, time.Now()) {
+		return
+	}
+	options = tenant.ParseOptions(options)
 
-	// Insert document into database
-	err = insertDocument(db, *doc)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to insert document into database: %v", err), http.StatusInternalServerError)
+	err = ingestQueue.Do(priority, func() {
+		doc, parseErr = parseDocumentFromWithOptions(string(xmlData), "http:"+clientIP(r).String(), options)
+		if parseErr == nil {
+			parseErr = validateXSD(doc)
+		}
+		if parseErr != nil {
+			trackIngest(db, source, len(xmlData), parseErr)
+			return
+		}
+		if publishAt != "" {
+			doc.PublishAt = publishAt
+		}
+		doc.Tenant = source
+		// Documents sent again, e.g. by a retrying feed, are turned away like requests over the rate limit
+		if doc.CanonicalHash != "" {
+			duplicateOf, insertErr = findDuplicate(db, doc.CanonicalHash)
+			if insertErr == nil || !errors.Is(insertErr, sql.ErrNoRows) {
+				return
+			}
+		}
+		id, insertErr = addDocument(db, *doc)
+		trackIngest(db, source, len(xmlData), insertErr)
+		if insertErr == nil {
+			usageMeter.Add(source, UsageCounts{Documents: 1, Bytes: int64(len(xmlData))}, time.Now())
+		}
+		if insertErr == nil && tenant.Quota > 0 {
+			if err := recordTenantUsage(db, source, time.Now()); err != nil {
+				log.Printf("handleAddRequest: Failed to count document of %s: %v", source, err)
+			}
+		}
+	})
+	if errors.Is(err, ErrQueueFull) {
+		// Tell the producer when the backlog should be worked off
+		w.Header().Set("Retry-After", strconv.Itoa(ingestQueue.RetryAfter()))
+		http.Error(w, "Too many documents waiting to be ingested", http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to queue document: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if errors.Is(parseErr, ErrNotXML) || errors.Is(parseErr, ErrUnsupportedEncoding) {
+		http.Error(w, fmt.Sprintf("Failed to parse document: %v", parseErr), http.StatusUnsupportedMediaType)
+		return
+	} else if errors.Is(parseErr, ErrParseLimit) {
+		http.Error(w, fmt.Sprintf("Failed to parse document: %v", parseErr), http.StatusRequestEntityTooLarge)
+		return
+	} else if errors.Is(parseErr, ErrTextTooLong) {
+		http.Error(w, fmt.Sprintf("Failed to parse document: %v", parseErr), http.StatusUnprocessableEntity)
+		return
+	} else if errors.As(parseErr, &parseError) {
+		httpParseError(w, "Failed to parse document", parseError)
+		return
+	} else if errors.As(parseErr, &schemaError) {
+		httpSchemaError(w, "Document doesn't conform to the XSD", schemaError)
+		return
+	} else if parseErr != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse document: %v", parseErr), http.StatusInternalServerError)
+		return
+	}
+	if duplicateOf != "" {
+		http.Error(w, fmt.Sprintf("Document is a duplicate of document %s", duplicateOf), http.StatusConflict)
+		return
+	}
+	if errors.Is(insertErr, ErrDBUnavailable) {
+		// The database is saturated, ask the producer to wait at least until the backlog is worked off
+		retryAfter := ingestQueue.RetryAfter()
+		if retryAfter < DB_UNAVAILABLE_RETRY_AFTER {
+			retryAfter = DB_UNAVAILABLE_RETRY_AFTER
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, fmt.Sprintf("Failed to insert document into database: %v", insertErr), http.StatusServiceUnavailable)
+		return
+	} else if insertErr != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to insert document into database: %v", insertErr), insertErr)
+		return
+	}
+
+	tenantNotifier.Post(tenant, TenantEvent{Event: TENANT_EVENT_DOCUMENT_ADDED, Tenant: source, ID: id, Title: doc.Title, At: formatExpiry(time.Now())})
+
+	// Lenient parses tell the client what was repaired
+	if options.Lenient || options.HTML {
+		response, err := json.Marshal(AddResponse{ID: id, Warnings: doc.Warnings})
+		if err != nil {
+			http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(response)
 		return
 	}
 
@@ -347,27 +1508,191 @@ func handleDeleteRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 
 	err := deleteDocumentByID(db, id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete document with ID %s: %v", id, err), http.StatusInternalServerError)
+	if errors.Is(err, ErrLegalHold) {
+		http.Error(w, fmt.Sprintf("Failed to delete document with ID %s: %v", id, err), http.StatusConflict)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to delete document with ID %s: %v", id, err), err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func main() {
-	docDB, err := sql.Open("sqlite3", "./documents.db")
+// documentSelection is the documents selected by the state, sort and filter parameters of /list and /export
+type documentSelection struct {
+	States     []string
+	Filter     string        // Filter is the condition of a WHERE clause, empty for all documents
+	FilterArgs []interface{} // FilterArgs are the values of the placeholders of Filter
+	Order      string        // Order is the ORDER BY clause
+}
+
+// parseDocumentSelection reads the documents selected by the parameters of a request
+func parseDocumentSelection(r *http.Request) (documentSelection, error) {
+	// Only active documents are listed unless other states are requested, e.g. ?state=archived,quarantined
+	selection := documentSelection{States: []string{DOC_STATE_ACTIVE}}
+	if param := r.URL.Query().Get("state"); param != "" {
+		selection.States = strings.Split(param, ",")
+		for _, state := range selection.States {
+			if !isValidState(state) {
+				return selection, fmt.Errorf("Invalid state %s", state)
+			}
+		}
+	}
+
+	// Documents are ordered by ID unless another order is requested, e.g. ?sort=-words
+	var err error
+	selection.Order, err = parseDocumentSort(r.URL.Query().Get("sort"))
 	if err != nil {
-		log.Fatal("Failed to open database", err)
+		return selection, err
 	}
-	defer docDB.Close()
 
-	initDB(docDB)
+	// Documents may be filtered by the result of their validation, e.g. ?validation=failed
+	selection.Filter, err = parseValidationFilter(r.URL.Query().Get("validation"))
+	if err != nil {
+		return selection, err
+	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		handleRequest(docDB, w, r)
-	})
+	// Documents may be filtered by the root element name of their DOCTYPE, e.g. ?doctype=html
+	if doctype := r.URL.Query().Get("doctype"); doctype != "" {
+		if selection.Filter != "" {
+			selection.Filter += " AND "
+		}
+		selection.Filter += DB_DOCTYPE_FIELD_NAME + "=?"
+		selection.FilterArgs = append(selection.FilterArgs, doctype)
+	}
 
-	log.Println("Server listening on :3456")
-	log.Fatal(http.ListenAndServe(":3456", nil))
+	// Documents may be filtered by their language, e.g. ?lang=en for English documents, British ones included
+	if tag := r.URL.Query().Get(LANGUAGE_PARAM); tag != "" {
+		condition, args, err := languageFilter(tag)
+		if err != nil {
+			return selection, err
+		}
+		if selection.Filter != "" {
+			selection.Filter += " AND "
+		}
+		selection.Filter += condition
+		selection.FilterArgs = append(selection.FilterArgs, args...)
+	}
+
+	// Documents may be filtered by a tag, e.g. ?tag=invoices-2023
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		if selection.Filter != "" {
+			selection.Filter += " AND "
+		}
+		selection.Filter += tagFilter
+		selection.FilterArgs = append(selection.FilterArgs, tag)
+	}
+	return selection, nil
+}
+
+// List returns the selected documents which are not expired at now
+func (selection documentSelection) List(db *sql.DB, now time.Time) ([]XMLDoc, error) {
+	return listDocuments(db, now, selection.States, selection.Filter, selection.Order, selection.FilterArgs...)
+}
+
+func handleListRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	selection, err := parseDocumentSelection(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	loc, err := parseTimeZone(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The summary view leaves out the element tree so clients can show previews of many documents cheaply
+	view := r.URL.Query().Get("view")
+	if view != "" && view != LIST_VIEW_SUMMARY {
+		http.Error(w, fmt.Sprintf("Invalid view %s", view), http.StatusBadRequest)
+		return
+	}
+	mode, err := parseXMLDataMode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	order, err := parseXMLDataOrder(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if view == LIST_VIEW_SUMMARY {
+		mode = XMLDATA_MODE_NONE
+	}
+
+	// Filter sidebars can be rendered from facet counts of the listed documents, e.g. ?facets=author,year
+	var facets []string
+	if param := r.URL.Query().Get("facets"); param != "" {
+		facets, err = parseFacets(param)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	docs, err := selection.List(db, time.Now())
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to list documents: %v", err), err)
+		return
+	}
+	for i := range docs {
+		renderCreatedAt(&docs[i], loc)
+		if mode == XMLDATA_MODE_FLAT {
+			if err := orderXMLData(&docs[i], order); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to order the elements of document %s: %v", docs[i].ID, err), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := shapeXMLData(&docs[i], mode); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build the element tree of document %s: %v", docs[i].ID, err), http.StatusInternalServerError)
+			return
+		}
+		// The element tree is only served by /document
+		docs[i].Tree = nil
+	}
+
+	// Convert to JSON and send response, the documents are wrapped with their facets if there are any
+	var result interface{} = docs
+	if facets != nil {
+		result = ListResponse{Documents: docs, Facets: countFacets(docs, facets)}
+	}
+	response, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+func handleStateRequest(db *sql.DB, w http.ResponseWriter, r *http.Request, to string) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+	if !isValidState(to) {
+		http.Error(w, fmt.Sprintf("Invalid state %s", to), http.StatusBadRequest)
+		return
+	}
+
+	err := transitionDocument(db, id, to)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrLegalHold) {
+		http.Error(w, fmt.Sprintf("Failed to change state of document with ID %s: %v", id, err), http.StatusConflict)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to change state of document with ID %s: %v", id, err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
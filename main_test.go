@@ -1,4 +1,4 @@
-package main
+package goapp
 
 import (
 	"database/sql"
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -76,10 +77,18 @@ func TestParseXML(t *testing.T) {
 				"<creationDate>2024-07-09</creationDate>",
 			},
 			err: nil,
+		}, {
+			desc:             "greater-than sign in text",
+			msg:              `<note to="a">x > y</note>`,
+			expectedResponse: []string{`<note to="a">x > y</note>`},
+		}, {
+			desc:             "angle brackets in attribute values",
+			msg:              `<note title='x < y'><ref to="<b>" alt="a > b">b</ref></note>`,
+			expectedResponse: []string{`<note title='x < y'><ref to="<b>" alt="a > b">b</ref></note>`, `<ref to="<b>" alt="a > b">b</ref>`},
 		}, {
 			desc: "invalid pairing",
 			msg:  `<document><title</description></document>`,
-			err:  errors.New("tag pairing error"),
+			err:  &ParseError{Line: 1, Col: 17, Msg: "tag pairing error", Snippet: "<document><title</description></document>"},
 		}, {
 			desc: "no opening tag",
 			msg: `</document>
@@ -88,7 +97,7 @@ func TestParseXML(t *testing.T) {
 			<author>Test Author</author>
 			<creationDate>2024-07-09</creationDate>
 		</document>`,
-			err: errors.New("no opening tag error: no opening tag"),
+			err: &ParseError{Line: 1, Col: 1, Msg: "no opening tag error: no opening tag", Snippet: "</document>"},
 		}, {
 			desc: "unmatched closing tag error",
 			msg: `<document>
@@ -97,7 +106,7 @@ func TestParseXML(t *testing.T) {
 			<author>Test Author</author>
 			<creationDate>2024-07-09</creationDate>
 		</document>`,
-			err: errors.New("unmatched closing tag error: <document> </title>"),
+			err: &ParseError{Line: 2, Col: 14, Msg: "unmatched closing tag error: <document> </title>", Snippet: "\t\t\tTest Title</title>"},
 		},
 	}
 	for _, tt := range tests {
@@ -113,6 +122,71 @@ func TestParseXML(t *testing.T) {
 	}
 }
 
+// benchmarkXML returns a document of records with nested elements and attributes, about 200 bytes per record
+func benchmarkXML(records int) string {
+	var data strings.Builder
+	data.WriteString("<document><title>Benchmark</title><records>")
+	for i := 0; i < records; i++ {
+		data.WriteString(`<record id="` + strconv.Itoa(i) + `" status="open"><name>Record</name><amount currency="EUR">12.50</amount><note>Fish &amp; Chips, delivered &gt; 5 days</note></record>`)
+	}
+	data.WriteString("</records></document>")
+	return data.String()
+}
+
+// Benchmark scanning the tags of a large document, which should allocate little more than the tags
+func BenchmarkScanXMLTags(b *testing.B) {
+	data := benchmarkXML(50000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := scanXMLTags(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark parsing a large document into XMLData
+func BenchmarkParseXML(b *testing.B) {
+	data := benchmarkXML(50000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseXML(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Test parsing documents whose attribute values hold '<' and '>', which used to end their tags
+func TestParseDocumentQuotedBrackets(t *testing.T) {
+	data := `<document><title lang="en">a > b</title><figure><img alt="a > b" src='<none>'/></figure><author note="<x>">Zoe</author></document>`
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "a > b", doc.Title)
+	require.Equal(t, "Zoe", doc.Author)
+	require.Equal(t, DocumentStats{Words: 4, Characters: 9, Elements: 5, MaxDepth: 3}, doc.Stats)
+	require.Equal(t, `<document><title lang="en">a > b</title><figure><img alt="a > b" src='<none>'/></figure><author note="<x>">Zoe</author></document>`,
+		normalizeWhitespace(data, WHITESPACE_STRIP, false))
+
+	root, err := ParseTree(strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, "a > b", root.Children[1].Children[0].Attrs["alt"])
+
+	_, err = parseDocument(`<document><title alt="a > b>Test</title></document>`)
+	require.EqualError(t, err, "unterminated attribute value at line 1, column 11")
+}
+
+// Test that metadata elements with child elements give their text, not their markup
+func TestParseDocumentMixedContent(t *testing.T) {
+	doc, err := parseDocument(`<document><title><i>Fish</i> &amp; Chips</title><title xml:lang="fr">Poisson <i>frit</i></title><description>The <b>quick</b> fox</description></document>`)
+	require.NoError(t, err)
+	require.Equal(t, "Fish & Chips", doc.Title)
+	require.Equal(t, "The quick fox", doc.Description)
+	require.Equal(t, []LangVariant{{Field: "title", Lang: "fr", Value: "Poisson frit"}}, doc.Variants)
+	require.Equal(t, "The ", doc.Tree.Children[2].Text)
+	require.Equal(t, " fox", doc.Tree.Children[2].Children[0].Tail)
+}
+
 // Test the document parsing function with valid data
 func TestParseDocument(t *testing.T) {
 	tests := []struct {
@@ -133,6 +207,7 @@ func TestParseDocument(t *testing.T) {
 				Title:       "Test Title",
 				Description: "Test Description",
 				Author:      "Test Author",
+				Authors:     []string{"Test Author"},
 				CreatedAt:   "2024-07-09",
 				XMLData: []string{
 					"<document><title>Test Title</title><description>Test Description</description><author>Test Author</author><creationDate>2024-07-09</creationDate></document>",
@@ -141,6 +216,21 @@ func TestParseDocument(t *testing.T) {
 					"<author>Test Author</author>",
 					"<creationDate>2024-07-09</creationDate>",
 				},
+				Paths:         []string{"/document", "/document/title[1]", "/document/description[1]", "/document/author[1]", "/document/creationDate[1]"},
+				ParserVersion: parserVersion,
+				CanonicalHash: canonicalHash("<document><title>Test Title</title><description>Test Description</description><author>Test Author</author><creationDate>2024-07-09</creationDate></document>"),
+				Stats:         DocumentStats{Words: 7, Characters: 50, Elements: 5, MaxDepth: 2},
+				Preview:       "Test Description",
+				Tree: func() *Node {
+					root := &Node{Name: "document", Children: []*Node{
+						{Name: "title", Text: "Test Title"},
+						{Name: "description", Text: "Test Description"},
+						{Name: "author", Text: "Test Author"},
+						{Name: "creationDate", Text: "2024-07-09"},
+					}}
+					root.setParents()
+					return root
+				}(),
 			},
 			err: nil,
 		}, {
@@ -329,6 +419,22 @@ func TestHandleDeleteRequest(t *testing.T) {
 	}
 }
 
+// Test that a failed deletion leaves the document with everything attached to it
+func TestDeleteDocumentRollsBack(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Test Title"}))
+	require.NoError(t, setDocumentTags(db, "1", []string{"case-1234"}))
+	_, err := db.Exec("DROP TABLE " + DB_ANNOTATION_TABLE_NAME)
+	require.NoError(t, err)
+
+	require.Error(t, deleteDocumentByID(db, "1"))
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"case-1234"}, doc.Tags)
+}
+
 // Test handling an invalid path
 func TestHandleRequestInvalidPath(t *testing.T) {
 	db, cleanup := setupTestDB(t)
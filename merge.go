@@ -0,0 +1,242 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	MERGE_STRATEGY_UNION  = "union"  // Merge strategy keeping every distinct element of all documents
+	MERGE_STRATEGY_NEWEST = "newest" // Merge strategy keeping the element of the newest document at each path
+
+	MERGE_MIN_DOCUMENTS = 2 // Number of documents needed for a merge
+)
+
+// mergeNode is an element of a document being merged
+type mergeNode struct {
+	Name     string
+	Attrs    string // Attrs is the raw attribute string of the start tag
+	Text     string // Text is the decoded text of the element, text between children included
+	Children []*mergeNode
+}
+
+// xmlEscaper escapes text written into merged documents
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// splitTag splits a start tag like `<tag attr="x">` into its name and raw attributes
+func splitTag(tag string) (name string, attrs string) {
+	open := strings.TrimSuffix(strings.TrimSuffix(tag[1:], ">"), "/")
+	name = open
+	if i := strings.IndexAny(open, " \t\r\n"); i >= 0 {
+		name, attrs = open[:i], strings.TrimSpace(open[i+1:])
+	}
+	return name, attrs
+}
+
+// buildMergeTree builds the element tree of the XML of a document
+func buildMergeTree(data string) (*mergeNode, error) {
+	root := &mergeNode{}
+	stack := []*mergeNode{root}
+	scanXML(data, func(tag string) {
+		switch {
+		case strings.HasPrefix(tag, "</"):
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case strings.HasPrefix(tag, "<?"), strings.HasPrefix(tag, "<!"):
+		default:
+			name, attrs := splitTag(tag)
+			node := &mergeNode{Name: name, Attrs: attrs}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+			if !strings.HasSuffix(tag, "/>") {
+				stack = append(stack, node)
+			}
+		}
+	}, func(text string) {
+		node := stack[len(stack)-1]
+		node.Text += strings.TrimSpace(text)
+	})
+	if len(root.Children) != 1 {
+		return nil, errors.New("document has no single root element")
+	}
+	return root.Children[0], nil
+}
+
+// String writes the node and its children as XML
+func (node *mergeNode) String() string {
+	var result strings.Builder
+	node.write(&result)
+	return result.String()
+}
+
+func (node *mergeNode) write(out *strings.Builder) {
+	out.WriteString("<" + node.Name)
+	if node.Attrs != "" {
+		out.WriteString(" " + node.Attrs)
+	}
+	out.WriteString(">")
+	out.WriteString(xmlEscaper.Replace(node.Text))
+	for _, child := range node.Children {
+		child.write(out)
+	}
+	out.WriteString("</" + node.Name + ">")
+}
+
+// childPaths keys the children of node by name and occurrence, e.g. "item[1]" for the second <item>
+func (node *mergeNode) childPaths() map[string]*mergeNode {
+	paths := map[string]*mergeNode{}
+	occurrences := map[string]int{}
+	for _, child := range node.Children {
+		paths[fmt.Sprintf("%s[%d]", child.Name, occurrences[child.Name])] = child
+		occurrences[child.Name]++
+	}
+	return paths
+}
+
+// mergeNodes merges src into dst, which both are at the same path
+// With the union strategy src adds its children which differ from those of dst, with the newest
+// strategy src is newer and its text, attributes and children replace those of dst at the same path.
+func mergeNodes(dst *mergeNode, src *mergeNode, strategy string) {
+	if strategy == MERGE_STRATEGY_NEWEST {
+		if src.Text != "" {
+			dst.Text = src.Text
+		}
+		if src.Attrs != "" {
+			dst.Attrs = src.Attrs
+		}
+	} else if dst.Text == "" {
+		dst.Text = src.Text
+	}
+
+	paths := dst.childPaths()
+	occurrences := map[string]int{}
+	for _, child := range src.Children {
+		path := fmt.Sprintf("%s[%d]", child.Name, occurrences[child.Name])
+		occurrences[child.Name]++
+
+		existing, ok := paths[path]
+		switch {
+		case !ok:
+			dst.Children = append(dst.Children, child)
+		case len(existing.Children) > 0 || len(child.Children) > 0:
+			mergeNodes(existing, child, strategy)
+		case strategy == MERGE_STRATEGY_NEWEST:
+			*existing = *child
+		case existing.Text != child.Text || existing.Attrs != child.Attrs:
+			// Differing values at the same path are both kept by the union
+			dst.Children = append(dst.Children, child)
+		}
+	}
+}
+
+// mergeDocuments merges documents into the XML of a new document
+// Documents are merged from the oldest to the newest by creation date, then by ID
+func mergeDocuments(docs []*XMLDoc, strategy string) (string, error) {
+	sorted := append([]*XMLDoc{}, docs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].CreatedAt != sorted[j].CreatedAt {
+			return sorted[i].CreatedAt < sorted[j].CreatedAt
+		}
+		return len(sorted[i].ID) < len(sorted[j].ID) || (len(sorted[i].ID) == len(sorted[j].ID) && sorted[i].ID < sorted[j].ID)
+	})
+
+	var merged *mergeNode
+	for _, doc := range sorted {
+		// The first element of XMLData is the outermost element holding the whole document
+		if len(doc.XMLData) == 0 {
+			return "", fmt.Errorf("document %s has no XML", doc.ID)
+		}
+		tree, err := buildMergeTree(doc.XMLData[0])
+		if err != nil {
+			return "", fmt.Errorf("document %s: %w", doc.ID, err)
+		}
+		if merged == nil {
+			merged = tree
+			continue
+		}
+		if tree.Name != merged.Name {
+			return "", fmt.Errorf("document %s has root <%s> instead of <%s>", doc.ID, tree.Name, merged.Name)
+		}
+		mergeNodes(merged, tree, strategy)
+	}
+	return merged.String(), nil
+}
+
+// handleMergeRequest merges documents into a new document and returns it
+func handleMergeRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) < MERGE_MIN_DOCUMENTS || ids[0] == "" {
+		http.Error(w, fmt.Sprintf("ids parameter needs at least %d document IDs", MERGE_MIN_DOCUMENTS), http.StatusBadRequest)
+		return
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			http.Error(w, fmt.Sprintf("Document ID %s is given more than once", id), http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+	strategy := r.URL.Query().Get("strategy")
+	if strategy == "" {
+		strategy = MERGE_STRATEGY_UNION
+	}
+	if strategy != MERGE_STRATEGY_UNION && strategy != MERGE_STRATEGY_NEWEST {
+		http.Error(w, fmt.Sprintf("Invalid strategy %s", strategy), http.StatusBadRequest)
+		return
+	}
+
+	var docs []*XMLDoc
+	for _, id := range ids {
+		// Embargoed documents can't be read through a merge before their publication time
+		doc, err := getPublishedDocumentByID(db, id, time.Now())
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+			return
+		}
+		docs = append(docs, doc)
+	}
+
+	data, err := mergeDocuments(docs, strategy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to merge documents: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	doc, err := parseDocumentFromWithOptions(data, "merge:"+strings.Join(ids, ","), storedParseOptions)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse merged document: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	doc.ID, err = addDocument(db, *doc)
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to insert merged document into database: %v", err), err)
+		return
+	}
+	doc.State = DOC_STATE_ACTIVE
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(doc)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
@@ -0,0 +1,141 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test merging documents with each strategy
+func TestMergeDocuments(t *testing.T) {
+	older := &XMLDoc{ID: "2", CreatedAt: "2024-01-01", XMLData: []string{
+		`<document><title>Old Title</title><price currency="EUR">10</price><item>a</item></document>`,
+	}}
+	newer := &XMLDoc{ID: "10", CreatedAt: "2024-02-01", XMLData: []string{
+		`<document><title>New Title</title><price currency="USD">12</price><item>a</item><item>b</item><stock>5</stock></document>`,
+	}}
+	tests := []struct {
+		desc     string
+		docs     []*XMLDoc
+		strategy string
+		expected string
+	}{
+		{
+			desc:     "union",
+			docs:     []*XMLDoc{newer, older},
+			strategy: MERGE_STRATEGY_UNION,
+			expected: `<document><title>Old Title</title><price currency="EUR">10</price><item>a</item><title>New Title</title><price currency="USD">12</price><item>b</item><stock>5</stock></document>`,
+		},
+		{
+			desc:     "newest",
+			docs:     []*XMLDoc{newer, older},
+			strategy: MERGE_STRATEGY_NEWEST,
+			expected: `<document><title>New Title</title><price currency="USD">12</price><item>a</item><item>b</item><stock>5</stock></document>`,
+		},
+		{
+			desc: "newest by ID on equal dates",
+			docs: []*XMLDoc{
+				{ID: "10", CreatedAt: "2024-01-01", XMLData: []string{`<document><title>Ten</title></document>`}},
+				{ID: "9", CreatedAt: "2024-01-01", XMLData: []string{`<document><title>Nine</title></document>`}},
+			},
+			strategy: MERGE_STRATEGY_NEWEST,
+			expected: `<document><title>Ten</title></document>`,
+		},
+		{
+			desc: "nested elements",
+			docs: []*XMLDoc{
+				{ID: "1", XMLData: []string{`<document><item><name>x</name></item></document>`}},
+				{ID: "2", XMLData: []string{`<document><item><name>y</name><size>L</size></item></document>`}},
+			},
+			strategy: MERGE_STRATEGY_NEWEST,
+			expected: `<document><item><name>y</name><size>L</size></item></document>`,
+		},
+		{
+			desc: "text is escaped",
+			docs: []*XMLDoc{
+				{ID: "1", XMLData: []string{`<document><title>Fish &amp; Chips</title></document>`}},
+				{ID: "2", XMLData: []string{`<document><note><![CDATA[<b>]]></note></document>`}},
+			},
+			strategy: MERGE_STRATEGY_UNION,
+			expected: `<document><title>Fish &amp; Chips</title><note>&lt;b&gt;</note></document>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			data, err := mergeDocuments(tt.docs, tt.strategy)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, data)
+		})
+	}
+}
+
+// Test that documents with different root elements aren't merged
+func TestMergeDocumentsDifferentRoots(t *testing.T) {
+	_, err := mergeDocuments([]*XMLDoc{
+		{ID: "1", XMLData: []string{`<document><title>a</title></document>`}},
+		{ID: "2", XMLData: []string{`<catalog><title>b</title></catalog>`}},
+	}, MERGE_STRATEGY_UNION)
+	require.EqualError(t, err, "document 2 has root <catalog> instead of <document>")
+}
+
+// Test merging stored documents into a new document through /documents/merge
+func TestHandleMergeRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		"<document><title>Test Title</title><author>John Doe</author><creationDate>2024-01-01</creationDate></document>",
+		"<document><title>New Title</title><description>Test Description</description><creationDate>2024-02-01</creationDate></document>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	tests := []struct {
+		desc   string
+		method string
+		query  string
+		status int
+	}{
+		{desc: "wrong method", method: "GET", query: "ids=1,2", status: http.StatusMethodNotAllowed},
+		{desc: "single id", method: "POST", query: "ids=1", status: http.StatusBadRequest},
+		{desc: "missing ids", method: "POST", query: "", status: http.StatusBadRequest},
+		{desc: "duplicate id", method: "POST", query: "ids=1,2,1", status: http.StatusBadRequest},
+		{desc: "invalid strategy", method: "POST", query: "ids=1,2&strategy=oldest", status: http.StatusBadRequest},
+		{desc: "unknown document", method: "POST", query: "ids=1,99", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/documents/merge?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleMergeRequest(db, w, req)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+		})
+	}
+
+	req := httptest.NewRequest("POST", "/documents/merge?ids=2,1&strategy=newest", nil)
+	w := httptest.NewRecorder()
+	handleMergeRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	var merged XMLDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merged))
+	require.Equal(t, "3", merged.ID)
+	require.Equal(t, "New Title", merged.Title)
+	require.Equal(t, "Test Description", merged.Description)
+	require.Equal(t, "John Doe", merged.Author)
+
+	stored, err := getDocumentByID(db, "3")
+	require.NoError(t, err)
+	require.Equal(t, merged.Title, stored.Title)
+	require.True(t, strings.HasPrefix(stored.XMLData[0], "<document>"))
+
+	// The merged documents are kept
+	_, err = getDocumentByID(db, "1")
+	require.NoError(t, err)
+}
@@ -0,0 +1,258 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DB_USAGE_TABLE_NAME           = "usage_meter" // Table name of the daily usage of API keys in SQLite
+	DB_USAGE_KEY_FIELD_NAME       = "key"         // Field name for the credential, "key:api" or "key:token-{id}"
+	DB_USAGE_DAY_FIELD_NAME       = "day"         // Field name for the UTC day as YYYY-MM-DD
+	DB_USAGE_REQUESTS_FIELD_NAME  = "requests"    // Field name for the number of requests made with the credential
+	DB_USAGE_DOCUMENTS_FIELD_NAME = "documents"   // Field name for the number of documents stored with the credential
+	DB_USAGE_BYTES_FIELD_NAME     = "bytes"       // Field name for the size of the stored documents
+
+	USAGE_FLUSH_INTERVAL = time.Minute  // Interval at which the counted usage is written to the database
+	USAGE_DAY_LAYOUT     = "2006-01-02" // Layout of the day of usage counters
+	USAGE_MONTH_LAYOUT   = "2006-01"    // Layout of the month of the usage export
+
+	USAGE_FORMAT_JSON = "json" // Format of the usage export for programs
+	USAGE_FORMAT_CSV  = "csv"  // Format of the usage export for spreadsheets
+)
+
+// UsageCounts is the usage of a credential
+type UsageCounts struct {
+	Requests  int64
+	Documents int64
+	Bytes     int64
+}
+
+// UsageRollup is the usage of a credential in a month, as exported for billing
+type UsageRollup struct {
+	Key   string
+	Month string
+	UsageCounts
+}
+
+// usageKey identifies the counters of a credential on a day
+type usageKey struct {
+	Key string
+	Day string
+}
+
+// UsageMeter counts the usage of credentials in memory until it is flushed to the database
+// Only requests authenticated with the API key or an access token are counted.
+type UsageMeter struct {
+	mu     sync.Mutex
+	counts map[usageKey]UsageCounts
+}
+
+// usageMeter counts the usage of the server
+var usageMeter = newUsageMeter()
+
+// usageMonth matches the month of the usage export
+var usageMonth = regexp.MustCompile(`^\d{4}-\d{2}$`)
+
+// newUsageMeter creates a meter without usage
+func newUsageMeter() *UsageMeter {
+	return &UsageMeter{counts: map[usageKey]UsageCounts{}}
+}
+
+// Add counts usage of the credential at now, ignoring requests without credentials
+func (meter *UsageMeter) Add(key string, usage UsageCounts, now time.Time) {
+	if !strings.HasPrefix(key, "key:") {
+		return
+	}
+
+	meter.mu.Lock()
+	defer meter.mu.Unlock()
+	id := usageKey{Key: key, Day: now.UTC().Format(USAGE_DAY_LAYOUT)}
+	counts := meter.counts[id]
+	counts.Requests += usage.Requests
+	counts.Documents += usage.Documents
+	counts.Bytes += usage.Bytes
+	meter.counts[id] = counts
+}
+
+// Flush writes the counted usage to the database
+// Usage which can't be written is kept for the next flush.
+func (meter *UsageMeter) Flush(db *sql.DB) error {
+	meter.mu.Lock()
+	pending := meter.counts
+	meter.counts = map[usageKey]UsageCounts{}
+	meter.mu.Unlock()
+
+	for id, counts := range pending {
+		if err := recordUsage(db, id.Key, id.Day, counts); err != nil {
+			meter.mu.Lock()
+			for id, counts := range pending {
+				current := meter.counts[id]
+				current.Requests += counts.Requests
+				current.Documents += counts.Documents
+				current.Bytes += counts.Bytes
+				meter.counts[id] = current
+			}
+			meter.mu.Unlock()
+			return err
+		}
+		delete(pending, id)
+	}
+	return nil
+}
+
+// runUsageFlusher writes the counted usage to the database every interval
+func runUsageFlusher(db *sql.DB, interval time.Duration) {
+	funcName := "runUsageFlusher"
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := usageMeter.Flush(db); err != nil {
+			log.Printf("%s: Failed to store usage: %v", funcName, err)
+		}
+	}
+}
+
+// meterRequests is a middleware counting the requests made with a credential
+func meterRequests(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		next(db, w, r)
+
+		// Tokens are only looked up for requests which have a credential
+		if bearerToken(r) != "" {
+			usageMeter.Add(requestSource(db, r), UsageCounts{Requests: 1}, time.Now())
+		}
+	}
+}
+
+// createUsageTable creates the usage table if not exists
+func createUsageTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY ("%s", "%s")
+	);
+`, DB_USAGE_TABLE_NAME, DB_USAGE_KEY_FIELD_NAME, DB_USAGE_DAY_FIELD_NAME, DB_USAGE_REQUESTS_FIELD_NAME, DB_USAGE_DOCUMENTS_FIELD_NAME, DB_USAGE_BYTES_FIELD_NAME,
+		DB_USAGE_KEY_FIELD_NAME, DB_USAGE_DAY_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// recordUsage adds usage of the credential on the day to its counters
+func recordUsage(db *sql.DB, key string, day string, usage UsageCounts) error {
+	defer observeQuery("recordUsage", time.Now())
+
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s, %[6]s) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(%[2]s, %[3]s) DO UPDATE SET
+			%[4]s=%[4]s+excluded.%[4]s,
+			%[5]s=%[5]s+excluded.%[5]s,
+			%[6]s=%[6]s+excluded.%[6]s
+	`, DB_USAGE_TABLE_NAME, DB_USAGE_KEY_FIELD_NAME, DB_USAGE_DAY_FIELD_NAME, DB_USAGE_REQUESTS_FIELD_NAME, DB_USAGE_DOCUMENTS_FIELD_NAME, DB_USAGE_BYTES_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, key, day, usage.Requests, usage.Documents, usage.Bytes)
+		return err
+	})
+}
+
+// listMonthlyUsage rolls the daily usage up into months, ordered by month and credential
+// An empty month lists every month.
+func listMonthlyUsage(db *sql.DB, month string) ([]UsageRollup, error) {
+	defer observeQuery("listMonthlyUsage", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %[2]s, substr(%[3]s, 1, 7) AS month, SUM(%[4]s), SUM(%[5]s), SUM(%[6]s) FROM %[1]s
+		WHERE ? = '' OR substr(%[3]s, 1, 7) = ?
+		GROUP BY %[2]s, month ORDER BY month, %[2]s
+	`, DB_USAGE_TABLE_NAME, DB_USAGE_KEY_FIELD_NAME, DB_USAGE_DAY_FIELD_NAME, DB_USAGE_REQUESTS_FIELD_NAME, DB_USAGE_DOCUMENTS_FIELD_NAME, DB_USAGE_BYTES_FIELD_NAME)
+	var rollups []UsageRollup
+	err := withDBRetry(func() error {
+		rollups = []UsageRollup{}
+		rows, err := db.Query(query, month, month)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var rollup UsageRollup
+			if err := rows.Scan(&rollup.Key, &rollup.Month, &rollup.Requests, &rollup.Documents, &rollup.Bytes); err != nil {
+				return err
+			}
+			rollups = append(rollups, rollup)
+		}
+		return rows.Err()
+	})
+	return rollups, err
+}
+
+// handleUsageRequest exports the monthly usage of the credentials, e.g. /admin/usage?month=2024-07&format=csv
+func handleUsageRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month != "" && !usageMonth.MatchString(month) {
+		http.Error(w, "month must be given as YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = USAGE_FORMAT_JSON
+	}
+	if format != USAGE_FORMAT_JSON && format != USAGE_FORMAT_CSV {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	// The usage counted since the last flush is included
+	if err := usageMeter.Flush(db); err != nil {
+		httpStoreError(w, "Failed to store usage", err)
+		return
+	}
+	rollups, err := listMonthlyUsage(db, month)
+	if err != nil {
+		httpStoreError(w, "Failed to load usage", err)
+		return
+	}
+
+	if format == USAGE_FORMAT_CSV {
+		name := "usage.csv"
+		if month != "" {
+			name = fmt.Sprintf("usage-%s.csv", month)
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		w.WriteHeader(http.StatusOK)
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"key", "month", "requests", "documents", "bytes"})
+		for _, rollup := range rollups {
+			writer.Write([]string{rollup.Key, rollup.Month, strconv.FormatInt(rollup.Requests, 10), strconv.FormatInt(rollup.Documents, 10), strconv.FormatInt(rollup.Bytes, 10)})
+		}
+		writer.Flush()
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(rollups)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,97 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test counting usage per credential and rolling it up into months
+func TestUsageMeter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	meter := newUsageMeter()
+	july := time.Date(2024, 7, 9, 12, 0, 0, 0, time.UTC)
+	meter.Add("key:api", UsageCounts{Requests: 1}, july)
+	meter.Add("key:api", UsageCounts{Documents: 1, Bytes: 120}, july)
+	meter.Add("key:token-3", UsageCounts{Requests: 2}, july.AddDate(0, 0, 1))
+	meter.Add("http:192.0.2.1", UsageCounts{Requests: 1}, july)
+	require.NoError(t, meter.Flush(db))
+
+	// Flushing adds to the stored counters
+	meter.Add("key:api", UsageCounts{Requests: 1, Documents: 1, Bytes: 80}, july.AddDate(0, 0, 2))
+	meter.Add("key:api", UsageCounts{Requests: 5}, july.AddDate(0, 1, 0))
+	require.NoError(t, meter.Flush(db))
+	require.NoError(t, meter.Flush(db))
+
+	rollups, err := listMonthlyUsage(db, "2024-07")
+	require.NoError(t, err)
+	require.Equal(t, []UsageRollup{
+		{Key: "key:api", Month: "2024-07", UsageCounts: UsageCounts{Requests: 2, Documents: 2, Bytes: 200}},
+		{Key: "key:token-3", Month: "2024-07", UsageCounts: UsageCounts{Requests: 2}},
+	}, rollups)
+
+	rollups, err = listMonthlyUsage(db, "")
+	require.NoError(t, err)
+	require.Len(t, rollups, 3)
+	require.Equal(t, UsageRollup{Key: "key:api", Month: "2024-08", UsageCounts: UsageCounts{Requests: 5}}, rollups[2])
+}
+
+// Test metering requests and stored documents of API keys and exporting them with /admin/usage
+func TestHandleUsageRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	apiKey = "test api key"
+	defer func() { apiKey = "" }()
+	defer func(previous *UsageMeter) { usageMeter = previous }(usageMeter)
+	usageMeter = newUsageMeter()
+
+	body := `<doc><title>Metered</title></doc>`
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	w := httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	// Requests without valid credentials aren't metered
+	w = httptest.NewRecorder()
+	handleRequest(db, w, httptest.NewRequest("GET", "/list", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+
+	month := time.Now().UTC().Format(USAGE_MONTH_LAYOUT)
+	req = httptest.NewRequest("GET", "/admin/usage?month="+month, nil)
+	w = httptest.NewRecorder()
+	handleUsageRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var rollups []UsageRollup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rollups))
+	require.Equal(t, []UsageRollup{{Key: "key:api", Month: month, UsageCounts: UsageCounts{Requests: 1, Documents: 1, Bytes: int64(len(body))}}}, rollups)
+
+	w = httptest.NewRecorder()
+	handleUsageRequest(db, w, httptest.NewRequest("GET", "/admin/usage?month="+month+"&format=csv", nil))
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.Equal(t, "text/csv", w.Result().Header.Get("Content-Type"))
+	require.Equal(t, "key,month,requests,documents,bytes\nkey:api,"+month+",1,1,33\n", w.Body.String())
+
+	tests := []struct {
+		desc   string
+		target string
+	}{
+		{desc: "invalid month", target: "/admin/usage?month=July"},
+		{desc: "invalid format", target: "/admin/usage?format=xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleUsageRequest(db, w, httptest.NewRequest("GET", tt.target, nil))
+			require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+		})
+	}
+}
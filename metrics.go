@@ -0,0 +1,86 @@
+package goapp
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry holds the counters exposed by the /metrics endpoint
+type metricsRegistry struct {
+	mu       sync.Mutex
+	counters map[string]float64 // counters maps a series like `name{label="value"}` to its value
+}
+
+// metrics is the registry shared by the whole server
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{counters: map[string]float64{}}
+}
+
+// seriesName builds the series of a metric from its name and label pairs ("key", "value", ...)
+func seriesName(name string, labels ...string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// add increases the counter of the series by value
+func (registry *metricsRegistry) add(value float64, name string, labels ...string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.counters[seriesName(name, labels...)] += value
+}
+
+// inc increases the counter of the series by one
+func (registry *metricsRegistry) inc(name string, labels ...string) {
+	registry.add(1, name, labels...)
+}
+
+// get returns the value of the series
+func (registry *metricsRegistry) get(name string, labels ...string) float64 {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	return registry.counters[seriesName(name, labels...)]
+}
+
+// writeText writes all series in the Prometheus text format, sorted by series name
+func (registry *metricsRegistry) writeText(w io.Writer) error {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	series := make([]string, 0, len(registry.counters))
+	for name := range registry.counters {
+		series = append(series, name)
+	}
+	sort.Strings(series)
+
+	for _, name := range series {
+		_, err := fmt.Fprintf(w, "%s %g\n", name, registry.counters[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleMetricsRequest returns all metrics in the Prometheus text format
+func handleMetricsRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	metrics.writeText(w)
+}
@@ -0,0 +1,49 @@
+package goapp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test counting and exposing metrics in the text format
+func TestMetricsRegistry(t *testing.T) {
+	registry := newMetricsRegistry()
+	registry.inc("parses_total", "source", "http")
+	registry.inc("parses_total", "source", "http")
+	registry.add(0.5, "db_query_seconds_total", "query", `say "hi"`)
+	registry.inc("requests_total")
+
+	require.EqualValues(t, 2, registry.get("parses_total", "source", "http"))
+	require.EqualValues(t, 0, registry.get("parses_total", "source", "file"))
+
+	var output bytes.Buffer
+	require.NoError(t, registry.writeText(&output))
+	require.Equal(t, `db_query_seconds_total{query="say \"hi\""} 0.5
+parses_total{source="http"} 2
+requests_total 1
+`, output.String())
+}
+
+// Test handling /metrics requests
+func TestHandleMetricsRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, err := getDocumentByID(db, "1")
+	require.Error(t, err)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	handleRequest(db, w, req)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `db_queries_total{query="getDocumentByID"}`)
+}
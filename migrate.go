@@ -0,0 +1,195 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+const (
+	FOREIGN_COLUMN_XML   = "xml"   // Mapping key of the column holding the XML of a document
+	FOREIGN_COLUMN_ID    = "id"    // Mapping key of the column holding the ID a document keeps
+	FOREIGN_COLUMN_STATE = "state" // Mapping key of the column holding the state of a document
+)
+
+// ForeignColumns maps the fields of a document to the columns of a foreign table or CSV
+// Only XML is required; without ID new IDs are assigned and without State documents are active
+type ForeignColumns struct {
+	XML   string
+	ID    string
+	State string
+}
+
+// foreignRow is a document read from a foreign source
+type foreignRow struct {
+	Line  int // Line is the row number in the source, starting at 1
+	XML   string
+	ID    string
+	State string
+}
+
+// MigrateReport summarizes a migration from a foreign source
+type MigrateReport struct {
+	Imported int
+	Failed   int
+}
+
+// parseColumnMapping parses a mapping like "xml=body,id=doc_id,state=status"
+func parseColumnMapping(mapping string) (ForeignColumns, error) {
+	columns := ForeignColumns{}
+	for _, pair := range strings.Split(mapping, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return columns, fmt.Errorf("invalid column mapping %q", pair)
+		}
+
+		switch parts[0] {
+		case FOREIGN_COLUMN_XML:
+			columns.XML = parts[1]
+		case FOREIGN_COLUMN_ID:
+			columns.ID = parts[1]
+		case FOREIGN_COLUMN_STATE:
+			columns.State = parts[1]
+		default:
+			return columns, fmt.Errorf("unknown field %s in column mapping", parts[0])
+		}
+	}
+
+	if columns.XML == "" {
+		return columns, errors.New("column mapping needs an xml column")
+	}
+	return columns, nil
+}
+
+// quoteIdentifier quotes a table or column name for SQLite
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// readSQLiteRows reads the mapped columns of all rows of a table in a foreign SQLite database
+func readSQLiteRows(foreign *sql.DB, table string, columns ForeignColumns) ([]foreignRow, error) {
+	// Unmapped fields are selected as empty strings so every row scans the same way
+	selected := []string{quoteIdentifier(columns.XML), "''", "''"}
+	if columns.ID != "" {
+		selected[1] = quoteIdentifier(columns.ID)
+	}
+	if columns.State != "" {
+		selected[2] = quoteIdentifier(columns.State)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s
+	`, strings.Join(selected, ", "), quoteIdentifier(table))
+	rows, err := foreign.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []foreignRow{}
+	for rows.Next() {
+		var xmlData, id, state sql.NullString
+		if err := rows.Scan(&xmlData, &id, &state); err != nil {
+			return nil, err
+		}
+		result = append(result, foreignRow{Line: len(result) + 1, XML: xmlData.String, ID: id.String, State: state.String})
+	}
+	return result, rows.Err()
+}
+
+// readCSVRows reads the mapped columns of a CSV whose first line holds the column names
+func readCSVRows(in io.Reader, columns ForeignColumns) ([]foreignRow, error) {
+	reader := csv.NewReader(in)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	index := func(name string) (int, error) {
+		if name == "" {
+			return -1, nil
+		}
+		for i, column := range header {
+			if strings.TrimSpace(column) == name {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("unknown CSV column %s", name)
+	}
+	xmlIndex, err := index(columns.XML)
+	if err != nil {
+		return nil, err
+	}
+	idIndex, err := index(columns.ID)
+	if err != nil {
+		return nil, err
+	}
+	stateIndex, err := index(columns.State)
+	if err != nil {
+		return nil, err
+	}
+
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	result := []foreignRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, foreignRow{
+			Line:  len(result) + 1,
+			XML:   field(record, xmlIndex),
+			ID:    field(record, idIndex),
+			State: field(record, stateIndex),
+		})
+	}
+	return result, nil
+}
+
+// migrateRows parses and inserts the rows read from a foreign source
+// Rows which can't be parsed or inserted are logged and skipped so one bad row doesn't stop the migration
+func migrateRows(db *sql.DB, rows []foreignRow, source string) MigrateReport {
+	funcName := "migrateRows"
+
+	report := MigrateReport{}
+	for _, row := range rows {
+		err := migrateRow(db, row, source)
+		trackIngest(db, source, len(row.XML), err)
+		if err != nil {
+			report.Failed++
+			log.Printf("%s: Skipping row %d of %s: %v", funcName, row.Line, source, err)
+			continue
+		}
+		report.Imported++
+	}
+	return report
+}
+
+// migrateRow parses and inserts a single row read from a foreign source
+func migrateRow(db *sql.DB, row foreignRow, source string) error {
+	state := strings.ToLower(strings.TrimSpace(row.State))
+	if state != "" && !isValidState(state) {
+		return fmt.Errorf("invalid state %s", row.State)
+	}
+
+	doc, err := parseDocumentFrom(row.XML, fmt.Sprintf("%s#%d", source, row.Line))
+	if err != nil {
+		return err
+	}
+	doc.ID = strings.TrimSpace(row.ID)
+	doc.State = state
+	return insertDocument(db, *doc)
+}
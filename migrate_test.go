@@ -0,0 +1,95 @@
+package goapp
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test parsing column mappings
+func TestParseColumnMapping(t *testing.T) {
+	tests := []struct {
+		desc     string
+		mapping  string
+		expected ForeignColumns
+		err      bool
+	}{
+		{desc: "xml only", mapping: "xml=body", expected: ForeignColumns{XML: "body"}},
+		{desc: "all fields", mapping: "xml=body, id=doc_id,state=status", expected: ForeignColumns{XML: "body", ID: "doc_id", State: "status"}},
+		{desc: "missing xml", mapping: "id=doc_id", err: true},
+		{desc: "unknown field", mapping: "xml=body,title=name", err: true},
+		{desc: "missing column", mapping: "xml=", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			columns, err := parseColumnMapping(tt.mapping)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, columns)
+		})
+	}
+}
+
+// Test migrating documents from a CSV
+func TestMigrateCSV(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	data := "doc_id,status,content\n" +
+		"10,archived,<doc><title>First</title></doc>\n" +
+		"11,,<doc><title>Second</title></doc>\n" +
+		"12,active,<doc><title>Broken</doc>\n" +
+		"13,lost,<doc><title>Unknown state</title></doc>\n"
+	rows, err := readCSVRows(strings.NewReader(data), ForeignColumns{XML: "content", ID: "doc_id", State: "status"})
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+
+	report := migrateRows(db, rows, "csv:test.csv")
+	require.Equal(t, MigrateReport{Imported: 2, Failed: 2}, report)
+
+	doc, err := getDocumentByID(db, "10")
+	require.NoError(t, err)
+	require.Equal(t, "First", doc.Title)
+	require.Equal(t, DOC_STATE_ARCHIVED, doc.State)
+	require.Equal(t, parserVersion, doc.ParserVersion)
+
+	doc, err = getDocumentByID(db, "11")
+	require.NoError(t, err)
+	require.Equal(t, DOC_STATE_ACTIVE, doc.State)
+
+	_, err = readCSVRows(strings.NewReader(data), ForeignColumns{XML: "body"})
+	require.ErrorContains(t, err, "unknown CSV column body")
+}
+
+// Test migrating documents from a foreign SQLite table
+func TestMigrateSQLite(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	foreign, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer foreign.Close()
+	foreign.SetMaxOpenConns(1)
+
+	_, err = foreign.Exec(`CREATE TABLE "old docs" (body TEXT, extra TEXT)`)
+	require.NoError(t, err)
+	_, err = foreign.Exec(`INSERT INTO "old docs" VALUES ('<doc><title>Legacy</title><author>Bob</author></doc>', 'x'), (NULL, 'y')`)
+	require.NoError(t, err)
+
+	rows, err := readSQLiteRows(foreign, "old docs", ForeignColumns{XML: "body"})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+
+	report := migrateRows(db, rows, "sqlite:old.db")
+	require.Equal(t, MigrateReport{Imported: 1, Failed: 1}, report)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Legacy", doc.Title)
+	require.Equal(t, "Bob", doc.Author)
+}
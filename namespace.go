@@ -0,0 +1,65 @@
+package goapp
+
+import "strings"
+
+const (
+	XMLNS_ATTRIBUTE = "xmlns"                                // Attribute declaring the default namespace, or a prefix as xmlns:{prefix}
+	XML_NAMESPACE   = "http://www.w3.org/XML/1998/namespace" // Namespace bound to the reserved xml prefix
+)
+
+// splitName splits a qualified name like "dc:title" into its prefix and local name
+func splitName(name string) (prefix string, local string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// localName returns the name without its namespace prefix, e.g. "title" for "dc:title"
+func localName(name string) string {
+	_, local := splitName(name)
+	return local
+}
+
+// namespaceDeclarations returns the namespaces declared by xmlns attributes by prefix
+// The default namespace is declared for the empty prefix
+func namespaceDeclarations(attrs map[string]string) map[string]string {
+	declarations := map[string]string{}
+	for name, value := range attrs {
+		if name == XMLNS_ATTRIBUTE {
+			declarations[""] = value
+		} else if prefix, local := splitName(name); prefix == XMLNS_ATTRIBUTE {
+			declarations[local] = value
+		}
+	}
+	return declarations
+}
+
+// documentNamespaces collects the namespace declarations of all elements of a document
+// The elements of XMLData don't know their ancestors, so declarations are resolved document-wide:
+// the outermost declaration of a prefix wins, which fits documents declaring their namespaces once.
+func documentNamespaces(xmlData []string) map[string]string {
+	namespaces := map[string]string{"xml": XML_NAMESPACE}
+	for _, str := range xmlData {
+		element, ok := parseXMLElement(str)
+		if !ok {
+			continue
+		}
+		for prefix, uri := range namespaceDeclarations(element.Attrs) {
+			if _, ok := namespaces[prefix]; !ok {
+				namespaces[prefix] = uri
+			}
+		}
+	}
+	return namespaces
+}
+
+// resolveNamespace sets the namespace URI of element from its own declarations, or else from namespaces
+func (element *XMLElement) resolveNamespace(namespaces map[string]string) {
+	prefix, _ := splitName(element.Name)
+	if uri, ok := namespaceDeclarations(element.Attrs)[prefix]; ok {
+		element.Space = uri
+		return
+	}
+	element.Space = namespaces[prefix]
+}
@@ -0,0 +1,55 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDCNamespace   = "http://purl.org/dc/elements/1.1/"
+	testAtomNamespace = "http://www.w3.org/2005/Atom"
+)
+
+// Test that prefixed metadata elements are extracted and resolved to their namespace
+func TestParseDocumentNamespaces(t *testing.T) {
+	data := `<record xmlns="` + testAtomNamespace + `" xmlns:dc="` + testDCNamespace + `">
+		<dc:title>Test Title</dc:title>
+		<dc:description xml:lang="fr">Description de test</dc:description>
+		<dc:creator>Test Author</dc:creator>
+		<author>Atom Author</author>
+		<meta:note xmlns:meta="urn:example:meta">Test Note</meta:note>
+	</record>`
+
+	doc, err := parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "Test Title", doc.Title)
+	require.Equal(t, "Description de test", doc.Description)
+	require.Equal(t, "Atom Author", doc.Author)
+	require.Equal(t, []LangVariant{{Field: "description", Lang: "fr", Value: "Description de test"}}, doc.Variants)
+
+	tests := []struct {
+		desc  string
+		find  func() (XMLElement, bool)
+		name  string
+		space string
+	}{
+		{desc: "local name", find: func() (XMLElement, bool) { return doc.Element("title") }, name: "dc:title", space: testDCNamespace},
+		{desc: "qualified name", find: func() (XMLElement, bool) { return doc.Element("dc:creator") }, name: "dc:creator", space: testDCNamespace},
+		{desc: "default namespace", find: func() (XMLElement, bool) { return doc.ElementNS(testAtomNamespace, "author") }, name: "author", space: testAtomNamespace},
+		{desc: "declared on the element", find: func() (XMLElement, bool) { return doc.ElementNS("urn:example:meta", "note") }, name: "meta:note", space: "urn:example:meta"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			element, ok := tt.find()
+			require.True(t, ok)
+			require.Equal(t, tt.name, element.Name)
+			require.Equal(t, tt.space, element.Space)
+		})
+	}
+
+	_, ok := doc.ElementNS(testAtomNamespace, "creator")
+	require.False(t, ok)
+	_, ok = doc.Element("atom:title")
+	require.False(t, ok)
+}
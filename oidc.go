@@ -0,0 +1,733 @@
+package goapp
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OIDC_ISSUER_ENV        = "DOC_OIDC_ISSUER"        // Environment variable with the issuer URL of the company SSO, login is disabled without it
+	OIDC_CLIENT_ID_ENV     = "DOC_OIDC_CLIENT_ID"     // Environment variable with the client ID registered at the SSO
+	OIDC_CLIENT_SECRET_ENV = "DOC_OIDC_CLIENT_SECRET" // Environment variable with the client secret registered at the SSO
+	OIDC_REDIRECT_URL_ENV  = "DOC_OIDC_REDIRECT_URL"  // Environment variable with the URL of /auth/callback as registered at the SSO
+	OIDC_GROUP_ROLES_ENV   = "DOC_OIDC_GROUP_ROLES"   // Environment variable mapping SSO groups to roles, e.g. "docs-admins=admin,staff=read"
+	OIDC_GROUPS_CLAIM_ENV  = "DOC_OIDC_GROUPS_CLAIM"  // Environment variable with the ID token claim listing the groups of the user
+
+	OIDC_DEFAULT_GROUPS_CLAIM = "groups"               // Claim listing the groups of the user if not configured
+	OIDC_SCOPES               = "openid email profile" // Scopes requested at login
+	OIDC_TIMEOUT              = 10 * time.Second       // Timeout of a request to the SSO
+	OIDC_LOGIN_TTL            = 10 * time.Minute       // Time a user has to log in at the SSO
+	OIDC_CLOCK_SKEW           = time.Minute            // Tolerated difference between the clocks of the SSO and the server
+
+	OIDC_STATE_COOKIE = "doc_oidc_state" // Cookie holding the state of a login in progress
+	OIDC_STATE_LABEL  = "login-state"    // Label the login state key is derived from the signing key with
+	SESSION_COOKIE    = "doc_session"    // Cookie holding the session of a logged in user
+	SESSION_TTL       = 8 * time.Hour    // Lifetime of a session
+
+	ROLE_ADMIN = "admin" // Role of users with the access of the API key; the other roles are ACCESS_READ and ACCESS_WRITE
+
+	DB_SESSION_TABLE_NAME            = "session"    // Table name of user sessions in SQLite
+	DB_SESSION_HASH_FIELD_NAME       = "token_hash" // Field name for the SHA-256 hash of the session cookie
+	DB_SESSION_SUBJECT_FIELD_NAME    = "subject"    // Field name for the subject of the ID token
+	DB_SESSION_EMAIL_FIELD_NAME      = "email"      // Field name for the email of the user
+	DB_SESSION_ROLE_FIELD_NAME       = "role"       // Field name for the role of the user
+	DB_SESSION_CREATEDAT_FIELD_NAME  = "created_at" // Field name for the login time
+	DB_SESSION_EXPIRES_AT_FIELD_NAME = "expires_at" // Field name for the time the session ends
+)
+
+// ErrOIDCDisabled is returned by the login endpoints when no SSO is configured
+var ErrOIDCDisabled = errors.New("OIDC login is not configured")
+
+// roleRanks orders the roles, a user in several groups gets the highest of their roles
+var roleRanks = map[string]int{ACCESS_READ: 1, ACCESS_WRITE: 2, ROLE_ADMIN: 3}
+
+// OIDCProvider logs users in with the authorization code flow of an OpenID Connect SSO
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	GroupRoles   map[string]string // GroupRoles maps the groups of the SSO to roles
+	GroupsClaim  string
+	Client       *http.Client
+
+	mu                    sync.Mutex
+	authorizationEndpoint string                    // authorizationEndpoint and the other endpoints are discovered on first use
+	tokenEndpoint         string                    //
+	jwksURI               string                    //
+	keys                  map[string]*rsa.PublicKey // keys are the signing keys of the SSO by key ID
+}
+
+// Session is the login of a user
+type Session struct {
+	Subject   string
+	Email     string
+	Role      string
+	CreatedAt string
+	ExpiresAt string
+}
+
+// loginState is kept in a signed cookie between the redirect to the SSO and the callback
+type loginState struct {
+	State    string
+	Nonce    string
+	Verifier string // Verifier is the PKCE code verifier
+	Expires  int64
+}
+
+// oidcProvider is the SSO users log in with, nil unless configured by initOIDC
+var oidcProvider *OIDCProvider
+
+// loginStateKey is the HMAC key for login state cookies, set by initOIDC
+var loginStateKey []byte
+
+// initOIDC sets up login with the company SSO if an issuer is configured
+func initOIDC() {
+	funcName := "initOIDC"
+
+	issuer := os.Getenv(OIDC_ISSUER_ENV)
+	if issuer == "" {
+		return
+	}
+	provider, err := newOIDCProvider(issuer, os.Getenv(OIDC_CLIENT_ID_ENV), os.Getenv(OIDC_CLIENT_SECRET_ENV), os.Getenv(OIDC_REDIRECT_URL_ENV), os.Getenv(OIDC_GROUP_ROLES_ENV))
+	if err != nil {
+		log.Fatalf("%s: %v", funcName, err)
+	}
+	if claim := os.Getenv(OIDC_GROUPS_CLAIM_ENV); claim != "" {
+		provider.GroupsClaim = claim
+	}
+	// A random key would break logins in progress on a restart and on other instances
+	secret := os.Getenv(SIGNING_KEY_ENV)
+	if secret == "" {
+		log.Fatalf("%s: %s is required for OIDC login", funcName, SIGNING_KEY_ENV)
+	}
+	loginStateKey = deriveLoginStateKey(secret)
+	if apiKey == "" {
+		log.Printf("%s: %s is not set, sessions aren't needed while authentication is disabled", funcName, API_KEY_ENV)
+	}
+	oidcProvider = provider
+}
+
+// newOIDCProvider creates a provider and checks its configuration
+func newOIDCProvider(issuer, clientID, clientSecret, redirectURL, groupRoles string) (*OIDCProvider, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("%s and %s are required for OIDC login", OIDC_CLIENT_ID_ENV, OIDC_CLIENT_SECRET_ENV)
+	}
+	parsed, err := url.Parse(redirectURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid %s %q", OIDC_REDIRECT_URL_ENV, redirectURL)
+	}
+	roles, err := parseGroupRoles(groupRoles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", OIDC_GROUP_ROLES_ENV, err)
+	}
+	return &OIDCProvider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		GroupRoles:   roles,
+		GroupsClaim:  OIDC_DEFAULT_GROUPS_CLAIM,
+		Client:       &http.Client{Timeout: OIDC_TIMEOUT},
+	}, nil
+}
+
+// parseGroupRoles parses a comma-separated list of group=role pairs
+func parseGroupRoles(value string) (map[string]string, error) {
+	roles := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(part, "=")
+		if !ok || group == "" {
+			return nil, fmt.Errorf("%q must be group=role", part)
+		}
+		if _, ok := roleRanks[role]; !ok {
+			return nil, fmt.Errorf("unknown role %q of group %s", role, group)
+		}
+		roles[group] = role
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("no group is given a role")
+	}
+	return roles, nil
+}
+
+// RoleFor returns the highest role of the groups, empty if none of them has one
+func (provider *OIDCProvider) RoleFor(groups []string) string {
+	role := ""
+	for _, group := range groups {
+		if mapped, ok := provider.GroupRoles[group]; ok && roleRanks[mapped] > roleRanks[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// getJSON fetches a JSON document from the SSO
+func (provider *OIDCProvider) getJSON(url string, target interface{}) error {
+	resp, err := provider.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// discover looks up the endpoints of the SSO in its discovery document, once
+func (provider *OIDCProvider) discover() error {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.tokenEndpoint != "" {
+		return nil
+	}
+
+	var config struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := provider.getJSON(provider.Issuer+"/.well-known/openid-configuration", &config); err != nil {
+		return fmt.Errorf("discovery failed: %v", err)
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != provider.Issuer {
+		return fmt.Errorf("discovery returned issuer %q instead of %q", config.Issuer, provider.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return errors.New("discovery document lacks endpoints")
+	}
+	provider.authorizationEndpoint = config.AuthorizationEndpoint
+	provider.tokenEndpoint = config.TokenEndpoint
+	provider.jwksURI = config.JWKSURI
+	return nil
+}
+
+// key returns the RSA signing key with the ID, fetching the keys of the SSO again if it is unknown, e.g. after a rotation
+func (provider *OIDCProvider) key(kid string) (*rsa.PublicKey, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := provider.getJSON(provider.jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+	provider.keys = map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		provider.keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// AuthCodeURL returns the URL of the SSO login page for a login with the state
+func (provider *OIDCProvider) AuthCodeURL(state loginState) string {
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientID)
+	query.Set("redirect_uri", provider.RedirectURL)
+	query.Set("scope", OIDC_SCOPES)
+	query.Set("state", state.State)
+	query.Set("nonce", state.Nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.authorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades the authorization code for an ID token and returns its verified claims
+func (provider *OIDCProvider) Exchange(code string, state loginState, now time.Time) (map[string]interface{}, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectURL)
+	form.Set("code_verifier", state.Verifier)
+	req, err := http.NewRequest(http.MethodPost, provider.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+
+	resp, err := provider.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed: unexpected status %s", resp.Status)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.IDToken == "" {
+		return nil, errors.New("token response lacks an ID token")
+	}
+	return provider.verifyIDToken(tokens.IDToken, state.Nonce, now)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token and returns its claims
+// Only RS256, which every OpenID provider has to support, is accepted.
+func (provider *OIDCProvider) verifyIDToken(token string, nonce string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+	key, err := provider.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed ID token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid ID token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q", issuer)
+	}
+	if !containsString(claimStrings(claims["aud"]), provider.ClientID) {
+		return nil, errors.New("ID token issued for another client")
+	}
+	expires, _ := claims["exp"].(float64)
+	if now.Add(-OIDC_CLOCK_SKEW).After(time.Unix(int64(expires), 0)) {
+		return nil, errors.New("ID token expired")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// decodeJWTPart decodes a base64url encoded JSON part of a JWT
+func decodeJWTPart(part string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed ID token")
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return errors.New("malformed ID token")
+	}
+	return nil
+}
+
+// claimStrings returns a claim which may be a string or a list of strings as a list
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// containsString reports whether the list holds the value
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// randomString returns a random URL-safe string for states, nonces and session cookies
+func randomString() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// deriveLoginStateKey derives the key for login state cookies from the signing key, so a signed URL is never a valid state
+func deriveLoginStateKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(OIDC_STATE_LABEL))
+	return mac.Sum(nil)
+}
+
+// encodeLoginState signs the state of a login for its cookie
+func encodeLoginState(state loginState) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, loginStateKey)
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodeLoginState checks the signature and expiry of a login state cookie
+func decodeLoginState(value string, now time.Time) (loginState, error) {
+	var state loginState
+	payload, sig, ok := strings.Cut(value, ".")
+	given, err := hex.DecodeString(sig)
+	if !ok || err != nil {
+		return state, errors.New("malformed login state")
+	}
+	mac := hmac.New(sha256.New, loginStateKey)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return state, errors.New("login state signature mismatch")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(data, &state) != nil {
+		return state, errors.New("malformed login state")
+	}
+	if now.Unix() > state.Expires {
+		return state, errors.New("login took too long")
+	}
+	return state, nil
+}
+
+// secureCookies reports whether cookies are only sent over HTTPS, which is the case unless the redirect URL is plain HTTP
+func (provider *OIDCProvider) secureCookies() bool {
+	return strings.HasPrefix(provider.RedirectURL, "https:")
+}
+
+// createSessionTable creates the session table if not exists
+func createSessionTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL PRIMARY KEY,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL
+	);
+`, DB_SESSION_TABLE_NAME, DB_SESSION_HASH_FIELD_NAME, DB_SESSION_SUBJECT_FIELD_NAME, DB_SESSION_EMAIL_FIELD_NAME, DB_SESSION_ROLE_FIELD_NAME,
+		DB_SESSION_CREATEDAT_FIELD_NAME, DB_SESSION_EXPIRES_AT_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// createSession stores a session and returns the value of its cookie
+// Only the hash of the cookie is stored, like for access tokens. Expired sessions are removed on the way.
+func createSession(db *sql.DB, session *Session, now time.Time) (string, error) {
+	defer observeQuery("createSession", time.Now())
+
+	token, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	session.CreatedAt = formatExpiry(now)
+	session.ExpiresAt = formatExpiry(now.Add(SESSION_TTL))
+
+	query := fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?, ?)", DB_SESSION_TABLE_NAME, DB_SESSION_HASH_FIELD_NAME, DB_SESSION_SUBJECT_FIELD_NAME,
+		DB_SESSION_EMAIL_FIELD_NAME, DB_SESSION_ROLE_FIELD_NAME, DB_SESSION_CREATEDAT_FIELD_NAME, DB_SESSION_EXPIRES_AT_FIELD_NAME)
+	sweep := fmt.Sprintf("DELETE FROM %s WHERE %s <= ?", DB_SESSION_TABLE_NAME, DB_SESSION_EXPIRES_AT_FIELD_NAME)
+	err = withDBRetry(func() error {
+		if _, err := db.Exec(sweep, formatExpiry(now)); err != nil {
+			return err
+		}
+		_, err := db.Exec(query, hashToken(token), session.Subject, session.Email, session.Role, session.CreatedAt, session.ExpiresAt)
+		return err
+	})
+	return token, err
+}
+
+// lookupSession finds the session of a cookie value
+// It returns sql.ErrNoRows if there is no such session or it expired
+func lookupSession(db *sql.DB, token string, now time.Time) (*Session, error) {
+	defer observeQuery("lookupSession", time.Now())
+
+	query := fmt.Sprintf("SELECT %s, %s, %s, %s, %s FROM %s WHERE %s=? AND %s > ?", DB_SESSION_SUBJECT_FIELD_NAME, DB_SESSION_EMAIL_FIELD_NAME, DB_SESSION_ROLE_FIELD_NAME,
+		DB_SESSION_CREATEDAT_FIELD_NAME, DB_SESSION_EXPIRES_AT_FIELD_NAME, DB_SESSION_TABLE_NAME, DB_SESSION_HASH_FIELD_NAME, DB_SESSION_EXPIRES_AT_FIELD_NAME)
+	var session Session
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, hashToken(token), formatExpiry(now)).Scan(&session.Subject, &session.Email, &session.Role, &session.CreatedAt, &session.ExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// deleteSession ends the session of a cookie value
+func deleteSession(db *sql.DB, token string) error {
+	defer observeQuery("deleteSession", time.Now())
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s=?", DB_SESSION_TABLE_NAME, DB_SESSION_HASH_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, hashToken(token))
+		return err
+	})
+}
+
+// requestSession returns the session of the cookie of a request, if it has a valid one
+func requestSession(db *sql.DB, r *http.Request) (*Session, bool) {
+	if oidcProvider == nil {
+		return nil, false
+	}
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	session, err := lookupSession(db, cookie.Value, time.Now())
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("requestSession: Failed to look up session: %v", err)
+		}
+		return nil, false
+	}
+	return session, true
+}
+
+// grants reports whether the role of the session allows the access
+func (session *Session) grants(access string) bool {
+	return roleRanks[session.Role] >= roleRanks[access]
+}
+
+// sessionAccess checks the session cookie of a request without credential for the access
+// It returns false if the request has no valid session, otherwise it answers with 403 Forbidden
+// if the role of the session doesn't allow the access and reports whether the request may proceed.
+// Changes need a request from the same site, since browsers send the cookie with cross-site links.
+func sessionAccess(db *sql.DB, w http.ResponseWriter, r *http.Request, access string) (bool, bool) {
+	session, ok := requestSession(db, r)
+	if !ok {
+		return false, false
+	}
+	if !session.grants(access) || (access != ACCESS_READ && r.Header.Get("Sec-Fetch-Site") == "cross-site") {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return true, false
+	}
+	return true, true
+}
+
+// httpOIDCError answers login requests when the SSO isn't configured or can't be reached
+func httpOIDCError(w http.ResponseWriter, err error) bool {
+	if oidcProvider == nil {
+		http.Error(w, ErrOIDCDisabled.Error(), http.StatusNotFound)
+		return true
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("SSO unavailable: %v", err), http.StatusBadGateway)
+		return true
+	}
+	return false
+}
+
+// handleLoginRequest redirects the user to the login page of the SSO
+func handleLoginRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		httpOIDCError(w, nil)
+		return
+	}
+	if httpOIDCError(w, oidcProvider.discover()) {
+		return
+	}
+
+	state := loginState{Expires: time.Now().Add(OIDC_LOGIN_TTL).Unix()}
+	var err error
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if *value, err = randomString(); err != nil {
+			http.Error(w, "Failed to start login", http.StatusInternalServerError)
+			return
+		}
+	}
+	cookie, err := encodeLoginState(state)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	// The SSO redirects back with a top-level GET, which SameSite=Lax cookies are sent with
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    cookie,
+		Path:     linkPath("/auth/"),
+		MaxAge:   int(OIDC_LOGIN_TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   oidcProvider.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, oidcProvider.AuthCodeURL(state), http.StatusFound)
+}
+
+// handleCallbackRequest finishes a login: the code is exchanged for an ID token, the groups of the user
+// are mapped to a role and a session cookie is set
+func handleCallbackRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	funcName := "handleCallbackRequest"
+
+	if oidcProvider == nil {
+		httpOIDCError(w, nil)
+		return
+	}
+	if httpOIDCError(w, oidcProvider.discover()) {
+		return
+	}
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, fmt.Sprintf("Login failed: %s %s", reason, query.Get("error_description")), http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	cookie, err := r.Cookie(OIDC_STATE_COOKIE)
+	if err != nil {
+		http.Error(w, "Login failed: no login in progress", http.StatusBadRequest)
+		return
+	}
+	state, err := decodeLoginState(cookie.Value, now)
+	if err != nil || !hmac.Equal([]byte(query.Get("state")), []byte(state.State)) {
+		http.Error(w, "Login failed: invalid state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: OIDC_STATE_COOKIE, Path: linkPath("/auth/"), MaxAge: -1, HttpOnly: true, Secure: oidcProvider.secureCookies()})
+
+	claims, err := oidcProvider.Exchange(query.Get("code"), state, now)
+	if err != nil {
+		log.Printf("%s: Login failed: %v", funcName, err)
+		http.Error(w, fmt.Sprintf("Login failed: %v", err), http.StatusUnauthorized)
+		return
+	}
+	session := &Session{}
+	session.Subject, _ = claims["sub"].(string)
+	session.Email, _ = claims["email"].(string)
+	session.Role = oidcProvider.RoleFor(claimStrings(claims[oidcProvider.GroupsClaim]))
+	if session.Subject == "" {
+		http.Error(w, "Login failed: ID token lacks a subject", http.StatusUnauthorized)
+		return
+	}
+	if session.Role == "" {
+		log.Printf("%s: %s (%s) has no role", funcName, session.Subject, session.Email)
+		http.Error(w, "Access denied: none of your groups has a role", http.StatusForbidden)
+		return
+	}
+
+	token, err := createSession(db, session, now)
+	if err != nil {
+		httpStoreError(w, "Failed to create session", err)
+		return
+	}
+	log.Printf("%s: %s (%s) logged in as %s", funcName, session.Subject, session.Email, session.Role)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    token,
+		Path:     linkPath("/"),
+		MaxAge:   int(SESSION_TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   oidcProvider.secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, linkPath("/auth/session"), http.StatusFound)
+}
+
+// handleLogoutRequest ends the session of the user on POST
+func handleLogoutRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		httpOIDCError(w, nil)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie(SESSION_COOKIE); err == nil && cookie.Value != "" {
+		if err := deleteSession(db, cookie.Value); err != nil {
+			httpStoreError(w, "Failed to end session", err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Path: linkPath("/"), MaxAge: -1, HttpOnly: true, Secure: oidcProvider.secureCookies()})
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleSessionRequest returns the session of the user, so a UI can show who is logged in
+func handleSessionRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if oidcProvider == nil {
+		httpOIDCError(w, nil)
+		return
+	}
+	session, ok := requestSession(db, r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(session)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,223 @@
+package goapp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSSO is an OpenID provider issuing ID tokens for the claims set by the test
+type fakeSSO struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	nonce  string
+}
+
+func newFakeSSO(t *testing.T) *fakeSSO {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	sso := &fakeSSO{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 sso.server.URL,
+			"authorization_endpoint": sso.server.URL + "/authorize",
+			"token_endpoint":         sso.server.URL + "/token",
+			"jwks_uri":               sso.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "client" || r.FormValue("code") != "code-1" || r.FormValue("code_verifier") == "" {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{"iss": sso.server.URL, "aud": "client", "exp": time.Now().Add(time.Hour).Unix(), "nonce": sso.nonce}
+		for name, value := range sso.claims {
+			claims[name] = value
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": sso.sign(t, claims)})
+	})
+	sso.server = httptest.NewServer(mux)
+	return sso
+}
+
+// sign returns an RS256 ID token with the claims
+func (sso *fakeSSO) sign(t *testing.T, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, sso.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Test parsing the group to role mapping and picking the highest role
+func TestGroupRoles(t *testing.T) {
+	roles, err := parseGroupRoles("docs-admins=admin, staff=read,editors=write")
+	require.NoError(t, err)
+	provider := &OIDCProvider{GroupRoles: roles}
+	require.Equal(t, ROLE_ADMIN, provider.RoleFor([]string{"staff", "docs-admins"}))
+	require.Equal(t, ACCESS_WRITE, provider.RoleFor([]string{"editors", "staff"}))
+	require.Equal(t, "", provider.RoleFor([]string{"sales"}))
+
+	for _, value := range []string{"", "staff", "=read", "staff=owner"} {
+		_, err := parseGroupRoles(value)
+		require.Error(t, err, value)
+	}
+}
+
+// Test a login with the SSO and using the session cookie instead of an API key
+func TestOIDCLogin(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	loginStateKey = deriveLoginStateKey("test key")
+	apiKey = "secret"
+	sso := newFakeSSO(t)
+	defer func() {
+		sso.server.Close()
+		apiKey = ""
+		oidcProvider = nil
+	}()
+
+	provider, err := newOIDCProvider(sso.server.URL+"/", "client", "client secret", "https://docs.example.com/auth/callback", "staff=read,docs-admins=admin")
+	require.NoError(t, err)
+	oidcProvider = provider
+
+	login := func(groups ...string) (*httptest.ResponseRecorder, *http.Cookie) {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+		require.Equal(t, http.StatusFound, rr.Code)
+		location, err := url.Parse(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+		stateCookie := rr.Result().Cookies()[0]
+		require.Equal(t, OIDC_STATE_COOKIE, stateCookie.Name)
+
+		sso.nonce = location.Query().Get("nonce")
+		sso.claims = map[string]interface{}{"sub": "u1", "email": "ada@example.com", "groups": groups}
+		req := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code-1&state="+location.Query().Get("state"), nil)
+		req.AddCookie(stateCookie)
+		rr = httptest.NewRecorder()
+		handleRequest(db, rr, req)
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == SESSION_COOKIE && cookie.Value != "" {
+				return rr, cookie
+			}
+		}
+		return rr, nil
+	}
+
+	rr, session := login("staff")
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	require.NotNil(t, session)
+
+	tests := []struct {
+		desc     string
+		method   string
+		path     string
+		expected int
+	}{
+		{desc: "session", method: http.MethodGet, path: "/auth/session", expected: http.StatusOK},
+		{desc: "read", method: http.MethodGet, path: "/list", expected: http.StatusOK},
+		{desc: "write", method: http.MethodPost, path: "/add", expected: http.StatusForbidden},
+		{desc: "admin", method: http.MethodGet, path: "/sources", expected: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.AddCookie(session)
+			rr := httptest.NewRecorder()
+			handleRequest(db, rr, req)
+			require.Equal(t, tt.expected, rr.Code, rr.Body.String())
+		})
+	}
+
+	_, admin := login("docs-admins")
+	req := httptest.NewRequest(http.MethodGet, "/sources", nil)
+	req.AddCookie(admin)
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Users without a role don't get a session
+	rr, none := login("sales")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Nil(t, none)
+
+	// Logging out ends the session
+	req = httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.AddCookie(session)
+	handleRequest(db, httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.AddCookie(session)
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+// Test that login states are signed with their own key derived from the signing key
+func TestLoginStateKey(t *testing.T) {
+	defer func() { loginStateKey = nil }()
+	loginStateKey = deriveLoginStateKey("test key")
+	require.Equal(t, loginStateKey, deriveLoginStateKey("test key"))
+	require.NotEqual(t, []byte("test key"), loginStateKey)
+
+	now := time.Now()
+	value, err := encodeLoginState(loginState{State: "s", Expires: now.Add(OIDC_LOGIN_TTL).Unix()})
+	require.NoError(t, err)
+	state, err := decodeLoginState(value, now)
+	require.NoError(t, err)
+	require.Equal(t, "s", state.State)
+
+	// Another instance with the same key accepts the state, one with another key doesn't
+	loginStateKey = deriveLoginStateKey("other key")
+	_, err = decodeLoginState(value, now)
+	require.EqualError(t, err, "login state signature mismatch")
+}
+
+// Test that ID tokens with a wrong nonce, audience or expiry are rejected
+func TestVerifyIDToken(t *testing.T) {
+	sso := newFakeSSO(t)
+	defer sso.server.Close()
+	provider, err := newOIDCProvider(sso.server.URL, "client", "client secret", "https://docs.example.com/auth/callback", "staff=read")
+	require.NoError(t, err)
+	require.NoError(t, provider.discover())
+
+	now := time.Now()
+	valid := map[string]interface{}{"iss": sso.server.URL, "aud": []string{"client"}, "exp": now.Add(time.Hour).Unix(), "nonce": "n1", "sub": "u1"}
+	claims, err := provider.verifyIDToken(sso.sign(t, valid), "n1", now)
+	require.NoError(t, err)
+	require.Equal(t, "u1", claims["sub"])
+
+	_, err = provider.verifyIDToken(sso.sign(t, valid), "n2", now)
+	require.Error(t, err)
+	_, err = provider.verifyIDToken(sso.sign(t, valid), "n1", now.Add(2*time.Hour))
+	require.Error(t, err)
+	valid["aud"] = "other"
+	_, err = provider.verifyIDToken(sso.sign(t, valid), "n1", now)
+	require.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package goapp
+
+// ParseOption tunes a single parse, e.g. parseDocument(data, WithLenient(true), WithMaxDepth(16))
+// Options are applied in order over the defaults, so a later option wins.
+type ParseOption func(*ParseOptions)
+
+// WithMaxDepth rejects documents with elements nested deeper than depth, 0 leaves the limit of the server
+func WithMaxDepth(depth int) ParseOption {
+	return func(options *ParseOptions) {
+		options.MaxDepth = depth
+	}
+}
+
+// WithLenient repairs dangling and unmatched tags instead of failing
+func WithLenient(lenient bool) ParseOption {
+	return func(options *ParseOptions) {
+		options.Lenient = lenient
+	}
+}
+
+// WithWhitespace handles the whitespace of the elements with a WHITESPACE_* mode
+func WithWhitespace(mode string) ParseOption {
+	return func(options *ParseOptions) {
+		options.Whitespace = mode
+	}
+}
+
+// WithNamespaces only reads metadata fields from elements in one of the namespaces, given by URI, "" for elements
+// without namespace
+// Without it metadata elements are matched by local name whatever their namespace, so <dc:title> and <atom:title>
+// both give the title.
+func WithNamespaces(spaces ...string) ParseOption {
+	return func(options *ParseOptions) {
+		options.Namespaces = append([]string{}, spaces...)
+	}
+}
+
+// WithOrder puts the XMLData of the document and its Paths in an XMLDATA_ORDER_* order
+// Documents are stored breadth-first, with the root element first; elements in depth-first order are for reading
+// only, the root element is last.
+func WithOrder(order string) ParseOption {
+	return func(options *ParseOptions) {
+		options.Order = order
+	}
+}
+
+// newParseOptions returns the options of a parse with opts applied in order
+func newParseOptions(opts ...ParseOption) ParseOptions {
+	var options ParseOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// Parse parses data into an XMLDoc with the options, like documents added through /add
+// Its XMLData is in document order unless another one is picked WithOrder.
+func Parse(data string, opts ...ParseOption) (*XMLDoc, error) {
+	return parseDocumentWithOptions(data, newParseOptions(append([]ParseOption{WithOrder(XMLDATA_ORDER_DOCUMENT)}, opts...)...))
+}
+
+// inNamespaces reports whether element is in one of the namespaces of WithNamespaces, any namespace if there are none
+func (options ParseOptions) inNamespaces(element XMLElement) bool {
+	if len(options.Namespaces) == 0 {
+		return true
+	}
+	for _, space := range options.Namespaces {
+		if element.Space == space {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDepth rejects data nested deeper than the MaxDepth of options, if it is set
+// The limit of the server is checked before parsing, so a larger MaxDepth doesn't lift it.
+func (options ParseOptions) checkDepth(data string) error {
+	if options.MaxDepth <= 0 {
+		return nil
+	}
+	return ParseLimits{MaxDepth: options.MaxDepth}.Check(data)
+}
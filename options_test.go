@@ -0,0 +1,46 @@
+package goapp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test tuning single parses with ParseOption
+func TestParseOptions(t *testing.T) {
+	nested := `<document><title>Deep</title><a><b><c>text</c></b></a></document>`
+	_, err := parseDocument(nested, WithMaxDepth(3))
+	require.True(t, errors.Is(err, ErrParseLimit), err)
+	_, err = parseXML(nested, WithMaxDepth(3))
+	require.True(t, errors.Is(err, ErrParseLimit), err)
+	doc, err := parseDocument(nested, WithMaxDepth(4))
+	require.NoError(t, err)
+	require.Equal(t, "Deep", doc.Title)
+
+	broken := `<document><title>Open</document>`
+	_, err = parseXML(broken)
+	require.Error(t, err)
+	xmlData, err := parseXML(broken, WithLenient(true))
+	require.NoError(t, err)
+	require.Equal(t, "<document><title>Open</title></document>", xmlData[0])
+	// A later option wins
+	_, err = parseDocument(broken, WithLenient(true), WithLenient(false))
+	require.Error(t, err)
+
+	xmlData, err = parseXML("<document>\n  <pre xml:space=\"preserve\">  a  </pre>\n</document>", WithWhitespace(WHITESPACE_PRESERVE))
+	require.NoError(t, err)
+	require.Equal(t, "<pre xml:space=\"preserve\">  a  </pre>", xmlData[1])
+
+	feed := `<entry xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<dc:title>Dublin Core</dc:title><title>Atom</title></entry>`
+	doc, err = Parse(feed)
+	require.NoError(t, err)
+	require.Equal(t, "Dublin Core", doc.Title)
+	doc, err = Parse(feed, WithNamespaces("http://www.w3.org/2005/Atom"))
+	require.NoError(t, err)
+	require.Equal(t, "Atom", doc.Title)
+	doc, err = ParseFragment("<title>Snippet</title>", WithNamespaces(""))
+	require.NoError(t, err)
+	require.Equal(t, "Snippet", doc.Title)
+}
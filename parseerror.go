@@ -0,0 +1,88 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const PARSE_ERROR_SNIPPET_LENGTH = 60 // Longest snippet of the line of a parse error, in characters
+
+// ParseError is an error in XML data with the position it was found at
+type ParseError struct {
+	Line    int    // Line is the 1-based line of the error
+	Col     int    // Col is the 1-based column of the error, counted in characters
+	Msg     string // Msg describes the error, like "tag pairing error"
+	Snippet string // Snippet is the line of the error, cut to PARSE_ERROR_SNIPPET_LENGTH characters around Col
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d", err.Msg, err.Line, err.Col)
+}
+
+// ParseErrorResponse is the JSON response for documents which failed to parse
+type ParseErrorResponse struct {
+	Error   string
+	Line    int
+	Col     int
+	Msg     string
+	Snippet string
+}
+
+// newParseError returns the error for the position offset, in bytes, of data
+func newParseError(data string, offset int, msg string) *ParseError {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	start := strings.LastIndexByte(data[:offset], '\n') + 1
+	end := strings.IndexByte(data[offset:], '\n')
+	if end < 0 {
+		end = len(data)
+	} else {
+		end += offset
+	}
+
+	col := utf8.RuneCountInString(data[start:offset]) + 1
+	return &ParseError{
+		Line:    strings.Count(data[:start], "\n") + 1,
+		Col:     col,
+		Msg:     msg,
+		Snippet: snippet(strings.TrimRight(data[start:end], "\r"), col),
+	}
+}
+
+// snippet cuts line to PARSE_ERROR_SNIPPET_LENGTH characters around the column col
+func snippet(line string, col int) string {
+	runes := []rune(line)
+	if len(runes) <= PARSE_ERROR_SNIPPET_LENGTH {
+		return line
+	}
+	start := col - 1 - PARSE_ERROR_SNIPPET_LENGTH/2
+	if start < 0 {
+		start = 0
+	} else if start > len(runes)-PARSE_ERROR_SNIPPET_LENGTH {
+		start = len(runes) - PARSE_ERROR_SNIPPET_LENGTH
+	}
+	return string(runes[start : start+PARSE_ERROR_SNIPPET_LENGTH])
+}
+
+// httpParseError answers with 400 and the position of the parse error as JSON
+func httpParseError(w http.ResponseWriter, message string, err *ParseError) {
+	response, marshalErr := json.Marshal(ParseErrorResponse{
+		Error:   fmt.Sprintf("%s: %v", message, err),
+		Line:    err.Line,
+		Col:     err.Col,
+		Msg:     err.Msg,
+		Snippet: err.Snippet,
+	})
+	if marshalErr != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(response)
+}
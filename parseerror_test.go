@@ -0,0 +1,91 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test computing the position and snippet of parse errors
+func TestNewParseError(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	tests := []struct {
+		desc     string
+		data     string
+		offset   int
+		expected ParseError
+	}{
+		{desc: "first line", data: "<a><b></a>", offset: 6, expected: ParseError{Line: 1, Col: 7, Snippet: "<a><b></a>"}},
+		{desc: "later line", data: "<a>\r\n  <b>\r\n</a>", offset: 7, expected: ParseError{Line: 2, Col: 3, Snippet: "  <b>"}},
+		{desc: "characters", data: "<a>éé</b>", offset: 7, expected: ParseError{Line: 1, Col: 6, Snippet: "<a>éé</b>"}},
+		{desc: "long line", data: long + "<b>" + long, offset: 100, expected: ParseError{Line: 1, Col: 101, Snippet: strings.Repeat("a", 30) + "<b>" + strings.Repeat("a", 27)}},
+		{desc: "start of long line", data: "<b>" + long, offset: 0, expected: ParseError{Line: 1, Col: 1, Snippet: "<b>" + strings.Repeat("a", 57)}},
+		{desc: "end of data", data: "<a>\n", offset: 4, expected: ParseError{Line: 2, Col: 1, Snippet: ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tt.expected.Msg = "error"
+			require.Equal(t, &tt.expected, newParseError(tt.data, tt.offset, "error"))
+		})
+	}
+}
+
+// Test the positions of errors of the streaming parser
+func TestParseReaderErrorPosition(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected ParseError
+	}{
+		{
+			desc:     "later line",
+			data:     "<document>\n  <title>Test</author>\n</document>",
+			expected: ParseError{Line: 2, Col: 14, Msg: "unmatched closing tag error: <title> </author>", Snippet: "  <title>Test</author>"},
+		},
+		{
+			desc:     "characters",
+			data:     "<document>éé</item>",
+			expected: ParseError{Line: 1, Col: 13, Msg: "unmatched closing tag error: <document> </item>", Snippet: "<document>éé</item>"},
+		},
+		{
+			desc:     "long line",
+			data:     "<document>" + strings.Repeat("a", 1000) + "</item>",
+			expected: ParseError{Line: 1, Col: 1011, Msg: "unmatched closing tag error: <document> </item>", Snippet: strings.Repeat("a", 53) + "</item>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := ParseReader(strings.NewReader(tt.data), &recordingHandler{})
+			var parseError *ParseError
+			require.True(t, errors.As(err, &parseError))
+			require.Equal(t, &tt.expected, parseError)
+		})
+	}
+}
+
+// Test that /add answers malformed documents with the error position as JSON
+func TestHandleAddRequestParseError(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/add", strings.NewReader("<document>\n  <title>Test Title</author>\n</document>"))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+	require.Equal(t, "application/json", w.Result().Header.Get("Content-Type"))
+	var response ParseErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, ParseErrorResponse{
+		Error:   "Failed to parse document: unmatched closing tag error: <title> </author> at line 2, column 20",
+		Line:    2,
+		Col:     20,
+		Msg:     "unmatched closing tag error: <title> </author>",
+		Snippet: "  <title>Test Title</author>",
+	}, response)
+}
@@ -0,0 +1,31 @@
+package goapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// PARSER_VERSION is bumped whenever the extraction logic of parseDocument changes
+// Changes to the extraction rules alone are picked up by the ruleset hash
+const PARSER_VERSION = "18"
+
+// parserRules lists the rules parseDocument extracts metadata with
+var parserRules = append([]string{
+	XML_TITLE_FIELD,
+	XML_DESCRIPTION_FIELD,
+	XML_AUTHOR_FIELD,
+	XML_CREATEDAT_FIELD,
+	XML_EXPIRESAT_FIELD,
+	XML_EXPIRES_ATTRIBUTE,
+	XML_LANG_ATTRIBUTE,
+}, append(expiryLayouts, createdAtLayouts...)...)
+
+// parserVersion is stamped on every parsed document as "{PARSER_VERSION}+{ruleset hash}"
+var parserVersion = formatParserVersion(PARSER_VERSION, parserRules)
+
+// formatParserVersion combines a version with a short hash of the rules
+func formatParserVersion(version string, rules []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(rules, "\n")))
+	return version + "+" + hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,18 @@
+package goapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the parser version changes with the rules
+func TestFormatParserVersion(t *testing.T) {
+	rules := []string{"<title>", "<author>"}
+	version := formatParserVersion("1", rules)
+
+	require.Regexp(t, `^1\+[0-9a-f]{12}$`, version)
+	require.Equal(t, version, formatParserVersion("1", rules))
+	require.NotEqual(t, version, formatParserVersion("2", rules))
+	require.NotEqual(t, version, formatParserVersion("1", []string{"<title>", "<creator>"}))
+}
@@ -0,0 +1,110 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const DB_XMLPATHS_FIELD_NAME = "xml_paths" // Field name for xml_paths (JSON encoded paths of the elements of xml_data) in SQLite table
+
+// pathStepPattern matches a step of an element path, like "author" or "author[2]"
+var pathStepPattern = regexp.MustCompile(`^([^\s/\[\]]+)(?:\[([1-9][0-9]*)\])?$`)
+
+// ElementResponse is the response of /element
+type ElementResponse struct {
+	ID   string
+	Path string // Path is the path of the element as given by Paths
+	XML  string // XML is the element as stored in XMLData
+	XMLElement
+}
+
+// normalizePath returns the form of an element path used by Paths, with the position of every step but the root
+// The position 1 may be left out, e.g. /document/metadata/author is /document/metadata[1]/author[1].
+func normalizePath(path string) (string, error) {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return "", fmt.Errorf("invalid path %q: paths start with / and the root element", path)
+	}
+	steps := strings.Split(path[1:], "/")
+	for i, step := range steps {
+		match := pathStepPattern.FindStringSubmatch(step)
+		if match == nil {
+			return "", fmt.Errorf("invalid path %q: invalid step %q", path, step)
+		}
+		switch {
+		case i == 0 && match[2] != "" && match[2] != "1":
+			return "", fmt.Errorf("invalid path %q: documents have a single root element", path)
+		case i == 0:
+			steps[i] = match[1]
+		case match[2] == "":
+			steps[i] = match[1] + "[1]"
+		}
+	}
+	return "/" + strings.Join(steps, "/"), nil
+}
+
+// ElementAt returns the element of XMLData the path locates, like /document/metadata/author[1]
+// Documents stored before the paths were kept have none until they are reprocessed.
+func (doc *XMLDoc) ElementAt(path string) (string, bool) {
+	path, err := normalizePath(path)
+	if err != nil {
+		return "", false
+	}
+	for i, elementPath := range doc.Paths {
+		if elementPath == path && i < len(doc.XMLData) {
+			return doc.XMLData[i], true
+		}
+	}
+	return "", false
+}
+
+// handleElementRequest returns the element a path locates in a document
+func handleElementRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+	path, err := normalizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+	if len(doc.Paths) == 0 {
+		http.Error(w, fmt.Sprintf("Document with ID %s has no element paths yet, reprocess it", id), http.StatusConflict)
+		return
+	}
+	str, ok := doc.ElementAt(path)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No element at %s in document with ID %s", path, id), http.StatusNotFound)
+		return
+	}
+	element, ok := parseXMLElement(str)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Failed to parse element at %s in document with ID %s", path, id), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(ElementResponse{ID: id, Path: path, XML: str, XMLElement: element})
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
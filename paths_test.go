@@ -0,0 +1,102 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that every element of XMLData gets the path locating it
+func TestParseXMLPaths(t *testing.T) {
+	data := `<document><metadata><author>Ann</author><author>Bob</author><dc:subject/></metadata><section><author>Cy</author></section></document>`
+	xmlData, paths, err := parseXMLPaths(data, "", "")
+	require.NoError(t, err)
+	require.Len(t, paths, len(xmlData))
+
+	located := map[string]string{}
+	for i, path := range paths {
+		located[path] = xmlData[i]
+	}
+	require.Equal(t, map[string]string{
+		"/document":                           data,
+		"/document/metadata[1]":               "<metadata><author>Ann</author><author>Bob</author><dc:subject/></metadata>",
+		"/document/metadata[1]/author[1]":     "<author>Ann</author>",
+		"/document/metadata[1]/author[2]":     "<author>Bob</author>",
+		"/document/metadata[1]/dc:subject[1]": "<dc:subject/>",
+		"/document/section[1]":                "<section><author>Cy</author></section>",
+		"/document/section[1]/author[1]":      "<author>Cy</author>",
+	}, located)
+}
+
+// Test the forms element paths are accepted in
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		err      bool
+	}{
+		{path: "/document", expected: "/document"},
+		{path: "/document[1]/metadata/author[2]", expected: "/document/metadata[1]/author[2]"},
+		{path: "/dc:record/dc:title", expected: "/dc:record/dc:title[1]"},
+		{path: "document/title", err: true},
+		{path: "/", err: true},
+		{path: "/document[2]", err: true},
+		{path: "/document//title", err: true},
+		{path: "/document/title[0]", err: true},
+		{path: "/document/title[last()]", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path, err := normalizePath(tt.path)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, path)
+		})
+	}
+}
+
+// Test looking up elements of a document by path with /element
+func TestHandleElementRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument(`<document><metadata><author id="a1">Ann</author><author id="b2">Bob</author></metadata></document>`)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("GET", "/element?"+query, nil))
+		return rr
+	}
+
+	rr := get("id=1&path=/document/metadata/author[2]")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var response ElementResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	require.Equal(t, "/document/metadata[1]/author[2]", response.Path)
+	require.Equal(t, `<author id="b2">Bob</author>`, response.XML)
+	require.Equal(t, "Bob", response.Text)
+	require.Equal(t, map[string]string{"id": "b2"}, response.Attrs)
+
+	require.Equal(t, http.StatusNotFound, get("id=1&path=/document/metadata/author[3]").Code)
+	require.Equal(t, http.StatusNotFound, get("id=2&path=/document").Code)
+	require.Equal(t, http.StatusBadRequest, get("id=1&path=author").Code)
+	require.Equal(t, http.StatusBadRequest, get("path=/document").Code)
+
+	// Documents stored before paths were kept get them by reprocessing
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET %s=NULL", DB_TABLE_NAME, DB_XMLPATHS_FIELD_NAME))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, get("id=1&path=/document").Code)
+	changed, err := reprocessDocument(db, "1")
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, http.StatusOK, get("id=1&path=/document").Code)
+}
@@ -0,0 +1,109 @@
+package goapp
+
+import (
+	"html"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	PREVIEW_SENTENCES_ENV = "DOC_PREVIEW_SENTENCES" // Environment variable with the number of sentences of previews
+
+	PREVIEW_DEFAULT_SENTENCES = 2   // Number of sentences of previews by default
+	PREVIEW_MAX_LENGTH        = 300 // Longest preview in characters before escaping, longer ones are cut
+	PREVIEW_ELLIPSIS          = "…" // Appended to previews which were cut
+
+	LIST_VIEW_SUMMARY = "summary" // View of /list leaving out the element tree of documents
+)
+
+// previewSentences is the number of sentences of previews, set by initPreviews
+var previewSentences = PREVIEW_DEFAULT_SENTENCES
+
+// initPreviews loads the number of sentences of previews from the environment
+func initPreviews() {
+	funcName := "initPreviews"
+
+	value := os.Getenv(PREVIEW_SENTENCES_ENV)
+	if value == "" {
+		return
+	}
+	sentences, err := strconv.Atoi(value)
+	if err != nil || sentences < 1 {
+		log.Fatalf("%s: %s must be at least 1", funcName, PREVIEW_SENTENCES_ENV)
+	}
+	previewSentences = sentences
+}
+
+// previewSkippedElements lists the metadata elements left out of previews since listings show them anyway
+var previewSkippedElements = map[string]bool{
+	XML_TITLE_FIELD:     true,
+	XML_AUTHOR_FIELD:    true,
+	XML_CREATEDAT_FIELD: true,
+	XML_EXPIRESAT_FIELD: true,
+}
+
+// previewText returns the text of data without markup and metadata elements, with whitespace collapsed
+func previewText(data string) string {
+	var result strings.Builder
+	skipped := 0 // skipped is the number of open metadata elements enclosing the current text
+	scanXML(data, func(tag string) {
+		result.WriteByte(' ')
+		if strings.HasPrefix(tag, "<?") || strings.HasPrefix(tag, "<!") || strings.HasSuffix(tag, "/>") {
+			return
+		}
+		closing := strings.HasPrefix(tag, "</")
+		name := strings.TrimLeft(strings.TrimSuffix(tag, ">"), "</")
+		if i := strings.IndexAny(name, " \t\r\n"); i >= 0 {
+			name = name[:i]
+		}
+		if !previewSkippedElements[localName(name)] {
+			return
+		}
+		if closing {
+			skipped--
+		} else {
+			skipped++
+		}
+	}, func(text string) {
+		if skipped == 0 {
+			result.WriteString(text)
+		}
+	})
+	return strings.Join(strings.Fields(result.String()), " ")
+}
+
+// firstSentences returns the first count sentences of text
+// A sentence ends with '.', '!' or '?' followed by a space or the end of the text
+func firstSentences(text string, count int) string {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '.' && text[i] != '!' && text[i] != '?' {
+			continue
+		}
+		if i+1 < len(text) && text[i+1] != ' ' {
+			continue
+		}
+		count--
+		if count == 0 {
+			return text[:i+1]
+		}
+	}
+	return text
+}
+
+// makePreview returns the HTML escaped preview of the document data
+// The preview is the first sentences of its text, cut at PREVIEW_MAX_LENGTH characters
+func makePreview(data string, sentences int) string {
+	preview := firstSentences(previewText(data), sentences)
+	if utf8.RuneCountInString(preview) > PREVIEW_MAX_LENGTH {
+		preview = string([]rune(preview)[:PREVIEW_MAX_LENGTH])
+		// Don't end in the middle of a word if there is a word boundary to cut at
+		if i := strings.LastIndexByte(preview, ' '); i > 0 {
+			preview = preview[:i]
+		}
+		preview += PREVIEW_ELLIPSIS
+	}
+	return html.EscapeString(preview)
+}
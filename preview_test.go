@@ -0,0 +1,90 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test generating previews of documents
+func TestMakePreview(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	tests := []struct {
+		desc      string
+		data      string
+		sentences int
+		expected  string
+	}{
+		{
+			desc:      "first sentences",
+			data:      "<document><title>Test Title</title><description>First one. Second one! Third one? Fourth.</description></document>",
+			sentences: 2,
+			expected:  "First one. Second one!",
+		},
+		{
+			desc:      "sentences across elements",
+			data:      "<document><p>First one.</p><p>Second one.</p><p>Third one.</p></document>",
+			sentences: 2,
+			expected:  "First one. Second one.",
+		},
+		{
+			desc:      "decimal point isn't a sentence end",
+			data:      "<document><p>Version 1.5 is out. Update now.</p></document>",
+			sentences: 1,
+			expected:  "Version 1.5 is out.",
+		},
+		{
+			desc:      "metadata left out",
+			data:      "<document><dc:title>Test Title</dc:title><author>Test Author</author><creationDate>2024-07-09</creationDate><p>Body text</p></document>",
+			sentences: 1,
+			expected:  "Body text",
+		},
+		{
+			desc:      "html escaped",
+			data:      "<document><p><![CDATA[<script>alert(\"x\")</script>]]> &amp; more.</p></document>",
+			sentences: 1,
+			expected:  "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; more.",
+		},
+		{
+			desc:      "cut at the longest length",
+			data:      "<document><p>" + long + "</p></document>",
+			sentences: 1,
+			expected:  strings.TrimSpace(strings.Repeat("word ", PREVIEW_MAX_LENGTH/5)) + PREVIEW_ELLIPSIS,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.expected, makePreview(tt.data, tt.sentences))
+		})
+	}
+}
+
+// Test that the summary view of /list leaves out the element tree but keeps previews
+func TestHandleListRequestSummary(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<document><title>Test Title</title><description>A short description. With more.</description></document>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	req := httptest.NewRequest("GET", "/list?view=summary", nil)
+	w := httptest.NewRecorder()
+	handleListRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.NotContains(t, w.Body.String(), "XMLData")
+
+	var docs []XMLDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, "A short description. With more.", docs[0].Preview)
+
+	req = httptest.NewRequest("GET", "/list?view=full", nil)
+	w = httptest.NewRecorder()
+	handleListRequest(db, w, req)
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
@@ -0,0 +1,118 @@
+package goapp
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	TRUSTED_PROXIES_ENV = "DOC_TRUSTED_PROXIES" // Environment variable with the comma-separated CIDRs or IPs of the reverse proxies in front of the server
+	TRUSTED_PROXY_UNIX  = "unix"                // Entry of TRUSTED_PROXIES_ENV trusting the peers of unix sockets, see DOC_LISTEN
+
+	FORWARDED_FOR_HEADER   = "X-Forwarded-For"   // Header a proxy appends the address of its client to
+	FORWARDED_PROTO_HEADER = "X-Forwarded-Proto" // Header with the scheme the client used, "http" or "https"
+	FORWARDED_HOST_HEADER  = "X-Forwarded-Host"  // Header with the host the client asked for
+)
+
+var (
+	trustedProxies     []*net.IPNet // trustedProxies are the networks whose X-Forwarded-* headers are honored
+	trustUnixProxies   bool         // trustUnixProxies honors the X-Forwarded-* headers of unix socket peers
+	forwardedProtocols = map[string]bool{"http": true, "https": true}
+)
+
+// initTrustedProxies reads the reverse proxies from the environment, none are trusted if it isn't set
+func initTrustedProxies() {
+	funcName := "initTrustedProxies"
+
+	var cidrs []string
+	for _, part := range strings.Split(os.Getenv(TRUSTED_PROXIES_ENV), ",") {
+		if strings.TrimSpace(part) == TRUSTED_PROXY_UNIX {
+			trustUnixProxies = true
+		} else {
+			cidrs = append(cidrs, part)
+		}
+	}
+	networks, err := parseCIDRs(strings.Join(cidrs, ","))
+	if err != nil {
+		log.Fatalf("%s: Invalid %s: %v", funcName, TRUSTED_PROXIES_ENV, err)
+	}
+	trustedProxies = networks
+}
+
+// isTrustedProxy reports whether ip is the address of a trusted proxy
+func isTrustedProxy(ip net.IP) bool {
+	return ip != nil && containsIP(trustedProxies, ip)
+}
+
+// fromTrustedProxy reports whether the request was sent by a trusted proxy, whose X-Forwarded-* headers are honored
+func fromTrustedProxy(r *http.Request) bool {
+	if _, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		return trustUnixProxies
+	}
+	return isTrustedProxy(peerIP(r))
+}
+
+// peerIP returns the IP the request came from, which is the proxy's if there is one
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// forwardedClientIP returns the client of a request from a trusted proxy: the last address of X-Forwarded-For
+// which isn't a trusted proxy, since the addresses before it may be made up by the client
+func forwardedClientIP(r *http.Request) net.IP {
+	var hops []string
+	for _, header := range r.Header.Values(FORWARDED_FOR_HEADER) {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// requestScheme returns the scheme the client used, as told by a trusted proxy
+func requestScheme(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get(FORWARDED_PROTO_HEADER), ",")[0]))
+		if forwardedProtocols[proto] {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHost returns the host the client asked for, as told by a trusted proxy
+func requestHost(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		host := strings.TrimSpace(strings.Split(r.Header.Get(FORWARDED_HOST_HEADER), ",")[0])
+		if host != "" && !strings.ContainsAny(host, "/\\@ ") {
+			return host
+		}
+	}
+	return r.Host
+}
+
+// absoluteURL returns the URL of the path of an endpoint as the client reaches the server, below the base path,
+// e.g. https://docs.example.com/xmlarchive/public/Xq3
+func absoluteURL(r *http.Request, path string) string {
+	return requestScheme(r) + "://" + requestHost(r) + linkPath(path)
+}
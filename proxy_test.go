@@ -0,0 +1,56 @@
+package goapp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test taking the client address, scheme and host from the headers of trusted proxies only
+func TestForwardedHeaders(t *testing.T) {
+	proxies, err := parseCIDRs("10.0.0.0/8")
+	require.NoError(t, err)
+	trustedProxies = proxies
+	defer func() { trustedProxies = nil }()
+
+	tests := []struct {
+		desc      string
+		remote    string
+		forwarded []string
+		client    string
+		url       string
+	}{
+		{desc: "direct", remote: "192.0.2.1:1234", client: "192.0.2.1", url: "http://example.com/public/a"},
+		{desc: "untrusted peer", remote: "192.0.2.1:1234", forwarded: []string{"198.51.100.7"}, client: "192.0.2.1", url: "http://example.com/public/a"},
+		{desc: "proxy", remote: "10.0.0.2:1234", forwarded: []string{"198.51.100.7"}, client: "198.51.100.7", url: "https://docs.example.com/public/a"},
+		{desc: "spoofed", remote: "10.0.0.2:1234", forwarded: []string{"203.0.113.9, 198.51.100.7", "10.0.0.3"}, client: "198.51.100.7", url: "https://docs.example.com/public/a"},
+		{desc: "invalid", remote: "10.0.0.2:1234", forwarded: []string{"unknown"}, client: "10.0.0.2", url: "https://docs.example.com/public/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/share?id=1", nil)
+			req.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				req.Header.Add(FORWARDED_FOR_HEADER, value)
+			}
+			req.Header.Set(FORWARDED_PROTO_HEADER, "https")
+			req.Header.Set(FORWARDED_HOST_HEADER, "docs.example.com")
+			require.Equal(t, tt.client, clientIP(req).String())
+			require.Equal(t, tt.url, absoluteURL(req, "/public/a"))
+		})
+	}
+
+	// Peers of unix sockets are trusted with the unix entry
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set(FORWARDED_FOR_HEADER, "198.51.100.7")
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/goapp.sock", Net: "unix"}))
+	require.Nil(t, clientIP(req))
+	trustUnixProxies = true
+	defer func() { trustUnixProxies = false }()
+	require.Equal(t, "198.51.100.7", clientIP(req).String())
+}
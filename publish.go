@@ -0,0 +1,51 @@
+package goapp
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	DB_PUBLISHAT_FIELD_NAME = "publish_at"     // Field name for publish_at (end of the embargo of the document) in SQLite table
+	DB_PUBLISHAT_INDEX_NAME = "doc_publish_at" // Index of publish_at, to find the last publication for the response cache
+
+	XML_PUBLISH_ATTRIBUTE = "publish" // Root element attribute holding the time the document is published at
+)
+
+// publishedCondition selects the documents which aren't embargoed at the time of its placeholder
+var publishedCondition = fmt.Sprintf("(%s IS NULL OR %s<=?)", DB_PUBLISHAT_FIELD_NAME, DB_PUBLISHAT_FIELD_NAME)
+
+// parsePublishAt parses a publication time in the formats of an expiry and returns it in the stored format
+func parsePublishAt(value string) (string, error) {
+	publishAt, err := parseExpiry(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid publication date: %s", value)
+	}
+	return publishAt, nil
+}
+
+// published reports whether the document may be read at now, documents without publication time always may
+func (doc *XMLDoc) published(now time.Time) bool {
+	return doc.PublishAt == "" || doc.PublishAt <= formatExpiry(now)
+}
+
+// getPublishedDocumentByID retrieves a document like getDocumentByID for the read endpoints
+// A document embargoed at now isn't found, so clients can't tell it from a missing one. A document in cold storage
+// is moved back to the table, so the following reads are fast again.
+func getPublishedDocumentByID(db *sql.DB, id string, now time.Time) (*XMLDoc, error) {
+	funcName := "getPublishedDocumentByID"
+
+	doc, err := getDocumentByID(db, id)
+	if err == nil && !doc.published(now) {
+		return nil, sql.ErrNoRows
+	}
+	if err == nil && doc.Tier == TIER_COLD {
+		// The document was read anyway, it stays cold if it can't be moved back
+		if err := rehydrateDocument(db, doc, now); err != nil {
+			log.Printf("%s: Failed to move document %s out of cold storage: %v", funcName, id, err)
+		}
+	}
+	return doc, err
+}
@@ -0,0 +1,78 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that embargoed documents are left out of the read endpoints until they are published
+func TestPublishAt(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	later := now.Add(time.Hour).Format(time.RFC3339)
+	add := func(query string, data string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("POST", "/add?"+query, strings.NewReader(data)))
+		return rr
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("GET", target, nil))
+		return rr
+	}
+
+	require.Equal(t, http.StatusCreated, add("publish_at="+later, "<document><title>Quarterly results</title></document>").Code)
+	require.Equal(t, http.StatusCreated, add("", `<document publish="2020-01-01"><title>Quarterly plan</title></document>`).Code)
+	require.Equal(t, http.StatusCreated, add("", `<document publish="`+later+`"><title>Quarterly outlook</title></document>`).Code)
+	require.Equal(t, http.StatusBadRequest, add("publish_at=soon", "<document><title>Never</title></document>").Code)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, later, doc.PublishAt)
+
+	rr := get("/list")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var docs []XMLDoc
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, "Quarterly plan", docs[0].Title)
+
+	require.Equal(t, http.StatusNotFound, get("/document?id=1").Code)
+	require.Equal(t, http.StatusNotFound, get("/query?id=3&xpath=/document/title").Code)
+	require.Equal(t, http.StatusNotFound, get("/overflow?id=1").Code)
+	require.Equal(t, http.StatusNotFound, get("/overflow?id=9").Code)
+	require.Equal(t, http.StatusNotFound, get("/sign?id=1").Code)
+	require.Equal(t, http.StatusOK, get("/document?id=2").Code)
+	require.Equal(t, http.StatusOK, get("/overflow?id=2").Code)
+	require.Equal(t, http.StatusOK, get("/sign?id=2").Code)
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("POST", "/documents/merge?ids=2,1", nil))
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.NotContains(t, rr.Body.String(), "Quarterly results")
+	var title struct {
+		Title string `xml:"title"`
+	}
+	require.True(t, errors.Is(decodeDocument(db, "1", &title), sql.ErrNoRows))
+	require.NoError(t, decodeDocument(db, "2", &title))
+	require.Equal(t, "Quarterly plan", title.Title)
+	suggestions, err := suggest(db, "quarterly", 10, now)
+	require.NoError(t, err)
+	require.Equal(t, []Suggestion{{Field: "title", Value: "Quarterly plan", Count: 1}}, suggestions)
+
+	// The documents become visible once their publication time has passed
+	published, err := listDocuments(db, now.Add(2*time.Hour), []string{DOC_STATE_ACTIVE}, "", DB_ID_FIELD_NAME)
+	require.NoError(t, err)
+	require.Len(t, published, 3)
+	require.True(t, doc.published(now.Add(2*time.Hour)))
+	require.False(t, publiclyVisible(doc, now))
+}
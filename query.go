@@ -0,0 +1,103 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// QueryResult is the response of /query
+type QueryResult struct {
+	ID     string
+	XPath  string
+	Values []string // Values are the values the path selects, in document order
+}
+
+// TextContent returns the text of the node and its descendants in document order, like the string value of an XPath element
+func (node *Node) TextContent() string {
+	var result strings.Builder
+	node.writeText(&result)
+	return result.String()
+}
+
+func (node *Node) writeText(out *strings.Builder) {
+	out.WriteString(node.Text)
+	for _, child := range node.Children {
+		child.writeText(out)
+		out.WriteString(child.Tail)
+	}
+}
+
+// Query returns the values the path of the XPath subset selects in the document
+// Elements give their text content, attributes their value and text() the text directly inside the elements.
+func (doc *XMLDoc) Query(path string) ([]string, error) {
+	xpath, err := parseXPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := doc.elementTree()
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	for _, node := range xpath.Select(tree) {
+		switch {
+		case xpath.Attribute != "":
+			values = append(values, node.Attrs[xpath.Attribute])
+		case xpath.Text:
+			values = append(values, node.directText())
+		default:
+			values = append(values, node.TextContent())
+		}
+	}
+	return values, nil
+}
+
+// handleQueryRequest returns the values an XPath selects in a document
+func handleQueryRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+	path := r.URL.Query().Get("xpath")
+	if path == "" {
+		http.Error(w, "xpath parameter is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := parseXPath(path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+	values, err := doc.Query(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to query document with ID %s: %v", id, err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(QueryResult{ID: id, XPath: path, Values: values})
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,84 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test querying values out of documents
+func TestDocumentQuery(t *testing.T) {
+	doc, err := parseDocument(`<document><metadata><author>Ann</author><author>Bob</author></metadata><item id="1">a<b>c</b></item><p>The <b>quick</b> fox</p></document>`)
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc     string
+		path     string
+		expected []string
+	}{
+		{desc: "elements", path: "/document/metadata/author", expected: []string{"Ann", "Bob"}},
+		{desc: "descendants", path: "//author[2]", expected: []string{"Bob"}},
+		{desc: "text content", path: "/document/item", expected: []string{"ac"}},
+		{desc: "text", path: "/document/item/text()", expected: []string{"a"}},
+		{desc: "mixed content", path: "/document/p", expected: []string{"The quick fox"}},
+		{desc: "attribute", path: "//item/@id", expected: []string{"1"}},
+		{desc: "no match", path: "/document/title", expected: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			values, err := doc.Query(tt.path)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, values)
+		})
+	}
+
+	// Documents without a stored tree are queried from their XML
+	doc.Tree = nil
+	values, err := doc.Query("//author")
+	require.NoError(t, err)
+	require.Equal(t, []string{"Ann", "Bob"}, values)
+
+	_, err = doc.Query("author")
+	require.EqualError(t, err, "invalid path author: only absolute paths are supported")
+}
+
+// Test querying stored documents through /query
+func TestHandleQueryRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<document><title>Test Title</title><author>John Doe</author></document>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	tests := []struct {
+		desc   string
+		query  string
+		status int
+	}{
+		{desc: "missing id", query: "xpath=/document", status: http.StatusBadRequest},
+		{desc: "missing xpath", query: "id=1", status: http.StatusBadRequest},
+		{desc: "invalid xpath", query: "id=1&xpath=document", status: http.StatusBadRequest},
+		{desc: "unknown document", query: "id=99&xpath=/document", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/query?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleQueryRequest(db, w, req)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/query?id=1&xpath="+url.QueryEscape("//author"), nil)
+	w := httptest.NewRecorder()
+	handleQueryRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var result QueryResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.Equal(t, QueryResult{ID: "1", XPath: "//author", Values: []string{"John Doe"}}, result)
+}
@@ -0,0 +1,118 @@
+package goapp
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_ENV    = "DOC_RATE_LIMIT" // Environment variable with the number of requests a client address may make per minute, 0 for unlimited
+	RATE_LIMIT_WINDOW = time.Minute      // Window requests are counted in
+)
+
+// RateLimiter limits the requests of every client address within fixed windows
+type RateLimiter struct {
+	mu          sync.Mutex
+	limit       int              // limit is the number of requests per window, 0 for unlimited
+	windowStart time.Time        // windowStart is the start of the current window
+	counts      map[string]int   // counts are the requests of each client in the current window
+	shared      *RedisClient     // shared counts the requests in Redis for all instances, nil to count them in memory
+	now         func() time.Time // now returns the current time, replaced in tests
+}
+
+// requestLimiter limits the requests of clients, unlimited unless configured by initRateLimit or the admin API
+var requestLimiter = newRateLimiter(0)
+
+// newRateLimiter creates a limiter allowing limit requests per window to each client
+func newRateLimiter(limit int) *RateLimiter {
+	return &RateLimiter{limit: limit, counts: map[string]int{}, now: time.Now}
+}
+
+// initRateLimit loads the rate limit from the environment
+func initRateLimit() {
+	funcName := "initRateLimit"
+
+	if value := os.Getenv(RATE_LIMIT_ENV); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			log.Fatalf("%s: %s must be a positive number or 0", funcName, RATE_LIMIT_ENV)
+		}
+		requestLimiter.SetLimit(limit)
+	}
+	// Instances sharing a Redis server enforce the limit together
+	requestLimiter.shared = sharedRedis
+}
+
+// Limit returns the number of requests a client may make per window, 0 for unlimited
+func (limiter *RateLimiter) Limit() int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.limit
+}
+
+// SetLimit changes the number of requests a client may make per window
+func (limiter *RateLimiter) SetLimit(limit int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.limit = limit
+}
+
+// Allow counts a request of the client and reports whether it is within the limit
+// If it isn't, it also returns the number of seconds until the next window starts.
+// With a shared Redis server the requests to all instances are counted, in memory while Redis fails.
+func (limiter *RateLimiter) Allow(client string) (bool, int) {
+	limiter.mu.Lock()
+	limit, shared, now := limiter.limit, limiter.shared, limiter.now()
+	limiter.mu.Unlock()
+
+	if limit <= 0 {
+		return true, 0
+	}
+	if shared != nil {
+		allowed, retryAfter, err := allowShared(shared, client, limit, now)
+		if err == nil {
+			return allowed, retryAfter
+		}
+		log.Printf("RateLimiter.Allow: Counting requests in memory, Redis failed: %v", err)
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if now.Sub(limiter.windowStart) >= RATE_LIMIT_WINDOW {
+		limiter.windowStart = now.Truncate(RATE_LIMIT_WINDOW)
+		limiter.counts = map[string]int{}
+	}
+	if limiter.counts[client] >= limit {
+		return false, retryAfter(limiter.windowStart, now)
+	}
+	limiter.counts[client]++
+	return true, 0
+}
+
+// allowShared counts a request of the client in Redis, under a key of the window which expires after it
+func allowShared(shared *RedisClient, client string, limit int, now time.Time) (bool, int, error) {
+	windowStart := now.Truncate(RATE_LIMIT_WINDOW)
+	key := shared.Key("ratelimit:" + strconv.FormatInt(windowStart.Unix(), 10) + ":" + client)
+	count, err := shared.Incr(key)
+	if err != nil {
+		return false, 0, err
+	}
+	if count == 1 {
+		if err := shared.Expire(key, 2*RATE_LIMIT_WINDOW); err != nil {
+			return false, 0, err
+		}
+	}
+	if count > int64(limit) {
+		return false, retryAfter(windowStart, now), nil
+	}
+	return true, 0, nil
+}
+
+// retryAfter returns the number of seconds until the window after the one starting at windowStart
+func retryAfter(windowStart time.Time, now time.Time) int {
+	return int(windowStart.Add(RATE_LIMIT_WINDOW).Sub(now).Seconds()) + 1
+}
@@ -0,0 +1,77 @@
+package goapp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test counting requests per client within fixed windows
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(2)
+	limiter.now = func() time.Time { return now }
+
+	tests := []struct {
+		desc       string
+		client     string
+		advance    time.Duration
+		allowed    bool
+		retryAfter int
+	}{
+		{desc: "first", client: "192.0.2.1", allowed: true},
+		{desc: "second", client: "192.0.2.1", allowed: true},
+		{desc: "over the limit", client: "192.0.2.1", advance: 15 * time.Second, retryAfter: 46},
+		{desc: "other client", client: "192.0.2.2", allowed: true},
+		{desc: "next window", client: "192.0.2.1", advance: 45 * time.Second, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			allowed, retryAfter := limiter.Allow(tt.client)
+			require.Equal(t, tt.allowed, allowed)
+			require.Equal(t, tt.retryAfter, retryAfter)
+		})
+	}
+
+	// A limit of 0 lets every request through
+	limiter.SetLimit(0)
+	for i := 0; i < 5; i++ {
+		allowed, _ := limiter.Allow("192.0.2.1")
+		require.True(t, allowed)
+	}
+}
+
+// Test counting requests in Redis, shared by the instances
+func TestRateLimiterShared(t *testing.T) {
+	client, err := newRedisClient(fakeRedis(t))
+	require.NoError(t, err)
+
+	now := time.Date(2024, 5, 1, 12, 0, 15, 0, time.UTC)
+	first, second := newRateLimiter(2), newRateLimiter(2)
+	for _, limiter := range []*RateLimiter{first, second} {
+		limiter.shared = client
+		limiter.now = func() time.Time { return now }
+	}
+
+	allowed, _ := first.Allow("192.0.2.1")
+	require.True(t, allowed)
+	allowed, _ = second.Allow("192.0.2.1")
+	require.True(t, allowed)
+	allowed, retryAfter := first.Allow("192.0.2.1")
+	require.False(t, allowed)
+	require.Equal(t, 46, retryAfter)
+
+	// The next window has its own counter
+	now = now.Add(time.Minute)
+	allowed, _ = second.Allow("192.0.2.1")
+	require.True(t, allowed)
+
+	// Requests are counted in memory while Redis is down
+	down, err := newRedisClient("redis://127.0.0.1:1")
+	require.NoError(t, err)
+	first.shared = down
+	allowed, _ = first.Allow("192.0.2.1")
+	require.True(t, allowed)
+}
@@ -0,0 +1,276 @@
+package goapp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REDIS_URL_ENV     = "DOC_REDIS_URL" // Environment variable with the URL of the Redis server instances share rate limits and idempotency keys in
+	REDIS_TIMEOUT     = 2 * time.Second // Timeout of connecting to Redis and of a command
+	REDIS_DEFAULT_KEY = "goapp:"        // Prefix of the keys the service stores in Redis
+)
+
+// ErrRedisNil is returned for replies without value, like GET of a missing key
+var ErrRedisNil = errors.New("redis: nil")
+
+// sharedRedis is the Redis server shared by the instances, nil unless REDIS_URL_ENV is set
+var sharedRedis *RedisClient
+
+// initRedis sets up the client of the shared Redis server from the environment
+func initRedis() {
+	funcName := "initRedis"
+
+	value := os.Getenv(REDIS_URL_ENV)
+	if value == "" {
+		return
+	}
+	client, err := newRedisClient(value)
+	if err != nil {
+		log.Fatalf("%s: Invalid %s: %v", funcName, REDIS_URL_ENV, err)
+	}
+	sharedRedis = client
+}
+
+// RedisClient sends commands to a Redis server over a single connection, speaking RESP
+// Commands are sent one at a time; the connection is opened again after an error.
+type RedisClient struct {
+	Addr     string // Addr is the host:port of the server
+	Password string
+	DB       int    // DB is the database selected after connecting
+	Prefix   string // Prefix is put before all keys, so several services can share a server
+	Breaker  *CircuitBreaker
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newRedisClient parses a URL like redis://:password@host:6379/0
+func newRedisClient(rawURL string) (*RedisClient, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "redis" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid Redis URL %s, expected redis://host:port/db", rawURL)
+	}
+	client := &RedisClient{Addr: parsed.Host, Prefix: REDIS_DEFAULT_KEY, Breaker: newCircuitBreaker("redis")}
+	if parsed.Port() == "" {
+		client.Addr = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if password, ok := parsed.User.Password(); ok {
+		client.Password = password
+	}
+	if db := strings.Trim(parsed.Path, "/"); db != "" {
+		client.DB, err = strconv.Atoi(db)
+		if err != nil || client.DB < 0 {
+			return nil, fmt.Errorf("invalid Redis database %s", db)
+		}
+	}
+	return client, nil
+}
+
+// Key returns the key of name with the prefix of the client
+func (client *RedisClient) Key(name string) string {
+	return client.Prefix + name
+}
+
+// Do sends a command and returns its reply: a string, an int64, nil or a []interface{} of those
+// Error replies are returned as errors, and a missing value as ErrRedisNil.
+func (client *RedisClient) Do(args ...string) (interface{}, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	var reply interface{}
+	var replyErr error
+	err := client.Breaker.Call(func() error {
+		var err error
+		reply, err = client.do(args)
+		var redisError redisError
+		if err != nil && !errors.As(err, &redisError) && !errors.Is(err, ErrRedisNil) {
+			// The connection is in an unknown state after a network error
+			client.close()
+			return err
+		}
+		// Error replies come from a healthy server
+		replyErr = err
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, replyErr
+}
+
+func (client *RedisClient) do(args []string) (interface{}, error) {
+	if client.conn == nil {
+		if err := client.connect(); err != nil {
+			return nil, err
+		}
+	}
+	client.conn.SetDeadline(time.Now().Add(REDIS_TIMEOUT))
+	if err := writeRedisCommand(client.conn, args); err != nil {
+		return nil, err
+	}
+	return readRedisReply(client.reader)
+}
+
+// connect opens the connection, authenticates and selects the database
+func (client *RedisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", client.Addr, REDIS_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	client.conn, client.reader = conn, bufio.NewReader(conn)
+	if client.Password != "" {
+		if _, err := client.do([]string{"AUTH", client.Password}); err != nil {
+			client.close()
+			return fmt.Errorf("redis authentication: %v", err)
+		}
+	}
+	if client.DB != 0 {
+		if _, err := client.do([]string{"SELECT", strconv.Itoa(client.DB)}); err != nil {
+			client.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (client *RedisClient) close() {
+	if client.conn != nil {
+		client.conn.Close()
+		client.conn, client.reader = nil, nil
+	}
+}
+
+// Get returns the value of key, ErrRedisNil if it isn't set
+func (client *RedisClient) Get(key string) (string, error) {
+	reply, err := client.Do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	value, _ := reply.(string)
+	return value, nil
+}
+
+// Set stores value under key, expiring after ttl unless it is 0
+func (client *RedisClient) Set(key string, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := client.Do(args...)
+	return err
+}
+
+// SetNX stores value under key unless it is set, expiring after ttl, and reports whether it was stored
+func (client *RedisClient) SetNX(key string, value string, ttl time.Duration) (bool, error) {
+	_, err := client.Do("SET", key, value, "PX", strconv.FormatInt(ttl.Milliseconds(), 10), "NX")
+	if errors.Is(err, ErrRedisNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Expire lets key expire after ttl
+func (client *RedisClient) Expire(key string, ttl time.Duration) error {
+	_, err := client.Do("PEXPIRE", key, strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Del removes key
+func (client *RedisClient) Del(key string) error {
+	_, err := client.Do("DEL", key)
+	return err
+}
+
+// Incr increments the counter of key and returns its new value
+func (client *RedisClient) Incr(key string) (int64, error) {
+	reply, err := client.Do("INCR", key)
+	if err != nil {
+		return 0, err
+	}
+	count, _ := reply.(int64)
+	return count, nil
+}
+
+// redisError is an error reply of the server, like "WRONGTYPE ..."
+type redisError string
+
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// writeRedisCommand writes a command as an array of bulk strings
+func writeRedisCommand(w io.Writer, args []string) error {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, command.String())
+	return err
+}
+
+// readRedisReply reads a reply of the server
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if size < 0 {
+			return nil, ErrRedisNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if count < 0 {
+			return nil, ErrRedisNil
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			items[i], err = readRedisReply(r)
+			if err != nil && !errors.Is(err, ErrRedisNil) {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,126 @@
+package goapp
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	REPORT_EMAIL_ENV    = "DOC_REPORT_EMAIL"    // Environment variable with the comma-separated addresses ingestion reports are mailed to
+	REPORT_INTERVAL_ENV = "DOC_REPORT_INTERVAL" // Environment variable with the time between two ingestion reports, e.g. "24h"
+
+	REPORT_DEFAULT_INTERVAL = 24 * time.Hour // Time between two ingestion reports by default
+)
+
+// IngestionReport sums up the ingestion of the sources since the previous report
+type IngestionReport struct {
+	From      string // From is the time of the previous report, empty for the first report which has the total counts
+	To        string
+	Documents int64
+	Failures  int64
+	Sources   []SourceStats // Sources hold the counts of the sources which ingested or failed since the previous report
+}
+
+// Reporter mails ingestion reports at a fixed interval
+type Reporter struct {
+	Recipients []string
+	Interval   time.Duration
+	Mailer     *Mailer
+
+	last   map[string]SourceStats // last holds the counters of the sources at the previous report
+	lastAt time.Time
+}
+
+// reporter sends the scheduled reports of the server, nil unless configured by initReports
+var reporter *Reporter
+
+// initReports sets up scheduled ingestion reports if recipients are configured
+func initReports() {
+	funcName := "initReports"
+
+	recipients := os.Getenv(REPORT_EMAIL_ENV)
+	if recipients == "" {
+		return
+	}
+	if mailer == nil {
+		log.Fatalf("%s: %s and %s are required to mail reports", funcName, SMTP_ADDR_ENV, SMTP_FROM_ENV)
+	}
+
+	interval := REPORT_DEFAULT_INTERVAL
+	if value := os.Getenv(REPORT_INTERVAL_ENV); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("%s: %s must be a positive duration like 24h", funcName, REPORT_INTERVAL_ENV)
+		}
+		interval = parsed
+	}
+
+	var addrs []string
+	for _, addr := range strings.Split(recipients, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	reporter = &Reporter{Recipients: addrs, Interval: interval, Mailer: mailer}
+}
+
+// summarize sums up the ingestion of the sources between the previous report and now from their counters
+func (reporter *Reporter) summarize(sources []SourceStats, now time.Time) IngestionReport {
+	report := IngestionReport{To: formatExpiry(now), Sources: []SourceStats{}}
+	if !reporter.lastAt.IsZero() {
+		report.From = formatExpiry(reporter.lastAt)
+	}
+	for _, source := range sources {
+		previous := reporter.last[source.Name]
+		source.Documents -= previous.Documents
+		source.Failures -= previous.Failures
+		source.Bytes -= previous.Bytes
+		if source.Documents == 0 && source.Failures == 0 {
+			continue
+		}
+		report.Documents += source.Documents
+		report.Failures += source.Failures
+		report.Sources = append(report.Sources, source)
+	}
+	return report
+}
+
+// Send builds the report until now and mails it to the recipients
+// The counts of the next report start at now only if the report was sent.
+func (reporter *Reporter) Send(db *sql.DB, now time.Time) error {
+	sources, err := listSources(db)
+	if err != nil {
+		return err
+	}
+	report := reporter.summarize(sources, now)
+	if err := reporter.Mailer.Send(reporter.Recipients, "report", report); err != nil {
+		return err
+	}
+
+	reporter.last = map[string]SourceStats{}
+	for _, source := range sources {
+		reporter.last[source.Name] = source
+	}
+	reporter.lastAt = now
+	return nil
+}
+
+// runReporter mails an ingestion report every interval, on a single instance if several share the database
+func runReporter(db *sql.DB, reporter *Reporter) {
+	funcName := "runReporter"
+
+	ticker := time.NewTicker(reporter.Interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if !leaderElector.Lead(db, JOB_REPORTER, now, 2*reporter.Interval) {
+			continue
+		}
+		if err := reporter.Send(db, now); err != nil {
+			log.Printf("%s: Failed to send the ingestion report: %v", funcName, err)
+		}
+	}
+}
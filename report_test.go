@@ -0,0 +1,54 @@
+package goapp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test mailing ingestion reports with the counts since the previous report
+func TestReporterSend(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var sent []string
+	var sendErr error
+	defer func(previous func(*Mailer, []string, []byte) error) { sendMail = previous }(sendMail)
+	sendMail = func(mailer *Mailer, to []string, message []byte) error {
+		sent = append(sent, string(message))
+		return sendErr
+	}
+
+	mailer, err := newMailer("mail.example.com:587", "reports@example.com", SMTP_TLS_STARTTLS, "")
+	require.NoError(t, err)
+	reporter := &Reporter{Recipients: []string{"team@example.com"}, Interval: 24 * time.Hour, Mailer: mailer}
+
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, recordIngest(db, "dir:./feed", 100, nil, start))
+	require.NoError(t, recordIngest(db, "key:api", 0, errors.New("tag pairing error"), start))
+	require.NoError(t, reporter.Send(db, start.Add(time.Hour)))
+	require.Len(t, sent, 1)
+	require.Contains(t, sent[0], "Subject: Ingestion report 2024-05-01T01:00:00Z\r\n")
+	require.Contains(t, sent[0], "\r\n\r\nIngestion until 2024-05-01T01:00:00Z: 1 documents, 1 failures\r\n\r\n"+
+		"dir:./feed: 1 documents, 0 failures, 100 bytes\r\n"+
+		"key:api: 0 documents, 1 failures, 0 bytes\r\n  last error: tag pairing error\r\n")
+
+	// Only the sources which ingested since the previous report are listed
+	require.NoError(t, recordIngest(db, "dir:./feed", 50, nil, start.Add(2*time.Hour)))
+	sources, err := listSources(db)
+	require.NoError(t, err)
+	report := reporter.summarize(sources, start.Add(25*time.Hour))
+	require.Equal(t, "2024-05-01T01:00:00Z", report.From)
+	require.Equal(t, int64(1), report.Documents)
+	require.Len(t, report.Sources, 1)
+	require.Equal(t, int64(50), report.Sources[0].Bytes)
+
+	// The counts of a report which failed to be sent are kept for the next one
+	sendErr = errors.New("connection refused")
+	require.Error(t, reporter.Send(db, start.Add(25*time.Hour)))
+	sendErr = nil
+	require.NoError(t, reporter.Send(db, start.Add(49*time.Hour)))
+	require.Contains(t, sent[2], "Ingestion from 2024-05-01T01:00:00Z until 2024-05-03T01:00:00Z: 1 documents, 0 failures")
+}
@@ -0,0 +1,363 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REPROCESS_PROGRESS_EVERY = 100 // Number of documents between two progress log lines
+	REPROCESS_MAX_FAILURES   = 100 // Number of failed documents listed in the report
+)
+
+// ErrReprocessRunning is returned when reprocessing is started while a previous run hasn't finished
+var ErrReprocessRunning = errors.New("reprocessing already running")
+
+// ReprocessFailure describes a document which couldn't be reprocessed
+type ReprocessFailure struct {
+	ID    string
+	Error string
+}
+
+// ReprocessReport is the progress report of a reprocessing run
+type ReprocessReport struct {
+	Running    bool
+	States     []string // States are the states of the documents being reprocessed
+	Outdated   bool     // Outdated is set if only documents parsed by another parser version are reprocessed
+	Version    string   // Version is the parser version stamped on reprocessed documents
+	Total      int      // Total is the number of documents to reprocess
+	Processed  int      // Processed is the number of documents reprocessed so far, including failed ones
+	Updated    int      // Updated is the number of documents whose metadata changed
+	Failed     int
+	Failures   []ReprocessFailure // Failures lists the first REPROCESS_MAX_FAILURES failed documents
+	StartedAt  string
+	FinishedAt string `json:",omitempty"`
+	Error      string `json:",omitempty"` // Error is set if the run stopped before all documents were processed
+}
+
+// reprocessJob tracks the single reprocessing run which may be active at a time
+type reprocessJob struct {
+	mu     sync.Mutex
+	report ReprocessReport
+}
+
+// reprocessing is the job shared by the admin endpoint
+var reprocessing = &reprocessJob{}
+
+// start marks the job as running with a fresh report
+func (job *reprocessJob) start(states []string, outdated bool, now time.Time) error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if job.report.Running {
+		return ErrReprocessRunning
+	}
+	job.report = ReprocessReport{
+		Running:   true,
+		States:    states,
+		Outdated:  outdated,
+		Version:   parserVersion,
+		Failures:  []ReprocessFailure{},
+		StartedAt: formatExpiry(now),
+	}
+	return nil
+}
+
+// update changes the report while holding the lock
+func (job *reprocessJob) update(fn func(report *ReprocessReport)) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	fn(&job.report)
+}
+
+// Report returns a copy of the current report
+func (job *reprocessJob) Report() ReprocessReport {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	report := job.report
+	report.Failures = append([]ReprocessFailure(nil), job.report.Failures...)
+	return report
+}
+
+// listDocumentIDs retrieves the IDs of all documents in one of the given states, expired or not
+// If outdated is set, documents parsed by the current parser version are left out
+func listDocumentIDs(db *sql.DB, states []string, outdated bool) ([]string, error) {
+	defer observeQuery("listDocumentIDs", time.Now())
+
+	placeholders := make([]string, len(states))
+	args := make([]interface{}, 0, len(states)+1)
+	for i, state := range states {
+		placeholders[i] = "?"
+		args = append(args, state)
+	}
+	condition := ""
+	if outdated {
+		condition = fmt.Sprintf("AND (%s IS NULL OR %s!=?)", DB_PARSERVERSION_FIELD_NAME, DB_PARSERVERSION_FIELD_NAME)
+		args = append(args, parserVersion)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s WHERE %s IN (%s) %s ORDER BY %s
+	`, DB_ID_FIELD_NAME, DB_TABLE_NAME, DB_STATE_FIELD_NAME, strings.Join(placeholders, ", "), condition, DB_ID_FIELD_NAME)
+	var ids []string
+	err := withDBRetry(func() error {
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		ids = []string{}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	return ids, err
+}
+
+// sqlExecer is implemented by both *sql.DB and *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlQueryer is implemented by both *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// updateDocumentMetadata replaces the parsed fields and parser version of a document, keeping its ID and state
+func updateDocumentMetadata(db *sql.DB, id string, doc XMLDoc) error {
+	defer observeQuery("updateDocumentMetadata", time.Now())
+
+	return withDBRetry(func() error {
+		return updateMetadata(db, id, doc)
+	})
+}
+
+// updateMetadata runs the update of updateDocumentMetadata on db or a transaction
+func updateMetadata(db sqlExecer, id string, doc XMLDoc) error {
+	langData, err := encodeLangVariants(doc.Variants)
+	if err != nil {
+		return err
+	}
+	tree, err := encodeTree(doc.Tree)
+	if err != nil {
+		return err
+	}
+	violations, err := encodeViolations(doc.Validation.Violations)
+	if err != nil {
+		return err
+	}
+	authors, err := encodeValues(doc.Authors)
+	if err != nil {
+		return err
+	}
+	custom, err := encodeCustomFields(doc.Custom)
+	if err != nil {
+		return err
+	}
+	paths, err := encodeValues(doc.Paths)
+	if err != nil {
+		return err
+	}
+	var expiresAt sql.NullString
+	if doc.ExpiresAt != "" {
+		expiresAt = sql.NullString{String: doc.ExpiresAt, Valid: true}
+	}
+	var language sql.NullString
+	if doc.Language != "" {
+		language = sql.NullString{String: doc.Language, Valid: true}
+	}
+	processingLog, err := encodeProcessingLog(doc.ProcessingLog)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE %s SET %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=?, %s=NULL WHERE %s=?
+	`, DB_TABLE_NAME, DB_TITLE_FIELD_NAME, DB_DESCRIPTION_FIELD_NAME, DB_AUTHOR_FIELD_NAME, DB_CREATEDAT_FIELD_NAME, DB_CREATEDOFFSET_FIELD_NAME, DB_DATEPROFILE_FIELD_NAME,
+		DB_WORDCOUNT_FIELD_NAME, DB_CHARCOUNT_FIELD_NAME, DB_ELEMENTCOUNT_FIELD_NAME, DB_MAXDEPTH_FIELD_NAME, DB_PREVIEW_FIELD_NAME, DB_TREE_FIELD_NAME, DB_XMLDATA_FIELD_NAME, DB_LANGDATA_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, DB_PARSERVERSION_FIELD_NAME,
+		DB_VALIDATIONSCHEMA_FIELD_NAME, DB_VALIDATIONSTATUS_FIELD_NAME, DB_VALIDATIONVIOLATIONS_FIELD_NAME, DB_VALIDATEDAT_FIELD_NAME, DB_PROLOG_FIELD_NAME, DB_DOCTYPE_FIELD_NAME, DB_AUTHORS_FIELD_NAME, DB_CUSTOM_FIELD_NAME, DB_CANONICALHASH_FIELD_NAME, DB_XMLPATHS_FIELD_NAME, DB_LANGUAGE_FIELD_NAME, DB_PROCESSINGLOG_FIELD_NAME, DB_TIER_FIELD_NAME, DB_ID_FIELD_NAME)
+	_, err = db.Exec(query, doc.Title, doc.Description, doc.Author, doc.CreatedAt, doc.CreatedOffset, doc.DateProfile,
+		doc.Stats.Words, doc.Stats.Characters, doc.Stats.Elements, doc.Stats.MaxDepth, doc.Preview, tree, strings.Join(doc.XMLData, SPLIT_XMLDATA_STR), langData, expiresAt, doc.ParserVersion,
+		doc.Validation.Schema, doc.Validation.Status, violations, doc.Validation.ValidatedAt, doc.Prolog, doc.Doctype, authors, custom, doc.CanonicalHash, paths, language, processingLog, id)
+	return err
+}
+
+// sameMetadata reports whether reprocessing a document left its parsed fields and parser version unchanged
+func sameMetadata(stored XMLDoc, parsed XMLDoc) bool {
+	storedLang, _ := encodeLangVariants(stored.Variants)
+	parsedLang, _ := encodeLangVariants(parsed.Variants)
+	storedTree, _ := encodeTree(stored.Tree)
+	storedCustom, _ := encodeCustomFields(stored.Custom)
+	parsedCustom, _ := encodeCustomFields(parsed.Custom)
+	parsedTree, _ := encodeTree(parsed.Tree)
+	return stored.Title == parsed.Title &&
+		stored.Description == parsed.Description &&
+		stored.Author == parsed.Author &&
+		strings.Join(stored.Authors, "\n") == strings.Join(parsed.Authors, "\n") &&
+		storedCustom == parsedCustom &&
+		stored.CreatedAt == parsed.CreatedAt &&
+		stored.CreatedOffset == parsed.CreatedOffset &&
+		stored.DateProfile == parsed.DateProfile &&
+		stored.Stats == parsed.Stats &&
+		stored.Preview == parsed.Preview &&
+		stored.ExpiresAt == parsed.ExpiresAt &&
+		stored.ParserVersion == parsed.ParserVersion &&
+		storedLang == parsedLang &&
+		storedTree == parsedTree &&
+		sameValidation(stored.Validation, parsed.Validation) &&
+		stored.Prolog == parsed.Prolog &&
+		stored.Doctype == parsed.Doctype &&
+		stored.Language == parsed.Language &&
+		len(stored.ProcessingLog) == len(parsed.ProcessingLog) &&
+		stored.CanonicalHash == parsed.CanonicalHash &&
+		strings.Join(stored.XMLData, SPLIT_XMLDATA_STR) == strings.Join(parsed.XMLData, SPLIT_XMLDATA_STR) &&
+		strings.Join(stored.Paths, "\n") == strings.Join(parsed.Paths, "\n")
+}
+
+// reprocessDocument parses the stored XML of a document again and updates its metadata
+// It reports whether the metadata changed
+func reprocessDocument(db *sql.DB, id string) (bool, error) {
+	stored, err := getDocumentByID(db, id)
+	if err != nil {
+		return false, err
+	}
+
+	// The stored XML already had its whitespace handled when the document was added
+	parsed, err := parseDocumentFromWithOptions(stored.rawXML(), "reprocess:"+id, stored.storedOptions())
+	if err != nil {
+		return false, err
+	}
+	// Dates are parsed with the profile of the original source
+	if parsed.DateProfile == "" {
+		applyDateProfile(parsed, stored.DateProfile)
+	}
+	// Rules added since the document was stored may change it, which is logged after the earlier changes
+	parsed.ProcessingLog = append(append([]ProcessingEntry{}, stored.ProcessingLog...), parsed.ProcessingLog...)
+	if sameMetadata(*stored, *parsed) {
+		return false, nil
+	}
+
+	err = updateDocumentMetadata(db, id, *parsed)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reprocessDocuments re-runs parsing over all documents in one of the given states, recording progress in job
+// If outdated is set, only documents parsed by another parser version are reprocessed
+func reprocessDocuments(db *sql.DB, states []string, outdated bool, job *reprocessJob) {
+	funcName := "reprocessDocuments"
+
+	defer job.update(func(report *ReprocessReport) {
+		report.Running = false
+		report.FinishedAt = formatExpiry(time.Now())
+	})
+
+	ids, err := listDocumentIDs(db, states, outdated)
+	if err != nil {
+		log.Printf("%s: Failed to list documents: %v", funcName, err)
+		job.update(func(report *ReprocessReport) { report.Error = err.Error() })
+		return
+	}
+	job.update(func(report *ReprocessReport) { report.Total = len(ids) })
+	log.Printf("%s: Reprocessing %d documents", funcName, len(ids))
+
+	for i, id := range ids {
+		updated, err := reprocessDocument(db, id)
+		if err != nil {
+			metrics.inc("reprocess_errors_total")
+			log.Printf("%s: Failed to reprocess document %s: %v", funcName, id, err)
+		}
+
+		job.update(func(report *ReprocessReport) {
+			report.Processed++
+			if err != nil {
+				report.Failed++
+				if len(report.Failures) < REPROCESS_MAX_FAILURES {
+					report.Failures = append(report.Failures, ReprocessFailure{ID: id, Error: err.Error()})
+				}
+			} else if updated {
+				report.Updated++
+			}
+		})
+
+		if (i+1)%REPROCESS_PROGRESS_EVERY == 0 {
+			log.Printf("%s: Reprocessed %d of %d documents", funcName, i+1, len(ids))
+		}
+	}
+
+	report := job.Report()
+	log.Printf("%s: Reprocessed %d documents, %d updated, %d failed", funcName, report.Processed, report.Updated, report.Failed)
+}
+
+// handleReprocessRequest starts reprocessing on POST and returns the progress report on GET
+// POST takes an optional ?state=a,b filter, all states are reprocessed by default, and ?outdated=true
+// to skip documents already parsed by the current parser version
+func handleReprocessRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		states := documentStates
+		if param := r.URL.Query().Get("state"); param != "" {
+			states = strings.Split(param, ",")
+			for _, state := range states {
+				if !isValidState(state) {
+					http.Error(w, fmt.Sprintf("Invalid state %s", state), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		outdated := false
+		if param := r.URL.Query().Get("outdated"); param != "" {
+			value, err := strconv.ParseBool(param)
+			if err != nil {
+				http.Error(w, "outdated must be true or false", http.StatusBadRequest)
+				return
+			}
+			outdated = value
+		}
+
+		err := reprocessing.start(states, outdated, time.Now())
+		if err != nil {
+			http.Error(w, "Reprocessing is already running", http.StatusConflict)
+			return
+		}
+		go reprocessDocuments(db, states, outdated, reprocessing)
+		status = http.StatusAccepted
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(reprocessing.Report())
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
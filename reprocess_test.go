@@ -0,0 +1,112 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test re-running parsing over stored documents
+func TestReprocessDocuments(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		"<doc><title>First</title><author>Ann</author></doc>",
+		"<doc><title>Second</title></doc>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+	// A document without stored XML can't be parsed again
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Broken"}))
+	require.NoError(t, transitionDocument(db, "2", DOC_STATE_ARCHIVED))
+
+	// Simulate metadata extracted by an older parser
+	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET %s='Stale', %s='', %s=NULL WHERE %s=1", DB_TABLE_NAME, DB_TITLE_FIELD_NAME, DB_AUTHOR_FIELD_NAME, DB_PARSERVERSION_FIELD_NAME, DB_ID_FIELD_NAME))
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc     string
+		states   []string
+		outdated bool
+		expected ReprocessReport
+	}{
+		{
+			desc:     "archived only",
+			states:   []string{DOC_STATE_ARCHIVED},
+			expected: ReprocessReport{Total: 1, Processed: 1},
+		}, {
+			desc:     "outdated only",
+			states:   []string{DOC_STATE_ACTIVE, DOC_STATE_ARCHIVED},
+			outdated: true,
+			expected: ReprocessReport{Total: 2, Processed: 2, Updated: 1, Failed: 1},
+		}, {
+			desc:     "all states",
+			states:   []string{DOC_STATE_ACTIVE, DOC_STATE_ARCHIVED},
+			expected: ReprocessReport{Total: 3, Processed: 3, Failed: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			job := &reprocessJob{}
+			require.NoError(t, job.start(tt.states, tt.outdated, time.Now()))
+			require.ErrorIs(t, job.start(tt.states, tt.outdated, time.Now()), ErrReprocessRunning)
+
+			reprocessDocuments(db, tt.states, tt.outdated, job)
+
+			report := job.Report()
+			require.False(t, report.Running)
+			require.NotEmpty(t, report.FinishedAt)
+			require.Equal(t, tt.expected.Total, report.Total)
+			require.Equal(t, tt.expected.Processed, report.Processed)
+			require.Equal(t, tt.expected.Updated, report.Updated)
+			require.Equal(t, tt.expected.Failed, report.Failed)
+			require.Len(t, report.Failures, tt.expected.Failed)
+		})
+	}
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "First", doc.Title)
+	require.Equal(t, "Ann", doc.Author)
+	require.Equal(t, DOC_STATE_ACTIVE, doc.State)
+	require.Equal(t, parserVersion, doc.ParserVersion)
+}
+
+// Test starting reprocessing and reading its report over HTTP
+func TestHandleReprocessRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		desc           string
+		method         string
+		query          string
+		expectedStatus int
+	}{
+		{desc: "invalid state", method: "POST", query: "?state=gone", expectedStatus: http.StatusBadRequest},
+		{desc: "invalid outdated", method: "POST", query: "?outdated=maybe", expectedStatus: http.StatusBadRequest},
+		{desc: "invalid method", method: "DELETE", expectedStatus: http.StatusMethodNotAllowed},
+		{desc: "report", method: "GET", expectedStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/reprocess"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleReprocessRequest(db, w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Result().StatusCode)
+			if tt.expectedStatus == http.StatusOK {
+				var report ReprocessReport
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			}
+		})
+	}
+}
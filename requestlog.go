@@ -0,0 +1,218 @@
+package goapp
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LOG_SAMPLE_RATE_ENV = "DOC_LOG_SAMPLE_RATE" // Environment variable with the share of requests to log, between 0 and 1
+	LOG_MAX_BODY_ENV    = "DOC_LOG_MAX_BODY"    // Environment variable with the number of body bytes logged per request
+	LOG_REDACT_ENV      = "DOC_LOG_REDACT"      // Environment variable with the comma-separated elements whose text is redacted
+	LOG_LEVEL_ENV       = "DOC_LOG_LEVEL"       // Environment variable with the least severe level which is logged
+
+	LOG_LEVEL_DEBUG = "debug" // Level logging every request
+	LOG_LEVEL_INFO  = "info"  // Level logging the sampled requests, the default
+	LOG_LEVEL_WARN  = "warn"  // Level logging slow queries and parses, but no requests
+	LOG_LEVEL_ERROR = "error" // Level logging only failures
+
+	LOG_DEFAULT_MAX_BODY = 2048         // Number of body bytes logged per request by default
+	LOG_REDACTED         = "[REDACTED]" // Replacement for redacted content
+)
+
+// RequestLogConfig configures the request logging middleware
+type RequestLogConfig struct {
+	SampleRate float64        // SampleRate is the share of requests which are logged, 0 disables logging
+	MaxBody    int            // MaxBody is the number of body bytes logged per request
+	Redact     []string       // Redact lists the elements whose text content is replaced in logged bodies
+	redactExpr *regexp.Regexp // redactExpr matches the elements of Redact, built by compile
+}
+
+// requestLogConfig is the configuration of the request logging middleware, set by initRequestLogging
+var requestLogConfig = RequestLogConfig{}
+
+// logLevels lists the log levels from the most to the least verbose
+var logLevels = []string{LOG_LEVEL_DEBUG, LOG_LEVEL_INFO, LOG_LEVEL_WARN, LOG_LEVEL_ERROR}
+
+// logLevel is the index in logLevels of the current level, it can be changed at runtime with the admin API
+var logLevel = func() *atomic.Int32 {
+	level := &atomic.Int32{}
+	level.Store(1)
+	return level
+}()
+
+// emailPattern matches e-mail addresses, which are always redacted
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// initRequestLogging loads the request logging configuration from the environment
+func initRequestLogging() {
+	funcName := "initRequestLogging"
+
+	config := RequestLogConfig{MaxBody: LOG_DEFAULT_MAX_BODY}
+	if value := os.Getenv(LOG_SAMPLE_RATE_ENV); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Fatalf("%s: %s must be between 0 and 1", funcName, LOG_SAMPLE_RATE_ENV)
+		}
+		config.SampleRate = rate
+	}
+	if value := os.Getenv(LOG_MAX_BODY_ENV); value != "" {
+		maxBody, err := strconv.Atoi(value)
+		if err != nil || maxBody < 0 {
+			log.Fatalf("%s: %s must be a positive number", funcName, LOG_MAX_BODY_ENV)
+		}
+		config.MaxBody = maxBody
+	}
+	for _, name := range strings.Split(os.Getenv(LOG_REDACT_ENV), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			config.Redact = append(config.Redact, name)
+		}
+	}
+
+	config.compile()
+	requestLogConfig = config
+
+	if value := os.Getenv(LOG_LEVEL_ENV); value != "" {
+		if err := setLogLevel(value); err != nil {
+			log.Fatalf("%s: %s %v", funcName, LOG_LEVEL_ENV, err)
+		}
+	}
+}
+
+// setLogLevel changes the least severe level which is logged
+func setLogLevel(name string) error {
+	for i, level := range logLevels {
+		if level == name {
+			logLevel.Store(int32(i))
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of %s", strings.Join(logLevels, ", "))
+}
+
+// currentLogLevel returns the name of the least severe level which is logged
+func currentLogLevel() string {
+	return logLevels[logLevel.Load()]
+}
+
+// logEnabled reports whether messages of the level are logged
+func logEnabled(name string) bool {
+	for i, level := range logLevels {
+		if level == name {
+			return int32(i) >= logLevel.Load()
+		}
+	}
+	return true
+}
+
+// compile builds the expression matching the redacted elements
+func (config *RequestLogConfig) compile() {
+	config.redactExpr = nil
+	if len(config.Redact) == 0 {
+		return
+	}
+
+	names := make([]string, len(config.Redact))
+	for i, name := range config.Redact {
+		names[i] = regexp.QuoteMeta(name)
+	}
+	// Matches <name attr="...">text</name> and keeps the tags in groups 1 and 3
+	// The closing tag may be missing when the logged body was cut off
+	config.redactExpr = regexp.MustCompile(`(<(` + strings.Join(names, "|") + `)(?:\s[^>]*)?>)[^<]*(</(?:` + strings.Join(names, "|") + `)>|$)`)
+}
+
+// redact replaces PII in a logged payload
+func (config *RequestLogConfig) redact(payload string) string {
+	if config.redactExpr != nil {
+		payload = config.redactExpr.ReplaceAllString(payload, "${1}"+LOG_REDACTED+"${3}")
+	}
+	return emailPattern.ReplaceAllString(payload, LOG_REDACTED)
+}
+
+// statusRecorder wraps a ResponseWriter to remember the status code and the size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	Status      int    // Status is the status code sent to the client
+	Bytes       int    // Bytes is the number of body bytes sent to the client
+	WroteHeader bool   // WroteHeader is true once the status was sent
+	Capture     int    // Capture is the number of body bytes to keep in Body
+	Body        []byte // Body holds the first Capture bytes of the response body
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.Status = status
+	recorder.WroteHeader = true
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	recorder.WroteHeader = true
+	if missing := recorder.Capture - len(recorder.Body); missing > 0 {
+		if missing > len(data) {
+			missing = len(data)
+		}
+		recorder.Body = append(recorder.Body, data[:missing]...)
+	}
+
+	n, err := recorder.ResponseWriter.Write(data)
+	recorder.Bytes += n
+	return n, err
+}
+
+// peekBody returns up to max bytes of the request body while leaving the body intact for the handler
+func peekBody(r *http.Request, max int) ([]byte, error) {
+	if r.Body == nil || max == 0 {
+		return nil, nil
+	}
+
+	head, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(max)))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(head), r.Body))
+	return head, err
+}
+
+// logRequests is a middleware logging a sample of requests with their redacted body and a summary of the response
+// At the debug level every request is logged, above the info level none is.
+func logRequests(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		config := requestLogConfig
+		sampled := config.SampleRate > 0 && rand.Float64() < config.SampleRate
+		if !logEnabled(LOG_LEVEL_INFO) || (!sampled && !logEnabled(LOG_LEVEL_DEBUG)) {
+			next(db, w, r)
+			return
+		}
+		funcName := "logRequests"
+
+		body, err := peekBody(r, config.MaxBody)
+		if err != nil {
+			log.Printf("%s: Failed to read request body: %v", funcName, err)
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, Status: http.StatusOK}
+		next(db, recorder, r)
+
+		// Signatures of signed URLs are credentials and must not end up in logs
+		query := r.URL.Query()
+		if query.Get("signature") != "" {
+			query.Set("signature", LOG_REDACTED)
+		}
+		target := r.URL.Path
+		if len(query) > 0 {
+			target += "?" + query.Encode()
+		}
+
+		log.Printf("%s: %s %s from %s status=%d bytes=%d duration=%s body=%q", funcName, r.Method, target, clientIP(r), recorder.Status, recorder.Bytes, time.Since(start), config.redact(string(body)))
+	}
+}
@@ -0,0 +1,96 @@
+package goapp
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test redacting configured elements and e-mail addresses
+func TestRequestLogRedact(t *testing.T) {
+	config := RequestLogConfig{Redact: []string{"author", "ssn"}}
+	config.compile()
+
+	tests := []struct {
+		desc             string
+		payload          string
+		expectedResponse string
+	}{
+		{
+			desc:             "redacted element",
+			payload:          `<document><title>Test Title</title><author>Test Author</author></document>`,
+			expectedResponse: `<document><title>Test Title</title><author>[REDACTED]</author></document>`,
+		}, {
+			desc:             "element with attributes",
+			payload:          `<ssn type="us">123-45-6789</ssn><ssnNote>kept</ssnNote>`,
+			expectedResponse: `<ssn type="us">[REDACTED]</ssn><ssnNote>kept</ssnNote>`,
+		}, {
+			desc:             "e-mail address",
+			payload:          `<contact>jane.doe@example.com</contact>`,
+			expectedResponse: `<contact>[REDACTED]</contact>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.expectedResponse, config.redact(tt.payload))
+		})
+	}
+}
+
+// Test that sampled requests are logged and the handler still gets the whole body
+func TestLogRequests(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	requestLogConfig = RequestLogConfig{SampleRate: 1, MaxBody: 12, Redact: []string{"author"}}
+	requestLogConfig.compile()
+	defer func() { requestLogConfig = RequestLogConfig{} }()
+
+	body := `<author>Test Author</author>`
+	var received string
+	handler := logRequests(func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		received = string(data)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler(nil, w, req)
+
+	require.Equal(t, body, received)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+	require.Contains(t, output.String(), "POST /add")
+	require.Contains(t, output.String(), "status=201")
+	require.Contains(t, output.String(), `body="<author>[REDACTED]"`)
+
+	// Nothing is logged when sampling is disabled
+	output.Reset()
+	requestLogConfig.SampleRate = 0
+	handler(nil, httptest.NewRecorder(), httptest.NewRequest("POST", "/add", strings.NewReader(body)))
+	require.Empty(t, output.String())
+
+	// The debug level logs every request, levels above info none
+	defer setLogLevel(currentLogLevel())
+	require.NoError(t, setLogLevel(LOG_LEVEL_DEBUG))
+	handler(nil, httptest.NewRecorder(), httptest.NewRequest("POST", "/add", strings.NewReader(body)))
+	require.Contains(t, output.String(), "POST /add")
+
+	output.Reset()
+	requestLogConfig.SampleRate = 1
+	require.NoError(t, setLogLevel(LOG_LEVEL_WARN))
+	handler(nil, httptest.NewRecorder(), httptest.NewRequest("POST", "/add", strings.NewReader(body)))
+	require.Empty(t, output.String())
+
+	require.EqualError(t, setLogLevel("trace"), "must be one of debug, info, warn, error")
+}
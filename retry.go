@@ -0,0 +1,121 @@
+package goapp
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	DB_RETRY_ATTEMPTS_ENV = "DOC_DB_RETRY_ATTEMPTS" // Environment variable with the number of attempts of a store operation
+	DB_RETRY_BASE_MS_ENV  = "DOC_DB_RETRY_BASE_MS"  // Environment variable with the base delay between attempts in milliseconds
+
+	DB_RETRY_DEFAULT_ATTEMPTS  = 5                      // Attempts of a store operation by default
+	DB_RETRY_DEFAULT_BASE      = 20 * time.Millisecond  // Base delay between attempts by default
+	DB_RETRY_DEFAULT_MAX_DELAY = 500 * time.Millisecond // Longest delay between attempts
+	DB_UNAVAILABLE_RETRY_AFTER = 1                      // Seconds clients are asked to wait after retries are exhausted
+)
+
+// ErrDBUnavailable is returned when a store operation kept failing with transient errors
+var ErrDBUnavailable = errors.New("database temporarily unavailable")
+
+// RetryPolicy retries operations failing with transient database errors using jittered exponential backoff
+type RetryPolicy struct {
+	MaxAttempts int           // MaxAttempts is the total number of attempts, including the first one
+	BaseDelay   time.Duration // BaseDelay is the delay cap before the second attempt, doubled for each further attempt
+	MaxDelay    time.Duration // MaxDelay caps the delay between two attempts
+
+	sleep func(time.Duration) // sleep waits between attempts, replaced in tests
+}
+
+// dbRetryPolicy is the policy of all store operations, set by initDBRetryPolicy
+var dbRetryPolicy = RetryPolicy{
+	MaxAttempts: DB_RETRY_DEFAULT_ATTEMPTS,
+	BaseDelay:   DB_RETRY_DEFAULT_BASE,
+	MaxDelay:    DB_RETRY_DEFAULT_MAX_DELAY,
+	sleep:       time.Sleep,
+}
+
+// initDBRetryPolicy loads the retry policy of store operations from the environment
+func initDBRetryPolicy() {
+	funcName := "initDBRetryPolicy"
+
+	if value := os.Getenv(DB_RETRY_ATTEMPTS_ENV); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 1 {
+			log.Fatalf("%s: %s must be at least 1", funcName, DB_RETRY_ATTEMPTS_ENV)
+		}
+		dbRetryPolicy.MaxAttempts = attempts
+	}
+	if value := os.Getenv(DB_RETRY_BASE_MS_ENV); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			log.Fatalf("%s: %s must be a positive number of milliseconds", funcName, DB_RETRY_BASE_MS_ENV)
+		}
+		dbRetryPolicy.BaseDelay = time.Duration(ms) * time.Millisecond
+	}
+}
+
+// isTransientDBError reports whether err may go away when the operation is retried
+func isTransientDBError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone)
+}
+
+// delay returns the jittered delay before the attempt following attempt (starting at 1)
+func (policy RetryPolicy) delay(attempt int) time.Duration {
+	ceiling := policy.BaseDelay << uint(attempt-1)
+	if ceiling > policy.MaxDelay || ceiling <= 0 {
+		ceiling = policy.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Do runs fn until it succeeds, fails with a permanent error or the attempts are exhausted
+// Exhausted retries are reported as ErrDBUnavailable wrapping the last error
+func (policy RetryPolicy) Do(fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		err = fn()
+		if err == nil || !isTransientDBError(err) {
+			return err
+		}
+
+		metrics.inc("db_retries_total")
+		if attempt < policy.MaxAttempts {
+			policy.sleep(policy.delay(attempt))
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrDBUnavailable, err)
+}
+
+// withDBRetry runs a store operation with dbRetryPolicy
+func withDBRetry(fn func() error) error {
+	return dbRetryPolicy.Do(fn)
+}
+
+// httpStoreError answers a failed store operation: 503 with Retry-After if the database is
+// temporarily unavailable, 500 otherwise
+func httpStoreError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, ErrDBUnavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(DB_UNAVAILABLE_RETRY_AFTER))
+		http.Error(w, message, http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, message, http.StatusInternalServerError)
+}
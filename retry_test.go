@@ -0,0 +1,76 @@
+package goapp
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+// Test retrying transient errors and giving up on permanent ones
+func TestRetryPolicy(t *testing.T) {
+	var delays []time.Duration
+	policy := RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    15 * time.Millisecond,
+		sleep:       func(d time.Duration) { delays = append(delays, d) },
+	}
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	tests := []struct {
+		desc             string
+		errs             []error
+		expectedAttempts int
+		expectedErr      error
+	}{
+		{desc: "success", errs: []error{nil}, expectedAttempts: 1},
+		{desc: "transient then success", errs: []error{busy, sqlite3.Error{Code: sqlite3.ErrLocked}, nil}, expectedAttempts: 3},
+		{desc: "permanent error", errs: []error{sql.ErrNoRows}, expectedAttempts: 1, expectedErr: sql.ErrNoRows},
+		{desc: "exhausted", errs: []error{busy, busy, busy}, expectedAttempts: 3, expectedErr: ErrDBUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			delays = nil
+			attempts := 0
+			err := policy.Do(func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+
+			require.Equal(t, tt.expectedAttempts, attempts)
+			if tt.expectedErr != nil {
+				require.True(t, errors.Is(err, tt.expectedErr))
+			} else {
+				require.NoError(t, err)
+			}
+
+			// Delays are jittered below the exponential ceiling
+			for i, delay := range delays {
+				ceiling := policy.BaseDelay << uint(i)
+				if ceiling > policy.MaxDelay {
+					ceiling = policy.MaxDelay
+				}
+				require.True(t, delay >= 0 && delay <= ceiling)
+			}
+		})
+	}
+}
+
+// Test that exhausted retries are answered with 503 and Retry-After
+func TestHTTPStoreError(t *testing.T) {
+	w := httptest.NewRecorder()
+	httpStoreError(w, "Failed to list documents", errors.New("disk I/O error"))
+	require.Equal(t, http.StatusInternalServerError, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	err := dbRetryPolicy.Do(func() error { return sqlite3.Error{Code: sqlite3.ErrBusy} })
+	httpStoreError(w, "Failed to list documents", err)
+	require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+	require.Equal(t, "1", w.Result().Header.Get("Retry-After"))
+}
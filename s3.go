@@ -0,0 +1,218 @@
+package goapp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3_TIMEOUT      = 5 * time.Minute                                                    // Timeout of a request to S3, large enough for snapshot uploads
+	S3_SIGNING_ALGO = "AWS4-HMAC-SHA256"                                                 // Algorithm of AWS signature version 4
+	S3_DATE_LAYOUT  = "20060102T150405Z"                                                 // Layout of the X-Amz-Date header
+	S3_SCOPE_LAYOUT = "20060102"                                                         // Layout of the date in the credential scope
+	S3_EMPTY_SHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // SHA-256 of an empty payload
+)
+
+// S3Client talks to S3-compatible storage with path-style URLs like {Endpoint}/{Bucket}/{key}
+// Requests are signed with AWS signature version 4, so it works with AWS, MinIO, R2 and the like
+type S3Client struct {
+	Endpoint  string // Endpoint is the base URL, e.g. https://s3.eu-west-1.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+	Breaker   *CircuitBreaker // Breaker stops calling S3 for a while when it is unreachable
+
+	now func() time.Time // now returns the current time, replaced in tests
+}
+
+// newS3Client creates a client with the default timeout and circuit breaker
+func newS3Client(endpoint string, region string, bucket string, accessKey string, secretKey string) *S3Client {
+	return &S3Client{
+		Endpoint:  strings.TrimRight(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: S3_TIMEOUT},
+		Breaker:   newCircuitBreaker("s3"),
+		now:       time.Now,
+	}
+}
+
+// PutObject stores data under key
+func (client *S3Client) PutObject(key string, data []byte) error {
+	_, err := client.do("PUT", key, nil, data)
+	return err
+}
+
+// GetObject returns the data stored under key
+func (client *S3Client) GetObject(key string) ([]byte, error) {
+	return client.do("GET", key, nil, nil)
+}
+
+// listBucketResult is the part of a ListObjectsV2 response the client uses
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// ListObjects returns the keys starting with prefix in lexical order
+func (client *S3Client) ListObjects(prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		body, err := client.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("invalid list response: %w", err)
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// do sends a signed request through the circuit breaker and returns the response body
+func (client *S3Client) do(method string, key string, query url.Values, payload []byte) ([]byte, error) {
+	var body []byte
+	err := client.Breaker.Call(func() error {
+		req, err := client.newRequest(method, key, query, payload)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status %s for %s %s", resp.Status, method, req.URL.Path)
+		}
+		return nil
+	})
+	return body, err
+}
+
+// newRequest builds a request for key, signed with AWS signature version 4
+func (client *S3Client) newRequest(method string, key string, query url.Values, payload []byte) (*http.Request, error) {
+	path := "/" + client.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	canonicalURI := s3Escape(path, false)
+	canonicalQuery := s3CanonicalQuery(query)
+
+	target := client.Endpoint + canonicalURI
+	if canonicalQuery != "" {
+		target += "?" + canonicalQuery
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := S3_EMPTY_SHA256
+	if len(payload) > 0 {
+		sum := sha256.Sum256(payload)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	now := client.now().UTC()
+	amzDate := now.Format(S3_DATE_LAYOUT)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalURI,
+		canonicalQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format(S3_SCOPE_LAYOUT), client.Region)
+	stringToSign := strings.Join([]string{S3_SIGNING_ALGO, amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	signingKey := []byte("AWS4" + client.SecretKey)
+	for _, part := range []string{now.Format(S3_SCOPE_LAYOUT), client.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		S3_SIGNING_ALGO, client.AccessKey, scope, signedHeaders, signature))
+	return req, nil
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape URI-encodes value as signature version 4 expects, keeping slashes unless encodeSlash is set
+func s3Escape(value string, encodeSlash bool) string {
+	var escaped strings.Builder
+	for _, b := range []byte(value) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// s3CanonicalQuery encodes the query sorted by key as signature version 4 expects
+func s3CanonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
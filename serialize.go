@@ -0,0 +1,127 @@
+package goapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SERIALIZE_PATH_PREFIX = "/document/" // Path prefix of the serialization endpoint, followed by the document ID
+	SERIALIZE_PATH_SUFFIX = "/xml"       // Path suffix of the serialization endpoint
+
+	SERIALIZE_MAX_INDENT = 8 // Largest number of spaces /document/{id}/xml indents with
+)
+
+// Serialize rebuilds the XML of the document from its element tree, with an XML declaration
+// Each level is indented by indent, e.g. "  ", or not at all if it is empty. Elements with text besides
+// their children are written on one line, so indenting doesn't change their content.
+// Comments, processing instructions and the DOCTYPE aren't part of the tree and are left out.
+func (doc *XMLDoc) Serialize(indent string) string {
+	var out strings.Builder
+	out.WriteString(`<?xml version="`)
+	version := "1.0"
+	if doc.Declaration != nil && doc.Declaration.Version != "" {
+		version = doc.Declaration.Version
+	}
+	// Documents are stored in UTF-8 whatever they were sent in
+	out.WriteString(xmlAttrEscaper.Replace(version) + `" encoding="UTF-8"`)
+	if doc.Declaration != nil && doc.Declaration.Standalone != "" {
+		out.WriteString(` standalone="` + xmlAttrEscaper.Replace(doc.Declaration.Standalone) + `"`)
+	}
+	out.WriteString("?>\n")
+	if doc.Tree != nil {
+		out.WriteString(doc.Tree.Serialize(indent))
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// Serialize writes the node and its descendants as XML like String, indenting each level by indent
+func (node *Node) Serialize(indent string) string {
+	if indent == "" {
+		return node.String()
+	}
+	var out strings.Builder
+	node.writeIndented(&out, indent, 0)
+	return out.String()
+}
+
+func (node *Node) writeIndented(out *strings.Builder, indent string, depth int) {
+	// Whitespace is content in mixed content and under xml:space="preserve"
+	mixed := strings.TrimSpace(node.Text) != "" || node.Attrs[XML_SPACE_ATTRIBUTE] == "preserve"
+	for _, child := range node.Children {
+		mixed = mixed || strings.TrimSpace(child.Tail) != ""
+	}
+	if mixed || len(node.Children) == 0 {
+		out.WriteString(node.String())
+		return
+	}
+
+	node.writeStartTag(out)
+	// Whitespace between the children is replaced by the indentation
+	for _, child := range node.Children {
+		out.WriteString("\n" + strings.Repeat(indent, depth+1))
+		child.writeIndented(out, indent, depth+1)
+	}
+	out.WriteString("\n" + strings.Repeat(indent, depth) + "</" + node.Name + ">")
+}
+
+// serializeDocumentID returns the document ID of a serialization path like "/document/1/xml"
+func serializeDocumentID(path string) (string, bool) {
+	if !strings.HasPrefix(path, SERIALIZE_PATH_PREFIX) || !strings.HasSuffix(path, SERIALIZE_PATH_SUFFIX) {
+		return "", false
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(path, SERIALIZE_PATH_PREFIX), SERIALIZE_PATH_SUFFIX)
+	if id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+// parseIndent returns the indentation of the indent parameter: a number of spaces or "tab"
+func parseIndent(param string) (string, error) {
+	if param == "" {
+		return "", nil
+	}
+	if param == "tab" {
+		return "\t", nil
+	}
+	spaces, err := strconv.Atoi(param)
+	if err != nil || spaces < 0 || spaces > SERIALIZE_MAX_INDENT {
+		return "", fmt.Errorf("indent must be tab or between 0 and %d spaces", SERIALIZE_MAX_INDENT)
+	}
+	return strings.Repeat(" ", spaces), nil
+}
+
+// handleSerializeRequest returns the XML of a document rebuilt from its element tree, e.g. /document/1/xml?indent=2
+func handleSerializeRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	indent, err := parseIndent(r.URL.Query().Get("indent"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+	// Documents stored before trees were kept have none until they are reprocessed
+	if doc.Tree == nil {
+		http.Error(w, fmt.Sprintf("Document with ID %s has no element tree, reprocess it first", id), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(doc.Serialize(indent)))
+}
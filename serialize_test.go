@@ -0,0 +1,67 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that serialized documents parse to the same tree, with and without indentation
+func TestSerialize(t *testing.T) {
+	doc, err := parseDocument(`<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?><order id="7" note="a &quot;b&quot; &lt; c"><item><name>Fish &amp; Chips</name><p>The <b>quick</b> fox</p></item></order>`)
+	require.NoError(t, err)
+
+	compact := doc.Serialize("")
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+
+		`<order id="7" note="a &quot;b&quot; &lt; c"><item><name>Fish &amp; Chips</name><p>The <b>quick</b> fox</p></item></order>`+"\n", compact)
+
+	indented := doc.Serialize("  ")
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<order id="7" note="a &quot;b&quot; &lt; c">
+  <item>
+    <name>Fish &amp; Chips</name>
+    <p>The <b>quick</b> fox</p>
+  </item>
+</order>
+`, indented)
+
+	for _, data := range []string{compact, indented} {
+		parsed, err := parseDocumentWithOptions(data, ParseOptions{Whitespace: WHITESPACE_TRIM})
+		require.NoError(t, err)
+		require.Equal(t, doc.Tree.String(), parsed.Tree.String())
+	}
+}
+
+// Test the serialization endpoint
+func TestHandleSerializeRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<document><title>Test</title></document>")
+	require.NoError(t, err)
+	id, err := addDocument(db, *doc)
+	require.NoError(t, err)
+
+	tests := []struct {
+		path     string
+		expected int
+		body     string
+	}{
+		{path: "/document/" + id + "/xml?indent=tab", expected: http.StatusOK, body: "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<document>\n\t<title>Test</title>\n</document>\n"},
+		{path: "/document/" + id + "/xml?indent=20", expected: http.StatusBadRequest},
+		{path: "/document/999/xml", expected: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleRequest(db, rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			require.Equal(t, tt.expected, rr.Code)
+			if tt.body != "" {
+				require.Equal(t, tt.body, rr.Body.String())
+				require.Equal(t, "application/xml", rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package goapp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_DB_PATH     = "./documents.db" // Database file used if the configuration names none
+	DEFAULT_LISTEN_ADDR = ":3456"          // Address RunServer listens on if the configuration names none
+	SHUTDOWN_TIMEOUT    = 10 * time.Second // Time requests in flight get to finish when RunServer is stopped
+
+	BASE_PATH_ENV = "DOC_BASE_PATH" // Environment variable with the path the API is mounted under if the configuration names none, e.g. "/xmlarchive"
+)
+
+// basePath is the path the service is mounted under, which the links it generates start with
+var basePath string
+
+// Config configures the document service when it is embedded with NewHandler or RunServer
+// Everything else, like the API key or alert rules, is read from the environment as for the standalone server.
+type Config struct {
+	DB         *sql.DB // DB is an open SQLite database to store documents in, DBPath is used if it is nil
+	DBPath     string  // DBPath is the SQLite database file, DEFAULT_DB_PATH if empty
+	Addr       string  // Addr is the address RunServer listens on, like ":3456" or "unix:/run/goapp.sock", LISTEN_ADDR_ENV or DEFAULT_LISTEN_ADDR if empty
+	PathPrefix string  // PathPrefix is the path the service is mounted under, e.g. "/docs", BASE_PATH_ENV or the root if empty
+
+	Listener net.Listener // Listener is an open listener RunServer serves on instead of Addr, closed when it returns
+
+	EntityResolver EntityResolver // EntityResolver resolves the external entities of documents, from ENTITY_ALLOWLIST_ENV or denying all if nil
+
+	LegacyAPI bool // LegacyAPI serves /document, /add and /del like the first release, from LEGACY_API_ENV if false
+}
+
+// service holds the database of the document service, which is set up once per process
+// The service keeps its configuration in package variables, so a process runs a single instance of it.
+var service struct {
+	once   sync.Once
+	db     *sql.DB
+	err    error
+	jobs   sync.Once
+	config Config
+}
+
+// setupService opens and migrates the database and loads the configuration from the environment, once
+// Later calls return the database of the first one, whatever their configuration.
+func setupService(config Config) (*sql.DB, error) {
+	service.once.Do(func() {
+		service.config = config
+		db := config.DB
+		if db == nil {
+			path := config.DBPath
+			if path == "" {
+				path = DEFAULT_DB_PATH
+			}
+			db, service.err = sql.Open("sqlite3", path)
+			if service.err != nil {
+				return
+			}
+		}
+		service.db = db
+
+		initDB(db)
+		initSigningKey()
+		initArchiveKey()
+		initAuth()
+		initOIDC()
+		initTrustedProxies()
+		initIPPolicies()
+		initRequestLogging()
+		initRedis()
+		initRateLimit()
+		initCache()
+		initIdempotency()
+		initSlowLogging()
+		initErrorReporter()
+		initDBRetryPolicy()
+		initSnapshots()
+		initColdStorage()
+		initLeaderElection()
+		initIngestQueue()
+		initParseLimits()
+		initTextLimits()
+		initIngestRules()
+		initDateProfiles()
+		initPreviews()
+		initLanguageDetection()
+		initEntityDecoding()
+		initEntityResolver(config)
+		initLegacyAPI(config)
+		initFieldMappings()
+		initValidationSchemas()
+		initXSDSchema()
+		initStylesheets()
+		initMailer()
+		initChatConnectors()
+		initAlerts()
+		initReports()
+		initRuntimeSettings(db)
+		initFeatureFlags(db)
+	})
+	if service.err == nil && (config.DB != service.config.DB || config.DBPath != service.config.DBPath) {
+		log.Printf("setupService: The service is already set up, ignoring the storage of another configuration")
+	}
+	return service.db, service.err
+}
+
+// startBackgroundJobs starts the jobs running besides the API, once
+func startBackgroundJobs(db *sql.DB) {
+	service.jobs.Do(func() {
+		// Pick up settings and feature flags changed through other instances
+		go runSettingsReloader(db, SETTINGS_RELOAD_INTERVAL)
+
+		// Store the metered usage of API keys and tokens
+		go runUsageFlusher(db, USAGE_FLUSH_INTERVAL)
+
+		// Archive expired documents in the background, on a single instance if several share the database
+		go runArchiver(db, ARCHIVE_INTERVAL)
+
+		// Replicate the database to S3 if configured
+		if snapshotter != nil {
+			go runSnapshotter(db, snapshotter)
+		}
+
+		// Notify about ingestion anomalies if alert rules are configured
+		if alerter != nil {
+			go runAlerter(db, alerter)
+		}
+
+		// Mail ingestion reports if recipients are configured
+		if reporter != nil {
+			go runReporter(db, reporter)
+		}
+
+		// Move the XML of idle documents to cold storage if configured
+		if coldStore != nil {
+			go runTiering(db, coldStore, COLD_INTERVAL)
+		}
+	})
+}
+
+// NewHandler sets up the document service and returns its routes, to be mounted in the server of another program
+// The background jobs, like the archiver, are started with the first handler.
+func NewHandler(config Config) (http.Handler, error) {
+	prefix := config.PathPrefix
+	if prefix == "" {
+		prefix = os.Getenv(BASE_PATH_ENV)
+	}
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("path prefix %q must start with /", prefix)
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	db, err := setupService(config)
+	if err != nil {
+		return nil, err
+	}
+	startBackgroundJobs(db)
+	basePath = prefix
+
+	handler := logRequests(reportErrors(handleRequest))
+	var mux http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(db, w, r)
+	})
+	if prefix == "" {
+		return mux, nil
+	}
+	return http.StripPrefix(prefix, mux), nil
+}
+
+// RunServer serves the document service until ctx is done, then waits for requests in flight to finish
+// It listens on the sockets passed by systemd socket activation if there are some, on Listener or on Addr otherwise.
+// It returns nil after a shutdown, or the error the server failed with.
+func RunServer(ctx context.Context, config Config) error {
+	handler, err := NewHandler(config)
+	if err != nil {
+		return err
+	}
+
+	var listeners []net.Listener
+	if config.Listener != nil {
+		listeners = []net.Listener{config.Listener}
+	} else {
+		addr := config.Addr
+		if addr == "" {
+			addr = os.Getenv(LISTEN_ADDR_ENV)
+		}
+		if addr == "" {
+			addr = DEFAULT_LISTEN_ADDR
+		}
+		listeners, err = listen(addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+	}
+	server := &http.Server{Handler: handler}
+
+	failed := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			log.Printf("Server listening on %s %s", listener.Addr().Network(), listener.Addr())
+			failed <- server.Serve(listener)
+		}(listener)
+	}
+
+	select {
+	case err := <-failed:
+		server.Close()
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// RunCommand sets up the document service and runs a command line subcommand like `goapp export --out archive.tar.gz`
+func RunCommand(config Config, args []string) error {
+	db, err := setupService(config)
+	if err != nil {
+		return err
+	}
+	return runCommand(db, args)
+}
+
+// linkPath returns the path of an endpoint as clients reach it, below the base path
+func linkPath(path string) string {
+	return basePath + path
+}
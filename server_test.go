@@ -0,0 +1,96 @@
+package goapp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test embedding the service under a path prefix
+func TestNewHandler(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, err := NewHandler(Config{DB: db, PathPrefix: "docs"})
+	require.Error(t, err)
+
+	handler, err := NewHandler(Config{DB: db, PathPrefix: "/docs/"})
+	require.NoError(t, err)
+	defer func() { basePath = "" }()
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{path: "/docs/list", expected: http.StatusOK},
+		{path: "/list", expected: http.StatusNotFound},
+		{path: "/docs", expected: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			require.Equal(t, tt.expected, rr.Code)
+		})
+	}
+
+	// Generated links are below the prefix
+	doc, err := parseDocument("<document><title>Linked</title></document>")
+	require.NoError(t, err)
+	id, err := addDocument(db, *doc)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/docs/share?id="+id, nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Contains(t, rr.Body.String(), `"URL":"http://example.com/docs/public/`)
+
+	// The prefix is read from the environment if the configuration has none
+	t.Setenv(BASE_PATH_ENV, "/xmlarchive")
+	handler, err = NewHandler(Config{DB: db})
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/xmlarchive/list", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	os.Unsetenv(BASE_PATH_ENV)
+
+	// RunServer stops when its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, RunServer(ctx, Config{DB: db, Addr: "127.0.0.1:0"}))
+
+	// RunServer serves on a unix socket and removes it on shutdown
+	path := filepath.Join(t.TempDir(), "goapp.sock")
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- RunServer(ctx, Config{DB: db, Addr: UNIX_ADDR_PREFIX + path})
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("http://goapp/list"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	require.NoError(t, <-done)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,373 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DB_SETTING_TABLE_NAME            = "runtime_setting" // Table name of runtime setting overrides in SQLite
+	DB_SETTING_NAME_FIELD_NAME       = "name"            // Field name for the name of the setting
+	DB_SETTING_VALUE_FIELD_NAME      = "value"           // Field name for the overriding value
+	DB_SETTING_UPDATED_AT_FIELD_NAME = "updated_at"      // Field name for the time the override was set
+
+	SETTING_LOG_LEVEL       = "log_level"       // Setting with the least severe level which is logged
+	SETTING_RATE_LIMIT      = "rate_limit"      // Setting with the number of requests a client address may make per minute
+	SETTING_LENIENT_PARSING = "lenient_parsing" // Setting with whether /add repairs broken tags when ?lenient isn't given
+	SETTING_MAINTENANCE     = "maintenance"     // Setting with whether write endpoints are turned away
+
+	LENIENT_PARSING_ENV = "DOC_LENIENT_PARSING" // Environment variable with whether /add parses leniently by default
+
+	SETTINGS_RELOAD_INTERVAL = 30 * time.Second // Interval at which overrides set on other instances are picked up
+	MAINTENANCE_RETRY_AFTER  = 60               // Seconds clients are told to wait during maintenance
+)
+
+// RuntimeSetting is a setting which can be changed without restarting the server
+type RuntimeSetting struct {
+	Name       string
+	Value      string // Value is the setting in effect
+	Default    string // Default is the value from the environment, used when there is no override
+	Overridden bool   // Overridden is true when the value was set with the admin API
+	UpdatedAt  string `json:",omitempty"` // UpdatedAt is the time the override was set
+}
+
+// settingDefinition describes how to check, apply and read a runtime setting
+type settingDefinition struct {
+	normalize func(value string) (string, error) // normalize checks a value and returns it in canonical form
+	apply     func(value string)                 // apply puts a normalized value in effect
+	current   func() string                      // current returns the value in effect
+}
+
+var (
+	lenientByDefault = &atomic.Bool{} // lenientByDefault is true when /add repairs broken tags unless told otherwise
+	maintenanceMode  = &atomic.Bool{} // maintenanceMode is true when write endpoints answer 503
+)
+
+// settingDefinitions are the settings which can be changed with the admin API
+var settingDefinitions = map[string]settingDefinition{
+	SETTING_LOG_LEVEL: {
+		normalize: func(value string) (string, error) {
+			value = strings.ToLower(value)
+			for _, level := range logLevels {
+				if level == value {
+					return value, nil
+				}
+			}
+			return "", fmt.Errorf("must be one of %s", strings.Join(logLevels, ", "))
+		},
+		apply:   func(value string) { setLogLevel(value) },
+		current: currentLogLevel,
+	},
+	SETTING_RATE_LIMIT: {
+		normalize: func(value string) (string, error) {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return "", fmt.Errorf("must be a positive number or 0")
+			}
+			return strconv.Itoa(limit), nil
+		},
+		apply: func(value string) {
+			limit, _ := strconv.Atoi(value)
+			requestLimiter.SetLimit(limit)
+		},
+		current: func() string { return strconv.Itoa(requestLimiter.Limit()) },
+	},
+	SETTING_LENIENT_PARSING: boolSetting(lenientByDefault),
+	SETTING_MAINTENANCE:     boolSetting(maintenanceMode),
+}
+
+// settingDefaults holds the values of the settings before any override, captured by initRuntimeSettings
+var settingDefaults = map[string]string{}
+
+// boolSetting defines a setting switching a flag on and off
+func boolSetting(flag *atomic.Bool) settingDefinition {
+	return settingDefinition{
+		normalize: func(value string) (string, error) {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return "", fmt.Errorf("must be true or false")
+			}
+			return strconv.FormatBool(parsed), nil
+		},
+		apply:   func(value string) { flag.Store(value == "true") },
+		current: func() string { return strconv.FormatBool(flag.Load()) },
+	}
+}
+
+// createSettingTable creates the runtime setting table if not exists
+func createSettingTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL PRIMARY KEY,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL
+	);
+`, DB_SETTING_TABLE_NAME, DB_SETTING_NAME_FIELD_NAME, DB_SETTING_VALUE_FIELD_NAME, DB_SETTING_UPDATED_AT_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// initRuntimeSettings remembers the settings from the environment as defaults and applies the overrides stored in the database
+// It must run after the other init functions so the defaults are complete.
+func initRuntimeSettings(db *sql.DB) {
+	funcName := "initRuntimeSettings"
+
+	if value := os.Getenv(LENIENT_PARSING_ENV); value != "" {
+		lenient, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("%s: %s must be true or false", funcName, LENIENT_PARSING_ENV)
+		}
+		lenientByDefault.Store(lenient)
+	}
+
+	for name, definition := range settingDefinitions {
+		settingDefaults[name] = definition.current()
+	}
+	if err := reloadSettings(db); err != nil {
+		log.Fatalf("%s: Failed to load runtime settings: %v", funcName, err)
+	}
+}
+
+// listSettingOverrides returns the stored overrides by setting name with the time they were set
+func listSettingOverrides(db *sql.DB) (map[string][2]string, error) {
+	defer observeQuery("listSettingOverrides", time.Now())
+
+	query := fmt.Sprintf("SELECT %s, %s, %s FROM %s", DB_SETTING_NAME_FIELD_NAME, DB_SETTING_VALUE_FIELD_NAME, DB_SETTING_UPDATED_AT_FIELD_NAME, DB_SETTING_TABLE_NAME)
+	var overrides map[string][2]string
+	err := withDBRetry(func() error {
+		overrides = map[string][2]string{}
+		rows, err := db.Query(query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name, value, updatedAt string
+			if err := rows.Scan(&name, &value, &updatedAt); err != nil {
+				return err
+			}
+			overrides[name] = [2]string{value, updatedAt}
+		}
+		return rows.Err()
+	})
+	return overrides, err
+}
+
+// saveSettingOverrides stores the overrides in one transaction
+func saveSettingOverrides(db *sql.DB, values map[string]string, now time.Time) error {
+	defer observeQuery("saveSettingOverrides", time.Now())
+
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s) VALUES (?, ?, ?)
+		ON CONFLICT(%[2]s) DO UPDATE SET %[3]s=excluded.%[3]s, %[4]s=excluded.%[4]s
+	`, DB_SETTING_TABLE_NAME, DB_SETTING_NAME_FIELD_NAME, DB_SETTING_VALUE_FIELD_NAME, DB_SETTING_UPDATED_AT_FIELD_NAME)
+	return withDBRetry(func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for name, value := range values {
+			if _, err := tx.Exec(query, name, value, formatExpiry(now)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// deleteSettingOverride removes the override of a setting
+func deleteSettingOverride(db *sql.DB, name string) error {
+	defer observeQuery("deleteSettingOverride", time.Now())
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s=?", DB_SETTING_TABLE_NAME, DB_SETTING_NAME_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, name)
+		return err
+	})
+}
+
+// reloadSettings applies the stored overrides and reverts the settings without one to their default
+// Stored values which are no longer valid are logged and ignored.
+func reloadSettings(db *sql.DB) error {
+	funcName := "reloadSettings"
+
+	overrides, err := listSettingOverrides(db)
+	if err != nil {
+		return err
+	}
+	for name, definition := range settingDefinitions {
+		value := settingDefaults[name]
+		if override, ok := overrides[name]; ok {
+			normalized, err := definition.normalize(override[0])
+			if err != nil {
+				log.Printf("%s: Ignoring invalid override of %s: %v", funcName, name, err)
+			} else {
+				value = normalized
+			}
+		}
+		if value != definition.current() {
+			definition.apply(value)
+		}
+	}
+	return nil
+}
+
+// runSettingsReloader picks up the settings and feature flags set through other instances sharing the database
+func runSettingsReloader(db *sql.DB, interval time.Duration) {
+	funcName := "runSettingsReloader"
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := reloadSettings(db); err != nil {
+			log.Printf("%s: Failed to reload runtime settings: %v", funcName, err)
+		}
+		if err := featureFlags.Reload(db); err != nil {
+			log.Printf("%s: Failed to reload feature flags: %v", funcName, err)
+		}
+	}
+}
+
+// listSettings returns the runtime settings sorted by name
+func listSettings(db *sql.DB) ([]RuntimeSetting, error) {
+	overrides, err := listSettingOverrides(db)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := []RuntimeSetting{}
+	for name, definition := range settingDefinitions {
+		setting := RuntimeSetting{Name: name, Value: definition.current(), Default: settingDefaults[name]}
+		if override, ok := overrides[name]; ok {
+			setting.Overridden = true
+			setting.UpdatedAt = override[1]
+		}
+		settings = append(settings, setting)
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	return settings, nil
+}
+
+// handleConfigRequest shows the runtime settings on GET, overrides them from a JSON object on PUT and PATCH,
+// and reverts one to its default on DELETE with ?name=
+func handleConfigRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	funcName := "handleConfigRequest"
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(body) == 0 {
+			http.Error(w, "No settings given", http.StatusBadRequest)
+			return
+		}
+
+		// All values are checked before any is applied
+		values := map[string]string{}
+		for name, raw := range body {
+			definition, ok := settingDefinitions[name]
+			if !ok {
+				http.Error(w, fmt.Sprintf("Unknown setting %s", name), http.StatusBadRequest)
+				return
+			}
+			var value string
+			switch raw := raw.(type) {
+			case string:
+				value = raw
+			case bool, float64:
+				value = fmt.Sprint(raw)
+			default:
+				http.Error(w, fmt.Sprintf("Invalid value for %s", name), http.StatusBadRequest)
+				return
+			}
+			normalized, err := definition.normalize(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s %v", name, err), http.StatusBadRequest)
+				return
+			}
+			values[name] = normalized
+		}
+
+		if err := saveSettingOverrides(db, values, time.Now()); err != nil {
+			httpStoreError(w, "Failed to save settings", err)
+			return
+		}
+		for name, value := range values {
+			settingDefinitions[name].apply(value)
+			log.Printf("%s: Set %s to %s", funcName, name, value)
+		}
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		definition, ok := settingDefinitions[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown setting %s", name), http.StatusNotFound)
+			return
+		}
+		if err := deleteSettingOverride(db, name); err != nil {
+			httpStoreError(w, "Failed to reset setting", err)
+			return
+		}
+		definition.apply(settingDefaults[name])
+		log.Printf("%s: Reset %s to %s", funcName, name, settingDefaults[name])
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := listSettings(db)
+	if err != nil {
+		httpStoreError(w, "Failed to list settings", err)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(settings)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// applyRuntimePolicies turns requests away during maintenance and when the client exceeds the rate limit
+// Admin endpoints are exempt so the settings can always be changed back.
+func applyRuntimePolicies(access string, next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next(db, w, r)
+			return
+		}
+
+		if access == ACCESS_WRITE && maintenanceMode.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(MAINTENANCE_RETRY_AFTER))
+			http.Error(w, "Server is in maintenance mode", http.StatusServiceUnavailable)
+			return
+		}
+		if allowed, retryAfter := requestLimiter.Allow(clientIP(r).String()); !allowed {
+			metrics.inc("rate_limited_requests_total")
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(db, w, r)
+	}
+}
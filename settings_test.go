@@ -0,0 +1,160 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setupRuntimeSettings loads the runtime settings of the database and returns a function restoring the defaults
+func setupRuntimeSettings(t *testing.T, db *sql.DB) func() {
+	t.Helper()
+
+	initRuntimeSettings(db)
+	return func() {
+		for name, definition := range settingDefinitions {
+			definition.apply(settingDefaults[name])
+		}
+	}
+}
+
+// Test viewing, overriding and resetting runtime settings with /admin/config
+func TestHandleConfigRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	defer setupRuntimeSettings(t, db)()
+
+	tests := []struct {
+		desc     string
+		method   string
+		target   string
+		body     string
+		status   int
+		expected map[string]string
+	}{
+		{desc: "defaults", method: "GET", target: "/admin/config", status: http.StatusOK, expected: map[string]string{SETTING_LOG_LEVEL: "info", SETTING_RATE_LIMIT: "0", SETTING_LENIENT_PARSING: "false", SETTING_MAINTENANCE: "false"}},
+		{desc: "override", method: "PUT", target: "/admin/config", body: `{"log_level": "WARN", "rate_limit": 120, "maintenance": true}`, status: http.StatusOK, expected: map[string]string{SETTING_LOG_LEVEL: "warn", SETTING_RATE_LIMIT: "120", SETTING_LENIENT_PARSING: "false", SETTING_MAINTENANCE: "true"}},
+		{desc: "invalid value", method: "PATCH", target: "/admin/config", body: `{"lenient_parsing": true, "rate_limit": -1}`, status: http.StatusBadRequest},
+		{desc: "unknown setting", method: "PATCH", target: "/admin/config", body: `{"workers": 4}`, status: http.StatusBadRequest},
+		{desc: "empty", method: "PATCH", target: "/admin/config", body: `{}`, status: http.StatusBadRequest},
+		{desc: "reset", method: "DELETE", target: "/admin/config?name=maintenance", status: http.StatusOK, expected: map[string]string{SETTING_LOG_LEVEL: "warn", SETTING_RATE_LIMIT: "120", SETTING_LENIENT_PARSING: "false", SETTING_MAINTENANCE: "false"}},
+		{desc: "reset unknown", method: "DELETE", target: "/admin/config?name=workers", status: http.StatusNotFound},
+		{desc: "wrong method", method: "POST", target: "/admin/config", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handleConfigRequest(db, w, req)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var settings []RuntimeSetting
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &settings))
+			values := map[string]string{}
+			for _, setting := range settings {
+				values[setting.Name] = setting.Value
+				require.Equal(t, setting.Value != setting.Default, setting.Overridden)
+			}
+			require.Equal(t, tt.expected, values)
+		})
+	}
+
+	// The rejected request changed nothing, the others are in effect and stored
+	require.False(t, lenientByDefault.Load())
+	require.False(t, maintenanceMode.Load())
+	require.Equal(t, LOG_LEVEL_WARN, currentLogLevel())
+	require.Equal(t, 120, requestLimiter.Limit())
+
+	overrides, err := listSettingOverrides(db)
+	require.NoError(t, err)
+	require.Len(t, overrides, 2)
+	require.Equal(t, "120", overrides[SETTING_RATE_LIMIT][0])
+}
+
+// Test applying overrides stored by other instances and ignoring invalid ones
+func TestReloadSettings(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	defer setupRuntimeSettings(t, db)()
+
+	require.NoError(t, saveSettingOverrides(db, map[string]string{SETTING_LENIENT_PARSING: "true", SETTING_LOG_LEVEL: "verbose"}, time.Now()))
+	require.NoError(t, reloadSettings(db))
+	require.True(t, lenientByDefault.Load())
+	require.Equal(t, LOG_LEVEL_INFO, currentLogLevel())
+
+	// Removed overrides revert to the default
+	require.NoError(t, deleteSettingOverride(db, SETTING_LENIENT_PARSING))
+	require.NoError(t, reloadSettings(db))
+	require.False(t, lenientByDefault.Load())
+}
+
+// Test that maintenance mode turns writes away and the rate limit applies, but admin endpoints stay reachable
+func TestApplyRuntimePolicies(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	defer setupRuntimeSettings(t, db)()
+
+	defer func(previous string) { apiKey = previous }(apiKey)
+	apiKey = "test api key"
+
+	maintenanceMode.Store(true)
+	req := httptest.NewRequest("POST", "/add", strings.NewReader("<root/>"))
+	req.Header.Set("Authorization", "Bearer test api key")
+	w := httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+	require.Equal(t, "60", w.Result().Header.Get("Retry-After"))
+
+	req = httptest.NewRequest("GET", "/list", nil)
+	req.Header.Set("Authorization", "Bearer test api key")
+	w = httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	req = httptest.NewRequest("PATCH", "/admin/config", strings.NewReader(`{"maintenance": false, "rate_limit": 1}`))
+	req.Header.Set("Authorization", "Bearer test api key")
+	w = httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.False(t, maintenanceMode.Load())
+
+	for _, status := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req = httptest.NewRequest("GET", "/list", nil)
+		req.Header.Set("Authorization", "Bearer test api key")
+		w = httptest.NewRecorder()
+		handleRequest(db, w, req)
+		require.Equal(t, status, w.Result().StatusCode)
+	}
+	require.NotEmpty(t, w.Result().Header.Get("Retry-After"))
+}
+
+// Test that /add parses leniently by default when lenient_parsing is set
+func TestHandleAddRequestLenientDefault(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	defer setupRuntimeSettings(t, db)()
+
+	broken := `<root id="1"><title>Broken</root>`
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add", strings.NewReader(broken)))
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	lenientByDefault.Store(true)
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add", strings.NewReader(broken)))
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	// The query parameter still wins
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add?lenient=false", strings.NewReader(`<root id="2"><title>Broken</root>`)))
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
@@ -0,0 +1,272 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	PUBLIC_PATH_PREFIX = "/public/" // Path prefix of shared documents "/public/{token}"
+
+	DB_SHARE_TABLE_NAME           = "share"       // Table name of public sharing links in SQLite
+	DB_SHARE_TOKEN_FIELD_NAME     = "token"       // Field name for the random token of the public URL
+	DB_SHARE_DOCUMENT_FIELD_NAME  = "document_id" // Field name for the shared document, a document has at most one link
+	DB_SHARE_CREATEDAT_FIELD_NAME = "created_at"  // Field name for the time the document was shared
+)
+
+// Share is the public link of a document
+type Share struct {
+	DocumentID string
+	URL        string
+	CreatedAt  string
+}
+
+// publicTemplate renders the read-only view of a shared document
+var publicTemplate = template.Must(template.New("public").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{if .Title}}{{.Title}}{{else}}Document {{.ID}}{{end}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; line-height: 1.4; }
+dl.meta dt { font-weight: bold; } ul.tree { list-style: none; padding-left: 1.2em; border-left: 1px solid #ddd; }
+.name { color: #905; font-family: monospace; } .attr { color: #07a; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>{{if .Title}}{{.Title}}{{else}}Document {{.ID}}{{end}}</h1>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<dl class="meta">
+{{if .Authors}}<dt>Authors</dt><dd>{{range $i, $author := .Authors}}{{if $i}}, {{end}}{{$author}}{{end}}</dd>{{end}}
+{{if .CreatedAt}}<dt>Created</dt><dd>{{.CreatedAt}}</dd>{{end}}
+<dt>Revision</dt><dd>{{.Revision}}</dd>
+</dl>
+{{with .Tree}}<ul class="tree">{{template "node" .}}</ul>{{end}}
+</body>
+</html>
+{{define "node"}}<li><span class="name">&lt;{{.Name}}&gt;</span>{{range $name, $value := .Attrs}} <span class="attr">{{$name}}="{{$value}}"</span>{{end}}
+{{with .TextContent}}{{if not $.Children}} {{.}}{{end}}{{end}}
+{{if .Children}}<ul class="tree">{{range .Children}}{{template "node" .}}{{end}}</ul>{{end}}</li>{{end}}
+`))
+
+// publicURL returns the path of the public view of a token
+func publicURL(token string) string {
+	return PUBLIC_PATH_PREFIX + token
+}
+
+// publicToken extracts the token from a "/public/{token}" path
+func publicToken(path string) (string, bool) {
+	if !strings.HasPrefix(path, PUBLIC_PATH_PREFIX) {
+		return "", false
+	}
+	token := path[len(PUBLIC_PATH_PREFIX):]
+	if token == "" || strings.Contains(token, "/") {
+		return "", false
+	}
+	return token, true
+}
+
+// createShareTable creates the table of public sharing links if not exists
+func createShareTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL PRIMARY KEY,
+		"%s" TEXT NOT NULL UNIQUE,
+		"%s" TEXT NOT NULL
+	);
+`, DB_SHARE_TABLE_NAME, DB_SHARE_TOKEN_FIELD_NAME, DB_SHARE_DOCUMENT_FIELD_NAME, DB_SHARE_CREATEDAT_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// shareDocument makes document id public and returns its link
+// Sharing a document which is already shared returns the existing link, so the URL stays stable.
+func shareDocument(db *sql.DB, id string, now time.Time) (*Share, error) {
+	defer observeQuery("shareDocument", time.Now())
+
+	token, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("INSERT OR IGNORE INTO %s (%s, %s, %s) VALUES (?, ?, ?)", DB_SHARE_TABLE_NAME, DB_SHARE_TOKEN_FIELD_NAME, DB_SHARE_DOCUMENT_FIELD_NAME, DB_SHARE_CREATEDAT_FIELD_NAME)
+	err = withDBRetry(func() error {
+		_, err := db.Exec(query, token, id, formatExpiry(now))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getShare(db, id)
+}
+
+// getShare returns the link of document id
+// It returns sql.ErrNoRows if the document isn't shared
+func getShare(db *sql.DB, id string) (*Share, error) {
+	defer observeQuery("getShare", time.Now())
+
+	query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s=?", DB_SHARE_TOKEN_FIELD_NAME, DB_SHARE_CREATEDAT_FIELD_NAME, DB_SHARE_TABLE_NAME, DB_SHARE_DOCUMENT_FIELD_NAME)
+	share := Share{DocumentID: id}
+	var token string
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, id).Scan(&token, &share.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	share.URL = publicURL(token)
+	return &share, nil
+}
+
+// unshareDocument revokes the link of document id, sharing it again gives a new link
+// It returns sql.ErrNoRows if the document isn't shared
+func unshareDocument(db *sql.DB, id string) error {
+	defer observeQuery("unshareDocument", time.Now())
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s=?", DB_SHARE_TABLE_NAME, DB_SHARE_DOCUMENT_FIELD_NAME)
+	return withDBRetry(func() error {
+		result, err := db.Exec(query, id)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// sharedDocumentID returns the document shared under a token
+// It returns sql.ErrNoRows if the token is unknown or revoked
+func sharedDocumentID(db *sql.DB, token string) (string, error) {
+	defer observeQuery("sharedDocumentID", time.Now())
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s=?", DB_SHARE_DOCUMENT_FIELD_NAME, DB_SHARE_TABLE_NAME, DB_SHARE_TOKEN_FIELD_NAME)
+	var id string
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, token).Scan(&id)
+	})
+	return id, err
+}
+
+// handleShareRequest returns (GET), creates (POST) or revokes (DELETE) the public link of a document, e.g. /share?id=1
+func handleShareRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	funcName := "handleShareRequest"
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	var share *Share
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		share, err = getShare(db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Document with ID %s is not shared", id), http.StatusNotFound)
+			return
+		}
+	case http.MethodPost:
+		// Only existing documents can be shared
+		_, err := getDocumentByID(db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+			return
+		}
+		share, err = shareDocument(db, id, time.Now())
+		if err == nil {
+			log.Printf("%s: Shared document %s", funcName, id)
+		}
+	case http.MethodDelete:
+		err = unshareDocument(db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("Document with ID %s is not shared", id), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, "Failed to revoke link", err)
+			return
+		}
+		log.Printf("%s: Revoked link of document %s", funcName, id)
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		httpStoreError(w, "Failed to share document", err)
+		return
+	}
+	share.URL = absoluteURL(r, share.URL)
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(share)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// handlePublicRequest serves the read-only view of a shared document without authentication
+// Only active documents are served; unknown, revoked and inactive links all answer 404 Not Found.
+func handlePublicRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, _ := publicToken(r.URL.Path)
+
+	id, err := sharedDocumentID(db, token)
+	var doc *XMLDoc
+	if err == nil {
+		doc, err = getDocumentByID(db, id)
+	}
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !publiclyVisible(doc, time.Now())) {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, "Failed to fetch shared document", err)
+		return
+	}
+
+	var out strings.Builder
+	if err := publicTemplate.Execute(&out, doc); err != nil {
+		http.Error(w, "Failed to render document", http.StatusInternalServerError)
+		return
+	}
+
+	// The view is static, it needs neither scripts nor the referrer leaking the link
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(out.String()))
+}
+
+// publiclyVisible reports whether a shared document may be served at now, archived, deleted,
+// quarantined, expired and embargoed documents aren't
+func publiclyVisible(doc *XMLDoc, now time.Time) bool {
+	return doc.State == DOC_STATE_ACTIVE && (doc.ExpiresAt == "" || doc.ExpiresAt > formatExpiry(now)) && doc.published(now)
+}
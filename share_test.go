@@ -0,0 +1,71 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test sharing a document, viewing it without credentials and revoking the link
+func TestShareDocument(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	apiKey = "secret"
+	defer func() { apiKey = "" }()
+
+	doc, err := parseDocument(`<document><title>Fish &amp; <i>Chips</i></title><author>Ada</author><body lang="en">Hello</body></document>`)
+	require.NoError(t, err)
+	id, err := addDocument(db, *doc)
+	require.NoError(t, err)
+
+	share := func(method string) (*httptest.ResponseRecorder, Share) {
+		req := httptest.NewRequest(method, "/share?id="+id, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, req)
+		var result Share
+		json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr, result
+	}
+	view := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest(http.MethodGet, url, nil))
+		return rr
+	}
+
+	rr, _ := share(http.MethodGet)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr, first := share(http.MethodPost)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, strings.HasPrefix(first.URL, "http://example.com"+PUBLIC_PATH_PREFIX))
+	_, second := share(http.MethodPost)
+	require.Equal(t, first.URL, second.URL)
+
+	rr = view(first.URL)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	// The metadata is the text of its element, escaped
+	require.Contains(t, rr.Body.String(), "<h1>Fish &amp; Chips</h1>")
+	require.Contains(t, rr.Body.String(), `lang="en"`)
+	require.Contains(t, rr.Body.String(), " Hello")
+
+	// Archived documents aren't served
+	require.NoError(t, changeState(db, id, DOC_STATE_ARCHIVED))
+	require.Equal(t, http.StatusNotFound, view(first.URL).Code)
+	require.NoError(t, changeState(db, id, DOC_STATE_ACTIVE))
+
+	rr, _ = share(http.MethodDelete)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, http.StatusNotFound, view(first.URL).Code)
+	require.Equal(t, http.StatusNotFound, view(PUBLIC_PATH_PREFIX+"unknown").Code)
+
+	// Sharing again gives a new link
+	_, third := share(http.MethodPost)
+	require.NotEqual(t, first.URL, third.URL)
+	require.Equal(t, http.StatusOK, view(third.URL).Code)
+}
@@ -0,0 +1,187 @@
+package goapp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SIGNING_KEY_ENV = "DOC_SIGNING_KEY" // Environment variable holding the key used to sign download URLs
+
+	SIGNED_URL_DEFAULT_TTL = time.Hour          // Lifetime of a signed URL if no ttl is requested
+	SIGNED_URL_MAX_TTL     = 7 * 24 * time.Hour // Longest lifetime a signed URL can be requested with
+
+	RAW_DOCUMENT_PATH_PREFIX = "/document/" // Path prefix of the raw document endpoint "/document/{id}/raw"
+	RAW_DOCUMENT_PATH_SUFFIX = "/raw"       // Path suffix of the raw document endpoint "/document/{id}/raw"
+)
+
+// urlSigningKey is the HMAC key for signed download URLs, set by initSigningKey
+var urlSigningKey []byte
+
+// initSigningKey loads the signing key from the environment
+// Without a configured key a random one is generated, so signed URLs don't survive a restart
+func initSigningKey() {
+	funcName := "initSigningKey"
+
+	if key := os.Getenv(SIGNING_KEY_ENV); key != "" {
+		urlSigningKey = []byte(key)
+		return
+	}
+
+	urlSigningKey = make([]byte, 32)
+	if _, err := rand.Read(urlSigningKey); err != nil {
+		log.Fatalf("%s: Failed to generate signing key: %v", funcName, err)
+	}
+	log.Printf("%s: %s is not set, signed URLs are only valid until the server restarts", funcName, SIGNING_KEY_ENV)
+}
+
+// rawDocumentPath returns the path of the raw document endpoint for id
+func rawDocumentPath(id string) string {
+	return RAW_DOCUMENT_PATH_PREFIX + url.PathEscape(id) + RAW_DOCUMENT_PATH_SUFFIX
+}
+
+// rawDocumentID extracts the document ID from a "/document/{id}/raw" path
+func rawDocumentID(path string) (string, bool) {
+	if !strings.HasPrefix(path, RAW_DOCUMENT_PATH_PREFIX) || !strings.HasSuffix(path, RAW_DOCUMENT_PATH_SUFFIX) {
+		return "", false
+	}
+	id := path[len(RAW_DOCUMENT_PATH_PREFIX) : len(path)-len(RAW_DOCUMENT_PATH_SUFFIX)]
+	if id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+// signature computes the signature granting access to document id until expires
+func signature(id string, expires int64) string {
+	mac := hmac.New(sha256.New, urlSigningKey)
+	fmt.Fprintf(mac, "%s:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signURL returns a signed URL for the raw document id which is valid until expires
+func signURL(id string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", signature(id, expires.Unix()))
+	return rawDocumentPath(id) + "?" + query.Encode()
+}
+
+// verifySignedURL checks the signature and expiry of a signed URL for document id
+func verifySignedURL(id string, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid expires parameter")
+	}
+
+	given, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || len(given) == 0 {
+		return errors.New("missing or invalid signature parameter")
+	}
+	expected, _ := hex.DecodeString(signature(id, expires))
+	if !hmac.Equal(given, expected) {
+		return errors.New("signature mismatch")
+	}
+
+	if now.Unix() > expires {
+		return errors.New("signed URL expired")
+	}
+	return nil
+}
+
+// requireSignedURL is a middleware rejecting requests to the raw document endpoint without a valid signature
+func requireSignedURL(next dbHandler) dbHandler {
+	return func(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+		id, ok := rawDocumentID(r.URL.Path)
+		if !ok {
+			http.Error(w, "404 Not Found", http.StatusNotFound)
+			return
+		}
+
+		err := verifySignedURL(id, r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Access denied: %v", err), http.StatusForbidden)
+			return
+		}
+
+		next(db, w, r)
+	}
+}
+
+// handleSignRequest generates a signed URL for a document, e.g. /sign?id=1&ttl=3600
+func handleSignRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	ttl := SIGNED_URL_DEFAULT_TTL
+	if param := r.URL.Query().Get("ttl"); param != "" {
+		seconds, err := strconv.Atoi(param)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > SIGNED_URL_MAX_TTL {
+			http.Error(w, fmt.Sprintf("ttl must be between 1 and %d seconds", int(SIGNED_URL_MAX_TTL.Seconds())), http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	// Only sign URLs for documents /raw will return
+	_, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+
+	expires := time.Now().Add(ttl)
+	response, err := json.Marshal(struct {
+		URL       string
+		ExpiresAt string
+	}{
+		URL:       absoluteURL(r, signURL(id, expires)),
+		ExpiresAt: expires.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// handleRawRequest returns the XML of a document, it is only reachable through requireSignedURL
+func handleRawRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id, _ := rawDocumentID(r.URL.Path)
+
+	doc, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+
+	// The root element is served after its prolog, like the XML declaration
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(doc.rawXML()))
+}
@@ -0,0 +1,93 @@
+package goapp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test verifying signed URLs
+func TestVerifySignedURL(t *testing.T) {
+	urlSigningKey = []byte("test key")
+
+	now := time.Date(2024, 7, 9, 0, 0, 0, 0, time.UTC)
+	valid, err := url.Parse(signURL("1", now.Add(time.Hour)))
+	require.NoError(t, err)
+	require.Equal(t, "/document/1/raw", valid.Path)
+
+	tests := []struct {
+		desc  string
+		id    string
+		query url.Values
+		now   time.Time
+		err   bool
+	}{
+		{desc: "valid", id: "1", query: valid.Query(), now: now},
+		{desc: "other document", id: "2", query: valid.Query(), now: now, err: true},
+		{desc: "expired", id: "1", query: valid.Query(), now: now.Add(2 * time.Hour), err: true},
+		{desc: "tampered expiry", id: "1", query: url.Values{"expires": {"9999999999"}, "signature": valid.Query()["signature"]}, now: now, err: true},
+		{desc: "missing signature", id: "1", query: url.Values{"expires": valid.Query()["expires"]}, now: now, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := verifySignedURL(tt.id, tt.query, tt.now)
+			if tt.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// Test signing a URL and downloading the raw document with it
+func TestHandleSignAndRawRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	urlSigningKey = []byte("test key")
+
+	doc, err := parseDocument(`<document><title>Test Title</title></document>`)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	req := httptest.NewRequest("GET", "/sign?id=1&ttl=60", nil)
+	w := httptest.NewRecorder()
+	handleRequest(db, w, req)
+
+	resp := w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var signed struct {
+		URL string
+	}
+	require.NoError(t, json.Unmarshal(body, &signed))
+
+	req = httptest.NewRequest("GET", signed.URL, nil)
+	w = httptest.NewRecorder()
+	handleRequest(db, w, req)
+
+	resp = w.Result()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "<document><title>Test Title</title></document>", string(body))
+
+	// Without signature the raw document is not accessible
+	req = httptest.NewRequest("GET", "/document/1/raw", nil)
+	w = httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+	req = httptest.NewRequest("GET", "/sign?id=2", nil)
+	w = httptest.NewRecorder()
+	handleRequest(db, w, req)
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
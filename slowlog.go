@@ -0,0 +1,85 @@
+package goapp
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SLOW_QUERY_ENV = "DOC_SLOW_QUERY_MS" // Environment variable with the duration in milliseconds above which a query is slow
+	SLOW_PARSE_ENV = "DOC_SLOW_PARSE_MS" // Environment variable with the duration in milliseconds above which a parse is slow
+
+	SLOW_QUERY_DEFAULT_THRESHOLD = 200 * time.Millisecond // Duration above which a query is slow by default
+	SLOW_PARSE_DEFAULT_THRESHOLD = 500 * time.Millisecond // Duration above which a parse is slow by default
+)
+
+var (
+	slowQueryThreshold = SLOW_QUERY_DEFAULT_THRESHOLD // Duration above which a query is logged, set by initSlowLogging
+	slowParseThreshold = SLOW_PARSE_DEFAULT_THRESHOLD // Duration above which a parse is logged, set by initSlowLogging
+)
+
+// initSlowLogging loads the slow query and slow parse thresholds from the environment
+func initSlowLogging() {
+	funcName := "initSlowLogging"
+
+	thresholds := map[string]*time.Duration{
+		SLOW_QUERY_ENV: &slowQueryThreshold,
+		SLOW_PARSE_ENV: &slowParseThreshold,
+	}
+	for env, threshold := range thresholds {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms <= 0 {
+			log.Fatalf("%s: %s must be a positive number of milliseconds", funcName, env)
+		}
+		*threshold = time.Duration(ms) * time.Millisecond
+	}
+}
+
+// observeQuery counts a query started at start and logs it if it was slow
+// It is meant to be deferred at the top of database functions: defer observeQuery("name", time.Now())
+func observeQuery(name string, start time.Time) {
+	elapsed := time.Since(start)
+	metrics.inc("db_queries_total", "query", name)
+	metrics.add(elapsed.Seconds(), "db_query_seconds_total", "query", name)
+
+	if elapsed > slowQueryThreshold {
+		metrics.inc("db_slow_queries_total", "query", name)
+		if logEnabled(LOG_LEVEL_WARN) {
+			log.Printf("observeQuery: Slow query %s took %s", name, elapsed)
+		}
+	}
+}
+
+// parseDocumentFrom rejects non-XML payloads, parses a document like parseDocument and logs the parse if it was slow
+// source describes where the data comes from as "kind:detail", e.g. "file:./xml_files/doc.xml" or "http:192.0.2.1"
+func parseDocumentFrom(data string, source string) (*XMLDoc, error) {
+	return parseDocumentFromWithOptions(data, source, ParseOptions{})
+}
+
+// parseDocumentFromWithOptions parses a document like parseDocumentFrom with the given options
+func parseDocumentFromWithOptions(data string, source string, options ParseOptions) (*XMLDoc, error) {
+	start := time.Now()
+	doc, err := ingestDocument(data, source, options)
+	elapsed := time.Since(start)
+
+	kind := strings.SplitN(source, ":", 2)[0]
+	metrics.inc("parses_total", "source", kind)
+	if err != nil {
+		metrics.inc("parse_errors_total", "source", kind)
+	}
+
+	if elapsed > slowParseThreshold {
+		metrics.inc("slow_parses_total", "source", kind)
+		if logEnabled(LOG_LEVEL_WARN) {
+			log.Printf("parseDocumentFrom: Slow parse of %d bytes from %s took %s", len(data), source, elapsed)
+		}
+	}
+	return doc, err
+}
@@ -0,0 +1,52 @@
+package goapp
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that slow queries are logged and counted
+func TestObserveQuery(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	slowQueries := metrics.get("db_slow_queries_total", "query", "testQuery")
+
+	observeQuery("testQuery", time.Now())
+	require.Empty(t, output.String())
+	require.Equal(t, slowQueries, metrics.get("db_slow_queries_total", "query", "testQuery"))
+
+	observeQuery("testQuery", time.Now().Add(-slowQueryThreshold-time.Millisecond))
+	require.Contains(t, output.String(), "Slow query testQuery")
+	require.Equal(t, slowQueries+1, metrics.get("db_slow_queries_total", "query", "testQuery"))
+}
+
+// Test that slow parses are logged with the size and the source of the document
+func TestParseDocumentFromSlow(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	threshold := slowParseThreshold
+	slowParseThreshold = -1
+	defer func() { slowParseThreshold = threshold }()
+
+	parses := metrics.get("parses_total", "source", "file")
+	errors := metrics.get("parse_errors_total", "source", "file")
+
+	data := `<document><title>Test Title</title></document>`
+	_, err := parseDocumentFrom(data, "file:./xml_files/test.xml")
+	require.NoError(t, err)
+	require.Contains(t, output.String(), "Slow parse of 46 bytes from file:./xml_files/test.xml")
+
+	_, err = parseDocumentFrom("", "file:./xml_files/empty.xml")
+	require.Error(t, err)
+	require.Equal(t, parses+2, metrics.get("parses_total", "source", "file"))
+	require.Equal(t, errors+1, metrics.get("parse_errors_total", "source", "file"))
+}
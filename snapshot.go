@@ -0,0 +1,241 @@
+package goapp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SNAPSHOT_ENDPOINT_ENV   = "DOC_SNAPSHOT_S3_ENDPOINT" // Environment variable with the S3 endpoint snapshots are replicated to
+	SNAPSHOT_REGION_ENV     = "DOC_SNAPSHOT_S3_REGION"   // Environment variable with the S3 region
+	SNAPSHOT_BUCKET_ENV     = "DOC_SNAPSHOT_S3_BUCKET"   // Environment variable with the S3 bucket
+	SNAPSHOT_PREFIX_ENV     = "DOC_SNAPSHOT_S3_PREFIX"   // Environment variable with the key prefix of snapshots
+	SNAPSHOT_INTERVAL_ENV   = "DOC_SNAPSHOT_INTERVAL"    // Environment variable with the time between two snapshots, e.g. "5m"
+	SNAPSHOT_ACCESS_KEY_ENV = "AWS_ACCESS_KEY_ID"        // Environment variable with the S3 access key
+	SNAPSHOT_SECRET_KEY_ENV = "AWS_SECRET_ACCESS_KEY"    // Environment variable with the S3 secret key
+
+	SNAPSHOT_DEFAULT_REGION   = "us-east-1"  // Region used when none is configured
+	SNAPSHOT_DEFAULT_PREFIX   = "snapshots/" // Key prefix used when none is configured
+	SNAPSHOT_DEFAULT_INTERVAL = time.Minute  // Time between two snapshots by default
+	SNAPSHOT_KEY_NAME         = "documents-" // Name of snapshot keys before their timestamp
+	SNAPSHOT_KEY_EXTENSION    = ".db.gz"     // Extension of snapshot keys
+)
+
+// ErrNoSnapshot is returned when no snapshot was taken at or before the requested time
+var ErrNoSnapshot = errors.New("no snapshot found")
+
+// Snapshotter replicates the database to S3-compatible storage as timestamped, gzipped snapshots
+type Snapshotter struct {
+	Replica  *S3Client
+	Prefix   string        // Prefix is prepended to the keys of snapshots
+	Interval time.Duration // Interval is the time between two snapshots
+
+	last string // last is the checksum of the last uploaded snapshot
+}
+
+// snapshotter is the replication of the server, nil unless configured by initSnapshots
+var snapshotter *Snapshotter
+
+// initSnapshots sets up replication if an S3 bucket is configured
+func initSnapshots() {
+	funcName := "initSnapshots"
+
+	bucket := os.Getenv(SNAPSHOT_BUCKET_ENV)
+	if bucket == "" {
+		return
+	}
+	endpoint := os.Getenv(SNAPSHOT_ENDPOINT_ENV)
+	if endpoint == "" {
+		log.Fatalf("%s: %s is required with %s", funcName, SNAPSHOT_ENDPOINT_ENV, SNAPSHOT_BUCKET_ENV)
+	}
+	accessKey, secretKey := os.Getenv(SNAPSHOT_ACCESS_KEY_ENV), os.Getenv(SNAPSHOT_SECRET_KEY_ENV)
+	if accessKey == "" || secretKey == "" {
+		log.Fatalf("%s: %s and %s are required with %s", funcName, SNAPSHOT_ACCESS_KEY_ENV, SNAPSHOT_SECRET_KEY_ENV, SNAPSHOT_BUCKET_ENV)
+	}
+	region := os.Getenv(SNAPSHOT_REGION_ENV)
+	if region == "" {
+		region = SNAPSHOT_DEFAULT_REGION
+	}
+	prefix, ok := os.LookupEnv(SNAPSHOT_PREFIX_ENV)
+	if !ok {
+		prefix = SNAPSHOT_DEFAULT_PREFIX
+	}
+
+	interval := SNAPSHOT_DEFAULT_INTERVAL
+	if value := os.Getenv(SNAPSHOT_INTERVAL_ENV); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("%s: %s must be a positive duration like 5m", funcName, SNAPSHOT_INTERVAL_ENV)
+		}
+		interval = parsed
+	}
+
+	snapshotter = &Snapshotter{
+		Replica:  newS3Client(endpoint, region, bucket, accessKey, secretKey),
+		Prefix:   prefix,
+		Interval: interval,
+	}
+}
+
+// snapshotKey returns the key of a snapshot taken at t
+// Keys sort in the order the snapshots were taken
+func (snapshotter *Snapshotter) snapshotKey(t time.Time) string {
+	return snapshotter.Prefix + SNAPSHOT_KEY_NAME + t.UTC().Format(S3_DATE_LAYOUT) + SNAPSHOT_KEY_EXTENSION
+}
+
+// snapshotTime returns the time a snapshot was taken at from its key
+func (snapshotter *Snapshotter) snapshotTime(key string) (time.Time, bool) {
+	name := strings.TrimPrefix(key, snapshotter.Prefix+SNAPSHOT_KEY_NAME)
+	if name == key || !strings.HasSuffix(name, SNAPSHOT_KEY_EXTENSION) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(S3_DATE_LAYOUT, strings.TrimSuffix(name, SNAPSHOT_KEY_EXTENSION))
+	return t, err == nil
+}
+
+// dumpDatabase returns a consistent copy of the database file
+func dumpDatabase(db *sql.DB) ([]byte, error) {
+	defer observeQuery("dumpDatabase", time.Now())
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// VACUUM INTO writes a compacted copy without blocking writers for longer than a read
+	path := filepath.Join(dir, "documents.db")
+	err = withDBRetry(func() error {
+		_, err := db.Exec("VACUUM INTO ?", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Snapshot uploads a snapshot of the database taken at now unless nothing changed since the last one
+// It returns the key of the uploaded snapshot, or an empty key if it was skipped
+func (snapshotter *Snapshotter) Snapshot(db *sql.DB, now time.Time) (string, error) {
+	data, err := dumpDatabase(db)
+	if err != nil {
+		return "", err
+	}
+	sum := checksum(data)
+	if sum == snapshotter.last {
+		return "", nil
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(data); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+
+	key := snapshotter.snapshotKey(now)
+	err = snapshotter.Replica.PutObject(key, compressed.Bytes())
+	if err != nil {
+		return "", err
+	}
+	snapshotter.last = sum
+	metrics.inc("snapshots_total")
+	return key, nil
+}
+
+// ListSnapshots returns the keys of all snapshots, oldest first
+func (snapshotter *Snapshotter) ListSnapshots() ([]string, error) {
+	keys, err := snapshotter.Replica.ListObjects(snapshotter.Prefix + SNAPSHOT_KEY_NAME)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []string{}
+	for _, key := range keys {
+		if _, ok := snapshotter.snapshotTime(key); ok {
+			snapshots = append(snapshots, key)
+		}
+	}
+	return snapshots, nil
+}
+
+// FindSnapshot returns the key of the latest snapshot taken at or before at
+func (snapshotter *Snapshotter) FindSnapshot(at time.Time) (string, error) {
+	keys, err := snapshotter.ListSnapshots()
+	if err != nil {
+		return "", err
+	}
+
+	found := ""
+	for _, key := range keys {
+		if t, _ := snapshotter.snapshotTime(key); !t.After(at) {
+			found = key
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("%w at or before %s", ErrNoSnapshot, formatExpiry(at))
+	}
+	return found, nil
+}
+
+// Restore writes the database of the snapshot with the given key to out, which must not exist yet
+func (snapshotter *Snapshotter) Restore(key string, out string) error {
+	compressed, err := snapshotter.Replica.GetObject(key)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runSnapshotter uploads a snapshot every interval of the snapshotter while this instance leads the snapshotter
+func runSnapshotter(db *sql.DB, snapshotter *Snapshotter) {
+	funcName := "runSnapshotter"
+
+	ticker := time.NewTicker(snapshotter.Interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if !leaderElector.Lead(db, JOB_SNAPSHOTTER, now, 2*snapshotter.Interval) {
+			continue
+		}
+
+		key, err := snapshotter.Snapshot(db, now)
+		if err != nil {
+			metrics.inc("snapshot_errors_total")
+			log.Printf("%s: Failed to upload snapshot: %v", funcName, err)
+			continue
+		}
+		if key != "" {
+			log.Printf("%s: Uploaded snapshot %s", funcName, key)
+		}
+	}
+}
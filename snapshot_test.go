@@ -0,0 +1,74 @@
+package goapp
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test uploading snapshots and restoring one at a point in time
+func TestSnapshotRestore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	client, _, cleanupS3 := setupFakeS3(t)
+	defer cleanupS3()
+
+	snapshotter := &Snapshotter{Replica: client, Prefix: SNAPSHOT_DEFAULT_PREFIX}
+	first := time.Date(2024, 7, 9, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "First"}))
+	key, err := snapshotter.Snapshot(db, first)
+	require.NoError(t, err)
+	require.Equal(t, "snapshots/documents-20240709T120000Z.db.gz", key)
+
+	// Nothing changed, so nothing is uploaded
+	key, err = snapshotter.Snapshot(db, first.Add(time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, key)
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Second"}))
+	_, err = snapshotter.Snapshot(db, second)
+	require.NoError(t, err)
+
+	keys, err := snapshotter.ListSnapshots()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	tests := []struct {
+		desc          string
+		at            time.Time
+		expectedCount int
+		err           error
+	}{
+		{desc: "before first snapshot", at: first.Add(-time.Second), err: ErrNoSnapshot},
+		{desc: "between snapshots", at: second.Add(-time.Second), expectedCount: 1},
+		{desc: "latest", at: second, expectedCount: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			key, err := snapshotter.FindSnapshot(tt.at)
+			if tt.err != nil {
+				require.True(t, errors.Is(err, tt.err))
+				return
+			}
+			require.NoError(t, err)
+
+			out := filepath.Join(t.TempDir(), "restored.db")
+			require.NoError(t, snapshotter.Restore(key, out))
+			// An existing database is never overwritten
+			require.Error(t, snapshotter.Restore(key, out))
+
+			restored, err := sql.Open("sqlite3", out)
+			require.NoError(t, err)
+			defer restored.Close()
+			ids, err := listDocumentIDs(restored, documentStates, false)
+			require.NoError(t, err)
+			require.Len(t, ids, tt.expectedCount)
+		})
+	}
+}
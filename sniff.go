@@ -0,0 +1,49 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	SNIFF_LENGTH = 512      // Number of bytes looked at to recognize non-XML payloads
+	UTF8_BOM     = "\uFEFF" // Byte order mark some editors put in front of UTF-8 files
+)
+
+var (
+	// ErrNotXML is wrapped by the errors of payloads which are obviously not XML
+	ErrNotXML = errors.New("payload is not XML")
+
+	ErrBinaryPayload = fmt.Errorf("%w: binary data", ErrNotXML)   // ErrBinaryPayload is returned for binary payloads like images or archives
+	ErrJSONPayload   = fmt.Errorf("%w: JSON document", ErrNotXML) // ErrJSONPayload is returned for JSON payloads
+	ErrHTMLPayload   = fmt.Errorf("%w: HTML page", ErrNotXML)     // ErrHTMLPayload is returned for HTML pages like upstream error pages
+)
+
+// sniffXML rejects payloads which are obviously not XML by looking at their beginning
+// It is cheap compared to parsing and lets callers answer with a specific error
+func sniffXML(data string) error {
+	head := data
+	if len(head) > SNIFF_LENGTH {
+		head = head[:SNIFF_LENGTH]
+		// Don't count a character cut in half as invalid
+		for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.ValidString(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+
+	if strings.ContainsRune(head, 0) || !utf8.ValidString(head) {
+		return ErrBinaryPayload
+	}
+
+	head = strings.TrimSpace(strings.TrimPrefix(head, UTF8_BOM))
+	if strings.HasPrefix(head, "{") || strings.HasPrefix(head, "[") {
+		return ErrJSONPayload
+	}
+	lower := strings.ToLower(head)
+	if strings.HasPrefix(lower, "<!doctype html") || strings.HasPrefix(lower, "<html") {
+		return ErrHTMLPayload
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package goapp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test recognizing payloads which are not XML
+func TestSniffXML(t *testing.T) {
+	tests := []struct {
+		desc string
+		data string
+		err  error
+	}{
+		{desc: "xml", data: `<document><title>Test Title</title></document>`},
+		{desc: "xml declaration", data: `<?xml version="1.0"?><document></document>`},
+		{desc: "xml with byte order mark", data: "\uFEFF<document></document>"},
+		{desc: "multibyte character at the sniff boundary", data: "<document>" + strings.Repeat("a", SNIFF_LENGTH-11) + "é</document>"},
+		{desc: "binary", data: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", err: ErrBinaryPayload},
+		{desc: "invalid utf-8", data: "<document>\xff\xfe</document>", err: ErrBinaryPayload},
+		{desc: "json object", data: ` {"title": "Test Title"}`, err: ErrJSONPayload},
+		{desc: "json array", data: `[{"title": "Test Title"}]`, err: ErrJSONPayload},
+		{desc: "html page", data: "<!DOCTYPE html>\n<html><body>502 Bad Gateway</body></html>", err: ErrHTMLPayload},
+		{desc: "html without doctype", data: `<HTML><head></head></HTML>`, err: ErrHTMLPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := sniffXML(tt.data)
+			if tt.err != nil {
+				require.Equal(t, tt.err, err)
+				require.True(t, errors.Is(err, ErrNotXML))
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// Test that /add rejects non-XML payloads with 415
+func TestHandleAddRequestNotXML(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(`{"title": "Test Title"}`))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Result().StatusCode)
+	require.Contains(t, w.Body.String(), "payload is not XML: JSON document")
+}
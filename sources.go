@@ -0,0 +1,257 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DB_SOURCE_TABLE_NAME             = "ingest_source" // Table name of ingestion sources in SQLite
+	DB_SOURCE_ID_FIELD_NAME          = "id"            // Field name for id in the ingestion source table
+	DB_SOURCE_NAME_FIELD_NAME        = "name"          // Field name for the source, e.g. "dir:./xml_files"
+	DB_SOURCE_DOCUMENTS_FIELD_NAME   = "documents"     // Field name for the number of documents ingested from the source
+	DB_SOURCE_FAILURES_FIELD_NAME    = "failures"      // Field name for the number of documents of the source which failed to ingest
+	DB_SOURCE_BYTES_FIELD_NAME       = "bytes"         // Field name for the size of the documents ingested from the source
+	DB_SOURCE_LASTSUCCESS_FIELD_NAME = "last_success"  // Field name for the time the last document of the source was ingested
+	DB_SOURCE_LASTFAILURE_FIELD_NAME = "last_failure"  // Field name for the time the last document of the source failed to ingest
+	DB_SOURCE_LASTERROR_FIELD_NAME   = "last_error"    // Field name for the error of the last failure
+
+	SOURCE_STATS_PATH_PREFIX = "/sources/" // Path prefix of the source statistics endpoint, followed by the source ID
+	SOURCE_STATS_PATH_SUFFIX = "/stats"    // Path suffix of the source statistics endpoint
+)
+
+// SourceStats holds the ingestion counters of a source
+type SourceStats struct {
+	ID          int64
+	Name        string // Name identifies the source, e.g. "dir:./xml_files", "key:token-3" or "http:192.0.2.1"
+	Documents   int64
+	Failures    int64
+	Bytes       int64
+	LastSuccess string `json:",omitempty"`
+	LastFailure string `json:",omitempty"`
+	LastError   string `json:",omitempty"`
+}
+
+// createSourceTable creates the ingestion source table if not exists
+func createSourceTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER PRIMARY KEY,
+		"%s" TEXT NOT NULL UNIQUE,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		"%s" TEXT,
+		"%s" TEXT,
+		"%s" TEXT
+	);
+`, DB_SOURCE_TABLE_NAME, DB_SOURCE_ID_FIELD_NAME, DB_SOURCE_NAME_FIELD_NAME, DB_SOURCE_DOCUMENTS_FIELD_NAME, DB_SOURCE_FAILURES_FIELD_NAME, DB_SOURCE_BYTES_FIELD_NAME,
+		DB_SOURCE_LASTSUCCESS_FIELD_NAME, DB_SOURCE_LASTFAILURE_FIELD_NAME, DB_SOURCE_LASTERROR_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// directorySource returns the source of files of a directory
+func directorySource(path string) string {
+	return "dir:" + filepath.Dir(path)
+}
+
+// requestSource returns the source of a document submitted to /add
+// Authenticated clients are told apart by their credential, others by their address.
+func requestSource(db *sql.DB, r *http.Request) string {
+	credential := bearerToken(r)
+	if credential != "" && isAPIKey(credential) {
+		return "key:api"
+	}
+	if credential != "" && apiKey != "" {
+		if token, err := lookupToken(db, credential); err == nil {
+			return fmt.Sprintf("key:token-%d", token.ID)
+		}
+	}
+	return "http:" + clientIP(r).String()
+}
+
+// recordIngest counts a document of size bytes ingested from source, or failed to ingest with ingestErr
+func recordIngest(db *sql.DB, source string, size int, ingestErr error, now time.Time) error {
+	defer observeQuery("recordIngest", time.Now())
+
+	documents, failures, bytes := 1, 0, size
+	var lastSuccess, lastFailure, lastError sql.NullString
+	if ingestErr != nil {
+		documents, failures, bytes = 0, 1, 0
+		lastFailure = sql.NullString{String: formatExpiry(now), Valid: true}
+		lastError = sql.NullString{String: ingestErr.Error(), Valid: true}
+	} else {
+		lastSuccess = sql.NullString{String: formatExpiry(now), Valid: true}
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s, %[6]s, %[7]s, %[8]s) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(%[2]s) DO UPDATE SET
+			%[3]s=%[3]s+excluded.%[3]s,
+			%[4]s=%[4]s+excluded.%[4]s,
+			%[5]s=%[5]s+excluded.%[5]s,
+			%[6]s=COALESCE(excluded.%[6]s, %[6]s),
+			%[7]s=COALESCE(excluded.%[7]s, %[7]s),
+			%[8]s=COALESCE(excluded.%[8]s, %[8]s)
+	`, DB_SOURCE_TABLE_NAME, DB_SOURCE_NAME_FIELD_NAME, DB_SOURCE_DOCUMENTS_FIELD_NAME, DB_SOURCE_FAILURES_FIELD_NAME, DB_SOURCE_BYTES_FIELD_NAME,
+		DB_SOURCE_LASTSUCCESS_FIELD_NAME, DB_SOURCE_LASTFAILURE_FIELD_NAME, DB_SOURCE_LASTERROR_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, source, documents, failures, bytes, lastSuccess, lastFailure, lastError)
+		return err
+	})
+}
+
+// trackIngest records an ingestion like recordIngest and logs if the counters can't be updated
+// Ingestion goes on without the counters, they are only used for dashboards.
+func trackIngest(db *sql.DB, source string, size int, ingestErr error) {
+	funcName := "trackIngest"
+
+	if err := recordIngest(db, source, size, ingestErr, time.Now()); err != nil {
+		log.Printf("%s: Failed to record ingestion from %s: %v", funcName, source, err)
+	}
+}
+
+// sourceColumns lists the columns read for a source, in the order scanSource expects
+var sourceColumns = []string{
+	DB_SOURCE_ID_FIELD_NAME,
+	DB_SOURCE_NAME_FIELD_NAME,
+	DB_SOURCE_DOCUMENTS_FIELD_NAME,
+	DB_SOURCE_FAILURES_FIELD_NAME,
+	DB_SOURCE_BYTES_FIELD_NAME,
+	DB_SOURCE_LASTSUCCESS_FIELD_NAME,
+	DB_SOURCE_LASTFAILURE_FIELD_NAME,
+	DB_SOURCE_LASTERROR_FIELD_NAME,
+}
+
+// scanSource reads a source selected with sourceColumns
+func scanSource(row rowScanner) (*SourceStats, error) {
+	var stats SourceStats
+	var lastSuccess, lastFailure, lastError sql.NullString
+	err := row.Scan(&stats.ID, &stats.Name, &stats.Documents, &stats.Failures, &stats.Bytes, &lastSuccess, &lastFailure, &lastError)
+	if err != nil {
+		return nil, err
+	}
+	stats.LastSuccess = lastSuccess.String
+	stats.LastFailure = lastFailure.String
+	stats.LastError = lastError.String
+	return &stats, nil
+}
+
+// listSources retrieves the counters of all sources ordered by name
+func listSources(db *sql.DB) ([]SourceStats, error) {
+	defer observeQuery("listSources", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s ORDER BY %s
+	`, strings.Join(sourceColumns, ", "), DB_SOURCE_TABLE_NAME, DB_SOURCE_NAME_FIELD_NAME)
+	var sources []SourceStats
+	err := withDBRetry(func() error {
+		rows, err := db.Query(query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		sources = []SourceStats{}
+		for rows.Next() {
+			stats, err := scanSource(rows)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, *stats)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// getSourceStats retrieves the counters of a source by its ID
+func getSourceStats(db *sql.DB, id int64) (*SourceStats, error) {
+	defer observeQuery("getSourceStats", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s WHERE %s=?
+	`, strings.Join(sourceColumns, ", "), DB_SOURCE_TABLE_NAME, DB_SOURCE_ID_FIELD_NAME)
+	var stats *SourceStats
+	err := withDBRetry(func() error {
+		var err error
+		stats, err = scanSource(db.QueryRow(query, id))
+		return err
+	})
+	return stats, err
+}
+
+// sourceStatsID returns the source ID of a statistics path like "/sources/1/stats"
+func sourceStatsID(path string) (string, bool) {
+	if !strings.HasPrefix(path, SOURCE_STATS_PATH_PREFIX) || !strings.HasSuffix(path, SOURCE_STATS_PATH_SUFFIX) {
+		return "", false
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(path, SOURCE_STATS_PATH_PREFIX), SOURCE_STATS_PATH_SUFFIX)
+	if id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+// handleSourcesRequest lists the ingestion counters of all sources
+func handleSourcesRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	sources, err := listSources(db)
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to list sources: %v", err), err)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(sources)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// handleSourceStatsRequest returns the ingestion counters of the source in the path
+func handleSourceStatsRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	param, _ := sourceStatsID(r.URL.Path)
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid source ID %s", param), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := getSourceStats(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Source with ID %d not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch source with ID %d: %v", id, err), err)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,111 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test counting ingestions per source
+func TestRecordIngest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	require.NoError(t, recordIngest(db, "dir:./xml_files", 100, nil, first))
+	require.NoError(t, recordIngest(db, "dir:./xml_files", 50, nil, second))
+	require.NoError(t, recordIngest(db, "dir:./xml_files", 70, errors.New("tag pairing error"), second))
+	require.NoError(t, recordIngest(db, "http:192.0.2.1", 10, nil, first))
+
+	sources, err := listSources(db)
+	require.NoError(t, err)
+	require.Equal(t, []SourceStats{
+		{ID: 1, Name: "dir:./xml_files", Documents: 2, Failures: 1, Bytes: 150, LastSuccess: formatExpiry(second), LastFailure: formatExpiry(second), LastError: "tag pairing error"},
+		{ID: 2, Name: "http:192.0.2.1", Documents: 1, Bytes: 10, LastSuccess: formatExpiry(first)},
+	}, sources)
+
+	stats, err := getSourceStats(db, 2)
+	require.NoError(t, err)
+	require.Equal(t, "http:192.0.2.1", stats.Name)
+}
+
+// Test the sources of documents added through /add
+func TestRequestSource(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(previous string) { apiKey = previous }(apiKey)
+	apiKey = "secret"
+	token, err := mintToken(db, ACCESS_WRITE, "", "", time.Now())
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc       string
+		credential string
+		expected   string
+	}{
+		{desc: "api key", credential: "secret", expected: "key:api"},
+		{desc: "token", credential: token.Token, expected: "key:token-1"},
+		{desc: "anonymous", expected: "http:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/add", nil)
+			if tt.credential != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.credential)
+			}
+			require.Equal(t, tt.expected, requestSource(db, req))
+		})
+	}
+}
+
+// Test the source endpoints after ingesting through /add
+func TestHandleSourcesRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{"<document><title>T</title></document>", "<document><title>T</document>"} {
+		req := httptest.NewRequest("POST", "/add", strings.NewReader(data))
+		w := httptest.NewRecorder()
+		handleAddRequest(db, w, req)
+	}
+
+	req := httptest.NewRequest("GET", "/sources", nil)
+	w := httptest.NewRecorder()
+	handleSourcesRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var sources []SourceStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sources))
+	require.Len(t, sources, 1)
+	require.Equal(t, "http:192.0.2.1", sources[0].Name)
+	require.Equal(t, int64(1), sources[0].Documents)
+	require.Equal(t, int64(1), sources[0].Failures)
+	require.Equal(t, int64(37), sources[0].Bytes)
+
+	tests := []struct {
+		desc   string
+		path   string
+		status int
+	}{
+		{desc: "found", path: "/sources/1/stats", status: http.StatusOK},
+		{desc: "unknown", path: "/sources/9/stats", status: http.StatusNotFound},
+		{desc: "invalid", path: "/sources/x/stats", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			access, handler := routeRequest(req)
+			require.Equal(t, ACCESS_READ, access)
+			w := httptest.NewRecorder()
+			handler(db, w, req)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+		})
+	}
+}
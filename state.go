@@ -0,0 +1,93 @@
+package goapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DOC_STATE_ACTIVE      = "active"      // State of a document which is visible in listings
+	DOC_STATE_ARCHIVED    = "archived"    // State of a document which was moved to the archive
+	DOC_STATE_QUARANTINED = "quarantined" // State of a document which is held back for review
+	DOC_STATE_DELETED     = "deleted"     // State of a document which was deleted but can still be restored
+)
+
+// ErrInvalidTransition is returned when a document can't move from its current state to the requested one
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// documentStates lists all document states
+var documentStates = []string{DOC_STATE_ACTIVE, DOC_STATE_ARCHIVED, DOC_STATE_QUARANTINED, DOC_STATE_DELETED}
+
+// stateTransitions lists the states each state can move to
+var stateTransitions = map[string][]string{
+	DOC_STATE_ACTIVE:      {DOC_STATE_ARCHIVED, DOC_STATE_QUARANTINED, DOC_STATE_DELETED},
+	DOC_STATE_ARCHIVED:    {DOC_STATE_ACTIVE, DOC_STATE_DELETED},
+	DOC_STATE_QUARANTINED: {DOC_STATE_ACTIVE, DOC_STATE_DELETED},
+	DOC_STATE_DELETED:     {DOC_STATE_ACTIVE},
+}
+
+// isValidState reports whether state is a known document state
+func isValidState(state string) bool {
+	_, ok := stateTransitions[state]
+	return ok
+}
+
+// canTransition reports whether a document in state from may move to state to
+func canTransition(from string, to string) bool {
+	for _, state := range stateTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionDocument moves the document with the given ID to state to
+// It returns sql.ErrNoRows if the document doesn't exist
+func transitionDocument(db *sql.DB, id string, to string) error {
+	defer observeQuery("transitionDocument", time.Now())
+
+	return withDBRetry(func() error {
+		return changeState(db, id, to)
+	})
+}
+
+// changeState runs a single attempt of transitionDocument
+func changeState(db *sql.DB, id string, to string) error {
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s WHERE %s=?
+	`, DB_STATE_FIELD_NAME, DB_TABLE_NAME, DB_ID_FIELD_NAME)
+	var from string
+	err := db.QueryRow(query, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+	if to == DOC_STATE_DELETED {
+		if err := checkNotHeld(db, id); err != nil {
+			return err
+		}
+	}
+
+	// Only update if the state wasn't changed concurrently since it was read
+	query = fmt.Sprintf(`
+		UPDATE %s SET %s=? WHERE %s=? AND %s=?
+	`, DB_TABLE_NAME, DB_STATE_FIELD_NAME, DB_ID_FIELD_NAME, DB_STATE_FIELD_NAME)
+	result, err := db.Exec(query, to, id, from)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: state of document changed concurrently", ErrInvalidTransition)
+	}
+	return nil
+}
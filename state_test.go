@@ -0,0 +1,147 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test moving a document through its states
+func TestTransitionDocument(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Test Title"}))
+
+	tests := []struct {
+		desc          string
+		to            string
+		expectedState string
+		err           error
+	}{
+		{
+			desc:          "archive",
+			to:            DOC_STATE_ARCHIVED,
+			expectedState: DOC_STATE_ARCHIVED,
+		}, {
+			desc:          "quarantine archived document",
+			to:            DOC_STATE_QUARANTINED,
+			expectedState: DOC_STATE_ARCHIVED,
+			err:           ErrInvalidTransition,
+		}, {
+			desc:          "unarchive",
+			to:            DOC_STATE_ACTIVE,
+			expectedState: DOC_STATE_ACTIVE,
+		}, {
+			desc:          "delete",
+			to:            DOC_STATE_DELETED,
+			expectedState: DOC_STATE_DELETED,
+		}, {
+			desc:          "restore",
+			to:            DOC_STATE_ACTIVE,
+			expectedState: DOC_STATE_ACTIVE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := transitionDocument(db, "1", tt.to)
+			if tt.err != nil {
+				require.True(t, errors.Is(err, tt.err))
+			} else {
+				require.NoError(t, err)
+			}
+
+			doc, err := getDocumentByID(db, "1")
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedState, doc.State)
+		})
+	}
+
+	err := transitionDocument(db, "2", DOC_STATE_ARCHIVED)
+	require.True(t, errors.Is(err, sql.ErrNoRows))
+}
+
+// Test handling state transition requests
+func TestHandleStateRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Test Title"}))
+
+	tests := []struct {
+		desc         string
+		path         string
+		expectedCode int
+	}{
+		{desc: "archive", path: "/archive?id=1", expectedCode: http.StatusOK},
+		{desc: "archive twice", path: "/archive?id=1", expectedCode: http.StatusConflict},
+		{desc: "unarchive", path: "/unarchive?id=1", expectedCode: http.StatusOK},
+		{desc: "quarantine", path: "/state?id=1&to=quarantined", expectedCode: http.StatusOK},
+		{desc: "unknown state", path: "/state?id=1&to=lost", expectedCode: http.StatusBadRequest},
+		{desc: "missing id", path: "/archive", expectedCode: http.StatusBadRequest},
+		{desc: "unknown document", path: "/archive?id=2", expectedCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, nil)
+			w := httptest.NewRecorder()
+
+			handleRequest(db, w, req)
+
+			require.Equal(t, tt.expectedCode, w.Result().StatusCode)
+		})
+	}
+}
+
+// Test filtering listings by state
+func TestHandleListRequestStateFilter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Active"}))
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Archived", State: DOC_STATE_ARCHIVED}))
+	require.NoError(t, insertDocument(db, XMLDoc{Title: "Quarantined", State: DOC_STATE_QUARANTINED}))
+
+	tests := []struct {
+		desc           string
+		path           string
+		expectedCode   int
+		expectedTitles []string
+	}{
+		{desc: "default", path: "/list", expectedCode: http.StatusOK, expectedTitles: []string{"Active"}},
+		{desc: "archived", path: "/list?state=archived", expectedCode: http.StatusOK, expectedTitles: []string{"Archived"}},
+		{desc: "several states", path: "/list?state=active,quarantined", expectedCode: http.StatusOK, expectedTitles: []string{"Active", "Quarantined"}},
+		{desc: "invalid state", path: "/list?state=lost", expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+
+			handleRequest(db, w, req)
+
+			resp := w.Result()
+			require.Equal(t, tt.expectedCode, resp.StatusCode)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			var docs []XMLDoc
+			require.NoError(t, json.Unmarshal(body, &docs))
+			var titles []string
+			for _, doc := range docs {
+				titles = append(titles, doc.Title)
+			}
+			require.EqualValues(t, tt.expectedTitles, titles)
+		})
+	}
+}
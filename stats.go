@@ -0,0 +1,112 @@
+package goapp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DocumentStats holds text statistics of a document, computed when it is parsed
+type DocumentStats struct {
+	Words      int // Words is the number of whitespace separated words of the text
+	Characters int // Characters is the number of characters of the text, single spaces between words included
+	Elements   int // Elements is the number of elements, the root included
+	MaxDepth   int // MaxDepth is the nesting level of the deepest element, 1 for the root
+}
+
+// documentSortColumns maps the sort keys of /list to their columns
+var documentSortColumns = map[string]string{
+	"id":         DB_ID_FIELD_NAME,
+	"words":      DB_WORDCOUNT_FIELD_NAME,
+	"characters": DB_CHARCOUNT_FIELD_NAME,
+	"elements":   DB_ELEMENTCOUNT_FIELD_NAME,
+	"depth":      DB_MAXDEPTH_FIELD_NAME,
+}
+
+// scanXML walks data calling tag for every tag and text for every text segment and CDATA content
+// Comments are skipped. Malformed data is walked as far as possible, the parser reports the errors.
+func scanXML(data string, tag func(string), text func(string)) {
+	for i := 0; i < len(data); {
+		if end := sectionEnd(data, i); end > 0 {
+			if strings.HasPrefix(data[i:], CDATA_START) {
+				text(data[i+len(CDATA_START) : end-len(CDATA_END)])
+			}
+			i = end
+			continue
+		}
+		if data[i] == '<' {
+			end := tagEnd(data, i, ">")
+			if end < 0 {
+				return
+			}
+			tag(data[i : end+1])
+			i = end + 1
+			continue
+		}
+
+		end := strings.IndexByte(data[i:], '<')
+		if end < 0 {
+			end = len(data) - i
+		}
+		text(decodeEntities(data[i : i+end]))
+		i += end
+	}
+}
+
+// extractText returns the text of data without markup, with whitespace collapsed to single spaces
+// Text of adjacent elements is separated by a space so "<a>x</a><b>y</b>" gives "x y"
+func extractText(data string) string {
+	var result strings.Builder
+	scanXML(data, func(string) {
+		result.WriteByte(' ')
+	}, func(text string) {
+		result.WriteString(text)
+	})
+	return strings.Join(strings.Fields(result.String()), " ")
+}
+
+// computeStats computes the text statistics of data
+func computeStats(data string) DocumentStats {
+	stats := DocumentStats{}
+	depth := 0
+	scanXML(data, func(tag string) {
+		switch {
+		case strings.HasPrefix(tag, "</"):
+			depth--
+		case strings.HasPrefix(tag, "<?"), strings.HasPrefix(tag, "<!"):
+			// Declarations aren't elements
+		default:
+			stats.Elements++
+			if depth+1 > stats.MaxDepth {
+				stats.MaxDepth = depth + 1
+			}
+			if !strings.HasSuffix(tag, "/>") {
+				depth++
+			}
+		}
+	}, func(string) {})
+
+	text := extractText(data)
+	stats.Words = len(strings.Fields(text))
+	stats.Characters = utf8.RuneCountInString(text)
+	return stats
+}
+
+// parseDocumentSort turns the sort parameter of /list like "words" or "-depth" into an ORDER BY clause
+// A leading '-' sorts in descending order, ties are ordered by ID
+func parseDocumentSort(param string) (string, error) {
+	if param == "" {
+		return DB_ID_FIELD_NAME, nil
+	}
+
+	direction := "ASC"
+	key := param
+	if strings.HasPrefix(key, "-") {
+		direction, key = "DESC", key[1:]
+	}
+	column, ok := documentSortColumns[key]
+	if !ok {
+		return "", fmt.Errorf("invalid sort %s", param)
+	}
+	return fmt.Sprintf("%s %s, %s", column, direction, DB_ID_FIELD_NAME), nil
+}
@@ -0,0 +1,103 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test computing text statistics
+func TestComputeStats(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		text     string
+		expected DocumentStats
+	}{
+		{
+			desc:     "flat",
+			data:     "<document><title>Test Title</title><author>Test Author</author></document>",
+			text:     "Test Title Test Author",
+			expected: DocumentStats{Words: 4, Characters: 22, Elements: 3, MaxDepth: 2},
+		},
+		{
+			desc:     "nested with empty element",
+			data:     `<document><section><p>One <b>two</b></p><br/></section></document>`,
+			text:     "One two",
+			expected: DocumentStats{Words: 2, Characters: 7, Elements: 5, MaxDepth: 4},
+		},
+		{
+			desc:     "cdata, comments and entities",
+			data:     `<?xml version="1.0"?><document><!-- draft --><title><![CDATA[Fish <&> Chips]]></title><note>a &amp; b</note></document>`,
+			text:     "Fish <&> Chips a & b",
+			expected: DocumentStats{Words: 6, Characters: 20, Elements: 3, MaxDepth: 2},
+		},
+		{
+			desc:     "multibyte characters",
+			data:     "<document>héllo wörld</document>",
+			text:     "héllo wörld",
+			expected: DocumentStats{Words: 2, Characters: 11, Elements: 1, MaxDepth: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.text, extractText(tt.data))
+			require.Equal(t, tt.expected, computeStats(tt.data))
+		})
+	}
+}
+
+// Test sorting the document list by statistics
+func TestHandleListRequestSort(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		"<document><title>Two Words</title></document>",
+		"<document><title>One</title></document>",
+		"<document><section><title>Three Words Here</title></section></document>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	tests := []struct {
+		desc   string
+		sort   string
+		status int
+		ids    []string
+	}{
+		{desc: "default", status: http.StatusOK, ids: []string{"1", "2", "3"}},
+		{desc: "words", sort: "words", status: http.StatusOK, ids: []string{"2", "1", "3"}},
+		{desc: "words descending", sort: "-words", status: http.StatusOK, ids: []string{"3", "1", "2"}},
+		{desc: "depth ties by id", sort: "-depth", status: http.StatusOK, ids: []string{"3", "1", "2"}},
+		{desc: "unknown", sort: "title", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/list?sort="+tt.sort, nil)
+			w := httptest.NewRecorder()
+			handleListRequest(db, w, req)
+
+			require.Equal(t, tt.status, w.Result().StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var docs []XMLDoc
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &docs))
+			var ids []string
+			for _, doc := range docs {
+				ids = append(ids, doc.ID)
+			}
+			require.Equal(t, tt.ids, ids)
+		})
+	}
+
+	doc, err := getDocumentByID(db, "3")
+	require.NoError(t, err)
+	require.Equal(t, DocumentStats{Words: 3, Characters: 16, Elements: 3, MaxDepth: 3}, doc.Stats)
+}
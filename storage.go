@@ -0,0 +1,240 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DB_TENANT_FIELD_NAME    = "tenant"     // Field name for the tenant which added the document in SQLite table, empty for other sources
+	DB_COLDBYTES_FIELD_NAME = "cold_bytes" // Field name for the size of the compressed XML of a document in cold storage in SQLite table
+
+	DB_STORAGE_TABLE_NAME           = "storage_usage" // Table name of the storage used per tenant, author, document type and tier in SQLite
+	DB_STORAGE_TENANT_FIELD_NAME    = "tenant"        // Field name for the tenant which added the documents
+	DB_STORAGE_AUTHOR_FIELD_NAME    = "author"        // Field name for the author of the documents
+	DB_STORAGE_DOCTYPE_FIELD_NAME   = "doc_type"      // Field name for the DOCTYPE of the documents
+	DB_STORAGE_TIER_FIELD_NAME      = "tier"          // Field name for the TIER_* the XML of the documents is stored in
+	DB_STORAGE_DOCUMENTS_FIELD_NAME = "documents"     // Field name for the number of documents
+	DB_STORAGE_BYTES_FIELD_NAME     = "bytes"         // Field name for the bytes used by the XML of the documents
+)
+
+// StorageTotal is the storage used by the documents sharing a tenant, author, document type or tier
+type StorageTotal struct {
+	Name      string
+	Documents int64
+	Bytes     int64
+}
+
+// StorageReport is the response of /admin/storage
+type StorageReport struct {
+	Documents int64
+	Bytes     int64
+	Tenants   []StorageTotal // Tenants break the storage down by the tenant which added the documents, "" for documents added otherwise
+	Authors   []StorageTotal
+	DocTypes  []StorageTotal // DocTypes break the storage down by the DOCTYPE of the documents, "" for documents without
+	Tiers     []StorageTotal // Tiers break the storage down by TIER_*, cold documents count with their compressed size
+}
+
+// storageKeyColumns are the columns of storage_usage identifying a row, with the expression giving each from a document row
+var storageKeyColumns = []struct {
+	Column     string
+	Expression string
+}{
+	{DB_STORAGE_TENANT_FIELD_NAME, "COALESCE(%[1]s." + DB_TENANT_FIELD_NAME + ", '')"},
+	{DB_STORAGE_AUTHOR_FIELD_NAME, "COALESCE(%[1]s." + DB_AUTHOR_FIELD_NAME + ", '')"},
+	{DB_STORAGE_DOCTYPE_FIELD_NAME, "COALESCE(%[1]s." + DB_DOCTYPE_FIELD_NAME + ", '')"},
+	{DB_STORAGE_TIER_FIELD_NAME, "COALESCE(%[1]s." + DB_TIER_FIELD_NAME + ", '" + TIER_HOT + "')"},
+}
+
+// storageBytesExpression gives the bytes used by a document row: its XML in the table, or in cold storage once moved
+const storageBytesExpression = "CASE WHEN %[1]s." + DB_TIER_FIELD_NAME + " IS NULL THEN LENGTH(CAST(%[1]s." + DB_XMLDATA_FIELD_NAME + " AS BLOB)) ELSE COALESCE(%[1]s." + DB_COLDBYTES_FIELD_NAME + ", 0) END"
+
+// createStorageTable creates the storage usage table if not exists, with the triggers keeping it up to date
+// The triggers count every write as it happens, so reports don't scan the documents. The table is filled from the
+// documents once, when the triggers are created for the first time.
+func createStorageTable(db *sql.DB) error {
+	var keys []string
+	for _, key := range storageKeyColumns {
+		keys = append(keys, key.Column)
+	}
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (%s)
+	);
+`, DB_STORAGE_TABLE_NAME, DB_STORAGE_TENANT_FIELD_NAME, DB_STORAGE_AUTHOR_FIELD_NAME, DB_STORAGE_DOCTYPE_FIELD_NAME, DB_STORAGE_TIER_FIELD_NAME,
+		DB_STORAGE_DOCUMENTS_FIELD_NAME, DB_STORAGE_BYTES_FIELD_NAME, strings.Join(keys, ", "))
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='trigger' AND name LIKE ?", DB_STORAGE_TABLE_NAME+"_%").Scan(&existing)
+	if err != nil {
+		return err
+	}
+	if existing == 0 {
+		if err := fillStorageTable(tx); err != nil {
+			return err
+		}
+	}
+
+	// Each trigger takes the old row of the document off its totals and adds the new one
+	tracked := []string{DB_TENANT_FIELD_NAME, DB_AUTHOR_FIELD_NAME, DB_DOCTYPE_FIELD_NAME, DB_TIER_FIELD_NAME, DB_XMLDATA_FIELD_NAME, DB_COLDBYTES_FIELD_NAME}
+	for _, trigger := range []struct {
+		Name  string
+		Event string
+		Rows  []string
+	}{
+		{"insert", "INSERT", []string{"NEW"}},
+		{"update", "UPDATE OF " + strings.Join(tracked, ", "), []string{"OLD", "NEW"}},
+		{"delete", "DELETE", []string{"OLD"}},
+	} {
+		var statements []string
+		for _, row := range trigger.Rows {
+			sign := "+"
+			if row == "OLD" {
+				sign = "-"
+			}
+			statements = append(statements, storageStatements(row, sign)...)
+		}
+		name := DB_STORAGE_TABLE_NAME + "_" + trigger.Name
+		query = fmt.Sprintf(`
+		CREATE TRIGGER %s AFTER %s ON %s
+		BEGIN
+			%s;
+			DELETE FROM %s WHERE %s=0;
+		END;
+`, name, trigger.Event, DB_TABLE_NAME, strings.Join(statements, ";\n\t\t\t"), DB_STORAGE_TABLE_NAME, DB_STORAGE_DOCUMENTS_FIELD_NAME)
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// storageStatements returns the statements adding (sign "+") or taking off (sign "-") the document row of a
+// trigger, NEW or OLD, to the totals of its tenant, author, document type and tier
+func storageStatements(row string, sign string) []string {
+	var columns, values, conditions []string
+	for _, key := range storageKeyColumns {
+		columns = append(columns, key.Column)
+		values = append(values, fmt.Sprintf(key.Expression, row))
+		conditions = append(conditions, fmt.Sprintf("%s=%s", key.Column, fmt.Sprintf(key.Expression, row)))
+	}
+	return []string{
+		fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (%s)", DB_STORAGE_TABLE_NAME, strings.Join(columns, ", "), strings.Join(values, ", ")),
+		fmt.Sprintf("UPDATE %s SET %s=%s%s1, %s=%s%s(%s) WHERE %s", DB_STORAGE_TABLE_NAME,
+			DB_STORAGE_DOCUMENTS_FIELD_NAME, DB_STORAGE_DOCUMENTS_FIELD_NAME, sign,
+			DB_STORAGE_BYTES_FIELD_NAME, DB_STORAGE_BYTES_FIELD_NAME, sign, fmt.Sprintf(storageBytesExpression, row),
+			strings.Join(conditions, " AND ")),
+	}
+}
+
+// fillStorageTable computes the storage usage table from the documents
+func fillStorageTable(tx *sql.Tx) error {
+	var columns, values []string
+	for _, key := range storageKeyColumns {
+		columns = append(columns, key.Column)
+		values = append(values, fmt.Sprintf(key.Expression, DB_TABLE_NAME))
+	}
+	if _, err := tx.Exec("DELETE FROM " + DB_STORAGE_TABLE_NAME); err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s) SELECT %s, COUNT(*), SUM(%s) FROM %s GROUP BY %s
+	`, DB_STORAGE_TABLE_NAME, strings.Join(columns, ", "), DB_STORAGE_DOCUMENTS_FIELD_NAME, DB_STORAGE_BYTES_FIELD_NAME,
+		strings.Join(values, ", "), fmt.Sprintf(storageBytesExpression, DB_TABLE_NAME), DB_TABLE_NAME, strings.Join(values, ", "))
+	_, err := tx.Exec(query)
+	return err
+}
+
+// getStorageReport sums up the storage usage table by tenant, author, document type and tier
+func getStorageReport(db *sql.DB) (StorageReport, error) {
+	defer observeQuery("getStorageReport", time.Now())
+
+	var report StorageReport
+	err := withDBRetry(func() error {
+		report = StorageReport{}
+		query := fmt.Sprintf("SELECT COALESCE(SUM(%s), 0), COALESCE(SUM(%s), 0) FROM %s", DB_STORAGE_DOCUMENTS_FIELD_NAME, DB_STORAGE_BYTES_FIELD_NAME, DB_STORAGE_TABLE_NAME)
+		if err := db.QueryRow(query).Scan(&report.Documents, &report.Bytes); err != nil {
+			return err
+		}
+		for column, totals := range map[string]*[]StorageTotal{
+			DB_STORAGE_TENANT_FIELD_NAME:  &report.Tenants,
+			DB_STORAGE_AUTHOR_FIELD_NAME:  &report.Authors,
+			DB_STORAGE_DOCTYPE_FIELD_NAME: &report.DocTypes,
+			DB_STORAGE_TIER_FIELD_NAME:    &report.Tiers,
+		} {
+			var err error
+			*totals, err = sumStorage(db, column)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+// sumStorage returns the storage used per value of column, the largest first
+func sumStorage(db *sql.DB, column string) ([]StorageTotal, error) {
+	query := fmt.Sprintf(`
+		SELECT %s, SUM(%s), SUM(%s) FROM %s GROUP BY %s ORDER BY SUM(%s) DESC, %s
+	`, column, DB_STORAGE_DOCUMENTS_FIELD_NAME, DB_STORAGE_BYTES_FIELD_NAME, DB_STORAGE_TABLE_NAME, column, DB_STORAGE_BYTES_FIELD_NAME, column)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []StorageTotal{}
+	for rows.Next() {
+		var total StorageTotal
+		if err := rows.Scan(&total.Name, &total.Documents, &total.Bytes); err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
+// handleStorageRequest reports the storage used by the documents, for capacity planning
+func handleStorageRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	report, err := getStorageReport(db)
+	if err != nil {
+		httpStoreError(w, "Failed to load storage usage", err)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
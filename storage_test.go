@@ -0,0 +1,88 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the storage report follows the writes to the documents
+func TestHandleStorageRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	apiKey = "test api key"
+	defer func() { apiKey = "" }()
+
+	request := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, req)
+		return rr
+	}
+	report := func() StorageReport {
+		rr := request("GET", "/admin/storage", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var report StorageReport
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		return report
+	}
+
+	memo := `<memo><author>Ann</author><body>Short</body></memo>`
+	require.Equal(t, http.StatusCreated, request("POST", "/add", memo).Code)
+	letter, err := parseDocument(`<!DOCTYPE letter><letter><author>Bob</author><body>A longer letter</body></letter>`)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *letter))
+	require.NoError(t, insertDocument(db, *letter))
+
+	memoDoc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "key:api", memoDoc.Tenant)
+	memoBytes, letterBytes := int64(len(strings.Join(memoDoc.XMLData, SPLIT_XMLDATA_STR))), int64(len(strings.Join(letter.XMLData, SPLIT_XMLDATA_STR)))
+	got := report()
+	require.Equal(t, int64(3), got.Documents)
+	require.Equal(t, memoBytes+2*letterBytes, got.Bytes)
+	require.Equal(t, []StorageTotal{{Name: "", Documents: 2, Bytes: 2 * letterBytes}, {Name: "key:api", Documents: 1, Bytes: memoBytes}}, got.Tenants)
+	require.Equal(t, []StorageTotal{{Name: "Bob", Documents: 2, Bytes: 2 * letterBytes}, {Name: "Ann", Documents: 1, Bytes: memoBytes}}, got.Authors)
+	require.Equal(t, []StorageTotal{{Name: "letter", Documents: 2, Bytes: 2 * letterBytes}, {Name: "", Documents: 1, Bytes: memoBytes}}, got.DocTypes)
+
+	// Deleted documents are taken off, cold documents count with the size of their compressed XML
+	require.NoError(t, deleteDocumentByID(db, "3"))
+	coldStore = dirColdStore(t.TempDir())
+	defer func() { coldStore = nil }()
+	moved, err := moveToColdStorage(db, coldStore, time.Now().Add(48*time.Hour), 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, 2, moved)
+	_, err = getPublishedDocumentByID(db, "1", time.Now())
+	require.NoError(t, err)
+
+	var coldBytes int64
+	require.NoError(t, db.QueryRow("SELECT "+DB_COLDBYTES_FIELD_NAME+" FROM "+DB_TABLE_NAME+" WHERE "+DB_ID_FIELD_NAME+"=2").Scan(&coldBytes))
+	got = report()
+	require.Equal(t, int64(2), got.Documents)
+	require.Equal(t, []StorageTotal{{Name: TIER_COLD, Documents: 1, Bytes: coldBytes}, {Name: TIER_HOT, Documents: 1, Bytes: memoBytes}}, got.Tiers)
+	require.Len(t, got.Authors, 2)
+
+	// The table is filled from the documents when the triggers are created for the first time
+	_, err = db.Exec("DROP TRIGGER " + DB_STORAGE_TABLE_NAME + "_insert")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *letter))
+	require.Equal(t, int64(2), report().Documents)
+	for _, name := range []string{"update", "delete"} {
+		_, err = db.Exec("DROP TRIGGER " + DB_STORAGE_TABLE_NAME + "_" + name)
+		require.NoError(t, err)
+	}
+	require.NoError(t, createStorageTable(db))
+	require.Equal(t, int64(3), report().Documents)
+
+	// Only admins see the report
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/admin/storage", nil))
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+}
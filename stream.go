@@ -0,0 +1,374 @@
+package goapp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	STREAM_TEXT_CHUNK_SIZE = 64 * 1024                      // Size at which long text is passed to the handler in several Text events
+	STREAM_MAX_TAG_LENGTH  = 1024 * 1024                    // Longest tag, attributes included, which is accepted by ParseReader
+	STREAM_LINE_BUFFER     = 4 * PARSE_ERROR_SNIPPET_LENGTH // Bytes of the current line kept for the snippets of parse errors
+)
+
+// StreamHandler receives the events of ParseReader in document order
+// Parsing stops at the first error returned by a handler method and ParseReader returns it.
+type StreamHandler interface {
+	// StartElement is called for every start tag and self-closing tag
+	StartElement(name string, attrs map[string]string) error
+	// EndElement is called for every end tag, and right after StartElement for self-closing tags
+	EndElement(name string) error
+	// Text is called for text and CDATA content between tags
+	// Long text may come in several calls, text of only whitespace isn't passed.
+	Text(text string) error
+}
+
+// CommentHandler is implemented by handlers which receive comments, like <!-- if x > 3 -->
+// Comments of other handlers are skipped.
+type CommentHandler interface {
+	// Comment is called with the text between <!-- and --> of every comment, also of those outside the root element
+	Comment(text string) error
+}
+
+// streamPosition is a 1-based line and column, counted in characters
+type streamPosition struct {
+	Line int
+	Col  int
+}
+
+// streamParser holds the state of ParseReader
+type streamParser struct {
+	in        *bufio.Reader
+	handler   StreamHandler
+	stack     []string        // stack holds the names of the open elements
+	text      strings.Builder // text holds the text read since the last tag
+	pos       streamPosition  // pos is the position of the next character
+	mark      streamPosition  // mark is the position of the '<' starting the last markup
+	textStart streamPosition  // textStart is the position of the first character of text
+	line      []byte          // line holds the end of the current line, up to STREAM_LINE_BUFFER bytes
+	lineStart int             // lineStart is the column of the first character of line
+}
+
+// ParseReader parses the XML read from r and passes its elements and text to handler as they are read
+// Unlike parseXML it doesn't hold the document in memory, so large files can be processed. Processing
+// instructions and declarations are skipped, and so are comments unless the handler is a CommentHandler. Text and attribute values are always entity
+// decoded, DOC_DECODE_ENTITIES only applies to the metadata. Malformed XML gives a *ParseError.
+func ParseReader(r io.Reader, handler StreamHandler) error {
+	parser := &streamParser{in: bufio.NewReader(r), handler: handler, pos: streamPosition{Line: 1, Col: 1}, lineStart: 1}
+	for {
+		char, err := parser.readByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if char != '<' {
+			if parser.text.Len() == 0 {
+				parser.textStart = streamPosition{Line: parser.pos.Line, Col: parser.pos.Col - 1}
+			}
+			parser.text.WriteByte(char)
+			if parser.text.Len() >= STREAM_TEXT_CHUNK_SIZE {
+				if err := parser.flushText(false); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		parser.mark = streamPosition{Line: parser.pos.Line, Col: parser.pos.Col - 1}
+		if err := parser.flushText(true); err != nil {
+			return err
+		}
+		if err := parser.readMarkup(); err != nil {
+			return err
+		}
+	}
+
+	if err := parser.flushText(true); err != nil {
+		return err
+	}
+	if len(parser.stack) > 0 {
+		return parser.errorAt(parser.pos, fmt.Sprintf("unclosed tag error: <%s>", parser.stack[len(parser.stack)-1]))
+	}
+	return nil
+}
+
+// flushText passes the text read so far to the handler
+// Unless all is set a trailing entity reference which may still be incomplete is kept for the next call.
+func (parser *streamParser) flushText(all bool) error {
+	text := parser.text.String()
+	rest := ""
+	if !all {
+		if i := strings.LastIndexByte(text, '&'); i >= 0 && len(text)-i <= ENTITY_MAX_LENGTH+1 && !strings.Contains(text[i:], ";") {
+			text, rest = text[:i], text[i:]
+		}
+	}
+	parser.text.Reset()
+	parser.text.WriteString(rest)
+
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if len(parser.stack) == 0 {
+		return parser.errorAt(parser.textStart, "text outside of the root element")
+	}
+	return parser.handler.Text(decodeEntities(text))
+}
+
+// readMarkup reads the markup after a '<' and passes it to the handler
+func (parser *streamParser) readMarkup() error {
+	prefix, _ := parser.in.Peek(len(CDATA_START) - 1)
+	switch {
+	case strings.HasPrefix("<"+string(prefix), CDATA_START):
+		parser.skip(len(CDATA_START) - 1)
+		return parser.readCDATA()
+	case strings.HasPrefix("<"+string(prefix), COMMENT_START):
+		parser.skip(len(COMMENT_START) - 1)
+		handler, ok := parser.handler.(CommentHandler)
+		if !ok {
+			return parser.skipUntil(COMMENT_END)
+		}
+		var comment strings.Builder
+		if err := parser.readUntil(COMMENT_END, &comment); err != nil {
+			return err
+		}
+		return handler.Comment(strings.TrimSuffix(comment.String(), COMMENT_END))
+	case strings.HasPrefix("<"+string(prefix), PI_START):
+		// Processing instructions may hold '>', like <?php if ($a > 1) ?>
+		parser.skip(len(PI_START) - 1)
+		return parser.skipUntil(PI_END)
+	case strings.HasPrefix("<"+string(prefix), DOCTYPE_START):
+		parser.skip(len(DOCTYPE_START) - 1)
+		return parser.skipDoctype()
+	}
+
+	tag, err := parser.readTag()
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(tag, "<!"):
+		// Other declarations like <!ELEMENT> aren't elements
+		return nil
+	case strings.HasPrefix(tag, "</"):
+		name := strings.TrimSpace(tag[2 : len(tag)-1])
+		if len(parser.stack) == 0 {
+			return parser.errorAt(parser.mark, "no opening tag error: no opening tag")
+		}
+		if open := parser.stack[len(parser.stack)-1]; open != name {
+			return parser.errorAt(parser.mark, "unmatched closing tag error: <"+open+"> "+tag)
+		}
+		parser.stack = parser.stack[:len(parser.stack)-1]
+		return parser.handler.EndElement(name)
+	}
+
+	name, attrs := splitTag(tag)
+	if name == "" {
+		return parser.errorAt(parser.mark, "empty tag error: "+tag)
+	}
+	values := parseAttributes(attrs)
+	for key, value := range values {
+		values[key] = decodeEntities(value)
+	}
+	if err := parser.handler.StartElement(name, values); err != nil {
+		return err
+	}
+	if strings.HasSuffix(tag, "/>") {
+		return parser.handler.EndElement(name)
+	}
+	parser.stack = append(parser.stack, name)
+	return nil
+}
+
+// readTag reads the rest of a tag after its '<'
+// A '>' inside a quoted attribute value doesn't end the tag.
+func (parser *streamParser) readTag() (string, error) {
+	var tag strings.Builder
+	tag.WriteByte('<')
+	var quote byte
+	for {
+		char, err := parser.readByte()
+		if err == io.EOF {
+			return "", parser.errorAt(parser.mark, "tag pairing error")
+		} else if err != nil {
+			return "", err
+		}
+		if tag.Len() >= STREAM_MAX_TAG_LENGTH {
+			return "", parser.errorAt(parser.mark, fmt.Sprintf("tag longer than %d bytes", STREAM_MAX_TAG_LENGTH))
+		}
+
+		switch {
+		case char == '<' && quote == 0:
+			return "", parser.errorAt(parser.mark, "tag pairing error")
+		case (char == '"' || char == '\'') && quote == 0:
+			quote = char
+		case char == quote:
+			quote = 0
+		}
+		tag.WriteByte(char)
+		if char == '>' && quote == 0 {
+			return tag.String(), nil
+		}
+	}
+}
+
+// readCDATA passes the content of a CDATA section to the handler, in chunks if it is long
+// The content is literal and isn't entity decoded.
+func (parser *streamParser) readCDATA() error {
+	if len(parser.stack) == 0 {
+		return parser.errorAt(parser.mark, "text outside of the root element")
+	}
+
+	var content strings.Builder
+	for {
+		char, err := parser.readByte()
+		if err == io.EOF {
+			return parser.errorAt(parser.mark, "unterminated CDATA section, comment or processing instruction")
+		} else if err != nil {
+			return err
+		}
+		content.WriteByte(char)
+
+		if strings.HasSuffix(content.String(), CDATA_END) {
+			text := strings.TrimSuffix(content.String(), CDATA_END)
+			if text == "" {
+				return nil
+			}
+			return parser.handler.Text(text)
+		}
+		// Keep the end of the chunk which may be the start of CDATA_END
+		if content.Len() >= STREAM_TEXT_CHUNK_SIZE+len(CDATA_END) {
+			text := content.String()
+			split := len(text) - len(CDATA_END) + 1
+			if err := parser.handler.Text(text[:split]); err != nil {
+				return err
+			}
+			content.Reset()
+			content.WriteString(text[split:])
+		}
+	}
+}
+
+// skipDoctype discards the rest of a DOCTYPE declaration, whose internal subset in brackets may hold '<' and '>'
+func (parser *streamParser) skipDoctype() error {
+	var quote byte
+	depth := 0 // depth is 1 inside the internal subset
+	for {
+		char, err := parser.readByte()
+		if err == io.EOF {
+			return parser.errorAt(parser.mark, "unterminated DOCTYPE")
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '[':
+			depth++
+		case char == ']' && depth > 0:
+			depth--
+		case char == '>' && depth == 0:
+			return nil
+		case char == '<' && depth > 0:
+			// Comments and processing instructions of the internal subset may hold quotes and brackets
+			prefix, _ := parser.in.Peek(len(COMMENT_START) - 1)
+			if strings.HasPrefix("<"+string(prefix), COMMENT_START) {
+				parser.skip(len(COMMENT_START) - 1)
+				if err := parser.skipUntil(COMMENT_END); err != nil {
+					return err
+				}
+			} else if strings.HasPrefix("<"+string(prefix), PI_START) {
+				parser.skip(len(PI_START) - 1)
+				if err := parser.skipUntil(PI_END); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// skipUntil discards the input up to and including end
+func (parser *streamParser) skipUntil(end string) error {
+	return parser.readUntil(end, nil)
+}
+
+// readUntil reads the input up to and including end into content, or discards it if content is nil
+func (parser *streamParser) readUntil(end string, content *strings.Builder) error {
+	var tail []byte // tail holds the last bytes read, as many as end has
+	for string(tail) != end {
+		char, err := parser.readByte()
+		if err == io.EOF {
+			return parser.errorAt(parser.mark, "unterminated CDATA section, comment or processing instruction")
+		} else if err != nil {
+			return err
+		}
+		if content != nil {
+			content.WriteByte(char)
+		}
+		tail = append(tail, char)
+		if len(tail) > len(end) {
+			tail = tail[1:]
+		}
+	}
+	return nil
+}
+
+// readByte reads the next byte and keeps track of its position
+func (parser *streamParser) readByte() (byte, error) {
+	char, err := parser.in.ReadByte()
+	if err != nil {
+		return char, err
+	}
+	if char == '\n' {
+		parser.pos = streamPosition{Line: parser.pos.Line + 1, Col: 1}
+		parser.line = parser.line[:0]
+		parser.lineStart = 1
+		return char, nil
+	}
+
+	// Continuation bytes of UTF-8 characters don't start a new column
+	if !isContinuationByte(char) {
+		parser.pos.Col++
+	}
+	parser.line = append(parser.line, char)
+	if len(parser.line) > STREAM_LINE_BUFFER {
+		cut := len(parser.line) / 2
+		for cut < len(parser.line) && isContinuationByte(parser.line[cut]) {
+			cut++
+		}
+		parser.lineStart += utf8.RuneCount(parser.line[:cut])
+		parser.line = append(parser.line[:0], parser.line[cut:]...)
+	}
+	return char, nil
+}
+
+// isContinuationByte tells whether char continues a UTF-8 encoded character
+func isContinuationByte(char byte) bool {
+	return char&0xC0 == 0x80
+}
+
+// skip reads n bytes which were peeked at before
+func (parser *streamParser) skip(n int) {
+	for i := 0; i < n; i++ {
+		parser.readByte()
+	}
+}
+
+// errorAt returns the parse error for the position with the part of the current line read so far as snippet
+func (parser *streamParser) errorAt(pos streamPosition, msg string) *ParseError {
+	line := string(parser.line)
+	col := pos.Col - parser.lineStart + 1
+	if pos.Line != parser.pos.Line || col < 1 {
+		// The position isn't in the kept part of the line anymore, show its end
+		col = utf8.RuneCountInString(line) + 1
+	}
+	return &ParseError{Line: pos.Line, Col: pos.Col, Msg: msg, Snippet: snippet(line, col)}
+}
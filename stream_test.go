@@ -0,0 +1,153 @@
+package goapp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler records the events of ParseReader as strings like "start title lang=en"
+type recordingHandler struct {
+	events []string
+	stopAt string // stopAt is the name of the element whose start fails
+}
+
+func (handler *recordingHandler) StartElement(name string, attrs map[string]string) error {
+	if name == handler.stopAt {
+		return errors.New("stopped")
+	}
+	event := "start " + name
+	for _, key := range []string{"id", "lang", "note"} {
+		if value, ok := attrs[key]; ok {
+			event += fmt.Sprintf(" %s=%s", key, value)
+		}
+	}
+	handler.events = append(handler.events, event)
+	return nil
+}
+
+func (handler *recordingHandler) EndElement(name string) error {
+	handler.events = append(handler.events, "end "+name)
+	return nil
+}
+
+func (handler *recordingHandler) Text(text string) error {
+	handler.events = append(handler.events, "text "+text)
+	return nil
+}
+
+// Test the events of streaming documents
+func TestParseReader(t *testing.T) {
+	tests := []struct {
+		desc     string
+		data     string
+		expected []string
+		err      string
+	}{
+		{
+			desc: "document",
+			data: "<?xml version=\"1.0\"?>\n<document>\n  <title lang=\"en\">Fish &amp; Chips</title>\n  <item id='1'/>\n</document>\n",
+			expected: []string{
+				"start document", "start title lang=en", "text Fish & Chips", "end title", "start item id=1", "end item", "end document",
+			},
+		},
+		{
+			desc:     "processing instructions",
+			data:     "<document><?php if ($a > 1) { echo '<b>'; } ?><title>T</title></document>",
+			expected: []string{"start document", "start title", "text T", "end title", "end document"},
+		},
+		{
+			desc:     "cdata and comments",
+			data:     "<document><!-- <skipped> --><description><![CDATA[<b>&amp;</b>]]> more</description><!----></document>",
+			expected: []string{"start document", "start description", "text <b>&amp;</b>", "text  more", "end description", "end document"},
+		},
+		{
+			desc:     "comment ending with extra dashes",
+			data:     "<document><!-- a ---><title>x</title></document>",
+			expected: []string{"start document", "start title", "text x", "end title", "end document"},
+		},
+		{
+			desc:     "quoted '>' in attribute",
+			data:     `<document note="a > b"></document>`,
+			expected: []string{"start document note=a > b", "end document"},
+		},
+		{
+			desc:     "entities in attributes",
+			data:     `<document note="&quot;a&quot; &amp; b"/>`,
+			expected: []string{`start document note="a" & b`, "end document"},
+		},
+		{desc: "unmatched closing tag", data: "<document><title></item></document>", err: "unmatched closing tag error: <title> </item> at line 1, column 18"},
+		{desc: "no opening tag", data: "</document>", err: "no opening tag error: no opening tag at line 1, column 1"},
+		{desc: "unclosed tag", data: "<document><title>", err: "unclosed tag error: <title> at line 1, column 18"},
+		{desc: "unterminated tag", data: "<document><title", err: "tag pairing error at line 1, column 11"},
+		{desc: "unterminated comment", data: "<document><!-- ", err: "unterminated CDATA section, comment or processing instruction at line 1, column 11"},
+		{desc: "text outside root", data: "oops<document/>", err: "text outside of the root element at line 1, column 1"},
+		{desc: "handler error", data: "<document><stop/></document>", err: "stopped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			handler := &recordingHandler{stopAt: "stop"}
+			err := ParseReader(strings.NewReader(tt.data), handler)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, handler.events)
+		})
+	}
+}
+
+// Test that long text comes in chunks without splitting entity references
+func TestParseReaderLongText(t *testing.T) {
+	text := strings.Repeat("a", STREAM_TEXT_CHUNK_SIZE-2) + "&amp;" + strings.Repeat("b", 10)
+	cdata := strings.Repeat("c", STREAM_TEXT_CHUNK_SIZE+10)
+	handler := &recordingHandler{}
+	require.NoError(t, ParseReader(strings.NewReader("<document><a>"+text+"</a><b><![CDATA["+cdata+"]]></b></document>"), handler))
+
+	var a, b strings.Builder
+	textEvents := 0
+	for _, event := range handler.events {
+		if strings.HasPrefix(event, "text a") || strings.HasPrefix(event, "text &") {
+			a.WriteString(strings.TrimPrefix(event, "text "))
+		}
+		if strings.HasPrefix(event, "text c") {
+			b.WriteString(strings.TrimPrefix(event, "text "))
+		}
+		if strings.HasPrefix(event, "text ") {
+			textEvents++
+		}
+	}
+	require.Equal(t, strings.Repeat("a", STREAM_TEXT_CHUNK_SIZE-2)+"&"+strings.Repeat("b", 10), a.String())
+	require.Equal(t, cdata, b.String())
+	require.Equal(t, 4, textEvents)
+}
+
+// commentHandler records comments besides the events of recordingHandler
+type commentHandler struct {
+	recordingHandler
+}
+
+func (handler *commentHandler) Comment(text string) error {
+	handler.events = append(handler.events, "comment "+text)
+	return nil
+}
+
+// Test that comments are passed to handlers taking them, and only end at -->
+func TestParseReaderComments(t *testing.T) {
+	data := `<!-- a > b --><doc>x<!-- if x > 3 -- <y> -->z</doc>`
+	handler := &recordingHandler{}
+	require.NoError(t, ParseReader(strings.NewReader(data), handler))
+	require.Equal(t, []string{"start doc", "text x", "text z", "end doc"}, handler.events)
+
+	comments := &commentHandler{}
+	require.NoError(t, ParseReader(strings.NewReader(data), comments))
+	require.Equal(t, []string{"comment  a > b ", "start doc", "text x", "comment  if x > 3 -- <y> ", "text z", "end doc"}, comments.events)
+
+	err := ParseReader(strings.NewReader("<doc><!-- x > y </doc>"), &commentHandler{})
+	var parseError *ParseError
+	require.ErrorAs(t, err, &parseError)
+}
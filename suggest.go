@@ -0,0 +1,141 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	SUGGEST_DEFAULT_LIMIT = 10 // Number of suggestions returned by default
+	SUGGEST_MAX_LIMIT     = 50 // Highest number of suggestions returned
+
+	DB_TITLE_INDEX_NAME       = "doc_title_nocase"  // Index of title ignoring case, for prefix queries of /suggest
+	DB_AUTHOR_INDEX_NAME      = "doc_author_nocase" // Index of author ignoring case, for prefix queries of /suggest
+	DB_TAG_SUGGEST_INDEX_NAME = "doc_tag_nocase"    // Index of tags ignoring case, for prefix queries of /suggest
+
+	SUGGEST_FIELD_TAG = "tag" // Field of suggested tags
+)
+
+// suggestFields are the fields completions are offered from, with their column, its table and its index
+var suggestFields = []struct {
+	Field  string
+	Table  string
+	Column string
+	Index  string
+}{
+	{XML_TITLE_FIELD, DB_TABLE_NAME, DB_TITLE_FIELD_NAME, DB_TITLE_INDEX_NAME},
+	{XML_AUTHOR_FIELD, DB_TABLE_NAME, DB_AUTHOR_FIELD_NAME, DB_AUTHOR_INDEX_NAME},
+	{SUGGEST_FIELD_TAG, DB_TAG_TABLE_NAME, DB_TAG_NAME_FIELD_NAME, DB_TAG_SUGGEST_INDEX_NAME},
+}
+
+// Suggestion is a completion of the prefix typed into a search box
+type Suggestion struct {
+	Value string
+	Field string // Field is the field the value is found in, "title", "author" or "tag"
+	Count int    // Count is the number of documents with the value
+}
+
+// createSuggestIndexes creates the indexes the prefix queries of /suggest run on
+// Values are compared ignoring the case of ASCII letters, like in the queries.
+func createSuggestIndexes(db *sql.DB) error {
+	for _, field := range suggestFields {
+		_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s COLLATE NOCASE)", field.Index, field.Table, field.Column))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// suggest returns up to limit titles, authors and tags of the active, published documents starting with prefix, ignoring case
+// Values found in more documents come first, then shorter values, so the completions typed most are offered first.
+func suggest(db *sql.DB, prefix string, limit int, now time.Time) ([]Suggestion, error) {
+	defer observeQuery("suggest", time.Now())
+
+	// The range of values starting with prefix, which the index finds unlike LIKE with its escaping
+	upper := prefix + string(utf8.MaxRune)
+	suggestions := []Suggestion{}
+	for _, field := range suggestFields {
+		// Tags are selected with the documents they are given to, which have the state and times
+		from := DB_TABLE_NAME
+		if field.Table != DB_TABLE_NAME {
+			from = fmt.Sprintf("%s JOIN %s ON %s.%s=%s.%s", field.Table, DB_TABLE_NAME, DB_TABLE_NAME, DB_ID_FIELD_NAME, field.Table, DB_TAG_DOCUMENT_FIELD_NAME)
+		}
+		query := fmt.Sprintf(`
+			SELECT %s, COUNT(*) FROM %s
+			WHERE %s >= ? COLLATE NOCASE AND %s < ? COLLATE NOCASE AND %s=? AND (%s IS NULL OR %s>?) AND %s
+			GROUP BY %s ORDER BY COUNT(*) DESC, LENGTH(%s), %s LIMIT ?
+		`, field.Column, from, field.Column, field.Column, DB_STATE_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, DB_EXPIRESAT_FIELD_NAME, publishedCondition,
+			field.Column, field.Column, field.Column)
+		err := withDBRetry(func() error {
+			rows, err := db.Query(query, prefix, upper, DOC_STATE_ACTIVE, formatExpiry(now), formatExpiry(now), limit)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				suggestion := Suggestion{Field: field.Field}
+				if err := rows.Scan(&suggestion.Value, &suggestion.Count); err != nil {
+					return err
+				}
+				suggestions = append(suggestions, suggestion)
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Count != suggestions[j].Count {
+			return suggestions[i].Count > suggestions[j].Count
+		}
+		return utf8.RuneCountInString(suggestions[i].Value) < utf8.RuneCountInString(suggestions[j].Value)
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// handleSuggestRequest answers GET /suggest?prefix=rep with the titles, authors and tags starting with the prefix
+func handleSuggestRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		http.Error(w, "prefix parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := SUGGEST_DEFAULT_LIMIT
+	if param := r.URL.Query().Get("limit"); param != "" {
+		value, err := strconv.Atoi(param)
+		if err != nil || value <= 0 || value > SUGGEST_MAX_LIMIT {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", SUGGEST_MAX_LIMIT), http.StatusBadRequest)
+			return
+		}
+		limit = value
+	}
+
+	suggestions, err := suggest(db, prefix, limit, time.Now())
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to find suggestions: %v", err), err)
+		return
+	}
+
+	response, err := json.Marshal(suggestions)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,112 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test completing titles, authors and tags
+func TestHandleSuggestRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, fields := range [][2]string{
+		{"Report 2024", "Rebecca Stone"},
+		{"Quarterly report", "Repo Team"},
+		{"Report 2024", "Ann Lee"},
+		{"Repair manual", "Repo Team"},
+		{"Reptiles", "Ann Lee"},
+	} {
+		doc, err := parseDocument(fmt.Sprintf("<document><title>%s</title><author>%s</author></document>", fields[0], fields[1]))
+		require.NoError(t, err)
+		_, err = addDocument(db, *doc)
+		require.NoError(t, err)
+	}
+	// Archived documents aren't suggested
+	doc, err := parseDocument("<document><title>Repository</title></document>")
+	require.NoError(t, err)
+	id, err := addDocument(db, *doc)
+	require.NoError(t, err)
+	_, err = db.Exec(fmt.Sprintf("UPDATE %s SET %s=? WHERE %s=?", DB_TABLE_NAME, DB_STATE_FIELD_NAME, DB_ID_FIELD_NAME), DOC_STATE_ARCHIVED, id)
+	require.NoError(t, err)
+	// Tags count the documents they are given to, but not archived ones
+	for _, tag := range [][2]string{{"1", "reports"}, {"2", "reports"}, {"3", "Repairs"}, {id, "repository"}} {
+		_, err = db.Exec(fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (?, ?)", DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_NAME_FIELD_NAME), tag[0], tag[1])
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		desc         string
+		path         string
+		expectedCode int
+		expected     []Suggestion
+	}{
+		{
+			desc:         "ranked by documents and length",
+			path:         "/suggest?prefix=rep",
+			expectedCode: http.StatusOK,
+			expected: []Suggestion{
+				{Value: "reports", Field: "tag", Count: 2},
+				{Value: "Repo Team", Field: "author", Count: 2},
+				{Value: "Report 2024", Field: "title", Count: 2},
+				{Value: "Repairs", Field: "tag", Count: 1},
+				{Value: "Reptiles", Field: "title", Count: 1},
+				{Value: "Repair manual", Field: "title", Count: 1},
+			},
+		},
+		{
+			desc:         "capped",
+			path:         "/suggest?prefix=REP&limit=1",
+			expectedCode: http.StatusOK,
+			expected:     []Suggestion{{Value: "reports", Field: "tag", Count: 2}},
+		},
+		{desc: "no match", path: "/suggest?prefix=xyz", expectedCode: http.StatusOK, expected: []Suggestion{}},
+		{desc: "missing prefix", path: "/suggest", expectedCode: http.StatusBadRequest},
+		{desc: "invalid limit", path: "/suggest?prefix=rep&limit=100", expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleRequest(db, rr, httptest.NewRequest("GET", tt.path, nil))
+			require.Equal(t, tt.expectedCode, rr.Code)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var suggestions []Suggestion
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &suggestions))
+			require.Equal(t, tt.expected, suggestions)
+		})
+	}
+}
+
+// Test the prefix queries running on the indexes
+func TestSuggestUsesIndexes(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, field := range suggestFields {
+		rows, err := db.Query(fmt.Sprintf("EXPLAIN QUERY PLAN SELECT %s FROM %s WHERE %s >= ? COLLATE NOCASE AND %s < ? COLLATE NOCASE",
+			field.Column, field.Table, field.Column, field.Column), "rep", "req")
+		require.NoError(t, err)
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			require.NoError(t, rows.Scan(&id, &parent, &unused, &detail))
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		require.Contains(t, strings.Join(plan, "\n"), "INDEX "+field.Index)
+	}
+
+	suggestions, err := suggest(db, "rep", SUGGEST_DEFAULT_LIMIT, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, suggestions)
+}
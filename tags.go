@@ -0,0 +1,227 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	TAG_ACTION_ADD    = "add"    // Action and parameter of /documents/tags giving the tag to the selected documents
+	TAG_ACTION_REMOVE = "remove" // Action and parameter of /documents/tags taking the tag from the selected documents
+
+	TAG_MAX_LENGTH = 64 // Longest tag in characters
+
+	DB_TAG_TABLE_NAME          = "doc_tag"     // Table name of the tags of documents in SQLite
+	DB_TAG_DOCUMENT_FIELD_NAME = "document_id" // Field name for the tagged document
+	DB_TAG_NAME_FIELD_NAME     = "tag"         // Field name for the tag
+	DB_TAG_INDEX_NAME          = "doc_tag_tag" // Index of tags, for selecting the documents with a tag
+)
+
+// documentTagsColumn is the column of documentColumns with the comma-separated tags of a document
+var documentTagsColumn = fmt.Sprintf("(SELECT group_concat(%s) FROM %s WHERE %s=%s.%s)",
+	DB_TAG_NAME_FIELD_NAME, DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TABLE_NAME, DB_ID_FIELD_NAME)
+
+// tagFilter is the condition selecting the documents with the tag of its placeholder
+var tagFilter = fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s=?)", DB_ID_FIELD_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_TABLE_NAME, DB_TAG_NAME_FIELD_NAME)
+
+// TagResult is the response of /documents/tags
+type TagResult struct {
+	Tag     string
+	Action  string
+	DryRun  bool
+	Matched int // Matched is the number of selected documents
+	Changed int // Changed is the number of documents which got or lost the tag, or would have in a dry run
+}
+
+// createTagTable creates the table of the tags of documents if not exists
+func createTagTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER NOT NULL,
+		"%s" TEXT NOT NULL,
+		PRIMARY KEY ("%s", "%s")
+	);
+`, DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_NAME_FIELD_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_NAME_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", DB_TAG_INDEX_NAME, DB_TAG_TABLE_NAME, DB_TAG_NAME_FIELD_NAME))
+	return err
+}
+
+// validateTag checks a tag is at most TAG_MAX_LENGTH characters without commas, control characters or surrounding space
+func validateTag(tag string) error {
+	if utf8.RuneCountInString(tag) > TAG_MAX_LENGTH {
+		return fmt.Errorf("tag is longer than %d characters", TAG_MAX_LENGTH)
+	}
+	if strings.TrimSpace(tag) != tag || strings.ContainsRune(tag, ',') || strings.IndexFunc(tag, unicode.IsControl) >= 0 {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+	return nil
+}
+
+// decodeTags returns the tags of documentTagsColumn in alphabetical order, nil for none
+func decodeTags(data string) []string {
+	if data == "" {
+		return nil
+	}
+	tags := strings.Split(data, ",")
+	sort.Strings(tags)
+	return tags
+}
+
+// tagDocuments adds the tag to or removes it from the selected documents which are not expired at now, in one transaction
+// A dry run counts the documents which would change without changing them.
+func tagDocuments(db *sql.DB, selection documentSelection, tag string, action string, dryRun bool, now time.Time) (TagResult, error) {
+	defer observeQuery("tagDocuments", time.Now())
+
+	result := TagResult{Tag: tag, Action: action, DryRun: dryRun}
+	condition, args := listCondition(now, selection.States, selection.Filter, selection.FilterArgs)
+	selected := fmt.Sprintf("SELECT %s FROM %s WHERE %s", DB_ID_FIELD_NAME, DB_TABLE_NAME, condition)
+	tagged := fmt.Sprintf("SELECT %s FROM %s WHERE %s=?", DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_TABLE_NAME, DB_TAG_NAME_FIELD_NAME)
+
+	countQuery := fmt.Sprintf("SELECT COUNT(*), COUNT(CASE WHEN %s IN (%s) THEN 1 END) FROM (%s)", DB_ID_FIELD_NAME, tagged, selected)
+	var changeQuery string
+	if action == TAG_ACTION_ADD {
+		changeQuery = fmt.Sprintf("INSERT OR IGNORE INTO %s (%s, %s) SELECT %s, ? FROM (%s)",
+			DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_NAME_FIELD_NAME, DB_ID_FIELD_NAME, selected)
+	} else {
+		changeQuery = fmt.Sprintf("DELETE FROM %s WHERE %s=? AND %s IN (%s)", DB_TAG_TABLE_NAME, DB_TAG_NAME_FIELD_NAME, DB_TAG_DOCUMENT_FIELD_NAME, selected)
+	}
+	tagArgs := append([]interface{}{tag}, args...)
+
+	err := withDBRetry(func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		var alreadyTagged int
+		if err := tx.QueryRow(countQuery, tagArgs...).Scan(&result.Matched, &alreadyTagged); err != nil {
+			return err
+		}
+		result.Changed = result.Matched - alreadyTagged
+		if action == TAG_ACTION_REMOVE {
+			result.Changed = alreadyTagged
+		}
+		if dryRun {
+			return nil
+		}
+
+		changed, err := tx.Exec(changeQuery, tagArgs...)
+		if err != nil {
+			return err
+		}
+		rows, err := changed.RowsAffected()
+		if err != nil {
+			return err
+		}
+		result.Changed = int(rows)
+		return tx.Commit()
+	})
+	return result, err
+}
+
+// setDocumentTags changes the tags of the document id to tags, only adding and removing the tags which differ
+func setDocumentTags(db sqlExecer, id string, tags []string) error {
+	args := []interface{}{id}
+	placeholders := make([]string, len(tags))
+	for i, tag := range tags {
+		placeholders[i] = "?"
+		args = append(args, tag)
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s=?", DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME)
+	if len(tags) > 0 {
+		query += fmt.Sprintf(" AND %s NOT IN (%s)", DB_TAG_NAME_FIELD_NAME, strings.Join(placeholders, ", "))
+	}
+	if _, err := db.Exec(query, args...); err != nil {
+		return err
+	}
+
+	insert := fmt.Sprintf("INSERT OR IGNORE INTO %s (%s, %s) VALUES (?, ?)", DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_NAME_FIELD_NAME)
+	for _, tag := range tags {
+		if _, err := db.Exec(insert, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// documentHasTag reports whether the document is in the collection of documents with the tag
+func documentHasTag(db *sql.DB, id string, tag string) (bool, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s=? AND %s=?", DB_TAG_TABLE_NAME, DB_TAG_DOCUMENT_FIELD_NAME, DB_TAG_NAME_FIELD_NAME)
+	var tagged int
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, id, tag).Scan(&tagged)
+	})
+	return tagged > 0, err
+}
+
+// handleTagRequest answers POST /documents/tags?add=invoices-2023&doctype=invoice with the number of documents selected
+// like by /list which got the tag, or lost it with remove=invoices-2023
+// With dry_run=true nothing is changed, so the size of a change to a large archive can be checked first.
+func handleTagRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The tag parameter selects documents by a tag they have, like for /list
+	add, remove := r.URL.Query().Get(TAG_ACTION_ADD), r.URL.Query().Get(TAG_ACTION_REMOVE)
+	if (add == "") == (remove == "") {
+		http.Error(w, "either add or remove parameter is required", http.StatusBadRequest)
+		return
+	}
+	tag, action := add, TAG_ACTION_ADD
+	if remove != "" {
+		tag, action = remove, TAG_ACTION_REMOVE
+	}
+	if err := validateTag(tag); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	// Taking the tag of a collection under legal hold would release its documents
+	if action == TAG_ACTION_REMOVE {
+		held, err := tagHeld(db, tag)
+		if err != nil {
+			httpStoreError(w, fmt.Sprintf("Failed to check legal holds: %v", err), err)
+			return
+		} else if held {
+			http.Error(w, fmt.Sprintf("%v: tag %s can't be removed", ErrLegalHold, tag), http.StatusConflict)
+			return
+		}
+	}
+
+	selection, err := parseDocumentSelection(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := tagDocuments(db, selection, tag, action, dryRun, time.Now())
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to tag documents: %v", err), err)
+		return
+	}
+
+	response, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,82 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test applying and removing a tag across the documents selected like by /list
+func TestHandleTagRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, data := range []string{
+		"<!DOCTYPE invoice><invoice><title>Invoice 1</title></invoice>",
+		"<!DOCTYPE invoice><invoice><title>Invoice 2</title></invoice>",
+		"<document><title>Letter</title></document>",
+	} {
+		doc, err := parseDocument(data)
+		require.NoError(t, err)
+		_, err = addDocument(db, *doc)
+		require.NoError(t, err)
+	}
+
+	tag := func(query string) TagResult {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("POST", "/documents/tags?"+query, nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var result TagResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result
+	}
+	list := func(query string) []string {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("GET", "/list?"+query, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		var docs []XMLDoc
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+		titles := []string{}
+		for _, doc := range docs {
+			titles = append(titles, doc.Title+":"+strings.Join(doc.Tags, "|"))
+		}
+		return titles
+	}
+
+	// A dry run counts without changing anything
+	require.Equal(t, TagResult{Tag: "billing", Action: TAG_ACTION_ADD, DryRun: true, Matched: 2, Changed: 2}, tag("add=billing&doctype=invoice&dry_run=true"))
+	require.Equal(t, []string{"Invoice 1:", "Invoice 2:", "Letter:"}, list(""))
+
+	require.Equal(t, TagResult{Tag: "billing", Action: TAG_ACTION_ADD, Matched: 2, Changed: 2}, tag("add=billing&doctype=invoice"))
+	require.Equal(t, TagResult{Tag: "2023", Action: TAG_ACTION_ADD, Matched: 3, Changed: 3}, tag("add=2023"))
+	// Documents which have the tag already aren't changed
+	require.Equal(t, TagResult{Tag: "billing", Action: TAG_ACTION_ADD, Matched: 3, Changed: 1}, tag("add=billing"))
+	require.Equal(t, []string{"Invoice 1:2023|billing", "Invoice 2:2023|billing", "Letter:2023|billing"}, list(""))
+
+	// Documents can be selected by a tag they have
+	require.Equal(t, TagResult{Tag: "billing", Action: TAG_ACTION_REMOVE, DryRun: true, Matched: 2, Changed: 2}, tag("remove=billing&tag=2023&doctype=invoice&dry_run=true"))
+	require.Equal(t, TagResult{Tag: "billing", Action: TAG_ACTION_REMOVE, Matched: 3, Changed: 3}, tag("remove=billing&tag=2023"))
+	require.Equal(t, []string{"Invoice 1:2023", "Invoice 2:2023", "Letter:2023"}, list("tag=2023"))
+	require.Empty(t, list("tag=billing"))
+
+	// Tags of deleted documents are removed with them
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/del?id=3", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+DB_TAG_TABLE_NAME).Scan(&count))
+	require.Equal(t, 2, count)
+
+	for _, query := range []string{"", "add=a&remove=b", "add=a,b", "add=%20a", "add=" + strings.Repeat("a", TAG_MAX_LENGTH+1), "add=a&state=gone"} {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("POST", "/documents/tags?"+query, nil))
+		require.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/documents/tags?add=a", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
@@ -0,0 +1,400 @@
+package goapp
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DB_TENANT_TABLE_NAME            = "tenant_config" // Table name of tenant configurations in SQLite
+	DB_TENANT_NAME_FIELD_NAME       = "tenant"        // Field name for the tenant the configuration applies to
+	DB_TENANT_CONFIG_FIELD_NAME     = "config"        // Field name for the configuration as JSON
+	DB_TENANT_UPDATED_AT_FIELD_NAME = "updated_at"    // Field name for the time the configuration was set
+
+	DB_TENANT_USAGE_TABLE_NAME           = "tenant_usage" // Table name of the documents added by tenants per day
+	DB_TENANT_USAGE_TENANT_FIELD_NAME    = "tenant"       // Field name for the tenant
+	DB_TENANT_USAGE_DAY_FIELD_NAME       = "day"          // Field name for the UTC day as YYYY-MM-DD
+	DB_TENANT_USAGE_DOCUMENTS_FIELD_NAME = "documents"    // Field name for the number of documents added on the day
+
+	TENANT_EVENT_DOCUMENT_ADDED = "document_added" // Event posted to the webhook of a tenant for each document it adds
+	TENANT_WEBHOOK_TIMEOUT      = 10 * time.Second // Timeout of a request to the webhook of a tenant
+	TENANT_USAGE_DAY_LAYOUT     = "2006-01-02"     // Layout of the day of usage counters
+)
+
+// TenantConfig overrides how documents of a tenant are ingested
+// Tenants are told apart like for feature flags: "key:api", "key:token-{id}" or "http:{address}".
+type TenantConfig struct {
+	Tenant    string
+	Fields    map[string]string  `json:",omitempty"` // Fields maps metadata fields like "title" to the element name or path holding them in the tenant's documents
+	Schemas   []ValidationSchema `json:",omitempty"` // Schemas are tried before the server's schemas
+	Quota     int                // Quota is the number of documents the tenant may add per UTC day, 0 for no limit
+	Webhook   string             `json:",omitempty"` // Webhook is a URL every added document is posted to as JSON
+	UpdatedAt string             `json:",omitempty"`
+	UsedToday int                // UsedToday is the number of documents the tenant added today, set when listing
+}
+
+// TenantEvent is posted to the webhook of a tenant
+type TenantEvent struct {
+	Event  string
+	Tenant string
+	ID     string
+	Title  string
+	At     string
+}
+
+// check returns why the configuration is invalid, or nil, and compiles the patterns of its schemas
+func (config *TenantConfig) check() error {
+	if config.Tenant == "" {
+		return fmt.Errorf("tenant is required")
+	}
+	if config.Quota < 0 {
+		return fmt.Errorf("quota must be a positive number or 0")
+	}
+
+	elements := map[string]bool{}
+	for field, element := range config.Fields {
+		if !metadataFields[field] {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		if element == "" || elements[element] {
+			return fmt.Errorf("field %s needs an element of its own", field)
+		}
+		if err := checkFieldRule(element); err != nil {
+			return fmt.Errorf("field %s: %v", field, err)
+		}
+		elements[element] = true
+	}
+	if err := compileValidationSchemas(config.Schemas); err != nil {
+		return err
+	}
+	if config.Webhook != "" {
+		parsed, err := url.Parse(config.Webhook)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("invalid webhook %q", config.Webhook)
+		}
+	}
+	return nil
+}
+
+// ParseOptions returns the options documents of the tenant are parsed with, based on the options of the request
+func (config *TenantConfig) ParseOptions(options ParseOptions) ParseOptions {
+	options.Fields = config.Fields
+	options.Schemas = config.Schemas
+	return options
+}
+
+// createTenantTables creates the tenant configuration and usage tables if not exists
+func createTenantTables(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL PRIMARY KEY,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY ("%s", "%s")
+	);
+`, DB_TENANT_TABLE_NAME, DB_TENANT_NAME_FIELD_NAME, DB_TENANT_CONFIG_FIELD_NAME, DB_TENANT_UPDATED_AT_FIELD_NAME,
+		DB_TENANT_USAGE_TABLE_NAME, DB_TENANT_USAGE_TENANT_FIELD_NAME, DB_TENANT_USAGE_DAY_FIELD_NAME, DB_TENANT_USAGE_DOCUMENTS_FIELD_NAME,
+		DB_TENANT_USAGE_TENANT_FIELD_NAME, DB_TENANT_USAGE_DAY_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// decodeTenantConfig decodes a stored configuration and compiles its schemas
+func decodeTenantConfig(tenant, data, updatedAt string) (*TenantConfig, error) {
+	var config TenantConfig
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return nil, err
+	}
+	config.Tenant = tenant
+	config.UpdatedAt = updatedAt
+	if err := config.check(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// getTenantConfig retrieves the configuration of the tenant
+// It returns sql.ErrNoRows if the tenant has none.
+func getTenantConfig(db *sql.DB, tenant string) (*TenantConfig, error) {
+	defer observeQuery("getTenantConfig", time.Now())
+
+	query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s=?", DB_TENANT_CONFIG_FIELD_NAME, DB_TENANT_UPDATED_AT_FIELD_NAME, DB_TENANT_TABLE_NAME, DB_TENANT_NAME_FIELD_NAME)
+	var data, updatedAt string
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, tenant).Scan(&data, &updatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeTenantConfig(tenant, data, updatedAt)
+}
+
+// tenantConfigFor returns the configuration of the tenant, or one without overrides if it has none
+func tenantConfigFor(db *sql.DB, tenant string) (*TenantConfig, error) {
+	config, err := getTenantConfig(db, tenant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &TenantConfig{Tenant: tenant}, nil
+	}
+	return config, err
+}
+
+// listTenantConfigs retrieves the configurations of all tenants ordered by tenant
+// Configurations which can't be decoded are logged and left out.
+func listTenantConfigs(db *sql.DB) ([]TenantConfig, error) {
+	defer observeQuery("listTenantConfigs", time.Now())
+	funcName := "listTenantConfigs"
+
+	query := fmt.Sprintf("SELECT %s, %s, %s FROM %s ORDER BY %s", DB_TENANT_NAME_FIELD_NAME, DB_TENANT_CONFIG_FIELD_NAME, DB_TENANT_UPDATED_AT_FIELD_NAME, DB_TENANT_TABLE_NAME, DB_TENANT_NAME_FIELD_NAME)
+	var configs []TenantConfig
+	err := withDBRetry(func() error {
+		configs = []TenantConfig{}
+		rows, err := db.Query(query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var tenant, data, updatedAt string
+			if err := rows.Scan(&tenant, &data, &updatedAt); err != nil {
+				return err
+			}
+			config, err := decodeTenantConfig(tenant, data, updatedAt)
+			if err != nil {
+				log.Printf("%s: Ignoring configuration of %s: %v", funcName, tenant, err)
+				continue
+			}
+			configs = append(configs, *config)
+		}
+		return rows.Err()
+	})
+	return configs, err
+}
+
+// saveTenantConfig stores the configuration of a tenant, replacing its previous one
+func saveTenantConfig(db *sql.DB, config TenantConfig, now time.Time) error {
+	defer observeQuery("saveTenantConfig", time.Now())
+
+	config.UpdatedAt = ""
+	config.UsedToday = 0
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s) VALUES (?, ?, ?)
+		ON CONFLICT(%[2]s) DO UPDATE SET %[3]s=excluded.%[3]s, %[4]s=excluded.%[4]s
+	`, DB_TENANT_TABLE_NAME, DB_TENANT_NAME_FIELD_NAME, DB_TENANT_CONFIG_FIELD_NAME, DB_TENANT_UPDATED_AT_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, config.Tenant, string(data), formatExpiry(now))
+		return err
+	})
+}
+
+// deleteTenantConfig removes the configuration of the tenant
+// It returns sql.ErrNoRows if the tenant has none.
+func deleteTenantConfig(db *sql.DB, tenant string) error {
+	defer observeQuery("deleteTenantConfig", time.Now())
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s=?", DB_TENANT_TABLE_NAME, DB_TENANT_NAME_FIELD_NAME)
+	return withDBRetry(func() error {
+		result, err := db.Exec(query, tenant)
+		if err != nil {
+			return err
+		}
+		if count, err := result.RowsAffected(); err == nil && count == 0 {
+			return sql.ErrNoRows
+		}
+		return err
+	})
+}
+
+// tenantUsage returns the number of documents the tenant added on the UTC day of now
+func tenantUsage(db *sql.DB, tenant string, now time.Time) (int, error) {
+	defer observeQuery("tenantUsage", time.Now())
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s=? AND %s=?", DB_TENANT_USAGE_DOCUMENTS_FIELD_NAME, DB_TENANT_USAGE_TABLE_NAME, DB_TENANT_USAGE_TENANT_FIELD_NAME, DB_TENANT_USAGE_DAY_FIELD_NAME)
+	var documents int
+	err := withDBRetry(func() error {
+		return db.QueryRow(query, tenant, now.UTC().Format(TENANT_USAGE_DAY_LAYOUT)).Scan(&documents)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return documents, err
+}
+
+// recordTenantUsage counts a document the tenant added on the UTC day of now
+func recordTenantUsage(db *sql.DB, tenant string, now time.Time) error {
+	defer observeQuery("recordTenantUsage", time.Now())
+
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s) VALUES (?, ?, 1)
+		ON CONFLICT(%[2]s, %[3]s) DO UPDATE SET %[4]s=%[4]s+1
+	`, DB_TENANT_USAGE_TABLE_NAME, DB_TENANT_USAGE_TENANT_FIELD_NAME, DB_TENANT_USAGE_DAY_FIELD_NAME, DB_TENANT_USAGE_DOCUMENTS_FIELD_NAME)
+	return withDBRetry(func() error {
+		_, err := db.Exec(query, tenant, now.UTC().Format(TENANT_USAGE_DAY_LAYOUT))
+		return err
+	})
+}
+
+// quotaRetryAfter returns the seconds until the quotas start over at the next UTC midnight
+func quotaRetryAfter(now time.Time) int {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(midnight.Sub(now).Seconds()) + 1
+}
+
+// TenantNotifier posts the events of tenants to their webhooks
+type TenantNotifier struct {
+	Client *http.Client
+
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker // breakers stop posting to the webhook of a tenant for a while when it is unreachable
+}
+
+// tenantNotifier posts to the webhooks of the tenants
+var tenantNotifier = &TenantNotifier{Client: &http.Client{Timeout: TENANT_WEBHOOK_TIMEOUT}, breakers: map[string]*CircuitBreaker{}}
+
+// breaker returns the circuit breaker of the tenant, creating it on first use
+func (notifier *TenantNotifier) breaker(tenant string) *CircuitBreaker {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	breaker, ok := notifier.breakers[tenant]
+	if !ok {
+		breaker = newCircuitBreaker("tenant_" + tenant)
+		notifier.breakers[tenant] = breaker
+	}
+	return breaker
+}
+
+// Post sends the event to the webhook of the tenant in the background, if it has one
+func (notifier *TenantNotifier) Post(config *TenantConfig, event TenantEvent) {
+	if config.Webhook == "" {
+		return
+	}
+	go func() {
+		err := notifier.breaker(config.Tenant).Call(func() error {
+			return notifier.send(config.Webhook, event)
+		})
+		if err != nil {
+			log.Printf("TenantNotifier: Failed to post to the webhook of %s: %v", config.Tenant, err)
+		}
+	}()
+}
+
+// send posts the event as JSON to the URL
+func (notifier *TenantNotifier) send(url string, event TenantEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := notifier.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// handleTenantsRequest lists the tenant configurations with today's usage on GET,
+// sets the configuration of a tenant from a JSON object on PUT, and removes one on DELETE with ?tenant=
+func handleTenantsRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	funcName := "handleTenantsRequest"
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var config TenantConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := config.check(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := saveTenantConfig(db, config, time.Now()); err != nil {
+			httpStoreError(w, "Failed to save tenant configuration", err)
+			return
+		}
+		log.Printf("%s: Set configuration of %s", funcName, config.Tenant)
+	case http.MethodDelete:
+		tenant := r.URL.Query().Get("tenant")
+		err := deleteTenantConfig(db, tenant)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, fmt.Sprintf("No configuration for tenant %q", tenant), http.StatusNotFound)
+			return
+		} else if err != nil {
+			httpStoreError(w, "Failed to delete tenant configuration", err)
+			return
+		}
+		log.Printf("%s: Removed configuration of %s", funcName, tenant)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	configs, err := listTenantConfigs(db)
+	if err != nil {
+		httpStoreError(w, "Failed to load tenant configurations", err)
+		return
+	}
+	now := time.Now()
+	for i := range configs {
+		configs[i].UsedToday, err = tenantUsage(db, configs[i].Tenant, now)
+		if err != nil {
+			httpStoreError(w, "Failed to load tenant usage", err)
+			return
+		}
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(configs)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// checkTenantQuota answers 429 and returns false if the tenant used up its quota for the day
+func checkTenantQuota(db *sql.DB, w http.ResponseWriter, config *TenantConfig, now time.Time) bool {
+	if config.Quota == 0 {
+		return true
+	}
+	used, err := tenantUsage(db, config.Tenant, now)
+	if err != nil {
+		httpStoreError(w, "Failed to load tenant usage", err)
+		return false
+	}
+	if used >= config.Quota {
+		metrics.inc("tenant_quota_exceeded_total")
+		w.Header().Set("Retry-After", strconv.Itoa(quotaRetryAfter(now)))
+		http.Error(w, fmt.Sprintf("Daily quota of %d documents used up", config.Quota), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
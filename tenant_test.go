@@ -0,0 +1,145 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test checking tenant configurations
+func TestTenantConfigCheck(t *testing.T) {
+	tests := []struct {
+		desc     string
+		config   TenantConfig
+		expected string
+	}{
+		{desc: "valid", config: TenantConfig{Tenant: "key:token-3", Fields: map[string]string{"title": "headline"}, Quota: 10, Webhook: "https://hooks.example.com/docs"}},
+		{desc: "no tenant", config: TenantConfig{Quota: 10}, expected: "tenant is required"},
+		{desc: "negative quota", config: TenantConfig{Tenant: "key:api", Quota: -1}, expected: "quota must be a positive number or 0"},
+		{desc: "unknown field", config: TenantConfig{Tenant: "key:api", Fields: map[string]string{"summary": "abstract"}}, expected: `unknown field "summary"`},
+		{desc: "shared element", config: TenantConfig{Tenant: "key:api", Fields: map[string]string{"title": "name", "author": "name"}}, expected: "needs an element of its own"},
+		{desc: "invalid schema", config: TenantConfig{Tenant: "key:api", Schemas: []ValidationSchema{{Name: "order", Patterns: map[string]string{"/order/@id": "("}}}}, expected: "schema order: invalid pattern"},
+		{desc: "invalid webhook", config: TenantConfig{Tenant: "key:api", Webhook: "ftp://hooks.example.com"}, expected: `invalid webhook "ftp://hooks.example.com"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			err := tt.config.check()
+			if tt.expected == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+// Test setting, listing and removing tenant configurations with /admin/tenants
+func TestHandleTenantsRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		desc     string
+		method   string
+		target   string
+		body     string
+		status   int
+		expected []string
+	}{
+		{desc: "empty", method: "GET", target: "/admin/tenants", status: http.StatusOK, expected: []string{}},
+		{desc: "set", method: "PUT", target: "/admin/tenants", body: `{"Tenant": "key:token-3", "Quota": 5}`, status: http.StatusOK, expected: []string{"key:token-3"}},
+		{desc: "set another", method: "PUT", target: "/admin/tenants", body: `{"Tenant": "http:192.0.2.1", "Fields": {"title": "headline"}}`, status: http.StatusOK, expected: []string{"http:192.0.2.1", "key:token-3"}},
+		{desc: "invalid", method: "PUT", target: "/admin/tenants", body: `{"Tenant": "key:api", "Quota": -5}`, status: http.StatusBadRequest},
+		{desc: "remove", method: "DELETE", target: "/admin/tenants?tenant=key:token-3", status: http.StatusOK, expected: []string{"http:192.0.2.1"}},
+		{desc: "remove unknown", method: "DELETE", target: "/admin/tenants?tenant=key:token-3", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			handleTenantsRequest(db, w, req)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var configs []TenantConfig
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &configs))
+			tenants := []string{}
+			for _, config := range configs {
+				tenants = append(tenants, config.Tenant)
+			}
+			require.Equal(t, tt.expected, tenants)
+		})
+	}
+}
+
+// Test applying the field mapping, schemas, quota and webhook of the tenant to documents added with /add
+func TestHandleAddRequestTenantConfig(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := make(chan TenantEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event TenantEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer server.Close()
+
+	// httptest requests come from 192.0.2.1
+	config := TenantConfig{
+		Tenant:  "http:192.0.2.1",
+		Fields:  map[string]string{XML_TITLE_FIELD: "headline"},
+		Schemas: []ValidationSchema{{Name: "story", Root: "story", Required: []string{"/story/byline"}}},
+		Quota:   1,
+		Webhook: server.URL,
+	}
+	require.NoError(t, config.check())
+	require.NoError(t, saveTenantConfig(db, config, time.Now()))
+
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add", strings.NewReader(`<story id="1"><headline>Rates rise</headline><title>Economy</title></story>`)))
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Rates rise", doc.Title)
+	require.Equal(t, "story", doc.Validation.Schema)
+	require.Equal(t, []string{"/story/byline is missing"}, doc.Validation.Violations)
+
+	select {
+	case event := <-events:
+		require.Equal(t, TenantEvent{Event: TENANT_EVENT_DOCUMENT_ADDED, Tenant: "http:192.0.2.1", ID: "1", Title: "Rates rise", At: event.At}, event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't called")
+	}
+
+	// The quota of one document a day is used up
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add", strings.NewReader(`<story id="2"><headline>Rates fall</headline></story>`)))
+	require.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	require.NotEmpty(t, w.Result().Header.Get("Retry-After"))
+
+	// Other tenants get the server's defaults
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(`<story id="2"><headline>Rates hold</headline><title>Economy</title></story>`))
+	req.RemoteAddr = "198.51.100.7:1234"
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+	doc, err = getDocumentByID(db, "2")
+	require.NoError(t, err)
+	require.Equal(t, "Economy", doc.Title)
+	require.Empty(t, doc.Validation.Schema)
+}
+
+// Test the wait until the quotas start over
+func TestQuotaRetryAfter(t *testing.T) {
+	require.Equal(t, 3601, quotaRetryAfter(time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)))
+	require.Equal(t, 3601, quotaRetryAfter(time.Date(2024, 3, 2, 0, 0, 0, 0, time.FixedZone("CET", 3600))))
+}
@@ -0,0 +1,289 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TEXT_MAX_LENGTH_ENV = "DOC_MAX_TEXT_LENGTH"   // Environment variable with the longest text of an element in characters
+	TEXT_LIMITS_ENV     = "DOC_TEXT_LIMITS"       // Environment variable with per-element limits like "title=200,description=4000"
+	TEXT_POLICY_ENV     = "DOC_TEXT_LIMIT_POLICY" // Environment variable with what happens to text over the limit
+
+	TEXT_POLICY_REJECT   = "reject"   // Policy rejecting documents with text over the limit
+	TEXT_POLICY_TRUNCATE = "truncate" // Policy cutting text over the limit and appending TEXT_TRUNCATED_MARKER
+	TEXT_POLICY_OVERFLOW = "overflow" // Policy truncating text over the limit and keeping the full text in the overflow table
+
+	TEXT_TRUNCATED_MARKER = "[...]" // Marker appended to truncated text, counted in the limit
+
+	DB_OVERFLOW_TABLE_NAME       = "doc_overflow" // Table name of the full text of truncated elements in SQLite
+	DB_OVERFLOW_DOC_FIELD_NAME   = "doc_id"       // Field name for the ID of the document
+	DB_OVERFLOW_POS_FIELD_NAME   = "position"     // Field name for the position of the truncated text in the document
+	DB_OVERFLOW_ELEM_FIELD_NAME  = "element"      // Field name for the name of the element holding the text
+	DB_OVERFLOW_VALUE_FIELD_NAME = "value"        // Field name for the full text
+)
+
+// ErrTextTooLong is returned when an element's text is over its limit and the policy is to reject
+var ErrTextTooLong = errors.New("element text too long")
+
+// TextLimits configures the longest text of elements and what happens to longer text
+// A limit of 0 means unlimited
+type TextLimits struct {
+	MaxLength int            // MaxLength applies to elements without a limit of their own
+	Elements  map[string]int // Elements holds limits of specific elements by name
+	Policy    string         // Policy is one of the TEXT_POLICY_* constants
+}
+
+// TextOverflow is the full text of an element truncated under the overflow policy
+type TextOverflow struct {
+	Position int    // Position is the index of the text among the truncated texts of the document
+	Element  string // Element is the name of the element holding the text
+	Value    string
+}
+
+// textLimits are the limits applied to ingested documents, set by initTextLimits
+var textLimits = TextLimits{Policy: TEXT_POLICY_TRUNCATE}
+
+// initTextLimits loads the text limits from the environment
+func initTextLimits() {
+	funcName := "initTextLimits"
+
+	limits := TextLimits{Elements: map[string]int{}, Policy: TEXT_POLICY_TRUNCATE}
+	if value := os.Getenv(TEXT_MAX_LENGTH_ENV); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 {
+			log.Fatalf("%s: %s must be a positive number of characters", funcName, TEXT_MAX_LENGTH_ENV)
+		}
+		limits.MaxLength = length
+	}
+	for _, pair := range strings.Split(os.Getenv(TEXT_LIMITS_ENV), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		length := -1
+		if len(parts) == 2 {
+			length, _ = strconv.Atoi(parts[1])
+		}
+		if parts[0] == "" || length < 0 {
+			log.Fatalf("%s: Invalid limit %q in %s", funcName, pair, TEXT_LIMITS_ENV)
+		}
+		limits.Elements[parts[0]] = length
+	}
+	if value := os.Getenv(TEXT_POLICY_ENV); value != "" {
+		if value != TEXT_POLICY_REJECT && value != TEXT_POLICY_TRUNCATE && value != TEXT_POLICY_OVERFLOW {
+			log.Fatalf("%s: %s must be %s, %s or %s", funcName, TEXT_POLICY_ENV, TEXT_POLICY_REJECT, TEXT_POLICY_TRUNCATE, TEXT_POLICY_OVERFLOW)
+		}
+		limits.Policy = value
+	}
+	textLimits = limits
+}
+
+// limit returns the longest text allowed in the element, 0 if unlimited
+func (limits TextLimits) limit(element string) int {
+	if length, ok := limits.Elements[element]; ok {
+		return length
+	}
+	return limits.MaxLength
+}
+
+// truncateText cuts text to length characters including TEXT_TRUNCATED_MARKER
+// Truncated text is never longer than length, so truncating it again leaves it unchanged
+func truncateText(text string, length int) string {
+	marker := TEXT_TRUNCATED_MARKER
+	if length < utf8.RuneCountInString(marker) {
+		marker = ""
+	}
+	keep := length - utf8.RuneCountInString(marker)
+
+	for i := range text {
+		if keep == 0 {
+			return text[:i] + marker
+		}
+		keep--
+	}
+	return text + marker
+}
+
+// Apply enforces the limits on the text of every element of data
+// It returns the data with long texts truncated and, under the overflow policy, their full text
+func (limits TextLimits) Apply(data string) (string, []TextOverflow, error) {
+	if limits.MaxLength == 0 && len(limits.Elements) == 0 {
+		return data, nil, nil
+	}
+
+	var result strings.Builder
+	var overflow []TextOverflow
+	var open []string // open holds the names of the elements enclosing the current text
+	for i := 0; i < len(data); {
+		end := sectionEnd(data, i)
+		if end > 0 && !strings.HasPrefix(data[i:], CDATA_START) {
+			// Comments, processing instructions and DOCTYPEs are kept as they are
+			result.WriteString(data[i:end])
+			i = end
+			continue
+		}
+		if data[i] == '<' && end <= 0 {
+			end := tagEnd(data, i, ">")
+			if end < 0 {
+				// Leave malformed data to the parser
+				result.WriteString(data[i:])
+				break
+			}
+			tag := data[i : end+1]
+			switch {
+			case strings.HasPrefix(tag, "</"):
+				if len(open) > 0 {
+					open = open[:len(open)-1]
+				}
+			case strings.HasPrefix(tag, "<?"), strings.HasPrefix(tag, "<!"), strings.HasSuffix(tag, "/>"):
+				// Declarations, comments and empty elements hold no text
+			default:
+				name := strings.TrimSuffix(tag[1:], ">")
+				if j := strings.IndexAny(name, " \t\r\n"); j >= 0 {
+					name = name[:j]
+				}
+				open = append(open, name)
+			}
+			result.WriteString(tag)
+			i = end + 1
+			continue
+		}
+
+		element := ""
+		if len(open) > 0 {
+			element = open[len(open)-1]
+		}
+		// The content of a CDATA section is limited like text and stays wrapped in the section
+		prefix, text, suffix := "", "", ""
+		if end > 0 {
+			prefix, text, suffix = CDATA_START, data[i+len(CDATA_START):end-len(CDATA_END)], CDATA_END
+			i = end
+		} else {
+			end = strings.IndexByte(data[i:], '<')
+			if end < 0 {
+				end = len(data) - i
+			}
+			text = data[i : i+end]
+			i += end
+		}
+
+		limit := limits.limit(element)
+		if element == "" || limit == 0 || utf8.RuneCountInString(text) <= limit {
+			result.WriteString(prefix + text + suffix)
+			continue
+		}
+		switch limits.Policy {
+		case TEXT_POLICY_REJECT:
+			return "", nil, fmt.Errorf("%w: <%s> has %d characters, at most %d are allowed", ErrTextTooLong, element, utf8.RuneCountInString(text), limit)
+		case TEXT_POLICY_OVERFLOW:
+			overflow = append(overflow, TextOverflow{Position: len(overflow), Element: element, Value: text})
+		}
+		metrics.inc("truncated_texts_total", "element", element)
+		result.WriteString(prefix + truncateText(text, limit) + suffix)
+	}
+	return result.String(), overflow, nil
+}
+
+// createOverflowTable creates the overflow table if not exists
+func createOverflowTable(db *sql.DB) error {
+	query := fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		"%s" INTEGER NOT NULL,
+		"%s" INTEGER NOT NULL,
+		"%s" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		PRIMARY KEY ("%s", "%s")
+	);
+`, DB_OVERFLOW_TABLE_NAME, DB_OVERFLOW_DOC_FIELD_NAME, DB_OVERFLOW_POS_FIELD_NAME, DB_OVERFLOW_ELEM_FIELD_NAME, DB_OVERFLOW_VALUE_FIELD_NAME, DB_OVERFLOW_DOC_FIELD_NAME, DB_OVERFLOW_POS_FIELD_NAME)
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// insertOverflow stores the full texts of a document within tx
+func insertOverflow(tx *sql.Tx, id int64, overflow []TextOverflow) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (%s, %s, %s, %s) VALUES (?, ?, ?, ?)
+	`, DB_OVERFLOW_TABLE_NAME, DB_OVERFLOW_DOC_FIELD_NAME, DB_OVERFLOW_POS_FIELD_NAME, DB_OVERFLOW_ELEM_FIELD_NAME, DB_OVERFLOW_VALUE_FIELD_NAME)
+	for _, text := range overflow {
+		_, err := tx.Exec(query, id, text.Position, text.Element, text.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listOverflow retrieves the full texts of a document ordered by position
+func listOverflow(db *sql.DB, id string) ([]TextOverflow, error) {
+	defer observeQuery("listOverflow", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, %s FROM %s WHERE %s=? ORDER BY %s
+	`, DB_OVERFLOW_POS_FIELD_NAME, DB_OVERFLOW_ELEM_FIELD_NAME, DB_OVERFLOW_VALUE_FIELD_NAME, DB_OVERFLOW_TABLE_NAME, DB_OVERFLOW_DOC_FIELD_NAME, DB_OVERFLOW_POS_FIELD_NAME)
+	var overflow []TextOverflow
+	err := withDBRetry(func() error {
+		rows, err := db.Query(query, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		overflow = []TextOverflow{}
+		for rows.Next() {
+			var text TextOverflow
+			if err := rows.Scan(&text.Position, &text.Element, &text.Value); err != nil {
+				return err
+			}
+			overflow = append(overflow, text)
+		}
+		return rows.Err()
+	})
+	return overflow, err
+}
+
+// handleOverflowRequest returns the full texts of the truncated elements of a document
+func handleOverflowRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	// Embargoed documents have no overflow either
+	_, err := getPublishedDocumentByID(db, id, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+
+	overflow, err := listOverflow(db, id)
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch overflow of document with ID %s: %v", id, err), err)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(overflow)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
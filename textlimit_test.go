@@ -0,0 +1,143 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test enforcing text limits under each policy
+func TestTextLimitsApply(t *testing.T) {
+	long := strings.Repeat("a", 20)
+	tests := []struct {
+		desc     string
+		limits   TextLimits
+		data     string
+		expected string
+		overflow []TextOverflow
+		err      error
+	}{
+		{
+			desc:     "unlimited",
+			limits:   TextLimits{Policy: TEXT_POLICY_TRUNCATE},
+			data:     "<document><description>" + long + "</description></document>",
+			expected: "<document><description>" + long + "</description></document>",
+		},
+		{
+			desc:     "within limit",
+			limits:   TextLimits{MaxLength: 20, Policy: TEXT_POLICY_TRUNCATE},
+			data:     "<document><description>" + long + "</description></document>",
+			expected: "<document><description>" + long + "</description></document>",
+		},
+		{
+			desc:     "truncate",
+			limits:   TextLimits{MaxLength: 10, Policy: TEXT_POLICY_TRUNCATE},
+			data:     `<document><description lang="en">` + long + "</description></document>",
+			expected: `<document><description lang="en">aaaaa[...]</description></document>`,
+		},
+		{
+			desc:     "truncate counts characters",
+			limits:   TextLimits{MaxLength: 7, Policy: TEXT_POLICY_TRUNCATE},
+			data:     "<document><title>ééééééééé</title></document>",
+			expected: "<document><title>éé[...]</title></document>",
+		},
+		{
+			desc:     "element limit overrides default",
+			limits:   TextLimits{MaxLength: 10, Elements: map[string]int{"description": 0, "title": 6}, Policy: TEXT_POLICY_TRUNCATE},
+			data:     "<document><title>Test Title</title><description>" + long + "</description></document>",
+			expected: "<document><title>T[...]</title><description>" + long + "</description></document>",
+		},
+		{
+			desc:     "overflow",
+			limits:   TextLimits{Elements: map[string]int{"description": 10}, Policy: TEXT_POLICY_OVERFLOW},
+			data:     "<document><description>" + long + "</description><item><description>" + long + "b</description></item></document>",
+			expected: "<document><description>aaaaa[...]</description><item><description>aaaaa[...]</description></item></document>",
+			overflow: []TextOverflow{
+				{Position: 0, Element: "description", Value: long},
+				{Position: 1, Element: "description", Value: long + "b"},
+			},
+		},
+		{
+			desc:     "cdata stays wrapped",
+			limits:   TextLimits{MaxLength: 10, Policy: TEXT_POLICY_TRUNCATE},
+			data:     "<document><description><![CDATA[<b>" + long + "</b>]]></description><!-- " + long + " --></document>",
+			expected: "<document><description><![CDATA[<b>aa[...]]]></description><!-- " + long + " --></document>",
+		},
+		{
+			desc:   "reject",
+			limits: TextLimits{MaxLength: 10, Policy: TEXT_POLICY_REJECT},
+			data:   "<document><description>" + long + "</description></document>",
+			err:    ErrTextTooLong,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			data, overflow, err := tt.limits.Apply(tt.data)
+			if tt.err != nil {
+				require.True(t, errors.Is(err, tt.err))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, data)
+			require.Equal(t, tt.overflow, overflow)
+
+			// Truncated documents are left unchanged when reprocessed
+			again, _, err := tt.limits.Apply(data)
+			require.NoError(t, err)
+			require.Equal(t, data, again)
+		})
+	}
+}
+
+// Test that the full text of truncated elements is stored, served and deleted with the document
+func TestTextOverflow(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(limits TextLimits) { textLimits = limits }(textLimits)
+	textLimits = TextLimits{Elements: map[string]int{"description": 10}, Policy: TEXT_POLICY_OVERFLOW}
+
+	long := strings.Repeat("a", 20)
+	req := httptest.NewRequest("POST", "/add", strings.NewReader("<document><title>Test Title</title><description>"+long+"</description></document>"))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "aaaaa[...]", doc.Description)
+
+	req = httptest.NewRequest("GET", "/overflow?id=1", nil)
+	w = httptest.NewRecorder()
+	handleOverflowRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var overflow []TextOverflow
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &overflow))
+	require.Equal(t, []TextOverflow{{Position: 0, Element: "description", Value: long}}, overflow)
+
+	require.NoError(t, deleteDocumentByID(db, "1"))
+	overflow, err = listOverflow(db, "1")
+	require.NoError(t, err)
+	require.Empty(t, overflow)
+}
+
+// Test that /add rejects documents with text over the limit with 422
+func TestHandleAddRequestTextTooLong(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(limits TextLimits) { textLimits = limits }(textLimits)
+	textLimits = TextLimits{MaxLength: 10, Policy: TEXT_POLICY_REJECT}
+
+	req := httptest.NewRequest("POST", "/add", strings.NewReader("<document><description>"+strings.Repeat("a", 20)+"</description></document>"))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+
+	require.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+	require.Contains(t, w.Body.String(), "<description> has 20 characters, at most 10 are allowed")
+}
@@ -0,0 +1,61 @@
+package goapp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	TIMEZONE_PARAM        = "tz"     // Query parameter naming the zone dates are rendered in, e.g. tz=Europe/Berlin
+	CREATED_OFFSET_LAYOUT = "-07:00" // Layout of the recorded original offset of creation dates
+)
+
+// createdAtLayouts lists the formats of creation dates which carry an offset
+// Dates without an offset are ambiguous and are kept as they are
+var createdAtLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05 -0700",
+	time.RFC1123Z,
+}
+
+// normalizeCreatedAt converts a creation date with an offset to UTC
+// It returns the date in the stored format and its original offset, or the value unchanged and
+// an empty offset if it isn't a timestamp with an offset
+func normalizeCreatedAt(value string) (string, string) {
+	for _, layout := range createdAtLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC().Format(time.RFC3339), t.Format(CREATED_OFFSET_LAYOUT)
+		}
+	}
+	return value, ""
+}
+
+// parseTimeZone returns the zone requested with the tz parameter, nil if there is none
+func parseTimeZone(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get(TIMEZONE_PARAM)
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %s", name)
+	}
+	return loc, nil
+}
+
+// renderCreatedAt converts the normalized creation date of doc to loc
+// Creation dates which aren't normalized timestamps are left as they are
+func renderCreatedAt(doc *XMLDoc, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	t, err := time.Parse(time.RFC3339, doc.CreatedAt)
+	if err != nil {
+		return
+	}
+	doc.CreatedAt = t.In(loc).Format(time.RFC3339)
+}
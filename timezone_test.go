@@ -0,0 +1,82 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test normalizing creation dates to UTC
+func TestNormalizeCreatedAt(t *testing.T) {
+	tests := []struct {
+		desc      string
+		value     string
+		createdAt string
+		offset    string
+	}{
+		{desc: "rfc 3339 with offset", value: "2024-07-09T14:30:00+02:00", createdAt: "2024-07-09T12:30:00Z", offset: "+02:00"},
+		{desc: "rfc 3339 in utc", value: "2024-07-09T12:30:00Z", createdAt: "2024-07-09T12:30:00Z", offset: "+00:00"},
+		{desc: "offset without colon", value: "2024-07-09T06:30:00-0600", createdAt: "2024-07-09T12:30:00Z", offset: "-06:00"},
+		{desc: "space separated", value: "2024-07-09 23:30:00 +1100", createdAt: "2024-07-09T12:30:00Z", offset: "+11:00"},
+		{desc: "rfc 1123", value: "Tue, 09 Jul 2024 14:30:00 +0200", createdAt: "2024-07-09T12:30:00Z", offset: "+02:00"},
+		{desc: "date only", value: "2024-07-09", createdAt: "2024-07-09"},
+		{desc: "time without offset", value: "2024-07-09T12:30:00", createdAt: "2024-07-09T12:30:00"},
+		{desc: "free text", value: "summer 2024", createdAt: "summer 2024"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			createdAt, offset := normalizeCreatedAt(tt.value)
+			require.Equal(t, tt.createdAt, createdAt)
+			require.Equal(t, tt.offset, offset)
+		})
+	}
+}
+
+// Test that creation dates are stored in UTC and rendered in the requested zone
+func TestHandleDocumentRequestTimeZone(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<document><title>Test Title</title><creationDate>2024-07-09T14:30:00+02:00</creationDate></document>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	tests := []struct {
+		desc      string
+		url       string
+		status    int
+		createdAt string
+	}{
+		{desc: "utc by default", url: "/document?id=1", status: http.StatusOK, createdAt: "2024-07-09T12:30:00Z"},
+		{desc: "requested zone", url: "/document?id=1&tz=America/New_York", status: http.StatusOK, createdAt: "2024-07-09T08:30:00-04:00"},
+		{desc: "invalid zone", url: "/document?id=1&tz=Mars/Olympus", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			handleDocumentRequest(db, w, req)
+
+			require.Equal(t, tt.status, w.Result().StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var readDoc XMLDoc
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &readDoc))
+			require.Equal(t, tt.createdAt, readDoc.CreatedAt)
+			require.Equal(t, "+02:00", readDoc.CreatedOffset)
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/list?tz=Asia/Tokyo", nil)
+	w := httptest.NewRecorder()
+	handleListRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var docs []XMLDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &docs))
+	require.Len(t, docs, 1)
+	require.Equal(t, "2024-07-09T21:30:00+09:00", docs[0].CreatedAt)
+}
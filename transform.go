@@ -0,0 +1,829 @@
+package goapp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	STYLESHEETS_ENV = "DOC_STYLESHEETS"                      // Environment variable with the directory of the .xsl stylesheets /document can apply
+	XSLT_NAMESPACE  = "http://www.w3.org/1999/XSL/Transform" // Namespace of the XSLT instructions
+
+	XSLT_METHOD_XML  = "xml"  // Output method writing markup, the default
+	XSLT_METHOD_HTML = "html" // Output method writing markup served as HTML
+	XSLT_METHOD_TEXT = "text" // Output method writing the text without escaping
+
+	XSLT_MAX_DEPTH = 1000 // Deepest nesting of template calls, to stop stylesheets recursing forever
+)
+
+// stylesheetExtensions are the extensions of the stylesheet files loaded from STYLESHEETS_ENV
+var stylesheetExtensions = map[string]bool{".xsl": true, ".xslt": true}
+
+// stylesheets are the registered stylesheets by name, the name of their file without extension
+var stylesheets = map[string]*Stylesheet{}
+
+// Stylesheet is a compiled stylesheet of the XSLT 1.0 subset applied by /document?transform=
+// Supported are the instructions template (match and name), apply-templates, call-template, for-each, sort,
+// value-of, copy, copy-of, if, choose, text, element, attribute and output (method only), literal result
+// elements with attribute value templates, and the expressions of the XPath subset relative to the context
+// element, like "item[2]/@lang", with the functions name(), count(), concat(), normalize-space() and not(),
+// and comparisons with = and !=. Variables, parameters, modes and keys aren't supported.
+type Stylesheet struct {
+	Method    string // Method is the output method, XSLT_METHOD_XML, XSLT_METHOD_HTML or XSLT_METHOD_TEXT
+	prefix    string // prefix is the prefix of the XSLT namespace in the stylesheet
+	templates []xslTemplate
+	named     map[string]*Node
+}
+
+// xslTemplate is a template rule for one alternative of its match pattern
+type xslTemplate struct {
+	pattern  xslPattern
+	priority float64
+	body     *Node
+}
+
+// xslPattern is a match pattern like "/", "item", "order/item[@lang='en']" or "*"
+type xslPattern struct {
+	root  bool       // root is true for the pattern "/" matching the document
+	steps []pathStep // steps are matched against the element and its ancestors, the last step first
+}
+
+// initStylesheets loads the stylesheets from the directory in the environment
+func initStylesheets() {
+	funcName := "initStylesheets"
+
+	dir := os.Getenv(STYLESHEETS_ENV)
+	if dir == "" {
+		return
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s: %v", funcName, STYLESHEETS_ENV, err)
+	}
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || !stylesheetExtensions[ext] {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			log.Fatalf("%s: Failed to read stylesheet %s: %v", funcName, file.Name(), err)
+		}
+		stylesheet, err := ParseStylesheet(string(data))
+		if err != nil {
+			log.Fatalf("%s: Invalid stylesheet %s: %v", funcName, file.Name(), err)
+		}
+		stylesheets[strings.TrimSuffix(file.Name(), ext)] = stylesheet
+	}
+	log.Printf("%s: Loaded %d stylesheets", funcName, len(stylesheets))
+}
+
+// ParseStylesheet compiles a stylesheet of the supported XSLT subset
+func ParseStylesheet(data string) (*Stylesheet, error) {
+	root, err := ParseTree(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	stylesheet := &Stylesheet{Method: XSLT_METHOD_XML, named: map[string]*Node{}}
+	for name, uri := range root.Attrs {
+		if prefix, local := splitName(name); prefix == XMLNS_ATTRIBUTE && uri == XSLT_NAMESPACE {
+			stylesheet.prefix = local
+		}
+	}
+	if stylesheet.prefix == "" {
+		return nil, fmt.Errorf("the XSLT namespace %s must be declared with a prefix on the root element", XSLT_NAMESPACE)
+	}
+	if instruction := stylesheet.instruction(root); instruction != "stylesheet" && instruction != "transform" {
+		return nil, fmt.Errorf("the root element must be %s:stylesheet, not %s", stylesheet.prefix, root.Name)
+	}
+
+	for _, child := range root.Children {
+		switch stylesheet.instruction(child) {
+		case "template":
+			if err := stylesheet.addTemplate(child); err != nil {
+				return nil, err
+			}
+		case "output":
+			method := child.Attrs["method"]
+			if method != "" && method != XSLT_METHOD_XML && method != XSLT_METHOD_HTML && method != XSLT_METHOD_TEXT {
+				return nil, fmt.Errorf("unsupported output method %s", method)
+			}
+			if method != "" {
+				stylesheet.Method = method
+			}
+		case "":
+			// Top-level elements of other namespaces are ignored
+		default:
+			return nil, fmt.Errorf("unsupported top-level instruction %s", child.Name)
+		}
+	}
+	return stylesheet, nil
+}
+
+// instruction returns the local name of an XSLT instruction, empty for other elements
+func (stylesheet *Stylesheet) instruction(node *Node) string {
+	prefix, local := splitName(node.Name)
+	if prefix != stylesheet.prefix {
+		return ""
+	}
+	return local
+}
+
+// addTemplate adds a template with a match pattern, a name, or both
+func (stylesheet *Stylesheet) addTemplate(node *Node) error {
+	name, match := node.Attrs["name"], node.Attrs["match"]
+	if name == "" && match == "" {
+		return fmt.Errorf("template without match or name")
+	}
+	if name != "" {
+		stylesheet.named[name] = node
+	}
+	if match == "" {
+		return nil
+	}
+
+	for _, alternative := range strings.Split(match, "|") {
+		pattern, err := parsePattern(strings.TrimSpace(alternative))
+		if err != nil {
+			return err
+		}
+		template := xslTemplate{pattern: pattern, priority: pattern.defaultPriority(), body: node}
+		if value := node.Attrs["priority"]; value != "" {
+			if template.priority, err = strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("invalid priority %s of template %s", value, match)
+			}
+		}
+		stylesheet.templates = append(stylesheet.templates, template)
+	}
+	return nil
+}
+
+// parsePattern parses a match pattern of element steps separated by '/'
+func parsePattern(pattern string) (xslPattern, error) {
+	if pattern == "/" {
+		return xslPattern{root: true}, nil
+	}
+	// The ancestors a pattern names are only checked for the parent steps, "//" is read as "/"
+	rest := strings.TrimLeft(pattern, "/")
+	result := xslPattern{}
+	for rest != "" {
+		end := stepEnd(rest)
+		step, err := parsePathStep(rest[:end])
+		if err != nil {
+			return result, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		result.steps = append(result.steps, step)
+		rest = strings.TrimLeft(rest[end:], "/")
+	}
+	if len(result.steps) == 0 {
+		return result, fmt.Errorf("invalid pattern %s", pattern)
+	}
+	return result, nil
+}
+
+// defaultPriority is the priority XSLT gives templates without one: names over '*' and steps with context over names
+func (pattern xslPattern) defaultPriority() float64 {
+	if pattern.root || len(pattern.steps) > 1 || pattern.steps[0].Position > 0 || len(pattern.steps[0].Attributes) > 0 {
+		return 0.5
+	}
+	if pattern.steps[0].Name == XPATH_ANY_NAME {
+		return -0.5
+	}
+	return 0
+}
+
+// matches tells whether the element, or the document if node is nil, matches the pattern
+func (pattern xslPattern) matches(node *Node) bool {
+	if node == nil || pattern.root {
+		return node == nil && pattern.root
+	}
+	for i := len(pattern.steps) - 1; i >= 0; i-- {
+		if node == nil || !pattern.steps[i].matches(node) {
+			return false
+		}
+		if position := pattern.steps[i].Position; position > 0 && siblingPosition(node, pattern.steps[i]) != position {
+			return false
+		}
+		node = node.Parent
+	}
+	return true
+}
+
+// siblingPosition returns the 1-based position of node among its siblings matching the step
+func siblingPosition(node *Node, step pathStep) int {
+	if node.Parent == nil {
+		return 1
+	}
+	position := 0
+	for _, sibling := range node.Parent.Children {
+		if step.matches(sibling) {
+			position++
+		}
+		if sibling == node {
+			break
+		}
+	}
+	return position
+}
+
+// ContentType returns the media type of the output of the stylesheet
+func (stylesheet *Stylesheet) ContentType() string {
+	switch stylesheet.Method {
+	case XSLT_METHOD_HTML:
+		return "text/html; charset=utf-8"
+	case XSLT_METHOD_TEXT:
+		return "text/plain; charset=utf-8"
+	}
+	return "application/xml"
+}
+
+// Transform applies the stylesheet to the element tree of a document
+func (stylesheet *Stylesheet) Transform(root *Node) (string, error) {
+	transformer := &transformer{stylesheet: stylesheet, root: root}
+	if err := transformer.applyTemplates(nil, 0); err != nil {
+		return "", err
+	}
+	return transformer.out.String(), nil
+}
+
+// transformer holds the output of a transformation
+type transformer struct {
+	stylesheet *Stylesheet
+	root       *Node
+	out        strings.Builder
+}
+
+// text writes text, escaped unless the output method is text
+func (t *transformer) text(text string) {
+	if t.stylesheet.Method == XSLT_METHOD_TEXT {
+		t.out.WriteString(text)
+	} else {
+		t.out.WriteString(xmlEscaper.Replace(text))
+	}
+}
+
+// applyTemplates applies the best matching template to the element, or to the document if node is nil
+// Without matching template the built-in rule writes the text of the element and applies the templates to its children.
+func (t *transformer) applyTemplates(node *Node, depth int) error {
+	if depth > XSLT_MAX_DEPTH {
+		return fmt.Errorf("templates nested deeper than %d", XSLT_MAX_DEPTH)
+	}
+
+	var best *xslTemplate
+	for i := range t.stylesheet.templates {
+		template := &t.stylesheet.templates[i]
+		// Of templates with the same priority the last one wins
+		if template.pattern.matches(node) && (best == nil || template.priority >= best.priority) {
+			best = template
+		}
+	}
+	if best != nil {
+		return t.instantiate(best.body, node, depth+1)
+	}
+
+	return t.applyToContent(node, depth)
+}
+
+// applyToContent applies the templates to the children of the element and writes the text between them
+func (t *transformer) applyToContent(node *Node, depth int) error {
+	if node == nil {
+		return t.applyTemplates(t.root, depth+1)
+	}
+	t.text(node.Text)
+	for _, child := range node.Children {
+		if err := t.applyTemplates(child, depth+1); err != nil {
+			return err
+		}
+		t.text(child.Tail)
+	}
+	return nil
+}
+
+// instantiate writes the content of a template or instruction for the context element
+func (t *transformer) instantiate(body *Node, context *Node, depth int) error {
+	t.literalText(body.Text)
+	for _, child := range body.Children {
+		if err := t.evaluate(child, context, depth); err != nil {
+			return err
+		}
+		t.literalText(child.Tail)
+	}
+	return nil
+}
+
+// literalText writes text of the stylesheet, only whitespace between instructions is left out
+func (t *transformer) literalText(text string) {
+	if strings.Trim(text, XML_WHITESPACE) != "" {
+		t.text(text)
+	}
+}
+
+// evaluate writes the result of an instruction or literal result element
+func (t *transformer) evaluate(node *Node, context *Node, depth int) error {
+	instruction := t.stylesheet.instruction(node)
+	switch instruction {
+	case "":
+		return t.literalElement(node, context, depth)
+	case "apply-templates", "for-each":
+		expr, ok := node.Attrs["select"]
+		if !ok && instruction == "for-each" {
+			return fmt.Errorf("for-each without select")
+		} else if !ok {
+			// Without select the text between the children is written too, as by the built-in rule
+			return t.applyToContent(context, depth)
+		}
+		nodes, err := t.selectElements(context, expr)
+		if err != nil {
+			return err
+		}
+		if err := t.sort(node, nodes); err != nil {
+			return err
+		}
+		for _, selected := range nodes {
+			var err error
+			if instruction == "for-each" {
+				err = t.instantiate(node, selected, depth+1)
+			} else {
+				err = t.applyTemplates(selected, depth+1)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case "call-template":
+		template, ok := t.stylesheet.named[node.Attrs["name"]]
+		if !ok {
+			return fmt.Errorf("no template named %s", node.Attrs["name"])
+		}
+		if depth > XSLT_MAX_DEPTH {
+			return fmt.Errorf("templates nested deeper than %d", XSLT_MAX_DEPTH)
+		}
+		return t.instantiate(template, context, depth+1)
+	case "value-of":
+		value, err := t.stringValue(context, node.Attrs["select"])
+		if err != nil {
+			return err
+		}
+		t.text(value)
+	case "copy-of":
+		nodes, attribute, text, err := t.evalPath(context, node.Attrs["select"])
+		if err != nil {
+			return err
+		}
+		for _, selected := range nodes {
+			switch {
+			case attribute != "":
+				t.text(selected.Attrs[attribute])
+			case text:
+				t.text(selected.directText())
+			case t.stylesheet.Method == XSLT_METHOD_TEXT:
+				t.text(selected.TextContent())
+			default:
+				t.out.WriteString(selected.String())
+			}
+		}
+	case "copy":
+		if context == nil {
+			return t.instantiate(node, context, depth)
+		}
+		return t.element(context.Name, nil, node, context, depth)
+	case "element":
+		name, err := t.attributeValue(node.Attrs["name"], context)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return fmt.Errorf("element without name")
+		}
+		return t.element(name, nil, node, context, depth)
+	case "text":
+		t.text(node.Text)
+	case "if":
+		matched, err := t.test(context, node.Attrs["test"])
+		if err != nil || !matched {
+			return err
+		}
+		return t.instantiate(node, context, depth)
+	case "choose":
+		for _, branch := range node.Children {
+			switch t.stylesheet.instruction(branch) {
+			case "when":
+				matched, err := t.test(context, branch.Attrs["test"])
+				if err != nil {
+					return err
+				}
+				if matched {
+					return t.instantiate(branch, context, depth)
+				}
+			case "otherwise":
+				return t.instantiate(branch, context, depth)
+			default:
+				return fmt.Errorf("unexpected %s in choose", branch.Name)
+			}
+		}
+	case "sort", "attribute":
+		// Handled by the instruction or element containing them
+	default:
+		return fmt.Errorf("unsupported instruction %s", node.Name)
+	}
+	return nil
+}
+
+// literalElement writes an element of the stylesheet which isn't an instruction
+func (t *transformer) literalElement(node *Node, context *Node, depth int) error {
+	attrs := map[string]string{}
+	for name, value := range node.Attrs {
+		// The declaration of the XSLT namespace isn't copied to the output
+		if prefix, local := splitName(name); prefix == XMLNS_ATTRIBUTE && local == t.stylesheet.prefix {
+			continue
+		}
+		value, err := t.attributeValue(value, context)
+		if err != nil {
+			return err
+		}
+		attrs[name] = value
+	}
+	return t.element(node.Name, attrs, node, context, depth)
+}
+
+// element writes an element with attrs and the attributes of the attribute instructions of body,
+// and the content of body
+func (t *transformer) element(name string, attrs map[string]string, body *Node, context *Node, depth int) error {
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	for _, child := range body.Children {
+		if t.stylesheet.instruction(child) != "attribute" {
+			continue
+		}
+		attrName, err := t.attributeValue(child.Attrs["name"], context)
+		if err != nil {
+			return err
+		}
+		if attrName == "" {
+			return fmt.Errorf("attribute without name")
+		}
+		// The content of the attribute is written to a transformer of its own
+		value := &transformer{stylesheet: &Stylesheet{Method: XSLT_METHOD_TEXT, prefix: t.stylesheet.prefix, named: t.stylesheet.named, templates: t.stylesheet.templates}, root: t.root}
+		if err := value.instantiate(child, context, depth+1); err != nil {
+			return err
+		}
+		attrs[attrName] = value.out.String()
+	}
+
+	if t.stylesheet.Method == XSLT_METHOD_TEXT {
+		return t.instantiate(body, context, depth+1)
+	}
+	(&Node{Name: name, Attrs: attrs}).writeStartTag(&t.out)
+	if err := t.instantiate(body, context, depth+1); err != nil {
+		return err
+	}
+	t.out.WriteString("</" + name + ">")
+	return nil
+}
+
+// attributeValue evaluates an attribute value template like "item-{@id}", "{{" and "}}" are literal braces
+func (t *transformer) attributeValue(template string, context *Node) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(template); i++ {
+		char := template[i]
+		switch {
+		case strings.HasPrefix(template[i:], "{{") || strings.HasPrefix(template[i:], "}}"):
+			result.WriteByte(char)
+			i++
+		case char == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated expression in %s", template)
+			}
+			value, err := t.stringValue(context, template[i+1:i+end])
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(value)
+			i += end
+		default:
+			result.WriteByte(char)
+		}
+	}
+	return result.String(), nil
+}
+
+// sort orders nodes by the sort instructions of the instruction selecting them
+func (t *transformer) sort(instruction *Node, nodes []*Node) error {
+	for i := len(instruction.Children) - 1; i >= 0; i-- {
+		child := instruction.Children[i]
+		if t.stylesheet.instruction(child) != "sort" {
+			continue
+		}
+		keys := make(map[*Node]string, len(nodes))
+		for _, node := range nodes {
+			key, err := t.stringValue(node, selectOrSelf(child.Attrs["select"]))
+			if err != nil {
+				return err
+			}
+			keys[node] = key
+		}
+		descending := child.Attrs["order"] == "descending"
+		numeric := child.Attrs["data-type"] == "number"
+		// The last sort key is applied first, stable sorts keep its order for equal earlier keys
+		sort.SliceStable(nodes, func(a, b int) bool {
+			keyA, keyB := keys[nodes[a]], keys[nodes[b]]
+			if descending {
+				keyA, keyB = keyB, keyA
+			}
+			if numeric {
+				numberA, _ := strconv.ParseFloat(strings.TrimSpace(keyA), 64)
+				numberB, _ := strconv.ParseFloat(strings.TrimSpace(keyB), 64)
+				return numberA < numberB
+			}
+			return keyA < keyB
+		})
+	}
+	return nil
+}
+
+// selectOrSelf returns the select expression of a sort, "." when it has none
+func selectOrSelf(expr string) string {
+	if expr == "" {
+		return "."
+	}
+	return expr
+}
+
+// selectElements returns the elements an expression selects
+func (t *transformer) selectElements(context *Node, expr string) ([]*Node, error) {
+	nodes, attribute, text, err := t.evalPath(context, expr)
+	if err != nil {
+		return nil, err
+	}
+	if attribute != "" || text {
+		return nil, fmt.Errorf("%s selects no elements", expr)
+	}
+	return nodes, nil
+}
+
+// evalPath evaluates a path of the XPath subset, absolute or relative to the context element
+// It returns the selected elements and the attribute or text of them the path selects.
+func (t *transformer) evalPath(context *Node, expr string) (nodes []*Node, attribute string, text bool, err error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, "", false, fmt.Errorf("empty expression")
+	}
+	if strings.HasPrefix(expr, "/") {
+		xpath, err := parseXPath(expr)
+		if err != nil {
+			return nil, "", false, err
+		}
+		return xpath.Select(t.root), xpath.Attribute, xpath.Text, nil
+	}
+
+	// The context of the document is the root element as its only child
+	document := &Node{Children: []*Node{t.root}}
+	if context == nil {
+		context = document
+	}
+	nodes = []*Node{context}
+	rest := expr
+	for rest != "" {
+		descendant := strings.HasPrefix(rest, "/")
+		rest = strings.TrimPrefix(rest, "/")
+		end := stepEnd(rest)
+		part := rest[:end]
+		rest = strings.TrimPrefix(rest[end:], "/")
+		last := rest == "" || rest == "/"
+
+		switch {
+		case part == ".":
+		case part == "..":
+			var parents []*Node
+			for _, node := range nodes {
+				if node.Parent != nil {
+					parents = append(parents, node.Parent)
+				} else if node == t.root {
+					parents = append(parents, document)
+				}
+			}
+			nodes = parents
+		case last && part == XPATH_TEXT_STEP && !descendant:
+			return nodes, "", true, nil
+		case last && strings.HasPrefix(part, XPATH_ATTRIBUTE_STEP) && !descendant:
+			attribute = part[len(XPATH_ATTRIBUTE_STEP):]
+			var result []*Node
+			for _, node := range nodes {
+				if _, ok := node.Attrs[attribute]; ok {
+					result = append(result, node)
+				}
+			}
+			return result, attribute, false, nil
+		default:
+			step, err := parsePathStep(part)
+			if err != nil {
+				return nil, "", false, fmt.Errorf("invalid path %s: %w", expr, err)
+			}
+			var next []*Node
+			for _, node := range nodes {
+				if !descendant {
+					next = append(next, step.filter(node.Children)...)
+					continue
+				}
+				for _, inner := range descendantsOrSelf(node) {
+					next = append(next, step.filter(inner.Children)...)
+				}
+			}
+			nodes = inDocumentOrder(t.root, next)
+		}
+	}
+	return nodes, "", false, nil
+}
+
+// stringValue evaluates an expression to a string: a literal, a function call or the value of the first node of a path
+func (t *transformer) stringValue(context *Node, expr string) (string, error) {
+	expr = strings.TrimSpace(expr)
+	if literal, ok := stringLiteral(expr); ok {
+		return literal, nil
+	}
+	if _, err := strconv.ParseFloat(expr, 64); err == nil {
+		return expr, nil
+	}
+	if name, args, ok := functionCall(expr); ok {
+		switch {
+		case name == "name" && len(args) == 0:
+			if context == nil {
+				return "", nil
+			}
+			return context.Name, nil
+		case name == "count" && len(args) == 1:
+			nodes, _, _, err := t.evalPath(context, args[0])
+			return strconv.Itoa(len(nodes)), err
+		case name == "normalize-space" && len(args) <= 1:
+			value, err := t.stringValue(context, selectOrSelf(strings.Join(args, "")))
+			return collapseText(value), err
+		case name == "concat" && len(args) >= 2:
+			var result strings.Builder
+			for _, arg := range args {
+				value, err := t.stringValue(context, arg)
+				if err != nil {
+					return "", err
+				}
+				result.WriteString(value)
+			}
+			return result.String(), nil
+		case name == "not" && len(args) == 1:
+			matched, err := t.test(context, args[0])
+			return strconv.FormatBool(!matched), err
+		}
+		return "", fmt.Errorf("unsupported function call %s", expr)
+	}
+
+	if expr == "." {
+		if context == nil {
+			return t.root.TextContent(), nil
+		}
+		return context.TextContent(), nil
+	}
+	nodes, attribute, text, err := t.evalPath(context, expr)
+	if err != nil || len(nodes) == 0 {
+		return "", err
+	}
+	switch {
+	case attribute != "":
+		return nodes[0].Attrs[attribute], nil
+	case text:
+		return nodes[0].directText(), nil
+	}
+	return nodes[0].TextContent(), nil
+}
+
+// test evaluates the condition of if and when: a comparison with = or !=, not(), or a path selecting something
+func (t *transformer) test(context *Node, expr string) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if left, operator, right, ok := splitComparison(expr); ok {
+		a, err := t.stringValue(context, left)
+		if err != nil {
+			return false, err
+		}
+		b, err := t.stringValue(context, right)
+		if err != nil {
+			return false, err
+		}
+		return (a == b) == (operator == "="), nil
+	}
+	if name, args, ok := functionCall(expr); ok {
+		if name == "not" && len(args) == 1 {
+			matched, err := t.test(context, args[0])
+			return !matched, err
+		}
+		value, err := t.stringValue(context, expr)
+		return value != "" && value != "0" && value != "false", err
+	}
+	if literal, ok := stringLiteral(expr); ok {
+		return literal != "", nil
+	}
+	nodes, _, _, err := t.evalPath(context, expr)
+	return len(nodes) > 0, err
+}
+
+// stringLiteral returns the content of a quoted string like 'en'
+func stringLiteral(expr string) (string, bool) {
+	if len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && strings.IndexByte(expr[1:], expr[0]) == len(expr)-2 {
+		return expr[1 : len(expr)-1], true
+	}
+	return "", false
+}
+
+// functionCall splits a call like concat(@a, '-', b) into the name and the arguments of the function
+func functionCall(expr string) (name string, args []string, ok bool) {
+	open := strings.IndexByte(expr, '(')
+	if open <= 0 || !strings.HasSuffix(expr, ")") || strings.ContainsAny(expr[:open], "/@[]'\" ") {
+		return "", nil, false
+	}
+	inner := expr[open+1 : len(expr)-1]
+	if strings.TrimSpace(inner) != "" {
+		args = splitOutside(inner, ",")
+		if args == nil {
+			return "", nil, false
+		}
+	}
+	return expr[:open], args, true
+}
+
+// splitComparison splits an expression like "@lang = 'en'" at its operator outside of quotes, brackets and parentheses
+func splitComparison(expr string) (left string, operator string, right string, ok bool) {
+	for _, operator := range []string{"!=", "="} {
+		if parts := splitOutside(expr, operator); len(parts) == 2 {
+			return parts[0], operator, parts[1], true
+		}
+	}
+	return "", "", "", false
+}
+
+// splitOutside splits expr at separator outside of quotes, brackets and parentheses, nil if they don't pair
+func splitOutside(expr string, separator string) []string {
+	var parts []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(expr); i++ {
+		switch char := expr[i]; {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case char == '[' || char == '(':
+			depth++
+		case char == ']' || char == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(expr[i:], separator):
+			// "!=" isn't an "=" comparison
+			if separator == "=" && i > 0 && expr[i-1] == '!' {
+				continue
+			}
+			parts = append(parts, strings.TrimSpace(expr[start:i]))
+			start = i + len(separator)
+			i += len(separator) - 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil
+	}
+	return append(parts, strings.TrimSpace(expr[start:]))
+}
+
+// transformDocument writes the result of applying a registered stylesheet to the document
+func transformDocument(w http.ResponseWriter, doc *XMLDoc, name string) {
+	stylesheet, ok := stylesheets[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown stylesheet %s", name), http.StatusBadRequest)
+		return
+	}
+
+	tree, err := doc.elementTree()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse document with ID %s: %v", doc.ID, err), http.StatusInternalServerError)
+		return
+	}
+	if tree == nil {
+		http.Error(w, fmt.Sprintf("Document with ID %s has no element tree, reprocess it first", doc.ID), http.StatusConflict)
+		return
+	}
+
+	result, err := stylesheet.Transform(tree)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to transform document with stylesheet %s: %v", name, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", stylesheet.ContentType())
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(result))
+}
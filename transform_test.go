@@ -0,0 +1,143 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testOrder = `<order id="7"><customer>Ada</customer><item sku="b" qty="2">Pen</item><item sku="a" qty="10">Ink &amp; paper</item><note>Rush</note></order>`
+
+// Test applying stylesheets of the XSLT subset
+func TestStylesheetTransform(t *testing.T) {
+	tests := []struct {
+		desc       string
+		stylesheet string
+		expected   string
+	}{
+		{
+			desc: "literal elements, for-each, sort and attribute value templates",
+			stylesheet: `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="/">
+    <summary order="{order/@id}" items="{count(order/item)}">
+      <xsl:for-each select="order/item">
+        <xsl:sort select="@sku"/>
+        <line sku="{@sku}"><xsl:value-of select="."/></line>
+      </xsl:for-each>
+    </summary>
+  </xsl:template>
+</xsl:stylesheet>`,
+			expected: `<summary items="2" order="7"><line sku="a">Ink &amp; paper</line><line sku="b">Pen</line></summary>`,
+		},
+		{
+			desc: "template rules, priorities and built-in rules",
+			stylesheet: `<x:transform version="1.0" xmlns:x="http://www.w3.org/1999/XSL/Transform">
+  <x:output method="text"/>
+  <x:template match="*"><x:apply-templates/></x:template>
+  <x:template match="customer">Customer: <x:value-of select="."/>; </x:template>
+  <x:template match="order/item[@sku='a']">
+    <x:text>Item </x:text><x:value-of select="concat(@sku, '=', @qty)"/><x:text>; </x:text>
+  </x:template>
+  <x:template match="note"/>
+</x:transform>`,
+			expected: `Customer: Ada; PenItem a=10; `,
+		},
+		{
+			desc: "conditions, named templates and elements",
+			stylesheet: `<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="order">
+    <xsl:element name="{name()}-copy">
+      <xsl:attribute name="rush"><xsl:if test="note = 'Rush'">yes</xsl:if></xsl:attribute>
+      <xsl:for-each select="item">
+        <xsl:sort select="@qty" data-type="number" order="descending"/>
+        <xsl:choose>
+          <xsl:when test="@qty != '2'"><xsl:call-template name="big"/></xsl:when>
+          <xsl:otherwise><xsl:copy-of select="."/></xsl:otherwise>
+        </xsl:choose>
+      </xsl:for-each>
+      <xsl:if test="not(gift)"><nogift/></xsl:if>
+    </xsl:element>
+  </xsl:template>
+  <xsl:template name="big"><xsl:copy><xsl:value-of select="../customer"/></xsl:copy></xsl:template>
+</xsl:stylesheet>`,
+			expected: `<order-copy rush="yes"><item>Ada</item><item qty="2" sku="b">Pen</item><nogift></nogift></order-copy>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			stylesheet, err := ParseStylesheet(tt.stylesheet)
+			require.NoError(t, err)
+			tree, err := ParseTree(strings.NewReader(testOrder))
+			require.NoError(t, err)
+
+			result, err := stylesheet.Transform(tree)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+// Test rejecting stylesheets outside of the subset
+func TestParseStylesheetErrors(t *testing.T) {
+	tests := []struct {
+		desc       string
+		stylesheet string
+		expected   string
+	}{
+		{desc: "no XSLT namespace", stylesheet: `<stylesheet><template match="/"/></stylesheet>`, expected: "must be declared"},
+		{desc: "wrong root", stylesheet: `<xsl:template xmlns:xsl="http://www.w3.org/1999/XSL/Transform"/>`, expected: "root element must be"},
+		{desc: "variables", stylesheet: `<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform"><xsl:variable name="a"/></xsl:stylesheet>`, expected: "unsupported top-level instruction xsl:variable"},
+		{desc: "bad pattern", stylesheet: `<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform"><xsl:template match="a[@b"/></xsl:stylesheet>`, expected: "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := ParseStylesheet(tt.stylesheet)
+			require.ErrorContains(t, err, tt.expected)
+		})
+	}
+
+	// Templates calling themselves stop at the maximum depth
+	stylesheet, err := ParseStylesheet(`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform"><xsl:template match="order"><xsl:apply-templates select="."/></xsl:template></xsl:stylesheet>`)
+	require.NoError(t, err)
+	tree, err := ParseTree(strings.NewReader(testOrder))
+	require.NoError(t, err)
+	_, err = stylesheet.Transform(tree)
+	require.ErrorContains(t, err, "nested deeper")
+}
+
+// Test transforming documents on retrieval
+func TestHandleDocumentTransform(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	stylesheet, err := ParseStylesheet(`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform"><xsl:output method="text"/><xsl:template match="/">Customer <xsl:value-of select="order/customer"/></xsl:template></xsl:stylesheet>`)
+	require.NoError(t, err)
+	stylesheets["customer"] = stylesheet
+	defer delete(stylesheets, "customer")
+
+	doc, err := parseDocument(testOrder)
+	require.NoError(t, err)
+	id, err := addDocument(db, *doc)
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{desc: "registered stylesheet", path: "/document?id=" + id + "&transform=customer", expectedCode: http.StatusOK, expectedBody: "Customer Ada"},
+		{desc: "unknown stylesheet", path: "/document?id=" + id + "&transform=other", expectedCode: http.StatusBadRequest, expectedBody: "Unknown stylesheet other\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleRequest(db, rr, httptest.NewRequest("GET", tt.path, nil))
+			require.Equal(t, tt.expectedCode, rr.Code)
+			require.Equal(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Node is an element of the element tree of a document
+type Node struct {
+	Name     string
+	Attrs    map[string]string `json:",omitempty"`
+	Children []*Node           `json:",omitempty"`
+	Text     string            `json:",omitempty"` // Text is the text inside the element up to its first child
+	Tail     string            `json:",omitempty"` // Tail is the text after the element up to its next sibling, inside its parent
+	Comments []Comment         `json:",omitempty"` // Comments are the comments directly inside the element, kept if the document was parsed with ParseOptions.Comments
+	Parent   *Node             `json:"-"`          // Parent is nil for the root
+}
+
+// Comment is a comment inside an element, like <!-- if x > 3 -->
+// It is kept apart from the children, so code walking the elements of a tree doesn't meet it.
+type Comment struct {
+	Text   string // Text is the content between <!-- and -->
+	Before int    // Before is the index of the child the comment comes before, the number of children if it comes after all
+	Offset int    // Offset is the length in bytes of the text between the previous child, or the start tag, and the comment
+}
+
+// hasComments reports whether the node or one of its descendants holds a comment
+func (node *Node) hasComments() bool {
+	if len(node.Comments) > 0 {
+		return true
+	}
+	for _, child := range node.Children {
+		if child.hasComments() {
+			return true
+		}
+	}
+	return false
+}
+
+// treeBuilder is the StreamHandler building the tree of ParseTree
+type treeBuilder struct {
+	root     *Node
+	current  *Node
+	comments bool // comments tells whether comments inside the root element are kept
+}
+
+func (builder *treeBuilder) StartElement(name string, attrs map[string]string) error {
+	if builder.root != nil && builder.current == nil {
+		return errors.New("more than one root element")
+	}
+	node := &Node{Name: name, Parent: builder.current}
+	if len(attrs) > 0 {
+		node.Attrs = attrs
+	}
+	if builder.current == nil {
+		builder.root = node
+	} else {
+		builder.current.Children = append(builder.current.Children, node)
+	}
+	builder.current = node
+	return nil
+}
+
+func (builder *treeBuilder) EndElement(name string) error {
+	builder.current = builder.current.Parent
+	return nil
+}
+
+func (builder *treeBuilder) Text(text string) error {
+	builder.current.appendText(text)
+	return nil
+}
+
+func (builder *treeBuilder) Comment(text string) error {
+	if !builder.comments || builder.current == nil {
+		return nil
+	}
+	node := builder.current
+	offset := len(node.Text)
+	if len(node.Children) > 0 {
+		offset = len(node.Children[len(node.Children)-1].Tail)
+	}
+	node.Comments = append(node.Comments, Comment{Text: text, Before: len(node.Children), Offset: offset})
+	return nil
+}
+
+// ParseTree parses the XML read from r into its element tree and returns the root
+// Comments are left out.
+func ParseTree(r io.Reader) (*Node, error) {
+	return parseTree(r, false)
+}
+
+// parseTree parses the XML read from r into its element tree like ParseTree, keeping the comments if comments is set
+func parseTree(r io.Reader, comments bool) (*Node, error) {
+	builder := &treeBuilder{comments: comments}
+	err := ParseReader(r, builder)
+	if err != nil {
+		return nil, err
+	}
+	if builder.root == nil {
+		return nil, errors.New("no data for parsing")
+	}
+	return builder.root, nil
+}
+
+// xmlAttrEscaper escapes attribute values written by Node.String
+var xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// directText returns the text directly inside the node, the Text followed by the tails of the children
+func (node *Node) directText() string {
+	text := node.Text
+	for _, child := range node.Children {
+		text += child.Tail
+	}
+	return text
+}
+
+// appendText adds text at the end of the content of the node, after its last child
+func (node *Node) appendText(text string) {
+	if len(node.Children) > 0 {
+		node.Children[len(node.Children)-1].Tail += text
+		return
+	}
+	node.Text += text
+}
+
+// setText replaces the text directly inside the node, which then comes before its children
+func (node *Node) setText(text string) {
+	node.Text = text
+	for _, child := range node.Children {
+		child.Tail = ""
+	}
+}
+
+// removeChild removes the child at index, the text after it stays in place
+func (node *Node) removeChild(index int) {
+	if index > 0 {
+		node.Children[index-1].Tail += node.Children[index].Tail
+	} else {
+		node.Text += node.Children[index].Tail
+	}
+	node.Children = append(node.Children[:index], node.Children[index+1:]...)
+}
+
+// String writes the node and its descendants as XML, attributes sorted by name
+// Text between children is written in place, so mixed content like `The <b>quick</b> fox` round-trips.
+func (node *Node) String() string {
+	var result strings.Builder
+	node.write(&result)
+	return result.String()
+}
+
+func (node *Node) write(out *strings.Builder) {
+	node.writeStartTag(out)
+	node.writeContent(out, node.Text, 0)
+	for i, child := range node.Children {
+		child.write(out)
+		node.writeContent(out, child.Tail, i+1)
+	}
+	out.WriteString("</" + node.Name + ">")
+}
+
+// writeContent writes the text of the node before the child at index, or after the last child, with the comments there
+func (node *Node) writeContent(out *strings.Builder, text string, index int) {
+	node.walkContent(text, index, func(text string) {
+		out.WriteString(xmlEscaper.Replace(text))
+	}, func(comment string) {
+		out.WriteString(COMMENT_START + comment + COMMENT_END)
+	})
+}
+
+// walkContent passes the text of the node before the child at index, or after the last child, to onText in pieces
+// split by the comments there, which are passed to onComment in between
+// Comments whose place was changed by an edit of the tree are placed at the nearest place left.
+func (node *Node) walkContent(text string, index int, onText func(string), onComment func(string)) {
+	written := 0
+	for _, comment := range node.Comments {
+		if comment.Before != index && (index < len(node.Children) || comment.Before < index) {
+			continue
+		}
+		offset := min(max(comment.Offset, written), len(text))
+		for offset < len(text) && !utf8.RuneStart(text[offset]) {
+			offset++
+		}
+		onText(text[written:offset])
+		onComment(comment.Text)
+		written = offset
+	}
+	onText(text[written:])
+}
+
+// writeStartTag writes the start tag of the node, attributes sorted by name
+func (node *Node) writeStartTag(out *strings.Builder) {
+	names := make([]string, 0, len(node.Attrs))
+	for name := range node.Attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out.WriteString("<" + node.Name)
+	for _, name := range names {
+		out.WriteString(" " + name + `="` + xmlAttrEscaper.Replace(node.Attrs[name]) + `"`)
+	}
+	out.WriteString(">")
+}
+
+// FlatElement is an element of the flat form of an element tree
+type FlatElement struct {
+	Path  string            // Path locates the element, like /order/item[2]/price[1]
+	Attrs map[string]string `json:",omitempty"`
+	Text  string            `json:",omitempty"`
+	Tail  string            `json:",omitempty"`
+}
+
+// flatten lists the node and its descendants in document order with their path
+func (node *Node) flatten() []FlatElement {
+	var elements []FlatElement
+	var walk func(node *Node, path string)
+	walk = func(node *Node, path string) {
+		elements = append(elements, FlatElement{Path: path, Attrs: node.Attrs, Text: node.Text, Tail: node.Tail})
+		counts := map[string]int{}
+		for _, child := range node.Children {
+			counts[child.Name]++
+			walk(child, fmt.Sprintf("%s/%s[%d]", path, child.Name, counts[child.Name]))
+		}
+	}
+	walk(node, "/"+node.Name)
+	return elements
+}
+
+// elementTree returns the element tree of the document, nil if it has no XML
+// Documents stored before element trees were kept get theirs from the stored XML.
+func (doc *XMLDoc) elementTree() (*Node, error) {
+	if doc.Tree != nil || len(doc.XMLData) == 0 || doc.XMLData[0] == "" {
+		return doc.Tree, nil
+	}
+	return ParseTree(strings.NewReader(doc.XMLData[0]))
+}
+
+// setParents links the children of node and their descendants to their parents, which JSON doesn't hold
+func (node *Node) setParents() {
+	for _, child := range node.Children {
+		child.Parent = node
+		child.setParents()
+	}
+}
+
+// storedTree is an element tree as the tree column holds it
+// LeadText tells the Text of its nodes ends at their first child. Trees stored without it held the tails
+// of the children in Text too.
+type storedTree struct {
+	*Node
+	LeadText bool `json:",omitempty"`
+}
+
+// encodeTree encodes an element tree for the tree column
+func encodeTree(root *Node) (string, error) {
+	if root == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(storedTree{Node: root, LeadText: true})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeTree decodes the content of the tree column
+func decodeTree(data string) (*Node, error) {
+	if data == "" {
+		return nil, nil
+	}
+	tree := storedTree{Node: &Node{}}
+	err := json.Unmarshal([]byte(data), &tree)
+	if err != nil {
+		return nil, err
+	}
+	tree.setParents()
+	if !tree.LeadText {
+		tree.splitJoinedText()
+	}
+	return tree.Node, nil
+}
+
+// splitJoinedText removes the tails of the children from the Text of the node and its descendants, which held
+// them too in trees stored without LeadText
+// Trees stored before tails were kept have none, their whole text stays before the children.
+func (node *Node) splitJoinedText() {
+	tails := ""
+	for _, child := range node.Children {
+		tails += child.Tail
+		child.splitJoinedText()
+	}
+	node.Text = strings.TrimSuffix(node.Text, tails)
+}
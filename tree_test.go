@@ -0,0 +1,185 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test building element trees
+func TestParseTree(t *testing.T) {
+	root, err := ParseTree(strings.NewReader(`<document id="1">intro<item lang="en">a &amp; b</item><item/>outro</document>`))
+	require.NoError(t, err)
+
+	require.Equal(t, "document", root.Name)
+	require.Equal(t, map[string]string{"id": "1"}, root.Attrs)
+	require.Equal(t, "intro", root.Text)
+	require.Nil(t, root.Parent)
+	require.Len(t, root.Children, 2)
+	require.Equal(t, &Node{Name: "item", Attrs: map[string]string{"lang": "en"}, Text: "a & b", Parent: root}, root.Children[0])
+	require.Equal(t, &Node{Name: "item", Tail: "outro", Parent: root}, root.Children[1])
+
+	tests := []struct {
+		desc string
+		data string
+		err  string
+	}{
+		{desc: "empty", data: "", err: "no data for parsing"},
+		{desc: "two roots", data: "<a/><b/>", err: "more than one root element"},
+		{desc: "malformed", data: "<a><b></a>", err: "unmatched closing tag error: <b> </a> at line 1, column 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := ParseTree(strings.NewReader(tt.data))
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+// Test that text between child elements keeps its place
+func TestMixedContent(t *testing.T) {
+	data := `<p>The <b>quick</b> brown <i>fox</i> jumps</p>`
+	root, err := ParseTree(strings.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, "The ", root.Text)
+	require.Equal(t, " brown ", root.Children[0].Tail)
+	require.Equal(t, data, root.String())
+	require.Equal(t, "The quick brown fox jumps", root.TextContent())
+
+	// Removing an element keeps the text around it
+	root.removeChild(0)
+	require.Equal(t, `<p>The  brown <i>fox</i> jumps</p>`, root.String())
+	root.appendText("!")
+	require.Equal(t, `<p>The  brown <i>fox</i> jumps!</p>`, root.String())
+	root.setText("Only ")
+	require.Equal(t, `<p>Only <i>fox</i></p>`, root.String())
+
+	// Trees stored before tails were kept write their text before the children
+	legacy := &Node{Name: "p", Text: "The  fox", Children: []*Node{{Name: "b", Text: "quick"}}}
+	require.Equal(t, `<p>The  fox<b>quick</b></p>`, legacy.String())
+	require.Equal(t, "The  foxquick", legacy.TextContent())
+}
+
+// Test that stored trees are decoded with their parent links
+func TestEncodeDecodeTree(t *testing.T) {
+	root, err := ParseTree(strings.NewReader(`<document><item><name>x</name></item></document>`))
+	require.NoError(t, err)
+
+	data, err := encodeTree(root)
+	require.NoError(t, err)
+	require.Equal(t, `{"Name":"document","Children":[{"Name":"item","Children":[{"Name":"name","Text":"x"}]}],"LeadText":true}`, data)
+
+	decoded, err := decodeTree(data)
+	require.NoError(t, err)
+	require.Equal(t, root, decoded)
+	require.Same(t, decoded.Children[0], decoded.Children[0].Children[0].Parent)
+
+	// Older trees held the text after the children in Text too
+	decoded, err = decodeTree(`{"Name":"p","Children":[{"Name":"b","Text":"quick","Tail":" fox"}],"Text":"The  fox"}`)
+	require.NoError(t, err)
+	require.Equal(t, "The ", decoded.Text)
+	require.Equal(t, "<p>The <b>quick</b> fox</p>", decoded.String())
+
+	data, err = encodeTree(nil)
+	require.NoError(t, err)
+	require.Empty(t, data)
+	decoded, err = decodeTree("")
+	require.NoError(t, err)
+	require.Nil(t, decoded)
+}
+
+// Test that /document serves the element tree and /list leaves it out
+func TestDocumentTreeResponse(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument(`<document><title>Test Title</title><item id="1">x</item></document>`)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	req := httptest.NewRequest("GET", "/document?id=1", nil)
+	w := httptest.NewRecorder()
+	handleDocumentRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+	var response struct{ Tree *Node }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, &Node{Name: "document", Children: []*Node{
+		{Name: "title", Text: "Test Title"},
+		{Name: "item", Attrs: map[string]string{"id": "1"}, Text: "x"},
+	}}, response.Tree)
+
+	req = httptest.NewRequest("GET", "/list", nil)
+	w = httptest.NewRecorder()
+	handleListRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.NotContains(t, w.Body.String(), `"Tree"`)
+}
+
+// Test writing element trees back as XML
+func TestNodeString(t *testing.T) {
+	root, err := ParseTree(strings.NewReader(`<document z="1" a="&quot;q&quot;"><title>Fish &amp; Chips</title><note><![CDATA[<b>]]></note><empty/></document>`))
+	require.NoError(t, err)
+	require.Equal(t, `<document a="&quot;q&quot;" z="1"><title>Fish &amp; Chips</title><note>&lt;b&gt;</note><empty></empty></document>`, root.String())
+
+	again, err := ParseTree(strings.NewReader(root.String()))
+	require.NoError(t, err)
+	require.Equal(t, root, again)
+}
+
+// Test keeping comments in the tree and writing them back in place
+func TestParseTreeComments(t *testing.T) {
+	data := `<p>The <!-- if x > 3 --><b>quick</b> brown <!--<i>-->fox<!-- end --></p>`
+	root, err := ParseTree(strings.NewReader(data))
+	require.NoError(t, err)
+	require.Empty(t, root.Comments)
+	require.Equal(t, `<p>The <b>quick</b> brown fox</p>`, root.String())
+
+	root, err = parseTree(strings.NewReader(data), true)
+	require.NoError(t, err)
+	require.Equal(t, []Comment{{Text: " if x > 3 ", Before: 0, Offset: 4}, {Text: "<i>", Before: 1, Offset: 7}, {Text: " end ", Before: 1, Offset: 10}}, root.Comments)
+	require.Len(t, root.Children, 1)
+	require.Equal(t, "The quick brown fox", root.TextContent())
+	require.Equal(t, data, root.String())
+	require.True(t, root.hasComments())
+
+	// Comments whose text was replaced are kept at the nearest place
+	root.setText("日本")
+	require.Equal(t, `<p>日本<!-- if x > 3 --><b>quick</b><!--<i>--><!-- end --></p>`, root.String())
+	root.removeChild(0)
+	require.Equal(t, `<p>日本<!-- if x > 3 --><!--<i>--><!-- end --></p>`, root.String())
+}
+
+// Test that documents added with comments keep them through a patch
+func TestAddDocumentComments(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	body := `<document><!-- draft > final --><title>Title<!-- not > this --></title><item>x</item></document>`
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("POST", "/add?comments=true", strings.NewReader(body)))
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Title", doc.Title)
+	require.Equal(t, []Comment{{Text: " draft > final ", Before: 0}}, doc.Tree.Comments)
+	require.Equal(t, []Comment{{Text: " not > this ", Before: 0, Offset: 5}}, doc.Tree.Children[0].Comments)
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("PATCH", "/document?id=1", strings.NewReader(`<diff><replace sel="/document/item/text()">y</replace></diff>`))
+	req.Header.Set("Content-Type", XML_PATCH_CONTENT_TYPE)
+	handleRequest(db, rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	doc, err = getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, `<document><!-- draft > final --><title>Title<!-- not > this --></title><item>y</item></document>`, doc.Tree.String())
+
+	rr = httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("POST", "/add?comments=maybe", strings.NewReader(body)))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,314 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	VALIDATION_SCHEMAS_ENV = "DOC_VALIDATION_SCHEMAS" // Environment variable with the path of a JSON file listing the validation schemas
+
+	VALIDATION_PASSED      = "passed"      // Validation status of documents without violations
+	VALIDATION_FAILED      = "failed"      // Validation status of documents with violations
+	VALIDATION_UNVALIDATED = "unvalidated" // Filter of /list for documents no schema applies to
+
+	REVALIDATE_PATH_PREFIX = "/document/"  // Path prefix of the revalidation endpoint, followed by the document ID
+	REVALIDATE_PATH_SUFFIX = "/revalidate" // Path suffix of the revalidation endpoint
+)
+
+// ValidationSchema describes the elements documents with a root element must have
+type ValidationSchema struct {
+	Name     string
+	Root     string            // Root is the name of the root elements the schema applies to, empty for all documents
+	Required []string          // Required are paths which must select a non-empty value
+	Patterns map[string]string // Patterns maps paths to regular expressions all values they select must match
+
+	patterns map[string]*regexp.Regexp // patterns holds the compiled Patterns
+}
+
+// ValidationResult is the outcome of validating a document against its schema
+type ValidationResult struct {
+	Schema      string   `json:",omitempty"`
+	Status      string   `json:",omitempty"` // Status is passed or failed, empty if no schema applies to the document
+	Violations  []string `json:",omitempty"`
+	ValidatedAt string   `json:",omitempty"`
+}
+
+// validationSchemas holds the schemas documents are validated against, set by initValidationSchemas
+var validationSchemas []ValidationSchema
+
+// initValidationSchemas loads the validation schemas from the file named by the environment
+func initValidationSchemas() {
+	funcName := "initValidationSchemas"
+
+	path := os.Getenv(VALIDATION_SCHEMAS_ENV)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s: %v", funcName, VALIDATION_SCHEMAS_ENV, err)
+	}
+	schemas, err := parseValidationSchemas(data)
+	if err != nil {
+		log.Fatalf("%s: Invalid schemas in %s: %v", funcName, path, err)
+	}
+	validationSchemas = schemas
+}
+
+// parseValidationSchemas parses a JSON list of schemas and checks their paths and patterns
+func parseValidationSchemas(data []byte) ([]ValidationSchema, error) {
+	var schemas []ValidationSchema
+	if err := json.Unmarshal(data, &schemas); err != nil {
+		return nil, err
+	}
+	if err := compileValidationSchemas(schemas); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// compileValidationSchemas checks the names, paths and patterns of schemas and compiles their patterns
+func compileValidationSchemas(schemas []ValidationSchema) error {
+	names := map[string]bool{}
+	for i := range schemas {
+		schema := &schemas[i]
+		if schema.Name == "" || names[schema.Name] {
+			return fmt.Errorf("schema %d needs a unique name", i+1)
+		}
+		names[schema.Name] = true
+
+		for _, path := range schema.Required {
+			if _, err := parseXPath(path); err != nil {
+				return fmt.Errorf("schema %s: %v", schema.Name, err)
+			}
+		}
+		schema.patterns = map[string]*regexp.Regexp{}
+		for path, pattern := range schema.Patterns {
+			if _, err := parseXPath(path); err != nil {
+				return fmt.Errorf("schema %s: %v", schema.Name, err)
+			}
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("schema %s: invalid pattern for %s: %v", schema.Name, path, err)
+			}
+			schema.patterns[path] = compiled
+		}
+	}
+	return nil
+}
+
+// schemaFor returns the schema for documents with the root element, or nil
+// A schema for the root element wins over one for all documents.
+func schemaFor(root string) *ValidationSchema {
+	return schemaIn(validationSchemas, root)
+}
+
+// schemaIn returns the schema of the list for documents with the root element like schemaFor, or nil
+func schemaIn(schemas []ValidationSchema, root string) *ValidationSchema {
+	var fallback *ValidationSchema
+	for i, schema := range schemas {
+		if schema.Root == root {
+			return &schemas[i]
+		}
+		if schema.Root == "" && fallback == nil {
+			fallback = &schemas[i]
+		}
+	}
+	return fallback
+}
+
+// Validate returns the violations of the schema in the document
+func (schema *ValidationSchema) Validate(doc *XMLDoc) []string {
+	var violations []string
+	for _, path := range schema.Required {
+		values, _ := doc.Query(path)
+		if strings.Join(values, "") == "" {
+			violations = append(violations, fmt.Sprintf("%s is missing", path))
+		}
+	}
+	for _, path := range sortedKeys(schema.Patterns) {
+		values, _ := doc.Query(path)
+		for _, value := range values {
+			if !schema.patterns[path].MatchString(value) {
+				violations = append(violations, fmt.Sprintf("%s value %q doesn't match %s", path, value, schema.Patterns[path]))
+			}
+		}
+	}
+	return violations
+}
+
+// sortedKeys returns the keys of the map in order, so violations are reported in a stable order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateDocument validates the document against the schema for its root element and sets its result
+// Documents no schema applies to get an empty result.
+func validateDocument(doc *XMLDoc, now time.Time) {
+	validateDocumentWith(doc, nil, now)
+}
+
+// validateDocumentWith validates the document like validateDocument, preferring the schemas of its tenant
+func validateDocumentWith(doc *XMLDoc, tenantSchemas []ValidationSchema, now time.Time) {
+	doc.Validation = ValidationResult{}
+	if doc.Tree == nil {
+		return
+	}
+	schema := schemaIn(tenantSchemas, doc.Tree.Name)
+	if schema == nil {
+		schema = schemaFor(doc.Tree.Name)
+	}
+	if schema == nil {
+		return
+	}
+
+	doc.Validation = ValidationResult{
+		Schema:      schema.Name,
+		Status:      VALIDATION_PASSED,
+		Violations:  schema.Validate(doc),
+		ValidatedAt: formatExpiry(now),
+	}
+	if len(doc.Validation.Violations) > 0 {
+		doc.Validation.Status = VALIDATION_FAILED
+		metrics.inc("validation_failures_total", "schema", schema.Name)
+	}
+}
+
+// encodeViolations encodes violations for the validation_violations column
+func encodeViolations(violations []string) (string, error) {
+	if len(violations) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(violations)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeViolations decodes the content of the validation_violations column
+func decodeViolations(data string) ([]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var violations []string
+	err := json.Unmarshal([]byte(data), &violations)
+	if err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
+
+// sameValidation reports whether two validation results agree, regardless of when they were made
+func sameValidation(a ValidationResult, b ValidationResult) bool {
+	return a.Schema == b.Schema && a.Status == b.Status && strings.Join(a.Violations, "\n") == strings.Join(b.Violations, "\n")
+}
+
+// updateValidation stores the validation result of a document
+func updateValidation(db *sql.DB, id string, result ValidationResult) error {
+	defer observeQuery("updateValidation", time.Now())
+
+	violations, err := encodeViolations(result.Violations)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`
+		UPDATE %s SET %s=?, %s=?, %s=?, %s=? WHERE %s=?
+	`, DB_TABLE_NAME, DB_VALIDATIONSCHEMA_FIELD_NAME, DB_VALIDATIONSTATUS_FIELD_NAME, DB_VALIDATIONVIOLATIONS_FIELD_NAME, DB_VALIDATEDAT_FIELD_NAME, DB_ID_FIELD_NAME)
+	return withDBRetry(func() error {
+		res, err := db.Exec(query, result.Schema, result.Status, violations, result.ValidatedAt, id)
+		if err != nil {
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// parseValidationFilter turns the validation parameter of /list into a WHERE condition, empty for all documents
+func parseValidationFilter(param string) (string, error) {
+	switch param {
+	case "":
+		return "", nil
+	case VALIDATION_PASSED, VALIDATION_FAILED:
+		return fmt.Sprintf("%s='%s'", DB_VALIDATIONSTATUS_FIELD_NAME, param), nil
+	case VALIDATION_UNVALIDATED:
+		return fmt.Sprintf("(%s IS NULL OR %s='')", DB_VALIDATIONSTATUS_FIELD_NAME, DB_VALIDATIONSTATUS_FIELD_NAME), nil
+	}
+	return "", fmt.Errorf("invalid validation %s", param)
+}
+
+// revalidateDocumentID returns the document ID of a revalidation path like "/document/1/revalidate"
+func revalidateDocumentID(path string) (string, bool) {
+	if !strings.HasPrefix(path, REVALIDATE_PATH_PREFIX) || !strings.HasSuffix(path, REVALIDATE_PATH_SUFFIX) {
+		return "", false
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(path, REVALIDATE_PATH_PREFIX), REVALIDATE_PATH_SUFFIX)
+	if id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+// handleRevalidateRequest validates a document against the current schemas again and stores the result
+func handleRevalidateRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+
+	doc, err := getDocumentByID(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+
+	doc.Tree, err = doc.elementTree()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse document: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	validateDocument(doc, time.Now())
+	err = updateValidation(db, id, doc.Validation)
+	if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to store validation of document with ID %s: %v", id, err), err)
+		return
+	}
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(doc.Validation)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,185 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testValidationSchemas = `[
+	{"Name": "article", "Root": "article", "Required": ["//title", "//author"], "Patterns": {"//isbn": "^[0-9-]+$"}},
+	{"Name": "any", "Required": ["//title"]}
+]`
+
+// Test parsing and checking validation schemas
+func TestParseValidationSchemas(t *testing.T) {
+	schemas, err := parseValidationSchemas([]byte(testValidationSchemas))
+	require.NoError(t, err)
+	require.Len(t, schemas, 2)
+	require.Equal(t, "article", schemas[0].Root)
+	require.True(t, schemas[0].patterns["//isbn"].MatchString("978-3"))
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{desc: "missing name", data: `[{"Root": "article"}]`, expected: "schema 1 needs a unique name"},
+		{desc: "duplicate name", data: `[{"Name": "a"}, {"Name": "a"}]`, expected: "schema 2 needs a unique name"},
+		{desc: "invalid path", data: `[{"Name": "a", "Required": ["title"]}]`, expected: "schema a: invalid path title: only absolute paths are supported"},
+		{desc: "invalid pattern", data: `[{"Name": "a", "Patterns": {"//isbn": "("}}]`, expected: "schema a: invalid pattern for //isbn: error parsing regexp: missing closing ): `(`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseValidationSchemas([]byte(tt.data))
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+// Test validating documents against the schema for their root element
+func TestValidateDocument(t *testing.T) {
+	schemas, err := parseValidationSchemas([]byte(testValidationSchemas))
+	require.NoError(t, err)
+	defer func(previous []ValidationSchema) { validationSchemas = previous }(validationSchemas)
+	validationSchemas = schemas
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc     string
+		data     string
+		expected ValidationResult
+	}{
+		{
+			desc:     "passed",
+			data:     "<article><title>T</title><author>A</author><isbn>978-3</isbn></article>",
+			expected: ValidationResult{Schema: "article", Status: VALIDATION_PASSED, ValidatedAt: formatExpiry(now)},
+		},
+		{
+			desc: "failed",
+			data: "<article><title></title><isbn>978 3</isbn></article>",
+			expected: ValidationResult{Schema: "article", Status: VALIDATION_FAILED, ValidatedAt: formatExpiry(now), Violations: []string{
+				"//title is missing",
+				"//author is missing",
+				`//isbn value "978 3" doesn't match ^[0-9-]+$`,
+			}},
+		},
+		{
+			desc:     "fallback schema",
+			data:     "<document><title>T</title></document>",
+			expected: ValidationResult{Schema: "any", Status: VALIDATION_PASSED, ValidatedAt: formatExpiry(now)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			doc, err := parseDocument(tt.data)
+			require.NoError(t, err)
+			validateDocument(doc, now)
+			require.Equal(t, tt.expected, doc.Validation)
+		})
+	}
+
+	// Without a schema for the document it isn't validated
+	validationSchemas = schemas[:1]
+	doc, err := parseDocument("<document><title>T</title></document>")
+	require.NoError(t, err)
+	validateDocument(doc, now)
+	require.Equal(t, ValidationResult{}, doc.Validation)
+}
+
+// Test storing validation results, filtering /list by them and revalidating documents
+func TestRevalidateRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	schemas, err := parseValidationSchemas([]byte(testValidationSchemas))
+	require.NoError(t, err)
+	defer func(previous []ValidationSchema) { validationSchemas = previous }(validationSchemas)
+	validationSchemas = schemas
+
+	for _, data := range []string{
+		"<article><title>T</title><author>A</author></article>",
+		"<article><title>T</title></article>",
+	} {
+		doc, err := parseDocumentFrom(data, "upload")
+		require.NoError(t, err)
+		require.NoError(t, insertDocument(db, *doc))
+	}
+
+	stored, err := getDocumentByID(db, "2")
+	require.NoError(t, err)
+	require.Equal(t, VALIDATION_FAILED, stored.Validation.Status)
+	require.Equal(t, []string{"//author is missing"}, stored.Validation.Violations)
+
+	list := func(query string) []string {
+		req := httptest.NewRequest("GET", "/list?"+query, nil)
+		w := httptest.NewRecorder()
+		handleListRequest(db, w, req)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+		var docs []XMLDoc
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &docs))
+		ids := []string{}
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []string{"2"}, list("validation=failed"))
+	require.Equal(t, []string{"1"}, list("validation=passed"))
+	require.Equal(t, []string{}, list("validation=unvalidated"))
+
+	req := httptest.NewRequest("GET", "/list?validation=broken", nil)
+	w := httptest.NewRecorder()
+	handleListRequest(db, w, req)
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	// After the schema is relaxed the document passes when revalidated
+	validationSchemas[0].Required = []string{"//title"}
+	access, handler := routeRequest(httptest.NewRequest("POST", "/document/2/revalidate", nil))
+	require.Equal(t, ACCESS_WRITE, access)
+	require.NotNil(t, handler)
+
+	req = httptest.NewRequest("POST", "/document/2/revalidate?id=2", nil)
+	w = httptest.NewRecorder()
+	handleRevalidateRequest(db, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var result ValidationResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.Equal(t, VALIDATION_PASSED, result.Status)
+	require.Equal(t, []string{"1", "2"}, list("validation=passed"))
+
+	req = httptest.NewRequest("POST", "/document/99/revalidate?id=99", nil)
+	w = httptest.NewRecorder()
+	handleRevalidateRequest(db, w, req)
+	require.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+
+	req = httptest.NewRequest("GET", "/document/2/revalidate?id=2", nil)
+	w = httptest.NewRecorder()
+	handleRevalidateRequest(db, w, req)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Result().StatusCode)
+}
+
+// Test the document ID of revalidation paths
+func TestRevalidateDocumentID(t *testing.T) {
+	tests := []struct {
+		path string
+		id   string
+		ok   bool
+	}{
+		{path: "/document/12/revalidate", id: "12", ok: true},
+		{path: "/document//revalidate"},
+		{path: "/document/1/2/revalidate"},
+		{path: "/document/12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			id, ok := revalidateDocumentID(tt.path)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.id, id)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package goapp
+
+import (
+	"strings"
+)
+
+const (
+	WHITESPACE_STRIP    = "strip"    // Mode removing tabs, runs of four spaces and line breaks everywhere, the default
+	WHITESPACE_PRESERVE = "preserve" // Mode keeping whitespace as it was sent
+	WHITESPACE_TRIM     = "trim"     // Mode trimming the text of elements and dropping whitespace between elements
+	WHITESPACE_COLLAPSE = "collapse" // Mode like trim which also replaces runs of whitespace in text by a single space
+
+	XML_SPACE_ATTRIBUTE = "xml:space" // Attribute whose value "preserve" keeps the whitespace of an element and its descendants
+	XML_WHITESPACE      = " \t\r\n"   // Characters XML treats as whitespace, other spaces like U+00A0 are content
+)
+
+// isValidWhitespaceMode reports whether mode is one of the WHITESPACE_* constants
+func isValidWhitespaceMode(mode string) bool {
+	switch mode {
+	case WHITESPACE_STRIP, WHITESPACE_PRESERVE, WHITESPACE_TRIM, WHITESPACE_COLLAPSE:
+		return true
+	}
+	return false
+}
+
+// xmlSpacePreserve reports whether the element opened by the start tag keeps its whitespace
+// xml:space="preserve" turns it on and xml:space="default" off, otherwise the element inherits it from its parent.
+func xmlSpacePreserve(tag string, inherited bool) bool {
+	open := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(tag, "<"), ">"), "/")
+	i := strings.IndexAny(open, XML_WHITESPACE)
+	// Most tags have no xml:space, their attributes aren't parsed
+	if i < 0 || !strings.Contains(open[i+1:], XML_SPACE_ATTRIBUTE) {
+		return inherited
+	}
+	switch value, _ := attributeValue(open[i+1:], XML_SPACE_ATTRIBUTE); value {
+	case "preserve":
+		return true
+	case "default":
+		return false
+	}
+	return inherited
+}
+
+// stripWhitespace removes whitespace the way the parser always has
+func stripWhitespace(str string) string {
+	str = strings.ReplaceAll(str, "\t", "")
+	str = strings.ReplaceAll(str, "    ", "")
+	str = strings.ReplaceAll(str, "\n", "")
+	return strings.ReplaceAll(str, "\r", "")
+}
+
+// normalizeWhitespace applies the whitespace mode to an element string as extracted by parseXML
+// preserve tells whether the parent of the element keeps its whitespace. Text inside elements
+// with xml:space="preserve" is kept whatever the mode, and so are CDATA sections unless the mode is strip.
+func normalizeWhitespace(str string, mode string, preserve bool) string {
+	if mode == "" {
+		mode = WHITESPACE_STRIP
+	}
+	if mode == WHITESPACE_PRESERVE {
+		return str
+	}
+
+	var result strings.Builder
+	result.Grow(len(str))
+	stack := []bool{preserve}
+	afterStart := false // afterStart is true when the last markup was a start tag
+	for i := 0; i < len(str); {
+		keep := stack[len(stack)-1]
+
+		if str[i] != '<' {
+			end := strings.IndexByte(str[i:], '<')
+			if end < 0 {
+				end = len(str)
+			} else {
+				end += i
+			}
+			text := str[i:end]
+			beforeEnd := strings.HasPrefix(str[end:], "</")
+			i = end
+
+			switch {
+			case keep:
+			case mode == WHITESPACE_STRIP:
+				text = stripWhitespace(text)
+			case strings.Trim(text, XML_WHITESPACE) == "":
+				text = ""
+			default:
+				text = trimText(text, mode, afterStart, beforeEnd)
+			}
+			result.WriteString(text)
+			continue
+		}
+
+		// CDATA sections, comments and processing instructions may hold '<' and '>'
+		if end := sectionEnd(str, i); end > 0 {
+			section := str[i:end]
+			if !keep && mode == WHITESPACE_STRIP {
+				section = stripWhitespace(section)
+			}
+			result.WriteString(section)
+			afterStart = false
+			i = end
+			continue
+		}
+
+		end := tagEnd(str, i, ">")
+		if end < 0 {
+			end = len(str)
+		} else {
+			end++
+		}
+		tag := str[i:end]
+		i = end
+
+		afterStart = false
+		if strings.HasPrefix(tag, "</") {
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		} else if !strings.HasSuffix(tag, "/>") {
+			stack = append(stack, xmlSpacePreserve(tag, keep))
+			afterStart = true
+		}
+		if !keep && mode == WHITESPACE_STRIP {
+			tag = stripWhitespace(tag)
+		}
+		result.WriteString(tag)
+	}
+	return result.String()
+}
+
+// trimText trims text which isn't only whitespace for the trim and collapse modes
+// Text is trimmed at the start of an element and at its end, so the spaces of mixed content like
+// `<p>Hello <b>world</b> again</p>` are kept.
+func trimText(text string, mode string, afterStart bool, beforeEnd bool) string {
+	if mode == WHITESPACE_COLLAPSE {
+		leading := strings.TrimLeft(text, XML_WHITESPACE) != text
+		trailing := strings.TrimRight(text, XML_WHITESPACE) != text
+		text = strings.Join(strings.FieldsFunc(text, func(r rune) bool { return strings.ContainsRune(XML_WHITESPACE, r) }), " ")
+		if leading && !afterStart {
+			text = " " + text
+		}
+		if trailing && !beforeEnd {
+			text += " "
+		}
+		return text
+	}
+
+	if afterStart {
+		text = strings.TrimLeft(text, XML_WHITESPACE)
+	}
+	if beforeEnd {
+		text = strings.TrimRight(text, XML_WHITESPACE)
+	}
+	return text
+}
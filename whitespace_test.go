@@ -0,0 +1,95 @@
+package goapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test the whitespace modes on element strings
+func TestNormalizeWhitespace(t *testing.T) {
+	element := "<doc>\n    <title>  Hello   world </title>\n    <p>Mixed <b>bold</b> text</p>\n</doc>"
+
+	tests := []struct {
+		desc     string
+		data     string
+		mode     string
+		preserve bool
+		expected string
+	}{
+		{desc: "default strips", data: element, expected: "<doc><title>  Hello   world </title><p>Mixed <b>bold</b> text</p></doc>"},
+		{desc: "preserve", data: element, mode: WHITESPACE_PRESERVE, expected: element},
+		{desc: "trim", data: element, mode: WHITESPACE_TRIM, expected: "<doc><title>Hello   world</title><p>Mixed <b>bold</b> text</p></doc>"},
+		{desc: "collapse", data: element, mode: WHITESPACE_COLLAPSE, expected: "<doc><title>Hello world</title><p>Mixed <b>bold</b> text</p></doc>"},
+		{
+			desc:     "xml:space",
+			data:     "<doc>\n  <pre xml:space=\"preserve\">\n\tline 1\n    line 2\n  <i> x </i></pre>\n  <code> y </code>\n</doc>",
+			mode:     WHITESPACE_TRIM,
+			expected: "<doc><pre xml:space=\"preserve\">\n\tline 1\n    line 2\n  <i> x </i></pre><code>y</code></doc>",
+		},
+		{
+			desc:     "xml:space in strip mode",
+			data:     "<doc>\n  <pre xml:space=\"preserve\">\n\tline</pre>\n</doc>",
+			expected: "<doc>  <pre xml:space=\"preserve\">\n\tline</pre></doc>",
+		},
+		{
+			desc:     "xml:space default",
+			data:     "<pre xml:space=\"preserve\"> a <p xml:space=\"default\"> b </p></pre>",
+			mode:     WHITESPACE_TRIM,
+			expected: "<pre xml:space=\"preserve\"> a <p xml:space=\"default\">b</p></pre>",
+		},
+		{desc: "inherited", data: "<i> x </i>", mode: WHITESPACE_COLLAPSE, preserve: true, expected: "<i> x </i>"},
+		{desc: "CDATA", data: "<code><![CDATA[\n  if a < b\n]]></code>", mode: WHITESPACE_COLLAPSE, expected: "<code><![CDATA[\n  if a < b\n]]></code>"},
+		{desc: "non-breaking space", data: "<p> </p>", mode: WHITESPACE_TRIM, expected: "<p> </p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.expected, normalizeWhitespace(tt.data, tt.mode, tt.preserve))
+		})
+	}
+}
+
+// Test that nested elements inherit xml:space from their ancestors
+func TestParseDocumentWhitespace(t *testing.T) {
+	data := "<doc>\n  <title>  Report  </title>\n  <body xml:space=\"preserve\">\n    <description>  Indented\n    text</description>\n  </body>\n</doc>"
+
+	doc, err := parseDocumentWithOptions(data, ParseOptions{Whitespace: WHITESPACE_COLLAPSE})
+	require.NoError(t, err)
+	require.Equal(t, "Report", doc.Title)
+	require.Equal(t, "  Indented\n    text", doc.Description)
+	require.Equal(t, "<doc><title>Report</title><body xml:space=\"preserve\">\n    <description>  Indented\n    text</description>\n  </body></doc>", doc.XMLData[0])
+
+	// The default mode keeps stripping outside of xml:space="preserve"
+	doc, err = parseDocument(data)
+	require.NoError(t, err)
+	require.Equal(t, "  Report  ", doc.Title)
+	require.Equal(t, "  Indented\n    text", doc.Description)
+}
+
+// Test choosing the whitespace mode with /add
+func TestHandleAddRequestWhitespace(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add?whitespace=squash", strings.NewReader("<doc/>")))
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, httptest.NewRequest("POST", "/add?whitespace=preserve", strings.NewReader("<doc id=\"1\">\n\t<title>Poem</title>\n\t<description>Roses\n    are red</description>\n</doc>")))
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+
+	doc, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Roses\n    are red", doc.Description)
+
+	// Reprocessing keeps the stored whitespace
+	_, err = reprocessDocument(db, "1")
+	require.NoError(t, err)
+	doc, err = getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "Roses\n    are red", doc.Description)
+}
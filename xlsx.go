@@ -0,0 +1,133 @@
+package goapp
+
+import (
+	"archive/zip"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	XLSX_CONTENT_TYPE  = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" // Content type of XLSX workbooks
+	XLSX_MAX_CELL_TEXT = 32767                                                               // Longest text of a cell Excel accepts, in characters
+)
+
+// xlsxParts are the parts of a workbook with a single sheet besides the sheet itself
+var xlsxParts = []struct {
+	Name    string
+	Content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`},
+	// Style 1 is the bold font of the header row
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs></styleSheet>`},
+}
+
+// XLSXCell is a cell of a spreadsheet, a number if Number is set and text otherwise
+type XLSXCell struct {
+	Text   string
+	Number *int64
+}
+
+// writeXLSX writes a workbook with a sheet of the header row and the rows to out
+// Text cells are written inline, so the workbook needs no shared string table.
+func writeXLSX(out io.Writer, sheet string, header []string, rows [][]XLSXCell) error {
+	archive := zip.NewWriter(out)
+	for _, part := range xlsxParts {
+		file, err := archive.Create(part.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.Content); err != nil {
+			return err
+		}
+	}
+
+	file, err := archive.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(file, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`+
+		xmlAttrEscaper.Replace(xlsxText(sheet, 31))+`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err != nil {
+		return err
+	}
+
+	file, err = archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var data strings.Builder
+	data.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	headerCells := make([]XLSXCell, len(header))
+	for i, name := range header {
+		headerCells[i] = XLSXCell{Text: name}
+	}
+	writeXLSXRow(&data, 1, headerCells, ` s="1"`)
+	for i, row := range rows {
+		writeXLSXRow(&data, i+2, row, "")
+		// Large sheets are written in pieces
+		if data.Len() > 1<<16 {
+			if _, err := io.WriteString(file, data.String()); err != nil {
+				return err
+			}
+			data.Reset()
+		}
+	}
+	data.WriteString(`</sheetData></worksheet>`)
+	if _, err := io.WriteString(file, data.String()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeXLSXRow writes the row with the 1-based number, style being the style attribute of its cells
+func writeXLSXRow(out *strings.Builder, number int, cells []XLSXCell, style string) {
+	row := strconv.Itoa(number)
+	out.WriteString(`<row r="` + row + `">`)
+	for i, cell := range cells {
+		ref := xlsxColumn(i) + row
+		if cell.Number != nil {
+			out.WriteString(`<c r="` + ref + `"` + style + `><v>` + strconv.FormatInt(*cell.Number, 10) + `</v></c>`)
+			continue
+		}
+		if cell.Text == "" {
+			continue
+		}
+		out.WriteString(`<c r="` + ref + `"` + style + ` t="inlineStr"><is><t xml:space="preserve">`)
+		out.WriteString(xmlEscaper.Replace(xlsxText(cell.Text, XLSX_MAX_CELL_TEXT)))
+		out.WriteString(`</t></is></c>`)
+	}
+	out.WriteString(`</row>`)
+}
+
+// xlsxColumn returns the letters of the 0-based column index, like "A", "Z" or "AA"
+func xlsxColumn(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxText drops the control characters XML doesn't allow from text and cuts it to max characters
+func xlsxText(text string, max int) string {
+	text = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, text)
+	if utf8.RuneCountInString(text) > max {
+		text = string([]rune(text)[:max])
+	}
+	return text
+}
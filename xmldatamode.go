@@ -0,0 +1,89 @@
+package goapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	XMLDATA_MODE_PARAM = "xmldata" // Query parameter of /document and /list selecting the shape of XMLData
+
+	XMLDATA_MODE_FLAT = "flat" // XMLData is the string of each element, the default for clients written before the modes
+	XMLDATA_MODE_TREE = "tree" // XMLData is the nested element tree of the document
+	XMLDATA_MODE_NONE = "none" // XMLData is left out, with Paths
+
+	XMLDATA_ORDER_PARAM = "order" // Query parameter of /document and /list selecting the order of XMLData
+
+	XMLDATA_ORDER_DOCUMENT      = "document"      // Elements in the order of their start tags, the root element first
+	XMLDATA_ORDER_DEPTH_FIRST   = "depth-first"   // Elements in the order of their end tags, each after its children and the root element last
+	XMLDATA_ORDER_BREADTH_FIRST = "breadth-first" // Elements by depth, the same depth in document order; documents are stored in this order
+)
+
+// parseXMLDataOrder returns the XMLDATA_ORDER_* requested by r, empty for the stored order
+func parseXMLDataOrder(r *http.Request) (string, error) {
+	order := r.URL.Query().Get(XMLDATA_ORDER_PARAM)
+	switch order {
+	case "", XMLDATA_ORDER_DOCUMENT, XMLDATA_ORDER_DEPTH_FIRST, XMLDATA_ORDER_BREADTH_FIRST:
+		return order, nil
+	}
+	return "", fmt.Errorf("invalid %s %s, must be %s, %s or %s", XMLDATA_ORDER_PARAM, order, XMLDATA_ORDER_DOCUMENT, XMLDATA_ORDER_DEPTH_FIRST, XMLDATA_ORDER_BREADTH_FIRST)
+}
+
+// orderXMLData puts the XMLData of a stored document and its Paths in the XMLDATA_ORDER_* order
+// The stored XML is parsed again, so documents stored before the order of elements of the same depth was stable
+// get it too.
+func orderXMLData(doc *XMLDoc, order string) error {
+	if order == "" || len(doc.XMLData) == 0 || doc.XMLData[0] == "" {
+		return nil
+	}
+	xmlData, paths, err := parseXMLPaths(doc.rawXML(), doc.storedOptions().Whitespace, order)
+	if err != nil {
+		return err
+	}
+	doc.XMLData, doc.Paths = xmlData, paths
+	return nil
+}
+
+// parseXMLDataMode returns the XMLDATA_MODE_* requested by r, XMLDATA_MODE_FLAT if none is
+func parseXMLDataMode(r *http.Request) (string, error) {
+	mode := r.URL.Query().Get(XMLDATA_MODE_PARAM)
+	switch mode {
+	case "":
+		return XMLDATA_MODE_FLAT, nil
+	case XMLDATA_MODE_FLAT, XMLDATA_MODE_TREE, XMLDATA_MODE_NONE:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid %s mode %s, must be %s, %s or %s", XMLDATA_MODE_PARAM, mode, XMLDATA_MODE_FLAT, XMLDATA_MODE_TREE, XMLDATA_MODE_NONE)
+}
+
+// shapeXMLData gives the XMLData of doc the shape of mode for the response
+// Paths locate the element strings, so they are only kept with them.
+func shapeXMLData(doc *XMLDoc, mode string) error {
+	switch mode {
+	case XMLDATA_MODE_TREE:
+		tree, err := doc.elementTree()
+		if err != nil {
+			return err
+		}
+		doc.xmlDataTree = tree
+		doc.XMLData, doc.Paths = nil, nil
+	case XMLDATA_MODE_NONE:
+		doc.XMLData, doc.Paths = nil, nil
+	}
+	return nil
+}
+
+// MarshalJSON encodes the document with its XMLData in the shape given by shapeXMLData
+func (doc XMLDoc) MarshalJSON() ([]byte, error) {
+	// plain has the fields of XMLDoc without this method
+	type plain XMLDoc
+	if doc.xmlDataTree == nil {
+		return json.Marshal(plain(doc))
+	}
+	// The field of the outer struct wins over the XMLData of the document
+	return json.Marshal(struct {
+		plain
+		XMLData *Node
+	}{plain(doc), doc.xmlDataTree})
+}
@@ -0,0 +1,118 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test the shapes of XMLData selected by the xmldata parameter
+func TestXMLDataModes(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument(`<document><title>Shapes</title><body lang="en">Text</body></document>`)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	get := func(target string) map[string]json.RawMessage {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		var fields map[string]json.RawMessage
+		if rr.Body.Bytes()[0] == '[' {
+			var docs []map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &docs))
+			require.Len(t, docs, 1)
+			return docs[0]
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fields))
+		return fields
+	}
+
+	// Clients which don't ask get the strings as before
+	for _, target := range []string{"/document?id=1", "/document?id=1&xmldata=flat", "/list"} {
+		fields := get(target)
+		var xmlData []string
+		require.NoError(t, json.Unmarshal(fields["XMLData"], &xmlData), target)
+		require.Equal(t, doc.XMLData, xmlData)
+		require.Contains(t, fields, "Paths")
+	}
+
+	for _, target := range []string{"/document?id=1&xmldata=tree", "/list?xmldata=tree"} {
+		fields := get(target)
+		var tree Node
+		require.NoError(t, json.Unmarshal(fields["XMLData"], &tree), target)
+		require.Equal(t, "document", tree.Name)
+		require.Len(t, tree.Children, 2)
+		require.Equal(t, map[string]string{"lang": "en"}, tree.Children[1].Attrs)
+		require.NotContains(t, fields, "Paths")
+		require.Equal(t, `"Shapes"`, string(fields["Title"]))
+	}
+	require.NotContains(t, get("/list?xmldata=tree"), "Tree")
+
+	for _, target := range []string{"/document?id=1&xmldata=none", "/list?xmldata=none", "/list?view=summary&xmldata=tree"} {
+		fields := get(target)
+		require.NotContains(t, fields, "XMLData", target)
+		require.NotContains(t, fields, "Paths", target)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/document?id=1&xmldata=nested", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// Test the orders of XMLData
+func TestXMLDataOrders(t *testing.T) {
+	data := `<doc><b><c/></b><a/><d><e/></d></doc>`
+	for _, tt := range []struct {
+		order string
+		paths []string
+	}{
+		{order: "", paths: []string{"/doc", "/doc/b[1]", "/doc/a[1]", "/doc/d[1]", "/doc/b[1]/c[1]", "/doc/d[1]/e[1]"}},
+		{order: XMLDATA_ORDER_BREADTH_FIRST, paths: []string{"/doc", "/doc/b[1]", "/doc/a[1]", "/doc/d[1]", "/doc/b[1]/c[1]", "/doc/d[1]/e[1]"}},
+		{order: XMLDATA_ORDER_DOCUMENT, paths: []string{"/doc", "/doc/b[1]", "/doc/b[1]/c[1]", "/doc/a[1]", "/doc/d[1]", "/doc/d[1]/e[1]"}},
+		{order: XMLDATA_ORDER_DEPTH_FIRST, paths: []string{"/doc/b[1]/c[1]", "/doc/b[1]", "/doc/a[1]", "/doc/d[1]/e[1]", "/doc/d[1]", "/doc"}},
+	} {
+		t.Run(tt.order, func(t *testing.T) {
+			doc, err := parseDocument(data, WithOrder(tt.order))
+			require.NoError(t, err)
+			require.Equal(t, tt.paths, doc.Paths)
+			require.Equal(t, "<c/>", doc.XMLData[indexOf(tt.paths, "/doc/b[1]/c[1]")])
+			require.Equal(t, data, doc.XMLData[indexOf(tt.paths, "/doc")])
+		})
+	}
+
+	// Stored documents are breadth-first, Parse gives document order
+	doc, err := Parse(data)
+	require.NoError(t, err)
+	require.Equal(t, "/doc/b[1]/c[1]", doc.Paths[2])
+
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	stored, err := parseDocument(data)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *stored))
+	for _, target := range []string{"/document?id=1&order=document", "/list?order=document"} {
+		rr := httptest.NewRecorder()
+		handleRequest(db, rr, httptest.NewRequest("GET", target, nil))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Contains(t, rr.Body.String(), `"Paths":["/doc","/doc/b[1]","/doc/b[1]/c[1]","/doc/a[1]"`, target)
+	}
+	rr := httptest.NewRecorder()
+	handleRequest(db, rr, httptest.NewRequest("GET", "/document?id=1&order=random", nil))
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+// indexOf returns the index of value in values, -1 if it isn't there
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
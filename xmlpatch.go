@@ -0,0 +1,332 @@
+package goapp
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	XML_PATCH_CONTENT_TYPE = "application/xml-patch+xml" // Content type of RFC 5261 style patches sent to PATCH /document
+	XML_PATCH_ROOT         = "diff"                      // Root element of a patch, holding the operations
+
+	PATCH_OP_ADD     = "add"     // Operation adding elements, an attribute or text
+	PATCH_OP_REPLACE = "replace" // Operation replacing an element, an attribute value or text
+	PATCH_OP_REMOVE  = "remove"  // Operation removing an element, an attribute or text
+
+	PATCH_POS_PREPEND = "prepend" // Position of add inserting the elements as first children
+	PATCH_POS_BEFORE  = "before"  // Position of add inserting the elements before the selected element
+	PATCH_POS_AFTER   = "after"   // Position of add inserting the elements after the selected element
+)
+
+// ErrInvalidPatch is returned for patches which can't be applied to a document
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// ErrRevisionConflict is returned when a document was changed since it was read
+var ErrRevisionConflict = errors.New("document was changed concurrently")
+
+// parsePatch parses a patch document and returns its operations
+func parsePatch(data []byte) ([]*Node, error) {
+	root, err := ParseTree(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if root.Name != XML_PATCH_ROOT {
+		return nil, fmt.Errorf("patch root must be <%s>, not <%s>", XML_PATCH_ROOT, root.Name)
+	}
+	for _, op := range root.Children {
+		switch op.Name {
+		case PATCH_OP_ADD, PATCH_OP_REPLACE, PATCH_OP_REMOVE:
+		default:
+			return nil, fmt.Errorf("unknown patch operation <%s>", op.Name)
+		}
+		if op.Attrs["sel"] == "" {
+			return nil, fmt.Errorf("patch operation <%s> needs a sel attribute", op.Name)
+		}
+	}
+	return root.Children, nil
+}
+
+// applyPatch applies the operations to the tree of root in order
+// The selector of each operation must select exactly one element.
+func applyPatch(root *Node, ops []*Node) error {
+	for i, op := range ops {
+		sel := op.Attrs["sel"]
+		path, err := parseXPath(sel)
+		if err != nil {
+			return fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i+1, err)
+		}
+		nodes := path.Select(root)
+		if len(nodes) != 1 {
+			return fmt.Errorf("%w: operation %d: %s selects %d nodes instead of 1", ErrInvalidPatch, i+1, sel, len(nodes))
+		}
+
+		switch op.Name {
+		case PATCH_OP_ADD:
+			err = patchAdd(nodes[0], path, op)
+		case PATCH_OP_REPLACE:
+			err = patchReplace(nodes[0], path, op)
+		case PATCH_OP_REMOVE:
+			err = patchRemove(nodes[0], path)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i+1, err)
+		}
+	}
+	return nil
+}
+
+// patchAdd adds the content of op to the selected element
+// With a type like "@lang" an attribute is added, otherwise the child elements of op at pos, or its text.
+func patchAdd(node *Node, path *XPath, op *Node) error {
+	if path.Attribute != "" || path.Text {
+		return errors.New("add needs an element selector")
+	}
+
+	if kind := op.Attrs["type"]; strings.HasPrefix(kind, XPATH_ATTRIBUTE_STEP) {
+		name := kind[len(XPATH_ATTRIBUTE_STEP):]
+		if _, ok := node.Attrs[name]; ok {
+			return fmt.Errorf("attribute %s already exists", name)
+		}
+		if node.Attrs == nil {
+			node.Attrs = map[string]string{}
+		}
+		node.Attrs[name] = op.Text
+		return nil
+	}
+	if len(op.Children) == 0 {
+		node.appendText(op.Text)
+		return nil
+	}
+
+	switch pos := op.Attrs["pos"]; pos {
+	case "":
+		node.Children = append(node.Children, adopt(node, op.Children)...)
+	case PATCH_POS_PREPEND:
+		node.Children = append(adopt(node, op.Children), node.Children...)
+	case PATCH_POS_BEFORE, PATCH_POS_AFTER:
+		if node.Parent == nil {
+			return fmt.Errorf("can't add %s the root element", pos)
+		}
+		index := childIndex(node)
+		if pos == PATCH_POS_AFTER {
+			index++
+		}
+		parent := node.Parent
+		children := append([]*Node{}, parent.Children[:index]...)
+		children = append(children, adopt(parent, op.Children)...)
+		parent.Children = append(children, parent.Children[index:]...)
+	default:
+		return fmt.Errorf("invalid pos %s", pos)
+	}
+	return nil
+}
+
+// patchReplace replaces the selected element by the single child element of op, or an attribute value or text by its text
+func patchReplace(node *Node, path *XPath, op *Node) error {
+	switch {
+	case path.Attribute != "":
+		node.Attrs[path.Attribute] = op.Text
+	case path.Text:
+		node.setText(op.Text)
+	case len(op.Children) != 1:
+		return fmt.Errorf("replace needs one element, not %d", len(op.Children))
+	case node.Parent == nil:
+		replacement := op.Children[0]
+		*node = Node{Name: replacement.Name, Attrs: replacement.Attrs, Text: replacement.Text, Children: replacement.Children}
+		node.setParents()
+	default:
+		// The text after the replaced element stays in place
+		replacement := adopt(node.Parent, op.Children)[0]
+		replacement.Tail = node.Tail
+		node.Parent.Children[childIndex(node)] = replacement
+	}
+	return nil
+}
+
+// patchRemove removes the selected element, attribute or text
+func patchRemove(node *Node, path *XPath) error {
+	switch {
+	case path.Attribute != "":
+		delete(node.Attrs, path.Attribute)
+	case path.Text:
+		node.setText("")
+	case node.Parent == nil:
+		return errors.New("can't remove the root element")
+	default:
+		node.Parent.removeChild(childIndex(node))
+	}
+	return nil
+}
+
+// adopt makes parent the parent of nodes and returns them
+// Text between the nodes in the patch isn't added to parent.
+func adopt(parent *Node, nodes []*Node) []*Node {
+	for _, node := range nodes {
+		node.Parent = parent
+		node.Tail = ""
+	}
+	return nodes
+}
+
+// childIndex returns the index of node among the children of its parent
+func childIndex(node *Node) int {
+	for i, child := range node.Parent.Children {
+		if child == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// patchDocument stores the patched metadata and tags of a document and bumps its revision
+// It returns ErrRevisionConflict if the revision isn't revision anymore.
+func patchDocument(db *sql.DB, id string, doc XMLDoc, revision int) error {
+	defer observeQuery("patchDocument", time.Now())
+
+	query := fmt.Sprintf(`
+		UPDATE %s SET %s=%s+1 WHERE %s=? AND %s=?
+	`, DB_TABLE_NAME, DB_REVISION_FIELD_NAME, DB_REVISION_FIELD_NAME, DB_ID_FIELD_NAME, DB_REVISION_FIELD_NAME)
+	return withDBRetry(func() error {
+		// The revision is checked and bumped together with the update so concurrent patches aren't lost
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		result, err := tx.Exec(query, id, revision)
+		if err != nil {
+			return err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return ErrRevisionConflict
+		}
+		if err := updateMetadata(tx, id, doc); err != nil {
+			return err
+		}
+		if err := setDocumentTags(tx, id, doc.Tags); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// handlePatchRequest applies an XML patch, or a JSON Patch of the metadata, to a document
+// An If-Match header with the revision makes the patch fail with 412 if the document was changed since.
+func handlePatchRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != XML_PATCH_CONTENT_TYPE && mediaType != JSON_PATCH_CONTENT_TYPE) {
+		http.Error(w, fmt.Sprintf("Content-Type must be %s or %s", XML_PATCH_CONTENT_TYPE, JSON_PATCH_CONTENT_TYPE), http.StatusUnsupportedMediaType)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	// apply changes the element tree of the document
+	var apply func(doc *XMLDoc, tree *Node) error
+	if mediaType == JSON_PATCH_CONTENT_TYPE {
+		ops, err := parseJSONPatch(data)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to parse patch: %v", err), http.StatusBadRequest)
+			return
+		}
+		apply = func(doc *XMLDoc, tree *Node) error { return applyJSONPatch(doc, tree, ops) }
+	} else {
+		ops, err := parsePatch(data)
+		var parseError *ParseError
+		if errors.As(err, &parseError) {
+			httpParseError(w, "Failed to parse patch", parseError)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to parse patch: %v", err), http.StatusBadRequest)
+			return
+		}
+		apply = func(doc *XMLDoc, tree *Node) error { return applyPatch(tree, ops) }
+	}
+
+	doc, err := getDocumentByID(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, fmt.Sprintf("Document with ID %s not found", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to fetch document with ID %s: %v", id, err), err)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != strconv.Itoa(doc.Revision) {
+		http.Error(w, fmt.Sprintf("Document with ID %s is at revision %d", id, doc.Revision), http.StatusPreconditionFailed)
+		return
+	}
+
+	tree, err := doc.elementTree()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse document: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	if tree == nil {
+		http.Error(w, fmt.Sprintf("Document with ID %s has no XML", id), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := apply(doc, tree); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	patched, err := parseDocumentFromWithOptions(tree.String(), "patch:"+id, doc.storedOptions())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse patched document: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	// Dates are parsed with the profile of the original source
+	if patched.DateProfile == "" {
+		applyDateProfile(patched, doc.DateProfile)
+	}
+	// Tags aren't part of the XML, JSON Patches change them on doc
+	patched.Tags = doc.Tags
+	// Patches adding elements the ingest rules strip have them stripped again, which is logged after the earlier changes
+	patched.ProcessingLog = append(append([]ProcessingEntry{}, doc.ProcessingLog...), patched.ProcessingLog...)
+	err = patchDocument(db, id, *patched, doc.Revision)
+	if errors.Is(err, ErrRevisionConflict) {
+		http.Error(w, fmt.Sprintf("Document with ID %s was changed concurrently", id), http.StatusConflict)
+		return
+	} else if err != nil {
+		httpStoreError(w, fmt.Sprintf("Failed to update document with ID %s: %v", id, err), err)
+		return
+	}
+	patched.ID = id
+	patched.State = doc.State
+	patched.Revision = doc.Revision + 1
+
+	// Convert to JSON and send response
+	response, err := json.Marshal(patched)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(patched.Revision)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
@@ -0,0 +1,178 @@
+package goapp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test applying patch operations to element trees
+func TestApplyPatch(t *testing.T) {
+	data := `<document><title>Old</title><item id="1">a</item><item id="2">b</item></document>`
+	tests := []struct {
+		desc     string
+		patch    string
+		expected string
+		err      string
+	}{
+		{
+			desc:     "add child",
+			patch:    `<diff><add sel="/document"><item id="3">c</item></add></diff>`,
+			expected: `<document><title>Old</title><item id="1">a</item><item id="2">b</item><item id="3">c</item></document>`,
+		},
+		{
+			desc:     "add prepend",
+			patch:    `<diff><add sel="/document" pos="prepend"><author>Ann</author></add></diff>`,
+			expected: `<document><author>Ann</author><title>Old</title><item id="1">a</item><item id="2">b</item></document>`,
+		},
+		{
+			desc:     "add before and after",
+			patch:    `<diff><add sel="/document/item[2]" pos="before"><x/></add><add sel="/document/item[@id='2']" pos="after"><y/></add></diff>`,
+			expected: `<document><title>Old</title><item id="1">a</item><x></x><item id="2">b</item><y></y></document>`,
+		},
+		{
+			desc:     "add attribute",
+			patch:    `<diff><add sel="/document/title" type="@lang">en</add></diff>`,
+			expected: `<document><title lang="en">Old</title><item id="1">a</item><item id="2">b</item></document>`,
+		},
+		{
+			desc:     "replace element",
+			patch:    `<diff><replace sel="/document/title"><title>New &amp; improved</title></replace></diff>`,
+			expected: `<document><title>New &amp; improved</title><item id="1">a</item><item id="2">b</item></document>`,
+		},
+		{
+			desc:     "replace attribute and text",
+			patch:    `<diff><replace sel="/document/item[1]/@id">7</replace><replace sel="/document/item[2]/text()">z</replace></diff>`,
+			expected: `<document><title>Old</title><item id="7">a</item><item id="2">z</item></document>`,
+		},
+		{
+			desc:     "remove",
+			patch:    `<diff><remove sel="/document/item[1]"/><remove sel="/document/item/@id"/><remove sel="/document/title/text()"/></diff>`,
+			expected: `<document><title></title><item>b</item></document>`,
+		},
+		{desc: "no match", patch: `<diff><remove sel="/document/author"/></diff>`, err: "operation 1: /document/author selects 0 nodes instead of 1"},
+		{desc: "several matches", patch: `<diff><remove sel="/document/item"/></diff>`, err: "operation 1: /document/item selects 2 nodes instead of 1"},
+		{desc: "remove root", patch: `<diff><remove sel="/document"/></diff>`, err: "operation 1: can't remove the root element"},
+		{desc: "existing attribute", patch: `<diff><add sel="/document/item[1]" type="@id">9</add></diff>`, err: "operation 1: attribute id already exists"},
+		{desc: "invalid path", patch: `<diff><remove sel="document/item"/></diff>`, err: "operation 1: invalid path document/item: only absolute paths are supported"},
+		{desc: "descendant path", patch: `<diff><remove sel="//item[@id='1']"/></diff>`, expected: `<document><title>Old</title><item id="2">b</item></document>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			root, err := ParseTree(strings.NewReader(data))
+			require.NoError(t, err)
+			ops, err := parsePatch([]byte(tt.patch))
+			require.NoError(t, err)
+
+			err = applyPatch(root, ops)
+			if tt.err != "" {
+				require.True(t, errors.Is(err, ErrInvalidPatch))
+				require.EqualError(t, err, "invalid patch: "+tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, root.String())
+		})
+	}
+}
+
+// Test rejecting malformed patch documents
+func TestParsePatchInvalid(t *testing.T) {
+	tests := []struct {
+		desc  string
+		patch string
+		err   string
+	}{
+		{desc: "wrong root", patch: `<patch/>`, err: "patch root must be <diff>, not <patch>"},
+		{desc: "unknown operation", patch: `<diff><move sel="/a"/></diff>`, err: "unknown patch operation <move>"},
+		{desc: "missing selector", patch: `<diff><remove/></diff>`, err: "patch operation <remove> needs a sel attribute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parsePatch([]byte(tt.patch))
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+// Test patching stored documents through PATCH /document
+func TestHandlePatchRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<document><title>Test Title</title><author>John Doe</author></document>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	patch := func(id string, contentType string, ifMatch string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/document?id="+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		handlePatchRequest(db, w, req)
+		return w
+	}
+	replaceTitle := `<diff><replace sel="/document/title/text()">New Title</replace></diff>`
+
+	w := patch("1", XML_PATCH_CONTENT_TYPE, `"1"`, replaceTitle)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.Equal(t, `"2"`, w.Result().Header.Get("ETag"))
+	var patched XMLDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &patched))
+	require.Equal(t, "New Title", patched.Title)
+	require.Equal(t, 2, patched.Revision)
+
+	stored, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, "New Title", stored.Title)
+	require.Equal(t, "John Doe", stored.Author)
+	require.Equal(t, 2, stored.Revision)
+	require.Equal(t, "<document><title>New Title</title><author>John Doe</author></document>", stored.XMLData[0])
+	require.Equal(t, "New Title", stored.Tree.Children[0].Text)
+
+	tests := []struct {
+		desc        string
+		id          string
+		contentType string
+		ifMatch     string
+		body        string
+		status      int
+	}{
+		{desc: "wrong content type", id: "1", contentType: "application/xml", body: replaceTitle, status: http.StatusUnsupportedMediaType},
+		{desc: "missing id", id: "", contentType: XML_PATCH_CONTENT_TYPE, body: replaceTitle, status: http.StatusBadRequest},
+		{desc: "malformed patch", id: "1", contentType: XML_PATCH_CONTENT_TYPE, body: "<diff>", status: http.StatusBadRequest},
+		{desc: "unknown document", id: "99", contentType: XML_PATCH_CONTENT_TYPE, body: replaceTitle, status: http.StatusNotFound},
+		{desc: "stale revision", id: "1", contentType: XML_PATCH_CONTENT_TYPE, ifMatch: `"1"`, body: replaceTitle, status: http.StatusPreconditionFailed},
+		{desc: "selector without match", id: "1", contentType: XML_PATCH_CONTENT_TYPE, body: `<diff><remove sel="/document/price"/></diff>`, status: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			w := patch(tt.id, tt.contentType, tt.ifMatch, tt.body)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+		})
+	}
+}
+
+// Test that a patch based on an outdated revision isn't stored
+func TestPatchDocumentConflict(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument("<document><title>Test Title</title></document>")
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	require.NoError(t, patchDocument(db, "1", *doc, 1))
+	require.True(t, errors.Is(patchDocument(db, "1", *doc, 1), ErrRevisionConflict))
+
+	stored, err := getDocumentByID(db, "1")
+	require.NoError(t, err)
+	require.Equal(t, 2, stored.Revision)
+}
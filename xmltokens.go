@@ -0,0 +1,216 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// nodeTokenReader is the xml.TokenReader returned by Node.Tokens
+type nodeTokenReader struct {
+	tokens []xml.Token
+}
+
+func (reader *nodeTokenReader) Token() (xml.Token, error) {
+	if len(reader.tokens) == 0 {
+		return nil, io.EOF
+	}
+	token := reader.tokens[0]
+	reader.tokens = reader.tokens[1:]
+	return token, nil
+}
+
+// Tokens returns the node and its descendants as a stream of encoding/xml tokens, like an xml.Decoder reading
+// the XML of the node would return them
+// Names carry the URI of their namespace in Space, as declared by the node and its descendants, so the stream can
+// be decoded into structs with xml.NewTokenDecoder. Text between children and comments are kept in place.
+func (node *Node) Tokens() xml.TokenReader {
+	reader := &nodeTokenReader{}
+	node.appendTokens(&reader.tokens, map[string]string{"xml": XML_NAMESPACE})
+	return reader
+}
+
+// appendTokens appends the tokens of the node to tokens, resolving prefixes with the namespaces in scope
+func (node *Node) appendTokens(tokens *[]xml.Token, namespaces map[string]string) {
+	if declared := namespaceDeclarations(node.Attrs); len(declared) > 0 {
+		scope := make(map[string]string, len(namespaces)+len(declared))
+		for prefix, uri := range namespaces {
+			scope[prefix] = uri
+		}
+		for prefix, uri := range declared {
+			scope[prefix] = uri
+		}
+		namespaces = scope
+	}
+
+	// Attributes are in the order Node.String writes them
+	names := make([]string, 0, len(node.Attrs))
+	for name := range node.Attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	start := xml.StartElement{Name: tokenName(node.Name, namespaces, true), Attr: make([]xml.Attr, 0, len(names))}
+	for _, name := range names {
+		start.Attr = append(start.Attr, xml.Attr{Name: tokenName(name, namespaces, false), Value: node.Attrs[name]})
+	}
+	*tokens = append(*tokens, start)
+
+	onText := func(text string) {
+		if text != "" {
+			*tokens = append(*tokens, xml.CharData(text))
+		}
+	}
+	onComment := func(comment string) {
+		*tokens = append(*tokens, xml.Comment(comment))
+	}
+	node.walkContent(node.Text, 0, onText, onComment)
+	for i, child := range node.Children {
+		child.appendTokens(tokens, namespaces)
+		node.walkContent(child.Tail, i+1, onText, onComment)
+	}
+	*tokens = append(*tokens, start.End())
+}
+
+// tokenName returns the xml.Name of an element or attribute name like "dc:title" with the namespaces in scope
+// Like xml.Decoder, namespace declarations keep "xmlns" as their Space, unprefixed attributes have no namespace
+// and prefixes which aren't declared are kept as the Space.
+func tokenName(name string, namespaces map[string]string, element bool) xml.Name {
+	prefix, local := splitName(name)
+	switch {
+	case prefix == XMLNS_ATTRIBUTE || (prefix == "" && !element):
+		return xml.Name{Space: prefix, Local: local}
+	case namespaces[prefix] != "":
+		return xml.Name{Space: namespaces[prefix], Local: local}
+	}
+	return xml.Name{Space: prefix, Local: local}
+}
+
+// TreeFromTokens builds the element tree of the tokens read from r until io.EOF, like an xml.Decoder returns them
+// Names are written with the prefixes their namespaces are declared with, so the tree of Tokens comes back as it
+// was. Comments are kept, processing instructions and directives are left out.
+func TreeFromTokens(r xml.TokenReader) (*Node, error) {
+	builder := &treeBuilder{comments: true}
+	// scopes holds for the root and each open element the prefixes of the namespaces in scope by URI
+	scopes := []map[string]string{{XML_NAMESPACE: "xml"}}
+	for {
+		token, err := r.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			scope, copied := scopes[len(scopes)-1], false
+			for _, attr := range token.Attr {
+				prefix := attr.Name.Local
+				if attr.Name.Space == "" && attr.Name.Local == XMLNS_ATTRIBUTE {
+					prefix = ""
+				} else if attr.Name.Space != XMLNS_ATTRIBUTE {
+					continue
+				}
+				if !copied {
+					scope, copied = copyPrefixes(scope), true
+				}
+				scope[attr.Value] = prefix
+			}
+			scopes = append(scopes, scope)
+
+			var attrs map[string]string
+			if len(token.Attr) > 0 {
+				attrs = make(map[string]string, len(token.Attr))
+				for _, attr := range token.Attr {
+					attrs[nodeName(attr.Name, scope)] = attr.Value
+				}
+			}
+			if err := builder.StartElement(nodeName(token.Name, scope), attrs); err != nil {
+				return nil, err
+			}
+		case xml.EndElement:
+			if builder.current == nil {
+				return nil, fmt.Errorf("unexpected end element %s", token.Name.Local)
+			}
+			scopes = scopes[:len(scopes)-1]
+			if err := builder.EndElement(token.Name.Local); err != nil {
+				return nil, err
+			}
+		case xml.CharData:
+			// Whitespace outside the root element isn't content
+			if builder.current == nil {
+				if strings.TrimLeft(string(token), XML_WHITESPACE) != "" {
+					return nil, errors.New("text outside the root element")
+				}
+				continue
+			}
+			if err := builder.Text(string(token)); err != nil {
+				return nil, err
+			}
+		case xml.Comment:
+			if err := builder.Comment(string(token)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if builder.root == nil {
+		return nil, errors.New("no data for parsing")
+	}
+	if builder.current != nil {
+		return nil, fmt.Errorf("unclosed element %s", builder.current.Name)
+	}
+	return builder.root, nil
+}
+
+// copyPrefixes returns a copy of the prefixes in scope, so an element can declare its own
+func copyPrefixes(scope map[string]string) map[string]string {
+	copied := make(map[string]string, len(scope)+1)
+	for uri, prefix := range scope {
+		copied[uri] = prefix
+	}
+	return copied
+}
+
+// nodeName returns the name of an element or attribute of the tree for an xml.Name, with the prefix the namespace
+// of its Space is declared with in scope
+// A Space which isn't a declared namespace is taken as the prefix, like in the tokens of xml.Decoder.RawToken.
+func nodeName(name xml.Name, scope map[string]string) string {
+	prefix, ok := scope[name.Space]
+	if !ok {
+		prefix = name.Space
+	}
+	if prefix == "" {
+		return name.Local
+	}
+	return prefix + ":" + name.Local
+}
+
+// decodeDocument decodes the XML of the document with the ID into v with encoding/xml, like xml.Unmarshal
+func decodeDocument(db *sql.DB, id string, v interface{}) error {
+	doc, err := getPublishedDocumentByID(db, id, time.Now())
+	if err != nil {
+		return err
+	}
+	tree, err := doc.elementTree()
+	if err != nil {
+		return err
+	}
+	if tree == nil {
+		return fmt.Errorf("document %s has no XML", id)
+	}
+	return xml.NewTokenDecoder(tree.Tokens()).Decode(v)
+}
+
+// DecodeInto decodes the stored document with the ID into v, a pointer to a struct annotated with `xml` tags
+// like for xml.Unmarshal, from the database of the service set up by NewHandler, RunServer or RunCommand
+// It returns sql.ErrNoRows if there is no such document or it is embargoed.
+func DecodeInto(id string, v interface{}) error {
+	if service.db == nil {
+		return errors.New("the document service isn't set up")
+	}
+	return decodeDocument(service.db, id, v)
+}
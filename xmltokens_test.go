@@ -0,0 +1,85 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test converting element trees to and from encoding/xml token streams
+func TestNodeTokens(t *testing.T) {
+	// Attributes are sorted by name like in trees
+	data := `<feed lang="en" xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<title>News</title><dc:creator>Ann &amp; Bob</dc:creator><summary>The <b>quick</b><!-- brown --> fox</summary></feed>`
+	tree, err := parseTree(strings.NewReader(data), true)
+	require.NoError(t, err)
+
+	// The tokens are those xml.Decoder reads from the XML
+	decoder := xml.NewDecoder(strings.NewReader(data))
+	var want []xml.Token
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		want = append(want, xml.CopyToken(token))
+	}
+	var got []xml.Token
+	tokens := tree.Tokens()
+	for {
+		token, err := tokens.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, token)
+	}
+	require.Equal(t, want, got)
+
+	// Both come back as the tree
+	back, err := TreeFromTokens(tree.Tokens())
+	require.NoError(t, err)
+	require.Equal(t, tree.String(), back.String())
+	decoded, err := TreeFromTokens(xml.NewDecoder(strings.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, tree.String(), decoded.String())
+
+	for _, data := range []string{"", "<a>", "<a></a><b></b>", "text"} {
+		_, err := TreeFromTokens(xml.NewDecoder(strings.NewReader(data)))
+		require.Error(t, err, data)
+	}
+}
+
+// Test decoding stored documents into annotated structs
+func TestDecodeDocument(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	doc, err := parseDocument(`<order id="42" xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:creator>Ann</dc:creator>` +
+		`<item sku="a1"><qty>2</qty></item><item sku="b2"><qty>1</qty></item></order>`)
+	require.NoError(t, err)
+	require.NoError(t, insertDocument(db, *doc))
+
+	var order struct {
+		ID      int    `xml:"id,attr"`
+		Creator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Items   []struct {
+			SKU string `xml:"sku,attr"`
+			Qty int    `xml:"qty"`
+		} `xml:"item"`
+	}
+	require.NoError(t, decodeDocument(db, "1", &order))
+	require.Equal(t, 42, order.ID)
+	require.Equal(t, "Ann", order.Creator)
+	require.Len(t, order.Items, 2)
+	require.Equal(t, "b2", order.Items[1].SKU)
+	require.Equal(t, 2, order.Items[0].Qty)
+
+	require.True(t, errors.Is(decodeDocument(db, "2", &order), sql.ErrNoRows))
+}
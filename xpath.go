@@ -0,0 +1,238 @@
+package goapp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	XPATH_TEXT_STEP      = "text()" // Last step of a path selecting the text of an element
+	XPATH_ATTRIBUTE_STEP = "@"      // Prefix of a last step selecting an attribute, like "@lang"
+	XPATH_ANY_NAME       = "*"      // Step matching elements of any name
+)
+
+// pathStep is a step of an absolute path like "item[2]" or "item[@lang='en']"
+type pathStep struct {
+	Name       string
+	Descendant bool              // Descendant tells whether the step follows "//" and matches descendants, not only children
+	Position   int               // Position is the 1-based position among the matching siblings, 0 for all
+	Attributes map[string]string // Attributes are the attribute values the elements must have
+}
+
+// XPath is a parsed absolute path of the XPath subset, like "/document/item[2]/@lang" or "//item/@lang"
+// Only child and descendant steps with names or '*', positions and attribute value predicates are
+// supported; the last step may select an attribute or the text of the elements.
+type XPath struct {
+	Steps     []pathStep
+	Attribute string // Attribute is the attribute the path selects, empty for elements
+	Text      bool   // Text tells whether the path selects the text of the elements
+}
+
+// parseXPath parses an absolute path of the XPath subset
+func parseXPath(path string) (*XPath, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %s: only absolute paths are supported", path)
+	}
+
+	result := &XPath{}
+	rest := path
+	for rest != "" {
+		descendant := strings.HasPrefix(rest, "//")
+		if descendant {
+			rest = rest[2:]
+		} else {
+			rest = rest[1:]
+		}
+		end := stepEnd(rest)
+		part := rest[:end]
+		rest = rest[end:]
+
+		last := rest == ""
+		switch {
+		case last && part == XPATH_TEXT_STEP && len(result.Steps) > 0 && !descendant:
+			result.Text = true
+			continue
+		case last && strings.HasPrefix(part, XPATH_ATTRIBUTE_STEP) && len(result.Steps) > 0 && !descendant:
+			result.Attribute = part[len(XPATH_ATTRIBUTE_STEP):]
+			if result.Attribute == "" {
+				return nil, fmt.Errorf("invalid path %s: empty attribute name", path)
+			}
+			continue
+		}
+
+		step, err := parsePathStep(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %s: %w", path, err)
+		}
+		step.Descendant = descendant
+		result.Steps = append(result.Steps, step)
+	}
+	if len(result.Steps) == 0 {
+		return nil, fmt.Errorf("invalid path %s: no steps", path)
+	}
+	return result, nil
+}
+
+// stepEnd returns the index of the '/' ending the first step of path, skipping slashes in predicates
+func stepEnd(path string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(path); i++ {
+		switch char := path[i]; {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case char == '[':
+			depth++
+		case char == ']':
+			depth--
+		case char == '/' && depth == 0:
+			return i
+		}
+	}
+	return len(path)
+}
+
+// parsePathStep parses a step like "item", "item[2]" or "item[@lang='en']"
+func parsePathStep(part string) (pathStep, error) {
+	step := pathStep{}
+	name := part
+	if i := strings.IndexByte(part, '['); i >= 0 {
+		name = part[:i]
+		predicates := part[i:]
+		for predicates != "" {
+			end := strings.IndexByte(predicates, ']')
+			if !strings.HasPrefix(predicates, "[") || end < 0 {
+				return step, fmt.Errorf("malformed predicate in %s", part)
+			}
+			if err := step.addPredicate(predicates[1:end]); err != nil {
+				return step, err
+			}
+			predicates = predicates[end+1:]
+		}
+	}
+	if name == "" {
+		return step, fmt.Errorf("empty step")
+	}
+	if strings.ContainsAny(name, "@()") {
+		return step, fmt.Errorf("unsupported step %s", name)
+	}
+	step.Name = name
+	return step, nil
+}
+
+// addPredicate adds a predicate like "2" or "@lang='en'" to the step
+func (step *pathStep) addPredicate(predicate string) error {
+	if position, err := strconv.Atoi(predicate); err == nil {
+		if position < 1 {
+			return fmt.Errorf("invalid position %d", position)
+		}
+		step.Position = position
+		return nil
+	}
+
+	attribute, value, ok := strings.Cut(predicate, "=")
+	if !strings.HasPrefix(attribute, XPATH_ATTRIBUTE_STEP) || !ok || len(value) < 2 ||
+		(value[0] != '\'' && value[0] != '"') || value[len(value)-1] != value[0] {
+		return fmt.Errorf("unsupported predicate [%s]", predicate)
+	}
+	if step.Attributes == nil {
+		step.Attributes = map[string]string{}
+	}
+	step.Attributes[strings.TrimSpace(attribute[len(XPATH_ATTRIBUTE_STEP):])] = value[1 : len(value)-1]
+	return nil
+}
+
+// matches tells whether an element matches the name and attribute predicates of the step
+func (step pathStep) matches(node *Node) bool {
+	if step.Name != XPATH_ANY_NAME && step.Name != node.Name {
+		return false
+	}
+	for name, value := range step.Attributes {
+		if node.Attrs[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the nodes matching the step, taking its position among the matches into account
+func (step pathStep) filter(nodes []*Node) []*Node {
+	var result []*Node
+	for _, node := range nodes {
+		if step.matches(node) {
+			result = append(result, node)
+		}
+	}
+	if step.Position == 0 {
+		return result
+	}
+	if step.Position > len(result) {
+		return nil
+	}
+	return result[step.Position-1 : step.Position]
+}
+
+// Select returns the elements of the tree of root the path selects, in document order
+// For paths selecting an attribute only elements having the attribute are returned.
+func (path *XPath) Select(root *Node) []*Node {
+	if root == nil || len(path.Steps) == 0 {
+		return nil
+	}
+
+	// The first step is matched against the root as the only child of the document
+	document := &Node{Children: []*Node{root}}
+	nodes := []*Node{document}
+	for _, step := range path.Steps {
+		var next []*Node
+		for _, node := range nodes {
+			if !step.Descendant {
+				next = append(next, step.filter(node.Children)...)
+				continue
+			}
+			for _, context := range descendantsOrSelf(node) {
+				next = append(next, step.filter(context.Children)...)
+			}
+		}
+		nodes = inDocumentOrder(root, next)
+	}
+
+	if path.Attribute == "" {
+		return nodes
+	}
+	var result []*Node
+	for _, node := range nodes {
+		if _, ok := node.Attrs[path.Attribute]; ok {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// descendantsOrSelf returns node and all of its descendants in document order
+func descendantsOrSelf(node *Node) []*Node {
+	result := []*Node{node}
+	for _, child := range node.Children {
+		result = append(result, descendantsOrSelf(child)...)
+	}
+	return result
+}
+
+// inDocumentOrder returns the nodes of the tree of root without duplicates, in document order
+func inDocumentOrder(root *Node, nodes []*Node) []*Node {
+	selected := map[*Node]bool{}
+	for _, node := range nodes {
+		selected[node] = true
+	}
+	var result []*Node
+	for _, node := range descendantsOrSelf(root) {
+		if selected[node] {
+			result = append(result, node)
+		}
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package goapp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test selecting elements with paths of the XPath subset
+func TestXPathSelect(t *testing.T) {
+	root, err := ParseTree(strings.NewReader(`<document><item id="1" lang="en">a</item><item id="2">b</item><group><item id="3">c</item></group></document>`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc      string
+		path      string
+		expected  []string // expected are the id attributes of the selected elements, or their names
+		attribute string
+		text      bool
+	}{
+		{desc: "root", path: "/document", expected: []string{"document"}},
+		{desc: "children", path: "/document/item", expected: []string{"1", "2"}},
+		{desc: "position", path: "/document/item[2]", expected: []string{"2"}},
+		{desc: "position out of range", path: "/document/item[3]", expected: nil},
+		{desc: "attribute predicate", path: `/document/item[@id="2"]`, expected: []string{"2"}},
+		{desc: "any name", path: "/document/*/item", expected: []string{"3"}},
+		{desc: "wrong root", path: "/catalog/item", expected: nil},
+		{desc: "attribute", path: "/document/item/@lang", expected: []string{"1"}, attribute: "lang"},
+		{desc: "text", path: "/document/item[1]/text()", expected: []string{"1"}, text: true},
+		{desc: "descendants", path: "//item", expected: []string{"1", "2", "3"}},
+		{desc: "descendants below", path: "/document/group//item", expected: []string{"3"}},
+		{desc: "descendant root", path: "//document", expected: []string{"document"}},
+		{desc: "descendant position among siblings", path: "//item[1]", expected: []string{"1", "3"}},
+		{desc: "nested descendants without duplicates", path: "//*//item", expected: []string{"1", "2", "3"}},
+		{desc: "slash in predicate", path: `//item[@id='a/b']`, expected: nil},
+		{desc: "descendant attribute", path: "//item/@lang", expected: []string{"1"}, attribute: "lang"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			path, err := parseXPath(tt.path)
+			require.NoError(t, err)
+			require.Equal(t, tt.attribute, path.Attribute)
+			require.Equal(t, tt.text, path.Text)
+
+			var selected []string
+			for _, node := range path.Select(root) {
+				if id, ok := node.Attrs["id"]; ok {
+					selected = append(selected, id)
+				} else {
+					selected = append(selected, node.Name)
+				}
+			}
+			require.Equal(t, tt.expected, selected)
+		})
+	}
+}
+
+// Test rejecting paths outside of the XPath subset
+func TestParseXPathInvalid(t *testing.T) {
+	for _, path := range []string{"document", "/", "///item", "/document/item/", "/document/item[0]", "/document/item[last()]", "/document/item[@id=2]", "/@id", "/document/@", "/document/item[1"} {
+		t.Run(path, func(t *testing.T) {
+			_, err := parseXPath(path)
+			require.Error(t, err)
+		})
+	}
+}
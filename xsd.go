@@ -0,0 +1,680 @@
+package goapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	VALIDATION_XSD_ENV = "DOC_VALIDATION_XSD" // Environment variable with the path of the XSD documents added with /add must conform to
+
+	XSD_UNBOUNDED = -1 // MaxOccurs of elements which may repeat without limit
+)
+
+// SchemaViolation is a way a document doesn't conform to the XSD
+type SchemaViolation struct {
+	Path    string // Path locates the element, like /order/item[2]/price
+	Message string
+}
+
+// SchemaError is returned for documents which don't conform to the XSD
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (err *SchemaError) Error() string {
+	first := err.Violations[0]
+	if len(err.Violations) == 1 {
+		return fmt.Sprintf("%s: %s", first.Path, first.Message)
+	}
+	return fmt.Sprintf("%s: %s (and %d more violations)", first.Path, first.Message, len(err.Violations)-1)
+}
+
+// SchemaErrorResponse is the JSON body of the answer to documents rejected by the XSD
+type SchemaErrorResponse struct {
+	Error      string
+	Violations []SchemaViolation
+}
+
+// ValidateResponse is the JSON body of the answer of /validate
+type ValidateResponse struct {
+	Valid      bool
+	Violations []SchemaViolation // Violations of the XSD, if one is configured
+	Validation ValidationResult  // Validation is the result of the validation schemas
+}
+
+// xsdElement declares an element with its type and number of occurrences
+type xsdElement struct {
+	Name      string
+	Ref       string // Ref is the name of the global element this declaration refers to
+	Type      string // Type is the local name of a built-in or named type
+	Complex   *xsdComplexType
+	Simple    *xsdSimpleType
+	MinOccurs int
+	MaxOccurs int // MaxOccurs is XSD_UNBOUNDED if unlimited
+}
+
+// xsdComplexType declares the children and attributes of elements
+type xsdComplexType struct {
+	Model      string // Model is sequence, all or choice, empty for elements without children
+	Elements   []*xsdElement
+	Attributes []xsdAttribute
+	Mixed      bool   // Mixed allows text between the children
+	Content    string // Content is the type of the text of elements with simple content, empty otherwise
+}
+
+// xsdAttribute declares an attribute
+type xsdAttribute struct {
+	Name     string
+	Type     string
+	Simple   *xsdSimpleType
+	Required bool
+}
+
+// xsdSimpleType restricts the text of elements and the values of attributes
+type xsdSimpleType struct {
+	Base         string
+	Enumeration  []string
+	Patterns     []*regexp.Regexp
+	MinLength    int
+	MaxLength    int // MaxLength is -1 if unlimited
+	MinInclusive *float64
+	MaxInclusive *float64
+}
+
+// XSDSchema holds the declarations of an XSD needed to validate documents
+// It supports the common subset of XSD: global and local elements, element references, named and anonymous
+// complex types with sequence, all or choice, attributes, simple content, and simple types restricting
+// built-in types with enumerations, patterns, lengths and inclusive bounds.
+type XSDSchema struct {
+	Elements     map[string]*xsdElement
+	ComplexTypes map[string]*xsdComplexType
+	SimpleTypes  map[string]*xsdSimpleType
+}
+
+// xsdSchema is the XSD documents added with /add must conform to, nil unless configured by initXSDSchema
+var xsdSchema *XSDSchema
+
+// initXSDSchema loads the XSD named by the environment
+func initXSDSchema() {
+	funcName := "initXSDSchema"
+
+	path := os.Getenv(VALIDATION_XSD_ENV)
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("%s: Failed to read %s: %v", funcName, VALIDATION_XSD_ENV, err)
+	}
+	xsdSchema, err = parseXSD(string(data))
+	if err != nil {
+		log.Fatalf("%s: Invalid XSD in %s: %v", funcName, path, err)
+	}
+}
+
+// parseXSD parses an XSD into its declarations
+func parseXSD(data string) (*XSDSchema, error) {
+	root, err := ParseTree(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if localName(root.Name) != "schema" {
+		return nil, fmt.Errorf("root element is <%s>, not <schema>", root.Name)
+	}
+
+	schema := &XSDSchema{Elements: map[string]*xsdElement{}, ComplexTypes: map[string]*xsdComplexType{}, SimpleTypes: map[string]*xsdSimpleType{}}
+	for _, child := range root.Children {
+		name := child.Attrs["name"]
+		switch localName(child.Name) {
+		case "element":
+			element, err := parseXSDElement(child)
+			if err != nil {
+				return nil, err
+			}
+			schema.Elements[element.Name] = element
+		case "complexType":
+			complexType, err := parseXSDComplexType(child)
+			if err != nil {
+				return nil, err
+			}
+			schema.ComplexTypes[name] = complexType
+		case "simpleType":
+			simpleType, err := parseXSDSimpleType(child)
+			if err != nil {
+				return nil, err
+			}
+			schema.SimpleTypes[name] = simpleType
+		}
+	}
+	if len(schema.Elements) == 0 {
+		return nil, errors.New("no global element is declared")
+	}
+	return schema, schema.check()
+}
+
+// parseXSDElement parses an <xs:element> declaration
+func parseXSDElement(node *Node) (*xsdElement, error) {
+	element := &xsdElement{
+		Name:      node.Attrs["name"],
+		Ref:       localName(node.Attrs["ref"]),
+		Type:      localName(node.Attrs["type"]),
+		MinOccurs: 1,
+		MaxOccurs: 1,
+	}
+	if element.Name == "" && element.Ref == "" {
+		return nil, errors.New("an element has neither a name nor a ref")
+	}
+	if value, ok := node.Attrs["minOccurs"]; ok {
+		minOccurs, err := strconv.Atoi(value)
+		if err != nil || minOccurs < 0 {
+			return nil, fmt.Errorf("element %s: invalid minOccurs %q", element.Name+element.Ref, value)
+		}
+		element.MinOccurs = minOccurs
+	}
+	if value, ok := node.Attrs["maxOccurs"]; ok {
+		maxOccurs, err := strconv.Atoi(value)
+		if value == "unbounded" {
+			maxOccurs, err = XSD_UNBOUNDED, nil
+		}
+		if err != nil || (maxOccurs != XSD_UNBOUNDED && maxOccurs < element.MinOccurs) {
+			return nil, fmt.Errorf("element %s: invalid maxOccurs %q", element.Name+element.Ref, value)
+		}
+		element.MaxOccurs = maxOccurs
+	}
+
+	for _, child := range node.Children {
+		var err error
+		switch localName(child.Name) {
+		case "complexType":
+			element.Complex, err = parseXSDComplexType(child)
+		case "simpleType":
+			element.Simple, err = parseXSDSimpleType(child)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("element %s: %v", element.Name, err)
+		}
+	}
+	return element, nil
+}
+
+// parseXSDComplexType parses an <xs:complexType> declaration
+func parseXSDComplexType(node *Node) (*xsdComplexType, error) {
+	complexType := &xsdComplexType{Mixed: node.Attrs["mixed"] == "true"}
+	for _, child := range node.Children {
+		switch localName(child.Name) {
+		case "sequence", "all", "choice":
+			complexType.Model = localName(child.Name)
+			for _, particle := range child.Children {
+				if localName(particle.Name) == "annotation" {
+					continue
+				} else if localName(particle.Name) != "element" {
+					return nil, fmt.Errorf("<%s> inside <%s> isn't supported", particle.Name, child.Name)
+				}
+				element, err := parseXSDElement(particle)
+				if err != nil {
+					return nil, err
+				}
+				complexType.Elements = append(complexType.Elements, element)
+			}
+		case "attribute":
+			attribute, err := parseXSDAttribute(child)
+			if err != nil {
+				return nil, err
+			}
+			complexType.Attributes = append(complexType.Attributes, attribute)
+		case "simpleContent":
+			// Only extensions adding attributes to a simple type are supported
+			for _, extension := range child.Children {
+				if localName(extension.Name) != "extension" {
+					return nil, fmt.Errorf("<%s> inside <%s> isn't supported", extension.Name, child.Name)
+				}
+				complexType.Content = localName(extension.Attrs["base"])
+				for _, attr := range extension.Children {
+					attribute, err := parseXSDAttribute(attr)
+					if err != nil {
+						return nil, err
+					}
+					complexType.Attributes = append(complexType.Attributes, attribute)
+				}
+			}
+		}
+	}
+	return complexType, nil
+}
+
+// parseXSDAttribute parses an <xs:attribute> declaration
+func parseXSDAttribute(node *Node) (xsdAttribute, error) {
+	attribute := xsdAttribute{Name: node.Attrs["name"], Type: localName(node.Attrs["type"]), Required: node.Attrs["use"] == "required"}
+	if attribute.Name == "" {
+		return attribute, errors.New("an attribute has no name")
+	}
+	for _, child := range node.Children {
+		if localName(child.Name) == "simpleType" {
+			simpleType, err := parseXSDSimpleType(child)
+			if err != nil {
+				return attribute, fmt.Errorf("attribute %s: %v", attribute.Name, err)
+			}
+			attribute.Simple = simpleType
+		}
+	}
+	return attribute, nil
+}
+
+// parseXSDSimpleType parses an <xs:simpleType> declaration with a restriction
+func parseXSDSimpleType(node *Node) (*xsdSimpleType, error) {
+	simpleType := &xsdSimpleType{MaxLength: -1}
+	for _, restriction := range node.Children {
+		if localName(restriction.Name) == "annotation" {
+			continue
+		} else if localName(restriction.Name) != "restriction" {
+			return nil, fmt.Errorf("<%s> inside <%s> isn't supported", restriction.Name, node.Name)
+		}
+		simpleType.Base = localName(restriction.Attrs["base"])
+		for _, facet := range restriction.Children {
+			value := facet.Attrs["value"]
+			var err error
+			switch localName(facet.Name) {
+			case "enumeration":
+				simpleType.Enumeration = append(simpleType.Enumeration, value)
+			case "pattern":
+				var pattern *regexp.Regexp
+				// Patterns of XSD match the whole value
+				pattern, err = regexp.Compile("^(?:" + value + ")$")
+				simpleType.Patterns = append(simpleType.Patterns, pattern)
+			case "length":
+				simpleType.MinLength, err = strconv.Atoi(value)
+				simpleType.MaxLength = simpleType.MinLength
+			case "minLength":
+				simpleType.MinLength, err = strconv.Atoi(value)
+			case "maxLength":
+				simpleType.MaxLength, err = strconv.Atoi(value)
+			case "minInclusive":
+				var bound float64
+				bound, err = strconv.ParseFloat(value, 64)
+				simpleType.MinInclusive = &bound
+			case "maxInclusive":
+				var bound float64
+				bound, err = strconv.ParseFloat(value, 64)
+				simpleType.MaxInclusive = &bound
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", localName(facet.Name), value)
+			}
+		}
+	}
+	return simpleType, nil
+}
+
+// check makes sure that all referenced elements and types are declared
+func (schema *XSDSchema) check() error {
+	var checkElement func(element *xsdElement) error
+	checkType := func(name string) error {
+		if name == "" || isBuiltinType(name) || schema.ComplexTypes[name] != nil || schema.SimpleTypes[name] != nil {
+			return nil
+		}
+		return fmt.Errorf("type %s isn't declared", name)
+	}
+	checkComplexType := func(complexType *xsdComplexType) error {
+		for _, element := range complexType.Elements {
+			if err := checkElement(element); err != nil {
+				return err
+			}
+		}
+		for _, attribute := range complexType.Attributes {
+			if err := checkType(attribute.Type); err != nil {
+				return err
+			}
+		}
+		return checkType(complexType.Content)
+	}
+	checkElement = func(element *xsdElement) error {
+		if element.Ref != "" && schema.Elements[element.Ref] == nil {
+			return fmt.Errorf("element %s isn't declared", element.Ref)
+		}
+		if element.Complex != nil {
+			return checkComplexType(element.Complex)
+		}
+		return checkType(element.Type)
+	}
+
+	for _, element := range schema.Elements {
+		if err := checkElement(element); err != nil {
+			return err
+		}
+	}
+	for _, complexType := range schema.ComplexTypes {
+		if err := checkComplexType(complexType); err != nil {
+			return err
+		}
+	}
+	for _, simpleType := range schema.SimpleTypes {
+		if err := checkType(simpleType.Base); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate returns the ways the element tree doesn't conform to the schema
+func (schema *XSDSchema) Validate(root *Node) []SchemaViolation {
+	path := "/" + root.Name
+	element := schema.Elements[localName(root.Name)]
+	if element == nil {
+		return []SchemaViolation{{Path: path, Message: fmt.Sprintf("root element <%s> isn't declared", root.Name)}}
+	}
+	return schema.validateElement(element, root, path)
+}
+
+// validateElement validates a node against its declaration
+func (schema *XSDSchema) validateElement(element *xsdElement, node *Node, path string) []SchemaViolation {
+	if element.Ref != "" {
+		element = schema.Elements[element.Ref]
+	}
+	complexType := element.Complex
+	if complexType == nil {
+		complexType = schema.ComplexTypes[element.Type]
+	}
+
+	var violations []SchemaViolation
+	violation := func(format string, args ...interface{}) {
+		violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if complexType == nil {
+		// Elements of simple types hold text only
+		if len(node.Children) > 0 {
+			violation("element <%s> can't have child elements", node.Name)
+		}
+		for name := range node.Attrs {
+			if !isNamespaceAttribute(name) {
+				violation("attribute %s isn't declared", name)
+			}
+		}
+		if message := schema.checkValue(element.Simple, element.Type, node.Text); message != "" {
+			violation("%s", message)
+		}
+		return violations
+	}
+
+	declared := map[string]bool{}
+	for _, attribute := range complexType.Attributes {
+		declared[attribute.Name] = true
+		value, ok := node.Attrs[attribute.Name]
+		if !ok {
+			if attribute.Required {
+				violation("attribute %s is missing", attribute.Name)
+			}
+			continue
+		}
+		if message := schema.checkValue(attribute.Simple, attribute.Type, value); message != "" {
+			violation("attribute %s: %s", attribute.Name, message)
+		}
+	}
+	for _, name := range sortedKeys(node.Attrs) {
+		if !declared[name] && !isNamespaceAttribute(name) {
+			violation("attribute %s isn't declared", name)
+		}
+	}
+
+	if complexType.Content != "" {
+		if len(node.Children) > 0 {
+			violation("element <%s> can't have child elements", node.Name)
+		}
+		if message := schema.checkValue(nil, complexType.Content, node.Text); message != "" {
+			violation("%s", message)
+		}
+		return violations
+	}
+	if !complexType.Mixed && strings.TrimSpace(node.directText()) != "" {
+		violation("element <%s> can't have text", node.Name)
+	}
+	violations = append(violations, schema.validateChildren(complexType, node, path)...)
+	return violations
+}
+
+// validateChildren validates the children of a node against the content model of its type
+func (schema *XSDSchema) validateChildren(complexType *xsdComplexType, node *Node, path string) []SchemaViolation {
+	var violations []SchemaViolation
+	declarations := map[string]*xsdElement{}
+	for _, element := range complexType.Elements {
+		declarations[element.name()] = element
+	}
+
+	counts := map[string]int{}
+	expected := 0 // expected is the index of the next declaration children of a sequence may match
+	for _, child := range node.Children {
+		name := localName(child.Name)
+		element := declarations[name]
+		counts[name]++
+		childPath := fmt.Sprintf("%s/%s[%d]", path, child.Name, counts[name])
+		if element == nil {
+			violations = append(violations, SchemaViolation{Path: childPath, Message: fmt.Sprintf("element <%s> isn't expected here", child.Name)})
+			continue
+		}
+		if complexType.Model == "sequence" {
+			position := expected
+			for position < len(complexType.Elements) && complexType.Elements[position] != element {
+				position++
+			}
+			if position == len(complexType.Elements) {
+				violations = append(violations, SchemaViolation{Path: childPath, Message: fmt.Sprintf("element <%s> is out of order", child.Name)})
+			} else {
+				expected = position
+			}
+		}
+		violations = append(violations, schema.validateElement(element, child, childPath)...)
+	}
+
+	chosen := 0 // chosen is the number of alternatives of a choice which occur
+	for _, element := range complexType.Elements {
+		count := counts[element.name()]
+		if count > 0 {
+			chosen++
+		}
+		if element.MaxOccurs != XSD_UNBOUNDED && count > element.MaxOccurs {
+			violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf("element <%s> occurs %d times, at most %d are allowed", element.name(), count, element.MaxOccurs)})
+		}
+		if complexType.Model != "choice" && count < element.MinOccurs {
+			violations = append(violations, SchemaViolation{Path: path, Message: fmt.Sprintf("element <%s> is missing", element.name())})
+		}
+	}
+	if complexType.Model == "choice" && chosen > 1 {
+		violations = append(violations, SchemaViolation{Path: path, Message: "only one of the choice elements is allowed"})
+	}
+	if complexType.Model == "choice" && chosen == 0 && len(complexType.Elements) > 0 {
+		optional := false
+		for _, element := range complexType.Elements {
+			optional = optional || element.MinOccurs == 0
+		}
+		if !optional {
+			violations = append(violations, SchemaViolation{Path: path, Message: "one of the choice elements is missing"})
+		}
+	}
+	return violations
+}
+
+// name returns the name of the declared element, also for references
+func (element *xsdElement) name() string {
+	if element.Ref != "" {
+		return element.Ref
+	}
+	return element.Name
+}
+
+// checkValue checks a text or attribute value against a simple type, either declared inline or by name
+// It returns a description of the problem, empty if the value is valid.
+func (schema *XSDSchema) checkValue(simpleType *xsdSimpleType, typeName string, value string) string {
+	if simpleType == nil {
+		simpleType = schema.SimpleTypes[typeName]
+	}
+	if simpleType == nil {
+		return checkBuiltinValue(typeName, value)
+	}
+	if message := schema.checkValue(nil, simpleType.Base, value); message != "" {
+		return message
+	}
+
+	if len(simpleType.Enumeration) > 0 {
+		found := false
+		for _, allowed := range simpleType.Enumeration {
+			found = found || value == allowed
+		}
+		if !found {
+			return fmt.Sprintf("value %q isn't one of %s", value, strings.Join(simpleType.Enumeration, ", "))
+		}
+	}
+	for _, pattern := range simpleType.Patterns {
+		if !pattern.MatchString(value) {
+			return fmt.Sprintf("value %q doesn't match %s", value, strings.TrimSuffix(strings.TrimPrefix(pattern.String(), "^(?:"), ")$"))
+		}
+	}
+	length := len([]rune(value))
+	if length < simpleType.MinLength || (simpleType.MaxLength >= 0 && length > simpleType.MaxLength) {
+		return fmt.Sprintf("value %q has %d characters, not between %d and %d", value, length, simpleType.MinLength, simpleType.MaxLength)
+	}
+	if simpleType.MinInclusive != nil || simpleType.MaxInclusive != nil {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Sprintf("value %q isn't a number", value)
+		}
+		if simpleType.MinInclusive != nil && number < *simpleType.MinInclusive {
+			return fmt.Sprintf("value %q is less than %g", value, *simpleType.MinInclusive)
+		}
+		if simpleType.MaxInclusive != nil && number > *simpleType.MaxInclusive {
+			return fmt.Sprintf("value %q is greater than %g", value, *simpleType.MaxInclusive)
+		}
+	}
+	return ""
+}
+
+// isBuiltinType reports whether the type is a built-in XSD type checkBuiltinValue knows
+func isBuiltinType(name string) bool {
+	switch name {
+	case "string", "normalizedString", "token", "anyURI", "ID", "IDREF", "NMTOKEN", "language", "anyType", "anySimpleType",
+		"integer", "int", "long", "short", "byte", "nonNegativeInteger", "positiveInteger", "nonPositiveInteger", "negativeInteger",
+		"decimal", "float", "double", "boolean", "date", "dateTime", "time":
+		return true
+	}
+	return false
+}
+
+// checkBuiltinValue checks a value against a built-in XSD type
+func checkBuiltinValue(typeName string, value string) string {
+	value = strings.TrimSpace(value)
+	valid := true
+	switch typeName {
+	case "integer", "int", "long", "short", "byte", "nonNegativeInteger", "positiveInteger", "nonPositiveInteger", "negativeInteger":
+		number, err := strconv.ParseInt(value, 10, 64)
+		valid = err == nil
+		switch typeName {
+		case "nonNegativeInteger":
+			valid = valid && number >= 0
+		case "positiveInteger":
+			valid = valid && number > 0
+		case "nonPositiveInteger":
+			valid = valid && number <= 0
+		case "negativeInteger":
+			valid = valid && number < 0
+		}
+	case "decimal", "float", "double":
+		_, err := strconv.ParseFloat(value, 64)
+		valid = err == nil
+	case "boolean":
+		valid = value == "true" || value == "false" || value == "1" || value == "0"
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		valid = err == nil
+	case "dateTime":
+		_, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			_, err = time.Parse("2006-01-02T15:04:05", value)
+		}
+		valid = err == nil
+	case "time":
+		_, err := time.Parse("15:04:05", value)
+		valid = err == nil
+	}
+	if !valid {
+		return fmt.Sprintf("value %q isn't a valid %s", value, typeName)
+	}
+	return ""
+}
+
+// isNamespaceAttribute reports whether the attribute declares a namespace or belongs to the XML Schema instance namespace
+func isNamespaceAttribute(name string) bool {
+	prefix, local := splitName(name)
+	return name == XMLNS_ATTRIBUTE || prefix == XMLNS_ATTRIBUTE || prefix == "xsi" || (prefix == "xml" && local != "")
+}
+
+// validateXSD checks the document against the XSD, if one is configured
+func validateXSD(doc *XMLDoc) error {
+	if xsdSchema == nil || doc.Tree == nil {
+		return nil
+	}
+	if violations := xsdSchema.Validate(doc.Tree); len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+	return nil
+}
+
+// httpSchemaError answers a document rejected by the XSD with 422 and its violations as JSON
+func httpSchemaError(w http.ResponseWriter, message string, err *SchemaError) {
+	response, marshalErr := json.Marshal(SchemaErrorResponse{Error: fmt.Sprintf("%s: %v", message, err), Violations: err.Violations})
+	if marshalErr != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	w.Write(response)
+}
+
+// handleValidateRequest validates the posted document against the XSD and the validation schemas without inserting it
+func handleValidateRequest(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	xmlData, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := parseDocumentFrom(string(xmlData), "http:"+clientIP(r).String())
+	var parseError *ParseError
+	if errors.As(err, &parseError) {
+		httpParseError(w, "Failed to parse document", parseError)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse document: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := ValidateResponse{Violations: []SchemaViolation{}, Validation: doc.Validation}
+	var schemaError *SchemaError
+	if errors.As(validateXSD(doc), &schemaError) {
+		response.Violations = schemaError.Violations
+	}
+	response.Valid = len(response.Violations) == 0 && doc.Validation.Status != VALIDATION_FAILED
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Failed to marshal JSON response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,212 @@
+package goapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testXSD declares orders with a customer, one or more items and an optional note
+const testXSD = `<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="order">
+    <xs:annotation><xs:documentation>An order</xs:documentation></xs:annotation>
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="customer" type="xs:string"/>
+        <xs:element name="item" type="ItemType" maxOccurs="unbounded"/>
+        <xs:element ref="note" minOccurs="0"/>
+      </xs:sequence>
+      <xs:attribute name="id" type="xs:positiveInteger" use="required"/>
+      <xs:attribute name="status" type="StatusType"/>
+    </xs:complexType>
+  </xs:element>
+  <xs:element name="note" type="xs:string"/>
+  <xs:complexType name="ItemType">
+    <xs:all>
+      <xs:element name="sku">
+        <xs:simpleType>
+          <xs:restriction base="xs:string"><xs:pattern value="[A-Z]{3}-\d+"/></xs:restriction>
+        </xs:simpleType>
+      </xs:element>
+      <xs:element name="price" type="PriceType"/>
+    </xs:all>
+  </xs:complexType>
+  <xs:complexType name="PriceType">
+    <xs:simpleContent>
+      <xs:extension base="xs:decimal"><xs:attribute name="currency" type="xs:string" use="required"/></xs:extension>
+    </xs:simpleContent>
+  </xs:complexType>
+  <xs:simpleType name="StatusType">
+    <xs:restriction base="xs:string">
+      <xs:enumeration value="open"/>
+      <xs:enumeration value="shipped"/>
+    </xs:restriction>
+  </xs:simpleType>
+</xs:schema>`
+
+// Test loading XSDs and rejecting unsupported or inconsistent ones
+func TestParseXSD(t *testing.T) {
+	schema, err := parseXSD(testXSD)
+	require.NoError(t, err)
+	require.Len(t, schema.Elements, 2)
+	require.Len(t, schema.ComplexTypes, 2)
+	require.Len(t, schema.SimpleTypes, 1)
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected string
+	}{
+		{desc: "not a schema", data: "<order/>", expected: "root element is <order>, not <schema>"},
+		{desc: "no element", data: `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"/>`, expected: "no global element is declared"},
+		{desc: "unknown type", data: `<xs:schema xmlns:xs="x"><xs:element name="a" type="Missing"/></xs:schema>`, expected: "type Missing isn't declared"},
+		{desc: "unknown ref", data: `<xs:schema xmlns:xs="x"><xs:element name="a"><xs:complexType><xs:sequence><xs:element ref="b"/></xs:sequence></xs:complexType></xs:element></xs:schema>`, expected: "element b isn't declared"},
+		{desc: "invalid maxOccurs", data: `<xs:schema xmlns:xs="x"><xs:element name="a" minOccurs="2" maxOccurs="1"/></xs:schema>`, expected: `element a: invalid maxOccurs "1"`},
+		{desc: "unsupported particle", data: `<xs:schema xmlns:xs="x"><xs:element name="a"><xs:complexType><xs:sequence><xs:any/></xs:sequence></xs:complexType></xs:element></xs:schema>`, expected: "element a: <xs:any> inside <xs:sequence> isn't supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := parseXSD(tt.data)
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+// Test validating element trees against an XSD
+func TestXSDSchemaValidate(t *testing.T) {
+	schema, err := parseXSD(testXSD)
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc     string
+		data     string
+		expected []SchemaViolation
+	}{
+		{
+			desc: "valid",
+			data: `<order id="7" status="open" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><customer>Acme</customer><item><price currency="EUR">9.50</price><sku>ABC-1</sku></item><item><sku>ABC-2</sku><price currency="EUR">1</price></item><note>Fragile</note></order>`,
+		},
+		{
+			desc:     "undeclared root",
+			data:     "<invoice/>",
+			expected: []SchemaViolation{{Path: "/invoice", Message: "root element <invoice> isn't declared"}},
+		},
+		{
+			desc: "attributes",
+			data: `<order status="lost" priority="high"><customer>Acme</customer><item><sku>ABC-1</sku><price currency="EUR">1</price></item></order>`,
+			expected: []SchemaViolation{
+				{Path: "/order", Message: "attribute id is missing"},
+				{Path: "/order", Message: `attribute status: value "lost" isn't one of open, shipped`},
+				{Path: "/order", Message: "attribute priority isn't declared"},
+			},
+		},
+		{
+			desc: "missing and repeated elements",
+			data: `<order id="0"><item><sku>abc</sku><sku>ABC-1</sku></item></order>`,
+			expected: []SchemaViolation{
+				{Path: "/order", Message: `attribute id: value "0" isn't a valid positiveInteger`},
+				{Path: "/order/item[1]/sku[1]", Message: `value "abc" doesn't match [A-Z]{3}-\d+`},
+				{Path: "/order/item[1]", Message: "element <sku> occurs 2 times, at most 1 are allowed"},
+				{Path: "/order/item[1]", Message: "element <price> is missing"},
+				{Path: "/order", Message: "element <customer> is missing"},
+			},
+		},
+		{
+			desc: "order, text and unknown elements",
+			data: `<order id="1">oops<item><sku>ABC-1</sku><price currency="EUR">cheap</price></item><customer>Acme</customer><gift/></order>`,
+			expected: []SchemaViolation{
+				{Path: "/order", Message: "element <order> can't have text"},
+				{Path: "/order/item[1]/price[1]", Message: `value "cheap" isn't a valid decimal`},
+				{Path: "/order/customer[1]", Message: "element <customer> is out of order"},
+				{Path: "/order/gift[1]", Message: "element <gift> isn't expected here"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			tree, err := ParseTree(strings.NewReader(tt.data))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, schema.Validate(tree))
+		})
+	}
+}
+
+// Test rejecting documents which don't conform to the XSD on /add
+func TestHandleAddRequestXSD(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(previous *XSDSchema) { xsdSchema = previous }(xsdSchema)
+	var err error
+	xsdSchema, err = parseXSD(testXSD)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/add", strings.NewReader(`<order id="1"><customer>Acme</customer></order>`))
+	w := httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+
+	var response SchemaErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Equal(t, "Document doesn't conform to the XSD: /order: element <item> is missing", response.Error)
+	require.Equal(t, []SchemaViolation{{Path: "/order", Message: "element <item> is missing"}}, response.Violations)
+	_, err = getDocumentByID(db, "1")
+	require.Error(t, err)
+
+	req = httptest.NewRequest("POST", "/add", strings.NewReader(`<order id="1"><customer>Acme</customer><item><sku>ABC-1</sku><price currency="EUR">1</price></item></order>`))
+	w = httptest.NewRecorder()
+	handleAddRequest(db, w, req)
+	require.Equal(t, http.StatusCreated, w.Result().StatusCode)
+}
+
+// Test validating documents without inserting them with /validate
+func TestHandleValidateRequest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	defer func(previous *XSDSchema) { xsdSchema = previous }(xsdSchema)
+	var err error
+	xsdSchema, err = parseXSD(testXSD)
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc       string
+		method     string
+		data       string
+		status     int
+		valid      bool
+		violations []SchemaViolation
+	}{
+		{desc: "valid", method: "POST", data: `<order id="1"><customer>Acme</customer><item><sku>ABC-1</sku><price currency="EUR">1</price></item></order>`, status: http.StatusOK, valid: true, violations: []SchemaViolation{}},
+		{desc: "invalid", method: "POST", data: `<order id="1"><item><sku>ABC-1</sku><price currency="EUR">1</price></item></order>`, status: http.StatusOK, violations: []SchemaViolation{{Path: "/order", Message: "element <customer> is missing"}}},
+		{desc: "malformed", method: "POST", data: `<order><customer></order>`, status: http.StatusBadRequest},
+		{desc: "wrong method", method: "GET", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/validate", strings.NewReader(tt.data))
+			w := httptest.NewRecorder()
+			handleValidateRequest(db, w, req)
+			require.Equal(t, tt.status, w.Result().StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var response ValidateResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Equal(t, tt.valid, response.Valid)
+			require.Equal(t, tt.violations, response.Violations)
+		})
+	}
+
+	// Nothing was inserted
+	docs, err := listDocuments(db, time.Now(), []string{DOC_STATE_ACTIVE}, "", DB_ID_FIELD_NAME)
+	require.NoError(t, err)
+	require.Empty(t, docs)
+}